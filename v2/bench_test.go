@@ -2,6 +2,7 @@ package car_test
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"math/rand"
 	"os"
@@ -81,6 +82,70 @@ func BenchmarkExtractV1File(b *testing.B) {
 	})
 }
 
+// BenchmarkGenerateIndex generates an index for a sample CARv1 file with and without a read-ahead
+// buffer, in order to compare the effect of WithReadBufferSize.
+func BenchmarkGenerateIndex(b *testing.B) {
+	path := "testdata/sample-v1.car"
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{0, 4 << 10, 64 << 10} {
+		b.Run(fmt.Sprintf("ReadBufferSize=%d", size), func(b *testing.B) {
+			b.SetBytes(info.Size())
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := carv2.GenerateIndexFromFile(path, carv2.WithReadBufferSize(size)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGenerateIndexParallel generates an index for a large random CARv2 file using varying
+// number of workers, in order to compare against the sequential index generation.
+func BenchmarkGenerateIndexParallel(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "bench-large-v2.car")
+	generateRandomCarV2File(b, path, 100<<20) // 100 MiB
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Sequential", func(b *testing.B) {
+		b.SetBytes(info.Size())
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := carv2.GenerateIndex(io.NewSectionReader(f, 0, info.Size())); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("Workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(info.Size())
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := carv2.GenerateIndexParallel(f, info.Size(), workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // BenchmarkExtractV1UsingReader extracts inner CARv1 payload from a sample CARv2 file using Reader
 // API. This benchmark is implemented to be used as a comparison in conjunction with
 // BenchmarkExtractV1File.
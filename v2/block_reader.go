@@ -28,8 +28,14 @@ type BlockReader struct {
 // CARv2 payload. Upon instantiation, the version is automatically detected and exposed via
 // BlockReader.Version. The root CIDs of the CAR payload are exposed via BlockReader.Roots
 //
+// The given reader is only ever read forward, such that it need not implement io.Seeker; this
+// allows iterating over blocks as they are streamed, e.g. from the body of an HTTP response. Bytes
+// preceding the data payload of a CARv2 are discarded, unless r is an io.Seeker, in which case they
+// are skipped via Seek. An error is returned if the CARv2 header locates the data payload within
+// the header itself, or if the data payload is empty.
+//
 // See BlockReader.Next
-func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
+func NewBlockReader(r io.Reader, opts ...ReadOption) (*BlockReader, error) {
 	options := ApplyOptions(opts...)
 
 	// Read CARv1 header or CARv2 pragma.
@@ -45,14 +51,18 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 		opts:    options,
 	}
 
-	// Expect either version 1 or 2.
+	if err := options.checkVersion(br.Version); err != nil {
+		return nil, err
+	}
+
+	// Expect either version 1 or 2; any other accepted version is read as CARv2.
 	switch br.Version {
 	case 1:
 		// If version is 1, r represents a CARv1.
 		// Simply populate br.Roots and br.r without modifying r.
 		br.Roots = pragmaOrV1Header.Roots
 		br.r = r
-	case 2:
+	default:
 		// If the version is 2:
 		//  1. Read CARv2 specific header to locate the inner CARv1 data payload offset and size.
 		//  2. Skip to the beginning of the inner CARv1 data payload.
@@ -64,6 +74,12 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 		if _, err := v2h.ReadFrom(r); err != nil {
 			return nil, err
 		}
+		if v2h.DataOffset < PragmaSize+HeaderSize {
+			return nil, fmt.Errorf("invalid data payload offset: %d", v2h.DataOffset)
+		}
+		if v2h.DataSize == 0 {
+			return nil, fmt.Errorf("invalid data payload size: %d", v2h.DataSize)
+		}
 
 		// Skip to the beginning of inner CARv1 data payload.
 		// Note, at this point the pragma and CARv1 header have been read.
@@ -89,9 +105,6 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 			return nil, fmt.Errorf("invalid data payload header version; expected 1, got %v", header.Version)
 		}
 		br.Roots = header.Roots
-	default:
-		// Otherwise, error out with invalid version since only versions 1 or 2 are expected.
-		return nil, fmt.Errorf("invalid car version: %d", br.Version)
 	}
 	return br, nil
 }
@@ -106,9 +119,10 @@ func NewBlockReader(r io.Reader, opts ...Option) (*BlockReader, error) {
 // As for CARv2 payload, the underlying io.Reader is read only up to the end of the last block.
 // Note, in a case where ZeroLengthSectionAsEOF Option is enabled, io.EOF is returned
 // immediately upon encountering a zero-length section without reading any further bytes from the
-// underlying io.Reader.
+// underlying io.Reader. Whereas in a case where WithSkipNullPadding Option is enabled, zero-length
+// sections are skipped over and the next block is returned.
 func (br *BlockReader) Next() (blocks.Block, error) {
-	c, data, err := util.ReadNode(br.r, br.opts.ZeroLengthSectionAsEOF, br.opts.MaxAllowedSectionSize)
+	c, data, err := util.ReadNode(br.r, br.opts.ZeroLengthSectionAsEOF, br.opts.SkipNullPadding, br.opts.LenientVarints, br.opts.MaxAllowedSectionSize)
 	if err != nil {
 		return nil, err
 	}
@@ -18,7 +18,7 @@ import (
 func TestBlockReaderFailsOnUnknownVersion(t *testing.T) {
 	r := requireReaderFromPath(t, "testdata/sample-rootless-v42.car")
 	_, err := carv2.NewBlockReader(r)
-	require.EqualError(t, err, "invalid car version: 42")
+	require.EqualError(t, err, "unsupported car version: 42; accepted versions are [1 2]")
 }
 
 func TestBlockReaderFailsOnCorruptPragma(t *testing.T) {
@@ -109,6 +109,58 @@ func TestBlockReader_WithCarV1Consistency(t *testing.T) {
 	}
 }
 
+func TestBlockReader_OverStream(t *testing.T) {
+	for _, path := range []string{
+		"testdata/sample-v1.car",
+		"testdata/sample-wrapped-v2.car",
+		"testdata/sample-v2-index-before-data.car",
+	} {
+		t.Run(path, func(t *testing.T) {
+			want, err := carv2.NewBlockReader(requireReaderFromPath(t, path))
+			require.NoError(t, err)
+
+			// Hide every method of the file other than Read, such that it cannot be seeked.
+			subject, err := carv2.NewBlockReader(struct{ io.Reader }{requireReaderFromPath(t, path)})
+			require.NoError(t, err)
+			require.Equal(t, want.Version, subject.Version)
+			require.Equal(t, want.Roots, subject.Roots)
+
+			var count int
+			for {
+				gotBlock, gotErr := subject.Next()
+				wantBlock, wantErr := want.Next()
+				require.Equal(t, wantBlock, gotBlock)
+				require.Equal(t, wantErr, gotErr)
+				if gotErr == io.EOF {
+					break
+				}
+				count++
+			}
+			require.NotZero(t, count)
+		})
+	}
+}
+
+func TestBlockReaderFailsOnInvalidDataPayload(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		header  carv2.Header
+		wantErr string
+	}{
+		{"OffsetWithinHeader", carv2.Header{DataOffset: carv2.PragmaSize, DataSize: 100}, "invalid data payload offset: 11"},
+		{"EmptyPayload", carv2.Header{DataOffset: carv2.PragmaSize + carv2.HeaderSize}, "invalid data payload size: 0"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			buf.Write(carv2.Pragma)
+			_, err := tt.header.WriteTo(&buf)
+			require.NoError(t, err)
+			_, err = carv2.NewBlockReader(&buf)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
 func TestMaxSectionLength(t *testing.T) {
 	// headerHex is the zero-roots CARv1 header
 	const headerHex = "11a265726f6f7473806776657273696f6e01"
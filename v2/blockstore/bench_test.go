@@ -10,6 +10,8 @@ import (
 	"github.com/ipfs/go-cid"
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
 )
 
 // BenchmarkOpenReadOnlyV1 opens a read-only blockstore,
@@ -78,3 +80,91 @@ func BenchmarkOpenReadOnlyV1(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkReadOnlyEachBlock iterates over all blocks of a read-only blockstore in the order in
+// which they appear on file.
+func BenchmarkReadOnlyEachBlock(b *testing.B) {
+	path := "../testdata/sample-v1.car"
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bs, err := blockstore.OpenReadOnly(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bs.Close()
+	b.SetBytes(info.Size())
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := bs.EachBlock(context.TODO(), func(cid.Cid, []byte, uint64) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadOnlyAllKeysChanThenGet iterates over all keys of a read-only blockstore, and
+// retrieves the block for each key; compare with BenchmarkReadOnlyEachBlock.
+func BenchmarkReadOnlyAllKeysChanThenGet(b *testing.B) {
+	path := "../testdata/sample-v1.car"
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bs, err := blockstore.OpenReadOnly(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bs.Close()
+	b.SetBytes(info.Size())
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		keys, err := bs.AllKeysChan(context.TODO())
+		if err != nil {
+			b.Fatal(err)
+		}
+		for c := range keys {
+			if _, err := bs.Get(context.TODO(), c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkReadOnlyGetSize gets the size of every block of a read-only blockstore, with an index
+// that only stores offsets, such that sizes are read from the payload, and with a sized index.
+func BenchmarkReadOnlyGetSize(b *testing.B) {
+	path := "../testdata/sample-v1.car"
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, index.CarMultihashSizedIndexSorted} {
+		b.Run(codec.String(), func(b *testing.B) {
+			bs, err := blockstore.OpenReadOnly(path, carv2.UseIndexCodec(codec))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer bs.Close()
+			keys, err := bs.AllKeysChan(context.TODO())
+			if err != nil {
+				b.Fatal(err)
+			}
+			var cids []cid.Cid
+			for c := range keys {
+				cids = append(cids, c)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for _, c := range cids {
+					if _, err := bs.GetSize(context.TODO(), c); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -25,4 +25,10 @@
 // * blockstore.Put and blockstore.PutMany will always succeed without performing any operation unless car.StoreIdentityCIDs is enabled.
 //
 // See: https://pkg.go.dev/github.com/ipfs/go-ipfs-blockstore#NewIdStore
+//
+// When blocks are identified by whole CIDs, i.e. UseWholeCIDs or WithStrictCodecMatch is enabled,
+// looking up a block follows every index record with the same multihash until the requested CID is
+// found on file. The number of records followed per lookup is bounded via WithMaxDuplicateLookups,
+// so that CAR files with a very large number of sections with the same multihash cannot make
+// lookups arbitrarily expensive.
 package blockstore
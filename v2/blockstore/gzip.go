@@ -0,0 +1,101 @@
+package blockstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math"
+	"os"
+
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// gzipMagic is the magic number that starts a gzip stream; see RFC 1952. It never starts a CAR,
+// since the CARv1 header of a CAR is a CBOR map, whereas 0x8b starts a CBOR array.
+var gzipMagic = [2]byte{0x1f, 0x8b}
+
+// WithGzipBacking is a read option which makes a ReadOnly blockstore detect backings that are
+// compressed as a whole with gzip, e.g. .car.gz files, and decompress them into memory before
+// reading them as a CAR. Backings that are not gzip compressed are read as usual.
+//
+// Since gzip streams cannot be read at random offsets, the entire backing is decompressed upfront
+// when the blockstore is instantiated, which takes time linear in its size, and the decompressed
+// CAR is held in memory for as long as the blockstore is in use. See WithGzipBackingTempFile to
+// decompress into a temporary file instead.
+//
+// Note that this option only affects ReadOnly blockstores, and is ignored by ReadWrite blockstores
+// and by the root go-car/v2 package.
+func WithGzipBacking() carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreGzipBacking = true
+	}
+}
+
+// WithGzipBackingTempFile is a read option which makes a ReadOnly blockstore decompress backings
+// that are compressed as a whole with gzip as WithGzipBacking does, except that the decompressed
+// CAR is written to a temporary file created in dir rather than held in memory. If dir is empty,
+// the default directory for temporary files is used; see os.TempDir.
+//
+// The temporary file takes as much disk space as the decompressed CAR, and is removed once the
+// blockstore is closed via ReadOnly.Close, which must therefore be called.
+func WithGzipBackingTempFile(dir string) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreGzipBacking = true
+		o.BlockstoreGzipTempFile = true
+		o.BlockstoreGzipTempDir = dir
+	}
+}
+
+// gunzipBacking decompresses the given backing if it starts with the gzip magic number, into
+// memory or into a temporary file as set via WithGzipBacking or WithGzipBackingTempFile. The
+// returned closer removes the temporary file, if any, and is nil otherwise. The backing is
+// returned as is if it is not gzip compressed.
+func gunzipBacking(backing io.ReaderAt, opts carv2.Options) (io.ReaderAt, io.Closer, error) {
+	var magic [2]byte
+	if n, _ := backing.ReadAt(magic[:], 0); n < len(magic) || magic != gzipMagic {
+		// Leave any error reading the backing to be reported when reading it as a CAR.
+		return backing, nil, nil
+	}
+	zr, err := gzip.NewReader(io.NewSectionReader(backing, 0, math.MaxInt64))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+
+	if !opts.BlockstoreGzipTempFile {
+		decompressed, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader(decompressed), nil, nil
+	}
+	f, err := os.CreateTemp(opts.BlockstoreGzipTempDir, "car-gunzipped-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	tf := &tempFile{f}
+	if _, err := io.Copy(f, zr); err != nil {
+		_ = tf.Close()
+		return nil, nil, err
+	}
+	// Rewind the file, since the version of a backing that is an io.Reader is read from its current
+	// position.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = tf.Close()
+		return nil, nil, err
+	}
+	return tf, tf, nil
+}
+
+// tempFile is a temporary file that is removed once closed.
+type tempFile struct {
+	*os.File
+}
+
+func (tf *tempFile) Close() error {
+	err := tf.File.Close()
+	if rerr := os.Remove(tf.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
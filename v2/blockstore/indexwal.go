@@ -0,0 +1,173 @@
+package blockstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-varint"
+)
+
+// indexWALMagic is written at the beginning of every index WAL file.
+var indexWALMagic = []byte("carindexwal\x01")
+
+// maxIndexWALRecordSize is the maximum size of a WAL record payload; anything larger is considered
+// corrupt. It accommodates two varints and a CID of up to 32 MiB, matching the go-cid maximum.
+const maxIndexWALRecordSize = 2*binary.MaxVarintLen64 + 32<<20
+
+type (
+	// indexWAL is an append-only log of the index records of sections written by ReadWrite, used
+	// to restore the index on resumption without rescanning the entire data payload.
+	//
+	// The log starts with indexWALMagic, followed by any number of records, each encoded as:
+	//
+	//	uvarint(len(payload)) | payload | crc32(payload)
+	//
+	// where payload is uvarint(offset) | uvarint(section length) | CID bytes, and the CRC32 uses
+	// the IEEE polynomial in little-endian byte order.
+	// Records are not synced to disk as they are appended. Therefore, the log may end with a
+	// partially written record after a crash, which is discarded when the log is replayed.
+	indexWAL struct {
+		f    *os.File
+		path string
+		size int64 // the size of log up to the end of last record.
+		buf  []byte
+	}
+
+	// indexWALRecord is a single record in the index WAL, describing the section at offset
+	// relative to the beginning of the data payload.
+	indexWALRecord struct {
+		cid    cid.Cid
+		offset uint64
+		length uint64
+	}
+)
+
+// openIndexWAL opens the index WAL at the given path, creating it if it does not exist.
+// An existing file must start with indexWALMagic, unless it is shorter than the magic, in which
+// case it is assumed to be partially initialised and is reset.
+func openIndexWAL(path string) (*indexWAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	w := &indexWAL{f: f, path: path}
+	magic := make([]byte, len(indexWALMagic))
+	if _, err = io.ReadFull(f, magic); err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = w.reset()
+	} else if err == nil && !bytes.Equal(magic, indexWALMagic) {
+		err = errors.New("not a CAR index WAL file: " + path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// reset truncates the log such that it contains no records.
+func (w *indexWAL) reset() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.WriteAt(indexWALMagic, 0); err != nil {
+		return err
+	}
+	w.size = int64(len(indexWALMagic))
+	return nil
+}
+
+// replay calls fn for each record in the log in the order they were appended, until fn returns
+// false or a partially written or corrupt record is encountered.
+// The log is then truncated to exclude the record at which replay stopped and any records after
+// it, such that subsequent appends follow the last accepted record.
+func (w *indexWAL) replay(fn func(indexWALRecord) bool) error {
+	pos := int64(len(indexWALMagic))
+	if _, err := w.f.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(w.f)
+	for {
+		rec, n, ok := readIndexWALRecord(r)
+		if !ok || !fn(rec) {
+			break
+		}
+		pos += n
+	}
+	if err := w.f.Truncate(pos); err != nil {
+		return err
+	}
+	w.size = pos
+	return nil
+}
+
+// readIndexWALRecord reads a single record from r, returning the record along with its encoded
+// size. The returned bool is false if a complete valid record could not be read.
+func readIndexWALRecord(r *bufio.Reader) (indexWALRecord, int64, bool) {
+	var rec indexWALRecord
+	size, err := varint.ReadUvarint(r)
+	if err != nil || size > maxIndexWALRecordSize {
+		return rec, 0, false
+	}
+	payload := make([]byte, size+4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, false
+	}
+	payload, sum := payload[:size], payload[size:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(sum) {
+		return rec, 0, false
+	}
+	offset, n, err := varint.FromUvarint(payload)
+	if err != nil {
+		return rec, 0, false
+	}
+	length, m, err := varint.FromUvarint(payload[n:])
+	if err != nil {
+		return rec, 0, false
+	}
+	cidLen, c, err := cid.CidFromBytes(payload[n+m:])
+	if err != nil || n+m+cidLen != len(payload) {
+		return rec, 0, false
+	}
+	rec = indexWALRecord{cid: c, offset: offset, length: length}
+	return rec, int64(varint.UvarintSize(size)) + int64(size) + 4, true
+}
+
+// append appends a record of the section at the given offset with the given length to the log.
+func (w *indexWAL) append(c cid.Cid, offset, length uint64) error {
+	payload := varint.ToUvarint(offset)
+	payload = append(payload, varint.ToUvarint(length)...)
+	payload = append(payload, c.Bytes()...)
+
+	w.buf = append(w.buf[:0], varint.ToUvarint(uint64(len(payload)))...)
+	w.buf = append(w.buf, payload...)
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.ChecksumIEEE(payload))
+	w.buf = append(w.buf, sum[:]...)
+	if _, err := w.f.WriteAt(w.buf, w.size); err != nil {
+		// Best-effort removal of the partially written record, so that it does not hide records
+		// appended later on replay.
+		_ = w.f.Truncate(w.size)
+		return err
+	}
+	w.size += int64(len(w.buf))
+	return nil
+}
+
+// close closes the log file, leaving it in place for a subsequent resumption.
+func (w *indexWAL) close() error {
+	return w.f.Close()
+}
+
+// remove closes and deletes the log file.
+func (w *indexWAL) remove() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(w.path)
+}
@@ -0,0 +1,213 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexWALReplayDiscardsPartiallyWrittenRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.wal")
+	subject, err := openIndexWAL(path)
+	require.NoError(t, err)
+	require.NoError(t, subject.reset())
+
+	want := generateIndexWALRecords(t, 3)
+	for _, rec := range want {
+		require.NoError(t, subject.append(rec.cid, rec.offset, rec.length))
+	}
+	require.NoError(t, subject.close())
+
+	// Simulate a crash while the last record was being written.
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, stat.Size()-3))
+
+	subject, err = openIndexWAL(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.close()) })
+	require.Equal(t, want[:2], replayAllIndexWALRecords(t, subject))
+
+	// Assert records appended after replay follow the last complete record.
+	require.NoError(t, subject.append(want[2].cid, want[2].offset, want[2].length))
+	require.Equal(t, want, replayAllIndexWALRecords(t, subject))
+}
+
+func TestIndexWALReplayStopsAtCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.wal")
+	subject, err := openIndexWAL(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.close()) })
+	require.NoError(t, subject.reset())
+
+	want := generateIndexWALRecords(t, 3)
+	require.NoError(t, subject.append(want[0].cid, want[0].offset, want[0].length))
+	secondRecordAt := subject.size
+	for _, rec := range want[1:] {
+		require.NoError(t, subject.append(rec.cid, rec.offset, rec.length))
+	}
+
+	// Flip a bit in the payload of the second record.
+	b := make([]byte, 1)
+	_, err = subject.f.ReadAt(b, secondRecordAt+2)
+	require.NoError(t, err)
+	b[0] ^= 0x01
+	_, err = subject.f.WriteAt(b, secondRecordAt+2)
+	require.NoError(t, err)
+
+	require.Equal(t, want[:1], replayAllIndexWALRecords(t, subject))
+	require.Equal(t, secondRecordAt, subject.size)
+}
+
+func TestOpenIndexWALFailsOnUnknownFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not-a.wal")
+	require.NoError(t, os.WriteFile(path, []byte("definitely not a wal file"), 0o666))
+	_, err := openIndexWAL(path)
+	require.Error(t, err)
+}
+
+func TestReadWriteWithIndexWAL(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "readwrite-index-wal.car")
+	walPath := filepath.Join(dir, "readwrite-index-wal.car.wal")
+	blks := generateBlocks(t, 10)
+	roots := []cid.Cid{blks[0].Cid()}
+
+	subject, err := OpenReadWrite(path, roots, WithIndexWAL(walPath))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[:5]))
+	firstSectionOffset, err := index.GetFirst(subject.idx, blks[0].Cid())
+	require.NoError(t, err)
+	// Simulate a crash by discarding the blockstore without finalizing.
+	subject.Discard()
+
+	// Assert the index is restored from the WAL, without reading sections other than the last
+	// one, by corrupting the CID version of the first section, skipping its single-byte length.
+	firstCidVersionAt := int64(carv2.PragmaSize+carv2.HeaderSize) + int64(firstSectionOffset) + 1
+	writeByteAt(t, path, firstCidVersionAt, 0x05)
+	subject, err = OpenReadWrite(path, roots, WithIndexWAL(walPath))
+	require.NoError(t, err)
+	for _, blk := range blks[:5] {
+		size, err := subject.GetSize(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, len(blk.RawData()), size)
+	}
+	subject.Discard()
+
+	// Assert that the index is restored when the WAL is behind the data payload, i.e. a crash
+	// happened after writing blocks but before their WAL records were fully written.
+	writeByteAt(t, path, firstCidVersionAt, 0x01)
+	subject, err = OpenReadWrite(path, roots, WithIndexWAL(walPath))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[5:]))
+	subject.Discard()
+	stat, err := os.Stat(walPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(walPath, stat.Size()-5))
+
+	subject, err = OpenReadWrite(path, roots, WithIndexWAL(walPath))
+	require.NoError(t, err)
+	for _, blk := range blks {
+		got, err := subject.Get(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk, got)
+	}
+	require.NoError(t, subject.Finalize())
+
+	// Assert the WAL is removed upon finalization, and the index is as expected.
+	_, err = os.Stat(walPath)
+	require.True(t, os.IsNotExist(err))
+	robs, err := OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	wantIdx, err := carv2.GenerateIndexFromFile(path)
+	require.NoError(t, err)
+	require.Equal(t, wantIdx, robs.idx)
+}
+
+func TestReadWriteWithMismatchingIndexWALRescansDataPayload(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "readwrite-index-wal.car")
+	otherPath := filepath.Join(dir, "other.car")
+	walPath := filepath.Join(dir, "readwrite-index-wal.car.wal")
+	blks := generateBlocks(t, 4)
+
+	// Populate the WAL using a different CAR file with the same roots and header size.
+	other, err := OpenReadWrite(otherPath, nil, WithIndexWAL(walPath))
+	require.NoError(t, err)
+	require.NoError(t, other.PutMany(ctx, blks[2:]))
+	other.Discard()
+	otherWAL, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+
+	subject, err := OpenReadWrite(path, nil, WithIndexWAL(walPath))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[:2]))
+	subject.Discard()
+	require.NoError(t, os.WriteFile(walPath, otherWAL, 0o666))
+
+	subject, err = OpenReadWrite(path, nil, WithIndexWAL(walPath))
+	require.NoError(t, err)
+	t.Cleanup(subject.Discard)
+	for _, blk := range blks[:2] {
+		has, err := subject.Has(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+	for _, blk := range blks[2:] {
+		has, err := subject.Has(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+}
+
+func writeByteAt(t *testing.T, path string, offset int64, b byte) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, f.Close()) }()
+	_, err = f.WriteAt([]byte{b}, offset)
+	require.NoError(t, err)
+}
+
+func generateBlocks(t *testing.T, count int) []blocks.Block {
+	blks := make([]blocks.Block, count)
+	for i := range blks {
+		data := []byte(fmt.Sprintf("🐟-%d", i))
+		mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		blks[i], err = blocks.NewBlockWithCid(data, cid.NewCidV1(cid.Raw, mh))
+		require.NoError(t, err)
+	}
+	return blks
+}
+
+func generateIndexWALRecords(t *testing.T, count int) []indexWALRecord {
+	recs := make([]indexWALRecord, count)
+	var offset uint64
+	for i, blk := range generateBlocks(t, count) {
+		length := uint64(blk.Cid().ByteLen() + len(blk.RawData()))
+		recs[i] = indexWALRecord{cid: blk.Cid(), offset: offset, length: length}
+		offset += 1 + length
+	}
+	return recs
+}
+
+func replayAllIndexWALRecords(t *testing.T, w *indexWAL) []indexWALRecord {
+	var recs []indexWALRecord
+	require.NoError(t, w.replay(func(rec indexWALRecord) bool {
+		recs = append(recs, rec)
+		return true
+	}))
+	return recs
+}
@@ -0,0 +1,197 @@
+//go:build go1.23
+
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+)
+
+// Keys returns a sequence of the keys in the CAR data payload, for use in a range-over-func loop:
+//
+//	for key, err := range bs.Keys(ctx) {
+//		if err != nil {
+//			// Handle the error; the sequence ends after it.
+//		}
+//	}
+//
+// The keys are the same as those sent by AllKeysChan, in the same order, except that they are read
+// on demand as the sequence is iterated over rather than by a separate goroutine. Any error ends
+// the sequence, and is yielded along with cid.Undef as its final element. In particular, the
+// sequence ends with the context error once ctx is done, and with an error if the blockstore is
+// closed while iterating.
+//
+// The blockstore is read-locked while iterating; the body of the loop must not call any of the
+// write methods of a ReadWrite blockstore.
+func (b *ReadOnly) Keys(ctx context.Context) iter.Seq2[cid.Cid, error] {
+	return func(yield func(cid.Cid, error) bool) {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+
+		if b.closed {
+			yield(cid.Undef, errClosed)
+			return
+		}
+
+		// Once the caller stops ranging over the sequence, errStopIteration stops reading keys.
+		fn := func(c cid.Cid) error {
+			if err := b.checkIteration(ctx); err != nil {
+				return err
+			}
+			if !yield(c, nil) {
+				return errStopIteration
+			}
+			return nil
+		}
+		var err error
+		if idx, ok := b.idx.(*index.CidIndexSorted); ok && b.opts.BlockstoreUseWholeCIDs {
+			err = idx.ForEachCid(func(c cid.Cid, _ uint64) error { return fn(c) })
+		} else {
+			rdr, serr := b.seekFirstSection()
+			if serr != nil {
+				yield(cid.Undef, serr)
+				return
+			}
+			err = b.forEachKey(rdr, fn)
+		}
+		if err != nil && err != errStopIteration {
+			yield(cid.Undef, err)
+		}
+	}
+}
+
+// Blocks returns a sequence of the blocks in the CAR data payload, for use in a range-over-func
+// loop:
+//
+//	for blk, err := range bs.Blocks(ctx) {
+//		if err != nil {
+//			// Handle the error; the sequence ends after it.
+//		}
+//	}
+//
+// The blocks are read sequentially in the order in which they appear in the data payload, as they
+// are by EachBlock, and their CIDs are flattened to the raw codec in the same way. Any error ends
+// the sequence, and is yielded along with a nil block as its final element. In particular, the
+// sequence ends with the context error once ctx is done, and with an error if the blockstore is
+// closed while iterating.
+//
+// The blockstore is read-locked while iterating; the body of the loop must not call any of the
+// write methods of a ReadWrite blockstore.
+func (b *ReadOnly) Blocks(ctx context.Context) iter.Seq2[blocks.Block, error] {
+	return func(yield func(blocks.Block, error) bool) {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+
+		if b.closed {
+			yield(nil, errClosed)
+			return
+		}
+
+		wholeCIDs := b.opts.BlockstoreUseWholeCIDs || b.opts.BlockstoreStrictCodecMatch
+		err := b.eachBlock(ctx, wholeCIDs, func(c cid.Cid, data []byte, _ uint64) error {
+			if err := b.checkIteration(ctx); err != nil {
+				return err
+			}
+			blk, err := blocks.NewBlockWithCid(data, c)
+			if err != nil {
+				return err
+			}
+			if !yield(blk, nil) {
+				return errStopIteration
+			}
+			return nil
+		})
+		if err != nil && err != errStopIteration {
+			yield(nil, err)
+		}
+	}
+}
+
+// IndexRecords returns a sequence of the records of the index in ascending order of offset, for use
+// in a range-over-func loop:
+//
+//	for r, err := range bs.IndexRecords(ctx) {
+//		if err != nil {
+//			// Handle the error; the sequence ends after it.
+//		}
+//	}
+//
+// Records are visited in the order in which their sections appear in the data payload, as blocks
+// are by Blocks and EachBlock, such that the index can be diffed against the payload in a single
+// pass over both. Every record is visited, including duplicate records of the same CID. Offsets are
+// relative to the start of the data payload, and records are populated with as much information as
+// the index stores; see index.OffsetIndex.
+//
+// Unless the index satisfies index.OffsetIndex, the records are ordered on first use, holding every
+// record in memory for the lifetime of the blockstore, as they are by CidAt. An error is yielded if
+// the index is not iterable. Any error ends the sequence, and is yielded along with a zero record as
+// its final element; in particular, the sequence ends with the context error once ctx is done, and
+// with an error if the blockstore is closed while iterating.
+//
+// The blockstore is read-locked while iterating; the body of the loop must not call any of the
+// write methods of a ReadWrite blockstore.
+func (b *ReadOnly) IndexRecords(ctx context.Context) iter.Seq2[index.Record, error] {
+	return func(yield func(index.Record, error) bool) {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+
+		if b.closed {
+			yield(index.Record{}, errClosed)
+			return
+		}
+		offsetIdx := b.offsetIndex()
+		if offsetIdx == nil {
+			yield(index.Record{}, fmt.Errorf("index with codec %s does not support iteration", b.idx.Codec()))
+			return
+		}
+
+		err := offsetIdx.ForEachOffsetOrder(func(r index.Record) error {
+			if err := b.checkIteration(ctx); err != nil {
+				return err
+			}
+			if !yield(r, nil) {
+				return errStopIteration
+			}
+			return nil
+		})
+		if err != nil && err != errStopIteration {
+			yield(index.Record{}, err)
+		}
+	}
+}
+
+// checkIteration returns the context error once ctx is done, or errClosed once the blockstore is
+// being closed, such that iterations in progress are stopped. It must be called with the read lock
+// held.
+func (b *ReadOnly) checkIteration(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.done:
+		return errClosed
+	default:
+		return nil
+	}
+}
+
+// Keys returns a sequence of the keys of the blocks put so far. See ReadOnly.Keys.
+func (b *ReadWrite) Keys(ctx context.Context) iter.Seq2[cid.Cid, error] {
+	return b.ronly.Keys(ctx)
+}
+
+// IndexRecords returns a sequence of the records of the blocks put so far, in ascending order of
+// offset. See ReadOnly.IndexRecords.
+func (b *ReadWrite) IndexRecords(ctx context.Context) iter.Seq2[index.Record, error] {
+	return b.ronly.IndexRecords(ctx)
+}
+
+// Blocks returns a sequence of the blocks put so far, in the order in which they were written.
+// See ReadOnly.Blocks.
+func (b *ReadWrite) Blocks(ctx context.Context) iter.Seq2[blocks.Block, error] {
+	return b.ronly.Blocks(ctx)
+}
@@ -0,0 +1,226 @@
+//go:build go1.23
+
+package blockstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyKeysAndBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		opts []carv2.Option
+	}{
+		{"CarV1", "../testdata/sample-v1.car", nil},
+		{"CarV2", "../testdata/sample-wrapped-v2.car", nil},
+		{"CarV1WithWholeCIDs", "../testdata/sample-v1.car", []carv2.Option{UseWholeCIDs(true)}},
+		{"CarV1WithCidIndex", "../testdata/sample-v1.car", []carv2.Option{UseWholeCIDs(true), carv2.UseIndexCodec(index.CarCidIndexSorted)}},
+		{"CarV1ZeroLenSection", "../testdata/sample-v1-with-zero-len-section.car", []carv2.Option{carv2.ZeroLengthSectionAsEOF(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			subject, err := OpenReadOnly(tt.path, tt.opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+			keysChan, err := subject.AllKeysChan(ctx)
+			require.NoError(t, err)
+			var wantKeys []cid.Cid
+			for key := range keysChan {
+				wantKeys = append(wantKeys, key)
+			}
+			require.NotEmpty(t, wantKeys)
+			var gotKeys []cid.Cid
+			for key, err := range subject.Keys(ctx) {
+				require.NoError(t, err)
+				gotKeys = append(gotKeys, key)
+			}
+			require.Equal(t, wantKeys, gotKeys)
+
+			var wantBlocks []blocks.Block
+			require.NoError(t, subject.EachBlock(ctx, func(c cid.Cid, data []byte, _ uint64) error {
+				blk, err := blocks.NewBlockWithCid(data, c)
+				require.NoError(t, err)
+				wantBlocks = append(wantBlocks, blk)
+				return nil
+			}))
+			var gotBlocks []blocks.Block
+			for blk, err := range subject.Blocks(ctx) {
+				require.NoError(t, err)
+				gotBlocks = append(gotBlocks, blk)
+			}
+			require.Equal(t, wantBlocks, gotBlocks)
+		})
+	}
+}
+
+func TestReadOnlyKeysAndBlocksStop(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-v1.car")
+	require.NoError(t, err)
+
+	// Assert breaking out of the loop stops iteration, and releases the blockstore.
+	var count int
+	for _, err := range subject.Keys(context.Background()) {
+		require.NoError(t, err)
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	require.Equal(t, 3, count)
+	count = 0
+	for blk, err := range subject.Blocks(context.Background()) {
+		require.NoError(t, err)
+		got, err := subject.Get(context.Background(), blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	require.Equal(t, 3, count)
+
+	// Assert the sequences end with the context error once the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count = 0
+	var lastErr error
+	for _, err := range subject.Keys(ctx) {
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		count++
+		cancel()
+	}
+	require.Equal(t, 1, count)
+	require.Equal(t, context.Canceled, lastErr)
+	count, lastErr = 0, nil
+	for blk, err := range subject.Blocks(ctx) {
+		if err != nil {
+			require.Nil(t, blk)
+			lastErr = err
+			continue
+		}
+		count++
+	}
+	require.Zero(t, count)
+	require.Equal(t, context.Canceled, lastErr)
+
+	// Assert the sequences of a closed blockstore only yield an error.
+	require.NoError(t, subject.Close())
+	for key, err := range subject.Keys(context.Background()) {
+		require.Equal(t, cid.Undef, key)
+		require.Equal(t, errClosed, err)
+	}
+	for blk, err := range subject.Blocks(context.Background()) {
+		require.Nil(t, blk)
+		require.Equal(t, errClosed, err)
+	}
+}
+
+func TestReadOnlyIndexRecords(t *testing.T) {
+	ctx := context.Background()
+	subject, err := OpenReadOnly("../testdata/sample-v1.car", carv2.StoreIdentityCIDs(true))
+	require.NoError(t, err)
+
+	// Assert the index-driven iteration matches the payload-driven one, section by section.
+	type section struct {
+		mh     multihash.Multihash
+		offset uint64
+	}
+	var want []section
+	require.NoError(t, subject.EachBlock(ctx, func(c cid.Cid, _ []byte, offset uint64) error {
+		want = append(want, section{c.Hash(), offset})
+		return nil
+	}))
+	require.NotEmpty(t, want)
+	var got []section
+	for r, err := range subject.IndexRecords(ctx) {
+		require.NoError(t, err)
+		got = append(got, section{r.Cid.Hash(), r.Offset})
+	}
+	require.Equal(t, want, got)
+
+	// Assert breaking out of the loop stops iteration.
+	var count int
+	for _, err := range subject.IndexRecords(ctx) {
+		require.NoError(t, err)
+		if count++; count == 3 {
+			break
+		}
+	}
+	require.Equal(t, 3, count)
+
+	require.NoError(t, subject.Close())
+	for r, err := range subject.IndexRecords(ctx) {
+		require.Equal(t, index.Record{}, r)
+		require.Equal(t, errClosed, err)
+	}
+
+	// Assert an error is yielded if the index is not iterable.
+	subject, err = OpenReadOnly("../testdata/sample-v1.car", carv2.UseIndexCodec(multicodec.CarIndexSorted))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+	count = 0
+	for _, err := range subject.IndexRecords(ctx) {
+		require.EqualError(t, err, "index with codec car-index-sorted does not support iteration")
+		count++
+	}
+	require.Equal(t, 1, count)
+}
+
+func TestReadWriteKeysAndBlocks(t *testing.T) {
+	ctx := context.Background()
+	blks := []blocks.Block{
+		merkledag.NewRawNode([]byte("fish")).Block,
+		merkledag.NewRawNode([]byte("lobster")).Block,
+		blocks.NewBlock([]byte("barreleye")),
+	}
+	subject, err := OpenReadWrite(filepath.Join(t.TempDir(), "readwrite-iter.car"), []cid.Cid{blks[0].Cid()}, UseWholeCIDs(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+	require.NoError(t, subject.PutMany(ctx, blks))
+
+	var gotKeys []cid.Cid
+	for key, err := range subject.Keys(ctx) {
+		require.NoError(t, err)
+		gotKeys = append(gotKeys, key)
+	}
+	require.Equal(t, []cid.Cid{blks[0].Cid(), blks[1].Cid(), blks[2].Cid()}, gotKeys)
+	var gotBlocks []blocks.Block
+	for blk, err := range subject.Blocks(ctx) {
+		require.NoError(t, err)
+		gotBlocks = append(gotBlocks, blk)
+	}
+	require.Len(t, gotBlocks, len(blks))
+	for i, blk := range blks {
+		require.Equal(t, blk.Cid(), gotBlocks[i].Cid())
+		require.Equal(t, blk.RawData(), gotBlocks[i].RawData())
+	}
+
+	// Assert the records of the blocks put so far are in the order in which they were written.
+	var gotRecords []cid.Cid
+	var lastOffset uint64
+	for r, err := range subject.IndexRecords(ctx) {
+		require.NoError(t, err)
+		require.Greater(t, r.Offset, lastOffset)
+		lastOffset = r.Offset
+		gotRecords = append(gotRecords, r.Cid)
+	}
+	require.Equal(t, gotKeys, gotRecords)
+}
@@ -0,0 +1,253 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	format "github.com/ipfs/go-ipld-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+)
+
+var (
+	_ blockstore.Blockstore = (*ReadOnlyLayered)(nil)
+	_ blockstore.Viewer     = (*ReadOnlyLayered)(nil)
+)
+
+// ReadOnlyLayered provides a read-only blockstore over several CAR layers, e.g. a base CAR with a
+// complete index and overlay CARs that add blocks, each with their own index. Lookups check each
+// layer in order, such that a block in an earlier layer shadows a block with the same key in a later
+// one, and blocks missing from earlier layers are supplemented by later ones.
+//
+// Each layer is a ReadOnly blockstore over its own backing and index, such that offsets in an index
+// are only ever resolved against the backing of its layer.
+type ReadOnlyLayered struct {
+	layers []*ReadOnly
+	opts   carv2.Options
+
+	// done is closed by Close, signalling any in-flight AllKeysChan goroutines to stop.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReadOnlyLayered creates a new ReadOnlyLayered blockstore with one layer per backing, in order of
+// precedence. Each backing is opened as by NewReadOnly with the index at the same position in
+// indexes, or with a nil index if indexes is nil, in which case the index of each layer is read from
+// its backing or generated. The given options apply to every layer.
+//
+// An error is returned if no backing is given, or if indexes is neither nil nor of the same length
+// as backings.
+func NewReadOnlyLayered(backings []io.ReaderAt, indexes []index.Index, opts ...carv2.Option) (*ReadOnlyLayered, error) {
+	if len(backings) == 0 {
+		return nil, errors.New("at least one backing is required")
+	}
+	if indexes != nil && len(indexes) != len(backings) {
+		return nil, fmt.Errorf("number of indexes %d does not match number of backings %d", len(indexes), len(backings))
+	}
+	b := &ReadOnlyLayered{
+		layers: make([]*ReadOnly, 0, len(backings)),
+		opts:   carv2.ApplyOptions(opts...),
+		done:   make(chan struct{}),
+	}
+	for i, backing := range backings {
+		var idx index.Index
+		if indexes != nil {
+			idx = indexes[i]
+		}
+		layer, err := NewReadOnly(backing, idx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open layer %d: %w", i, err)
+		}
+		b.layers = append(b.layers, layer)
+	}
+	return b, nil
+}
+
+// DeleteBlock is not supported and always returns an error.
+func (b *ReadOnlyLayered) DeleteBlock(context.Context, cid.Cid) error {
+	return errReadOnly
+}
+
+// Put is not supported and always returns an error.
+func (b *ReadOnlyLayered) Put(context.Context, blocks.Block) error {
+	return errReadOnly
+}
+
+// PutMany is not supported and always returns an error.
+func (b *ReadOnlyLayered) PutMany(context.Context, []blocks.Block) error {
+	return errReadOnly
+}
+
+// Has indicates if any of the layers contains a block that corresponds to the given key.
+// This function always returns true for any given key with multihash.IDENTITY code.
+func (b *ReadOnlyLayered) Has(ctx context.Context, key cid.Cid) (bool, error) {
+	has, err := b.has(ctx, key, len(b.layers))
+	if hook := b.opts.BlockstoreHasHook; hook != nil {
+		hook(key, has, err)
+	}
+	return has, err
+}
+
+// has checks whether any of the first n layers contains the block corresponding to the given key.
+func (b *ReadOnlyLayered) has(ctx context.Context, key cid.Cid, n int) (bool, error) {
+	for _, layer := range b.layers[:n] {
+		if has, err := layer.has(ctx, key); err != nil || has {
+			return has, err
+		}
+	}
+	return false, nil
+}
+
+// Get gets the block corresponding to the given key from the first layer that contains it.
+// This API will always succeed if the given key has multihash.IDENTITY code.
+//
+// Errors other than format.ErrNotFound are returned as soon as a layer returns them, without
+// checking the layers following it.
+func (b *ReadOnlyLayered) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
+	blk, err := b.get(ctx, key)
+	if hook := b.opts.BlockstoreGetHook; hook != nil {
+		var size int
+		if err == nil {
+			size = len(blk.RawData())
+		}
+		hook(key, size, err)
+	}
+	return blk, err
+}
+
+func (b *ReadOnlyLayered) get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
+	for _, layer := range b.layers {
+		blk, err := layer.get(ctx, key)
+		if !format.IsNotFound(err) {
+			return blk, err
+		}
+	}
+	return nil, format.ErrNotFound{Cid: key}
+}
+
+// GetSize gets the size of the block corresponding to the given key from the first layer that
+// contains it.
+func (b *ReadOnlyLayered) GetSize(ctx context.Context, key cid.Cid) (int, error) {
+	for _, layer := range b.layers {
+		size, err := layer.GetSize(ctx, key)
+		if !format.IsNotFound(err) {
+			return size, err
+		}
+	}
+	return -1, format.ErrNotFound{Cid: key}
+}
+
+// View calls callback with the data of the block corresponding to the given key, read from the
+// first layer that contains it. See ReadOnly.View.
+func (b *ReadOnlyLayered) View(ctx context.Context, key cid.Cid, callback func([]byte) error) error {
+	var size int
+	err := b.view(ctx, key, func(data []byte) error {
+		size = len(data)
+		return callback(data)
+	})
+	if hook := b.opts.BlockstoreGetHook; hook != nil {
+		if err != nil {
+			size = 0
+		}
+		hook(key, size, err)
+	}
+	return err
+}
+
+func (b *ReadOnlyLayered) view(ctx context.Context, key cid.Cid, callback func([]byte) error) error {
+	for _, layer := range b.layers {
+		// Only look up the following layers if the block was not found, rather than if the callback
+		// happens to return format.ErrNotFound.
+		var called bool
+		err := layer.view(ctx, key, func(data []byte) error {
+			called = true
+			return callback(data)
+		})
+		if called || !format.IsNotFound(err) {
+			return err
+		}
+	}
+	return format.ErrNotFound{Cid: key}
+}
+
+// AllKeysChan returns the union of the keys in the data payloads of the layers, in order of the
+// layers. Keys of blocks that are shadowed by an earlier layer are skipped, such that each key is
+// sent for the layer it is served from. As with ReadOnly.AllKeysChan, keys that are duplicated
+// within a layer, as well as keys with multihash.IDENTITY code, may be sent more than once.
+//
+// Errors that occur while reading the keys of any layer are passed to the error handler set via
+// WithAsyncErrorHandler, if any, and terminate the asynchronous operation.
+func (b *ReadOnlyLayered) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	// The keys of the layers are read with a context that is cancelled once done, such that layers
+	// release their read lock if keys are no longer consumed. The first layer is opened
+	// synchronously, such that errors such as errClosed are returned.
+	layerCtx, cancel := context.WithCancel(ctx)
+	first, err := b.layers[0].AllKeysChan(layerCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	ch := make(chan cid.Cid, 5)
+	go func() {
+		defer close(ch)
+		defer cancel()
+
+		for i, layer := range b.layers {
+			keys := first
+			if i > 0 {
+				var err error
+				if keys, err = layer.AllKeysChan(layerCtx); err != nil {
+					maybeReportError(ctx, err)
+					return
+				}
+			}
+			for key := range keys {
+				if _, ok, _ := isIdentity(key); !ok && i > 0 {
+					shadowed, err := b.has(ctx, key, i)
+					if err != nil {
+						maybeReportError(ctx, err)
+						return
+					}
+					if shadowed {
+						continue
+					}
+				}
+				select {
+				case ch <- key:
+				case <-ctx.Done():
+					maybeReportError(ctx, ctx.Err())
+					return
+				case <-b.done:
+					maybeReportError(ctx, errClosed)
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// HashOnRead is currently unimplemented; hashing on reads never happens.
+func (b *ReadOnlyLayered) HashOnRead(bool) {}
+
+// Close closes every layer, returning the first error encountered, if any.
+// After this call, the blockstore can no longer be used.
+//
+// Any AllKeysChan that hasn't been fully consumed or cancelled is stopped.
+func (b *ReadOnlyLayered) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	var err error
+	for _, layer := range b.layers {
+		if cerr := layer.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
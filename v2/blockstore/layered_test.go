@@ -0,0 +1,133 @@
+package blockstore_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyLayered(t *testing.T) {
+	ctx := context.Background()
+	base, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, base.Close()) })
+	baseBS, err := blockstore.NewReadOnly(base, nil)
+	require.NoError(t, err)
+	baseKeys := allKeys(t, baseBS)
+	var shadowed cid.Cid
+	for _, key := range baseKeys {
+		if key.Prefix().MhType != multihash.IDENTITY {
+			shadowed = key
+			break
+		}
+	}
+
+	// Write an overlay that supplements the base with a new block, and shadows a block of the base
+	// with distinct data, such that the layer a block is served from can be told apart.
+	added := merkledag.NewRawNode([]byte("overlaid")).Block
+	shadowing, err := blocks.NewBlockWithCid([]byte("shadowing"), shadowed)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "overlay.car")
+	rw, err := blockstore.OpenReadWrite(path, []cid.Cid{added.Cid()})
+	require.NoError(t, err)
+	require.NoError(t, rw.PutMany(ctx, []blocks.Block{added, shadowing}))
+	require.NoError(t, rw.Finalize())
+	overlay, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, overlay.Close()) })
+
+	baseIdx, err := carv2.GenerateIndexFromFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	subject, err := blockstore.NewReadOnlyLayered([]io.ReaderAt{overlay, io.NewSectionReader(base, 0, math.MaxInt64)}, []index.Index{nil, baseIdx})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+	// Assert the overlay shadows the base.
+	got, err := subject.Get(ctx, shadowed)
+	require.NoError(t, err)
+	require.Equal(t, shadowing.RawData(), got.RawData())
+	size, err := subject.GetSize(ctx, shadowed)
+	require.NoError(t, err)
+	require.Equal(t, len(shadowing.RawData()), size)
+	require.NoError(t, subject.View(ctx, shadowed, func(data []byte) error {
+		require.Equal(t, shadowing.RawData(), data)
+		return nil
+	}))
+
+	// Assert the overlay supplements the base, which serves the blocks missing from the overlay.
+	for _, key := range []cid.Cid{added.Cid(), baseKeys[len(baseKeys)-1]} {
+		has, err := subject.Has(ctx, key)
+		require.NoError(t, err)
+		require.True(t, has)
+		_, err = subject.Get(ctx, key)
+		require.NoError(t, err)
+	}
+	missing := merkledag.NewRawNode([]byte("missing")).Cid()
+	has, err := subject.Has(ctx, missing)
+	require.NoError(t, err)
+	require.False(t, has)
+	_, err = subject.Get(ctx, missing)
+	require.True(t, format.IsNotFound(err))
+	_, err = subject.GetSize(ctx, missing)
+	require.True(t, format.IsNotFound(err))
+
+	// Assert the keys are the union of the layers, without the key shadowed by the overlay twice.
+	want := []cid.Cid{added.Cid(), shadowed}
+	for _, key := range baseKeys {
+		if !key.Equals(shadowed) {
+			want = append(want, key)
+		}
+	}
+	require.Equal(t, want, allKeys(t, subject))
+
+	// Assert the base shadows the overlay once layered first.
+	reversed, err := blockstore.NewReadOnlyLayered([]io.ReaderAt{io.NewSectionReader(base, 0, math.MaxInt64), io.NewSectionReader(overlay, 0, math.MaxInt64)}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reversed.Close()) })
+	got, err = reversed.Get(ctx, shadowed)
+	require.NoError(t, err)
+	require.NotEqual(t, shadowing.RawData(), got.RawData())
+	require.Equal(t, append(append([]cid.Cid(nil), baseKeys...), added.Cid()), allKeys(t, reversed))
+
+	require.NoError(t, subject.Close())
+	_, err = subject.AllKeysChan(ctx)
+	require.Error(t, err)
+	_, err = subject.Get(ctx, added.Cid())
+	require.Error(t, err)
+}
+
+func TestNewReadOnlyLayeredErrors(t *testing.T) {
+	_, err := blockstore.NewReadOnlyLayered(nil, nil)
+	require.EqualError(t, err, "at least one backing is required")
+
+	base, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, base.Close()) })
+	_, err = blockstore.NewReadOnlyLayered([]io.ReaderAt{base}, []index.Index{nil, nil})
+	require.EqualError(t, err, "number of indexes 2 does not match number of backings 1")
+}
+
+func allKeys(t *testing.T, bs interface {
+	AllKeysChan(context.Context) (<-chan cid.Cid, error)
+}) []cid.Cid {
+	ch, err := bs.AllKeysChan(context.Background())
+	require.NoError(t, err)
+	var keys []cid.Cid
+	for key := range ch {
+		keys = append(keys, key)
+	}
+	return keys
+}
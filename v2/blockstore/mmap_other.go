@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package blockstore
+
+import "golang.org/x/exp/mmap"
+
+// mmapFile memory-maps the file at the given path. The mapped bytes are not exposed, hence View
+// copies block data read from it.
+func mmapFile(path string) (readerAtCloser, error) {
+	f, err := mmap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,75 @@
+//go:build linux || darwin
+
+package blockstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// mmapBacking is a read-only memory mapping of a file. Unlike mmap.ReaderAt, it exposes the mapped
+// bytes, such that View can pass block data to its callback without copying it.
+type mmapBacking struct {
+	data []byte
+}
+
+// mmapFile memory-maps the file at the given path.
+func mmapFile(path string) (readerAtCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+	if size == 0 {
+		return &mmapBacking{data: []byte{}}, nil
+	}
+	if size != int64(int(size)) {
+		return nil, fmt.Errorf("file %q is too large to be memory-mapped", path)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &mmapBacking{data: data}, nil
+}
+
+func (m *mmapBacking) ReadAt(p []byte, off int64) (int, error) {
+	if m.data == nil {
+		return 0, errors.New("mmap: closed")
+	}
+	if off < 0 || int64(len(m.data)) < off {
+		return 0, fmt.Errorf("mmap: invalid ReadAt offset %d", off)
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Len returns the length of the mapped file.
+func (m *mmapBacking) Len() int {
+	return len(m.data)
+}
+
+// Bytes returns the mapped bytes, which are only valid until Close is called.
+func (m *mmapBacking) Bytes() []byte {
+	return m.data
+}
+
+func (m *mmapBacking) Close() error {
+	data := m.data
+	m.data = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package blockstore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocate reserves disk space for the given file such that it is at least size bytes long,
+// using fallocate where supported by the filesystem, and falling back on truncation otherwise.
+func preallocate(f *os.File, size int64) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() >= size {
+		return nil
+	}
+	for {
+		err = syscall.Fallocate(int(f.Fd()), 0, 0, size)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return f.Truncate(size)
+	}
+	return err
+}
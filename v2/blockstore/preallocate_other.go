@@ -0,0 +1,18 @@
+//go:build !linux
+
+package blockstore
+
+import "os"
+
+// preallocate extends the given file to at least size bytes long. Unlike on Linux, disk space is
+// not necessarily reserved, since the file is extended by truncation.
+func preallocate(f *os.File, size int64) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() >= size {
+		return nil
+	}
+	return f.Truncate(size)
+}
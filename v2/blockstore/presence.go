@@ -0,0 +1,150 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ blockstore.Blockstore = (*PresenceOnly)(nil)
+
+// ErrDataNotAvailable signals that a block is present in a PresenceOnly blockstore, but that its
+// data, or its size, cannot be served, since the blockstore has no data payload to read it from.
+var ErrDataNotAvailable = errors.New("block is present but its data is not available from a presence-only blockstore")
+
+// PresenceOnly provides a read-only blockstore backed by an index alone, without the CAR payload it
+// indexes, e.g. to answer whether a block is present and advertise content cheaply using a loaded
+// index file, while the data lives elsewhere.
+//
+// Since indices are keyed by multihash, blocks are looked up by multihash regardless of the codec
+// of the CIDs given, as ReadOnly does by default. Keys with multihash.IDENTITY code are always
+// present, and their data is served from the key itself.
+type PresenceOnly struct {
+	idx index.Index
+}
+
+// NewPresenceOnly creates a new PresenceOnly blockstore backed by the given index, which must not
+// be nil. The index is not modified, and may be shared with other blockstores.
+func NewPresenceOnly(idx index.Index) (*PresenceOnly, error) {
+	if idx == nil {
+		return nil, errors.New("index must not be nil")
+	}
+	return &PresenceOnly{idx: idx}, nil
+}
+
+// Has indicates if the index contains the given key.
+// This function always returns true for any given key with multihash.IDENTITY code.
+func (b *PresenceOnly) Has(_ context.Context, key cid.Cid) (bool, error) {
+	if _, ok, err := isIdentity(key); err != nil {
+		return false, err
+	} else if ok {
+		return true, nil
+	}
+	err := b.idx.GetAll(key, func(uint64) bool { return false })
+	if errors.Is(err, index.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Get returns ErrDataNotAvailable if the index contains the given key, and format.ErrNotFound
+// otherwise. The block is only returned for keys with multihash.IDENTITY code, whose data is
+// inlined in the key.
+func (b *PresenceOnly) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
+	if digest, ok, err := isIdentity(key); err != nil {
+		return nil, err
+	} else if ok {
+		return blocks.NewBlockWithCid(digest, key)
+	}
+	has, err := b.Has(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, format.ErrNotFound{Cid: key}
+	}
+	return nil, ErrDataNotAvailable
+}
+
+// GetSize gets the size of the block corresponding to the given key, if it is recorded in the index,
+// i.e. if the index is an index.SizedIndex such as one in the index.CarMultihashSizedIndexSorted
+// codec. Otherwise, ErrDataNotAvailable is returned if the index contains the given key, and
+// format.ErrNotFound if not.
+func (b *PresenceOnly) GetSize(ctx context.Context, key cid.Cid) (int, error) {
+	if digest, ok, err := isIdentity(key); err != nil {
+		return -1, err
+	} else if ok {
+		return len(digest), nil
+	}
+	if sidx, ok := b.idx.(index.SizedIndex); ok {
+		size, known, err := sidx.GetSize(key)
+		if errors.Is(err, index.ErrNotFound) {
+			return -1, format.ErrNotFound{Cid: key}
+		} else if err != nil {
+			return -1, err
+		}
+		if known {
+			return int(size), nil
+		}
+		return -1, ErrDataNotAvailable
+	}
+	// Get returns ErrDataNotAvailable if the key is indexed, since it is not an identity CID.
+	_, err := b.Get(ctx, key)
+	return -1, err
+}
+
+// AllKeysChan returns the keys in the index, with the multihash of each record flattened to a CID
+// with the raw codec, since the index does not record the codec of blocks. As with
+// ReadOnly.AllKeysChan, keys of blocks that are indexed more than once are sent more than once.
+//
+// An error is returned if the index is not an index.IterableIndex. Errors that occur while
+// iterating over the index are passed to the error handler set via WithAsyncErrorHandler, if any,
+// and terminate the asynchronous operation.
+func (b *PresenceOnly) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	iidx, ok := b.idx.(index.IterableIndex)
+	if !ok {
+		return nil, errors.New("cannot list the keys of an index that is not iterable")
+	}
+	ch := make(chan cid.Cid, 5)
+	go func() {
+		defer close(ch)
+		err := iidx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+			select {
+			case ch <- cid.NewCidV1(cid.Raw, mh):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			maybeReportError(ctx, err)
+		}
+	}()
+	return ch, nil
+}
+
+// DeleteBlock is not supported and always returns an error.
+func (b *PresenceOnly) DeleteBlock(context.Context, cid.Cid) error {
+	return errReadOnly
+}
+
+// Put is not supported and always returns an error.
+func (b *PresenceOnly) Put(context.Context, blocks.Block) error {
+	return errReadOnly
+}
+
+// PutMany is not supported and always returns an error.
+func (b *PresenceOnly) PutMany(context.Context, []blocks.Block) error {
+	return errReadOnly
+}
+
+// HashOnRead is a no-op, since block data is never read.
+func (b *PresenceOnly) HashOnRead(bool) {}
@@ -0,0 +1,120 @@
+package blockstore_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceOnly(t *testing.T) {
+	ctx := context.TODO()
+	const path = "../testdata/sample-v1.car"
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	r, err := carv1.NewCarReader(f)
+	require.NoError(t, err)
+	var want []blocks.Block
+	for {
+		blk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if blk.Cid().Prefix().MhType != multihash.IDENTITY {
+			want = append(want, blk)
+		}
+	}
+	missing := merkledag.NewRawNode([]byte("lobster")).Cid()
+	identity, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.IDENTITY, MhLength: -1}.Sum([]byte("fish"))
+	require.NoError(t, err)
+
+	for _, codec := range []multicodec.Code{index.CarMultihashSizedIndexSorted, multicodec.CarMultihashIndexSorted, multicodec.CarIndexSorted} {
+		codec := codec
+		t.Run(codec.String(), func(t *testing.T) {
+			// Load the index from file, without the CAR it indexes.
+			generated, err := carv2.GenerateIndexFromFile(path, carv2.UseIndexCodec(codec))
+			require.NoError(t, err)
+			idxPath := filepath.Join(t.TempDir(), "sample.idx")
+			require.NoError(t, index.SaveToFile(generated, idxPath))
+			idx, err := index.FromFile(idxPath)
+			require.NoError(t, err)
+			subject, err := blockstore.NewPresenceOnly(idx)
+			require.NoError(t, err)
+
+			for _, blk := range want {
+				has, err := subject.Has(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.True(t, has)
+				// Blocks are matched by multihash.
+				has, err = subject.Has(ctx, cid.NewCidV1(cid.DagCBOR, blk.Cid().Hash()))
+				require.NoError(t, err)
+				require.True(t, has)
+
+				_, err = subject.Get(ctx, blk.Cid())
+				require.True(t, errors.Is(err, blockstore.ErrDataNotAvailable))
+
+				size, err := subject.GetSize(ctx, blk.Cid())
+				if codec == index.CarMultihashSizedIndexSorted {
+					require.NoError(t, err)
+					require.Equal(t, len(blk.RawData()), size)
+				} else {
+					require.True(t, errors.Is(err, blockstore.ErrDataNotAvailable))
+				}
+			}
+
+			has, err := subject.Has(ctx, missing)
+			require.NoError(t, err)
+			require.False(t, has)
+			_, err = subject.Get(ctx, missing)
+			require.True(t, format.IsNotFound(err))
+			_, err = subject.GetSize(ctx, missing)
+			require.True(t, format.IsNotFound(err))
+
+			// Assert identity CIDs are served from the key itself.
+			has, err = subject.Has(ctx, identity)
+			require.NoError(t, err)
+			require.True(t, has)
+			blk, err := subject.Get(ctx, identity)
+			require.NoError(t, err)
+			require.Equal(t, []byte("fish"), blk.RawData())
+
+			require.Error(t, subject.Put(ctx, want[0]))
+			require.Error(t, subject.DeleteBlock(ctx, want[0].Cid()))
+
+			keys, err := subject.AllKeysChan(ctx)
+			if codec == multicodec.CarIndexSorted {
+				// Digest-only indices cannot reconstruct multihashes.
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var count int
+			for key := range keys {
+				has, err := subject.Has(ctx, key)
+				require.NoError(t, err)
+				require.True(t, has)
+				count++
+			}
+			require.Equal(t, len(want), count)
+		})
+	}
+
+	_, err = blockstore.NewPresenceOnly(nil)
+	require.Error(t, err)
+}
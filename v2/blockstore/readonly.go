@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -17,17 +18,20 @@ import (
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
-	"github.com/multiformats/go-varint"
-	"golang.org/x/exp/mmap"
 )
 
-var _ blockstore.Blockstore = (*ReadOnly)(nil)
+var (
+	_ blockstore.Blockstore = (*ReadOnly)(nil)
+	_ blockstore.Viewer     = (*ReadOnly)(nil)
+)
 
 var (
 	errZeroLengthSection = fmt.Errorf("zero-length carv2 section not allowed by default; see WithZeroLengthSectionAsEOF option")
 	errReadOnly          = fmt.Errorf("called write method on a read-only carv2 blockstore")
 	errClosed            = fmt.Errorf("cannot use a carv2 blockstore after closing")
+	errStopIteration     = fmt.Errorf("iteration stopped")
 )
 
 // ReadOnly provides a read-only CAR Block Store.
@@ -45,18 +49,62 @@ type ReadOnly struct {
 	// will return errClosed to avoid panics or broken behavior.
 	closed bool
 
+	// done is closed when the blockstore is closed, signalling any in-flight AllKeysChan
+	// goroutines to stop. Unlike closed, it is not guarded by mu, since closing must be signalled
+	// before the mutex can be acquired for writing. It is guarded by doneMu instead, and is only
+	// replaced while mu is held for writing, i.e. while no AllKeysChan goroutines are running.
+	done       chan struct{}
+	doneMu     sync.Mutex
+	doneClosed bool
+
 	// The backing containing the data payload in CARv1 format.
 	backing io.ReaderAt
 
+	// data holds the bytes of the data payload if the backing exposes them directly, e.g. when it
+	// is memory-mapped, in which case View passes block data to its callback without copying it.
+	// It is only valid until the blockstore is closed.
+	data []byte
+
 	// The CARv1 content index.
 	idx index.Index
 
+	// The optional bloom filter over the multihashes of idx, consulted before looking up idx.
+	bloom *index.Bloom
+
+	// offsetIdx resolves offsets via idx, and is set on first use via offsetIndex. It is nil if idx
+	// cannot resolve offsets, i.e. if it is not iterable.
+	offsetIdx     index.OffsetIndex
+	offsetIdxOnce sync.Once
+
+	// mhIdx holds the records of idx keyed by multihash alone, and is set on first use via
+	// multihashIndex if idx records whole CIDs, in which case it cannot be looked up by multihash.
+	mhIdx     index.Index
+	mhIdxErr  error
+	mhIdxOnce sync.Once
+
 	// If we called carv2.NewReaderMmap, remember to close it too.
 	carv2Closer io.Closer
 
 	opts carv2.Options
 }
 
+// viewBufPool pools the buffers used by View to read block data when no zero-copy access to the
+// backing is available.
+var viewBufPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// bytesBacking is implemented by backings that expose their content as a contiguous byte slice,
+// e.g. memory-mapped files, allowing zero-copy reads.
+type bytesBacking interface {
+	Bytes() []byte
+}
+
+type readerAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
 type contextKey string
 
 const asyncErrHandlerKey contextKey = "asyncErrorHandlerKey"
@@ -85,6 +133,215 @@ func UseWholeCIDs(enable bool) carv2.Option {
 	}
 }
 
+// WithStrictCodecMatch is a read option which makes a CAR blockstore that identifies blocks by
+// multihash only return a block if the codec of its CID on file matches the codec of the requested
+// CID. Blocks are still indexed by multihash, and CIDs of different versions but with the same
+// codec and multihash match. This resolves the ambiguity of CAR files that contain blocks with the
+// same multihash but different codecs, without identifying blocks by whole CIDs via UseWholeCIDs.
+//
+// Enabling this option affects a number of methods:
+//
+// • Get, GetSize, Has and View will only return a block whose codec matches the requested one,
+// and return format.ErrNotFound otherwise.
+//
+// • AllKeysChan and EachBlock will return the original whole CIDs, instead of with their
+// multicodec set to "raw", so that the returned keys can be looked up.
+//
+// • If AllowDuplicatePuts isn't set,
+// Put and PutMany will deduplicate by the whole CID.
+//
+// This option has no effect if UseWholeCIDs is enabled.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithStrictCodecMatch() carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreStrictCodecMatch = true
+	}
+}
+
+// WithMaxDuplicateLookups is a read option which sets the maximum number of index records with the
+// same multihash that a CAR blockstore looks at when looking up a single block via Get, GetSize,
+// Has or View. Looking up a block whose multihash is shared by more records results in a
+// car.ErrTooManyDuplicateLookups error, rather than the lookup silently giving up.
+// Defaults to car.DefaultMaxDuplicateLookups.
+//
+// Only the first record is ever looked at when identifying blocks by multihash. Therefore, this
+// option only has an effect when UseWholeCIDs or WithStrictCodecMatch is enabled, in which case
+// every record with the same multihash is looked at until one matches the requested CID. Note that
+// each record looked at requires reading the CID of its section from the CAR file.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithMaxDuplicateLookups(n uint64) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreMaxDuplicateLookups = n
+	}
+}
+
+// WithGetHook sets a function that is called after every ReadOnly.Get and ReadOnly.View call, with
+// the requested CID, the size of the block data and the error returned by the call, if any. The
+// size is zero if the call failed. This allows observing blockstore operations, e.g. via metrics,
+// without wrapping the blockstore.
+//
+// The hook is called after the blockstore lock is released, and must be safe for concurrent use.
+// A nil hook is ignored.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithGetHook(hook func(c cid.Cid, size int, err error)) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreGetHook = hook
+	}
+}
+
+// WithHasHook sets a function that is called after every ReadOnly.Has call, with the requested
+// CID, whether the block is present and the error returned by the call, if any.
+//
+// The hook is called after the blockstore lock is released, and must be safe for concurrent use.
+// A nil hook is ignored.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithHasHook(hook func(c cid.Cid, has bool, err error)) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreHasHook = hook
+	}
+}
+
+// WithVerifyOnGet is a read option which makes Get tolerate an index that is stale or corrupt, e.g.
+// when recovering data from a damaged CAR. Get always checks that the section at an indexed offset
+// has the requested CID; with this option, if none of the sections at the indexed offsets match,
+// the data payload is scanned linearly for a matching section instead of failing. Blocks that are
+// not in the index at all are not scanned for.
+//
+// This trades lookup speed for robustness, since the scan reads the data payload from the start.
+// Discrepancies found this way may be reported via WithIndexMismatchHook.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithVerifyOnGet() carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreVerifyOnGet = true
+	}
+}
+
+// WithIndexMismatchHook sets a function that is called whenever Get falls back on scanning the data
+// payload because of WithVerifyOnGet, with the requested CID, the first offset at which the index
+// claimed the block to be, the offset at which the block was actually found, and whether it was
+// found at all.
+//
+// Unlike other hooks, the hook is called while the blockstore lock is held, and must not call the
+// blockstore. It must be safe for concurrent use. A nil hook is ignored.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithIndexMismatchHook(hook func(key cid.Cid, indexedOffset, actualOffset uint64, found bool)) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreIndexMismatchHook = hook
+	}
+}
+
+// WithPutHook sets a function that is called for every block put via ReadWrite.Put and
+// ReadWrite.PutMany, with the CID of the block, the size of its data and the error that resulted
+// from putting it, if any. Blocks that are not written because they are deduplicated are reported
+// as successfully put. When putting a block fails, the remaining blocks of the same PutMany call are
+// not attempted, and are not reported.
+//
+// The hook is called after the blockstore lock is released, and must be safe for concurrent use.
+// A nil hook is ignored.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithPutHook(hook func(c cid.Cid, size int, err error)) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstorePutHook = hook
+	}
+}
+
+// UseMmapIndex is a read option which makes a ReadOnly blockstore look up blocks in the index
+// embedded in a CARv2 backing via index.OpenMmap, rather than reading the entire index into memory.
+// Lookups then search over the index as stored in the backing, which is memory-mapped when the
+// blockstore is instantiated via OpenReadOnly, unless WithoutMmap is set.
+//
+// Only embedded indices with multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted or
+// index.CarMultihashIndexHashed codecs are looked up this way, including checksummed ones, and only
+// if the size of the backing can be determined; otherwise, the index is read into memory as usual.
+//
+// Regardless of this option, embedded indices larger than the threshold set via UseMmapIndexAbove
+// are looked up this way.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func UseMmapIndex(enable bool) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreMmapIndex = enable
+	}
+}
+
+// UseMmapIndexAbove is a read option which sets the size in bytes of the index embedded in a CARv2
+// backing above which a ReadOnly blockstore looks up blocks via index.OpenMmap, as it does when
+// UseMmapIndex is enabled. This avoids reading large indices into memory upfront, which is
+// particularly costly when the backing is remote, e.g. fetched over the network on every read,
+// and only a handful of blocks are looked up. Lookups then read from the backing instead.
+//
+// The size of the index is that of the backing past the index offset, and is therefore only known
+// if the size of the backing can be determined. Setting the threshold to math.MaxUint64 disables
+// this behaviour altogether.
+//
+// Defaults to car.DefaultMmapIndexThreshold.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func UseMmapIndexAbove(size uint64) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreMmapIndexThreshold = size
+	}
+}
+
+// WithoutMmap is a read option which makes OpenReadOnly read the CAR file as a regular file, rather
+// than memory-mapping it. By default, the file is memory-mapped, and only read as a regular file if
+// memory-mapping it fails, e.g. on filesystems that do not support it. Either way, the file is
+// closed when the blockstore is closed.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithoutMmap() carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreDisableMmap = true
+	}
+}
+
+// WithBloomFilter is a read option which makes a ReadOnly blockstore build a bloom filter over the
+// multihashes of its index when it is instantiated, with the given false positive rate. Lookups of
+// blocks that are not in the CAR are then answered by the filter, without looking up the index,
+// except for false positives. Building the filter requires iterating over the index once, which
+// must therefore be an index.IterableIndex. The filter is ignored by ReadWrite blockstores.
+// See index.BuildBloom.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithBloomFilter(fpRate float64) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreBloomFPRate = fpRate
+	}
+}
+
+// UseBloomFilter is a read option which makes a ReadOnly blockstore consult the given bloom
+// filter before looking up its index, as with WithBloomFilter, rather than building one. The
+// filter must have been built over the records of the index of the CAR, e.g. via index.BuildBloom
+// and read back from a file next to it via index.Bloom.Unmarshal; otherwise, blocks that are in the
+// CAR may wrongly be reported as absent. The filter takes precedence over WithBloomFilter.
+// The filter is ignored by ReadWrite blockstores.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func UseBloomFilter(bloom *index.Bloom) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreBloom = bloom
+	}
+}
+
 // NewReadOnly creates a new ReadOnly blockstore from the backing with a optional index as idx.
 // This function accepts both CARv1 and CARv2 backing.
 // The blockstore is instantiated with the given index if it is not nil.
@@ -93,10 +350,34 @@ func UseWholeCIDs(enable bool) carv2.Option {
 // * For a CARv1 backing an index is generated.
 // * For a CARv2 backing an index is only generated if Header.HasIndex returns false.
 //
-// There is no need to call ReadOnly.Close on instances returned by this function.
+// If enabled via WithGzipBacking or WithGzipBackingTempFile, a backing that is compressed as a whole
+// with gzip is decompressed before it is read.
+//
+// There is no need to call ReadOnly.Close on instances returned by this function, unless the
+// backing is decompressed into a temporary file via WithGzipBackingTempFile.
 func NewReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*ReadOnly, error) {
+	if o := carv2.ApplyOptions(opts...); o.BlockstoreGzipBacking {
+		gunzipped, closer, err := gunzipBacking(backing, o)
+		if err != nil {
+			return nil, err
+		}
+		b, err := newReadOnly(gunzipped, idx, opts...)
+		if closer != nil {
+			if err != nil {
+				_ = closer.Close()
+				return nil, err
+			}
+			b.carv2Closer = closer
+		}
+		return b, err
+	}
+	return newReadOnly(backing, idx, opts...)
+}
+
+func newReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*ReadOnly, error) {
 	b := &ReadOnly{
 		opts: carv2.ApplyOptions(opts...),
+		done: make(chan struct{}),
 	}
 
 	version, err := readVersion(backing, opts...)
@@ -111,21 +392,23 @@ func NewReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*R
 			}
 		}
 		b.backing = backing
+		if bb, ok := backing.(bytesBacking); ok {
+			b.data = bb.Bytes()
+		}
 		b.idx = idx
+		if err := b.initBloom(); err != nil {
+			return nil, err
+		}
 		return b, nil
-	case 2:
+	default:
+		// Any accepted version other than 1 is read as CARv2.
 		v2r, err := carv2.NewReader(backing, opts...)
 		if err != nil {
 			return nil, err
 		}
 		if idx == nil {
 			if v2r.Header.HasIndex() {
-				ir, err := v2r.IndexReader()
-				if err != nil {
-					return nil, err
-				}
-				idx, err = index.ReadFrom(ir)
-				if err != nil {
+				if idx, err = readEmbeddedIndex(backing, v2r, b.opts); err != nil {
 					return nil, err
 				}
 			} else {
@@ -142,10 +425,95 @@ func NewReadOnly(backing io.ReaderAt, idx index.Index, opts ...carv2.Option) (*R
 		if err != nil {
 			return nil, err
 		}
+		if bb, ok := backing.(bytesBacking); ok {
+			data := bb.Bytes()
+			dataEnd := v2r.Header.DataOffset + v2r.Header.DataSize
+			if dataEnd >= v2r.Header.DataOffset && dataEnd <= uint64(len(data)) {
+				b.data = data[v2r.Header.DataOffset:dataEnd]
+			}
+		}
 		b.idx = idx
+		if err := b.initBloom(); err != nil {
+			return nil, err
+		}
 		return b, nil
+	}
+}
+
+// initBloom sets the bloom filter of the blockstore as configured by UseBloomFilter or
+// WithBloomFilter, if any, building it over the index in the latter case.
+func (b *ReadOnly) initBloom() error {
+	if b.opts.BlockstoreBloom != nil {
+		b.bloom = b.opts.BlockstoreBloom
+		return nil
+	}
+	if b.opts.BlockstoreBloomFPRate == 0 {
+		return nil
+	}
+	iterable, ok := b.idx.(index.IterableIndex)
+	if !ok {
+		return fmt.Errorf("cannot build bloom filter over index with codec %s, which is not iterable", b.idx.Codec())
+	}
+	bloom, err := index.BuildBloom(iterable, b.opts.BlockstoreBloomFPRate)
+	if err != nil {
+		return err
+	}
+	b.bloom = bloom
+	return nil
+}
+
+// readEmbeddedIndex reads the index embedded in the CARv2 backing. If the index codec is supported,
+// and either UseMmapIndex is enabled or the index is larger than the threshold set via
+// UseMmapIndexAbove, the index is opened via index.OpenMmap instead of being read into memory.
+func readEmbeddedIndex(backing io.ReaderAt, v2r *carv2.Reader, opts carv2.Options) (index.Index, error) {
+	ir, err := v2r.IndexReader()
+	if err != nil {
+		return nil, err
+	}
+	size, ok := readerAtSize(backing)
+	if !ok {
+		return index.ReadFrom(ir)
+	}
+	// Note that the index need not follow the data payload; see carv2.Header.IndexSize.
+	indexOffset := int64(v2r.Header.IndexOffset)
+	indexSize := v2r.Header.IndexSize(size)
+	if !opts.BlockstoreMmapIndex && uint64(indexSize) <= opts.BlockstoreMmapIndexThreshold {
+		return index.ReadFrom(ir)
+	}
+	codec, err := index.ReadCodec(ir)
+	if err != nil {
+		return nil, err
+	}
+	switch codec {
+	case multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, index.CarMultihashIndexHashed, index.CarIndexChecksummed:
+		return index.OpenMmap(io.NewSectionReader(backing, indexOffset, indexSize), indexSize)
+	default:
+		idx, err := index.New(codec)
+		if err != nil {
+			return nil, err
+		}
+		if err := idx.Unmarshal(ir); err != nil {
+			return nil, err
+		}
+		return idx, nil
+	}
+}
+
+// readerAtSize returns the size of r, if it can be determined.
+func readerAtSize(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case interface{ Stat() (os.FileInfo, error) }:
+		stat, err := r.Stat()
+		if err != nil {
+			return 0, false
+		}
+		return stat.Size(), true
 	default:
-		return nil, fmt.Errorf("unsupported car version: %v", version)
+		return 0, false
 	}
 }
 
@@ -181,34 +549,57 @@ func generateIndex(at io.ReaderAt, opts ...carv2.Option) (index.Index, error) {
 		}
 	}
 
-	// Note, we do not set any write options so that all write options fall back onto defaults.
+	// Note, the index is generated in the codec set via car.UseIndexCodec, if any.
 	return carv2.GenerateIndex(rs, opts...)
 }
 
 // OpenReadOnly opens a read-only blockstore from a CAR file (either v1 or v2), generating an index if it does not exist.
 // Note, the generated index if the index does not exist is ephemeral and only stored in memory.
+// It is generated in the codec set via car.UseIndexCodec, if any.
 // See car.GenerateIndex and Index.Attach for persisting index onto a CAR file.
 func OpenReadOnly(path string, opts ...carv2.Option) (*ReadOnly, error) {
-	f, err := mmap.Open(path)
+	f, err := openBacking(path, carv2.ApplyOptions(opts...).BlockstoreDisableMmap)
 	if err != nil {
 		return nil, err
 	}
 
 	robs, err := NewReadOnly(f, nil, opts...)
 	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
-	robs.carv2Closer = f
+	if robs.carv2Closer != nil {
+		// The file was decompressed into a temporary file, which is read from instead.
+		_ = f.Close()
+	} else {
+		robs.carv2Closer = f
+	}
 
 	return robs, nil
 }
 
+// mmapOpen memory-maps the file at the given path; it is a variable so that tests can simulate
+// memory-mapping failures.
+var mmapOpen = mmapFile
+
+// openBacking opens the file at the given path for reading, memory-mapped unless disableMmap is
+// true. If memory-mapping fails, e.g. because the filesystem does not support it, the file is
+// opened as a regular file instead.
+func openBacking(path string, disableMmap bool) (readerAtCloser, error) {
+	if !disableMmap {
+		if f, err := mmapOpen(path); err == nil {
+			return f, nil
+		}
+	}
+	return os.Open(path)
+}
+
 func (b *ReadOnly) readBlock(idx int64) (cid.Cid, []byte, error) {
 	r, err := internalio.NewOffsetReadSeeker(b.backing, idx)
 	if err != nil {
 		return cid.Cid{}, nil, err
 	}
-	return util.ReadNode(r, b.opts.ZeroLengthSectionAsEOF, b.opts.MaxAllowedSectionSize)
+	return util.ReadNode(r, b.opts.ZeroLengthSectionAsEOF, b.opts.SkipNullPadding, b.opts.LenientVarints, b.opts.MaxAllowedSectionSize)
 }
 
 // DeleteBlock is unsupported and always errors.
@@ -219,6 +610,14 @@ func (b *ReadOnly) DeleteBlock(_ context.Context, _ cid.Cid) error {
 // Has indicates if the store contains a block that corresponds to the given key.
 // This function always returns true for any given key with multihash.IDENTITY code.
 func (b *ReadOnly) Has(ctx context.Context, key cid.Cid) (bool, error) {
+	has, err := b.has(ctx, key)
+	if hook := b.opts.BlockstoreHasHook; hook != nil {
+		hook(key, has, err)
+	}
+	return has, err
+}
+
+func (b *ReadOnly) has(ctx context.Context, key cid.Cid) (bool, error) {
 	// Check if the given CID has multihash.IDENTITY code
 	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
 	if _, ok, err := isIdentity(key); err != nil {
@@ -233,32 +632,23 @@ func (b *ReadOnly) Has(ctx context.Context, key cid.Cid) (bool, error) {
 	if b.closed {
 		return false, errClosed
 	}
+	return b.hasWithoutMutex(ctx, key)
+}
 
+// hasWithoutMutex checks whether the block corresponding to the given non-identity key is present.
+// It must be called with b.mu held.
+func (b *ReadOnly) hasWithoutMutex(ctx context.Context, key cid.Cid) (bool, error) {
 	var fnFound bool
 	var fnErr error
-	err := b.idx.GetAll(key, func(offset uint64) bool {
-		uar, err := internalio.NewOffsetReadSeeker(b.backing, int64(offset))
+	err := b.getAll(ctx, key, func(offset uint64) bool {
+		sh, err := b.readSectionHeader(offset)
 		if err != nil {
 			fnErr = err
 			return false
 		}
-		_, err = varint.ReadUvarint(uar)
-		if err != nil {
-			fnErr = err
-			return false
-		}
-		_, readCid, err := cid.CidFromReader(uar)
-		if err != nil {
-			fnErr = err
-			return false
-		}
-		if b.opts.BlockstoreUseWholeCIDs {
-			fnFound = readCid.Equals(key)
-			return !fnFound // continue looking if we haven't found it
-		} else {
-			fnFound = bytes.Equal(readCid.Hash(), key.Hash())
-			return false
-		}
+		var more bool
+		fnFound, more = b.matchesKey(sh.cid, key)
+		return more
 	})
 	if errors.Is(err, index.ErrNotFound) {
 		return false, nil
@@ -270,7 +660,25 @@ func (b *ReadOnly) Has(ctx context.Context, key cid.Cid) (bool, error) {
 
 // Get gets a block corresponding to the given key.
 // This API will always return true if the given key has multihash.IDENTITY code.
+//
+// Errors returned by the index other than index.ErrNotFound are returned as is, and an
+// index.ErrRecordOutOfBounds is returned if the index points at a section past the end of the data
+// payload; the same holds for the other lookups of ReadOnly. Likewise, lookups via Get, GetSize,
+// Has and View are aborted once ctx is done, including any scan of the data payload due to
+// WithVerifyOnGet, in which case the context error is returned.
 func (b *ReadOnly) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
+	blk, err := b.get(ctx, key)
+	if hook := b.opts.BlockstoreGetHook; hook != nil {
+		var size int
+		if err == nil {
+			size = len(blk.RawData())
+		}
+		hook(key, size, err)
+	}
+	return blk, err
+}
+
+func (b *ReadOnly) get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
 	// Check if the given CID has multihash.IDENTITY code
 	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
 	if digest, ok, err := isIdentity(key); err != nil {
@@ -288,30 +696,29 @@ func (b *ReadOnly) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
 
 	var fnData []byte
 	var fnErr error
-	err := b.idx.GetAll(key, func(offset uint64) bool {
+	var indexedOffset uint64
+	var looked bool
+	err := b.getAll(ctx, key, func(offset uint64) bool {
+		if !looked {
+			indexedOffset, looked = offset, true
+		}
 		readCid, data, err := b.readBlock(int64(offset))
 		if err != nil {
-			fnErr = err
+			fnErr = recordReadError(offset, err)
 			return false
 		}
-		if b.opts.BlockstoreUseWholeCIDs {
-			if readCid.Equals(key) {
-				fnData = data
-				return false
-			} else {
-				return true // continue looking
-			}
-		} else {
-			if bytes.Equal(readCid.Hash(), key.Hash()) {
-				fnData = data
-			}
-			return false
+		found, more := b.matchesKey(readCid, key)
+		if found {
+			fnData = data
 		}
+		return more
 	})
 	if errors.Is(err, index.ErrNotFound) {
 		return nil, format.ErrNotFound{Cid: key}
 	} else if err != nil {
-		return nil, format.ErrNotFound{Cid: key}
+		return nil, err
+	} else if fnData == nil && b.opts.BlockstoreVerifyOnGet {
+		return b.getByScan(ctx, key, indexedOffset)
 	} else if fnErr != nil {
 		return nil, fnErr
 	}
@@ -321,6 +728,31 @@ func (b *ReadOnly) Get(ctx context.Context, key cid.Cid) (blocks.Block, error) {
 	return blocks.NewBlockWithCid(fnData, key)
 }
 
+// getByScan gets the block corresponding to the given key by scanning the data payload, after
+// looking it up at the given offset in the index failed. See WithVerifyOnGet.
+// It must be called with b.mu held.
+func (b *ReadOnly) getByScan(ctx context.Context, key cid.Cid, indexedOffset uint64) (blocks.Block, error) {
+	var foundData []byte
+	var foundOffset uint64
+	err := b.eachBlock(ctx, true, func(c cid.Cid, data []byte, offset uint64) error {
+		if found, _ := b.matchesKey(c, key); found {
+			foundData, foundOffset = data, offset
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	if hook := b.opts.BlockstoreIndexMismatchHook; hook != nil {
+		hook(key, indexedOffset, foundOffset, foundData != nil)
+	}
+	if foundData == nil {
+		return nil, format.ErrNotFound{Cid: key}
+	}
+	return blocks.NewBlockWithCid(foundData, key)
+}
+
 // GetSize gets the size of an item corresponding to the given key.
 func (b *ReadOnly) GetSize(ctx context.Context, key cid.Cid) (int, error) {
 	// Check if the given CID has multihash.IDENTITY code
@@ -338,37 +770,35 @@ func (b *ReadOnly) GetSize(ctx context.Context, key cid.Cid) (int, error) {
 		return 0, errClosed
 	}
 
-	fnSize := -1
-	var fnErr error
-	err := b.idx.GetAll(key, func(offset uint64) bool {
-		rdr, err := internalio.NewOffsetReadSeeker(b.backing, int64(offset))
-		if err != nil {
-			fnErr = err
-			return false
+	// Use the size recorded in the index if available to avoid reading the payload.
+	// This is only possible when matching blocks by multihash, since the size of a block is
+	// determined by its multihash, whereas matching by CID or codec would require reading the CID
+	// on file.
+	if sidx, ok := b.idx.(index.SizedIndex); ok && !b.opts.BlockstoreUseWholeCIDs && !b.opts.BlockstoreStrictCodecMatch {
+		size, known, err := sidx.GetSize(key)
+		if errors.Is(err, index.ErrNotFound) {
+			return -1, format.ErrNotFound{Cid: key}
+		} else if err != nil {
+			return -1, err
 		}
-		sectionLen, err := varint.ReadUvarint(rdr)
-		if err != nil {
-			fnErr = err
-			return false
+		if known {
+			return int(size), nil
 		}
-		cidLen, readCid, err := cid.CidFromReader(rdr)
+	}
+
+	fnSize := -1
+	var fnErr error
+	err := b.getAll(ctx, key, func(offset uint64) bool {
+		sh, err := b.readSectionHeader(offset)
 		if err != nil {
 			fnErr = err
 			return false
 		}
-		if b.opts.BlockstoreUseWholeCIDs {
-			if readCid.Equals(key) {
-				fnSize = int(sectionLen) - cidLen
-				return false
-			} else {
-				return true // continue looking
-			}
-		} else {
-			if bytes.Equal(readCid.Hash(), key.Hash()) {
-				fnSize = int(sectionLen) - cidLen
-			}
-			return false
+		found, more := b.matchesKey(sh.cid, key)
+		if found {
+			fnSize = int(sh.dataLen())
 		}
+		return more
 	})
 	if errors.Is(err, index.ErrNotFound) {
 		return -1, format.ErrNotFound{Cid: key}
@@ -383,38 +813,547 @@ func (b *ReadOnly) GetSize(ctx context.Context, key cid.Cid) (int, error) {
 	return fnSize, nil
 }
 
-func isIdentity(key cid.Cid) (digest []byte, ok bool, err error) {
-	dmh, err := multihash.Decode(key.Hash())
+// HasMultihash indicates if the store contains a block with the given multihash, regardless of
+// the codec or version of its CID. This avoids wrapping a multihash in a CID for lookups where only
+// the multihash is known, e.g. when serving bitswap requests.
+// This function always returns true for any given multihash with multihash.IDENTITY code.
+//
+// Note that when UseWholeCIDs is enabled, blocks with the same multihash but distinct CID codecs
+// cannot be told apart by their multihash; HasMultihash returns true if any of them is present.
+// If the index records whole CIDs, i.e. is in the index.CarCidIndexSorted codec, its records are
+// keyed by multihash once, on the first lookup by multihash, e.g. via HasMultihash or
+// GetByMultihash, which takes time and memory linear in the number of records.
+func (b *ReadOnly) HasMultihash(mh multihash.Multihash) (bool, error) {
+	dmh, err := multihash.Decode(mh)
 	if err != nil {
-		return nil, false, err
+		return false, err
+	}
+	if dmh.Code == multihash.IDENTITY {
+		return true, nil
 	}
-	ok = dmh.Code == multihash.IDENTITY
-	digest = dmh.Digest
-	return digest, ok, nil
-}
-
-// Put is not supported and always returns an error.
-func (b *ReadOnly) Put(context.Context, blocks.Block) error {
-	return errReadOnly
-}
 
-// PutMany is not supported and always returns an error.
-func (b *ReadOnly) PutMany(context.Context, []blocks.Block) error {
-	return errReadOnly
-}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 
-// WithAsyncErrorHandler returns a context with async error handling set to the given errHandler.
-// Any errors that occur during asynchronous operations of AllKeysChan will be passed to the given
-// handler.
-func WithAsyncErrorHandler(ctx context.Context, errHandler func(error)) context.Context {
-	return context.WithValue(ctx, asyncErrHandlerKey, errHandler)
-}
+	if b.closed {
+		return false, errClosed
+	}
 
-// AllKeysChan returns the list of keys in the CAR data payload.
+	var fnFound bool
+	var fnErr error
+	err = b.getAllMultihash(mh, func(offset uint64) bool {
+		sh, err := b.readSectionHeader(offset)
+		if err != nil {
+			fnErr = err
+			return false
+		}
+		fnFound = bytes.Equal(sh.cid.Hash(), mh)
+		return !fnFound
+	})
+	if errors.Is(err, index.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return fnFound, fnErr
+}
+
+// GetSizeMultihash gets the size of the block with the given multihash, regardless of the codec or
+// version of its CID. The size recorded in the index is used if available, avoiding reading the
+// data payload.
+// The size of any given multihash with multihash.IDENTITY code is the size of its digest.
+//
+// Note that when UseWholeCIDs is enabled, blocks with the same multihash but distinct CID codecs
+// cannot be told apart by their multihash; the size of the first one found is returned. See
+// HasMultihash for the cost of the first lookup if the index records whole CIDs.
+func (b *ReadOnly) GetSizeMultihash(mh multihash.Multihash) (int, error) {
+	dmh, err := multihash.Decode(mh)
+	if err != nil {
+		return -1, err
+	}
+	if dmh.Code == multihash.IDENTITY {
+		return len(dmh.Digest), nil
+	}
+	// The multihash is wrapped in a CID only to look up its recorded size and to report it as not
+	// found, since neither depends on the CID codec.
+	key := cid.NewCidV1(cid.Raw, mh)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return -1, errClosed
+	}
+
+	if _, ok := b.idx.(*index.CidIndexSorted); !ok {
+		if sidx, ok := b.idx.(index.SizedIndex); ok {
+			size, known, err := sidx.GetSize(key)
+			if errors.Is(err, index.ErrNotFound) {
+				return -1, format.ErrNotFound{Cid: key}
+			} else if err != nil {
+				return -1, err
+			}
+			if known {
+				return int(size), nil
+			}
+		}
+	}
+
+	fnSize := -1
+	var fnErr error
+	err = b.getAllMultihash(mh, func(offset uint64) bool {
+		sh, err := b.readSectionHeader(offset)
+		if err != nil {
+			fnErr = err
+			return false
+		}
+		if bytes.Equal(sh.cid.Hash(), mh) {
+			fnSize = int(sh.dataLen())
+			return false
+		}
+		return true
+	})
+	if errors.Is(err, index.ErrNotFound) {
+		return -1, format.ErrNotFound{Cid: key}
+	} else if err != nil {
+		return -1, err
+	} else if fnErr != nil {
+		return -1, fnErr
+	}
+	if fnSize == -1 {
+		return -1, format.ErrNotFound{Cid: key}
+	}
+	return fnSize, nil
+}
+
+// GetByMultihash gets the first block with the given multihash, regardless of the codec or version
+// of its CID, returning the CID as it is stored in the data payload along with the block data.
+// This allows callers that only know the multihash of a block, e.g. bitswap servers receiving
+// wantlists keyed by multihash, to learn the codec of the block.
+// See GetAllByMultihash for getting every block with the given multihash.
+//
+// Since blocks with multihash.IDENTITY code are not stored, the CID returned for them is a CIDv1
+// with the raw codec, along with the digest as data.
+func (b *ReadOnly) GetByMultihash(mh multihash.Multihash) (cid.Cid, []byte, error) {
+	var foundCid cid.Cid
+	var foundData []byte
+	err := b.getByMultihash(mh, func(c cid.Cid, data []byte) bool {
+		foundCid, foundData = c, data
+		return false
+	})
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return foundCid, foundData, nil
+}
+
+// GetAllByMultihash gets every block with the given multihash, regardless of the codec or version
+// of its CID, in the order in which they are found in the index. The CID of each returned block is
+// as it is stored in the data payload, such that blocks with the same multihash but different
+// codecs are told apart. Blocks with the same CID stored more than once are returned once.
+// See GetByMultihash.
+func (b *ReadOnly) GetAllByMultihash(mh multihash.Multihash) ([]blocks.Block, error) {
+	var found []blocks.Block
+	err := b.getByMultihash(mh, func(c cid.Cid, data []byte) bool {
+		for _, blk := range found {
+			if blk.Cid().Equals(c) {
+				return true
+			}
+		}
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err == nil {
+			found = append(found, blk)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// Offsets returns the offsets of the sections recorded in the index for the given key, relative to
+// the beginning of the data payload, in the order in which they are found in the index. Sections
+// are matched as the index matches them, e.g. by multihash for the default index codec, without
+// reading them, and the number of records looked at is bounded by WithMaxDuplicateLookups.
+// index.ErrNotFound is returned as is if the key isn't indexed.
+// See ForEachOffset.
+func (b *ReadOnly) Offsets(key cid.Cid) ([]uint64, error) {
+	var offsets []uint64
+	err := b.ForEachOffset(context.Background(), key, 0, func(offset uint64) error {
+		offsets = append(offsets, offset)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// ForEachOffset calls f with the offset of each section recorded in the index for the given key,
+// just like Offsets. Iteration stops once f has been called limit times, where zero means no
+// limit, at the first error returned by f, or once ctx is done, in which case the context error
+// is returned. index.ErrNotFound is returned as is if the key isn't indexed.
+func (b *ReadOnly) ForEachOffset(ctx context.Context, key cid.Cid, limit uint64, f func(offset uint64) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return errClosed
+	}
+
+	var calls uint64
+	var fErr error
+	err := b.getAll(ctx, key, func(offset uint64) bool {
+		if fErr = ctx.Err(); fErr != nil {
+			return false
+		}
+		if fErr = f(offset); fErr != nil {
+			return false
+		}
+		calls++
+		return limit == 0 || calls < limit
+	})
+	if fErr != nil {
+		return fErr
+	}
+	return err
+}
+
+// getByMultihash calls fn with the CID and data of every section with the given multihash, until
+// fn returns false. format.ErrNotFound is returned if no section is found.
+func (b *ReadOnly) getByMultihash(mh multihash.Multihash, fn func(c cid.Cid, data []byte) bool) error {
+	dmh, err := multihash.Decode(mh)
+	if err != nil {
+		return err
+	}
+	key := cid.NewCidV1(cid.Raw, mh)
+	if dmh.Code == multihash.IDENTITY {
+		fn(key, dmh.Digest)
+		return nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return errClosed
+	}
+
+	var any bool
+	var fnErr error
+	err = b.getAllMultihash(mh, func(offset uint64) bool {
+		readCid, data, err := b.readBlock(int64(offset))
+		if err != nil {
+			fnErr = recordReadError(offset, err)
+			return false
+		}
+		if !bytes.Equal(readCid.Hash(), mh) {
+			return true
+		}
+		any = true
+		return fn(readCid, data)
+	})
+	if errors.Is(err, index.ErrNotFound) {
+		return format.ErrNotFound{Cid: key}
+	} else if err != nil {
+		return err
+	} else if fnErr != nil {
+		return fnErr
+	}
+	if !any {
+		return format.ErrNotFound{Cid: key}
+	}
+	return nil
+}
+
+// View calls callback with the raw data of the block corresponding to the given key, satisfying
+// the blockstore.Viewer interface.
+// The callback is only called if the block is found; otherwise format.ErrNotFound is returned.
+// Any error returned by callback is propagated as is.
+//
+// The byte slice passed to callback must not be modified or retained after callback returns, as it
+// may be backed by a memory-mapped region or a pooled buffer. When the backing is memory-mapped,
+// e.g. as opened by OpenReadOnly on Linux and macOS, block data is passed without being copied,
+// and the blockstore must not be closed by callback. Otherwise, block data is copied and callback
+// may use the blockstore freely.
+// This API will always succeed if the given key has multihash.IDENTITY code, passing its digest to
+// callback.
+func (b *ReadOnly) View(ctx context.Context, key cid.Cid, callback func([]byte) error) error {
+	hook := b.opts.BlockstoreGetHook
+	if hook == nil {
+		return b.view(ctx, key, callback)
+	}
+	var size int
+	err := b.view(ctx, key, func(data []byte) error {
+		size = len(data)
+		return callback(data)
+	})
+	if err != nil {
+		size = 0
+	}
+	hook(key, size, err)
+	return err
+}
+
+func (b *ReadOnly) view(ctx context.Context, key cid.Cid, callback func([]byte) error) error {
+	// Check if the given CID has multihash.IDENTITY code
+	// Note, we do this without locking, since there is no shared information to lock for in order to perform the check.
+	if digest, ok, err := isIdentity(key); err != nil {
+		return err
+	} else if ok {
+		return callback(digest)
+	}
+
+	b.mu.RLock()
+	locked := true
+	defer func() {
+		if locked {
+			b.mu.RUnlock()
+		}
+	}()
+
+	if b.closed {
+		return errClosed
+	}
+
+	var found *sectionHeader
+	var fnErr error
+	err := b.getAll(ctx, key, func(offset uint64) bool {
+		sh, err := b.readSectionHeader(offset)
+		if err != nil {
+			fnErr = err
+			return false
+		}
+		if sh.length > b.opts.MaxAllowedSectionSize {
+			fnErr = util.ErrSectionTooLarge
+			return false
+		}
+		matched, more := b.matchesKey(sh.cid, key)
+		if matched {
+			found = &sh
+		}
+		return more
+	})
+	if errors.Is(err, index.ErrNotFound) {
+		return format.ErrNotFound{Cid: key}
+	} else if err != nil {
+		return err
+	} else if fnErr != nil {
+		return fnErr
+	}
+	if found == nil {
+		return format.ErrNotFound{Cid: key}
+	}
+
+	// Avoid copying the block data when the bytes of the data payload are exposed directly. The
+	// lock is held while callback runs, since the bytes are only valid until the blockstore is
+	// closed.
+	start, end := found.dataOffset(), found.dataOffset()+found.dataLen()
+	if b.data != nil {
+		if end < start || end > uint64(len(b.data)) {
+			return recordReadError(found.offset, io.ErrUnexpectedEOF)
+		}
+		return callback(b.data[start:end])
+	}
+
+	bufp := viewBufPool.Get().(*[]byte)
+	defer viewBufPool.Put(bufp)
+	if uint64(cap(*bufp)) < found.dataLen() {
+		*bufp = make([]byte, found.dataLen())
+	}
+	buf := (*bufp)[:found.dataLen()]
+	if n, err := b.backing.ReadAt(buf, int64(start)); n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return recordReadError(found.offset, err)
+	}
+	// The data is copied, hence the lock is released before calling callback, such that callback
+	// may use the blockstore, e.g. to put blocks to a ReadWrite blockstore.
+	b.mu.RUnlock()
+	locked = false
+	return callback(buf)
+}
+
+// sectionHeader describes the section length and CID that start a section of the data payload.
+type sectionHeader struct {
+	// offset is the offset of the section, relative to the beginning of the data payload.
+	offset uint64
+	// length is the length of the section, i.e. the length of the CID and block data.
+	length uint64
+	// lengthLen is the number of bytes spanned by the varint encoding of length, which may be
+	// larger than its minimal encoding if varints are read leniently.
+	lengthLen int
+	// cidLen is the number of bytes spanned by cid.
+	cidLen int
+	cid    cid.Cid
+}
+
+// dataOffset returns the offset of the block data of the section.
+func (sh sectionHeader) dataOffset() uint64 {
+	return sh.offset + uint64(sh.lengthLen) + uint64(sh.cidLen)
+}
+
+// dataLen returns the length of the block data of the section.
+func (sh sectionHeader) dataLen() uint64 {
+	return sh.length - uint64(sh.cidLen)
+}
+
+// readSectionHeader reads the header of the section at the given offset recorded by the index,
+// without reading its block data. Errors are returned as by recordReadError.
+func (b *ReadOnly) readSectionHeader(offset uint64) (sectionHeader, error) {
+	rdr, err := internalio.NewOffsetReadSeeker(b.backing, int64(offset))
+	if err != nil {
+		return sectionHeader{}, recordReadError(offset, err)
+	}
+	length, lengthLen, err := util.ReadUvarint(rdr, b.opts.LenientVarints)
+	if err != nil {
+		return sectionHeader{}, recordReadError(offset, err)
+	}
+	cidLen, c, err := cid.CidFromReader(rdr)
+	if err != nil {
+		return sectionHeader{}, recordReadError(offset, err)
+	}
+	if uint64(cidLen) > length {
+		return sectionHeader{}, errors.New("section length shorter than CID length")
+	}
+	return sectionHeader{offset: offset, length: length, lengthLen: lengthLen, cidLen: cidLen, cid: c}, nil
+}
+
+// recordReadError returns the given error, encountered while reading the section at the given
+// offset recorded by the index, as an index.ErrRecordOutOfBounds if it signals that the section
+// lies past the end of the data payload.
+func recordReadError(offset uint64, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &index.ErrRecordOutOfBounds{Offset: offset, Err: err}
+	}
+	return err
+}
+
+// getAll calls fn with the offsets of the index records for the given key, just like
+// index.Index.GetAll, but stops with a car.ErrTooManyDuplicateLookups error once fn asks for more
+// records than the configured maximum, and with the context error once ctx is done.
+func (b *ReadOnly) getAll(ctx context.Context, key cid.Cid, fn func(uint64) bool) error {
+	return b.getAllFrom(ctx, b.idx, key, fn)
+}
+
+// getAllFrom is like getAll, except that it looks up the given index, which must hold the same
+// records as b.idx.
+func (b *ReadOnly) getAllFrom(ctx context.Context, idx index.Index, key cid.Cid, fn func(uint64) bool) error {
+	if b.bloom != nil && !b.bloom.Has(key.Hash()) {
+		return index.ErrNotFound
+	}
+	var lookups uint64
+	var limitErr error
+	err := index.GetAllContext(ctx, idx, key, func(offset uint64) bool {
+		if lookups == b.opts.BlockstoreMaxDuplicateLookups {
+			limitErr = &carv2.ErrTooManyDuplicateLookups{Cid: key, MaxLookups: lookups}
+			return false
+		}
+		lookups++
+		return fn(offset)
+	})
+	if limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+// getAllMultihash calls fn for the offset of every index record that may correspond to the given
+// multihash, regardless of the codec or version of the CID it was recorded with. Indices that
+// record whole CIDs cannot be looked up by multihash alone, and are looked up via multihashIndex
+// instead. It must be called with b.mu held.
+func (b *ReadOnly) getAllMultihash(mh multihash.Multihash, fn func(uint64) bool) error {
+	key := cid.NewCidV1(cid.Raw, mh)
+	if _, ok := b.idx.(*index.CidIndexSorted); !ok {
+		return b.getAll(context.Background(), key, fn)
+	}
+	mhIdx, err := b.multihashIndex()
+	if err != nil {
+		return err
+	}
+	return b.getAllFrom(context.Background(), mhIdx, key, fn)
+}
+
+// multihashIndex returns the records of b.idx keyed by multihash alone, which are loaded into an
+// index.MultihashIndexSorted on first use and kept for the lifetime of the blockstore. It must only
+// be called if b.idx is an index.CidIndexSorted.
+func (b *ReadOnly) multihashIndex() (index.Index, error) {
+	b.mhIdxOnce.Do(func() {
+		var records []index.Record
+		b.mhIdxErr = b.idx.(*index.CidIndexSorted).ForEachCid(func(key cid.Cid, offset uint64) error {
+			records = append(records, index.Record{Cid: key, Offset: offset})
+			return nil
+		})
+		if b.mhIdxErr != nil {
+			return
+		}
+		mhIdx := index.NewMultihashSorted()
+		if b.mhIdxErr = mhIdx.Load(records); b.mhIdxErr == nil {
+			b.mhIdx = mhIdx
+		}
+	})
+	return b.mhIdx, b.mhIdxErr
+}
+
+// matchesKey reports whether the CID read from a section on file matches the given key, and whether
+// any further index records for the key should be looked at. Blocks are matched by whole CID if
+// UseWholeCIDs is enabled, by multihash and codec if WithStrictCodecMatch is enabled, and by
+// multihash otherwise.
+func (b *ReadOnly) matchesKey(readCid, key cid.Cid) (found bool, more bool) {
+	switch {
+	case b.opts.BlockstoreUseWholeCIDs:
+		found = readCid.Equals(key)
+	case b.opts.BlockstoreStrictCodecMatch:
+		found = readCid.Prefix().Codec == key.Prefix().Codec && bytes.Equal(readCid.Hash(), key.Hash())
+	default:
+		// Records are only ever looked up by multihash; stop at the first one.
+		return bytes.Equal(readCid.Hash(), key.Hash()), false
+	}
+	// Continue looking if we haven't found it.
+	return found, !found
+}
+
+func isIdentity(key cid.Cid) (digest []byte, ok bool, err error) {
+	dmh, err := multihash.Decode(key.Hash())
+	if err != nil {
+		return nil, false, err
+	}
+	ok = dmh.Code == multihash.IDENTITY
+	digest = dmh.Digest
+	return digest, ok, nil
+}
+
+// Put is not supported and always returns an error.
+func (b *ReadOnly) Put(context.Context, blocks.Block) error {
+	return errReadOnly
+}
+
+// PutMany is not supported and always returns an error.
+func (b *ReadOnly) PutMany(context.Context, []blocks.Block) error {
+	return errReadOnly
+}
+
+// WithAsyncErrorHandler returns a context with async error handling set to the given errHandler.
+// Any errors that occur during asynchronous operations of AllKeysChan will be passed to the given
+// handler.
+func WithAsyncErrorHandler(ctx context.Context, errHandler func(error)) context.Context {
+	return context.WithValue(ctx, asyncErrHandlerKey, errHandler)
+}
+
+// AllKeysChan returns the list of keys in the CAR data payload.
 // If the ctx is constructed using WithAsyncErrorHandler any errors that occur during asynchronous
 // retrieval of CIDs will be passed to the error handler function set in context.
 // Otherwise, errors will terminate the asynchronous operation silently.
 //
+// If UseWholeCIDs is enabled and the blockstore index is an index.CidIndexSorted, the keys are
+// served directly from the index in index order, rather than by reading the data payload.
+//
 // See WithAsyncErrorHandler
 func (b *ReadOnly) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 	// We release the lock when the channel-sending goroutine stops.
@@ -428,83 +1367,358 @@ func (b *ReadOnly) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 		return nil, errClosed
 	}
 
+	// When using whole CIDs, an index that stores them can serve the keys without reading the payload.
+	if b.opts.BlockstoreUseWholeCIDs {
+		if idx, ok := b.idx.(*index.CidIndexSorted); ok {
+			return b.allKeysChanFromIndex(ctx, idx), nil
+		}
+	}
+
 	// TODO we may use this walk for populating the index, and we need to be able to iterate keys in this way somewhere for index generation. In general though, when it's asked for all keys from a blockstore with an index, we should iterate through the index when possible rather than linear reads through the full car.
+	rdr, err := b.seekFirstSection()
+	if err != nil {
+		b.mu.RUnlock() // don't hold the mutex forever
+		return nil, err
+	}
+
+	// TODO: document this choice of 5, or use simpler buffering like 0 or 1.
+	ch := make(chan cid.Cid, 5)
+
+	go func() {
+		defer b.mu.RUnlock()
+		defer close(ch)
+
+		err := b.forEachKey(rdr, func(c cid.Cid) error {
+			select {
+			case ch <- c:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-b.done:
+				return errClosed
+			}
+		})
+		if err != nil {
+			maybeReportError(ctx, err)
+		}
+	}()
+	return ch, nil
+}
+
+// seekFirstSection returns a reader over the data payload positioned at its first section, i.e.
+// right after the CARv1 header.
+func (b *ReadOnly) seekFirstSection() (internalio.ReadSeekerAt, error) {
 	rdr, err := internalio.NewOffsetReadSeeker(b.backing, 0)
 	if err != nil {
 		return nil, err
 	}
 	header, err := carv1.ReadHeader(rdr, b.opts.MaxAllowedHeaderSize)
 	if err != nil {
-		b.mu.RUnlock() // don't hold the mutex forever
 		return nil, fmt.Errorf("error reading car header: %w", err)
 	}
 	headerSize, err := carv1.HeaderSize(header)
 	if err != nil {
-		b.mu.RUnlock() // don't hold the mutex forever
 		return nil, err
 	}
-
-	// TODO: document this choice of 5, or use simpler buffering like 0 or 1.
-	ch := make(chan cid.Cid, 5)
-
-	// Seek to the end of header.
 	if _, err = rdr.Seek(int64(headerSize), io.SeekStart); err != nil {
-		b.mu.RUnlock() // don't hold the mutex forever
 		return nil, err
 	}
+	return rdr, nil
+}
 
-	go func() {
-		defer b.mu.RUnlock()
-		defer close(ch)
+// forEachKey calls fn for the CID of every section read from rdr, as returned by
+// seekFirstSection, reading only the CIDs and skipping over the block data. As with AllKeysChan,
+// the CIDs are flattened to the raw codec unless UseWholeCIDs or WithStrictCodecMatch is enabled.
+// Iteration stops at the first error returned by fn, and that error is returned.
+func (b *ReadOnly) forEachKey(rdr internalio.ReadSeekerAt, fn func(c cid.Cid) error) error {
+	for {
+		length, _, err := util.ReadUvarint(rdr, b.opts.LenientVarints)
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			return nil
+		}
 
-		for {
-			length, err := varint.ReadUvarint(rdr)
-			if err != nil {
-				if err != io.EOF {
-					maybeReportError(ctx, err)
-				}
-				return
+		// Null padding; by default it's an error.
+		if length == 0 {
+			if b.opts.SkipNullPadding {
+				continue
+			} else if b.opts.ZeroLengthSectionAsEOF {
+				return nil
+			} else {
+				return errZeroLengthSection
 			}
+		}
 
-			// Null padding; by default it's an error.
-			if length == 0 {
-				if b.opts.ZeroLengthSectionAsEOF {
-					break
-				} else {
-					maybeReportError(ctx, errZeroLengthSection)
-					return
-				}
+		thisItemForNxt, err := rdr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		_, c, err := cid.CidFromReader(rdr)
+		if err != nil {
+			return err
+		}
+		if _, err := rdr.Seek(thisItemForNxt+int64(length), io.SeekStart); err != nil {
+			return err
+		}
+
+		// If we're just using multihashes, flatten to the "raw" codec.
+		if !b.opts.BlockstoreUseWholeCIDs && !b.opts.BlockstoreStrictCodecMatch {
+			c = cid.NewCidV1(cid.Raw, c.Hash())
+		}
+
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+}
+
+// offsetIndex returns the index wrapped such that it resolves offsets, or nil if it is not iterable.
+// The ordering it computes is kept for the lifetime of the blockstore.
+func (b *ReadOnly) offsetIndex() index.OffsetIndex {
+	b.offsetIdxOnce.Do(func() {
+		if iterable, ok := b.idx.(index.IterableIndex); ok {
+			b.offsetIdx = index.WithOffsetLookup(iterable)
+		}
+	})
+	return b.offsetIdx
+}
+
+// CidAt returns the CID of the block whose section contains the given offset, along with the
+// offset of the start of that section. Like the offsets of the index and of EachBlock, offsets are
+// relative to the start of the data payload; for a CARv2 file, the data offset of its header must be
+// subtracted from an offset relative to the start of the file. For example, this allows telling
+// which block is affected by a read error at a given offset, without scanning the data payload.
+//
+// The section is found via the index, which orders its records by offset on the first call unless
+// it satisfies index.OffsetIndex, followed by reading the section at the offset found. Sections that
+// are not indexed, e.g. sections of identity CIDs, are found by reading the sections following the
+// closest indexed one. If the index cannot resolve offsets, i.e. it is not iterable, the data
+// payload is read from its first section onwards instead.
+//
+// An error wrapping index.ErrNotFound is returned if no section contains the offset, e.g. if it is
+// within the CARv1 header, within null padding, or past the end of the data payload.
+func (b *ReadOnly) CidAt(offset uint64) (cid.Cid, uint64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return cid.Undef, 0, errClosed
+	}
+
+	rdr, err := internalio.NewOffsetReadSeeker(b.backing, 0)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if _, err := carv1.ReadHeader(rdr, b.opts.MaxAllowedHeaderSize); err != nil {
+		return cid.Undef, 0, fmt.Errorf("error reading car header: %w", err)
+	}
+	headerEnd, err := rdr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	notFound := fmt.Errorf("%w: no section contains offset %d", index.ErrNotFound, offset)
+	if offset < uint64(headerEnd) {
+		return cid.Undef, 0, notFound
+	}
+
+	// Start reading sections at the closest indexed one, if any.
+	start := uint64(headerEnd)
+	if offsetIdx := b.offsetIndex(); offsetIdx != nil {
+		// Even if the offset is past the end of the section found, the sections following it are
+		// read, since they may not be indexed.
+		r, _, err := offsetIdx.FindByOffset(offset)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		if r.Cid.Defined() && r.Offset > start {
+			start = r.Offset
+		}
+	}
+
+	for {
+		if _, err := rdr.Seek(int64(start), io.SeekStart); err != nil {
+			return cid.Undef, 0, err
+		}
+		length, lengthLen, err := util.ReadUvarint(rdr, b.opts.LenientVarints)
+		if err != nil {
+			if err == io.EOF {
+				return cid.Undef, 0, notFound
+			}
+			return cid.Undef, 0, err
+		}
+		if length == 0 {
+			if !b.opts.SkipNullPadding {
+				return cid.Undef, 0, notFound
+			}
+			// Skip over the zero length, which is part of no section.
+			if start += uint64(lengthLen); start > offset {
+				return cid.Undef, 0, notFound
 			}
+			continue
+		}
+		end := start + uint64(lengthLen) + length
+		if offset >= end {
+			start = end
+			continue
+		}
+		_, c, err := cid.CidFromReader(rdr)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		return c, start, nil
+	}
+}
 
-			thisItemForNxt, err := rdr.Seek(0, io.SeekCurrent)
-			if err != nil {
-				maybeReportError(ctx, err)
-				return
+// EachBlock calls fn for every block in this blockstore, in the order in which the blocks appear
+// in the data payload, along with the offset of their section relative to the start of the data
+// payload. Unlike iterating over AllKeysChan and calling Get for each key, the data payload is read
+// sequentially, one section at a time, without consulting the index.
+//
+// As with AllKeysChan, unless UseWholeCIDs or WithStrictCodecMatch is enabled the CIDs passed to fn
+// are flattened to the raw codec. Zero-length sections are treated according to the
+// ZeroLengthSectionAsEOF and WithSkipNullPadding options.
+//
+// Iteration stops at the first error returned by fn, or once ctx is cancelled, and that error is
+// returned. fn must not call any of the write methods of a ReadWrite blockstore, since the
+// blockstore is read-locked during iteration.
+func (b *ReadOnly) EachBlock(ctx context.Context, fn func(c cid.Cid, data []byte, offset uint64) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return errClosed
+	}
+	return b.eachBlock(ctx, b.opts.BlockstoreUseWholeCIDs || b.opts.BlockstoreStrictCodecMatch, fn)
+}
+
+// Codecs returns the number of blocks in this blockstore by the codec of their CID, e.g. to tell
+// whether a CAR mostly consists of raw leaves or dag-pb nodes. The codecs are read from the CIDs
+// of sections in the data payload, in the same way as EachBlock, and are therefore counted
+// regardless of the index type and of whether UseWholeCIDs is enabled. Duplicate blocks are
+// counted once per section.
+func (b *ReadOnly) Codecs() (map[uint64]int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, errClosed
+	}
+	codecs := make(map[uint64]int)
+	err := b.eachBlock(context.Background(), true, func(c cid.Cid, _ []byte, _ uint64) error {
+		codecs[c.Type()]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codecs, nil
+}
+
+// eachBlock implements EachBlock, and must be called with b.mu read-locked. Unless wholeCIDs is
+// true, the CIDs passed to fn are flattened to the raw codec.
+func (b *ReadOnly) eachBlock(ctx context.Context, wholeCIDs bool, fn func(c cid.Cid, data []byte, offset uint64) error) error {
+	var backing io.ReaderAt = b.backing
+	if b.opts.ReadBufferSize > 0 {
+		backing = internalio.NewPrefetchReaderAt(backing, b.opts.ReadBufferSize)
+	}
+	rdr, err := internalio.NewOffsetReadSeeker(backing, 0)
+	if err != nil {
+		return err
+	}
+	header, err := carv1.ReadHeader(rdr, b.opts.MaxAllowedHeaderSize)
+	if err != nil {
+		return fmt.Errorf("error reading car header: %w", err)
+	}
+	headerSize, err := carv1.HeaderSize(header)
+	if err != nil {
+		return err
+	}
+
+	offset := headerSize
+	if _, err = rdr.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		length, lengthLen, err := util.ReadUvarint(rdr, b.opts.LenientVarints)
+		if err != nil {
+			if err == io.EOF {
+				return nil
 			}
-			_, c, err := cid.CidFromReader(rdr)
-			if err != nil {
-				maybeReportError(ctx, err)
-				return
+			return err
+		}
+
+		// Null padding; by default it's an error.
+		if length == 0 {
+			if b.opts.SkipNullPadding {
+				// Skip over the zero length, which is a single byte unless encoded non-minimally.
+				offset += uint64(lengthLen)
+				continue
 			}
-			if _, err := rdr.Seek(thisItemForNxt+int64(length), io.SeekStart); err != nil {
-				maybeReportError(ctx, err)
-				return
+			if b.opts.ZeroLengthSectionAsEOF {
+				return nil
 			}
+			return errZeroLengthSection
+		}
+		if length > b.opts.MaxAllowedSectionSize {
+			return util.ErrSectionTooLarge
+		}
 
-			// If we're just using multihashes, flatten to the "raw" codec.
-			if !b.opts.BlockstoreUseWholeCIDs {
-				c = cid.NewCidV1(cid.Raw, c.Hash())
+		section := make([]byte, length)
+		if _, err := io.ReadFull(rdr, section); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
 			}
+			return err
+		}
+		n, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			return err
+		}
 
+		// If we're just using multihashes, flatten to the "raw" codec.
+		if !wholeCIDs {
+			c = cid.NewCidV1(cid.Raw, c.Hash())
+		}
+
+		if err := fn(c, section[n:], offset); err != nil {
+			return err
+		}
+		offset += uint64(lengthLen) + length
+	}
+}
+
+// allKeysChanFromIndex returns a channel that is fed the CIDs stored in the given index.
+// It must be called with b.mu read-locked; the lock is released once the sending goroutine stops.
+//
+// Note that only the CIDs present in the index are returned, which excludes identity CIDs unless
+// the index was generated with StoreIdentityCIDs enabled.
+func (b *ReadOnly) allKeysChanFromIndex(ctx context.Context, idx *index.CidIndexSorted) <-chan cid.Cid {
+	ch := make(chan cid.Cid, 5)
+	go func() {
+		defer b.mu.RUnlock()
+		defer close(ch)
+
+		err := idx.ForEachCid(func(c cid.Cid, _ uint64) error {
 			select {
 			case ch <- c:
+				return nil
 			case <-ctx.Done():
-				maybeReportError(ctx, ctx.Err())
-				return
+				return ctx.Err()
+			case <-b.done:
+				return errClosed
 			}
+		})
+		if err != nil {
+			maybeReportError(ctx, err)
 		}
 	}()
-	return ch, nil
+	return ch
 }
 
 // maybeReportError checks if an error handler is present in context associated to the key
@@ -534,18 +1748,123 @@ func (b *ReadOnly) Roots() ([]cid.Cid, error) {
 	return header.Roots, nil
 }
 
+// MissingRoots returns the roots declared in the CAR header whose blocks are not present in this
+// blockstore, in the order in which they are declared. Note that a CAR file is not required to
+// contain the blocks of its roots.
+//
+// Roots with multihash.IDENTITY code are always considered present, consistent with Has.
+// An empty slice is returned if all the roots are present.
+func (b *ReadOnly) MissingRoots() ([]cid.Cid, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return nil, errClosed
+	}
+
+	roots, err := b.Roots()
+	if err != nil {
+		return nil, err
+	}
+	missing := make([]cid.Cid, 0)
+	for _, root := range roots {
+		if _, ok, err := isIdentity(root); err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+		has, err := b.hasWithoutMutex(context.Background(), root)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			missing = append(missing, root)
+		}
+	}
+	return missing, nil
+}
+
+// Len returns the number of records in the index of this blockstore, when the index supports
+// counting its records via index.CountableIndex. An error is returned otherwise.
+//
+// The returned value counts every record in the index, including records for blocks with duplicate
+// CIDs or multihashes. It therefore may be larger than the number of unique keys returned by
+// AllKeysChan. Note that blocks with multihash.IDENTITY code are not indexed unless
+// car.StoreIdentityCIDs is enabled, and are not counted.
+func (b *ReadOnly) Len() (uint64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return 0, errClosed
+	}
+
+	idx, ok := b.idx.(index.CountableIndex)
+	if !ok {
+		return 0, fmt.Errorf("index with codec %s does not support counting records", b.idx.Codec())
+	}
+	return idx.Count()
+}
+
+// IndexMemory returns the estimated heap memory held by the index of this blockstore, when the index
+// supports reporting it via index.MemoryFootprintIndex. An error is returned otherwise, e.g. for
+// indices opened via index.OpenMmap, whose records are not held in memory.
+//
+// The estimate excludes the bloom filter set via UseBloomFilter, if any. It may be exported as a
+// gauge in order to account for the memory cost of keeping many blockstores open at once.
+func (b *ReadOnly) IndexMemory() (uint64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return 0, errClosed
+	}
+
+	idx, ok := b.idx.(index.MemoryFootprintIndex)
+	if !ok {
+		return 0, fmt.Errorf("index with codec %s does not support reporting its memory footprint", b.idx.Codec())
+	}
+	return idx.MemoryFootprint(), nil
+}
+
 // Close closes the underlying reader if it was opened by OpenReadOnly.
 // After this call, the blockstore can no longer be used.
 //
-// Note that this call may block if any blockstore operations are currently in
-// progress, including an AllKeysChan that hasn't been fully consumed or cancelled.
+// Any AllKeysChan that hasn't been fully consumed or cancelled is stopped.
+// Note that this call may block if any other blockstore operations are currently in progress.
 func (b *ReadOnly) Close() error {
+	b.signalClose()
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	return b.closeWithoutMutex()
 }
 
+// signalClose notifies in-flight AllKeysChan goroutines that the blockstore is closing, so that
+// they release their read lock.
+// It must be called before acquiring the write lock in order to avoid deadlocks.
+func (b *ReadOnly) signalClose() {
+	b.doneMu.Lock()
+	defer b.doneMu.Unlock()
+	if b.done != nil && !b.doneClosed {
+		close(b.done)
+		b.doneClosed = true
+	}
+}
+
+// resetCloseSignal undoes signalClose, such that AllKeysChan can be used again once a close is
+// abandoned, e.g. by a cancelled ReadWrite.FinalizeContext.
+// It must be called with b.mu held for writing.
+func (b *ReadOnly) resetCloseSignal() {
+	b.doneMu.Lock()
+	defer b.doneMu.Unlock()
+	if b.doneClosed {
+		b.done = make(chan struct{})
+		b.doneClosed = false
+	}
+}
+
 func (b *ReadOnly) closeWithoutMutex() error {
 	b.closed = true
 	if b.carv2Closer != nil {
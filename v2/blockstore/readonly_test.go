@@ -2,18 +2,28 @@ package blockstore
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	ipfsblockstore "github.com/ipfs/go-ipfs-blockstore"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
 	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
@@ -123,6 +133,174 @@ func TestReadOnly(t *testing.T) {
 	}
 }
 
+func TestReadOnlyGetSizeWithSizedIndex(t *testing.T) {
+	path := "../testdata/sample-v1.car"
+	sizedIdx, err := carv2.GenerateIndexFromFile(path, carv2.UseIndexCodec(index.CarMultihashSizedIndexSorted))
+	require.NoError(t, err)
+
+	// Construct a sized index that lacks sizes from the default index.
+	mhIdx, err := carv2.GenerateIndexFromFile(path)
+	require.NoError(t, err)
+	var records []index.Record
+	err = mhIdx.(index.IterableIndex).ForEach(func(mh multihash.Multihash, offset uint64) error {
+		records = append(records, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset})
+		return nil
+	})
+	require.NoError(t, err)
+	sizelessIdx := index.NewMultihashSizedSorted()
+	require.NoError(t, sizelessIdx.Load(records))
+
+	tests := []struct {
+		name           string
+		idx            index.Index
+		withoutPayload bool
+	}{
+		{"SizedIndexIsUsedWithoutReadingPayload", sizedIdx, true},
+		{"SizedIndexWithoutSizesFallsBackOnPayload", sizelessIdx, false},
+		{"OffsetOnlyIndexFallsBackOnPayload", mhIdx, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, f.Close()) })
+			subject, err := NewReadOnly(f, tt.idx)
+			require.NoError(t, err)
+			if tt.withoutPayload {
+				subject.backing = io.NewSectionReader(f, 0, 0)
+			}
+
+			v1r := newV1ReaderFromV1File(t, path, false)
+			for {
+				wantBlock, err := v1r.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				if wantBlock.Cid().Prefix().MhType == multihash.IDENTITY {
+					continue
+				}
+				gotSize, err := subject.GetSize(context.TODO(), wantBlock.Cid())
+				require.NoError(t, err)
+				require.Equal(t, len(wantBlock.RawData()), gotSize)
+			}
+
+			notFound, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte("not in car"))
+			require.NoError(t, err)
+			_, err = subject.GetSize(context.TODO(), notFound)
+			require.IsType(t, format.ErrNotFound{}, err)
+		})
+	}
+}
+
+func TestReadOnlyRecordOutOfBoundsIsTypedError(t *testing.T) {
+	ctx := context.TODO()
+	path := "../testdata/sample-v1.car"
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	key := merkledag.NewRawNode([]byte("lobstermuncher")).Block.Cid()
+	offset := uint64(info.Size()) + 42
+	idx, err := index.NewFromRecords(multicodec.CarMultihashIndexSorted, []index.Record{{Cid: key, Offset: offset}})
+	require.NoError(t, err)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	subject, err := NewReadOnly(f, idx)
+	require.NoError(t, err)
+
+	requireOutOfBounds := func(t *testing.T, err error) {
+		var oob *index.ErrRecordOutOfBounds
+		require.True(t, errors.As(err, &oob), "expected out of bounds error, got %v", err)
+		require.Equal(t, offset, oob.Offset)
+		require.True(t, errors.Is(err, io.EOF))
+	}
+	_, err = subject.Get(ctx, key)
+	requireOutOfBounds(t, err)
+	_, err = subject.Has(ctx, key)
+	requireOutOfBounds(t, err)
+	_, err = subject.GetSize(ctx, key)
+	requireOutOfBounds(t, err)
+	err = subject.View(ctx, key, func([]byte) error { return nil })
+	requireOutOfBounds(t, err)
+	_, _, err = subject.GetByMultihash(key.Hash())
+	requireOutOfBounds(t, err)
+}
+
+func TestReadOnlyHasMultihashAndGetSizeMultihash(t *testing.T) {
+	path := "../testdata/sample-v1.car"
+	cidIdx, err := carv2.GenerateIndexFromFile(path, carv2.UseIndexCodec(index.CarCidIndexSorted))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		idx  index.Index
+		opts []carv2.Option
+	}{
+		{"Default", nil, nil},
+		{"UseWholeCIDs", nil, []carv2.Option{UseWholeCIDs(true)}},
+		{"StrictCodecMatch", nil, []carv2.Option{WithStrictCodecMatch()}},
+		{"UseWholeCIDsWithCidSortedIndex", cidIdx, []carv2.Option{UseWholeCIDs(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, f.Close()) })
+			subject, err := NewReadOnly(f, tt.idx, tt.opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+			v1r := newV1ReaderFromV1File(t, path, false)
+			for {
+				wantBlock, err := v1r.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				mh := wantBlock.Cid().Hash()
+				has, err := subject.HasMultihash(mh)
+				require.NoError(t, err)
+				require.True(t, has)
+				gotSize, err := subject.GetSizeMultihash(mh)
+				require.NoError(t, err)
+				require.Equal(t, len(wantBlock.RawData()), gotSize)
+			}
+			// Assert the records of an index that stores whole CIDs are keyed by multihash once,
+			// rather than iterated over on every lookup.
+			if tt.idx != nil {
+				require.IsType(t, &index.MultihashIndexSorted{}, subject.mhIdx)
+			} else {
+				require.Nil(t, subject.mhIdx)
+			}
+
+			notFound, err := multihash.Sum([]byte("not in car"), multihash.SHA2_256, -1)
+			require.NoError(t, err)
+			has, err := subject.HasMultihash(notFound)
+			require.NoError(t, err)
+			require.False(t, has)
+			_, err = subject.GetSizeMultihash(notFound)
+			require.IsType(t, format.ErrNotFound{}, err)
+
+			_, err = subject.HasMultihash(multihash.Multihash("not a multihash"))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestReadOnlyMissingRoots(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+
+	got, err := subject.MissingRoots()
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	require.NoError(t, subject.Close())
+	_, err = subject.MissingRoots()
+	require.Equal(t, errClosed, err)
+}
+
 func TestNewReadOnlyFailsOnUnknownVersion(t *testing.T) {
 	f, err := os.Open("../testdata/sample-rootless-v42.car")
 	require.NoError(t, err)
@@ -198,6 +376,93 @@ func TestReadOnlyAllKeysChanErrHandlerNeverCalled(t *testing.T) {
 	}
 }
 
+func TestReadOnlyAllKeysChanServesWholeCidsFromCidSortedIndex(t *testing.T) {
+	path := "../testdata/sample-v1.car"
+	idx, err := carv2.GenerateIndexFromFile(path,
+		carv2.UseIndexCodec(index.CarCidIndexSorted),
+		carv2.StoreIdentityCIDs(true))
+	require.NoError(t, err)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	subject, err := NewReadOnly(f, idx, UseWholeCIDs(true))
+	require.NoError(t, err)
+
+	// Truncate the payload view to assert that keys are served from the index alone.
+	subject.backing = io.NewSectionReader(f, 0, 0)
+
+	ctx := WithAsyncErrorHandler(context.Background(), func(err error) {
+		require.Fail(t, "unexpected call", "error handler called unexpectedly with err: %v", err)
+	})
+	keysChan, err := subject.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var gotCids []cid.Cid
+	for k := range keysChan {
+		gotCids = append(gotCids, k)
+	}
+	require.ElementsMatch(t, listCids(t, newV1ReaderFromV1File(t, path, false)), gotCids)
+}
+
+func TestReadOnlyAllKeysChanStopsOnClose(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-v1.car")
+	require.NoError(t, err)
+
+	// Abandon the channel without consuming it or cancelling its context.
+	keysChan, err := subject.AllKeysChan(context.Background())
+	require.NoError(t, err)
+
+	// Assert Close does not block on the abandoned AllKeysChan.
+	closeErr := make(chan error, 1)
+	go func() { closeErr <- subject.Close() }()
+	select {
+	case err := <-closeErr:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 3):
+		require.Fail(t, "Close blocked by abandoned AllKeysChan")
+	}
+
+	// Assert the goroutine has stopped, i.e. the channel is closed once any buffered keys are drained.
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case _, ok := <-keysChan:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			require.Fail(t, "AllKeysChan goroutine did not stop after Close")
+			return
+		}
+	}
+}
+
+func TestReadOnlyAllKeysChanReleasesLockOnError(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-v1.car")
+	require.NoError(t, err)
+
+	// Corrupt the backing such that the data header cannot be read before iteration starts.
+	backing := subject.backing
+	subject.backing = bytes.NewReader([]byte{0xff})
+	_, err = subject.AllKeysChan(context.Background())
+	require.Error(t, err)
+	subject.backing = backing
+
+	// Assert the lock is released, i.e. a write lock can be taken.
+	locked := make(chan struct{})
+	go func() {
+		subject.mu.Lock()
+		subject.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second * 3):
+		require.Fail(t, "AllKeysChan did not release the lock on error")
+	}
+	require.NoError(t, subject.Close())
+}
+
 func listCids(t *testing.T, v1r *carv1.CarReader) (cids []cid.Cid) {
 	for {
 		block, err := v1r.Next()
@@ -300,3 +565,957 @@ func TestNewReadOnly_CarV1WithoutIndexWorksAsExpected(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, wantBlock, gotBlock)
 }
+
+func TestReadOnlyView(t *testing.T) {
+	tests := []struct {
+		name       string
+		v1OrV2path string
+		opts       []carv2.Option
+	}{
+		{
+			"OpenedWithCarV1",
+			"../testdata/sample-v1.car",
+			[]carv2.Option{UseWholeCIDs(true), carv2.StoreIdentityCIDs(true)},
+		},
+		{
+			"OpenedWithCarV2",
+			"../testdata/sample-wrapped-v2.car",
+			[]carv2.Option{UseWholeCIDs(true), carv2.StoreIdentityCIDs(true)},
+		},
+		{
+			"OpenedWithCarV2MultihashOnly",
+			"../testdata/sample-wrapped-v2.car",
+			[]carv2.Option{carv2.StoreIdentityCIDs(true)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			subject, err := OpenReadOnly(tt.v1OrV2path, tt.opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+			// Assert the blockstore satisfies the optional Viewer interface.
+			var bs ipfsblockstore.Blockstore = subject
+			viewer, ok := bs.(ipfsblockstore.Viewer)
+			require.True(t, ok)
+
+			f, err := os.Open(tt.v1OrV2path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, f.Close()) })
+			reader, err := carv2.NewBlockReader(f, tt.opts...)
+			require.NoError(t, err)
+
+			for {
+				wantBlock, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				var gotData []byte
+				err = viewer.View(ctx, wantBlock.Cid(), func(data []byte) error {
+					// Copy the data since it must not be retained after the callback returns.
+					gotData = append([]byte{}, data...)
+					return nil
+				})
+				require.NoError(t, err)
+				require.Equal(t, wantBlock.RawData(), gotData)
+			}
+
+			// Assert errors returned by callback are propagated.
+			roots, err := subject.Roots()
+			require.NoError(t, err)
+			wantErr := errors.New("lobster")
+			gotErr := viewer.View(ctx, roots[0], func([]byte) error { return wantErr })
+			require.Equal(t, wantErr, gotErr)
+
+			// Assert callback is not called when block is not found.
+			nonExistingKey := merkledag.NewRawNode([]byte("lobstermuncher")).Block.Cid()
+			err = viewer.View(ctx, nonExistingKey, func([]byte) error {
+				require.Fail(t, "callback called for non-existing key")
+				return nil
+			})
+			require.IsType(t, format.ErrNotFound{}, err)
+		})
+	}
+}
+
+func TestReadOnlyViewWithoutCopy(t *testing.T) {
+	ctx := context.TODO()
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-wrapped-v2.car"} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			subject, err := OpenReadOnly(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			if subject.data == nil {
+				t.Skip("the memory-mapped bytes are not exposed on this platform")
+			}
+
+			// Assert the data passed to callback is the block data within the memory-mapped bytes.
+			roots, err := subject.Roots()
+			require.NoError(t, err)
+			want, err := subject.Get(ctx, roots[0])
+			require.NoError(t, err)
+			offsets, err := subject.Offsets(roots[0])
+			require.NoError(t, err)
+			require.NoError(t, subject.View(ctx, roots[0], func(data []byte) error {
+				require.Equal(t, want.RawData(), data)
+				start := cap(subject.data) - cap(data)
+				require.Greater(t, start, int(offsets[0]))
+				require.True(t, &subject.data[start] == &data[0], "expected data not to be copied")
+				return nil
+			}))
+		})
+	}
+}
+
+func TestReadOnlyWithMmapIndex(t *testing.T) {
+	ctx := context.TODO()
+	for _, path := range []string{
+		"../testdata/sample-wrapped-v2.car",
+		"../testdata/sample-rw-bs-v2.car",
+	} {
+		t.Run(path, func(t *testing.T) {
+			want, err := OpenReadOnly(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, want.Close()) })
+			subject, err := OpenReadOnly(path, UseMmapIndex(true))
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+			// Assert the embedded index is not read into memory.
+			require.Equal(t, want.idx.Codec(), subject.idx.Codec())
+			require.NotEqual(t, reflect.TypeOf(want.idx), reflect.TypeOf(subject.idx))
+
+			keys, err := want.AllKeysChan(ctx)
+			require.NoError(t, err)
+			var count int
+			for key := range keys {
+				count++
+				wantBlock, err := want.Get(ctx, key)
+				require.NoError(t, err)
+				gotBlock, err := subject.Get(ctx, key)
+				require.NoError(t, err)
+				require.Equal(t, wantBlock, gotBlock)
+			}
+			require.NotZero(t, count)
+
+			has, err := subject.Has(ctx, merkledag.NewRawNode([]byte("lobstermuncher")).Block.Cid())
+			require.NoError(t, err)
+			require.False(t, has)
+		})
+	}
+}
+
+func TestReadOnlyWithMmapIndexAboveThreshold(t *testing.T) {
+	ctx := context.TODO()
+	path := "../testdata/sample-wrapped-v2.car"
+	want, err := OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, want.Close()) })
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		opts     []carv2.Option
+		wantMmap bool
+	}{
+		{"Default", nil, false},
+		{"BelowThreshold", []carv2.Option{UseMmapIndexAbove(uint64(len(data)))}, false},
+		{"AboveThreshold", []carv2.Option{UseMmapIndexAbove(1)}, true},
+		{"Disabled", []carv2.Option{UseMmapIndexAbove(math.MaxUint64)}, false},
+		{"DisabledWithMmapIndex", []carv2.Option{UseMmapIndexAbove(math.MaxUint64), UseMmapIndex(true)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Use an in-memory backing, which stands in for a remote one as it is not a file.
+			subject, err := NewReadOnly(bytes.NewReader(data), nil, tt.opts...)
+			require.NoError(t, err)
+			require.Equal(t, want.idx.Codec(), subject.idx.Codec())
+			require.Equal(t, tt.wantMmap, reflect.TypeOf(want.idx) != reflect.TypeOf(subject.idx))
+
+			keys, err := want.AllKeysChan(ctx)
+			require.NoError(t, err)
+			for key := range keys {
+				wantBlock, err := want.Get(ctx, key)
+				require.NoError(t, err)
+				gotBlock, err := subject.Get(ctx, key)
+				require.NoError(t, err)
+				require.Equal(t, wantBlock, gotBlock)
+			}
+		})
+	}
+}
+
+func TestReadOnlyEachBlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		v1OrV2path string
+		opts       []carv2.Option
+	}{
+		{
+			"OpenedWithCarV1",
+			"../testdata/sample-v1.car",
+			[]carv2.Option{UseWholeCIDs(true), carv2.StoreIdentityCIDs(true)},
+		},
+		{
+			"OpenedWithCarV2",
+			"../testdata/sample-wrapped-v2.car",
+			[]carv2.Option{UseWholeCIDs(true), carv2.StoreIdentityCIDs(true)},
+		},
+		{
+			"OpenedWithCarV1ZeroLenSection",
+			"../testdata/sample-v1-with-zero-len-section.car",
+			[]carv2.Option{UseWholeCIDs(true), carv2.ZeroLengthSectionAsEOF(true)},
+		},
+		{
+			"OpenedWithReadBuffer",
+			"../testdata/sample-wrapped-v2.car",
+			[]carv2.Option{UseWholeCIDs(true), carv2.StoreIdentityCIDs(true), carv2.WithReadBufferSize(512)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.TODO()
+			subject, err := OpenReadOnly(tt.v1OrV2path, tt.opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+			f, err := os.Open(tt.v1OrV2path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, f.Close()) })
+			reader, err := carv2.NewBlockReader(f, tt.opts...)
+			require.NoError(t, err)
+			var wantBlocks []blocks.Block
+			for {
+				blk, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				wantBlocks = append(wantBlocks, blk)
+			}
+
+			var gotBlocks []blocks.Block
+			var lastOffset uint64
+			err = subject.EachBlock(ctx, func(c cid.Cid, data []byte, offset uint64) error {
+				if len(gotBlocks) > 0 {
+					require.Greater(t, offset, lastOffset)
+				}
+				lastOffset = offset
+
+				// Assert the offset is that of the block's section.
+				gotCid, gotData, err := subject.readBlock(int64(offset))
+				require.NoError(t, err)
+				require.Equal(t, c, gotCid)
+				require.Equal(t, data, gotData)
+
+				blk, err := blocks.NewBlockWithCid(data, c)
+				require.NoError(t, err)
+				gotBlocks = append(gotBlocks, blk)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, wantBlocks, gotBlocks)
+		})
+	}
+}
+
+func TestReadOnlyOffsets(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+	ctx := context.TODO()
+	wantOffsets := make(map[cid.Cid]uint64)
+	err = subject.EachBlock(ctx, func(c cid.Cid, _ []byte, offset uint64) error {
+		if _, ok, err := isIdentity(c); err != nil || ok {
+			return err
+		}
+		wantOffsets[c] = offset
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, wantOffsets)
+
+	// Assert the offsets of every block include the offset of its section.
+	for c, offset := range wantOffsets {
+		offsets, err := subject.Offsets(c)
+		require.NoError(t, err)
+		require.Contains(t, offsets, offset)
+
+		var got []uint64
+		err = subject.ForEachOffset(ctx, c, 1, func(offset uint64) error {
+			got = append(got, offset)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, offsets[:1], got)
+	}
+
+	missing := blocks.NewBlock([]byte("fish")).Cid()
+	_, err = subject.Offsets(missing)
+	require.Equal(t, index.ErrNotFound, err)
+	err = subject.ForEachOffset(ctx, missing, 0, func(uint64) error { return nil })
+	require.Equal(t, index.ErrNotFound, err)
+
+	roots, err := subject.Roots()
+	require.NoError(t, err)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = subject.ForEachOffset(cancelled, roots[0], 0, func(uint64) error { return nil })
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestReadOnlyCidAt(t *testing.T) {
+	info, err := os.Stat("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	payloadSize := uint64(info.Size())
+
+	tests := []struct {
+		name string
+		open func(t *testing.T) *ReadOnly
+	}{
+		{
+			name: "CARv1",
+			open: func(t *testing.T) *ReadOnly {
+				subject, err := OpenReadOnly("../testdata/sample-v1.car", UseWholeCIDs(true))
+				require.NoError(t, err)
+				return subject
+			},
+		},
+		{
+			name: "CARv2",
+			open: func(t *testing.T) *ReadOnly {
+				// The data payload of the CARv2 is identical to the CARv1.
+				subject, err := OpenReadOnly("../testdata/sample-wrapped-v2.car", UseWholeCIDs(true))
+				require.NoError(t, err)
+				return subject
+			},
+		},
+		{
+			name: "NonIterableIndex",
+			open: func(t *testing.T) *ReadOnly {
+				subject, err := OpenReadOnly("../testdata/sample-v1.car", UseWholeCIDs(true), carv2.UseIndexCodec(multicodec.CarIndexSorted))
+				require.NoError(t, err)
+				_, ok := subject.idx.(index.IterableIndex)
+				require.False(t, ok)
+				return subject
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject := tt.open(t)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+			var cids []cid.Cid
+			var offsets []uint64
+			require.NoError(t, subject.EachBlock(context.TODO(), func(c cid.Cid, _ []byte, offset uint64) error {
+				cids = append(cids, c)
+				offsets = append(offsets, offset)
+				return nil
+			}))
+			require.NotEmpty(t, cids)
+
+			// Assert offsets at the start, middle and end of every section resolve to it, including
+			// sections of identity CIDs, which are not indexed.
+			for i, start := range offsets {
+				end := payloadSize
+				if i+1 < len(offsets) {
+					end = offsets[i+1]
+				}
+				for _, at := range []uint64{start, (start + end) / 2, end - 1} {
+					gotCid, gotStart, err := subject.CidAt(at)
+					require.NoError(t, err)
+					require.Equal(t, cids[i], gotCid)
+					require.Equal(t, start, gotStart)
+				}
+			}
+
+			// Assert offsets within the CARv1 header, or past the data payload, are not found.
+			for _, at := range []uint64{0, offsets[0] - 1, payloadSize, payloadSize + 1000} {
+				_, _, err := subject.CidAt(at)
+				require.True(t, errors.Is(err, index.ErrNotFound), "expected index.ErrNotFound but got: %v", err)
+			}
+		})
+	}
+
+	t.Run("ReadWrite", func(t *testing.T) {
+		subject, err := OpenReadWrite(filepath.Join(t.TempDir(), "readwrite.car"), nil)
+		require.NoError(t, err)
+		t.Cleanup(subject.Discard)
+		for i := 0; i < 10; i++ {
+			blk := merkledag.NewRawNode([]byte(fmt.Sprintf("fish %d", i)))
+			require.NoError(t, subject.Put(context.TODO(), blk))
+
+			// Assert blocks put after an offset has been resolved are resolved too.
+			offsets, err := subject.Offsets(blk.Cid())
+			require.NoError(t, err)
+			gotCid, gotStart, err := subject.CidAt(offsets[0] + 1)
+			require.NoError(t, err)
+			require.Equal(t, blk.Cid(), gotCid)
+			require.Equal(t, offsets[0], gotStart)
+		}
+	})
+}
+
+func TestReadOnlyEachBlockStops(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-v1.car")
+	require.NoError(t, err)
+
+	// Assert iteration stops on the first error returned by the callback.
+	wantErr := errors.New("lobster")
+	var calls int
+	err = subject.EachBlock(context.TODO(), func(cid.Cid, []byte, uint64) error {
+		calls++
+		if calls == 3 {
+			return wantErr
+		}
+		return nil
+	})
+	require.Equal(t, wantErr, err)
+	require.Equal(t, 3, calls)
+
+	// Assert iteration stops once the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = subject.EachBlock(ctx, func(cid.Cid, []byte, uint64) error {
+		calls++
+		cancel()
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 1, calls)
+
+	// Assert iteration is not possible after closing.
+	require.NoError(t, subject.Close())
+	err = subject.EachBlock(context.TODO(), func(cid.Cid, []byte, uint64) error { return nil })
+	require.Equal(t, errClosed, err)
+}
+
+func TestOpenReadOnlyWithoutMmap(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []carv2.Option
+		failMap bool
+	}{
+		{name: "WithoutMmap", opts: []carv2.Option{WithoutMmap()}},
+		{name: "MmapFailure", failMap: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.failMap {
+				t.Cleanup(func() { mmapOpen = mmapFile })
+				mmapOpen = func(string) (readerAtCloser, error) {
+					return nil, errors.New("mmap not supported")
+				}
+			}
+			subject, err := OpenReadOnly("../testdata/sample-wrapped-v2.car", tt.opts...)
+			require.NoError(t, err)
+			f, ok := subject.carv2Closer.(*os.File)
+			require.True(t, ok, "expected the file to be read as a regular file, got %T", subject.carv2Closer)
+
+			// Assert every block is read as it is stored in the CAR.
+			want, err := OpenReadOnly("../testdata/sample-wrapped-v2.car", UseMmapIndex(true))
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, want.Close()) })
+			ctx := context.TODO()
+			var count int
+			err = want.EachBlock(ctx, func(c cid.Cid, data []byte, _ uint64) error {
+				got, err := subject.Get(ctx, c)
+				require.NoError(t, err)
+				require.Equal(t, data, got.RawData())
+				count++
+				return nil
+			})
+			require.NoError(t, err)
+			require.NotZero(t, count)
+
+			// Assert the file is closed along with the blockstore.
+			require.NoError(t, subject.Close())
+			_, err = f.Stat()
+			require.True(t, errors.Is(err, os.ErrClosed))
+		})
+	}
+}
+
+func TestReadOnlyWithIndexBeforeData(t *testing.T) {
+	ctx := context.TODO()
+	want, err := OpenReadOnly("../testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, want.Close()) })
+
+	for _, useMmap := range []bool{false, true} {
+		t.Run(fmt.Sprintf("UseMmapIndex=%t", useMmap), func(t *testing.T) {
+			subject, err := OpenReadOnly("../testdata/sample-v2-index-before-data.car", UseMmapIndex(useMmap))
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+			var count int
+			err = want.EachBlock(ctx, func(c cid.Cid, data []byte, _ uint64) error {
+				got, err := subject.Get(ctx, c)
+				require.NoError(t, err)
+				require.Equal(t, data, got.RawData())
+				count++
+				return nil
+			})
+			require.NoError(t, err)
+			require.NotZero(t, count)
+		})
+	}
+}
+
+func TestReadOnlyWrappedV1WithPadding(t *testing.T) {
+	ctx := context.TODO()
+	want, err := OpenReadOnly("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, want.Close()) })
+
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, multicodec.CarIndexSorted} {
+		t.Run(codec.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "wrapped-padded.car")
+			require.NoError(t, carv2.WrapV1File("../testdata/sample-v1.car", path,
+				carv2.UseDataPadding(1413), carv2.UseIndexPadding(4096), carv2.UseIndexCodec(codec)))
+
+			for _, useMmap := range []bool{false, true} {
+				t.Run(fmt.Sprintf("UseMmapIndex=%t", useMmap), func(t *testing.T) {
+					subject, err := OpenReadOnly(path, UseMmapIndex(useMmap))
+					require.NoError(t, err)
+					t.Cleanup(func() { require.NoError(t, subject.Close()) })
+
+					wantRoots, err := want.Roots()
+					require.NoError(t, err)
+					gotRoots, err := subject.Roots()
+					require.NoError(t, err)
+					require.Equal(t, wantRoots, gotRoots)
+
+					var count int
+					err = want.EachBlock(ctx, func(c cid.Cid, data []byte, _ uint64) error {
+						got, err := subject.Get(ctx, c)
+						require.NoError(t, err)
+						require.Equal(t, data, got.RawData())
+						count++
+						return nil
+					})
+					require.NoError(t, err)
+					require.NotZero(t, count)
+				})
+			}
+		})
+	}
+}
+
+func TestReadOnlyIndexMemory(t *testing.T) {
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-wrapped-v2.car"} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			subject, err := OpenReadOnly(path)
+			require.NoError(t, err)
+			got, err := subject.IndexMemory()
+			require.NoError(t, err)
+			footprint, ok := subject.idx.(index.MemoryFootprintIndex)
+			require.True(t, ok)
+			require.Equal(t, footprint.MemoryFootprint(), got)
+			require.NotZero(t, got)
+
+			require.NoError(t, subject.Close())
+			_, err = subject.IndexMemory()
+			require.Error(t, err)
+		})
+	}
+
+	// Assert indices whose records are not held in memory report an error.
+	subject, err := OpenReadOnly("../testdata/sample-wrapped-v2.car", UseMmapIndex(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+	_, err = subject.IndexMemory()
+	require.Error(t, err)
+}
+
+func TestReadOnlyWithVerifyOnGet(t *testing.T) {
+	ctx := context.TODO()
+	f, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+
+	// Collect the offset of every non-identity block.
+	want, err := NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), nil)
+	require.NoError(t, err)
+	var blks []blocks.Block
+	var records []index.Record
+	err = want.EachBlock(ctx, func(c cid.Cid, data []byte, offset uint64) error {
+		if _, ok, err := isIdentity(c); err != nil || ok {
+			return err
+		}
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return err
+		}
+		blks = append(blks, blk)
+		records = append(records, index.Record{Cid: c, Offset: offset})
+		return nil
+	})
+	require.NoError(t, err)
+	require.Greater(t, len(blks), 3)
+
+	// Point the index at the wrong block for one CID, and at the middle of a section for another.
+	wrongOffset := records[2].Offset
+	records[1].Offset = wrongOffset
+	records[3].Offset++
+	staleIdx, err := index.NewFromRecords(multicodec.CarMultihashIndexSorted, records)
+	require.NoError(t, err)
+
+	// Assert the blocks are not found without verification.
+	subject, err := NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), staleIdx)
+	require.NoError(t, err)
+	_, err = subject.Get(ctx, blks[1].Cid())
+	require.IsType(t, format.ErrNotFound{}, err)
+
+	type mismatch struct {
+		key                         cid.Cid
+		indexedOffset, actualOffset uint64
+		found                       bool
+	}
+	var mismatches []mismatch
+	subject, err = NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), staleIdx, WithVerifyOnGet(), WithIndexMismatchHook(
+		func(key cid.Cid, indexedOffset, actualOffset uint64, found bool) {
+			mismatches = append(mismatches, mismatch{key, indexedOffset, actualOffset, found})
+		}))
+	require.NoError(t, err)
+	for _, blk := range blks {
+		got, err := subject.Get(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+	wantOffsets, err := want.Offsets(blks[1].Cid())
+	require.NoError(t, err)
+	require.Equal(t, []mismatch{
+		{key: blks[1].Cid(), indexedOffset: wrongOffset, actualOffset: wantOffsets[0], found: true},
+		{key: blks[3].Cid(), indexedOffset: records[3].Offset, actualOffset: records[3].Offset - 1, found: true},
+	}, mismatches)
+
+	// Assert blocks that are not indexed at all are not scanned for.
+	_, err = subject.Get(ctx, blocks.NewBlock([]byte("fish")).Cid())
+	require.IsType(t, format.ErrNotFound{}, err)
+	require.Len(t, mismatches, 2)
+}
+
+func TestReadOnlyLookupsWithCancelledContext(t *testing.T) {
+	subject, err := OpenReadOnly("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+	keys, err := subject.AllKeysChan(context.Background())
+	require.NoError(t, err)
+	key := <-keys
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = subject.Get(ctx, key)
+	require.Equal(t, context.Canceled, err)
+	_, err = subject.Has(ctx, key)
+	require.Equal(t, context.Canceled, err)
+	_, err = subject.GetSize(ctx, key)
+	require.Equal(t, context.Canceled, err)
+	err = subject.View(ctx, key, func([]byte) error { return nil })
+	require.Equal(t, context.Canceled, err)
+	err = subject.ForEachOffset(ctx, key, 0, func(uint64) error { return nil })
+	require.Equal(t, context.Canceled, err)
+
+	// Assert identity CIDs are resolved regardless, since the index is not looked up.
+	identity, err := cid.NewPrefixV1(cid.Raw, multihash.IDENTITY).Sum([]byte("fish"))
+	require.NoError(t, err)
+	has, err := subject.Has(ctx, identity)
+	require.NoError(t, err)
+	require.True(t, has)
+
+	got, err := subject.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.Equal(t, key, got.Cid())
+}
+
+func TestReadOnlyWithBloomFilter(t *testing.T) {
+	ctx := context.TODO()
+	f, err := os.Open("../testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+
+	plain, err := NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), nil)
+	require.NoError(t, err)
+	keys, err := plain.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var present []cid.Cid
+	for key := range keys {
+		present = append(present, key)
+	}
+	require.NotEmpty(t, present)
+	var absent []cid.Cid
+	for i := 0; i < 100; i++ {
+		c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte(fmt.Sprintf("absent-%d", i)))
+		require.NoError(t, err)
+		absent = append(absent, c)
+	}
+
+	subject, err := NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), nil, WithBloomFilter(0.01))
+	require.NoError(t, err)
+	require.NotNil(t, subject.bloom)
+	for _, key := range present {
+		has, err := subject.Has(ctx, key)
+		require.NoError(t, err)
+		require.True(t, has)
+		_, err = subject.Get(ctx, key)
+		require.NoError(t, err)
+	}
+	for _, key := range absent {
+		has, err := subject.Has(ctx, key)
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+
+	// Assert a given filter is consulted instead of the index: an empty filter hides every block.
+	empty, err := index.NewBloom(uint64(len(present)), 0.01)
+	require.NoError(t, err)
+	subject, err = NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), nil, WithBloomFilter(0.01), UseBloomFilter(empty))
+	require.NoError(t, err)
+	require.Same(t, empty, subject.bloom)
+	for _, key := range present {
+		if _, ok, _ := isIdentity(key); ok {
+			continue
+		}
+		has, err := subject.Has(ctx, key)
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+
+	// Assert an invalid false positive rate, or an index that is not iterable, is an error.
+	_, err = NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), nil, WithBloomFilter(1.5))
+	require.Error(t, err)
+	sortedIdx, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	_, err = NewReadOnly(io.NewSectionReader(f, 0, math.MaxInt64), sortedIdx, WithBloomFilter(0.01))
+	require.Error(t, err)
+}
+
+func TestReadOnlyWithCidV0(t *testing.T) {
+	ctx := context.Background()
+	child := merkledag.NodeWithData([]byte("fish"))
+	parent := merkledag.NodeWithData([]byte("lobster"))
+	require.NoError(t, parent.AddNodeLink("child", child))
+	blks := []blocks.Block{parent, child}
+	roots := []cid.Cid{parent.Cid()}
+
+	tests := []struct {
+		name      string
+		codec     multicodec.Code
+		wholeCIDs bool
+	}{
+		{"Multihashes", multicodec.CarMultihashIndexSorted, false},
+		{"WholeCIDs", multicodec.CarMultihashIndexSorted, true},
+		{"WholeCIDsWithCidIndex", index.CarCidIndexSorted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []carv2.Option{carv2.UseIndexCodec(tt.codec), UseWholeCIDs(tt.wholeCIDs)}
+			path := filepath.Join(t.TempDir(), "readonly-cidv0.car")
+			rw, err := OpenReadWrite(path, roots, opts...)
+			require.NoError(t, err)
+			require.NoError(t, rw.PutMany(ctx, blks))
+			require.NoError(t, rw.Finalize())
+
+			subject, err := OpenReadOnly(path, opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			gotRoots, err := subject.Roots()
+			require.NoError(t, err)
+			require.Equal(t, roots, gotRoots)
+			require.Equal(t, uint64(0), gotRoots[0].Version())
+
+			for _, blk := range blks {
+				got, err := subject.Get(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.Equal(t, blk.RawData(), got.RawData())
+
+				// A CIDv1 with the same codec and multihash only matches if whole CIDs are not in use.
+				has, err := subject.Has(ctx, cid.NewCidV1(cid.DagProtobuf, blk.Cid().Hash()))
+				require.NoError(t, err)
+				require.Equal(t, !tt.wholeCIDs, has)
+			}
+
+			keys, err := subject.AllKeysChan(ctx)
+			require.NoError(t, err)
+			var gotKeys []cid.Cid
+			for key := range keys {
+				gotKeys = append(gotKeys, key)
+			}
+			if tt.wholeCIDs {
+				require.ElementsMatch(t, []cid.Cid{parent.Cid(), child.Cid()}, gotKeys)
+				return
+			}
+			// Otherwise, keys are flattened to the raw codec with the multihash left intact, such that
+			// each of them retrieves its CIDv0 block.
+			require.ElementsMatch(t, []cid.Cid{
+				cid.NewCidV1(cid.Raw, parent.Cid().Hash()),
+				cid.NewCidV1(cid.Raw, child.Cid().Hash()),
+			}, gotKeys)
+			for _, key := range gotKeys {
+				got, err := subject.Get(ctx, key)
+				require.NoError(t, err)
+				want := parent
+				if key.Equals(cid.NewCidV1(cid.Raw, child.Cid().Hash())) {
+					want = child
+				}
+				require.Equal(t, want.RawData(), got.RawData())
+			}
+		})
+	}
+}
+
+func TestReadOnlyWithGzipBacking(t *testing.T) {
+	for _, path := range []string{"../testdata/sample-v1.car", "../testdata/sample-wrapped-v2.car"} {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			ctx := context.TODO()
+			plain, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var gz bytes.Buffer
+			zw := gzip.NewWriter(&gz)
+			_, err = zw.Write(plain)
+			require.NoError(t, err)
+			require.NoError(t, zw.Close())
+			gzPath := filepath.Join(t.TempDir(), "sample.car.gz")
+			require.NoError(t, os.WriteFile(gzPath, gz.Bytes(), 0o666))
+
+			want, err := NewReadOnly(bytes.NewReader(plain), nil)
+			require.NoError(t, err)
+			wantRoots, err := want.Roots()
+			require.NoError(t, err)
+			keys, err := want.AllKeysChan(ctx)
+			require.NoError(t, err)
+			var wantBlocks []blocks.Block
+			for key := range keys {
+				blk, err := want.Get(ctx, key)
+				require.NoError(t, err)
+				wantBlocks = append(wantBlocks, blk)
+			}
+			require.NotEmpty(t, wantBlocks)
+
+			assertSame := func(t *testing.T, subject *ReadOnly) {
+				gotRoots, err := subject.Roots()
+				require.NoError(t, err)
+				require.Equal(t, wantRoots, gotRoots)
+				for _, blk := range wantBlocks {
+					got, err := subject.Get(ctx, blk.Cid())
+					require.NoError(t, err)
+					require.Equal(t, blk.RawData(), got.RawData())
+				}
+			}
+
+			// Without the option, a gzipped backing is not a valid CAR.
+			_, err = NewReadOnly(bytes.NewReader(gz.Bytes()), nil)
+			require.Error(t, err)
+
+			t.Run("InMemory", func(t *testing.T) {
+				subject, err := NewReadOnly(bytes.NewReader(gz.Bytes()), nil, WithGzipBacking())
+				require.NoError(t, err)
+				assertSame(t, subject)
+				require.NoError(t, subject.Close())
+			})
+			t.Run("TempFile", func(t *testing.T) {
+				dir := t.TempDir()
+				subject, err := OpenReadOnly(gzPath, WithGzipBackingTempFile(dir))
+				require.NoError(t, err)
+				entries, err := os.ReadDir(dir)
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+				assertSame(t, subject)
+
+				// Assert the temporary file is removed once the blockstore is closed.
+				require.NoError(t, subject.Close())
+				entries, err = os.ReadDir(dir)
+				require.NoError(t, err)
+				require.Empty(t, entries)
+			})
+			t.Run("NotGzipped", func(t *testing.T) {
+				dir := t.TempDir()
+				subject, err := OpenReadOnly(path, WithGzipBackingTempFile(dir))
+				require.NoError(t, err)
+				entries, err := os.ReadDir(dir)
+				require.NoError(t, err)
+				require.Empty(t, entries)
+				assertSame(t, subject)
+				require.NoError(t, subject.Close())
+			})
+		})
+	}
+}
+
+func TestReadOnlyGeneratesIndexInGivenCodec(t *testing.T) {
+	ctx := context.Background()
+	const path = "../testdata/sample-v1.car"
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	r, err := carv1.NewCarReader(f)
+	require.NoError(t, err)
+	var blks []blocks.Block
+	for {
+		blk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		blks = append(blks, blk)
+	}
+	missing := merkledag.NewRawNode([]byte("lobster")).Cid()
+
+	tests := []struct {
+		codec multicodec.Code
+		opts  []carv2.Option
+	}{
+		{codec: multicodec.CarIndexSorted},
+		{codec: multicodec.CarMultihashIndexSorted},
+		{codec: index.CarMultihashSizedIndexSorted},
+		{codec: index.CarMultihashIndexHashed},
+		{codec: index.CarCidIndexSorted, opts: []carv2.Option{UseWholeCIDs(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec.String(), func(t *testing.T) {
+			subject, err := OpenReadOnly(path, append(tt.opts, carv2.UseIndexCodec(tt.codec))...)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			require.Equal(t, tt.codec, subject.idx.Codec())
+
+			// Assert lookups are identical regardless of the index codec.
+			for _, blk := range blks {
+				has, err := subject.Has(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.True(t, has)
+				got, err := subject.Get(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.Equal(t, blk.RawData(), got.RawData())
+				size, err := subject.GetSize(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.Equal(t, len(blk.RawData()), size)
+			}
+			has, err := subject.Has(ctx, missing)
+			require.NoError(t, err)
+			require.False(t, has)
+			_, err = subject.Get(ctx, missing)
+			require.True(t, format.IsNotFound(err))
+			_, err = subject.GetSize(ctx, missing)
+			require.True(t, format.IsNotFound(err))
+		})
+	}
+
+	// Assert unknown codecs are rejected.
+	unregistered := multicodec.Code(0x300fff)
+	_, err = OpenReadOnly(path, carv2.UseIndexCodec(unregistered))
+	var unknown *index.ErrUnknownIndexCodec
+	require.True(t, errors.As(err, &unknown))
+	require.Equal(t, unregistered, unknown.Code)
+	unknown = nil
+	_, err = carv2.GenerateIndexFromFile(path, carv2.UseIndexCodec(unregistered))
+	require.True(t, errors.As(err, &unknown))
+	require.Equal(t, unregistered, unknown.Code)
+}
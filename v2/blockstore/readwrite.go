@@ -1,6 +1,7 @@
 package blockstore
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
 
 	carv2 "github.com/ipld/go-car/v2"
@@ -19,7 +21,10 @@ import (
 	internalio "github.com/ipld/go-car/v2/internal/io"
 )
 
-var _ blockstore.Blockstore = (*ReadWrite)(nil)
+var (
+	_ blockstore.Blockstore = (*ReadWrite)(nil)
+	_ blockstore.Viewer     = (*ReadWrite)(nil)
+)
 
 // ReadWrite implements a blockstore that stores blocks in CARv2 format.
 // Blocks put into the blockstore can be read back once they are successfully written.
@@ -33,10 +38,19 @@ var _ blockstore.Blockstore = (*ReadWrite)(nil)
 type ReadWrite struct {
 	ronly ReadOnly
 
-	f          *os.File
+	f          ReadWriteAt
 	dataWriter *internalio.OffsetWriteSeeker
-	idx        *insertionIndex
+	idx        *index.InsertionIndex
 	header     carv2.Header
+	wal        *indexWAL
+	// unindexed records the sections of the data payload that are not inserted into idx, i.e. those
+	// of identity CIDs unless StoreIdentityCIDs is enabled, such that PutAt does not overwrite them.
+	unindexed []index.Record
+	// syncer syncs the written file to disk, which is f if it can be synced, unless overridden in
+	// tests.
+	syncer interface{ Sync() error }
+	// putManyCalls counts the PutMany calls since the file was last synced; see WithSyncInterval.
+	putManyCalls uint64
 
 	opts carv2.Options
 }
@@ -65,6 +79,140 @@ func AllowDuplicatePuts(allow bool) carv2.Option {
 	}
 }
 
+// WithIndexWAL is a write option which makes a ReadWrite blockstore append the index record of
+// every block it writes to a write-ahead log file at the given path.
+//
+// On resumption, the index is restored from the log rather than by scanning the entire data
+// payload; only the last logged section is verified and any sections written after it are
+// scanned. A log that does not match the data payload is discarded, in which case the data
+// payload is scanned entirely. Upon Finalize the log is removed, since its records are then
+// included in the index written out onto the CAR file.
+//
+// Note, the log is not synced to disk on every write, and is tolerant of partially written records
+// resulting from a crash.
+func WithIndexWAL(path string) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreIndexWALPath = path
+	}
+}
+
+// WithExistingIndex is a write option which makes a ReadWrite blockstore adopt the given index
+// on resumption, rather than re-indexing the existing data payload by scanning it. The index must
+// implement index.IterableIndex, e.g. an index previously generated for the same file via
+// car.GenerateIndex or one read from a finalized file.
+//
+// On resumption, the records of the index are trusted as is, provided that they all fall within
+// the data payload on file and that the section with the largest offset matches its record. Only
+// the sections written after it are scanned. An error is returned if the index does not match the
+// data payload. When not resuming, the index must be empty.
+//
+// The index must record whole CIDs, as told by index.StoresWholeCIDs, if UseWholeCIDs is enabled
+// or if the index codec is index.CarCidIndexSorted, since blocks cannot be deduplicated by exact
+// CID otherwise. This option cannot be combined with WithIndexWAL.
+func WithExistingIndex(idx index.Index) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreExistingIndex = idx
+	}
+}
+
+// WithEmbeddedIndex is a write option which makes a ReadWrite blockstore adopt the index embedded
+// in a finalized CARv2 file on resumption, as if given via WithExistingIndex, rather than
+// re-indexing the existing data payload by scanning it.
+//
+// Unlike with WithExistingIndex, an embedded index that cannot be adopted is ignored, and the data
+// payload is scanned instead. This is the case if the index cannot be read, is not iterable, e.g.
+// because its codec is multicodec.CarIndexSorted, or does not match the data payload, as well as
+// if UseWholeCIDs is enabled and the index does not record whole CIDs, since blocks could not be
+// deduplicated by exact CID otherwise; see index.StoresWholeCIDs. Finalize with the
+// index.CarCidIndexSorted codec for the embedded index to be adopted when using whole CIDs.
+//
+// This option has no effect if combined with WithIndexWAL or WithExistingIndex.
+func WithEmbeddedIndex() carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreEmbeddedIndex = true
+	}
+}
+
+// WithInsertionIndexMode is a write option which sets the mode of the index.InsertionIndex in which
+// a ReadWrite blockstore holds the index records of the blocks written until it is finalized.
+// Defaults to index.InsertionIndexBalanced; use index.InsertionIndexCompact to reduce the memory held
+// by the index during large ingests, at the cost of slower puts.
+func WithInsertionIndexMode(mode index.InsertionIndexMode) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreInsertionIndexMode = mode
+	}
+}
+
+// WithIndexChecksum is a write option which sets whether the index written by ReadWrite.Finalize is
+// checksummed, such that corruption of the index is detected when it is read back rather than
+// resulting in lookups at the wrong offsets. See index.WriteToWithChecksum. Enabled by default.
+//
+// Note that checksummed indices cannot be read by versions of this library that predate them;
+// disable this option to write indices that can.
+func WithIndexChecksum(enable bool) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreDisableIndexChecksum = !enable
+	}
+}
+
+// WithSyncOnFinalize is a write option which makes ReadWrite.Finalize sync the file to disk after
+// writing the data payload, index and header, such that the CAR is durable once Finalize returns.
+//
+// By default the file is never synced, and it is left to the operating system to flush written
+// data to disk; a power loss may then lose recently written blocks even after a successful put or
+// finalization. Syncing blocks until all written data reaches the disk, which may take a long time
+// for large files or slow disks; therefore, this option is disabled by default.
+func WithSyncOnFinalize() carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreSyncOnFinalize = true
+	}
+}
+
+// WithSyncInterval is a write option which makes ReadWrite sync the file to disk once every n calls
+// to Put or PutMany, limiting the blocks that may be lost to a power loss during long ingests.
+// Zero disables syncing on put, which is the default.
+//
+// Note that the performance cost of syncing is paid by the call that triggers it, and that the index
+// WAL, if any, is not synced; see WithIndexWAL. See WithSyncOnFinalize.
+func WithSyncInterval(n uint64) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreSyncInterval = n
+	}
+}
+
+// WithExpectedSize is a write option which makes ReadWrite preallocate the file up to the given
+// size in bytes, i.e. the expected size of the finalized CAR including its index, such that the
+// file is less fragmented on disk and running out of disk space is detected early on. The space is
+// reserved via fallocate on Linux, where supported by the filesystem; on other platforms the file
+// is extended via truncation instead, which does not necessarily reserve disk space.
+//
+// Blocks are written into the preallocated space, and the file is extended as usual if the
+// expected size is exceeded. Upon Finalize, the file is truncated to its actual size.
+//
+// Since the preallocated space reads as null padding, a file that is not finalized can only be
+// resumed with either this option or ZeroLengthSectionAsEOF, in which case the first zero-length
+// section marks the end of the blocks written.
+func WithExpectedSize(n uint64) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreExpectedSize = n
+	}
+}
+
+// WithVerifyPutHashes is a write option which makes ReadWrite hash the data of every block put, with
+// the multihash code and length of its CID, and reject the block with a *car.ErrHashMismatch error
+// before anything is written if the resulting multihash differs from the one of its CID. This
+// guards against corrupting the CAR with blocks whose CID does not match their data. Blocks with
+// identity CIDs are not verified.
+//
+// Verification is disabled by default, since hashing every block put is costly. As with any other
+// error, the blocks of the same PutMany call that precede the rejected block are written, and the
+// blocks that follow it are not.
+func WithVerifyPutHashes() carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreVerifyPutHashes = true
+	}
+}
+
 // OpenReadWrite creates a new ReadWrite at the given path with a provided set of root CIDs and options.
 //
 // ReadWrite.Finalize must be called once putting and reading blocks are no longer needed.
@@ -76,6 +224,9 @@ func AllowDuplicatePuts(allow bool) carv2.Option {
 // If a file at given path does not exist, the instantiation will write car.Pragma and data payload
 // header (i.e. the inner CARv1 header) onto the file before returning.
 //
+// The data and index padding options are validated via car.ValidatePadding before the file is
+// written to; a car.ErrPaddingTooLarge error is returned if either exceeds car.MaxAllowedPadding.
+//
 // When the given path already exists, the blockstore will attempt to resume from it.
 // On resumption the existing data sections in file are re-indexed, allowing the caller to continue
 // putting any remaining blocks without having to re-ingest blocks for which previous ReadWrite.Put
@@ -89,8 +240,9 @@ func AllowDuplicatePuts(allow bool) carv2.Option {
 // resuming from must:
 //  1. start with a complete CARv2 car.Pragma.
 //  2. contain a complete CARv1 data header with root CIDs matching the CIDs passed to the
-//     constructor, starting at offset optionally padded by WithDataPadding, followed by zero or
-//     more complete data sections. If any corrupt data sections are present the resumption will fail.
+//     constructor in the same order, including any duplicates, starting at offset optionally
+//     padded by WithDataPadding, followed by zero or more complete data sections. If any corrupt
+//     data sections are present the resumption will fail.
 //     Note, if set previously, the blockstore must use the same WithDataPadding option as before,
 //     since this option is used to locate the CARv1 data payload.
 //
@@ -98,31 +250,81 @@ func AllowDuplicatePuts(allow bool) carv2.Option {
 // written into the file are not re-written. Unless, the user explicitly wants duplicate blocks.
 //
 // Resuming from finalized files is allowed. However, resumption will regenerate the index
-// regardless by scanning every existing block in file.
+// regardless by scanning every existing block in file, unless the index is restored via
+// WithIndexWAL, WithExistingIndex or WithEmbeddedIndex.
+//
+// See NewReadWrite to write to storage other than a file.
 func OpenReadWrite(path string, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666) // TODO: Should the user be able to configure FileMode permissions?
 	if err != nil {
 		return nil, fmt.Errorf("could not open read/write file: %w", err)
 	}
-	rwbs, err := OpenReadWriteFile(f, roots, opts...)
+	return NewReadWrite(&readWriteFile{f}, roots, opts...)
+}
+
+// OpenReadWriteFile is similar as OpenReadWrite but lets you control the file lifecycle.
+// You are responsible for closing the given file.
+func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
+	return newReadWrite(&readWriteFile{f}, roots, opts...)
+}
+
+// ReadWriteAt is a random access storage that a ReadWrite blockstore can write a CAR to, such as an
+// in-memory buffer or an object store abstraction; see NewReadWrite.
+//
+// If the storage also implements Sync() error, it is called to persist the bytes written as set via
+// WithSyncInterval and WithSyncOnFinalize.
+type ReadWriteAt interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	// Size returns the size of the storage in bytes, i.e. the offset immediately after the last
+	// byte written.
+	Size() (int64, error)
+	// Truncate changes the size of the storage to the given size, discarding any bytes beyond it
+	// or extending it with zeros.
+	Truncate(size int64) error
+}
+
+// readWriteFile is the ReadWriteAt of a file.
+type readWriteFile struct {
+	*os.File
+}
+
+func (f *readWriteFile) Size() (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// NewReadWrite creates a new ReadWrite that writes to the given storage, with a provided set of
+// root CIDs and options. It behaves as OpenReadWrite does, except that the CAR is written to rw
+// rather than to a file at a path: if rw is not empty, the blockstore attempts to resume from it.
+//
+// The blockstore takes ownership of rw, which is closed once the blockstore is finalized, or if
+// instantiation fails.
+//
+// Note that WithExpectedSize extends rw via ReadWriteAt.Truncate unless it is backed by a file,
+// and that WithTraversalOrder stages the rewritten data payload in a temporary file created in
+// the default directory for temporary files; see os.TempDir.
+func NewReadWrite(rw ReadWriteAt, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
+	rwbs, err := newReadWrite(rw, roots, opts...)
 	if err != nil {
 		return nil, err
 	}
-	// close the file when finalizing
+	// Close the storage when finalizing.
 	rwbs.ronly.carv2Closer = rwbs.f
 	return rwbs, nil
 }
 
-// OpenReadWriteFile is similar as OpenReadWrite but lets you control the file lifecycle.
-// You are responsible for closing the given file.
-func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
-	stat, err := f.Stat()
+func newReadWrite(f ReadWriteAt, roots []cid.Cid, opts ...carv2.Option) (*ReadWrite, error) {
+	size, err := f.Size()
 	if err != nil {
-		// Note, we should not get a an os.ErrNotExist here because the flags used to open file includes os.O_CREATE
 		return nil, err
 	}
 	// Try and resume by default if the file size is non-zero.
-	resume := stat.Size() != 0
+	resume := size != 0
 	// If construction of blockstore fails, make sure to close off the open file.
 	defer func() {
 		if err != nil {
@@ -134,12 +336,26 @@ func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*Read
 	// Set the header fileld before applying options since padding options may modify header.
 	rwbs := &ReadWrite{
 		f:      f,
-		idx:    newInsertionIndex(),
 		header: carv2.NewHeader(0),
 		opts:   carv2.ApplyOptions(opts...),
+		syncer: nopSyncer{},
+	}
+	if syncer, ok := f.(interface{ Sync() error }); ok {
+		rwbs.syncer = syncer
 	}
+	rwbs.idx = index.NewInsertionIndexWithMode(rwbs.opts.BlockstoreInsertionIndexMode)
 	rwbs.ronly.opts = rwbs.opts
+	rwbs.ronly.done = make(chan struct{})
 
+	if err = carv2.ValidatePadding(rwbs.opts.DataPadding, rwbs.opts.IndexPadding, rwbs.opts.MaxAllowedPadding); err != nil {
+		return nil, err
+	}
+	if algo := rwbs.opts.DataChecksum; algo != 0 && !rwbs.opts.WriteAsCarV1 {
+		// Check the checksum algorithm upfront, rather than failing once finalizing.
+		if _, err = carv2.DataChecksumSize(algo); err != nil {
+			return nil, err
+		}
+	}
 	if p := rwbs.opts.DataPadding; p > 0 {
 		rwbs.header = rwbs.header.WithDataPadding(p)
 	}
@@ -159,6 +375,28 @@ func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*Read
 	rwbs.ronly.backing = v1r
 	rwbs.ronly.idx = rwbs.idx
 
+	if rwbs.opts.BlockstoreExistingIndex != nil && rwbs.opts.BlockstoreIndexWALPath != "" {
+		err = errors.New("existing index cannot be used in conjunction with index WAL")
+		return nil, err
+	}
+
+	if p := rwbs.opts.BlockstoreIndexWALPath; p != "" {
+		if rwbs.wal, err = openIndexWAL(p); err != nil {
+			return nil, fmt.Errorf("could not open index WAL: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				rwbs.wal.close()
+			}
+		}()
+		if !resume {
+			// Discard any records left over from a previous file at the same path.
+			if err = rwbs.wal.reset(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	if resume {
 		if err = rwbs.resumeWithRoots(!rwbs.opts.WriteAsCarV1, roots); err != nil {
 			return nil, err
@@ -167,11 +405,69 @@ func OpenReadWriteFile(f *os.File, roots []cid.Cid, opts ...carv2.Option) (*Read
 		if err = rwbs.initWithRoots(!rwbs.opts.WriteAsCarV1, roots); err != nil {
 			return nil, err
 		}
+		if rwbs.opts.BlockstoreExistingIndex != nil {
+			// There is no data payload yet; any record in the existing index is out of bounds.
+			var offset uint64
+			if offset, err = carv1.HeaderSize(&carv1.CarHeader{Roots: roots, Version: 1}); err != nil {
+				return nil, err
+			}
+			if _, err = rwbs.adoptExistingIndex(rwbs.opts.BlockstoreExistingIndex, v1r, int64(offset), int64(offset)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if n := rwbs.opts.BlockstoreExpectedSize; n > 0 {
+		if err = rwbs.preallocateStorage(int64(n)); err != nil {
+			return nil, fmt.Errorf("could not preallocate file: %w", err)
+		}
+		// Limit reads to the blocks written, since the preallocated space reads as null padding.
+		rwbs.ronly.backing = &writtenReaderAt{r: v1r, w: rwbs.dataWriter}
 	}
 
 	return rwbs, nil
 }
 
+// preallocateStorage extends the storage such that it is at least size bytes long, reserving disk
+// space if it is backed by a file.
+func (b *ReadWrite) preallocateStorage(size int64) error {
+	if rf, ok := b.f.(*readWriteFile); ok {
+		return preallocate(rf.File, size)
+	}
+	current, err := b.f.Size()
+	if err != nil || current >= size {
+		return err
+	}
+	return b.f.Truncate(size)
+}
+
+// nopSyncer is the syncer of storage that cannot be synced.
+type nopSyncer struct{}
+
+func (nopSyncer) Sync() error { return nil }
+
+// writtenReaderAt reads from the data payload of a ReadWrite up to the position of its writer,
+// such that preallocated space after the written blocks reads as EOF.
+type writtenReaderAt struct {
+	r io.ReaderAt
+	w *internalio.OffsetWriteSeeker
+}
+
+func (wr *writtenReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	end := wr.w.Position()
+	if off >= end {
+		return 0, io.EOF
+	}
+	if remaining := end - off; int64(len(p)) > remaining {
+		n, err := wr.r.ReadAt(p[:remaining], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return wr.r.ReadAt(p, off)
+}
+
 func (b *ReadWrite) initWithRoots(v2 bool, roots []cid.Cid) error {
 	if v2 {
 		if _, err := b.f.WriteAt(carv2.Pragma, 0); err != nil {
@@ -185,7 +481,11 @@ func (b *ReadWrite) resumeWithRoots(v2 bool, roots []cid.Cid) error {
 	// On resumption it is expected that the CARv2 Pragma, and the CARv1 header is successfully written.
 	// Otherwise we cannot resume from the file.
 	// Read pragma to assert if b.f is indeed a CARv2.
-	version, err := carv2.ReadVersion(b.f)
+	vr, err := internalio.NewOffsetReadSeeker(b.f, 0)
+	if err != nil {
+		return err
+	}
+	version, err := carv2.ReadVersion(vr)
 	if err != nil {
 		// The file is not a valid CAR file and cannot resume from it.
 		// Or the write must have failed before pragma was written.
@@ -244,15 +544,22 @@ func (b *ReadWrite) resumeWithRoots(v2 bool, roots []cid.Cid) error {
 		// Cannot read the CARv1 header; the file is most likely corrupt.
 		return fmt.Errorf("error reading car header: %w", err)
 	}
-	if !header.Matches(carv1.CarHeader{Roots: roots, Version: 1}) {
-		// Cannot resume if version and root does not match.
+	if !header.MatchesExactly(carv1.CarHeader{Roots: roots, Version: 1}) {
+		// Cannot resume if version and roots, including their order, do not match.
 		return errors.New("cannot resume on file with mismatching data header")
 	}
 
+	var embedded index.Index
 	if headerInFile.DataOffset != 0 {
 		// If header in file contains the size of car v1, then the index is most likely present.
-		// Since we will need to re-generate the index, as the one in file is flattened, truncate
-		// the file so that the Readonly.backing has the right set of bytes to deal with.
+		// If enabled via WithEmbeddedIndex, and unless an index is restored otherwise, read it so
+		// that its records can be adopted rather than scanning the data payload. Since the index is
+		// rebuilt from its records, as the one in file is flattened, an index that cannot be read
+		// is simply ignored.
+		if b.opts.BlockstoreEmbeddedIndex && headerInFile.HasIndex() && b.opts.BlockstoreExistingIndex == nil && b.wal == nil {
+			embedded = b.readEmbeddedIndex(headerInFile)
+		}
+		// Truncate the file so that the Readonly.backing has the right set of bytes to deal with.
 		// This effectively means resuming from a finalized file will wipe its index even if there
 		// are no blocks put unless the user calls finalize.
 		if err := b.f.Truncate(int64(headerInFile.DataOffset + headerInFile.DataSize)); err != nil {
@@ -268,58 +575,302 @@ func (b *ReadWrite) resumeWithRoots(v2 bool, roots []cid.Cid) error {
 		}
 	}
 
-	// TODO See how we can reduce duplicate code here.
-	// The code here comes from car.GenerateIndex.
-	// Copied because we need to populate an insertindex, not a sorted index.
-	// Producing a sorted index via generate, then converting it to insertindex is not possible.
-	// Because Index interface does not expose internal records.
-	// This may be done as part of https://github.com/ipld/go-car/issues/95
-
 	offset, err := carv1.HeaderSize(header)
 	if err != nil {
 		return err
 	}
-	sectionOffset := int64(0)
-	if sectionOffset, err = v1r.Seek(int64(offset), io.SeekStart); err != nil {
+
+	// Determine the size of data payload so that sections extending beyond it are detected.
+	// Note that any index present on file is truncated above; therefore, the payload spans until
+	// the end of file.
+	dataSize, err := b.f.Size()
+	if err != nil {
+		return err
+	}
+	if v2 {
+		dataSize -= int64(b.header.DataOffset)
+	}
+
+	// Restore as much of the index as possible from the existing index or the WAL, if any, and
+	// only scan the remaining sections in the data payload.
+	sectionOffset := int64(offset)
+	if b.opts.BlockstoreExistingIndex != nil {
+		if sectionOffset, err = b.adoptExistingIndex(b.opts.BlockstoreExistingIndex, v1r, sectionOffset, dataSize); err != nil {
+			return err
+		}
+	} else if embedded != nil {
+		// The embedded index is adopted on a best-effort basis: if it cannot be, e.g. because it
+		// does not record whole CIDs while they are in use, the data payload is scanned instead.
+		if adopted, err := b.adoptExistingIndex(embedded, v1r, sectionOffset, dataSize); err == nil {
+			sectionOffset = adopted
+		}
+	} else if b.wal != nil {
+		if sectionOffset, err = b.replayIndexWAL(v1r, sectionOffset, dataSize); err != nil {
+			return err
+		}
+	}
+	if sectionOffset, err = b.indexSections(v1r, sectionOffset, dataSize); err != nil {
 		return err
 	}
 
+	// Seek to the end of last skipped block where the writer should resume writing.
+	_, err = b.dataWriter.Seek(sectionOffset, io.SeekStart)
+	return err
+}
+
+// replayIndexWAL restores the index records from the WAL, given the offset at which the first
+// section starts and the size of the data payload. It returns the offset immediately after the
+// last section restored, from which the remaining sections should be indexed by scanning the data
+// payload.
+//
+// Records must describe contiguous sections that fit within the data payload. Records from the
+// first one that does not onwards are discarded. The last restored record is verified against
+// the data payload; if it does not match, the WAL is discarded entirely.
+func (b *ReadWrite) replayIndexWAL(v1r internalio.ReadSeekerAt, firstSectionOffset, dataSize int64) (int64, error) {
+	var records []indexWALRecord
+	next := firstSectionOffset
+	err := b.wal.replay(func(rec indexWALRecord) bool {
+		if rec.offset != uint64(next) || rec.length == 0 || rec.length > uint64(dataSize-next) {
+			return false
+		}
+		end := next + int64(varint.UvarintSize(rec.length)) + int64(rec.length)
+		if end > dataSize {
+			return false
+		}
+		records = append(records, rec)
+		next = end
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return firstSectionOffset, nil
+	}
+
+	// Verify the last record against the section on file, since the WAL may have been persisted
+	// while the data it refers to was not.
+	last := records[len(records)-1]
+	if _, err := v1r.Seek(int64(last.offset), io.SeekStart); err != nil {
+		return 0, err
+	}
+	length, _, err := util.ReadUvarint(v1r, b.ronly.opts.LenientVarints)
+	if err == nil && length == last.length {
+		var c cid.Cid
+		if _, c, err = cid.CidFromReader(v1r); err == nil && c.Equals(last.cid) {
+			for _, rec := range records {
+				b.insertRecord(rec.cid, rec.offset, rec.length)
+			}
+			return next, nil
+		}
+	}
+
+	// The WAL does not match the data payload; discard it and rescan the payload entirely.
+	if err := b.wal.reset(); err != nil {
+		return 0, err
+	}
+	return firstSectionOffset, nil
+}
+
+// readEmbeddedIndex reads the index embedded in the finalized file with the given CARv2 header. It
+// returns nil if the index cannot be read, e.g. because it is corrupt or its codec is unknown.
+func (b *ReadWrite) readEmbeddedIndex(header carv2.Header) index.Index {
+	size, err := b.f.Size()
+	if err != nil {
+		return nil
+	}
+	idx, err := index.ReadFrom(io.NewSectionReader(b.f, int64(header.IndexOffset), header.IndexSize(size)))
+	if err != nil {
+		return nil
+	}
+	return idx
+}
+
+// adoptExistingIndex restores the index records from the given existing index, i.e. the index given
+// via WithExistingIndex or the one embedded in a finalized file, given the offset at which the first
+// section starts and the size of the data payload. It returns the offset immediately after the
+// section with the largest offset in the index, from which the remaining sections should be indexed
+// by scanning the data payload.
+//
+// Every record must fall within the data payload, and the record with the largest offset is
+// verified against the data payload; an error is returned otherwise, in which case no records are
+// restored. The index must record whole CIDs if they are in use, since exact-CID deduplication
+// cannot be guaranteed otherwise.
+func (b *ReadWrite) adoptExistingIndex(existing index.Index, v1r internalio.ReadSeekerAt, firstSectionOffset, dataSize int64) (int64, error) {
+	iterable, ok := existing.(index.IterableIndex)
+	if !ok {
+		return 0, fmt.Errorf("existing index of type %T does not support iteration", existing)
+	}
+	wholeCIDs := b.opts.BlockstoreUseWholeCIDs || b.opts.IndexCodec == index.CarCidIndexSorted
+	if wholeCIDs && !index.StoresWholeCIDs(existing) {
+		return 0, fmt.Errorf("existing index of type %T does not record whole CIDs", existing)
+	}
+
+	// Records are populated with as much information as the index stores. If only the multihash of
+	// CIDs is known, records have CIDs of codec cid.Raw; since whole CIDs are not in use, records
+	// are only ever looked up by multihash.
+	records, err := index.OffsetOrdered(iterable)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return firstSectionOffset, nil
+	}
+	for _, offset := range []uint64{records[0].Offset, records[len(records)-1].Offset} {
+		if offset < uint64(firstSectionOffset) || offset >= uint64(dataSize) {
+			return 0, fmt.Errorf("existing index does not match data payload; "+
+				"record offset %d is not within sections of data payload in range [%d, %d)",
+				offset, firstSectionOffset, dataSize)
+		}
+	}
+
+	// Verify the last record against the section on file, and find where the section ends.
+	lastRecord := &records[len(records)-1]
+	if _, err := v1r.Seek(int64(lastRecord.Offset), io.SeekStart); err != nil {
+		return 0, err
+	}
+	length, lengthLen, err := util.ReadUvarint(v1r, b.ronly.opts.LenientVarints)
+	if err != nil || length == 0 || length > uint64(dataSize)-lastRecord.Offset {
+		return 0, fmt.Errorf("existing index does not match data payload; "+
+			"no valid section at record offset %d", lastRecord.Offset)
+	}
+	next := int64(lastRecord.Offset) + int64(lengthLen) + int64(length)
+	_, c, err := cid.CidFromReader(v1r)
+	if err != nil || next > dataSize || !bytes.Equal(c.Hash(), lastRecord.Cid.Hash()) ||
+		(wholeCIDs && !c.Equals(lastRecord.Cid)) || (lastRecord.Size != 0 && lastRecord.Size != length) {
+		return 0, fmt.Errorf("existing index does not match data payload; "+
+			"section at record offset %d does not match its record", lastRecord.Offset)
+	}
+	lastRecord.Size = length
+
+	for _, r := range records {
+		b.insertRecord(r.Cid, r.Offset, r.Size)
+	}
+	return next, nil
+}
+
+// insertRecord inserts a record of the section at the given offset into the index, unless its CID
+// has multihash.IDENTITY code and StoreIdentityCIDs is disabled, consistently with
+// car.GenerateIndex. It is used to index sections that are already in the data payload; sections
+// with identity CIDs are not written by Put unless StoreIdentityCIDs is enabled, but may be written
+// by PutAt or be present in a resumed file, in which case they are recorded in b.unindexed instead.
+func (b *ReadWrite) insertRecord(c cid.Cid, offset, size uint64) {
+	if !b.opts.StoreIdentityCIDs && c.Prefix().MhType == multihash.IDENTITY {
+		b.unindexed = append(b.unindexed, index.Record{Cid: c, Offset: offset, Size: size})
+		return
+	}
+	b.idx.InsertSizedNoReplace(c, offset, size)
+}
+
+// indexSections indexes the sections in data payload, starting from the given offset until the
+// end of data payload. It returns the offset immediately after the last indexed section.
+// If a WAL is in use, the records of indexed sections are appended to it.
+// The progress of indexing is reported, and cancellation checked, as configured via
+// carv2.WithIndexProgress and carv2.WithIndexContext.
+func (b *ReadWrite) indexSections(v1r internalio.ReadSeekerAt, sectionOffset, dataSize int64) (int64, error) {
+	// Note that while an index generated via car.GenerateIndex could be converted via
+	// index.InsertionIndexFrom, sections are scanned here instead: unlike car.GenerateIndex, every
+	// section is strictly validated against the data payload, its size is recorded, and the offset
+	// at which to resume writing is tracked.
+
+	if _, err := v1r.Seek(sectionOffset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var sections, reported uint64
+	progress := b.opts.IndexProgress
 	for {
-		// Grab the length of the section.
-		// Note that ReadUvarint wants a ByteReader.
-		length, err := varint.ReadUvarint(v1r)
+		if ctx := b.opts.IndexContext; ctx != nil {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+
+		// Grab the length of the section, and the number of bytes it spans, which may be larger
+		// than its minimal encoding if varints are read leniently.
+		length, lengthLen, err := util.ReadUvarint(v1r, b.ronly.opts.LenientVarints)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return 0, &carv2.ErrCorruptSection{
+				Offset: uint64(sectionOffset),
+				Reason: fmt.Sprintf("cannot read section length: %v", err),
+			}
 		}
 
 		// Null padding; by default it's an error.
 		if length == 0 {
-			if b.ronly.opts.ZeroLengthSectionAsEOF {
+			if b.ronly.opts.SkipNullPadding {
+				// Skip over the bytes of the zero length.
+				sectionOffset += int64(lengthLen)
+				continue
+			} else if b.ronly.opts.ZeroLengthSectionAsEOF || b.opts.BlockstoreExpectedSize > 0 {
+				// Preallocated space after the blocks written reads as null padding.
 				break
 			} else {
-				return fmt.Errorf("carv1 null padding not allowed by default; see WithZeroLegthSectionAsEOF")
+				return 0, fmt.Errorf("carv1 null padding not allowed by default; see WithZeroLegthSectionAsEOF")
 			}
 		}
 
-		// Grab the CID.
+		// Grab the CID, and check that it is plausible before indexing it.
+		// Since the length of a section is trusted to find the next one, a wrong length would
+		// otherwise result in decoding CIDs from the middle of block data.
 		n, c, err := cid.CidFromReader(v1r)
 		if err != nil {
-			return err
+			return 0, &carv2.ErrCorruptSection{
+				Offset: uint64(sectionOffset),
+				Reason: fmt.Sprintf("cannot decode CID: %v", err),
+			}
+		}
+		if uint64(n) > b.opts.MaxIndexCidSize {
+			return 0, &carv2.ErrCorruptSection{
+				Offset: uint64(sectionOffset),
+				Reason: fmt.Sprintf("CID size is larger than max allowed (%d > %d)", n, b.opts.MaxIndexCidSize),
+			}
+		}
+		if uint64(n) > length {
+			return 0, &carv2.ErrCorruptSection{
+				Offset: uint64(sectionOffset),
+				Reason: fmt.Sprintf("CID size is larger than section length (%d > %d)", n, length),
+			}
 		}
-		b.idx.insertNoReplace(c, uint64(sectionOffset))
 
-		// Seek to the next section by skipping the block.
+		// Seek to the next section by skipping the block, and check that it starts strictly after
+		// the current section and within the data payload.
 		// The section length includes the CID, so subtract it.
-		if sectionOffset, err = v1r.Seek(int64(length)-int64(n), io.SeekCurrent); err != nil {
-			return err
+		var nextSectionOffset int64
+		if uint64(lengthLen)+length <= uint64(dataSize-sectionOffset) {
+			if nextSectionOffset, err = v1r.Seek(int64(length)-int64(n), io.SeekCurrent); err != nil {
+				return 0, err
+			}
+		}
+		if nextSectionOffset <= sectionOffset || nextSectionOffset > dataSize {
+			return 0, &carv2.ErrCorruptSection{
+				Offset: uint64(sectionOffset),
+				Reason: fmt.Sprintf("section length %d does not fit within data payload of size %d", length, dataSize),
+			}
+		}
+		b.insertRecord(c, uint64(sectionOffset), length)
+		// Note that every section is appended to the WAL regardless, since it is replayed as a
+		// contiguous sequence of sections.
+		if b.wal != nil {
+			if err := b.wal.append(c, uint64(sectionOffset), length); err != nil {
+				return 0, err
+			}
+		}
+		sectionOffset = nextSectionOffset
+
+		sections++
+		if progress != nil && sections%b.opts.IndexProgressInterval == 0 {
+			progress(sections, sectionOffset, dataSize)
+			reported = sections
 		}
 	}
-	// Seek to the end of last skipped block where the writer should resume writing.
-	_, err = b.dataWriter.Seek(sectionOffset, io.SeekStart)
-	return err
+	if progress != nil && (reported != sections || sections == 0) {
+		progress(sections, sectionOffset, dataSize)
+	}
+	return sectionOffset, nil
 }
 
 func (b *ReadWrite) unfinalize() error {
@@ -327,30 +878,76 @@ func (b *ReadWrite) unfinalize() error {
 	return err
 }
 
+// PutResult describes the outcome of putting a block via ReadWrite.PutWithResult.
+type PutResult struct {
+	// Written is true if the block was written to the data payload, and false if it was not, i.e.
+	// because it was deduplicated against a block put earlier, or because it has an identity CID
+	// which is not stored unless StoreIdentityCIDs is enabled.
+	Written bool
+	// Offset is the offset of the section of the block in the data payload if it was written, in
+	// the same form as the offsets recorded by the index. It is zero if the block was not written.
+	Offset uint64
+}
+
 // Put puts a given block to the underlying datastore
 func (b *ReadWrite) Put(ctx context.Context, blk blocks.Block) error {
 	// PutMany already checks b.ronly.closed.
 	return b.PutMany(ctx, []blocks.Block{blk})
 }
 
+// PutWithResult puts a given block just like Put, and reports whether the block was written or
+// deduplicated, along with the offset at which it was written.
+// See PutResult.
+func (b *ReadWrite) PutWithResult(ctx context.Context, blk blocks.Block) (PutResult, error) {
+	var results [1]PutResult
+	err := b.putManyWithHook([]blocks.Block{blk}, results[:])
+	return results[0], err
+}
+
 // PutMany puts a slice of blocks at the same time using batching
 // capabilities of the underlying datastore whenever possible.
 func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
+	return b.putManyWithHook(blks, nil)
+}
+
+// putManyWithHook puts the given blocks via putMany, and reports them to the put hook, if any.
+func (b *ReadWrite) putManyWithHook(blks []blocks.Block, results []PutResult) error {
+	put, err := b.putMany(blks, results)
+	if hook := b.opts.BlockstorePutHook; hook != nil {
+		for i, bl := range blks[:put] {
+			var blkErr error
+			if i == put-1 {
+				// Only the last block attempted may have failed.
+				blkErr = err
+			}
+			hook(bl.Cid(), len(bl.RawData()), blkErr)
+		}
+	}
+	return err
+}
+
+// putMany puts the given blocks in order, stopping at the first block that fails. It returns the
+// number of blocks attempted, including the failed one if any. If results is not nil, the outcome
+// of putting each block is stored at the same position in it; it must be as long as blks.
+func (b *ReadWrite) putMany(blks []blocks.Block, results []PutResult) (int, error) {
 	b.ronly.mu.Lock()
 	defer b.ronly.mu.Unlock()
 
 	if b.ronly.closed {
-		return errClosed
+		if len(blks) == 0 {
+			return 0, errClosed
+		}
+		return 1, errClosed
 	}
 
-	for _, bl := range blks {
+	for i, bl := range blks {
 		c := bl.Cid()
 
 		// If StoreIdentityCIDs option is disabled then treat IDENTITY CIDs like IdStore.
 		if !b.opts.StoreIdentityCIDs {
 			// Check for IDENTITY CID. If IDENTITY, ignore and move to the next block.
 			if _, ok, err := isIdentity(c); err != nil {
-				return err
+				return i + 1, err
 			} else if ok {
 				continue
 			}
@@ -362,14 +959,22 @@ func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
 		// Since multhihash codes other than IDENTITY can result in large digests.
 		cSize := uint64(len(c.Bytes()))
 		if cSize > b.opts.MaxIndexCidSize {
-			return &carv2.ErrCidTooLarge{MaxSize: b.opts.MaxIndexCidSize, CurrentSize: cSize}
+			return i + 1, &carv2.ErrCidTooLarge{MaxSize: b.opts.MaxIndexCidSize, CurrentSize: cSize}
+		}
+
+		// Check that the block data matches its CID before it is written, if enabled.
+		if b.opts.BlockstoreVerifyPutHashes {
+			if err := verifyHash(c, bl.RawData()); err != nil {
+				return i + 1, err
+			}
 		}
 
 		if !b.opts.BlockstoreAllowDuplicatePuts {
-			if b.ronly.opts.BlockstoreUseWholeCIDs && b.idx.hasExactCID(c) {
+			wholeCIDs := b.ronly.opts.BlockstoreUseWholeCIDs || b.ronly.opts.BlockstoreStrictCodecMatch
+			if wholeCIDs && b.idx.HasExactCID(c) {
 				continue // deduplicated by CID
 			}
-			if !b.ronly.opts.BlockstoreUseWholeCIDs {
+			if !wholeCIDs {
 				_, err := b.idx.Get(c)
 				if err == nil {
 					continue // deduplicated by hash
@@ -379,9 +984,142 @@ func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
 
 		n := uint64(b.dataWriter.Position())
 		if err := util.LdWrite(b.dataWriter, c.Bytes(), bl.RawData()); err != nil {
+			return i + 1, err
+		}
+		size := cSize + uint64(len(bl.RawData()))
+		b.idx.InsertSizedNoReplace(c, n, size)
+		if results != nil {
+			results[i] = PutResult{Written: true, Offset: n}
+		}
+		if b.wal != nil {
+			if err := b.wal.append(c, n, size); err != nil {
+				return i + 1, err
+			}
+		}
+	}
+
+	if interval := b.opts.BlockstoreSyncInterval; interval > 0 {
+		if b.putManyCalls++; b.putManyCalls >= interval {
+			b.putManyCalls = 0
+			if err := b.syncer.Sync(); err != nil {
+				return len(blks), err
+			}
+		}
+	}
+	return len(blks), nil
+}
+
+// PutAt writes the given block as a section starting at the given offset of the data payload, in
+// the same form as the offsets recorded by the index, and records it in the index at that offset.
+// This allows reconstructing a known layout of sections, e.g. in order to rewrite a CAR without
+// moving its blocks, or to patch some of its sections in place. Unlike Put, the block is written
+// even if a block with the same CID was put earlier, and identity CIDs are written as any other
+// CID, although they are only indexed if StoreIdentityCIDs is enabled.
+//
+// The offset must not precede the end of the CARv1 header, and the section must not overlap any
+// section recorded by the index, nor any unindexed section of an identity CID written by PutAt or
+// present in a resumed file; an error is returned otherwise. Sections recorded without a size,
+// e.g. by a resumed index, are assumed to extend up to the following recorded section. Since
+// every record is visited in order to find overlaps, PutAt takes time linear in the number of
+// blocks put so far.
+//
+// Blocks put via Put after a block put via PutAt are written after the end of the section with the
+// greatest end offset, such that they never overlap blocks put via PutAt. Any gap left between
+// sections reads as null padding, which readers reject by default; callers must fill every gap
+// before calling Finalize, unless the file is read with WithSkipNullPadding.
+func (b *ReadWrite) PutAt(offset uint64, blk blocks.Block) error {
+	err := b.putAt(offset, blk)
+	if hook := b.opts.BlockstorePutHook; hook != nil {
+		hook(blk.Cid(), len(blk.RawData()), err)
+	}
+	return err
+}
+
+func (b *ReadWrite) putAt(offset uint64, blk blocks.Block) error {
+	b.ronly.mu.Lock()
+	defer b.ronly.mu.Unlock()
+
+	if b.ronly.closed {
+		return errClosed
+	}
+
+	c := blk.Cid()
+	cSize := uint64(len(c.Bytes()))
+	if cSize > b.opts.MaxIndexCidSize {
+		return &carv2.ErrCidTooLarge{MaxSize: b.opts.MaxIndexCidSize, CurrentSize: cSize}
+	}
+	if b.opts.BlockstoreVerifyPutHashes {
+		if err := verifyHash(c, blk.RawData()); err != nil {
+			return err
+		}
+	}
+
+	rdr, err := b.ronly.seekFirstSection()
+	if err != nil {
+		return err
+	}
+	headerEnd, err := rdr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if offset < uint64(headerEnd) {
+		return fmt.Errorf("cannot put block at offset %d within CARv1 header ending at offset %d", offset, headerEnd)
+	}
+
+	// Check the section preceding the offset and the one preceding the end of the new section.
+	size := cSize + uint64(len(blk.RawData()))
+	end := offset + uint64(varint.UvarintSize(size)) + size
+	for _, at := range []uint64{offset, end - 1} {
+		r, contains, err := b.idx.FindByOffset(at)
+		if err != nil {
 			return err
 		}
-		b.idx.insertNoReplace(c, n)
+		if contains || (r.Cid.Defined() && r.Offset >= offset) {
+			return fmt.Errorf("cannot put block at offset %d; section of %s at offset %d overlaps range [%d, %d)",
+				offset, r.Cid, r.Offset, offset, end)
+		}
+	}
+	for _, r := range b.unindexed {
+		rEnd := r.Offset + uint64(varint.UvarintSize(r.Size)) + r.Size
+		if r.Offset < end && offset < rEnd {
+			return fmt.Errorf("cannot put block at offset %d; section of %s at offset %d overlaps range [%d, %d)",
+				offset, r.Cid, r.Offset, offset, end)
+		}
+	}
+
+	pos := b.dataWriter.Position()
+	if _, err := b.dataWriter.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	err = util.LdWrite(b.dataWriter, c.Bytes(), blk.RawData())
+	// Keep writing sequential puts after every section written so far.
+	if b.dataWriter.Position() < pos {
+		if _, serr := b.dataWriter.Seek(pos, io.SeekStart); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	b.insertRecord(c, offset, size)
+	if b.wal != nil {
+		return b.wal.append(c, offset, size)
+	}
+	return nil
+}
+
+// verifyHash checks that the given data hashes to the multihash of c, with the same multihash code
+// and length, and returns a *carv2.ErrHashMismatch error otherwise. Identity CIDs are not verified.
+func verifyHash(c cid.Cid, data []byte) error {
+	if _, ok, err := isIdentity(c); err != nil || ok {
+		return err
+	}
+	hashed, err := c.Prefix().Sum(data)
+	if err != nil {
+		return fmt.Errorf("cannot verify hash of %s: %w", c, err)
+	}
+	if !bytes.Equal(hashed.Hash(), c.Hash()) {
+		return &carv2.ErrHashMismatch{Cid: c, Actual: hashed.Hash()}
 	}
 	return nil
 }
@@ -389,27 +1127,72 @@ func (b *ReadWrite) PutMany(ctx context.Context, blks []blocks.Block) error {
 // Discard closes this blockstore without finalizing its header and index.
 // After this call, the blockstore can no longer be used.
 //
-// Note that this call may block if any blockstore operations are currently in
-// progress, including an AllKeysChan that hasn't been fully consumed or cancelled.
+// Any AllKeysChan that hasn't been fully consumed or cancelled is stopped.
+// Note that this call may block if any other blockstore operations are currently in progress.
 func (b *ReadWrite) Discard() {
 	// Same semantics as ReadOnly.Close, including allowing duplicate calls.
 	// The only difference is that our method is called Discard,
 	// to further clarify that we're not properly finalizing and writing a
 	// CARv2 file.
 	b.ronly.Close()
+	// Keep the index WAL, if any, so that it can be used to resume later on.
+	b.closeIndexWAL()
 }
 
 // Finalize finalizes this blockstore by writing the CARv2 header, along with flattened index
-// for more efficient subsequent read.
+// for more efficient subsequent read. The index is checksummed, unless disabled via
+// WithIndexChecksum, and the file is synced to disk if enabled via WithSyncOnFinalize. If set via
+// WithTraversalOrder, the data payload is first rewritten in traversal order. If enabled via
+// carv2.WithDataChecksum, a checksum of the data payload is written ahead of the index.
 // After this call, the blockstore can no longer be used.
+//
+// See FinalizeContext to finalize with cancellation.
 func (b *ReadWrite) Finalize() error {
+	return b.FinalizeContext(context.Background())
+}
+
+// FinalizeContext is similar to Finalize, except that finalization is aborted once ctx is done, in
+// which case ctx.Err() is returned. Cancellation is observed while the index is written, at least
+// once every chunk of up to 64 KiB written, while the data payload is read to compute its checksum
+// if enabled, and between flattening and writing the index for codecs other than the default one;
+// once the index is written, finalization carries on to completion.
+//
+// A cancelled FinalizeContext leaves the file unfinalized: the partially written index is
+// truncated away and the CARv2 header is left as is, such that the file can be resumed from via
+// OpenReadWrite. The blockstore itself is left open, as if FinalizeContext was never called, except
+// that any in-flight AllKeysChan is stopped; therefore, blocks may still be put, and FinalizeContext
+// can be retried, or the blockstore discarded via Discard.
+//
+// If finalization fails for any other reason, the blockstore can no longer be used, as with
+// Finalize.
+func (b *ReadWrite) FinalizeContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.opts.BlockstoreTraversalRoot.Defined() {
+		if err := b.rewriteInTraversalOrder(ctx); err != nil {
+			return err
+		}
+	}
 	if b.opts.WriteAsCarV1 {
 		// all blocks are already properly written to the CARv1 inner container and there's
 		// no additional finalization required at the end of the file for a complete v1
+		if b.opts.BlockstoreExpectedSize > 0 || b.opts.BlockstoreSyncOnFinalize {
+			var err error
+			b.ronly.mu.Lock()
+			if !b.ronly.closed {
+				err = b.finalizeFile(b.dataWriter.Position())
+			}
+			b.ronly.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
 		b.ronly.Close()
-		return nil
+		return b.removeIndexWAL()
 	}
 
+	b.ronly.signalClose()
 	b.ronly.mu.Lock()
 	defer b.ronly.mu.Unlock()
 
@@ -420,35 +1203,199 @@ func (b *ReadWrite) Finalize() error {
 	}
 
 	// TODO check if add index option is set and don't write the index then set index offset to zero.
-	b.header = b.header.WithDataSize(uint64(b.dataWriter.Position()))
-	b.header.Characteristics.SetFullyIndexed(b.opts.StoreIdentityCIDs)
-
-	// Note that we can't use b.Close here, as that tries to grab the same
-	// mutex we're holding here.
-	defer b.ronly.closeWithoutMutex()
+	// Note that the header is only updated once the index is written, so that finalization can be
+	// retried if cancelled.
+	header := b.header.WithDataSize(uint64(b.dataWriter.Position()))
+	header.Characteristics.SetFullyIndexed(b.opts.StoreIdentityCIDs)
+	// The checksum of the data payload, if any, is written right after it, as index padding.
+	checksumSize := b.dataChecksumSize()
+	header = header.WithIndexPadding(checksumSize)
 
 	// TODO if index not needed don't bother flattening it.
-	fi, err := b.idx.flatten(b.opts.IndexCodec)
+	// Note that the index is written without materializing a flattened copy of it, unless the
+	// index codec is other than the default; see index.InsertionIndex.WriteFlattenedTo.
+	writeIndex := b.idx.WriteFlattenedToWithChecksum
+	if b.opts.BlockstoreDisableIndexChecksum {
+		writeIndex = b.idx.WriteFlattenedTo
+	}
+	iw := contextWriter{ctx: ctx, w: internalio.NewOffsetWriter(b.f, int64(header.IndexOffset))}
+	writeChecksumAndIndex := func() (int64, error) {
+		if checksumSize > 0 {
+			data := contextReader{ctx: ctx, r: io.NewSectionReader(b.f, int64(header.DataOffset), int64(header.DataSize))}
+			cw := internalio.NewOffsetWriter(b.f, int64(header.DataOffset+header.DataSize))
+			if _, err := carv2.WriteDataChecksum(cw, data, b.opts.DataChecksum); err != nil {
+				return 0, err
+			}
+		}
+		return writeIndex(iw, b.opts.IndexCodec)
+	}
+	indexSize, err := writeChecksumAndIndex()
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// Truncate the data checksum and partially written index away, leaving the data
+			// payload as is.
+			if err := b.f.Truncate(int64(header.DataOffset + header.DataSize)); err != nil {
+				b.abortFinalize()
+				return err
+			}
+			b.ronly.resetCloseSignal()
+			return ctxErr
+		}
+		b.abortFinalize()
 		return err
 	}
-	if _, err := index.WriteTo(fi, internalio.NewOffsetWriter(b.f, int64(b.header.IndexOffset))); err != nil {
+	b.header = header
+
+	// Note that we can't use b.Close here, as that tries to grab the same
+	// mutex we're holding here.
+	defer b.ronly.closeWithoutMutex()
+	// Keep the index WAL, if any, should finalization fail.
+	defer b.closeIndexWAL()
+
+	if _, err := b.header.WriteTo(internalio.NewOffsetWriter(b.f, carv2.PragmaSize)); err != nil {
 		return err
 	}
-	if _, err := b.header.WriteTo(internalio.NewOffsetWriter(b.f, carv2.PragmaSize)); err != nil {
+	if err := b.finalizeFile(int64(b.header.IndexOffset) + indexSize); err != nil {
 		return err
 	}
 
 	if err := b.ronly.closeWithoutMutex(); err != nil {
 		return err
 	}
+	return b.removeIndexWAL()
+}
+
+// abortFinalize closes this blockstore after finalization failed, keeping the index WAL, if any.
+// It must be called with b.ronly.mu held.
+func (b *ReadWrite) abortFinalize() {
+	b.closeIndexWAL()
+	_ = b.ronly.closeWithoutMutex()
+}
+
+// dataChecksumSize returns the size of the checksum of the data payload written on Finalize, or zero
+// if disabled.
+func (b *ReadWrite) dataChecksumSize() uint64 {
+	if b.opts.DataChecksum == 0 {
+		return 0
+	}
+	// The algorithm is checked by OpenReadWrite.
+	size, _ := carv2.DataChecksumSize(b.opts.DataChecksum)
+	return size
+}
+
+// contextReader is an io.Reader that fails with the error of its context once it is done, such that
+// long reads can be cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// contextWriter is an io.Writer that writes in chunks of up to maxContextWriteChunk bytes, and
+// fails with the error of its context once it is done, such that long writes can be cancelled.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+const maxContextWriteChunk = 64 << 10
+
+func (cw contextWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if err := cw.ctx.Err(); err != nil {
+			return written, err
+		}
+		chunk := p
+		if len(chunk) > maxContextWriteChunk {
+			chunk = chunk[:maxContextWriteChunk]
+		}
+		n, err := cw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// finalizeFile truncates any preallocated space beyond the given size of the finalized file, and
+// syncs the file if enabled via WithSyncOnFinalize.
+func (b *ReadWrite) finalizeFile(size int64) error {
+	if b.opts.BlockstoreExpectedSize > 0 {
+		if err := b.f.Truncate(size); err != nil {
+			return err
+		}
+	}
+	if b.opts.BlockstoreSyncOnFinalize {
+		return b.syncer.Sync()
+	}
+	return nil
+}
+
+// removeIndexWAL removes the index WAL, if any, once its records are no longer needed.
+func (b *ReadWrite) removeIndexWAL() error {
+	if b.wal == nil {
+		return nil
+	}
+	wal := b.wal
+	b.wal = nil
+	if err := wal.remove(); err != nil {
+		return fmt.Errorf("could not remove index WAL: %w", err)
+	}
 	return nil
 }
 
+// closeIndexWAL closes the index WAL, if any, leaving it in place.
+func (b *ReadWrite) closeIndexWAL() {
+	if b.wal != nil {
+		_ = b.wal.close()
+		b.wal = nil
+	}
+}
+
 func (b *ReadWrite) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
 	return b.ronly.AllKeysChan(ctx)
 }
 
+// EachBlock calls fn for every block put so far, in the order in which they were written.
+// See ReadOnly.EachBlock.
+func (b *ReadWrite) EachBlock(ctx context.Context, fn func(c cid.Cid, data []byte, offset uint64) error) error {
+	return b.ronly.EachBlock(ctx, fn)
+}
+
+// CidAt returns the CID of the block whose section contains the given offset, along with the
+// offset of the start of that section.
+// See ReadOnly.CidAt.
+func (b *ReadWrite) CidAt(offset uint64) (cid.Cid, uint64, error) {
+	return b.ronly.CidAt(offset)
+}
+
+// Codecs returns the number of blocks put so far by the codec of their CID.
+// See ReadOnly.Codecs.
+func (b *ReadWrite) Codecs() (map[uint64]int, error) {
+	return b.ronly.Codecs()
+}
+
+// Offsets returns the offsets of the sections put so far for the given key.
+// See ReadOnly.Offsets.
+func (b *ReadWrite) Offsets(key cid.Cid) ([]uint64, error) {
+	return b.ronly.Offsets(key)
+}
+
+// ForEachOffset calls f with the offset of each section put so far for the given key.
+// See ReadOnly.ForEachOffset.
+func (b *ReadWrite) ForEachOffset(ctx context.Context, key cid.Cid, limit uint64, f func(offset uint64) error) error {
+	return b.ronly.ForEachOffset(ctx, key, limit, f)
+}
+
 func (b *ReadWrite) Has(ctx context.Context, key cid.Cid) (bool, error) {
 	return b.ronly.Has(ctx, key)
 }
@@ -457,10 +1404,40 @@ func (b *ReadWrite) Get(ctx context.Context, key cid.Cid) (blocks.Block, error)
 	return b.ronly.Get(ctx, key)
 }
 
+// View calls callback with the raw data of the block corresponding to the given key.
+// See ReadOnly.View. Block data is always copied, hence callback may put blocks.
+func (b *ReadWrite) View(ctx context.Context, key cid.Cid, callback func([]byte) error) error {
+	return b.ronly.View(ctx, key, callback)
+}
+
 func (b *ReadWrite) GetSize(ctx context.Context, key cid.Cid) (int, error) {
 	return b.ronly.GetSize(ctx, key)
 }
 
+// HasMultihash indicates if the store contains a block with the given multihash.
+// See ReadOnly.HasMultihash.
+func (b *ReadWrite) HasMultihash(mh multihash.Multihash) (bool, error) {
+	return b.ronly.HasMultihash(mh)
+}
+
+// GetSizeMultihash gets the size of the block with the given multihash.
+// See ReadOnly.GetSizeMultihash.
+func (b *ReadWrite) GetSizeMultihash(mh multihash.Multihash) (int, error) {
+	return b.ronly.GetSizeMultihash(mh)
+}
+
+// GetByMultihash gets the first block with the given multihash, along with its CID.
+// See ReadOnly.GetByMultihash.
+func (b *ReadWrite) GetByMultihash(mh multihash.Multihash) (cid.Cid, []byte, error) {
+	return b.ronly.GetByMultihash(mh)
+}
+
+// GetAllByMultihash gets every block with the given multihash.
+// See ReadOnly.GetAllByMultihash.
+func (b *ReadWrite) GetAllByMultihash(mh multihash.Multihash) ([]blocks.Block, error) {
+	return b.ronly.GetAllByMultihash(mh)
+}
+
 func (b *ReadWrite) DeleteBlock(_ context.Context, _ cid.Cid) error {
 	return fmt.Errorf("ReadWrite blockstore does not support deleting blocks")
 }
@@ -472,3 +1449,72 @@ func (b *ReadWrite) HashOnRead(enable bool) {
 func (b *ReadWrite) Roots() ([]cid.Cid, error) {
 	return b.ronly.Roots()
 }
+
+// MissingRoots returns the roots of this blockstore whose blocks have not been put.
+// See ReadOnly.MissingRoots.
+func (b *ReadWrite) MissingRoots() ([]cid.Cid, error) {
+	return b.ronly.MissingRoots()
+}
+
+// Len returns the number of index records of blocks put so far, including blocks resumed from an
+// existing file. See ReadOnly.Len.
+func (b *ReadWrite) Len() (uint64, error) {
+	return b.ronly.Len()
+}
+
+// IndexMemory returns the estimated heap memory held by the index of blocks put so far.
+// See ReadOnly.IndexMemory.
+func (b *ReadWrite) IndexMemory() (uint64, error) {
+	return b.ronly.IndexMemory()
+}
+
+// Index returns a snapshot of the index of blocks put so far, including blocks resumed from an
+// existing file, flattened in the codec set via carv2.UseIndexCodec, i.e. the index that Finalize
+// would write should no more blocks be put. As in the finalized file, offsets are relative to the
+// beginning of the data payload.
+//
+// The snapshot is taken consistently with concurrent puts, and is independent of this blockstore:
+// it can be queried or persisted, e.g. via index.WriteTo, without finalizing, and is not affected by
+// blocks put afterwards. Note that flattening takes time and memory linear in the number of
+// records.
+func (b *ReadWrite) Index() (index.Index, error) {
+	b.ronly.mu.RLock()
+	defer b.ronly.mu.RUnlock()
+
+	if b.ronly.closed {
+		return nil, errClosed
+	}
+	return b.idx.Flatten(b.opts.IndexCodec)
+}
+
+// EstimatedFinalSize returns an estimate of the size of the file once finalized via Finalize, given
+// the blocks put so far. For a CARv2, it is the size of the CARv2 header and data payload, along
+// with their padding, plus the size of the index in the codec set via carv2.UseIndexCodec, which is
+// computed from the number of index records by the layout of their CIDs without flattening the
+// index, and includes the index checksum unless disabled via WithIndexChecksum, as well as the
+// checksum of the data payload if enabled via carv2.WithDataChecksum. For a CARv1 written via
+// carv2.WriteAsCarV1, it is the size of the data payload.
+//
+// The estimate is exact for the index codecs defined by the index package, and for other codecs
+// whose index can be flattened, as long as no more blocks are put. If the index cannot be
+// flattened in the chosen codec, the estimate excludes the index and is therefore a lower bound.
+// When WithTraversalOrder is set, Finalize drops the blocks that are not reachable from the
+// traversal root, such that the estimate is an upper bound.
+func (b *ReadWrite) EstimatedFinalSize() uint64 {
+	b.ronly.mu.RLock()
+	defer b.ronly.mu.RUnlock()
+
+	dataSize := uint64(b.dataWriter.Position())
+	if b.opts.WriteAsCarV1 {
+		return dataSize
+	}
+	indexSize := b.idx.FlattenedSizeWithChecksum
+	if b.opts.BlockstoreDisableIndexChecksum {
+		indexSize = b.idx.FlattenedSize
+	}
+	size := b.header.WithDataSize(dataSize).WithIndexPadding(b.dataChecksumSize()).IndexOffset
+	if n, err := indexSize(b.opts.IndexCodec); err == nil {
+		size += n
+	}
+	return size
+}
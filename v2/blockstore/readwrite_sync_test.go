@@ -0,0 +1,79 @@
+package blockstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSyncFile wraps a file, counting the number of times it is synced.
+type countingSyncFile struct {
+	*os.File
+	syncs int
+}
+
+func (f *countingSyncFile) Sync() error {
+	f.syncs++
+	return f.File.Sync()
+}
+
+func TestReadWriteSync(t *testing.T) {
+	ctx := context.TODO()
+	blks := generateBlocks(t, 10)
+	roots := []cid.Cid{blks[0].Cid()}
+
+	tests := []struct {
+		name           string
+		opts           []carv2.Option
+		wantPutSyncs   int
+		wantFinalSyncs int
+	}{
+		{
+			name: "NoSyncByDefault",
+		},
+		{
+			name:           "SyncOnFinalize",
+			opts:           []carv2.Option{WithSyncOnFinalize()},
+			wantFinalSyncs: 1,
+		},
+		{
+			name:           "SyncOnFinalizeAsCarV1",
+			opts:           []carv2.Option{WithSyncOnFinalize(), WriteAsCarV1(true)},
+			wantFinalSyncs: 1,
+		},
+		{
+			name:           "SyncInterval",
+			opts:           []carv2.Option{WithSyncInterval(3)},
+			wantPutSyncs:   3,
+			wantFinalSyncs: 3,
+		},
+		{
+			name:           "SyncIntervalAndOnFinalize",
+			opts:           []carv2.Option{WithSyncInterval(3), WithSyncOnFinalize()},
+			wantPutSyncs:   3,
+			wantFinalSyncs: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-sync.car")
+			subject, err := OpenReadWrite(path, roots, tt.opts...)
+			require.NoError(t, err)
+			f := &countingSyncFile{File: subject.f.(*readWriteFile).File}
+			subject.syncer = f
+
+			// Put every block individually, i.e. one call per block.
+			for _, blk := range blks {
+				require.NoError(t, subject.Put(ctx, blk))
+			}
+			require.Equal(t, tt.wantPutSyncs, f.syncs)
+			require.NoError(t, subject.Finalize())
+			require.Equal(t, tt.wantFinalSyncs, f.syncs)
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package blockstore_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha512"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"os"
 	"path"
@@ -15,6 +18,7 @@ import (
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	ipfsblockstore "github.com/ipfs/go-ipfs-blockstore"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
@@ -22,8 +26,12 @@ import (
 	"github.com/ipld/go-car/v2/blockstore"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -654,6 +662,59 @@ func TestReadWriteWithPaddingWorksAsExpected(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestReadWriteWithInvalidPaddingIsError(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []carv2.Option
+		wantErr error
+	}{
+		{
+			name: "ZeroPadding",
+			opts: []carv2.Option{carv2.UseDataPadding(0), carv2.UseIndexPadding(0)},
+		},
+		{
+			name: "TypicalPadding",
+			opts: []carv2.Option{carv2.UseDataPadding(1413), carv2.UseIndexPadding(1314)},
+		},
+		{
+			name:    "AbsurdDataPadding",
+			opts:    []carv2.Option{carv2.UseDataPadding(math.MaxUint64)},
+			wantErr: &carv2.ErrPaddingTooLarge{MaxSize: carv2.DefaultMaxAllowedPadding, CurrentSize: math.MaxUint64},
+		},
+		{
+			name:    "AbsurdIndexPadding",
+			opts:    []carv2.Option{carv2.UseIndexPadding(math.MaxUint64)},
+			wantErr: &carv2.ErrPaddingTooLarge{MaxSize: carv2.DefaultMaxAllowedPadding, CurrentSize: math.MaxUint64},
+		},
+		{
+			name:    "PaddingLargerThanConfiguredMax",
+			opts:    []carv2.Option{carv2.UseDataPadding(1414), carv2.MaxAllowedPadding(1413)},
+			wantErr: &carv2.ErrPaddingTooLarge{MaxSize: 1413, CurrentSize: 1414},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-padding.car")
+			subject, err := blockstore.OpenReadWrite(path, []cid.Cid{oneTestBlockWithCidV1.Cid()}, tt.opts...)
+			if tt.wantErr != nil {
+				require.Equal(t, tt.wantErr, err)
+				require.Nil(t, subject)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, subject.Put(context.TODO(), oneTestBlockWithCidV1))
+			require.NoError(t, subject.Finalize())
+
+			robs, err := blockstore.OpenReadOnly(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, robs.Close()) })
+			gotBlock, err := robs.Get(context.TODO(), oneTestBlockWithCidV1.Cid())
+			require.NoError(t, err)
+			require.Equal(t, oneTestBlockWithCidV1.RawData(), gotBlock.RawData())
+		})
+	}
+}
+
 func TestReadWriteResumptionFromNonV2FileIsError(t *testing.T) {
 	tmpPath := requireTmpCopy(t, "../testdata/sample-rootless-v42.car")
 	subject, err := blockstore.OpenReadWrite(tmpPath, []cid.Cid{})
@@ -683,6 +744,46 @@ func TestReadWriteResumptionMismatchingRootsIsError(t *testing.T) {
 	require.Equal(t, origContent, newContent)
 }
 
+func TestReadWriteResumptionPreservesRootOrderAndDuplicates(t *testing.T) {
+	a := oneTestBlockWithCidV1.Cid()
+	b := anotherTestBlockWithCidV0.Cid()
+	wantRoots := []cid.Cid{a, b, a}
+	path := filepath.Join(t.TempDir(), "readwrite-resume-roots.car")
+
+	subject, err := blockstore.OpenReadWrite(path, wantRoots)
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(context.TODO(), oneTestBlockWithCidV1))
+	subject.Discard()
+
+	// Assert resumption with roots in a different order or without duplicates fails.
+	_, err = blockstore.OpenReadWrite(path, []cid.Cid{b, a, a})
+	require.EqualError(t, err, "cannot resume on file with mismatching data header")
+	_, err = blockstore.OpenReadWrite(path, []cid.Cid{a, b, b})
+	require.EqualError(t, err, "cannot resume on file with mismatching data header")
+
+	subject, err = blockstore.OpenReadWrite(path, wantRoots)
+	require.NoError(t, err)
+	gotRoots, err := subject.Roots()
+	require.NoError(t, err)
+	require.Equal(t, wantRoots, gotRoots)
+	require.NoError(t, subject.Put(context.TODO(), anotherTestBlockWithCidV0))
+	require.NoError(t, subject.Finalize())
+
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	gotRoots, err = robs.Roots()
+	require.NoError(t, err)
+	require.Equal(t, wantRoots, gotRoots)
+
+	cr, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, cr.Close()) })
+	gotRoots, err = cr.Roots()
+	require.NoError(t, err)
+	require.Equal(t, wantRoots, gotRoots)
+}
+
 func requireTmpCopy(t *testing.T, src string) string {
 	srcF, err := os.Open(src)
 	require.NoError(t, err)
@@ -1027,3 +1128,1970 @@ func TestBlockstore_IdentityCidWithEmptyDataIsIndexed(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 1, count)
 }
+
+func TestReadWriteView(t *testing.T) {
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "readwrite-view.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{oneTestBlockWithCidV1.Cid()})
+	require.NoError(t, err)
+	var deadlocked bool
+	t.Cleanup(func() {
+		if !deadlocked {
+			subject.Discard()
+		}
+	})
+
+	var bs ipfsblockstore.Blockstore = subject
+	viewer, ok := bs.(ipfsblockstore.Viewer)
+	require.True(t, ok)
+
+	require.NoError(t, subject.PutMany(ctx, []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0}))
+	for _, wantBlock := range []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0} {
+		var gotData []byte
+		err := viewer.View(ctx, wantBlock.Cid(), func(data []byte) error {
+			gotData = append([]byte{}, data...)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, wantBlock.RawData(), gotData)
+	}
+
+	// Assert callback may use the blockstore, e.g. to put blocks, without deadlocking.
+	added := merkledag.NewRawNode([]byte("put while viewing")).Block
+	viewed := make(chan error, 1)
+	go func() {
+		viewed <- subject.View(ctx, oneTestBlockWithCidV1.Cid(), func([]byte) error {
+			if err := subject.Put(ctx, added); err != nil {
+				return err
+			}
+			_, err := subject.Get(ctx, added.Cid())
+			return err
+		})
+	}()
+	select {
+	case err := <-viewed:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 3):
+		deadlocked = true
+		require.FailNow(t, "View deadlocked when callback put a block")
+	}
+	has, err := subject.Has(ctx, added.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+}
+
+func TestReadWriteFinalizeWithCidSortedIndexCodec(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "readwrite-cid-sorted-index.car")
+	cborCid := cid.NewCidV1(cid.DagCBOR, oneTestBlockWithCidV1.Cid().Hash())
+	cborBlock, err := blocks.NewBlockWithCid(oneTestBlockWithCidV1.RawData(), cborCid)
+	require.NoError(t, err)
+
+	subject, err := blockstore.OpenReadWrite(p, nil,
+		carv2.UseIndexCodec(index.CarCidIndexSorted),
+		blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(context.TODO(), []blocks.Block{oneTestBlockWithCidV1, cborBlock}))
+	require.NoError(t, subject.Finalize())
+
+	r, err := carv2.OpenReader(p)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, r.Close()) })
+	ir, err := r.IndexReader()
+	require.NoError(t, err)
+	idx, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	cidIdx, ok := idx.(*index.CidIndexSorted)
+	require.True(t, ok)
+
+	var gotCids []cid.Cid
+	require.NoError(t, cidIdx.ForEachCid(func(c cid.Cid, _ uint64) error {
+		gotCids = append(gotCids, c)
+		return nil
+	}))
+	require.ElementsMatch(t, []cid.Cid{oneTestBlockWithCidV1.Cid(), cborCid}, gotCids)
+
+	robs, err := blockstore.OpenReadOnly(p, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	keysChan, err := robs.AllKeysChan(context.Background())
+	require.NoError(t, err)
+	gotCids = nil
+	for c := range keysChan {
+		gotCids = append(gotCids, c)
+	}
+	require.ElementsMatch(t, []cid.Cid{oneTestBlockWithCidV1.Cid(), cborCid}, gotCids)
+}
+
+func TestReadWriteFinalizeWithoutBlocks(t *testing.T) {
+	roots := []cid.Cid{oneTestBlockWithCidV1.Cid()}
+	codecs := []multicodec.Code{multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, index.CarCidIndexSorted, index.CarMultihashSizedIndexSorted, index.CarMultihashIndexHashed}
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			ctx := context.Background()
+			p := filepath.Join(t.TempDir(), "readwrite-empty.car")
+			subject, err := blockstore.OpenReadWrite(p, roots, carv2.UseIndexCodec(codec))
+			require.NoError(t, err)
+			require.NoError(t, subject.Finalize())
+
+			// Assert the finalized CAR can be resumed and finalized again.
+			subject, err = blockstore.OpenReadWrite(p, roots, carv2.UseIndexCodec(codec))
+			require.NoError(t, err)
+			has, err := subject.Has(ctx, oneTestBlockWithCidV1.Cid())
+			require.NoError(t, err)
+			require.False(t, has)
+			require.NoError(t, subject.Finalize())
+
+			// Assert the embedded index is empty, and that every way of reading it handles that.
+			r, err := carv2.OpenReader(p)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, r.Close()) })
+			require.True(t, r.Header.HasIndex())
+			ir, err := r.IndexReader()
+			require.NoError(t, err)
+			idx, err := index.ReadFrom(ir)
+			require.NoError(t, err)
+			require.Equal(t, codec, idx.Codec())
+			_, err = index.GetFirst(idx, oneTestBlockWithCidV1.Cid())
+			require.True(t, errors.Is(err, index.ErrNotFound))
+
+			for _, opts := range [][]carv2.Option{
+				nil,
+				{blockstore.UseMmapIndex(true)},
+				{blockstore.UseWholeCIDs(true)},
+			} {
+				robs, err := blockstore.OpenReadOnly(p, opts...)
+				require.NoError(t, err)
+				gotRoots, err := robs.Roots()
+				require.NoError(t, err)
+				require.Equal(t, roots, gotRoots)
+				_, err = robs.Get(ctx, oneTestBlockWithCidV1.Cid())
+				require.IsType(t, format.ErrNotFound{}, err)
+				has, err := robs.Has(ctx, oneTestBlockWithCidV1.Cid())
+				require.NoError(t, err)
+				require.False(t, has)
+				keys, err := robs.AllKeysChan(ctx)
+				require.NoError(t, err)
+				_, ok := <-keys
+				require.False(t, ok)
+				require.NoError(t, robs.Close())
+			}
+		})
+	}
+}
+
+func TestReadWriteResumptionWithCorruptSectionLengthIsError(t *testing.T) {
+	roots := []cid.Cid{oneTestBlockWithCidV1.Cid()}
+	blks := []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0}
+
+	// Compute the offsets of sections relative to the data payload.
+	headerSize, err := carv1.HeaderSize(&carv1.CarHeader{Roots: roots, Version: 1})
+	require.NoError(t, err)
+	firstLen := len(blks[0].Cid().Bytes()) + len(blks[0].RawData())
+	secondLen := len(blks[1].Cid().Bytes()) + len(blks[1].RawData())
+	firstOffset := int64(headerSize)
+	secondOffset := firstOffset + 1 + int64(firstLen)
+
+	tests := []struct {
+		name          string
+		sectionOffset int64
+		length        byte
+		wantOffset    int64
+		wantReason    string
+	}{
+		{
+			name:          "LengthSmallerThanCid",
+			sectionOffset: firstOffset,
+			length:        1,
+			wantOffset:    firstOffset,
+			wantReason:    "CID size is larger than section length",
+		},
+		{
+			name:          "LengthBeyondDataPayload",
+			sectionOffset: secondOffset,
+			length:        byte(secondLen + 1),
+			wantOffset:    secondOffset,
+			wantReason:    "does not fit within data payload",
+		},
+		{
+			name:          "LengthLandingInBlockData",
+			sectionOffset: firstOffset,
+			length:        byte(len(blks[0].Cid().Bytes()) + 1),
+			// The next section is then read from the middle of the first block's data.
+			wantOffset: firstOffset + 1 + int64(len(blks[0].Cid().Bytes())) + 1,
+			wantReason: "cannot decode CID",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-resume-corrupt-section.car")
+			subject, err := blockstore.OpenReadWrite(path, roots)
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(context.TODO(), blks))
+			require.NoError(t, subject.Finalize())
+
+			// Corrupt the single-byte length varint of the section.
+			f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+			require.NoError(t, err)
+			_, err = f.WriteAt([]byte{tt.length}, carv2.PragmaSize+carv2.HeaderSize+tt.sectionOffset)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			subject, err = blockstore.OpenReadWrite(path, roots)
+			require.Nil(t, subject)
+			var corrupt *carv2.ErrCorruptSection
+			require.True(t, errors.As(err, &corrupt), "expected ErrCorruptSection but got: %v", err)
+			require.Equal(t, uint64(tt.wantOffset), corrupt.Offset)
+			require.Contains(t, corrupt.Reason, tt.wantReason)
+		})
+	}
+}
+
+func TestReadWriteSizedIndexRecordsBlockSizes(t *testing.T) {
+	ctx := context.TODO()
+	p := filepath.Join(t.TempDir(), "readwrite-sized-index.car")
+	blks := []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0}
+	opts := []carv2.Option{carv2.UseIndexCodec(index.CarMultihashSizedIndexSorted)}
+
+	requireSizes := func(t *testing.T, bs ipfsblockstore.Blockstore) {
+		for _, blk := range blks {
+			gotSize, err := bs.GetSize(ctx, blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, len(blk.RawData()), gotSize)
+		}
+	}
+
+	// Assert sizes are recorded at put time.
+	subject, err := blockstore.OpenReadWrite(p, nil, opts...)
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks))
+	requireSizes(t, subject)
+	require.NoError(t, subject.Finalize())
+
+	// Assert sizes are recorded when resuming.
+	subject, err = blockstore.OpenReadWrite(p, nil, opts...)
+	require.NoError(t, err)
+	requireSizes(t, subject)
+	require.NoError(t, subject.Finalize())
+
+	// Assert the finalized index carries the block sizes.
+	r, err := carv2.OpenReader(p)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, r.Close()) })
+	ir, err := r.IndexReader()
+	require.NoError(t, err)
+	idx, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	sized, ok := idx.(index.SizedIndex)
+	require.True(t, ok)
+	for _, blk := range blks {
+		gotSize, known, err := sized.GetSize(blk.Cid())
+		require.NoError(t, err)
+		require.True(t, known)
+		require.Equal(t, uint64(len(blk.RawData())), gotSize)
+	}
+
+	robs, err := blockstore.OpenReadOnly(p)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	requireSizes(t, robs)
+}
+
+func TestReadWriteMissingRoots(t *testing.T) {
+	ctx := context.TODO()
+	absent, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte("absent root"))
+	require.NoError(t, err)
+	identity, err := cid.NewPrefixV1(cid.Raw, multihash.IDENTITY).Sum([]byte("identity root"))
+	require.NoError(t, err)
+	roots := []cid.Cid{absent, oneTestBlockWithCidV1.Cid(), identity, anotherTestBlockWithCidV0.Cid()}
+
+	path := filepath.Join(t.TempDir(), "readwrite-missing-roots.car")
+	subject, err := blockstore.OpenReadWrite(path, roots)
+	require.NoError(t, err)
+
+	got, err := subject.MissingRoots()
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{absent, oneTestBlockWithCidV1.Cid(), anotherTestBlockWithCidV0.Cid()}, got)
+
+	require.NoError(t, subject.Put(ctx, oneTestBlockWithCidV1))
+	got, err = subject.MissingRoots()
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{absent, anotherTestBlockWithCidV0.Cid()}, got)
+	require.NoError(t, subject.Finalize())
+
+	// Assert missing roots are consistent once finalized.
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	got, err = robs.MissingRoots()
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{absent, anotherTestBlockWithCidV0.Cid()}, got)
+}
+
+func TestReadWriteResumptionAdoptsExistingIndex(t *testing.T) {
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "readwrite-existing-index.car")
+	blks := make([]blocks.Block, 10)
+	for i := range blks {
+		blks[i] = merkledag.NewRawNode([]byte(fmt.Sprintf("🐠-%d", i))).Block
+	}
+	roots := []cid.Cid{blks[0].Cid()}
+
+	subject, err := blockstore.OpenReadWrite(path, roots)
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[:5]))
+	require.NoError(t, subject.Finalize())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	existing, err := carv2.ReadOrGenerateIndex(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Corrupt the CID version of the first section, skipping its single-byte length, so that
+	// resumption fails if the data payload is scanned.
+	headerSize, err := carv1.HeaderSize(&carv1.CarHeader{Roots: roots, Version: 1})
+	require.NoError(t, err)
+	firstCidVersionAt := int64(carv2.PragmaSize+carv2.HeaderSize+headerSize) + 1
+	writeCidVersion := func(version byte) {
+		f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+		require.NoError(t, err)
+		_, err = f.WriteAt([]byte{version}, firstCidVersionAt)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	writeCidVersion(0x05)
+	_, err = blockstore.OpenReadWrite(path, roots)
+	var corrupt *carv2.ErrCorruptSection
+	require.True(t, errors.As(err, &corrupt), "expected ErrCorruptSection but got: %v", err)
+
+	// Assert the existing index is adopted without scanning the data payload, and only the new
+	// blocks are written.
+	subject, err = blockstore.OpenReadWrite(path, roots, blockstore.WithExistingIndex(existing))
+	require.NoError(t, err)
+	for _, blk := range blks[1:5] {
+		has, err := subject.Has(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks))
+	require.NoError(t, subject.Finalize())
+	writeCidVersion(0x01)
+
+	var wantDataSize uint64
+	for _, blk := range blks[5:] {
+		sectionLen := uint64(len(blk.Cid().Bytes()) + len(blk.RawData()))
+		wantDataSize += uint64(varint.UvarintSize(sectionLen)) + sectionLen
+	}
+	r, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, r.Close()) })
+	require.Equal(t, uint64(stat.Size())-carv2.PragmaSize-carv2.HeaderSize+wantDataSize, r.Header.DataSize)
+
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	for _, blk := range blks {
+		got, err := robs.Get(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+	ir, err := r.IndexReader()
+	require.NoError(t, err)
+	gotIdx, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	wantIdx, err := carv2.GenerateIndexFromFile(path)
+	require.NoError(t, err)
+	require.Equal(t, wantIdx, gotIdx)
+}
+
+func TestReadWriteResumptionAdoptsEmbeddedIndex(t *testing.T) {
+	ctx := context.TODO()
+	blks := make([]blocks.Block, 10)
+	for i := range blks {
+		blks[i] = merkledag.NewRawNode([]byte(fmt.Sprintf("🐡-%d", i))).Block
+	}
+	roots := []cid.Cid{blks[0].Cid()}
+	headerSize, err := carv1.HeaderSize(&carv1.CarHeader{Roots: roots, Version: 1})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		opts    []carv2.Option
+		adopted bool
+	}{
+		{name: "MultihashIndexSorted", adopted: true},
+		{name: "MultihashSizedIndexSorted", opts: []carv2.Option{carv2.UseIndexCodec(index.CarMultihashSizedIndexSorted)}, adopted: true},
+		{name: "CidIndexSortedWithWholeCIDs", opts: []carv2.Option{carv2.UseIndexCodec(index.CarCidIndexSorted), blockstore.UseWholeCIDs(true)}, adopted: true},
+		// Indices that cannot be adopted are ignored, and the data payload is scanned instead.
+		{name: "NonIterableIndex", opts: []carv2.Option{carv2.UseIndexCodec(multicodec.CarIndexSorted)}},
+		{name: "WholeCIDsWithoutCidIndexSorted", opts: []carv2.Option{blockstore.UseWholeCIDs(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-embedded-index.car")
+			subject, err := blockstore.OpenReadWrite(path, roots, tt.opts...)
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(ctx, blks[:5]))
+			require.NoError(t, subject.Finalize())
+
+			// Corrupt the CID version of the first section, skipping its single-byte length, so
+			// that resumption fails if the data payload is scanned.
+			firstCidVersionAt := int64(carv2.PragmaSize+carv2.HeaderSize+headerSize) + 1
+			writeCidVersion := func(version byte) {
+				f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+				require.NoError(t, err)
+				_, err = f.WriteAt([]byte{version}, firstCidVersionAt)
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			}
+			writeCidVersion(0x05)
+			subject, err = blockstore.OpenReadWrite(path, roots, append(tt.opts, blockstore.WithEmbeddedIndex())...)
+			if !tt.adopted {
+				var corrupt *carv2.ErrCorruptSection
+				require.True(t, errors.As(err, &corrupt), "expected ErrCorruptSection but got: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			// The first block cannot be read back until its section is restored.
+			for _, blk := range blks[1:5] {
+				has, err := subject.Has(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.True(t, has)
+			}
+			require.NoError(t, subject.PutMany(ctx, blks))
+			require.NoError(t, subject.Finalize())
+			writeCidVersion(0x01)
+
+			// Assert the blocks put before resumption are not written again.
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, f.Close()) })
+			br, err := carv2.NewBlockReader(f)
+			require.NoError(t, err)
+			for _, want := range blks {
+				got, err := br.Next()
+				require.NoError(t, err)
+				require.Equal(t, want.Cid(), got.Cid())
+			}
+			_, err = br.Next()
+			require.Equal(t, io.EOF, err)
+
+			robs, err := blockstore.OpenReadOnly(path, tt.opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, robs.Close()) })
+			for _, blk := range blks {
+				got, err := robs.Get(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.Equal(t, blk.RawData(), got.RawData())
+			}
+		})
+	}
+}
+
+func TestReadWriteWithMismatchingExistingIndexIsError(t *testing.T) {
+	roots := []cid.Cid{oneTestBlockWithCidV1.Cid()}
+	blks := []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0}
+	headerSize, err := carv1.HeaderSize(&carv1.CarHeader{Roots: roots, Version: 1})
+	require.NoError(t, err)
+	firstLen := len(blks[0].Cid().Bytes()) + len(blks[0].RawData())
+	secondOffset := headerSize + 1 + uint64(firstLen)
+
+	loadMultihashSorted := func(t *testing.T, records ...index.Record) index.Index {
+		idx := index.NewMultihashSorted()
+		require.NoError(t, idx.Load(records))
+		return idx
+	}
+	tests := []struct {
+		name     string
+		existing func(t *testing.T, path string) index.Index
+		opts     []carv2.Option
+		fresh    bool
+	}{
+		{
+			name: "OffsetBeyondDataPayload",
+			existing: func(t *testing.T, _ string) index.Index {
+				return loadMultihashSorted(t, index.Record{Cid: blks[0].Cid(), Offset: 1 << 20})
+			},
+		},
+		{
+			name: "OffsetWithinHeader",
+			existing: func(t *testing.T, _ string) index.Index {
+				return loadMultihashSorted(t, index.Record{Cid: blks[0].Cid(), Offset: 1})
+			},
+		},
+		{
+			name: "LastRecordNotMatchingSection",
+			existing: func(t *testing.T, _ string) index.Index {
+				return loadMultihashSorted(t,
+					index.Record{Cid: blks[0].Cid(), Offset: headerSize},
+					index.Record{Cid: blks[0].Cid(), Offset: secondOffset})
+			},
+		},
+		{
+			name: "NonIterableIndex",
+			existing: func(t *testing.T, path string) index.Index {
+				idx, err := carv2.GenerateIndexFromFile(path, carv2.UseIndexCodec(multicodec.CarIndexSorted))
+				require.NoError(t, err)
+				return idx
+			},
+		},
+		{
+			name: "WholeCidsWithoutCidSortedIndex",
+			existing: func(t *testing.T, path string) index.Index {
+				idx, err := carv2.GenerateIndexFromFile(path)
+				require.NoError(t, err)
+				return idx
+			},
+			opts: []carv2.Option{blockstore.UseWholeCIDs(true)},
+		},
+		{
+			name: "WithIndexWAL",
+			existing: func(t *testing.T, path string) index.Index {
+				idx, err := carv2.GenerateIndexFromFile(path)
+				require.NoError(t, err)
+				return idx
+			},
+			opts: []carv2.Option{blockstore.WithIndexWAL(filepath.Join(t.TempDir(), "index.wal"))},
+		},
+		{
+			name: "NonEmptyIndexWhenNotResuming",
+			existing: func(t *testing.T, path string) index.Index {
+				idx, err := carv2.GenerateIndexFromFile(path)
+				require.NoError(t, err)
+				return idx
+			},
+			fresh: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-existing-index.car")
+			subject, err := blockstore.OpenReadWrite(path, roots)
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(context.TODO(), blks))
+			require.NoError(t, subject.Finalize())
+			existing := tt.existing(t, path)
+			if tt.fresh {
+				path = filepath.Join(t.TempDir(), "readwrite-existing-index-fresh.car")
+			}
+
+			opts := append([]carv2.Option{blockstore.WithExistingIndex(existing)}, tt.opts...)
+			subject, err = blockstore.OpenReadWrite(path, roots, opts...)
+			require.Error(t, err)
+			require.Nil(t, subject)
+		})
+	}
+}
+
+func TestReadWriteWithStrictCodecMatch(t *testing.T) {
+	ctx := context.TODO()
+	data := []byte("undadasea")
+	rawBlock := merkledag.NewRawNode(data).Block
+	pbCid := cid.NewCidV1(cid.DagProtobuf, rawBlock.Cid().Hash())
+	pbBlock, err := blocks.NewBlockWithCid(data, pbCid)
+	require.NoError(t, err)
+
+	requireNotFound := func(t *testing.T, bs ipfsblockstore.Blockstore, key cid.Cid) {
+		_, err := bs.Get(ctx, key)
+		require.IsType(t, format.ErrNotFound{}, err)
+		_, err = bs.GetSize(ctx, key)
+		require.IsType(t, format.ErrNotFound{}, err)
+		has, err := bs.Has(ctx, key)
+		require.NoError(t, err)
+		require.False(t, has)
+		err = bs.(ipfsblockstore.Viewer).View(ctx, key, func([]byte) error { return nil })
+		require.IsType(t, format.ErrNotFound{}, err)
+	}
+	requireFound := func(t *testing.T, bs ipfsblockstore.Blockstore, want blocks.Block) {
+		got, err := bs.Get(ctx, want.Cid())
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		size, err := bs.GetSize(ctx, want.Cid())
+		require.NoError(t, err)
+		require.Equal(t, len(want.RawData()), size)
+		has, err := bs.Has(ctx, want.Cid())
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+
+	t.Run("DisabledMatchesByMultihash", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "readwrite-multihash.car")
+		subject, err := blockstore.OpenReadWrite(path, []cid.Cid{})
+		require.NoError(t, err)
+		t.Cleanup(func() { subject.Discard() })
+		require.NoError(t, subject.Put(ctx, rawBlock))
+
+		// Assert the raw block is returned under the dag-pb key.
+		requireFound(t, subject, pbBlock)
+	})
+
+	t.Run("EnabledMatchesByCodec", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "readwrite-strict-codec.car")
+		subject, err := blockstore.OpenReadWrite(path, []cid.Cid{}, blockstore.WithStrictCodecMatch())
+		require.NoError(t, err)
+		require.NoError(t, subject.Put(ctx, rawBlock))
+
+		requireFound(t, subject, rawBlock)
+		requireNotFound(t, subject, pbCid)
+
+		// Assert the dag-pb block is not deduplicated by multihash, and that both are found.
+		require.NoError(t, subject.Put(ctx, pbBlock))
+		requireFound(t, subject, rawBlock)
+		requireFound(t, subject, pbBlock)
+		require.NoError(t, subject.Finalize())
+
+		// Assert the same holds when reading the finalized file.
+		robs, err := blockstore.OpenReadOnly(path, blockstore.WithStrictCodecMatch())
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, robs.Close()) })
+		requireFound(t, robs, rawBlock)
+		requireFound(t, robs, pbBlock)
+
+		// Assert the keys are returned with their original codecs.
+		keys, err := robs.AllKeysChan(ctx)
+		require.NoError(t, err)
+		var gotKeys []cid.Cid
+		for k := range keys {
+			gotKeys = append(gotKeys, k)
+		}
+		require.Equal(t, []cid.Cid{rawBlock.Cid(), pbCid}, gotKeys)
+	})
+}
+
+func TestReadWriteWithMaxDuplicateLookups(t *testing.T) {
+	ctx := context.TODO()
+	data := []byte("lobstermuncher")
+	blk := merkledag.NewRawNode(data).Block
+	pbCid := cid.NewCidV1(cid.DagProtobuf, blk.Cid().Hash())
+	pbBlock, err := blocks.NewBlockWithCid(data, pbCid)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "readwrite-max-duplicate-lookups.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{},
+		blockstore.UseWholeCIDs(true),
+		blockstore.AllowDuplicatePuts(true),
+		blockstore.WithMaxDuplicateLookups(3))
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+
+	// Put the same block as many times as the max allowed lookups.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, subject.Put(ctx, blk))
+	}
+
+	// Assert blocks are found within the max allowed lookups, whether present or not.
+	got, err := subject.Get(ctx, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk, got)
+	_, err = subject.Get(ctx, pbCid)
+	require.IsType(t, format.ErrNotFound{}, err)
+
+	// Assert looking up a block that requires more than the max allowed lookups is an error.
+	require.NoError(t, subject.Put(ctx, blk))
+	require.NoError(t, subject.Put(ctx, pbBlock))
+	wantErr := &carv2.ErrTooManyDuplicateLookups{Cid: pbCid, MaxLookups: 3}
+	_, err = subject.Get(ctx, pbCid)
+	require.Equal(t, wantErr, err)
+	_, err = subject.GetSize(ctx, pbCid)
+	require.Equal(t, wantErr, err)
+	_, err = subject.Has(ctx, pbCid)
+	require.Equal(t, wantErr, err)
+	err = subject.View(ctx, pbCid, func([]byte) error { return nil })
+	require.Equal(t, wantErr, err)
+
+	// Assert the first block is still found within the max allowed lookups.
+	got, err = subject.Get(ctx, blk.Cid())
+	require.NoError(t, err)
+	require.Equal(t, blk, got)
+}
+
+func TestReadWriteLen(t *testing.T) {
+	ctx := context.TODO()
+	blk1 := merkledag.NewRawNode([]byte("fish")).Block
+	blk2 := merkledag.NewRawNode([]byte("lobster")).Block
+
+	path := filepath.Join(t.TempDir(), "readwrite-len.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{}, blockstore.AllowDuplicatePuts(true))
+	require.NoError(t, err)
+
+	gotLen, err := subject.Len()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), gotLen)
+
+	// Assert duplicate puts are counted as separate records.
+	require.NoError(t, subject.PutMany(ctx, []blocks.Block{blk1, blk2, blk1}))
+	gotLen, err = subject.Len()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), gotLen)
+	require.NoError(t, subject.Finalize())
+
+	// Assert the count is consistent when reading back the finalized file.
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	gotLen, err = robs.Len()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), gotLen)
+
+	require.NoError(t, robs.Close())
+	_, err = robs.Len()
+	require.Error(t, err)
+}
+
+func TestReadWriteFinalizeWithIndexChecksum(t *testing.T) {
+	ctx := context.TODO()
+	blks := []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0}
+
+	finalize := func(t *testing.T, opts ...carv2.Option) string {
+		path := filepath.Join(t.TempDir(), "readwrite-index-checksum.car")
+		subject, err := blockstore.OpenReadWrite(path, []cid.Cid{}, opts...)
+		require.NoError(t, err)
+		require.NoError(t, subject.PutMany(ctx, blks))
+		require.NoError(t, subject.Finalize())
+		return path
+	}
+	readIndexCodec := func(t *testing.T, path string) (multicodec.Code, int64) {
+		r, err := carv2.OpenReader(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, r.Close()) })
+		ir, err := r.IndexReader()
+		require.NoError(t, err)
+		codec, err := index.ReadCodec(ir)
+		require.NoError(t, err)
+		return codec, int64(r.Header.IndexOffset)
+	}
+
+	t.Run("EnabledByDefault", func(t *testing.T) {
+		path := finalize(t)
+		codec, indexOffset := readIndexCodec(t, path)
+		require.Equal(t, index.CarIndexChecksummed, codec)
+
+		for _, useMmap := range []bool{false, true} {
+			robs, err := blockstore.OpenReadOnly(path, blockstore.UseMmapIndex(useMmap))
+			require.NoError(t, err)
+			for _, blk := range blks {
+				got, err := robs.Get(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.Equal(t, blk.RawData(), got.RawData())
+			}
+			require.NoError(t, robs.Close())
+		}
+
+		// Assert a single flipped bit in the index is detected.
+		f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+		require.NoError(t, err)
+		stat, err := f.Stat()
+		require.NoError(t, err)
+		last := make([]byte, 1)
+		_, err = f.ReadAt(last, stat.Size()-1)
+		require.NoError(t, err)
+		last[0] ^= 0x01
+		_, err = f.WriteAt(last, stat.Size()-1)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.Less(t, indexOffset, stat.Size()-1)
+
+		for _, useMmap := range []bool{false, true} {
+			_, err = blockstore.OpenReadOnly(path, blockstore.UseMmapIndex(useMmap))
+			var checksumErr *index.ErrIndexChecksum
+			require.True(t, errors.As(err, &checksumErr), "expected checksum error, got %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		path := finalize(t, blockstore.WithIndexChecksum(false))
+		codec, _ := readIndexCodec(t, path)
+		require.Equal(t, multicodec.CarMultihashIndexSorted, codec)
+
+		robs, err := blockstore.OpenReadOnly(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, robs.Close()) })
+		for _, blk := range blks {
+			has, err := robs.Has(ctx, blk.Cid())
+			require.NoError(t, err)
+			require.True(t, has)
+		}
+	})
+}
+
+func TestReadWriteHooks(t *testing.T) {
+	type call struct {
+		c    cid.Cid
+		size int
+		has  bool
+		err  error
+	}
+	var mu sync.Mutex
+	var gets, hass, puts []call
+	record := func(calls *[]call, c call) {
+		mu.Lock()
+		defer mu.Unlock()
+		*calls = append(*calls, c)
+	}
+	opts := []carv2.Option{
+		blockstore.WithGetHook(func(c cid.Cid, size int, err error) {
+			record(&gets, call{c: c, size: size, err: err})
+		}),
+		blockstore.WithHasHook(func(c cid.Cid, has bool, err error) {
+			record(&hass, call{c: c, has: has, err: err})
+		}),
+		blockstore.WithPutHook(func(c cid.Cid, size int, err error) {
+			record(&puts, call{c: c, size: size, err: err})
+		}),
+	}
+
+	ctx := context.TODO()
+	blk := oneTestBlockWithCidV1
+	missing := anotherTestBlockWithCidV0
+	path := filepath.Join(t.TempDir(), "readwrite-hooks.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{}, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+
+	require.NoError(t, subject.Put(ctx, blk))
+	// Assert a deduplicated block is reported as successfully put.
+	require.NoError(t, subject.PutMany(ctx, []blocks.Block{blk}))
+	require.Equal(t, []call{
+		{c: blk.Cid(), size: len(blk.RawData())},
+		{c: blk.Cid(), size: len(blk.RawData())},
+	}, puts)
+
+	has, err := subject.Has(ctx, blk.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = subject.Has(ctx, missing.Cid())
+	require.NoError(t, err)
+	require.False(t, has)
+	require.Equal(t, []call{
+		{c: blk.Cid(), has: true},
+		{c: missing.Cid()},
+	}, hass)
+
+	_, err = subject.Get(ctx, blk.Cid())
+	require.NoError(t, err)
+	_, err = subject.Get(ctx, missing.Cid())
+	wantErr := format.ErrNotFound{Cid: missing.Cid()}
+	require.Equal(t, wantErr, err)
+	require.NoError(t, subject.View(ctx, blk.Cid(), func([]byte) error { return nil }))
+	require.Equal(t, []call{
+		{c: blk.Cid(), size: len(blk.RawData())},
+		{c: missing.Cid(), err: wantErr},
+		{c: blk.Cid(), size: len(blk.RawData())},
+	}, gets)
+
+	// Assert failed puts are reported, and that blocks after the failed one are not.
+	subject.Discard()
+	err = subject.PutMany(ctx, []blocks.Block{missing, blk})
+	require.Error(t, err)
+	require.Len(t, puts, 3)
+	require.Equal(t, call{c: missing.Cid(), size: len(missing.RawData()), err: err}, puts[2])
+}
+
+func TestReadWriteHooksCanUseBlockstore(t *testing.T) {
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "readwrite-hooks-reentrant.car")
+	var subject *blockstore.ReadWrite
+	var gotSize int
+	var gotErr error
+	// Assert hooks run outside the blockstore lock, such that they may call the blockstore.
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{}, blockstore.WithPutHook(func(c cid.Cid, _ int, _ error) {
+		gotSize, gotErr = subject.GetSize(ctx, c)
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+
+	require.NoError(t, subject.Put(ctx, oneTestBlockWithCidV1))
+	require.NoError(t, gotErr)
+	require.Equal(t, len(oneTestBlockWithCidV1.RawData()), gotSize)
+}
+
+func TestReadWriteGetByMultihashWithMixedCodecs(t *testing.T) {
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "readwrite-mixed-codecs.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{}, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+
+	// Put the same data under two codecs, such that both blocks share a multihash.
+	data := []byte("fish")
+	rawCid, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum(data)
+	require.NoError(t, err)
+	cborCid := cid.NewCidV1(cid.DagCBOR, rawCid.Hash())
+	rawBlock, err := blocks.NewBlockWithCid(data, rawCid)
+	require.NoError(t, err)
+	cborBlock, err := blocks.NewBlockWithCid(data, cborCid)
+	require.NoError(t, err)
+	otherBlock := merkledag.NewRawNode([]byte("lobster"))
+	require.NoError(t, subject.PutMany(ctx, []blocks.Block{cborBlock, otherBlock, rawBlock}))
+
+	requireGetByMultihash := func(t *testing.T, bs interface {
+		GetByMultihash(multihash.Multihash) (cid.Cid, []byte, error)
+		GetAllByMultihash(multihash.Multihash) ([]blocks.Block, error)
+	}) {
+		gotCid, gotData, err := bs.GetByMultihash(rawCid.Hash())
+		require.NoError(t, err)
+		require.Contains(t, []cid.Cid{rawCid, cborCid}, gotCid)
+		require.Equal(t, data, gotData)
+
+		gotAll, err := bs.GetAllByMultihash(rawCid.Hash())
+		require.NoError(t, err)
+		require.Len(t, gotAll, 2)
+		var gotCids []cid.Cid
+		for _, blk := range gotAll {
+			gotCids = append(gotCids, blk.Cid())
+			require.Equal(t, data, blk.RawData())
+		}
+		require.ElementsMatch(t, []cid.Cid{rawCid, cborCid}, gotCids)
+
+		gotCid, gotData, err = bs.GetByMultihash(otherBlock.Cid().Hash())
+		require.NoError(t, err)
+		require.Equal(t, otherBlock.Cid(), gotCid)
+		require.Equal(t, otherBlock.RawData(), gotData)
+
+		notFound, err := multihash.Sum([]byte("crab"), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		_, _, err = bs.GetByMultihash(notFound)
+		require.IsType(t, format.ErrNotFound{}, err)
+		_, err = bs.GetAllByMultihash(notFound)
+		require.IsType(t, format.ErrNotFound{}, err)
+
+		identity, err := multihash.Sum([]byte("shrimp"), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		gotCid, gotData, err = bs.GetByMultihash(identity)
+		require.NoError(t, err)
+		require.Equal(t, cid.NewCidV1(cid.Raw, identity), gotCid)
+		require.Equal(t, []byte("shrimp"), gotData)
+	}
+
+	t.Run("ReadWrite", func(t *testing.T) { requireGetByMultihash(t, subject) })
+	require.NoError(t, subject.Finalize())
+
+	for _, opts := range [][]carv2.Option{nil, {blockstore.UseWholeCIDs(true)}} {
+		ronly, err := blockstore.OpenReadOnly(path, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, ronly.Close()) })
+		t.Run("ReadOnly", func(t *testing.T) { requireGetByMultihash(t, ronly) })
+	}
+}
+
+func TestReadWriteResumptionReportsProgressAndIsCancellable(t *testing.T) {
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "readwrite-resume-progress.car")
+	blks := make([]blocks.Block, 10)
+	for i := range blks {
+		blks[i] = merkledag.NewRawNode([]byte(fmt.Sprintf("🦑-%d", i))).Block
+	}
+	roots := []cid.Cid{blks[0].Cid()}
+
+	subject, err := blockstore.OpenReadWrite(path, roots)
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks))
+	require.NoError(t, subject.Finalize())
+
+	r, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	wantDataSize := int64(r.Header.DataSize)
+	require.NoError(t, r.Close())
+
+	// Assert resumption is aborted when the context is done.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = blockstore.OpenReadWrite(path, roots, carv2.WithIndexContext(cancelled))
+	require.True(t, errors.Is(err, context.Canceled), "expected context.Canceled but got: %v", err)
+
+	// Assert the progress of scanning sections is reported every interval, and once at the end.
+	type progress struct {
+		sections              uint64
+		bytesRead, totalBytes int64
+	}
+	var got []progress
+	subject, err = blockstore.OpenReadWrite(path, roots, carv2.WithIndexProgress(3, func(sections uint64, bytesRead, totalBytes int64) {
+		got = append(got, progress{sections, bytesRead, totalBytes})
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+	require.Len(t, got, 4)
+	for i, p := range got[:3] {
+		require.Equal(t, uint64(3*(i+1)), p.sections)
+		require.Less(t, p.bytesRead, wantDataSize)
+		require.Equal(t, wantDataSize, p.totalBytes)
+	}
+	require.Equal(t, progress{10, wantDataSize, wantDataSize}, got[3])
+}
+
+func TestReadWriteWithExpectedSize(t *testing.T) {
+	ctx := context.TODO()
+	blks := make([]blocks.Block, 10)
+	for i := range blks {
+		blks[i] = merkledag.NewRawNode([]byte(fmt.Sprintf("🦞-%d", i))).Block
+	}
+	roots := []cid.Cid{blks[0].Cid()}
+
+	requireFinalizedWithBlocks := func(t *testing.T, path string, v1 bool) {
+		r, err := carv2.OpenReader(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, r.Close()) })
+		stats, err := r.Inspect(true)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(blks)), stats.BlockCount)
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		if v1 {
+			return
+		}
+		ir, err := r.IndexReader()
+		require.NoError(t, err)
+		_, err = index.ReadFrom(ir)
+		require.NoError(t, err)
+		// Assert the file ends right after the index.
+		indexSize, err := io.Copy(io.Discard, ir)
+		require.NoError(t, err)
+		require.Zero(t, indexSize)
+		require.Greater(t, stat.Size(), int64(r.Header.IndexOffset))
+	}
+
+	tests := []struct {
+		name         string
+		expectedSize uint64
+		v1           bool
+	}{
+		{name: "Oversize", expectedSize: 1 << 20},
+		{name: "OversizeAsCarV1", expectedSize: 1 << 20, v1: true},
+		{name: "TooSmall", expectedSize: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-expected-size.car")
+			opts := []carv2.Option{blockstore.WithExpectedSize(tt.expectedSize), blockstore.WriteAsCarV1(tt.v1)}
+			subject, err := blockstore.OpenReadWrite(path, roots, opts...)
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(ctx, blks[:5]))
+			stat, err := os.Stat(path)
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, stat.Size(), int64(tt.expectedSize))
+
+			// Assert that the preallocated space is not read as blocks.
+			var got []cid.Cid
+			require.NoError(t, subject.EachBlock(ctx, func(c cid.Cid, _ []byte, _ uint64) error {
+				got = append(got, c)
+				return nil
+			}))
+			require.Len(t, got, 5)
+
+			// Assert that a file that is not finalized is resumed despite the preallocated space.
+			subject.Discard()
+			subject, err = blockstore.OpenReadWrite(path, roots, opts...)
+			require.NoError(t, err)
+			// The first block cannot be read back until its section is restored.
+			for _, blk := range blks[1:5] {
+				has, err := subject.Has(ctx, blk.Cid())
+				require.NoError(t, err)
+				require.True(t, has)
+			}
+			require.NoError(t, subject.PutMany(ctx, blks[5:]))
+			require.NoError(t, subject.Finalize())
+
+			stat, err = os.Stat(path)
+			require.NoError(t, err)
+			if tt.expectedSize > 1000 {
+				// Assert the oversize preallocation is trimmed.
+				require.Less(t, stat.Size(), int64(tt.expectedSize))
+			}
+			requireFinalizedWithBlocks(t, path, tt.v1)
+		})
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	ctx := context.TODO()
+	var blks []blocks.Block
+	wantCodecs := map[uint64]int{cid.Raw: 3, cid.DagProtobuf: 2, cid.DagCBOR: 1}
+	for codec, count := range wantCodecs {
+		for i := 0; i < count; i++ {
+			data := []byte(fmt.Sprintf("🐙-%d-%d", codec, i))
+			c, err := cid.NewPrefixV1(codec, multihash.SHA2_256).Sum(data)
+			require.NoError(t, err)
+			blk, err := blocks.NewBlockWithCid(data, c)
+			require.NoError(t, err)
+			blks = append(blks, blk)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "readwrite-codecs.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()})
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks))
+	got, err := subject.Codecs()
+	require.NoError(t, err)
+	require.Equal(t, wantCodecs, got)
+	require.NoError(t, subject.Finalize())
+
+	// Assert the codecs are counted regardless of whether whole CIDs are used.
+	for _, opts := range [][]carv2.Option{nil, {blockstore.UseWholeCIDs(true)}} {
+		ronly, err := blockstore.OpenReadOnly(path, opts...)
+		require.NoError(t, err)
+		got, err := ronly.Codecs()
+		require.NoError(t, err)
+		require.Equal(t, wantCodecs, got)
+		require.NoError(t, ronly.Close())
+	}
+}
+
+// cancelAfterContext is a context whose Err method starts returning context.Canceled once it has
+// been called a given number of times, such that cancellation happens at a deterministic point.
+type cancelAfterContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.remaining <= 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+func TestReadWriteFinalizeContext(t *testing.T) {
+	ctx := context.TODO()
+	// Put enough blocks for the index to be written in several chunks.
+	blks := make([]blocks.Block, 20000)
+	for i := range blks {
+		blks[i] = merkledag.NewRawNode([]byte(fmt.Sprintf("🐙-%d", i))).Block
+	}
+	extra := merkledag.NewRawNode([]byte("🐙-extra")).Block
+	roots := []cid.Cid{blks[0].Cid()}
+
+	requireFinalizedWithBlocks := func(t *testing.T, path string, want []blocks.Block) {
+		robs, err := blockstore.OpenReadOnly(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, robs.Close()) })
+		n, err := robs.Len()
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(want)), n)
+		for _, blk := range want {
+			has, err := robs.Has(ctx, blk.Cid())
+			require.NoError(t, err)
+			require.True(t, has)
+		}
+	}
+
+	for _, opts := range []struct {
+		name string
+		opts []carv2.Option
+	}{
+		{name: "Checksummed"},
+		{name: "WithoutChecksum", opts: []carv2.Option{blockstore.WithIndexChecksum(false)}},
+		{name: "NonDefaultCodec", opts: []carv2.Option{carv2.UseIndexCodec(index.CarMultihashIndexHashed)}},
+	} {
+		t.Run(opts.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-finalize-context.car")
+			subject, err := blockstore.OpenReadWrite(path, roots, opts.opts...)
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(ctx, blks))
+			stat, err := os.Stat(path)
+			require.NoError(t, err)
+			unfinalizedSize := stat.Size()
+
+			// Assert an already cancelled context aborts finalization upfront.
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			require.Equal(t, context.Canceled, subject.FinalizeContext(cancelled))
+
+			// Assert cancelling while the index is written leaves the file unfinalized, and the
+			// blockstore usable.
+			err = subject.FinalizeContext(&cancelAfterContext{Context: ctx, remaining: 3})
+			require.Equal(t, context.Canceled, err)
+			stat, err = os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, unfinalizedSize, stat.Size())
+			// An unfinalized CARv2 header is zeroed.
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			header := make([]byte, carv2.HeaderSize)
+			_, err = f.ReadAt(header, carv2.PragmaSize)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			require.Equal(t, make([]byte, carv2.HeaderSize), header)
+
+			require.NoError(t, subject.Put(ctx, extra))
+			has, err := subject.Has(ctx, blks[len(blks)-1].Cid())
+			require.NoError(t, err)
+			require.True(t, has)
+			keys, err := subject.AllKeysChan(ctx)
+			require.NoError(t, err)
+			var count int
+			for range keys {
+				count++
+			}
+			require.Equal(t, len(blks)+1, count)
+
+			// Assert finalization can be retried.
+			require.NoError(t, subject.FinalizeContext(ctx))
+			requireFinalizedWithBlocks(t, path, append(blks, extra))
+		})
+	}
+
+	// Assert a file left by a cancelled finalization can be resumed from and finalized.
+	path := filepath.Join(t.TempDir(), "readwrite-finalize-context-resume.car")
+	subject, err := blockstore.OpenReadWrite(path, roots)
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks))
+	require.Equal(t, context.Canceled, subject.FinalizeContext(&cancelAfterContext{Context: ctx, remaining: 3}))
+	subject.Discard()
+
+	subject, err = blockstore.OpenReadWrite(path, roots)
+	require.NoError(t, err)
+	require.NoError(t, subject.Put(ctx, extra))
+	require.NoError(t, subject.Finalize())
+	requireFinalizedWithBlocks(t, path, append(blks, extra))
+}
+
+func TestReadWritePutWithResult(t *testing.T) {
+	ctx := context.TODO()
+	blk := merkledag.NewRawNode([]byte("🦀")).Block
+	// A block with the same multihash as blk, but a different codec.
+	sameHash, err := blocks.NewBlockWithCid(blk.RawData(), cid.NewCidV1(cid.DagCBOR, blk.Cid().Hash()))
+	require.NoError(t, err)
+	identityMh, err := multihash.Sum([]byte("🦀"), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	identity, err := blocks.NewBlockWithCid([]byte("🦀"), cid.NewCidV1(cid.Raw, identityMh))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		opts         []carv2.Option
+		wantSameHash bool
+		wantIdentity bool
+		wantRepeat   bool
+	}{
+		{name: "Default"},
+		{name: "UseWholeCIDs", opts: []carv2.Option{blockstore.UseWholeCIDs(true)}, wantSameHash: true},
+		{name: "AllowDuplicatePuts", opts: []carv2.Option{blockstore.AllowDuplicatePuts(true)}, wantRepeat: true},
+		{name: "StoreIdentityCIDs", opts: []carv2.Option{carv2.StoreIdentityCIDs(true)}, wantIdentity: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-put-with-result.car")
+			var hooked []cid.Cid
+			opts := append(tt.opts, blockstore.WithPutHook(func(c cid.Cid, _ int, err error) {
+				require.NoError(t, err)
+				hooked = append(hooked, c)
+			}))
+			subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blk.Cid()}, opts...)
+			require.NoError(t, err)
+			t.Cleanup(subject.Discard)
+
+			requireWritten := func(blk blocks.Block, want bool) {
+				got, err := subject.PutWithResult(ctx, blk)
+				require.NoError(t, err)
+				require.Equal(t, want, got.Written)
+				if !want {
+					require.Zero(t, got.Offset)
+					return
+				}
+				offsets, err := subject.Offsets(blk.Cid())
+				require.NoError(t, err)
+				require.Contains(t, offsets, got.Offset)
+			}
+			requireWritten(blk, true)
+			requireWritten(blk, tt.wantRepeat)
+			requireWritten(sameHash, tt.wantSameHash || tt.wantRepeat)
+			requireWritten(identity, tt.wantIdentity)
+			require.Equal(t, []cid.Cid{blk.Cid(), blk.Cid(), sameHash.Cid(), identity.Cid()}, hooked)
+		})
+	}
+}
+
+func TestReadWriteResumptionIndexesIdentityCIDsAsGenerateIndex(t *testing.T) {
+	ctx := context.TODO()
+	identityMh, err := multihash.Sum([]byte("🐚"), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	identity, err := blocks.NewBlockWithCid([]byte("🐚"), cid.NewCidV1(cid.Raw, identityMh))
+	require.NoError(t, err)
+	blks := []blocks.Block{
+		merkledag.NewRawNode([]byte("🐚-1")).Block,
+		identity,
+		merkledag.NewRawNode([]byte("🐚-2")).Block,
+	}
+	roots := []cid.Cid{blks[0].Cid()}
+
+	for _, withWAL := range []bool{false, true} {
+		for _, store := range []bool{false, true} {
+			t.Run(fmt.Sprintf("WAL=%t/StoreIdentityCIDs=%t", withWAL, store), func(t *testing.T) {
+				dir := t.TempDir()
+				path := filepath.Join(dir, "readwrite-identity.car")
+				var opts []carv2.Option
+				if withWAL {
+					opts = append(opts, blockstore.WithIndexWAL(filepath.Join(dir, "index.wal")))
+				}
+
+				// Write a file that contains a section with an identity CID, and leave it unfinalized.
+				subject, err := blockstore.OpenReadWrite(path, roots, append(opts, carv2.StoreIdentityCIDs(true))...)
+				require.NoError(t, err)
+				require.NoError(t, subject.PutMany(ctx, blks))
+				subject.Discard()
+
+				subject, err = blockstore.OpenReadWrite(path, roots, append(opts, carv2.StoreIdentityCIDs(store))...)
+				require.NoError(t, err)
+				require.NoError(t, subject.Finalize())
+
+				// Assert the finalized index agrees with the index generated from the data payload.
+				r, err := carv2.OpenReader(path)
+				require.NoError(t, err)
+				t.Cleanup(func() { require.NoError(t, r.Close()) })
+				ir, err := r.IndexReader()
+				require.NoError(t, err)
+				got, err := index.ReadFrom(ir)
+				require.NoError(t, err)
+				dr, err := r.DataReader()
+				require.NoError(t, err)
+				want, err := carv2.GenerateIndex(dr, carv2.StoreIdentityCIDs(store))
+				require.NoError(t, err)
+
+				var gotBuf, wantBuf bytes.Buffer
+				_, err = index.WriteTo(got, &gotBuf)
+				require.NoError(t, err)
+				_, err = index.WriteTo(want, &wantBuf)
+				require.NoError(t, err)
+				require.Equal(t, wantBuf.Bytes(), gotBuf.Bytes())
+
+				_, err = index.GetFirst(got, identity.Cid())
+				if store {
+					require.NoError(t, err)
+				} else {
+					require.Equal(t, index.ErrNotFound, err)
+				}
+			})
+		}
+	}
+}
+
+func TestReadWriteWithTraversalOrder(t *testing.T) {
+	ctx := context.TODO()
+	leaf := merkledag.NewRawNode([]byte("fish"))
+	otherLeaf := merkledag.NewRawNode([]byte("lobster"))
+	unrelated := merkledag.NewRawNode([]byte("barreleye"))
+	inline, err := cid.NewPrefixV1(cid.Raw, multihash.IDENTITY).Sum([]byte("inline"))
+	require.NoError(t, err)
+	pb := merkledag.NodeWithData([]byte("octopus"))
+	require.NoError(t, pb.AddNodeLink("fish", leaf))
+	require.NoError(t, pb.AddRawLink("inline", &format.Link{Cid: inline}))
+	root, err := cbor.WrapObject(map[string]interface{}{
+		"a": pb.Cid(),
+		"b": otherLeaf.Cid(),
+		"c": leaf.Cid(),
+	}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	roots := []cid.Cid{root.Cid()}
+
+	for _, v1 := range []bool{false, true} {
+		t.Run(fmt.Sprintf("WriteAsCarV1=%t", v1), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-traversal-order.car")
+			var subject *blockstore.ReadWrite
+			loads := make(map[cid.Cid]int)
+			ls := cidlink.DefaultLinkSystem()
+			ls.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+				c := lnk.(cidlink.Link).Cid
+				loads[c]++
+				blk, err := subject.Get(lctx.Ctx, c)
+				if err != nil {
+					return nil, err
+				}
+				return bytes.NewReader(blk.RawData()), nil
+			}
+			subject, err = blockstore.OpenReadWrite(path, roots,
+				blockstore.WriteAsCarV1(v1),
+				blockstore.WithTraversalOrder(ls, root.Cid()))
+			require.NoError(t, err)
+
+			// Put blocks leaves first, along with a block that is not part of the DAG.
+			require.NoError(t, subject.PutMany(ctx, []blocks.Block{leaf, unrelated, otherLeaf, pb, leaf, root}))
+			require.NoError(t, subject.Finalize())
+
+			// Assert each block is loaded once, and identity CIDs are not loaded at all.
+			require.Equal(t, map[cid.Cid]int{root.Cid(): 1, pb.Cid(): 1, leaf.Cid(): 1, otherLeaf.Cid(): 1}, loads)
+
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, f.Close()) })
+			br, err := carv2.NewBlockReader(f)
+			require.NoError(t, err)
+			require.Equal(t, roots, br.Roots)
+			var got []cid.Cid
+			for {
+				blk, err := br.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				got = append(got, blk.Cid())
+			}
+			require.Equal(t, []cid.Cid{root.Cid(), pb.Cid(), leaf.Cid(), otherLeaf.Cid()}, got)
+
+			_, err = f.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			require.NoError(t, carv2.VerifyStream(f, root.Cid()))
+
+			if !v1 {
+				robs, err := blockstore.OpenReadOnly(path)
+				require.NoError(t, err)
+				t.Cleanup(func() { require.NoError(t, robs.Close()) })
+				for _, blk := range []blocks.Block{root, pb, leaf, otherLeaf} {
+					gotBlk, err := robs.Get(ctx, blk.Cid())
+					require.NoError(t, err)
+					require.Equal(t, blk.RawData(), gotBlk.RawData())
+				}
+				has, err := robs.Has(ctx, unrelated.Cid())
+				require.NoError(t, err)
+				require.False(t, has)
+			}
+		})
+	}
+
+	t.Run("MissingBlockIsError", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "readwrite-traversal-order-missing.car")
+		var subject *blockstore.ReadWrite
+		ls := cidlink.DefaultLinkSystem()
+		ls.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			blk, err := subject.Get(lctx.Ctx, lnk.(cidlink.Link).Cid)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(blk.RawData()), nil
+		}
+		subject, err = blockstore.OpenReadWrite(path, roots, blockstore.WithTraversalOrder(ls, root.Cid()))
+		require.NoError(t, err)
+		t.Cleanup(subject.Discard)
+		require.NoError(t, subject.PutMany(ctx, []blocks.Block{root, pb, leaf}))
+
+		require.Error(t, subject.Finalize())
+
+		// Assert the blockstore is left as is.
+		for _, blk := range []blocks.Block{root, pb, leaf} {
+			got, err := subject.Get(ctx, blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, blk.RawData(), got.RawData())
+		}
+		require.NoError(t, subject.Put(ctx, otherLeaf))
+		require.NoError(t, subject.Finalize())
+	})
+}
+
+func TestReadWriteWithVerifyPutHashes(t *testing.T) {
+	ctx := context.TODO()
+	newBlock := func(data []byte, prefix cid.Prefix) blocks.Block {
+		c, err := prefix.Sum(data)
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid(data, c)
+		require.NoError(t, err)
+		return blk
+	}
+	withCid := func(data []byte, c cid.Cid) blocks.Block {
+		blk, err := blocks.NewBlockWithCid(data, c)
+		require.NoError(t, err)
+		return blk
+	}
+	rawSha256 := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}
+	cborSha512 := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_512, MhLength: -1}
+	truncatedSha256 := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: 20}
+	identityPrefix := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.IDENTITY, MhLength: -1}
+
+	root := newBlock([]byte("fish"), rawSha256)
+	valid := []blocks.Block{
+		root,
+		newBlock([]byte("lobster"), cborSha512),
+		newBlock([]byte("barreleye"), truncatedSha256),
+	}
+	// Identity CIDs are not verified, and are not stored by default.
+	identityMismatch := withCid([]byte("🦞"), newBlock([]byte("🦀"), identityPrefix).Cid())
+	mismatches := []blocks.Block{
+		withCid([]byte("squid"), root.Cid()),
+		withCid([]byte("squid"), valid[1].Cid()),
+		withCid([]byte("squid"), valid[2].Cid()),
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		subject, err := blockstore.OpenReadWrite(filepath.Join(t.TempDir(), "readwrite-unverified.car"), []cid.Cid{root.Cid()})
+		require.NoError(t, err)
+		t.Cleanup(subject.Discard)
+		require.NoError(t, subject.PutMany(ctx, mismatches))
+	})
+
+	path := filepath.Join(t.TempDir(), "readwrite-verified.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{root.Cid()}, blockstore.WithVerifyPutHashes())
+	require.NoError(t, err)
+	t.Cleanup(subject.Discard)
+	require.NoError(t, subject.PutMany(ctx, append(valid, identityMismatch)))
+
+	for _, mismatch := range mismatches {
+		before, err := os.Stat(path)
+		require.NoError(t, err)
+
+		// Assert the block preceding the mismatched one is written, while the mismatched one and
+		// the one following it are not, not even partially.
+		preceding := newBlock([]byte(fmt.Sprintf("preceding %s", mismatch.Cid())), rawSha256)
+		following := newBlock([]byte(fmt.Sprintf("following %s", mismatch.Cid())), rawSha256)
+		err = subject.PutMany(ctx, []blocks.Block{preceding, mismatch, following})
+		var mismatchErr *carv2.ErrHashMismatch
+		require.True(t, errors.As(err, &mismatchErr))
+		require.Equal(t, mismatch.Cid(), mismatchErr.Cid)
+		wantActual, err := mismatch.Cid().Prefix().Sum(mismatch.RawData())
+		require.NoError(t, err)
+		require.Equal(t, wantActual.Hash(), mismatchErr.Actual)
+
+		after, err := os.Stat(path)
+		require.NoError(t, err)
+		sectionLen := uint64(preceding.Cid().ByteLen() + len(preceding.RawData()))
+		require.Equal(t, before.Size()+int64(varint.UvarintSize(sectionLen))+int64(sectionLen), after.Size())
+		valid = append(valid, preceding)
+
+		has, err := subject.Has(ctx, following.Cid())
+		require.NoError(t, err)
+		require.False(t, has)
+		offsets, err := subject.Offsets(mismatch.Cid())
+		require.NoError(t, err)
+		require.Len(t, offsets, 1)
+	}
+	require.NoError(t, subject.Finalize())
+
+	// Assert the finalized CAR only contains the valid blocks, which BlockReader verifies.
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	br, err := carv2.NewBlockReader(f)
+	require.NoError(t, err)
+	for _, want := range valid {
+		got, err := br.Next()
+		require.NoError(t, err)
+		require.Equal(t, want.Cid(), got.Cid())
+		require.Equal(t, want.RawData(), got.RawData())
+	}
+	_, err = br.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReadWriteEstimatedFinalSize(t *testing.T) {
+	ctx := context.TODO()
+	var blks []blocks.Block
+	for i := 0; i < 300; i++ {
+		data := []byte(fmt.Sprintf("block %d", i))
+		prefix := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}
+		switch i % 3 {
+		case 1:
+			prefix = cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_512, MhLength: -1}
+		case 2:
+			prefix = cid.Prefix{Version: 0, Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1}
+		}
+		c, err := prefix.Sum(data)
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid(data, c)
+		require.NoError(t, err)
+		blks = append(blks, blk)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []carv2.Option
+	}{
+		{name: "Default"},
+		{name: "WithoutIndexChecksum", opts: []carv2.Option{blockstore.WithIndexChecksum(false)}},
+		{name: "WithPadding", opts: []carv2.Option{carv2.UseDataPadding(1413), carv2.UseIndexPadding(1314)}},
+		{name: "WithDuplicates", opts: []carv2.Option{blockstore.AllowDuplicatePuts(true)}},
+		{name: "CarIndexSorted", opts: []carv2.Option{carv2.UseIndexCodec(multicodec.CarIndexSorted)}},
+		{name: "CidIndexSorted", opts: []carv2.Option{carv2.UseIndexCodec(index.CarCidIndexSorted)}},
+		{name: "MultihashSizedIndexSorted", opts: []carv2.Option{carv2.UseIndexCodec(index.CarMultihashSizedIndexSorted)}},
+		{name: "MultihashIndexHashed", opts: []carv2.Option{carv2.UseIndexCodec(index.CarMultihashIndexHashed)}},
+		{name: "WriteAsCarV1", opts: []carv2.Option{blockstore.WriteAsCarV1(true)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-estimated-final-size.car")
+			subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()}, tc.opts...)
+			require.NoError(t, err)
+
+			// Assert the estimate grows with every block put, including duplicates if allowed.
+			last := subject.EstimatedFinalSize()
+			for _, blk := range append(blks, blks[:10]...) {
+				require.NoError(t, subject.Put(ctx, blk))
+				got := subject.EstimatedFinalSize()
+				require.GreaterOrEqual(t, got, last)
+				last = got
+			}
+			require.Greater(t, last, uint64(len(blks)))
+
+			want := subject.EstimatedFinalSize()
+			require.NoError(t, subject.Finalize())
+			stat, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, want, uint64(stat.Size()))
+		})
+	}
+
+	t.Run("WithTraversalOrderIsUpperBound", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "readwrite-estimated-final-size-traversal.car")
+		var subject *blockstore.ReadWrite
+		ls := cidlink.DefaultLinkSystem()
+		ls.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			blk, err := subject.Get(lctx.Ctx, lnk.(cidlink.Link).Cid)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(blk.RawData()), nil
+		}
+		root := blks[0].Cid()
+		subject, err := blockstore.OpenReadWrite(path, []cid.Cid{root}, blockstore.WithTraversalOrder(ls, root))
+		require.NoError(t, err)
+		require.NoError(t, subject.PutMany(ctx, blks))
+
+		// Only the root is reachable, such that every other block is dropped.
+		estimate := subject.EstimatedFinalSize()
+		require.NoError(t, subject.Finalize())
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Less(t, uint64(stat.Size()), estimate)
+	})
+}
+
+func TestReadWriteWithDataChecksum(t *testing.T) {
+	ctx := context.TODO()
+	for _, algo := range []multicodec.Code{multicodec.Sha2_256, carv2.Crc32c} {
+		t.Run(algo.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-data-checksum.car")
+			subject, err := blockstore.OpenReadWrite(path, []cid.Cid{oneTestBlockWithCidV1.Cid()},
+				carv2.WithDataChecksum(algo),
+				carv2.UseIndexPadding(13))
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(ctx, []blocks.Block{oneTestBlockWithCidV1, anotherTestBlockWithCidV0}))
+			estimate := subject.EstimatedFinalSize()
+			require.NoError(t, subject.Finalize())
+			stat, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, estimate, uint64(stat.Size()))
+
+			r, err := carv2.OpenReader(path)
+			require.NoError(t, err)
+			require.NoError(t, r.VerifyDataChecksum())
+			header := r.Header
+			require.NoError(t, r.Close())
+
+			robs, err := blockstore.OpenReadOnly(path)
+			require.NoError(t, err)
+			got, err := robs.Get(ctx, anotherTestBlockWithCidV0.Cid())
+			require.NoError(t, err)
+			require.Equal(t, anotherTestBlockWithCidV0.RawData(), got.RawData())
+			require.NoError(t, robs.Close())
+
+			// Assert a single flipped byte in the data payload is detected.
+			f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+			require.NoError(t, err)
+			at := int64(header.DataOffset+header.DataSize) - 1
+			last := make([]byte, 1)
+			_, err = f.ReadAt(last, at)
+			require.NoError(t, err)
+			last[0] ^= 0xff
+			_, err = f.WriteAt(last, at)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			r, err = carv2.OpenReader(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, r.Close()) })
+			var mismatch *carv2.ErrDataChecksumMismatch
+			err = r.VerifyDataChecksum()
+			require.True(t, errors.As(err, &mismatch), "expected checksum mismatch, got %v", err)
+		})
+	}
+
+	_, err := blockstore.OpenReadWrite(filepath.Join(t.TempDir(), "readwrite-data-checksum-unsupported.car"), []cid.Cid{},
+		carv2.WithDataChecksum(multicodec.Md5))
+	require.EqualError(t, err, "unsupported data checksum algorithm: md5")
+}
+
+func TestReadWritePutAt(t *testing.T) {
+	ctx := context.Background()
+	src, err := blockstore.OpenReadOnly("../testdata/sample-v1.car", blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, src.Close()) })
+	roots, err := src.Roots()
+	require.NoError(t, err)
+	type section struct {
+		blk    blocks.Block
+		offset uint64
+	}
+	var layout []section
+	require.NoError(t, src.EachBlock(ctx, func(c cid.Cid, data []byte, offset uint64) error {
+		blk, err := blocks.NewBlockWithCid(append([]byte(nil), data...), c)
+		if err != nil {
+			return err
+		}
+		layout = append(layout, section{blk, offset})
+		return nil
+	}))
+	require.NotEmpty(t, layout)
+
+	// Reconstruct the data payload from its offset map, putting blocks in reverse order.
+	path := filepath.Join(t.TempDir(), "putat.car")
+	subject, err := blockstore.OpenReadWrite(path, roots, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	for i := len(layout) - 1; i >= 0; i-- {
+		require.NoError(t, subject.PutAt(layout[i].offset, layout[i].blk))
+	}
+	for _, s := range layout[:10] {
+		if s.blk.Cid().Prefix().MhType == multihash.IDENTITY {
+			continue
+		}
+		offsets, err := subject.Offsets(s.blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, []uint64{s.offset}, offsets)
+	}
+
+	// Assert writes overlapping the CARv1 header or any section are rejected.
+	first, last := layout[0], layout[len(layout)-1]
+	extra := merkledag.NewRawNode([]byte("extra")).Block
+	require.Error(t, subject.PutAt(0, extra))
+	require.Error(t, subject.PutAt(first.offset-1, extra))
+	require.Error(t, subject.PutAt(first.offset, extra))
+	require.Error(t, subject.PutAt(last.offset+1, extra))
+
+	// Assert sequential puts are written after every section put via PutAt.
+	result, err := subject.PutWithResult(ctx, extra)
+	require.NoError(t, err)
+	require.True(t, result.Written)
+	info, err := os.Stat("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	require.Equal(t, uint64(info.Size()), result.Offset)
+	require.NoError(t, subject.Finalize())
+
+	// Assert the data payload is identical to the original one, followed by the extra block.
+	want, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	reader, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reader.Close()) })
+	dr, err := reader.DataReader()
+	require.NoError(t, err)
+	got, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	require.Equal(t, want, got[:len(want)])
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, robs.Close()) })
+	gotExtra, err := robs.Get(ctx, extra.Cid())
+	require.NoError(t, err)
+	require.Equal(t, extra.RawData(), gotExtra.RawData())
+}
+
+func TestReadWritePutAtRejectsOverlapWithIdentitySection(t *testing.T) {
+	ctx := context.Background()
+	idmh, err := multihash.Sum([]byte("identity"), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	identity, err := blocks.NewBlockWithCid([]byte("identity"), cid.NewCidV1(cid.Raw, idmh))
+	require.NoError(t, err)
+	first := merkledag.NewRawNode([]byte("first")).Block
+	other := merkledag.NewRawNode([]byte("other")).Block
+	sectionLen := func(blk blocks.Block) uint64 {
+		size := uint64(len(blk.Cid().Bytes()) + len(blk.RawData()))
+		return uint64(varint.UvarintSize(size)) + size
+	}
+
+	mem := &memReadWriteAt{}
+	subject, err := blockstore.NewReadWrite(mem, []cid.Cid{first.Cid()})
+	require.NoError(t, err)
+	result, err := subject.PutWithResult(ctx, first)
+	require.NoError(t, err)
+	identityOffset := result.Offset + sectionLen(first)
+	require.NoError(t, subject.PutAt(identityOffset, identity))
+
+	assertRejected := func(subject *blockstore.ReadWrite) {
+		// The identity section is not indexed, yet must not be overwritten.
+		has, err := subject.Has(ctx, identity.Cid())
+		require.NoError(t, err)
+		require.True(t, has)
+		for _, offset := range []uint64{
+			identityOffset,
+			identityOffset + 1,
+			identityOffset + sectionLen(identity) - 1,
+		} {
+			require.Error(t, subject.PutAt(offset, other))
+		}
+		require.NoError(t, subject.PutAt(identityOffset+sectionLen(identity), other))
+	}
+	assertRejected(subject)
+
+	// Assert the identity section is accounted for after resuming, once found by scanning the data
+	// payload.
+	subject.Discard()
+	mem.closed = false
+	mem.buf = mem.buf[:len(mem.buf)-int(sectionLen(other))]
+	subject, err = blockstore.NewReadWrite(mem, []cid.Cid{first.Cid()})
+	require.NoError(t, err)
+	assertRejected(subject)
+	require.NoError(t, subject.Finalize())
+}
+
+// memReadWriteAt is an in-memory blockstore.ReadWriteAt.
+type memReadWriteAt struct {
+	buf    []byte
+	closed bool
+}
+
+func (m *memReadWriteAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memReadWriteAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(m.buf)) {
+		m.buf = append(m.buf, make([]byte, end-int64(len(m.buf)))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+func (m *memReadWriteAt) Size() (int64, error) { return int64(len(m.buf)), nil }
+
+func (m *memReadWriteAt) Truncate(size int64) error {
+	if size <= int64(len(m.buf)) {
+		m.buf = m.buf[:size]
+	} else {
+		m.buf = append(m.buf, make([]byte, size-int64(len(m.buf)))...)
+	}
+	return nil
+}
+
+func (m *memReadWriteAt) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestNewReadWrite(t *testing.T) {
+	ctx := context.TODO()
+	var blks []blocks.Block
+	for i := 0; i < 10; i++ {
+		blks = append(blks, merkledag.NewRawNode([]byte(fmt.Sprintf("🐟-%d", i))).Block)
+	}
+	roots := []cid.Cid{blks[0].Cid()}
+
+	// Write the same blocks to a file, to compare the CAR written in memory against.
+	path := filepath.Join(t.TempDir(), "readwrite.car")
+	want, err := blockstore.OpenReadWrite(path, roots, carv2.UseDataPadding(17))
+	require.NoError(t, err)
+	require.NoError(t, want.PutMany(ctx, blks))
+	require.NoError(t, want.Finalize())
+	wantBytes, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// Put half of the blocks, and resume from the storage without finalizing.
+	mem := &memReadWriteAt{}
+	subject, err := blockstore.NewReadWrite(mem, roots, carv2.UseDataPadding(17))
+	require.NoError(t, err)
+	require.NoError(t, subject.PutMany(ctx, blks[:5]))
+	subject.Discard()
+	require.True(t, mem.closed)
+	mem.closed = false
+
+	subject, err = blockstore.NewReadWrite(mem, roots, carv2.UseDataPadding(17))
+	require.NoError(t, err)
+	for _, blk := range blks[:5] {
+		got, err := subject.Get(ctx, blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+	require.NoError(t, subject.PutMany(ctx, blks[5:]))
+	require.NoError(t, subject.Finalize())
+	require.True(t, mem.closed)
+	require.Equal(t, wantBytes, mem.buf)
+
+	// Assert resuming from a finalized CAR in storage removes its index.
+	mem.closed = false
+	subject, err = blockstore.NewReadWrite(mem, roots, carv2.UseDataPadding(17))
+	require.NoError(t, err)
+	has, err := subject.Has(ctx, blks[9].Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+	require.NoError(t, subject.Finalize())
+	require.Equal(t, wantBytes, mem.buf)
+
+	// Assert the storage is closed when instantiation fails.
+	mem.closed = false
+	_, err = blockstore.NewReadWrite(mem, []cid.Cid{blks[1].Cid()}, carv2.UseDataPadding(17))
+	require.Error(t, err)
+	require.True(t, mem.closed)
+}
+
+func TestReadWriteIndex(t *testing.T) {
+	ctx := context.TODO()
+	var blks []blocks.Block
+	for i := 0; i < 20; i++ {
+		blks = append(blks, merkledag.NewRawNode([]byte(fmt.Sprintf("🦀-%d", i))).Block)
+	}
+	missing := merkledag.NewRawNode([]byte("lobster")).Cid()
+
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, index.CarMultihashSizedIndexSorted} {
+		codec := codec
+		t.Run(codec.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite-index.car")
+			subject, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()}, carv2.UseIndexCodec(codec))
+			require.NoError(t, err)
+			require.NoError(t, subject.PutMany(ctx, blks[:10]))
+
+			snapshot, err := subject.Index()
+			require.NoError(t, err)
+			require.Equal(t, codec, snapshot.Codec())
+
+			// Assert the snapshot answers the same lookups as the live blockstore.
+			for _, blk := range blks[:10] {
+				want, err := subject.Offsets(blk.Cid())
+				require.NoError(t, err)
+				var got []uint64
+				require.NoError(t, snapshot.GetAll(blk.Cid(), func(o uint64) bool {
+					got = append(got, o)
+					return true
+				}))
+				require.Equal(t, want, got)
+				if sized, ok := snapshot.(index.SizedIndex); ok {
+					size, known, err := sized.GetSize(blk.Cid())
+					require.NoError(t, err)
+					require.True(t, known)
+					wantSize, err := subject.GetSize(ctx, blk.Cid())
+					require.NoError(t, err)
+					require.Equal(t, uint64(wantSize), size)
+				}
+			}
+			_, err = index.GetFirst(snapshot, missing)
+			require.True(t, errors.Is(err, index.ErrNotFound))
+
+			// Assert blocks put after the snapshot is taken are not in it.
+			require.NoError(t, subject.PutMany(ctx, blks[10:]))
+			_, err = index.GetFirst(snapshot, blks[10].Cid())
+			require.True(t, errors.Is(err, index.ErrNotFound))
+
+			// Assert the snapshot is identical to the index written once finalized.
+			snapshot, err = subject.Index()
+			require.NoError(t, err)
+			var want bytes.Buffer
+			_, err = index.WriteTo(snapshot, &want)
+			require.NoError(t, err)
+			require.NoError(t, subject.Finalize())
+			_, err = subject.Index()
+			require.Error(t, err)
+
+			reader, err := carv2.OpenReader(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, reader.Close()) })
+			ir, err := reader.IndexReader()
+			require.NoError(t, err)
+			finalized, err := index.ReadFrom(ir)
+			require.NoError(t, err)
+			var got bytes.Buffer
+			_, err = index.WriteTo(finalized, &got)
+			require.NoError(t, err)
+			require.Equal(t, want.Bytes(), got.Bytes())
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package blockstore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/ipld/go-car/v2/internal/links"
+	ipld "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// WithTraversalOrder is a write option which makes ReadWrite.Finalize rewrite the data payload
+// such that its blocks appear in the order they are visited by a depth-first traversal of the DAG
+// from the given root: the root comes first, and every block is followed by the blocks it links
+// to, in the order of its links, each visited once. Such CARs can be verified incrementally as they
+// are read, e.g. via car.VerifyStream, unlike CARs whose blocks appear in the order they were put.
+//
+// Blocks are loaded via the given link system, which may be backed by the blockstore itself, and
+// only blocks reachable from root are kept; any other block put is dropped from the data payload.
+// Blocks encoded as raw, dag-pb or dag-cbor are supported; raw blocks have no links. Identity CIDs
+// are not loaded; their inlined data is traversed as a block, and they are only written if
+// StoreIdentityCIDs is enabled.
+//
+// The DAG is traversed before the file is modified, such that if a block cannot be loaded or
+// decoded, or if finalization is cancelled during traversal, Finalize returns an error and leaves
+// the blockstore as is. The rewritten data payload is staged in a temporary file in the directory
+// of the CAR file. Blocks must not be put while Finalize traverses the DAG.
+//
+// Note that this option only affects the blockstore, and is ignored by the root
+// go-car/v2 package.
+func WithTraversalOrder(ls ipld.LinkSystem, root cid.Cid) carv2.Option {
+	return func(o *carv2.Options) {
+		o.BlockstoreTraversalLinkSystem = ls
+		o.BlockstoreTraversalRoot = root
+	}
+}
+
+// rewriteInTraversalOrder rewrites the data payload in the order set via WithTraversalOrder, and
+// re-indexes it. It must be called without b.ronly.mu held, since the link system may be backed
+// by the blockstore itself.
+func (b *ReadWrite) rewriteInTraversalOrder(ctx context.Context) error {
+	payloadOffset := int64(b.header.DataOffset)
+	if b.opts.WriteAsCarV1 {
+		payloadOffset = 0
+	}
+	hr, err := internalio.NewOffsetReadSeeker(b.f, payloadOffset)
+	if err != nil {
+		return err
+	}
+	header, err := carv1.ReadHeader(hr, b.opts.MaxAllowedHeaderSize)
+	if err != nil {
+		return err
+	}
+	firstSectionOffset, err := carv1.HeaderSize(header)
+	if err != nil {
+		return err
+	}
+
+	// Stage next to the file written, if any, such that the staged payload is on the same disk.
+	var dir string
+	if rf, ok := b.f.(*readWriteFile); ok {
+		dir = filepath.Dir(rf.Name())
+	}
+	staged, err := os.CreateTemp(dir, ".car-traversal-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		staged.Close()
+		os.Remove(staged.Name())
+	}()
+	sw := bufio.NewWriter(staged)
+	records, err := b.writeTraversal(ctx, sw, firstSectionOffset)
+	if err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	b.ronly.mu.Lock()
+	defer b.ronly.mu.Unlock()
+	if b.ronly.closed {
+		return errClosed
+	}
+	// From here on, the data payload is partially overwritten should anything fail.
+	if _, err := b.dataWriter.Seek(int64(firstSectionOffset), io.SeekStart); err != nil {
+		b.abortFinalize()
+		return err
+	}
+	if _, err := io.Copy(b.dataWriter, staged); err != nil {
+		b.abortFinalize()
+		return err
+	}
+	if err := b.f.Truncate(payloadOffset + b.dataWriter.Position()); err != nil {
+		b.abortFinalize()
+		return err
+	}
+	*b.idx = *index.NewInsertionIndexWithMode(b.opts.BlockstoreInsertionIndexMode)
+	b.unindexed = nil
+	for _, r := range records {
+		b.idx.InsertSizedNoReplace(r.Cid, r.Offset, r.Size)
+	}
+	if b.wal != nil {
+		if err := b.wal.reset(); err != nil {
+			b.abortFinalize()
+			return err
+		}
+		for _, r := range records {
+			if err := b.wal.append(r.Cid, r.Offset, r.Size); err != nil {
+				b.abortFinalize()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeTraversal writes the sections of the blocks visited by a depth-first traversal of the DAG
+// from the root set via WithTraversalOrder to w, and returns their records, with offsets starting
+// at the given offset.
+func (b *ReadWrite) writeTraversal(ctx context.Context, w io.Writer, offset uint64) ([]index.Record, error) {
+	ls := b.opts.BlockstoreTraversalLinkSystem
+	var records []index.Record
+	visited := make(map[cid.Cid]struct{})
+	stack := []cid.Cid{b.opts.BlockstoreTraversalRoot}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, seen := visited[c]; seen {
+			continue
+		}
+		visited[c] = struct{}{}
+
+		var data []byte
+		identity := c.Prefix().MhType == multihash.IDENTITY
+		if identity {
+			dmh, err := multihash.Decode(c.Hash())
+			if err != nil {
+				return nil, err
+			}
+			data = dmh.Digest
+		} else {
+			var err error
+			if data, err = ls.LoadRaw(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}); err != nil {
+				return nil, fmt.Errorf("cannot load block %s: %w", c, err)
+			}
+		}
+		linked, err := links.Extract(c, data)
+		if err != nil {
+			return nil, err
+		}
+		// Push links in reverse, such that they are visited in order.
+		for i := len(linked) - 1; i >= 0; i-- {
+			if _, seen := visited[linked[i]]; !seen {
+				stack = append(stack, linked[i])
+			}
+		}
+
+		if identity && !b.opts.StoreIdentityCIDs {
+			continue
+		}
+		cSize := uint64(len(c.Bytes()))
+		if cSize > b.opts.MaxIndexCidSize {
+			return nil, &carv2.ErrCidTooLarge{MaxSize: b.opts.MaxIndexCidSize, CurrentSize: cSize}
+		}
+		if err := util.LdWrite(w, c.Bytes(), data); err != nil {
+			return nil, err
+		}
+		records = append(records, index.Record{Cid: c, Offset: offset, Size: cSize + uint64(len(data))})
+		offset += util.LdSize(c.Bytes(), data)
+	}
+	return records, nil
+}
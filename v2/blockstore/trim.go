@@ -0,0 +1,70 @@
+package blockstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+)
+
+// TrimToRoots writes to dst a CARv1 containing only the blocks of src that are reachable from the
+// roots declared by src, i.e. without any orphan blocks, and returns the number of blocks dropped.
+// The roots are preserved as declared, and the blocks written are copied in the order in which they
+// appear in the data payload of src, with their CIDs as is. The result may be wrapped in a CARv2
+// via car.WrapV1.
+//
+// Reachable blocks are found as they are by VerifyComplete, such that blocks encoded as raw, dag-pb
+// or dag-cbor are supported, and an error is returned for blocks using any other codec. Blocks
+// missing from src are skipped. The set of reachable CIDs is held in memory, whereas the blocks
+// themselves are read from src one at a time. Duplicate sections of a reachable block are kept.
+//
+// Sections are matched against reachable CIDs as they are by lookups of src, i.e. by multihash
+// unless UseWholeCIDs or WithStrictCodecMatch is enabled.
+func TrimToRoots(ctx context.Context, src *ReadOnly, dst io.Writer) (uint64, error) {
+	roots, err := src.Roots()
+	if err != nil {
+		return 0, err
+	}
+	wholeCIDs := src.opts.BlockstoreUseWholeCIDs || src.opts.BlockstoreStrictCodecMatch
+	reachable := make(map[cid.Cid]struct{})
+	err = walkDAG(ctx, src, roots, func(c cid.Cid, found bool) {
+		if found {
+			reachable[reachableKey(c, wholeCIDs)] = struct{}{}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: 1}, dst); err != nil {
+		return 0, err
+	}
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	if src.closed {
+		return 0, errClosed
+	}
+	var dropped uint64
+	err = src.eachBlock(ctx, true, func(c cid.Cid, data []byte, _ uint64) error {
+		if _, ok := reachable[reachableKey(c, wholeCIDs)]; !ok {
+			dropped++
+			return nil
+		}
+		return util.LdWrite(dst, c.Bytes(), data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
+
+// reachableKey returns the key of the given CID in the set of reachable CIDs, which is the CID
+// itself if whole CIDs are matched, and the CID flattened to the raw codec otherwise.
+func reachableKey(c cid.Cid, wholeCIDs bool) cid.Cid {
+	if wholeCIDs {
+		return c
+	}
+	return cid.NewCidV1(cid.Raw, c.Hash())
+}
@@ -0,0 +1,83 @@
+package blockstore_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrimToRoots(t *testing.T) {
+	ctx := context.TODO()
+
+	leaf := merkledag.NewRawNode([]byte("fish"))
+	pb := merkledag.NodeWithData([]byte("octopus"))
+	require.NoError(t, pb.AddNodeLink("fish", leaf))
+	root, err := cbor.WrapObject(map[string]interface{}{"pb": pb.Cid()}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	// Orphans include a dag-pb node linking to a reachable leaf, which must not make it reachable.
+	orphanLeaf := merkledag.NewRawNode([]byte("lobster"))
+	orphanPb := merkledag.NodeWithData([]byte("barreleye"))
+	require.NoError(t, orphanPb.AddNodeLink("fish", leaf))
+	orphanRaw := merkledag.NewRawNode([]byte("undadasea"))
+
+	path := filepath.Join(t.TempDir(), "readwrite-trim.car")
+	rw, err := blockstore.OpenReadWrite(path, []cid.Cid{root.Cid()})
+	require.NoError(t, err)
+	require.NoError(t, rw.PutMany(ctx, []blocks.Block{orphanLeaf, root, orphanPb, pb, orphanRaw, leaf}))
+	require.NoError(t, rw.Finalize())
+
+	src, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, src.Close()) })
+	var buf bytes.Buffer
+	dropped, err := blockstore.TrimToRoots(ctx, src, &buf)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), dropped)
+
+	// Assert the reachable blocks are kept in order with their roots, and the orphans are gone.
+	br, err := carv2.NewBlockReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), br.Version)
+	require.Equal(t, []cid.Cid{root.Cid()}, br.Roots)
+	var got []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, blk.Cid())
+	}
+	require.Equal(t, []cid.Cid{root.Cid(), pb.Cid(), leaf.Cid()}, got)
+
+	// Assert the trimmed DAG is still complete.
+	trimmed, err := blockstore.NewReadOnly(bytes.NewReader(buf.Bytes()), nil)
+	require.NoError(t, err)
+	missing, err := blockstore.VerifyComplete(ctx, trimmed, []cid.Cid{root.Cid()})
+	require.NoError(t, err)
+	require.Empty(t, missing)
+	for _, orphan := range []cid.Cid{orphanLeaf.Cid(), orphanPb.Cid(), orphanRaw.Cid()} {
+		has, err := trimmed.Has(ctx, orphan)
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+
+	// Assert a CAR without orphans is left as is.
+	var again bytes.Buffer
+	dropped, err = blockstore.TrimToRoots(ctx, trimmed, &again)
+	require.NoError(t, err)
+	require.Zero(t, dropped)
+	require.Equal(t, buf.Bytes(), again.Bytes())
+}
@@ -0,0 +1,94 @@
+package blockstore
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car/v2/internal/links"
+	"github.com/multiformats/go-multihash"
+)
+
+// VerifyComplete checks that the DAG reachable from the given roots is fully present in the given
+// blockstore. Starting from the roots, every block is decoded and the CIDs it links to are looked
+// up in turn, visiting each block at most once.
+//
+// The CIDs that are linked to, or declared as roots, but are absent from the blockstore are
+// returned in the order they were encountered. The returned slice is empty if the DAG is complete.
+//
+// Blocks encoded as raw, dag-pb or dag-cbor are supported; raw blocks have no links. An error is
+// returned if a present block uses any other codec, fails to decode, or cannot be read from the
+// blockstore. Identity CIDs are considered present, and their inlined data is traversed as a block.
+func VerifyComplete(ctx context.Context, bs blockstore.Blockstore, roots []cid.Cid) ([]cid.Cid, error) {
+	missing := make([]cid.Cid, 0)
+	err := walkDAG(ctx, bs, roots, func(c cid.Cid, found bool) {
+		if !found {
+			missing = append(missing, c)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// walkDAG traverses the DAG reachable from the given roots breadth-first, visiting each CID at most
+// once, and calls visit with every CID visited along with whether its block is present in bs. The
+// links of present blocks are traversed in turn. See VerifyComplete.
+func walkDAG(ctx context.Context, bs blockstore.Blockstore, roots []cid.Cid, visit func(c cid.Cid, found bool)) error {
+	visited := make(map[cid.Cid]struct{})
+	queue := make([]cid.Cid, 0, len(roots))
+	for _, root := range roots {
+		if _, seen := visited[root]; !seen {
+			visited[root] = struct{}{}
+			queue = append(queue, root)
+		}
+	}
+
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c := queue[0]
+		queue = queue[1:]
+
+		data, found, err := loadBlockData(ctx, bs, c)
+		if err != nil {
+			return err
+		}
+		visit(c, found)
+		if !found {
+			continue
+		}
+		linked, err := links.Extract(c, data)
+		if err != nil {
+			return err
+		}
+		for _, link := range linked {
+			if _, seen := visited[link]; !seen {
+				visited[link] = struct{}{}
+				queue = append(queue, link)
+			}
+		}
+	}
+	return nil
+}
+
+func loadBlockData(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) ([]byte, bool, error) {
+	if c.Prefix().MhType == multihash.IDENTITY {
+		dmh, err := multihash.Decode(c.Hash())
+		if err != nil {
+			return nil, false, err
+		}
+		return dmh.Digest, true, nil
+	}
+	has, err := bs.Has(ctx, c)
+	if err != nil || !has {
+		return nil, false, err
+	}
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, false, err
+	}
+	return blk.RawData(), true, nil
+}
@@ -0,0 +1,79 @@
+package blockstore_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyComplete(t *testing.T) {
+	ctx := context.TODO()
+
+	leaf := merkledag.NewRawNode([]byte("fish"))
+	missingLeaf := merkledag.NewRawNode([]byte("lobster"))
+	missingPb := merkledag.NodeWithData([]byte("barreleye"))
+	missingRoot := merkledag.NewRawNode([]byte("undadasea"))
+	inline, err := cid.NewPrefixV1(cid.Raw, multihash.IDENTITY).Sum([]byte("inline"))
+	require.NoError(t, err)
+
+	pb := merkledag.NodeWithData([]byte("octopus"))
+	require.NoError(t, pb.AddNodeLink("fish", leaf))
+	require.NoError(t, pb.AddNodeLink("lobster", missingLeaf))
+
+	root, err := cbor.WrapObject(map[string]interface{}{
+		"pb":      pb.Cid(),
+		"again":   leaf.Cid(),
+		"inline":  inline,
+		"missing": missingPb.Cid(),
+	}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "readwrite-verify-complete.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{root.Cid(), missingRoot.Cid()})
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+	require.NoError(t, subject.PutMany(ctx, []blocks.Block{root, pb, leaf}))
+
+	got, err := blockstore.VerifyComplete(ctx, subject, []cid.Cid{root.Cid(), missingRoot.Cid()})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []cid.Cid{missingRoot.Cid(), missingPb.Cid(), missingLeaf.Cid()}, got)
+
+	// Assert the DAG under the dag-pb node alone is only missing its raw leaf.
+	got, err = blockstore.VerifyComplete(ctx, subject, []cid.Cid{pb.Cid()})
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{missingLeaf.Cid()}, got)
+
+	// Assert the DAG becomes complete once the missing blocks are added.
+	require.NoError(t, subject.PutMany(ctx, []blocks.Block{missingRoot, missingPb, missingLeaf}))
+	got, err = blockstore.VerifyComplete(ctx, subject, []cid.Cid{root.Cid(), missingRoot.Cid()})
+	require.NoError(t, err)
+	require.Empty(t, got)
+	require.NotNil(t, got)
+}
+
+func TestVerifyCompleteWithUnsupportedCodecIsError(t *testing.T) {
+	ctx := context.TODO()
+	data := []byte(`{"fish":"lobster"}`)
+	c, err := cid.NewPrefixV1(uint64(multicodec.DagJson), multihash.SHA2_256).Sum(data)
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(data, c)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "readwrite-verify-complete-unsupported.car")
+	subject, err := blockstore.OpenReadWrite(path, []cid.Cid{c})
+	require.NoError(t, err)
+	t.Cleanup(func() { subject.Discard() })
+	require.NoError(t, subject.Put(ctx, blk))
+
+	_, err = blockstore.VerifyComplete(ctx, subject, []cid.Cid{c})
+	require.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 )
 
 const (
@@ -128,6 +129,30 @@ func (h Header) WithDataPadding(padding uint64) Header {
 	return h
 }
 
+// ValidatePadding checks that the given data and index paddings are at most maxPadding, and that
+// the data and index offsets resulting from them can be represented as file offsets, i.e. do not
+// overflow int64.
+// An ErrPaddingTooLarge error is returned if either padding exceeds maxPadding.
+//
+// See: MaxAllowedPadding, Header.WithDataPadding, Header.WithIndexPadding.
+func ValidatePadding(dataPadding, indexPadding, maxPadding uint64) error {
+	if dataPadding > maxPadding {
+		return &ErrPaddingTooLarge{MaxSize: maxPadding, CurrentSize: dataPadding}
+	}
+	if indexPadding > maxPadding {
+		return &ErrPaddingTooLarge{MaxSize: maxPadding, CurrentSize: indexPadding}
+	}
+	dataOffset := PragmaSize + HeaderSize + dataPadding
+	if dataOffset < dataPadding || dataOffset > math.MaxInt64 {
+		return fmt.Errorf("invalid data padding %d; data offset overflows", dataPadding)
+	}
+	indexOffset := dataOffset + indexPadding
+	if indexOffset < dataOffset || indexOffset > math.MaxInt64 {
+		return fmt.Errorf("invalid index padding %d; index offset overflows", indexPadding)
+	}
+	return nil
+}
+
 func (h Header) WithDataSize(size uint64) Header {
 	h.DataSize = size
 	h.IndexOffset = size + h.IndexOffset
@@ -139,6 +164,23 @@ func (h Header) HasIndex() bool {
 	return h.IndexOffset != 0
 }
 
+// IndexSize returns the number of bytes available to the index within a CARv2 of the given total
+// size, starting at IndexOffset. The index is typically placed after the data payload, in which case
+// it extends up to the end of the CARv2. However, the index may be placed anywhere, such as before
+// the data payload, in which case it extends up to the start of the data payload at most.
+//
+// The returned size is an upper bound on the size of the index, which may be followed by padding.
+// Zero is returned if the index is not present, or if IndexOffset is past the end of the CARv2.
+func (h Header) IndexSize(totalSize int64) int64 {
+	if !h.HasIndex() || int64(h.IndexOffset) >= totalSize {
+		return 0
+	}
+	if h.IndexOffset < h.DataOffset {
+		return int64(h.DataOffset - h.IndexOffset)
+	}
+	return totalSize - int64(h.IndexOffset)
+}
+
 // WriteTo serializes this header as bytes and writes them using the given io.Writer.
 func (h Header) WriteTo(w io.Writer) (n int64, err error) {
 	wn, err := h.Characteristics.WriteTo(w)
@@ -2,6 +2,7 @@ package car_test
 
 import (
 	"bytes"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -193,6 +194,108 @@ func TestHeader_WithPadding(t *testing.T) {
 	}
 }
 
+func TestHeader_IndexSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		subject   carv2.Header
+		totalSize int64
+		want      int64
+	}{
+		{
+			"WhenIndexFollowsDataItExtendsToEnd",
+			carv2.NewHeader(123).WithIndexPadding(7),
+			carv2.PragmaSize + carv2.HeaderSize + 123 + 7 + 42,
+			42,
+		},
+		{
+			"WhenIndexPrecedesDataItExtendsToData",
+			carv2.Header{DataOffset: carv2.PragmaSize + carv2.HeaderSize + 42, DataSize: 123, IndexOffset: carv2.PragmaSize + carv2.HeaderSize},
+			carv2.PragmaSize + carv2.HeaderSize + 42 + 123,
+			42,
+		},
+		{
+			"WhenIndexIsNotPresentItIsZero",
+			carv2.Header{DataOffset: carv2.PragmaSize + carv2.HeaderSize, DataSize: 123},
+			carv2.PragmaSize + carv2.HeaderSize + 123,
+			0,
+		},
+		{
+			"WhenIndexOffsetIsPastEndItIsZero",
+			carv2.NewHeader(123),
+			carv2.PragmaSize + carv2.HeaderSize + 100,
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.subject.IndexSize(tt.totalSize))
+		})
+	}
+}
+
+func TestValidatePadding(t *testing.T) {
+	tests := []struct {
+		name         string
+		dataPadding  uint64
+		indexPadding uint64
+		maxPadding   uint64
+		wantErr      string
+	}{
+		{
+			name:       "ZeroPaddingIsValid",
+			maxPadding: carv2.DefaultMaxAllowedPadding,
+		},
+		{
+			name:         "TypicalPaddingIsValid",
+			dataPadding:  1413,
+			indexPadding: 1314,
+			maxPadding:   carv2.DefaultMaxAllowedPadding,
+		},
+		{
+			name:         "PaddingEqualToMaxIsValid",
+			dataPadding:  carv2.DefaultMaxAllowedPadding,
+			indexPadding: carv2.DefaultMaxAllowedPadding,
+			maxPadding:   carv2.DefaultMaxAllowedPadding,
+		},
+		{
+			name:        "DataPaddingLargerThanMaxIsError",
+			dataPadding: 1414,
+			maxPadding:  1413,
+			wantErr:     "padding size is larger than max allowed (1414 > 1413)",
+		},
+		{
+			name:         "IndexPaddingLargerThanMaxIsError",
+			indexPadding: 1414,
+			maxPadding:   1413,
+			wantErr:      "padding size is larger than max allowed (1414 > 1413)",
+		},
+		{
+			name:        "AbsurdDataPaddingOverflowIsError",
+			dataPadding: math.MaxUint64,
+			maxPadding:  math.MaxUint64,
+			wantErr:     "invalid data padding 18446744073709551615; data offset overflows",
+		},
+		{
+			name:         "AbsurdIndexPaddingOverflowIsError",
+			dataPadding:  1413,
+			indexPadding: math.MaxInt64,
+			maxPadding:   math.MaxUint64,
+			wantErr:      "invalid index padding 9223372036854775807; index offset overflows",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := carv2.ValidatePadding(tt.dataPadding, tt.indexPadding, tt.maxPadding)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestNewHeaderHasExpectedValues(t *testing.T) {
 	wantCarV1Len := uint64(1413)
 	want := carv2.Header{
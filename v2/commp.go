@@ -0,0 +1,155 @@
+package car
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// MinCommPPayloadSize is the minimum number of bytes over which CommP can compute a piece
+	// commitment, as mandated by Filecoin.
+	MinCommPPayloadSize = 65
+
+	// fr32 padding expands every 127 bytes of data to 128 bytes, by inserting two zero bits after
+	// every 254 bits such that each 32 byte node is a valid BLS12-381 field element.
+	fr32UnpaddedChunkSize = 127
+	fr32PaddedChunkSize   = 128
+	commPNodeSize         = 32
+
+	// commPReadChunks is the number of fr32 chunks read at a time by CommP.
+	commPReadChunks = 1 << 10
+)
+
+// CommP computes the Filecoin piece commitment of the bytes read from r, typically those of a CAR,
+// returning the piece CID along with the padded piece size, i.e. the size of the piece once it is
+// fr32 padded and zero padded to the next power of two.
+//
+// The bytes are fr32 padded and hashed into a binary merkle tree of SHA2-256 hashes truncated to
+// 254 bits as they are read, such that r is never buffered in memory as a whole. The returned CID
+// is a CIDv1 with the fil-commitment-unsealed codec and the sha2-256-trunc254-padded multihash.
+// At least MinCommPPayloadSize bytes must be read from r.
+func CommP(r io.Reader) (cid.Cid, uint64, error) {
+	var t commPTree
+	unpadded := make([]byte, fr32UnpaddedChunkSize*commPReadChunks)
+	padded := make([]byte, fr32PaddedChunkSize*commPReadChunks)
+	var size uint64
+	for {
+		n, err := io.ReadFull(r, unpadded)
+		if n > 0 {
+			size += uint64(n)
+			// Zero pad the last chunk, which is equivalent to zero padding the piece.
+			chunks := (n + fr32UnpaddedChunkSize - 1) / fr32UnpaddedChunkSize
+			for i := n; i < chunks*fr32UnpaddedChunkSize; i++ {
+				unpadded[i] = 0
+			}
+			for i := 0; i < chunks; i++ {
+				fr32Pad(unpadded[i*fr32UnpaddedChunkSize:(i+1)*fr32UnpaddedChunkSize], padded[i*fr32PaddedChunkSize:(i+1)*fr32PaddedChunkSize])
+			}
+			for i := 0; i < chunks*fr32PaddedChunkSize; i += commPNodeSize {
+				var leaf [commPNodeSize]byte
+				copy(leaf[:], padded[i:i+commPNodeSize])
+				t.add(0, leaf)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+	}
+	if size < MinCommPPayloadSize {
+		return cid.Undef, 0, fmt.Errorf("commp: payload of %d bytes is smaller than the minimum of %d bytes", size, MinCommPPayloadSize)
+	}
+
+	// Pad the piece to the next power of two, in units of fr32 chunks.
+	chunks := (size + fr32UnpaddedChunkSize - 1) / fr32UnpaddedChunkSize
+	paddedSize := uint64(fr32PaddedChunkSize)
+	if chunks > 1 {
+		paddedSize <<= bits.Len64(chunks - 1)
+	}
+	root := t.root(bits.TrailingZeros64(paddedSize / commPNodeSize))
+
+	mh, err := multihash.Encode(root[:], multihash.SHA2_256_TRUNC254_PADDED)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return cid.NewCidV1(cid.FilCommitmentUnsealed, mh), paddedSize, nil
+}
+
+// fr32Pad pads 127 bytes of in into 128 bytes of out, by inserting two zero bits after every 254
+// bits of in, read as a little-endian bit stream.
+func fr32Pad(in, out []byte) {
+	_ = in[fr32UnpaddedChunkSize-1]
+	_ = out[fr32PaddedChunkSize-1]
+
+	// The first 254 bits are copied as is.
+	copy(out[:32], in[:32])
+	out[31] &= 0x3f
+	// The next 254 bits start at bit 6 of byte 31.
+	for i := 0; i < 32; i++ {
+		out[32+i] = in[31+i]>>6 | in[32+i]<<2
+	}
+	out[63] &= 0x3f
+	// The next 254 bits start at bit 4 of byte 63.
+	for i := 0; i < 32; i++ {
+		out[64+i] = in[63+i]>>4 | in[64+i]<<4
+	}
+	out[95] &= 0x3f
+	// The last 254 bits start at bit 2 of byte 95.
+	for i := 0; i < 31; i++ {
+		out[96+i] = in[95+i]>>2 | in[96+i]<<6
+	}
+	out[127] = in[126] >> 2
+}
+
+// commPTree incrementally computes the root of a binary merkle tree, holding at most one pending
+// node per layer.
+type commPTree struct {
+	pending [64][commPNodeSize]byte
+	// hasPending has the i-th bit set if there is a pending node at layer i.
+	hasPending uint64
+}
+
+// add adds a node at the given layer, merging it with the pending nodes of that layer and above.
+func (t *commPTree) add(layer int, node [commPNodeSize]byte) {
+	for t.hasPending&(1<<layer) != 0 {
+		node = commPHash(&t.pending[layer], &node)
+		t.hasPending &^= 1 << layer
+		layer++
+	}
+	t.pending[layer] = node
+	t.hasPending |= 1 << layer
+}
+
+// root returns the root of the tree with the given height, whose leaves past those added so far
+// are zero nodes.
+func (t *commPTree) root(height int) [commPNodeSize]byte {
+	var zero [commPNodeSize]byte
+	for layer := 0; layer < height; layer++ {
+		if t.hasPending&(1<<layer) != 0 {
+			t.add(layer, zero)
+		}
+		zero = commPHash(&zero, &zero)
+	}
+	if t.hasPending&(1<<height) == 0 {
+		return zero
+	}
+	return t.pending[height]
+}
+
+// commPHash hashes the given nodes into their parent, i.e. their SHA2-256 hash truncated to 254
+// bits.
+func commPHash(left, right *[commPNodeSize]byte) [commPNodeSize]byte {
+	var buf [2 * commPNodeSize]byte
+	copy(buf[:commPNodeSize], left[:])
+	copy(buf[commPNodeSize:], right[:])
+	parent := sha256.Sum256(buf[:])
+	parent[commPNodeSize-1] &= 0x3f
+	return parent
+}
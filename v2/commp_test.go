@@ -0,0 +1,113 @@
+package car_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommP(t *testing.T) {
+	patterned := func(n, mul, add, mod int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte((i*mul + add) % mod)
+		}
+		return b
+	}
+	tests := []struct {
+		name           string
+		data           []byte
+		wantDigest     string
+		wantPaddedSize uint64
+	}{
+		// The commitments of zero pieces, as specified by Filecoin.
+		{
+			name:           "Zero127Bytes",
+			data:           make([]byte, 127),
+			wantDigest:     "3731bb99ac689f66eef5973e4a94da188f4ddcae580724fc6f3fd60dfd488333",
+			wantPaddedSize: 128,
+		},
+		{
+			name:           "Zero254Bytes",
+			data:           make([]byte, 254),
+			wantDigest:     "642a607ef886b004bf2c1978463ae1d4693ac0f410eb2d1b7a47fe205e5e750f",
+			wantPaddedSize: 256,
+		},
+		{
+			name:           "Zero508Bytes",
+			data:           make([]byte, 508),
+			wantDigest:     "57a2381a28652bf47f6bef7aca679be4aede5871ab5cf3eb2c08114488cb8526",
+			wantPaddedSize: 512,
+		},
+		{
+			name:           "MinimumPayload",
+			data:           make([]byte, carv2.MinCommPPayloadSize),
+			wantDigest:     "3731bb99ac689f66eef5973e4a94da188f4ddcae580724fc6f3fd60dfd488333",
+			wantPaddedSize: 128,
+		},
+		{
+			name:           "Patterned1000Bytes",
+			data:           patterned(1000, 7, 3, 256),
+			wantDigest:     "eb69d339663240b21384e86ff5b1e302023ae9cbbfe04cfb48be45a9ce7bb819",
+			wantPaddedSize: 1024,
+		},
+		{
+			name:           "Patterned128KiB",
+			data:           patterned(128<<10, 31, 11, 251),
+			wantDigest:     "7fbb734d215e9cc830e1f2f810dc2c66504bff90b72cf6a7a1e62017bf0b5938",
+			wantPaddedSize: 256 << 10,
+		},
+		{
+			name:           "SampleCarV1",
+			data:           readFile(t, "testdata/sample-v1.car"),
+			wantDigest:     "ff9539c8502f8f11649502a2d8c4fd2e3c737eb1c24591f331d8b968c8352421",
+			wantPaddedSize: 512 << 10,
+		},
+		{
+			name:           "SampleCarV2",
+			data:           readFile(t, "testdata/sample-wrapped-v2.car"),
+			wantDigest:     "e4bf0a30d1d6098c3c70d24f5fa0f666f55e7f3d1ae05c152e48645903a79d25",
+			wantPaddedSize: 1 << 20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Read in small chunks to assert the bytes are processed as they are streamed.
+			got, gotPaddedSize, err := carv2.CommP(iotest.HalfReader(bytes.NewReader(tt.data)))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantPaddedSize, gotPaddedSize)
+			require.Equal(t, uint64(cid.FilCommitmentUnsealed), got.Prefix().Codec)
+			dmh, err := multihash.Decode(got.Hash())
+			require.NoError(t, err)
+			require.Equal(t, uint64(multihash.SHA2_256_TRUNC254_PADDED), dmh.Code)
+			require.Equal(t, tt.wantDigest, hex.EncodeToString(dmh.Digest))
+		})
+	}
+
+	got, _, err := carv2.CommP(bytes.NewReader(make([]byte, 127)))
+	require.NoError(t, err)
+	require.Equal(t, "baga6ea4seaqdomn3tgwgrh3g532zopskstnbrd2n3sxfqbze7rxt7vqn7veigmy", got.String())
+}
+
+func TestCommPIsError(t *testing.T) {
+	_, _, err := carv2.CommP(bytes.NewReader(make([]byte, carv2.MinCommPPayloadSize-1)))
+	require.Error(t, err)
+
+	wantErr := io.ErrClosedPipe
+	_, _, err = carv2.CommP(io.MultiReader(bytes.NewReader(make([]byte, 1<<10)), iotest.ErrReader(wantErr)))
+	require.Equal(t, wantErr, err)
+}
+
+func readFile(t *testing.T, path string) []byte {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return b
+}
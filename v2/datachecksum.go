@@ -0,0 +1,155 @@
+package car
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+// CarDataChecksum is the multicodec code that prefixes the checksum of the data payload of a CARv2,
+// as written by WriteDataChecksum. Since the checksum is not yet defined in the CARv2 spec, its code
+// is taken from the private use range of multicodec codes.
+const CarDataChecksum = multicodec.Code(0x300010)
+
+// Crc32c is the multicodec code of the CRC-32C checksum, i.e. CRC-32 using the Castagnoli
+// polynomial, stored as its big-endian bytes. Since the checksum is not defined in the multicodec
+// table, its code is taken from the private use range of multicodec codes.
+const Crc32c = multicodec.Code(0x300011)
+
+// maxDataChecksumSize is the maximum size of a data checksum as written by WriteDataChecksum, i.e.
+// three varints followed by a digest no larger than sha2-256.
+const maxDataChecksumSize = 3*varint.MaxLenUvarint63 + sha256.Size
+
+// ErrNoDataChecksum signals that a CARv2 does not store a checksum of its data payload.
+// See: WithDataChecksum.
+var ErrNoDataChecksum = errors.New("no data checksum")
+
+// newDataChecksumHash returns the hash that computes the checksum of the given algorithm.
+func newDataChecksumHash(algo multicodec.Code) (hash.Hash, error) {
+	switch algo {
+	case multicodec.Sha2_256:
+		return sha256.New(), nil
+	case Crc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	default:
+		return nil, fmt.Errorf("unsupported data checksum algorithm: %s", algo)
+	}
+}
+
+// DataChecksumSize returns the number of bytes written by WriteDataChecksum with the given
+// algorithm, such that writers can reserve space for it ahead of the index.
+func DataChecksumSize(algo multicodec.Code) (uint64, error) {
+	h, err := newDataChecksumHash(algo)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(varint.UvarintSize(uint64(CarDataChecksum)) +
+		varint.UvarintSize(uint64(algo)) +
+		varint.UvarintSize(uint64(h.Size())) +
+		h.Size()), nil
+}
+
+// WriteDataChecksum computes the checksum of data, read until EOF, using the given algorithm, and
+// writes it to w, returning the number of bytes written. The checksum is written as the
+// CarDataChecksum code, followed by the algorithm code and the length of the digest as varints,
+// then the digest itself. Only multicodec.Sha2_256 and Crc32c algorithms are supported.
+//
+// Writers store the checksum right after the data payload, i.e. at the start of the padding
+// between the data payload and the index, which readers otherwise skip. See: WithDataChecksum.
+func WriteDataChecksum(w io.Writer, data io.Reader, algo multicodec.Code) (uint64, error) {
+	h, err := newDataChecksumHash(algo)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(h, data); err != nil {
+		return 0, err
+	}
+	return writeDataChecksum(w, algo, h.Sum(nil))
+}
+
+func writeDataChecksum(w io.Writer, algo multicodec.Code, digest []byte) (uint64, error) {
+	buf := varint.ToUvarint(uint64(CarDataChecksum))
+	buf = append(buf, varint.ToUvarint(uint64(algo))...)
+	buf = append(buf, varint.ToUvarint(uint64(len(digest)))...)
+	buf = append(buf, digest...)
+	n, err := w.Write(buf)
+	return uint64(n), err
+}
+
+// readDataChecksum reads a checksum as written by WriteDataChecksum from r, returning its algorithm
+// and digest. ErrNoDataChecksum is returned if r does not start with the CarDataChecksum code.
+func readDataChecksum(r io.Reader) (multicodec.Code, []byte, error) {
+	br := bufio.NewReaderSize(r, maxDataChecksumSize)
+	code, err := varint.ReadUvarint(br)
+	if err != nil || multicodec.Code(code) != CarDataChecksum {
+		return 0, nil, ErrNoDataChecksum
+	}
+	algo, err := varint.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid data checksum algorithm: %w", err)
+	}
+	h, err := newDataChecksumHash(multicodec.Code(algo))
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := varint.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid data checksum length: %w", err)
+	}
+	if length != uint64(h.Size()) {
+		return 0, nil, fmt.Errorf("invalid data checksum length for %s: %d", multicodec.Code(algo), length)
+	}
+	digest := make([]byte, length)
+	if _, err := io.ReadFull(br, digest); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return multicodec.Code(algo), digest, nil
+}
+
+// VerifyDataChecksum recomputes the checksum of the data payload of this CARv2 and compares it with
+// the checksum stored right after the data payload, as written via WithDataChecksum. This detects
+// corruption of the data payload, e.g. bit rot, by reading it once without decoding its sections or
+// hashing every block.
+//
+// ErrNoDataChecksum is returned if no checksum is stored, and ErrDataChecksumMismatch if the
+// checksum does not match. An error is returned if the payload is a CARv1.
+func (r *Reader) VerifyDataChecksum() error {
+	if r.Version == 1 {
+		return errors.New("cannot verify data checksum of a CARv1")
+	}
+	at := r.Header.DataOffset + r.Header.DataSize
+	size := uint64(maxDataChecksumSize)
+	if r.Header.HasIndex() && r.Header.IndexOffset >= at && r.Header.IndexOffset-at < size {
+		size = r.Header.IndexOffset - at
+	}
+	algo, want, err := readDataChecksum(io.NewSectionReader(r.r, int64(at), int64(size)))
+	if err != nil {
+		return err
+	}
+	h, err := newDataChecksumHash(algo)
+	if err != nil {
+		return err
+	}
+	dr, err := r.DataReader()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, dr); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(want, got) {
+		return &ErrDataChecksumMismatch{Algorithm: algo, Expected: want, Actual: got}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package car_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDataChecksum(t *testing.T) {
+	for _, algo := range []multicodec.Code{multicodec.Sha2_256, carv2.Crc32c} {
+		t.Run(algo.String(), func(t *testing.T) {
+			src, err := os.Open("testdata/sample-v1.car")
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, src.Close()) })
+			var buf bytes.Buffer
+			require.NoError(t, carv2.WrapV1(src, &buf, carv2.WithDataChecksum(algo)))
+			path := filepath.Join(t.TempDir(), "data-checksum.car")
+			require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o666))
+
+			subject, err := carv2.OpenReader(path)
+			require.NoError(t, err)
+			require.NoError(t, subject.VerifyDataChecksum())
+
+			// Assert the checksum is stored as index padding, such that the index is read as usual.
+			checksumSize, err := carv2.DataChecksumSize(algo)
+			require.NoError(t, err)
+			require.Equal(t, subject.Header.DataOffset+subject.Header.DataSize+checksumSize, subject.Header.IndexOffset)
+			ir, err := subject.IndexReader()
+			require.NoError(t, err)
+			_, err = index.ReadFrom(ir)
+			require.NoError(t, err)
+			dataAt := int64(subject.Header.DataOffset + subject.Header.DataSize/2)
+			require.NoError(t, subject.Close())
+
+			// Assert a single flipped byte in the data payload is detected.
+			data := append([]byte(nil), buf.Bytes()...)
+			data[dataAt] ^= 0xff
+			require.NoError(t, os.WriteFile(path, data, 0o666))
+			subject, err = carv2.OpenReader(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			var mismatch *carv2.ErrDataChecksumMismatch
+			err = subject.VerifyDataChecksum()
+			require.True(t, errors.As(err, &mismatch), "expected checksum mismatch, got %v", err)
+			require.Equal(t, algo, mismatch.Algorithm)
+			require.NotEqual(t, mismatch.Expected, mismatch.Actual)
+		})
+	}
+
+	t.Run("WithoutChecksum", func(t *testing.T) {
+		subject, err := carv2.OpenReader("testdata/sample-wrapped-v2.car")
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, subject.Close()) })
+		require.Equal(t, carv2.ErrNoDataChecksum, subject.VerifyDataChecksum())
+	})
+
+	t.Run("CarV1", func(t *testing.T) {
+		subject, err := carv2.OpenReader("testdata/sample-v1.car")
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, subject.Close()) })
+		require.EqualError(t, subject.VerifyDataChecksum(), "cannot verify data checksum of a CARv1")
+	})
+
+	t.Run("UnsupportedAlgorithm", func(t *testing.T) {
+		src, err := os.Open("testdata/sample-v1.car")
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, src.Close()) })
+		err = carv2.WrapV1(src, &bytes.Buffer{}, carv2.WithDataChecksum(multicodec.Md5))
+		require.EqualError(t, err, "unsupported data checksum algorithm: md5")
+	})
+}
@@ -0,0 +1,191 @@
+package car
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+)
+
+// diffEntry is a CID stored in a CAR payload along with the offset of its section.
+type diffEntry struct {
+	cid    cid.Cid
+	offset uint64
+}
+
+// CompareBlockData sets whether Diff compares the data of blocks present in both CAR payloads.
+// Since blocks are content addressed, blocks with the same CID must have the same data; a mismatch
+// indicates corruption and is reported as an ErrBlockDataMismatch error.
+// Disabled by default.
+func CompareBlockData(enable bool) Option {
+	return func(o *Options) {
+		o.CompareBlockData = enable
+	}
+}
+
+// Diff computes the symmetric difference of the block CIDs stored in the CAR payloads a and b,
+// returning the CIDs only present in a and those only present in b. Both CARv1 and CARv2 formats
+// are accepted. Blocks are identified by whole CIDs, i.e. blocks with the same multihash but a
+// different CID are considered different. The returned CIDs are deduplicated, and ordered by the
+// length of their binary representation, then by their bytes.
+//
+// The CIDs of each payload are enumerated in sorted order from its index: the index embedded in a
+// CARv2 payload is used if it is an index.CidIndexSorted; otherwise an index.CidIndexSorted is
+// generated from the data payload. The sorted CIDs of both payloads are then merged, such that only
+// the CIDs of a are held in memory.
+//
+// If CompareBlockData is enabled, the data of blocks present in both payloads is compared, and an
+// ErrBlockDataMismatch error is returned upon the first mismatch. Note that as with index
+// generation, identity CIDs are only considered if StoreIdentityCIDs is enabled.
+func Diff(a, b io.ReaderAt, opts ...Option) (onlyA, onlyB []cid.Cid, err error) {
+	o := ApplyOptions(opts...)
+	aIdx, aData, err := readCidSortedIndex(a, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot index first CAR: %w", err)
+	}
+	bIdx, bData, err := readCidSortedIndex(b, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot index second CAR: %w", err)
+	}
+
+	var aEntries []diffEntry
+	if err := forEachUniqueCid(aIdx, func(e diffEntry) error {
+		aEntries = append(aEntries, e)
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	onlyA = make([]cid.Cid, 0)
+	onlyB = make([]cid.Cid, 0)
+	i := 0
+	err = forEachUniqueCid(bIdx, func(e diffEntry) error {
+		for ; i < len(aEntries) && cidLess(aEntries[i].cid, e.cid); i++ {
+			onlyA = append(onlyA, aEntries[i].cid)
+		}
+		if i == len(aEntries) || !aEntries[i].cid.Equals(e.cid) {
+			onlyB = append(onlyB, e.cid)
+			return nil
+		}
+		if o.CompareBlockData {
+			if err := compareBlockData(aData, aEntries[i].offset, bData, e.offset, e.cid, o); err != nil {
+				return err
+			}
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for ; i < len(aEntries); i++ {
+		onlyA = append(onlyA, aEntries[i].cid)
+	}
+	return onlyA, onlyB, nil
+}
+
+// readCidSortedIndex returns the index of the given CAR payload as an index.CidIndexSorted, along
+// with its data payload to which the index offsets are relative.
+func readCidSortedIndex(r io.ReaderAt, opts []Option) (*index.CidIndexSorted, io.ReaderAt, error) {
+	cr, err := NewReader(r, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	dr, err := cr.DataReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cr.Header.HasIndex() {
+		ir, err := cr.IndexReader()
+		if err != nil {
+			return nil, nil, err
+		}
+		codec, err := index.ReadCodec(ir)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch codec {
+		case index.CarCidIndexSorted:
+			idx := index.NewCidSorted()
+			if err := idx.Unmarshal(ir); err != nil {
+				return nil, nil, err
+			}
+			return idx, dr, nil
+		case index.CarIndexChecksummed:
+			// The wrapped index may or may not be CidIndexSorted; read it to find out.
+			if ir, err = cr.IndexReader(); err != nil {
+				return nil, nil, err
+			}
+			idx, err := index.ReadFrom(ir)
+			if err != nil {
+				return nil, nil, err
+			}
+			if idx, ok := idx.(*index.CidIndexSorted); ok {
+				return idx, dr, nil
+			}
+		}
+	}
+	idx, err := GenerateIndex(dr, append(append([]Option{}, opts...), UseIndexCodec(index.CarCidIndexSorted))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return idx.(*index.CidIndexSorted), dr, nil
+}
+
+// forEachUniqueCid calls f for every distinct CID in the given index, in the order of
+// index.CidIndexSorted.ForEachCid. For CIDs indexed more than once, the first entry is used.
+func forEachUniqueCid(idx *index.CidIndexSorted, f func(diffEntry) error) error {
+	var prev cid.Cid
+	return idx.ForEachCid(func(key cid.Cid, offset uint64) error {
+		if prev.Defined() && prev.Equals(key) {
+			return nil
+		}
+		prev = key
+		return f(diffEntry{cid: key, offset: offset})
+	})
+}
+
+// cidLess reports whether a is ordered before b in the order of index.CidIndexSorted.ForEachCid.
+func cidLess(a, b cid.Cid) bool {
+	ab, bb := a.Bytes(), b.Bytes()
+	if len(ab) != len(bb) {
+		return len(ab) < len(bb)
+	}
+	return bytes.Compare(ab, bb) < 0
+}
+
+// compareBlockData compares the data of the block with the given CID, stored in the sections at the
+// given offsets of the data payloads a and b.
+func compareBlockData(a io.ReaderAt, aOffset uint64, b io.ReaderAt, bOffset uint64, key cid.Cid, o Options) error {
+	aData, err := readDiffSection(a, aOffset, key, o)
+	if err != nil {
+		return err
+	}
+	bData, err := readDiffSection(b, bOffset, key, o)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(aData, bData) {
+		return &ErrBlockDataMismatch{Cid: key}
+	}
+	return nil
+}
+
+func readDiffSection(r io.ReaderAt, offset uint64, key cid.Cid, o Options) ([]byte, error) {
+	rdr, err := internalio.NewOffsetReadSeeker(r, int64(offset))
+	if err != nil {
+		return nil, err
+	}
+	c, data, err := util.ReadNode(rdr, false, false, o.LenientVarints, o.MaxAllowedSectionSize)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Equals(key) {
+		return nil, fmt.Errorf("index does not match data payload; expected %s at offset %d but got %s", key, offset, c)
+	}
+	return data, nil
+}
@@ -0,0 +1,80 @@
+package car_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	shared := []blocks.Block{
+		merkledag.NewRawNode([]byte("fish")),
+		merkledag.NodeWithData([]byte("lobster")),
+	}
+	onlyInA := merkledag.NewRawNode([]byte("barreleye"))
+	onlyInB := merkledag.NodeWithData([]byte("octopus"))
+	// A block with the same multihash as a shared one but a different CID.
+	sameHash, err := blocks.NewBlockWithCid(shared[0].RawData(), cid.NewCidV1(cid.DagCBOR, shared[0].Cid().Hash()))
+	require.NoError(t, err)
+
+	a := writeDiffTestCar(t, "a.car", append([]blocks.Block{onlyInA}, shared...))
+	b := writeDiffTestCar(t, "b.car", append(shared, onlyInB, sameHash, shared[0]), blockstore.AllowDuplicatePuts(true), carv2.UseIndexCodec(index.CarCidIndexSorted))
+
+	gotOnlyA, gotOnlyB, err := carv2.Diff(a, b, carv2.CompareBlockData(true))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{onlyInA.Cid()}, gotOnlyA)
+	require.ElementsMatch(t, []cid.Cid{onlyInB.Cid(), sameHash.Cid()}, gotOnlyB)
+
+	// Assert the difference is symmetric.
+	gotOnlyB, gotOnlyA, err = carv2.Diff(b, a)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{onlyInA.Cid()}, gotOnlyA)
+	require.ElementsMatch(t, []cid.Cid{onlyInB.Cid(), sameHash.Cid()}, gotOnlyB)
+
+	// Assert a CAR has no difference with itself.
+	gotOnlyA, gotOnlyB, err = carv2.Diff(a, a, carv2.CompareBlockData(true))
+	require.NoError(t, err)
+	require.Empty(t, gotOnlyA)
+	require.Empty(t, gotOnlyB)
+}
+
+func TestDiffWithMismatchingBlockData(t *testing.T) {
+	blk := merkledag.NewRawNode([]byte("fish"))
+	corrupt, err := blocks.NewBlockWithCid([]byte("lobster"), blk.Cid())
+	require.NoError(t, err)
+
+	a := writeDiffTestCar(t, "a.car", []blocks.Block{blk})
+	b := writeDiffTestCar(t, "b.car", []blocks.Block{corrupt}, blockstore.WriteAsCarV1(true))
+
+	// Assert block data is only compared when enabled.
+	gotOnlyA, gotOnlyB, err := carv2.Diff(a, b)
+	require.NoError(t, err)
+	require.Empty(t, gotOnlyA)
+	require.Empty(t, gotOnlyB)
+
+	_, _, err = carv2.Diff(a, b, carv2.CompareBlockData(true))
+	require.Equal(t, &carv2.ErrBlockDataMismatch{Cid: blk.Cid()}, err)
+}
+
+func writeDiffTestCar(t *testing.T, name string, blks []blocks.Block, opts ...carv2.Option) *os.File {
+	path := filepath.Join(t.TempDir(), name)
+	bs, err := blockstore.OpenReadWrite(path, []cid.Cid{}, opts...)
+	require.NoError(t, err)
+	for _, blk := range blks {
+		require.NoError(t, bs.Put(context.TODO(), blk))
+	}
+	require.NoError(t, bs.Finalize())
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	return f
+}
@@ -2,9 +2,26 @@ package car
 
 import (
 	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 )
 
-var _ (error) = (*ErrCidTooLarge)(nil)
+var (
+	_ (error) = (*ErrCidTooLarge)(nil)
+	_ (error) = (*ErrPaddingTooLarge)(nil)
+	_ (error) = (*ErrCorruptSection)(nil)
+	_ (error) = (*ErrTooManyDuplicateLookups)(nil)
+	_ (error) = (*ErrUnsupportedVersion)(nil)
+	_ (error) = (*ErrBlockDataMismatch)(nil)
+	_ (error) = (*ErrTooManyRoots)(nil)
+	_ (error) = (*ErrHashMismatch)(nil)
+	_ (error) = (*ErrDataChecksumMismatch)(nil)
+	_ (error) = (*ErrMalformedDataHeader)(nil)
+	_ (error) = (*ErrTruncatedStream)(nil)
+)
 
 // ErrCidTooLarge signals that a CID is too large to include in CARv2 index.
 // See: MaxIndexCidSize.
@@ -16,3 +33,152 @@ type ErrCidTooLarge struct {
 func (e *ErrCidTooLarge) Error() string {
 	return fmt.Sprintf("cid size is larger than max allowed (%d > %d)", e.CurrentSize, e.MaxSize)
 }
+
+// ErrPaddingTooLarge signals that a data or index padding is too large to include in CARv2.
+// See: MaxAllowedPadding.
+type ErrPaddingTooLarge struct {
+	MaxSize     uint64
+	CurrentSize uint64
+}
+
+func (e *ErrPaddingTooLarge) Error() string {
+	return fmt.Sprintf("padding size is larger than max allowed (%d > %d)", e.CurrentSize, e.MaxSize)
+}
+
+// ErrCorruptSection signals that a section in the CARv1 data payload is malformed, e.g. because its
+// length is inconsistent with the rest of the payload or its CID cannot be decoded.
+type ErrCorruptSection struct {
+	// Offset is the offset of the section relative to the beginning of the CARv1 data payload.
+	Offset uint64
+	// Reason describes why the section is considered corrupt.
+	Reason string
+}
+
+func (e *ErrCorruptSection) Error() string {
+	return fmt.Sprintf("corrupt section at offset %d: %s", e.Offset, e.Reason)
+}
+
+// ErrTooManyDuplicateLookups signals that looking up a block required looking at more index records
+// with the same multihash than allowed.
+// See: DefaultMaxDuplicateLookups.
+type ErrTooManyDuplicateLookups struct {
+	Cid        cid.Cid
+	MaxLookups uint64
+}
+
+func (e *ErrTooManyDuplicateLookups) Error() string {
+	return fmt.Sprintf("looking up %s requires looking at more than the max allowed %d index records with the same multihash", e.Cid, e.MaxLookups)
+}
+
+// ErrUnsupportedVersion signals that the version of a CAR payload is not one of the accepted ones.
+// See: WithAcceptedVersions.
+type ErrUnsupportedVersion struct {
+	Version  uint64
+	Accepted []uint64
+}
+
+func (e *ErrUnsupportedVersion) Error() string {
+	return fmt.Sprintf("unsupported car version: %d; accepted versions are %v", e.Version, e.Accepted)
+}
+
+// ErrBlockDataMismatch signals that two CAR payloads contain different data for the block with the
+// same CID, which indicates that at least one of them is corrupt.
+// See: Diff, CompareBlockData.
+type ErrBlockDataMismatch struct {
+	Cid cid.Cid
+}
+
+func (e *ErrBlockDataMismatch) Error() string {
+	return fmt.Sprintf("block data mismatch for %s", e.Cid)
+}
+
+// ErrTooManyRoots signals that the header of a CAR payload declares more roots than allowed.
+// See: WithMaxRoots.
+type ErrTooManyRoots struct {
+	MaxRoots uint64
+	Count    uint64
+}
+
+func (e *ErrTooManyRoots) Error() string {
+	return fmt.Sprintf("number of roots is larger than max allowed (%d > %d)", e.Count, e.MaxRoots)
+}
+
+// ErrMissingBlock signals that a block of the DAG a CAR payload is expected to contain is absent.
+// See: VerifyStream.
+type ErrMissingBlock struct {
+	Cid cid.Cid
+}
+
+func (e *ErrMissingBlock) Error() string {
+	return fmt.Sprintf("block %s is missing", e.Cid)
+}
+
+// ErrUnreachableBlock signals that a CAR payload contains a block that is not part of the DAG it
+// is expected to contain.
+// See: VerifyStream.
+type ErrUnreachableBlock struct {
+	Cid cid.Cid
+}
+
+func (e *ErrUnreachableBlock) Error() string {
+	return fmt.Sprintf("block %s is not reachable from the root", e.Cid)
+}
+
+// ErrHashMismatch signals that the data of a block does not hash to the multihash of its CID, when
+// hashed with the same multihash code and length.
+// See: blockstore.WithVerifyPutHashes.
+type ErrHashMismatch struct {
+	Cid cid.Cid
+	// Actual is the multihash of the block data.
+	Actual multihash.Multihash
+}
+
+func (e *ErrHashMismatch) Error() string {
+	return fmt.Sprintf("hash mismatch for %s: data hashes to %s", e.Cid, e.Actual.B58String())
+}
+
+// ErrDataChecksumMismatch signals that the checksum of the data payload of a CARv2 does not match
+// the checksum stored alongside it, i.e. the data payload is corrupt.
+// See: Reader.VerifyDataChecksum.
+type ErrDataChecksumMismatch struct {
+	Algorithm multicodec.Code
+	Expected  []byte
+	Actual    []byte
+}
+
+func (e *ErrDataChecksumMismatch) Error() string {
+	return fmt.Sprintf("data checksum mismatch: expected %s %x, got %x", e.Algorithm, e.Expected, e.Actual)
+}
+
+// ErrMalformedDataHeader signals that the CARv1 header of a data payload is truncated or cannot be
+// decoded. Err is the cause, e.g. io.ErrUnexpectedEOF if the header is truncated.
+// See: Reader.Roots.
+type ErrMalformedDataHeader struct {
+	Err error
+}
+
+func (e *ErrMalformedDataHeader) Error() string {
+	return fmt.Sprintf("malformed data payload header: %v", e.Err)
+}
+
+func (e *ErrMalformedDataHeader) Unwrap() error {
+	return e.Err
+}
+
+// ErrTruncatedStream signals that a CAR stream ended before a part of it was read in full. Part
+// names the part, e.g. "header" or "data payload", of which Expected bytes were expected and only
+// Actual bytes read. It unwraps to io.ErrUnexpectedEOF.
+// See: ExtractV1Stream.
+type ErrTruncatedStream struct {
+	Part     string
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *ErrTruncatedStream) Error() string {
+	return fmt.Sprintf("truncated %s: expected %d bytes, got %d", e.Part, e.Expected, e.Actual)
+}
+
+func (e *ErrTruncatedStream) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
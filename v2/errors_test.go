@@ -1,6 +1,8 @@
 package car
 
 import (
+	"errors"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -10,3 +12,19 @@ func TestNewErrCidTooLarge_ErrorContainsSizes(t *testing.T) {
 	subject := &ErrCidTooLarge{MaxSize: 1413, CurrentSize: 1414}
 	require.EqualError(t, subject, "cid size is larger than max allowed (1414 > 1413)")
 }
+
+func TestNewErrPaddingTooLarge_ErrorContainsSizes(t *testing.T) {
+	subject := &ErrPaddingTooLarge{MaxSize: 1413, CurrentSize: 1414}
+	require.EqualError(t, subject, "padding size is larger than max allowed (1414 > 1413)")
+}
+
+func TestNewErrCorruptSection_ErrorContainsOffsetAndReason(t *testing.T) {
+	subject := &ErrCorruptSection{Offset: 1413, Reason: "cannot decode CID"}
+	require.EqualError(t, subject, "corrupt section at offset 1413: cannot decode CID")
+}
+
+func TestNewErrTruncatedStream_ErrorContainsPartAndSizes(t *testing.T) {
+	subject := &ErrTruncatedStream{Part: "data payload", Expected: 1413, Actual: 14}
+	require.EqualError(t, subject, "truncated data payload: expected 1413 bytes, got 14")
+	require.True(t, errors.Is(subject, io.ErrUnexpectedEOF))
+}
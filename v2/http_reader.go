@@ -0,0 +1,129 @@
+package car
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var _ io.ReaderAt = (*httpReaderAt)(nil)
+
+// httpReaderAt implements io.ReaderAt over a remote resource using HTTP range requests.
+type httpReaderAt struct {
+	url    string
+	client *http.Client
+
+	sizeOnce sync.Once
+	size     int64
+	sizeErr  error
+}
+
+// NewHTTPReaderAt returns an io.ReaderAt that reads the resource at the given url, typically a
+// CAR file, by issuing HTTP range requests for the bytes requested on each ReadAt call.
+// If client is nil, http.DefaultClient is used.
+//
+// The total length of the resource is requested once via a HEAD request and cached for the
+// lifetime of the returned reader; reads past the end return io.EOF. Any failed request or
+// unexpected HTTP response status is returned as a read error.
+//
+// The returned reader can be passed to NewReader or blockstore.NewReadOnly in order to access a
+// remote CAR without downloading it entirely. Note that without an index, one is generated by
+// reading the entire data payload. For random access that only fetches the blocks needed, pass an
+// externally provided index to blockstore.NewReadOnly.
+func NewHTTPReaderAt(url string, client *http.Client) io.ReaderAt {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpReaderAt{
+		url:    url,
+		client: client,
+	}
+}
+
+func (h *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset: %d", off)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	size, err := h.getSize()
+	if err != nil {
+		return 0, err
+	}
+
+	// Trim the read to the end of resource when its size is known.
+	var atEOF bool
+	if size >= 0 {
+		if off >= size {
+			return 0, io.EOF
+		}
+		if remaining := size - off; int64(len(p)) > remaining {
+			p = p[:remaining]
+			atEOF = true
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body := resp.Body
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range request and is sending the entire resource; skip to offset.
+		if _, err := io.CopyN(io.Discard, body, off); err != nil {
+			if err == io.EOF {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		return 0, fmt.Errorf("unexpected HTTP response status reading range from %s: %s", h.url, resp.Status)
+	}
+
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err == nil && atEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// getSize returns the size of the remote resource, or -1 if unknown.
+// The size is requested only once, and cached for subsequent calls.
+func (h *httpReaderAt) getSize() (int64, error) {
+	h.sizeOnce.Do(func() {
+		h.size = -1
+		req, err := http.NewRequest(http.MethodHead, h.url, nil)
+		if err != nil {
+			h.sizeErr = err
+			return
+		}
+		resp, err := h.client.Do(req)
+		if err != nil {
+			h.sizeErr = err
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			h.sizeErr = fmt.Errorf("unexpected HTTP response status getting size of %s: %s", h.url, resp.Status)
+			return
+		}
+		h.size = resp.ContentLength
+	})
+	return h.size, h.sizeErr
+}
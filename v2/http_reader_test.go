@@ -0,0 +1,108 @@
+package car_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPReaderAt(t *testing.T) {
+	path := "testdata/sample-wrapped-v2.car"
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var servedBytes int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingResponseWriter{ResponseWriter: w, count: &servedBytes}
+		http.ServeContent(cw, r, "sample.car", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+
+	subject := carv2.NewHTTPReaderAt(srv.URL, srv.Client())
+
+	// Assert arbitrary ranges are read as expected.
+	got := make([]byte, 100)
+	n, err := subject.ReadAt(got, 13)
+	require.NoError(t, err)
+	require.Equal(t, 100, n)
+	require.Equal(t, content[13:113], got)
+
+	// Assert reads that go past the end are trimmed and signal io.EOF.
+	n, err = subject.ReadAt(got, int64(len(content)-10))
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 10, n)
+	require.Equal(t, content[len(content)-10:], got[:n])
+	n, err = subject.ReadAt(got, int64(len(content)))
+	require.Equal(t, io.EOF, err)
+	require.Zero(t, n)
+
+	// Assert specific blocks can be read using an externally provided index, fetching only the
+	// sections needed.
+	idx, err := carv2.GenerateIndexFromFile(path)
+	require.NoError(t, err)
+	local, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, local.Close()) })
+
+	atomic.StoreInt64(&servedBytes, 0)
+	remote, err := blockstore.NewReadOnly(subject, idx)
+	require.NoError(t, err)
+	roots, err := remote.Roots()
+	require.NoError(t, err)
+	wantRoots, err := local.Roots()
+	require.NoError(t, err)
+	require.Equal(t, wantRoots, roots)
+
+	for _, root := range roots {
+		gotBlock, err := remote.Get(context.TODO(), root)
+		require.NoError(t, err)
+		wantBlock, err := local.Get(context.TODO(), root)
+		require.NoError(t, err)
+		require.Equal(t, wantBlock, gotBlock)
+	}
+	require.Less(t, atomic.LoadInt64(&servedBytes), int64(len(content)))
+}
+
+func TestHTTPReaderAtSurfacesHTTPErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "1413")
+			return
+		}
+		http.Error(w, "fish", http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+
+	subject := carv2.NewHTTPReaderAt(srv.URL, srv.Client())
+	_, err := subject.ReadAt(make([]byte, 10), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "500 Internal Server Error")
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notFound.Close)
+	subject = carv2.NewHTTPReaderAt(notFound.URL, notFound.Client())
+	_, err = subject.ReadAt(make([]byte, 10), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "404 Not Found")
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	count *int64
+}
+
+func (c *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
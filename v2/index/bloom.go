@@ -0,0 +1,227 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// bloomFormatVersion is the version of the serialized form of Bloom, written as its first byte.
+	bloomFormatVersion = 1
+	// maxBloomHashes is the maximum number of bit positions set per multihash in a Bloom.
+	maxBloomHashes = 32
+)
+
+var (
+	_ Index         = (*bloomIndex)(nil)
+	_ IterableIndex = (*bloomIterableIndex)(nil)
+)
+
+type (
+	// Bloom is a bloom filter over the multihashes of the records of an index, which tells with
+	// certainty that a multihash is not indexed, allowing negative lookups to skip the index
+	// altogether. See BuildBloom and WithBloom.
+	//
+	// A Bloom is serialized separately from the index it is built from, such that it can be stored
+	// next to a CAR file, e.g. as a sidecar file. The serialized form starts with a version byte,
+	// followed by the number of hashes per multihash as a little-endian uint32, the number of 64-bit
+	// words of the filter as a little-endian uint64, and the words themselves in little-endian order.
+	//
+	// Bloom is not safe for concurrent use if multihashes are added to it, i.e. via Add or via the
+	// Load method of the index returned by WithBloom.
+	Bloom struct {
+		hashes uint32
+		words  []uint64
+	}
+
+	// bloomIndex is an Index that consults a Bloom before looking up the index it wraps.
+	bloomIndex struct {
+		Index
+		bloom *Bloom
+	}
+
+	// bloomIterableIndex is a bloomIndex that wraps an IterableIndex.
+	bloomIterableIndex struct {
+		bloomIndex
+		iterable IterableIndex
+	}
+)
+
+// NewBloom instantiates a new, empty Bloom sized to hold the given number of multihashes with the
+// given false positive rate, which must be strictly between 0 and 1.
+func NewBloom(count uint64, fpRate float64) (*Bloom, error) {
+	if !(fpRate > 0 && fpRate < 1) {
+		return nil, fmt.Errorf("bloom filter false positive rate must be between 0 and 1 exclusive; got %v", fpRate)
+	}
+	// The optimal number of bits is -n*ln(p)/ln(2)^2, and the optimal number of hashes is
+	// bits/n*ln(2).
+	n := math.Max(float64(count), 1)
+	bits := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	words := uint64(math.Ceil(bits / 64))
+	if words == 0 {
+		words = 1
+	}
+	hashes := math.Round(float64(words*64) / n * math.Ln2)
+	hashes = math.Min(math.Max(hashes, 1), maxBloomHashes)
+	return &Bloom{
+		hashes: uint32(hashes),
+		words:  make([]uint64, words),
+	}, nil
+}
+
+// BuildBloom builds a Bloom over the multihashes of the records of the given index, sized to hold
+// every record with the given false positive rate, which must be strictly between 0 and 1.
+func BuildBloom(idx IterableIndex, fpRate float64) (*Bloom, error) {
+	var count uint64
+	if cidx, ok := idx.(CountableIndex); ok {
+		var err error
+		if count, err = cidx.Count(); err != nil {
+			return nil, err
+		}
+	} else if err := idx.ForEach(func(multihash.Multihash, uint64) error {
+		count++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	b, err := NewBloom(count, fpRate)
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		b.Add(mh)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Add adds the given multihash to the filter.
+func (b *Bloom) Add(mh multihash.Multihash) {
+	b.forEachBit(mh, func(word int, mask uint64) bool {
+		b.words[word] |= mask
+		return true
+	})
+}
+
+// Has returns false if the given multihash was certainly not added to the filter, and true if it
+// may have been.
+func (b *Bloom) Has(mh multihash.Multihash) bool {
+	has := true
+	b.forEachBit(mh, func(word int, mask uint64) bool {
+		has = b.words[word]&mask != 0
+		return has
+	})
+	return has
+}
+
+// forEachBit calls f with the word and mask of every bit of the given multihash, until f returns
+// false. Bit positions are derived from two hashes of the multihash via double hashing.
+func (b *Bloom) forEachBit(mh multihash.Multihash, f func(word int, mask uint64) bool) {
+	h1 := hashMultihash(mh)
+	// Derive the second hash from the first via the SplitMix64 finalizer, forcing it to be odd.
+	h2 := h1
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 = (h2 ^ (h2 >> 31)) | 1
+
+	bits := uint64(len(b.words)) * 64
+	if bits == 0 {
+		// The filter is not instantiated, and may therefore hold any multihash.
+		return
+	}
+	for i := uint32(0); i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % bits
+		if !f(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+// Marshal writes the filter to w in its serialized form, and returns the number of bytes written.
+func (b *Bloom) Marshal(w io.Writer) (int64, error) {
+	buf := make([]byte, 1+4+8+8*len(b.words))
+	buf[0] = bloomFormatVersion
+	binary.LittleEndian.PutUint32(buf[1:], b.hashes)
+	binary.LittleEndian.PutUint64(buf[5:], uint64(len(b.words)))
+	for i, word := range b.words {
+		binary.LittleEndian.PutUint64(buf[13+8*i:], word)
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// Unmarshal reads the filter from r in its serialized form, replacing the current content of the
+// filter. ErrMalformedIndex is returned if the filter is of an unknown version or is invalid.
+func (b *Bloom) Unmarshal(r io.Reader) error {
+	var header [13]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if header[0] != bloomFormatVersion {
+		return malformedIndexError("unknown bloom filter version %d", header[0])
+	}
+	hashes := binary.LittleEndian.Uint32(header[1:])
+	if hashes == 0 || hashes > maxBloomHashes {
+		return malformedIndexError("bloom filter hash count %d must be between 1 and %d", hashes, maxBloomHashes)
+	}
+	words := binary.LittleEndian.Uint64(header[5:])
+	if words == 0 || words > math.MaxInt64/8 {
+		return malformedIndexError("bloom filter word count %d is invalid", words)
+	}
+	buf, err := readDeclaredLength(r, words*8)
+	if err != nil {
+		return err
+	}
+	b.hashes = hashes
+	b.words = make([]uint64, words)
+	for i := range b.words {
+		b.words[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+	return nil
+}
+
+// WithBloom wraps the given index such that lookups consult the given Bloom first, returning
+// ErrNotFound without looking up the index if the filter tells that the multihash of the key is
+// not indexed. Lookups of keys that may be indexed are passed on to the index as is.
+//
+// The filter must have been built over the records of the index, e.g. via BuildBloom; otherwise,
+// lookups may wrongly return ErrNotFound. Records loaded via the Load method of the returned index
+// are added to the filter as well. The returned index has the same codec, and is written the same
+// way, as the wrapped index; the filter is not written alongside it, and must be written separately
+// via Bloom.Marshal. The returned index is an IterableIndex if the wrapped index is one.
+func WithBloom(idx Index, bloom *Bloom) Index {
+	bi := bloomIndex{Index: idx, bloom: bloom}
+	if iterable, ok := idx.(IterableIndex); ok {
+		return &bloomIterableIndex{bloomIndex: bi, iterable: iterable}
+	}
+	return &bi
+}
+
+func (bi *bloomIndex) GetAll(key cid.Cid, fn func(uint64) bool) error {
+	if !bi.bloom.Has(key.Hash()) {
+		return ErrNotFound
+	}
+	return bi.Index.GetAll(key, fn)
+}
+
+func (bi *bloomIndex) Load(records []Record) error {
+	for _, r := range records {
+		bi.bloom.Add(r.Cid.Hash())
+	}
+	return bi.Index.Load(records)
+}
+
+func (bi *bloomIterableIndex) ForEach(f func(multihash.Multihash, uint64) error) error {
+	return bi.iterable.ForEach(f)
+}
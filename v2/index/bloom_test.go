@@ -0,0 +1,161 @@
+package index_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildBloom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	var records []index.Record
+	for len(records) < 1000 {
+		records = append(records, generateIndexRecords(t, multihash.SHA2_256, rng)...)
+	}
+	idx, err := index.NewFromRecords(multicodec.CarMultihashIndexSorted, records)
+	require.NoError(t, err)
+
+	subject, err := index.BuildBloom(idx.(index.IterableIndex), 0.01)
+	require.NoError(t, err)
+	for _, r := range records {
+		require.True(t, subject.Has(r.Cid.Hash()))
+	}
+
+	// Assert the false positive rate is roughly as requested.
+	var falsePositives int
+	const probes = 10000
+	for i := 0; i < probes; i++ {
+		if subject.Has(generateCidV1(t, multihash.SHA2_256, rng).Hash()) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, probes*3/100)
+
+	// Assert the filter round-trips.
+	var buf bytes.Buffer
+	n, err := subject.Marshal(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+	want := append([]byte(nil), buf.Bytes()...)
+	var got index.Bloom
+	require.NoError(t, got.Unmarshal(&buf))
+	for _, r := range records {
+		require.True(t, got.Has(r.Cid.Hash()))
+	}
+	buf.Reset()
+	_, err = got.Marshal(&buf)
+	require.NoError(t, err)
+	require.Equal(t, want, buf.Bytes())
+}
+
+func TestBloom_MarshalIsStable(t *testing.T) {
+	subject, err := index.NewBloom(2, 0.01)
+	require.NoError(t, err)
+	for _, data := range []string{"lobster", "fish"} {
+		mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		subject.Add(mh)
+	}
+	var buf bytes.Buffer
+	_, err = subject.Marshal(&buf)
+	require.NoError(t, err)
+	// The version, the number of hashes, the number of words, and the words.
+	require.Equal(t, "01"+"16000000"+"0100000000000000"+"6e9b69a595dc72cb", hex.EncodeToString(buf.Bytes()))
+}
+
+func TestNewBloom_InvalidFalsePositiveRateIsError(t *testing.T) {
+	for _, fpRate := range []float64{0, 1, -0.5, 1.5, math.NaN()} {
+		t.Run(fmt.Sprint(fpRate), func(t *testing.T) {
+			_, err := index.NewBloom(1, fpRate)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestBloom_UnmarshalInvalidIsError(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"Empty", "", io.ErrUnexpectedEOF},
+		{"TruncatedHeader", "01160000000100", io.ErrUnexpectedEOF},
+		{"TruncatedWords", "01160000000100000000000000" + "6e9b69a5", io.ErrUnexpectedEOF},
+		{"UnknownVersion", "02160000000100000000000000" + "6e9b69a595dc72cb", nil},
+		{"NoHashes", "01000000000100000000000000" + "6e9b69a595dc72cb", nil},
+		{"TooManyHashes", "01210000000100000000000000" + "6e9b69a595dc72cb", nil},
+		{"NoWords", "01160000000000000000000000", nil},
+		{"OverflowingWords", "0116000000ffffffffffffffff", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := hex.DecodeString(tt.encoded)
+			require.NoError(t, err)
+			var subject index.Bloom
+			err = subject.Unmarshal(bytes.NewReader(encoded))
+			if tt.wantErr != nil {
+				require.Equal(t, tt.wantErr, err)
+				return
+			}
+			var malformed *index.ErrMalformedIndex
+			require.True(t, errors.As(err, &malformed), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestWithBloom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	absent := generateIndexRecords(t, multihash.SHA2_256, rng)
+
+	idx, err := index.NewFromRecords(multicodec.CarMultihashIndexSorted, records)
+	require.NoError(t, err)
+	bloom, err := index.BuildBloom(idx.(index.IterableIndex), 0.01)
+	require.NoError(t, err)
+	subject := index.WithBloom(idx, bloom)
+
+	require.Equal(t, idx.Codec(), subject.Codec())
+	require.Equal(t, marshalIndex(t, idx), marshalIndex(t, subject))
+	requireContainsAll(t, subject, records)
+	for _, r := range absent {
+		_, err := index.GetFirst(subject, r.Cid)
+		require.Equal(t, index.ErrNotFound, err)
+	}
+
+	// Assert the wrapper is iterable since the wrapped index is.
+	iterable, ok := subject.(index.IterableIndex)
+	require.True(t, ok)
+	var count int
+	require.NoError(t, iterable.ForEach(func(multihash.Multihash, uint64) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, len(records), count)
+
+	// Assert loaded records are added to the filter.
+	require.NoError(t, subject.Load(absent))
+	requireContainsAll(t, subject, absent)
+
+	// Assert the filter is consulted before the index: an empty filter hides every record.
+	empty, err := index.NewBloom(1, 0.01)
+	require.NoError(t, err)
+	subject = index.WithBloom(idx, empty)
+	_, err = index.GetFirst(subject, records[0].Cid)
+	require.Equal(t, index.ErrNotFound, err)
+
+	// Assert the wrapper is not iterable if the wrapped index is not.
+	sortedIdx, err := index.NewFromRecords(multicodec.CarIndexSorted, records)
+	require.NoError(t, err)
+	_, ok = index.WithBloom(sortedIdx, bloom).(index.IterableIndex)
+	require.False(t, ok)
+}
@@ -0,0 +1,124 @@
+package index
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/multiformats/go-varint"
+)
+
+// checksumSize is the size of the checksum of checksummed indices, i.e. the size of a SHA-256
+// digest.
+const checksumSize = sha256.Size
+
+// WriteToWithChecksum writes the given idx into w, in the same format as WriteTo, wrapped with a
+// checksum over the written bytes such that corruption of the index can be detected when it is
+// read back.
+//
+// The written bytes start with the CarIndexChecksummed codec, followed by the little-endian
+// uint64 length of the wrapped index and its SHA-256 digest, followed by the wrapped index itself.
+// The index can be read back using ReadFrom, FromFile or OpenMmap, all of which verify the
+// checksum and return ErrIndexChecksum on mismatch. Note that readers that predate checksummed
+// indices cannot read them.
+func WriteToWithChecksum(idx Index, w io.Writer) (int64, error) {
+	var wrapped bytes.Buffer
+	if _, err := WriteTo(idx, &wrapped); err != nil {
+		return 0, err
+	}
+	checksum := sha256.Sum256(wrapped.Bytes())
+
+	written, err := writeChecksumHeader(w, uint64(wrapped.Len()), checksum[:])
+	if err != nil {
+		return written, err
+	}
+	l, err := wrapped.WriteTo(w)
+	return written + l, err
+}
+
+// writeChecksumHeader writes the CarIndexChecksummed codec, followed by the given length and
+// checksum of the wrapped index.
+func writeChecksumHeader(w io.Writer, length uint64, checksum []byte) (int64, error) {
+	buf := make([]byte, binary.MaxVarintLen64+8+checksumSize)
+	n := varint.PutUvarint(buf, uint64(CarIndexChecksummed))
+	binary.LittleEndian.PutUint64(buf[n:], length)
+	n += 8
+	n += copy(buf[n:], checksum)
+	written, err := w.Write(buf[:n])
+	return int64(written), err
+}
+
+// readChecksumHeader reads the length and checksum of the wrapped index of a checksummed index,
+// i.e. the bytes that follow the CarIndexChecksummed codec.
+func readChecksumHeader(r io.Reader) (length uint64, checksum []byte, err error) {
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if int64(length) < 0 {
+		return 0, nil, malformedIndexError("checksummed index length is overflowing int64")
+	}
+	checksum = make([]byte, checksumSize)
+	if _, err := io.ReadFull(r, checksum); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return length, checksum, nil
+}
+
+// verifyChecksum reads length bytes from r, returning ErrIndexChecksum if their SHA-256 digest
+// does not match the given checksum.
+func verifyChecksum(r io.Reader, length uint64, checksum []byte) error {
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, io.LimitReader(r, int64(length)))
+	if err != nil {
+		return err
+	}
+	if uint64(n) != length {
+		return io.ErrUnexpectedEOF
+	}
+	if actual := hasher.Sum(nil); !bytes.Equal(checksum, actual) {
+		return &ErrIndexChecksum{Expected: checksum, Actual: actual}
+	}
+	return nil
+}
+
+// readChecksummed reads a checksummed index from r, which is positioned right after the
+// CarIndexChecksummed codec. The checksum is verified before the wrapped index is decoded.
+func readChecksummed(r io.Reader) (Index, error) {
+	length, checksum, err := readChecksumHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	// Read the wrapped index into memory, such that it is decoded only once its checksum is
+	// verified. Note that its length is not trusted; the buffer only grows as bytes are read.
+	var wrapped bytes.Buffer
+	if err := verifyChecksum(io.TeeReader(r, &wrapped), length, checksum); err != nil {
+		return nil, err
+	}
+
+	wr := bytes.NewReader(wrapped.Bytes())
+	codec, err := ReadCodec(wr)
+	if err != nil {
+		return nil, err
+	}
+	if codec == CarIndexChecksummed {
+		return nil, malformedIndexError("checksummed index cannot wrap another checksummed index")
+	}
+	idx, err := New(codec)
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.Unmarshal(wr); err != nil {
+		return nil, err
+	}
+	if wr.Len() != 0 {
+		return nil, malformedIndexError("%d unexpected trailing bytes in checksummed index", wr.Len())
+	}
+	return idx, nil
+}
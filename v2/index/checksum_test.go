@@ -0,0 +1,103 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteToWithChecksum_ReadFromRoundTrip(t *testing.T) {
+	codecs := []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashIndexHashed,
+	}
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1413))
+			records := generateIndexRecords(t, multihash.SHA2_256, rng)
+			want, err := index.New(codec)
+			require.NoError(t, err)
+			require.NoError(t, want.Load(records))
+
+			buf := new(bytes.Buffer)
+			n, err := index.WriteToWithChecksum(want, buf)
+			require.NoError(t, err)
+			require.Equal(t, int64(buf.Len()), n)
+
+			gotCodec, err := index.ReadCodec(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			require.Equal(t, index.CarIndexChecksummed, gotCodec)
+
+			// Assert the wrapped index is read back as is, consuming all the written bytes.
+			r := bytes.NewReader(buf.Bytes())
+			got, err := index.ReadFrom(r)
+			require.NoError(t, err)
+			require.Equal(t, codec, got.Codec())
+			require.Equal(t, marshalIndex(t, want), marshalIndex(t, got))
+			require.Zero(t, r.Len())
+
+			// Assert the wrapped index is looked up identically when opened via OpenMmap, whether
+			// or not its codec is supported.
+			mmapped, err := index.OpenMmap(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			require.Equal(t, codec, mmapped.Codec())
+			requireContainsAll(t, mmapped, records)
+		})
+	}
+}
+
+func TestWriteToWithChecksum_CorruptIndexIsError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewMultihashSorted()
+	require.NoError(t, subject.Load(generateIndexRecords(t, multihash.SHA2_256, rng)))
+	buf := new(bytes.Buffer)
+	_, err := index.WriteToWithChecksum(subject, buf)
+	require.NoError(t, err)
+	valid := buf.Bytes()
+
+	// Flip a single bit in the last record of the wrapped index.
+	corrupt := append([]byte(nil), valid...)
+	corrupt[len(corrupt)-1] ^= 0x01
+
+	_, err = index.ReadFrom(bytes.NewReader(corrupt))
+	var checksumErr *index.ErrIndexChecksum
+	require.True(t, errors.As(err, &checksumErr), "expected checksum error, got %v", err)
+	_, err = index.OpenMmap(bytes.NewReader(corrupt), int64(len(corrupt)))
+	require.True(t, errors.As(err, &checksumErr), "expected checksum error, got %v", err)
+
+	path := filepath.Join(t.TempDir(), "corrupt.carindex")
+	require.NoError(t, os.WriteFile(path, corrupt, 0o666))
+	_, err = index.FromFile(path)
+	require.True(t, errors.As(err, &checksumErr), "expected checksum error, got %v", err)
+
+	// Assert a truncated index is an error.
+	truncated := valid[:len(valid)-1]
+	_, err = index.ReadFrom(bytes.NewReader(truncated))
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = index.OpenMmap(bytes.NewReader(truncated), int64(len(truncated)))
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadFrom_LegacyIndexWithoutChecksum(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	want := index.NewMultihashSorted()
+	require.NoError(t, want.Load(generateIndexRecords(t, multihash.SHA2_256, rng)))
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(want, buf)
+	require.NoError(t, err)
+
+	got, err := index.ReadFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, marshalIndex(t, want), marshalIndex(t, got))
+}
@@ -0,0 +1,117 @@
+package index
+
+import (
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Index         = (*CidIndexSorted)(nil)
+	_ IterableIndex = (*CidIndexSorted)(nil)
+)
+
+// CidIndexSorted is an index that stores whole CIDs along with their offsets.
+//
+// Unlike MultihashIndexSorted, the CID codec and version are preserved, meaning that lookups only
+// match blocks with the exact same CID and that the original CIDs can be reconstructed from the
+// index alone; see ForEachCid.
+//
+// Records are grouped by the length of their CID bytes and each group is sorted by CID bytes.
+// The serial form is identical to that of multicodec.CarIndexSorted, except that whole CID bytes
+// are stored in place of multihash digests, and that it is preceded by the total number of records
+// such that truncated indices are detected. Indices written without the number of records are
+// still read.
+type CidIndexSorted struct {
+	widths multiWidthIndex
+}
+
+// NewCidSorted instantiates a new empty CidIndexSorted.
+func NewCidSorted() *CidIndexSorted {
+	return &CidIndexSorted{
+		widths: make(multiWidthIndex),
+	}
+}
+
+func (c *CidIndexSorted) Codec() multicodec.Code {
+	return CarCidIndexSorted
+}
+
+func (c *CidIndexSorted) Marshal(w io.Writer) (int64, error) {
+	count, err := c.Count()
+	if err != nil {
+		return 0, err
+	}
+	l, err := writeCountedHeader(w, count)
+	if err != nil {
+		return l, err
+	}
+	n, err := c.widths.Marshal(w)
+	return l + n, err
+}
+
+func (c *CidIndexSorted) Unmarshal(r io.Reader) error {
+	if c.widths == nil {
+		c.widths = make(multiWidthIndex)
+	}
+	buckets, count, counted, err := readCountedHeader(r)
+	if err != nil {
+		return err
+	}
+	if err := c.widths.unmarshalBuckets(r, buckets); err != nil {
+		return err
+	}
+	if counted {
+		return checkRecordCount(c, count)
+	}
+	return nil
+}
+
+func (c *CidIndexSorted) Load(records []Record) error {
+	// Split cids on the length of their bytes.
+	idxs := make(map[int][]digestRecord)
+	for _, record := range records {
+		key := record.Cid.Bytes()
+		idxs[len(key)] = append(idxs[len(key)], digestRecord{key, record.Offset})
+	}
+	c.widths.loadDigestRecords(idxs)
+	return nil
+}
+
+// GetAll calls fn for the offset of every block with the exact given key.
+// Blocks with the same multihash but a different CID codec or version are not matched.
+func (c *CidIndexSorted) GetAll(key cid.Cid, fn func(uint64) bool) error {
+	k := key.Bytes()
+	if s, ok := c.widths[uint32(len(k)+8)]; ok {
+		return s.getAll(k, fn)
+	}
+	return ErrNotFound
+}
+
+// ForEach calls f for the multihash of every CID and its associated offset stored by this index.
+func (c *CidIndexSorted) ForEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	return c.ForEachCid(func(key cid.Cid, offset uint64) error {
+		return f(key.Hash(), offset)
+	})
+}
+
+// Count returns the total number of records stored by this index.
+func (c *CidIndexSorted) Count() (uint64, error) {
+	return c.widths.Count()
+}
+
+// ForEachCid calls f for every CID and its associated offset stored by this index.
+// The CIDs are visited in order of their byte length, then in order of their bytes.
+//
+// If f returns a non-nil error, the iteration is aborted and the error is returned.
+func (c *CidIndexSorted) ForEachCid(f func(key cid.Cid, offset uint64) error) error {
+	return c.widths.forEachDigest(func(digest []byte, offset uint64) error {
+		key, err := cid.Cast(digest)
+		if err != nil {
+			return err
+		}
+		return f(key, offset)
+	})
+}
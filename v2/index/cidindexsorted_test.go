@@ -0,0 +1,142 @@
+package index_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCidSortedIndex_Codec(t *testing.T) {
+	subject, err := index.New(index.CarCidIndexSorted)
+	require.NoError(t, err)
+	require.Equal(t, index.CarCidIndexSorted, subject.Codec())
+}
+
+func TestCidSortedIndex_WriteToReadFrom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	subject := index.NewCidSorted()
+	require.NoError(t, subject.Load(records))
+
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(subject, buf)
+	require.NoError(t, err)
+
+	got, err := index.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, index.CarCidIndexSorted, got.Codec())
+
+	requireContainsAll(t, subject, records)
+	requireContainsAll(t, got, records)
+}
+
+func TestCidSortedIndex_RecordCount(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	subject := index.NewCidSorted()
+	require.NoError(t, subject.Load(records))
+	testRecordCount(t, subject, records)
+}
+
+// testRecordCount checks that subject is written with its record count, that the count is checked
+// when it is read back, and that subject is still read when written without the count.
+func testRecordCount(t *testing.T, subject index.Index, records []index.Record) {
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(subject, buf)
+	require.NoError(t, err)
+	codecLen := varint.UvarintSize(uint64(subject.Codec()))
+	written := buf.Bytes()
+	header := written[codecLen : codecLen+12]
+	require.Equal(t, int32(-1), int32(binary.LittleEndian.Uint32(header)))
+	require.Equal(t, uint64(len(records)), binary.LittleEndian.Uint64(header[4:]))
+
+	t.Run("WithoutRecordCount", func(t *testing.T) {
+		old := append(append([]byte{}, written[:codecLen]...), written[codecLen+12:]...)
+		got, err := index.ReadFrom(bytes.NewReader(old))
+		require.NoError(t, err)
+		require.Equal(t, subject, got)
+		requireContainsAll(t, got, records)
+	})
+
+	t.Run("MismatchedRecordCount", func(t *testing.T) {
+		for _, count := range []uint64{uint64(len(records)) - 1, uint64(len(records)) + 1} {
+			mismatched := append([]byte{}, written...)
+			binary.LittleEndian.PutUint64(mismatched[codecLen+4:], count)
+			_, err := index.ReadFrom(bytes.NewReader(mismatched))
+			var malformed *index.ErrMalformedIndex
+			require.True(t, errors.As(err, &malformed), "unexpected error: %v", err)
+		}
+	})
+
+	t.Run("MissingBucket", func(t *testing.T) {
+		// Declare one bucket fewer, such that the records of the last bucket are not read.
+		truncated := append([]byte{}, written...)
+		buckets := binary.LittleEndian.Uint32(truncated[codecLen+12:])
+		binary.LittleEndian.PutUint32(truncated[codecLen+12:], buckets-1)
+		_, err := index.ReadFrom(bytes.NewReader(truncated))
+		var malformed *index.ErrMalformedIndex
+		require.True(t, errors.As(err, &malformed), "unexpected error: %v", err)
+	})
+}
+
+func TestCidSortedIndex_GetAllMatchesWholeCid(t *testing.T) {
+	rng := rand.New(rand.NewSource(1414))
+	rawCid := generateCidV1(t, multihash.SHA2_256, rng)
+	cborCid := cid.NewCidV1(cid.DagCBOR, rawCid.Hash())
+
+	subject := index.NewCidSorted()
+	require.NoError(t, subject.Load([]index.Record{{Cid: rawCid, Offset: 1413}}))
+
+	got, err := index.GetFirst(subject, rawCid)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1413), got)
+
+	// A CID with the same multihash but a different codec must not match.
+	_, err = index.GetFirst(subject, cborCid)
+	require.Equal(t, index.ErrNotFound, err)
+}
+
+func TestCidSortedIndex_ForEachCidReturnsOriginalCids(t *testing.T) {
+	rng := rand.New(rand.NewSource(1415))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	// Mix in CIDs of other codecs and versions that share multihashes with existing records.
+	for _, r := range records[:len(records)/2] {
+		records = append(records,
+			index.Record{Cid: cid.NewCidV1(cid.DagCBOR, r.Cid.Hash()), Offset: rng.Uint64()},
+			index.Record{Cid: cid.NewCidV0(r.Cid.Hash()), Offset: rng.Uint64()},
+		)
+	}
+
+	subject := index.NewCidSorted()
+	require.NoError(t, subject.Load(records))
+
+	want := make(map[cid.Cid]uint64, len(records))
+	for _, r := range records {
+		want[r.Cid] = r.Offset
+	}
+	got := make(map[cid.Cid]uint64, len(records))
+	require.NoError(t, subject.ForEachCid(func(c cid.Cid, offset uint64) error {
+		got[c] = offset
+		return nil
+	}))
+	require.Equal(t, want, got)
+
+	var gotMhs int
+	require.NoError(t, subject.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		gotMhs++
+		return nil
+	}))
+	require.Equal(t, len(records), gotMhs)
+}
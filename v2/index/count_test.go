@@ -0,0 +1,81 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountableIndex_CountIncludesDuplicates(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateSizedIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateSizedIndexRecords(t, multihash.SHA2_512, rng)...)
+	// Add records with duplicate multihashes but different offsets.
+	for _, r := range records[:10] {
+		records = append(records, index.Record{Cid: r.Cid, Offset: rng.Uint64(), Size: r.Size})
+	}
+	wantCount := uint64(len(records))
+
+	codecs := []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	}
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			subject, err := index.New(codec)
+			require.NoError(t, err)
+			require.NoError(t, subject.Load(records))
+			requireCount(t, subject, wantCount)
+
+			// Assert the count survives a round-trip via ReadFrom and OpenMmap, where supported.
+			buf := new(bytes.Buffer)
+			_, err = index.WriteTo(subject, buf)
+			require.NoError(t, err)
+			serialized := buf.Bytes()
+
+			read, err := index.ReadFrom(bytes.NewReader(serialized))
+			require.NoError(t, err)
+			requireCount(t, read, wantCount)
+
+			if codec != index.CarCidIndexSorted && codec != index.CarMultihashSizedIndexSorted {
+				mmapped, err := index.OpenMmap(bytes.NewReader(serialized), int64(len(serialized)))
+				require.NoError(t, err)
+				requireCount(t, mmapped, wantCount)
+			}
+		})
+	}
+
+	t.Run("InsertionIndex", func(t *testing.T) {
+		subject := index.NewInsertionIndex()
+		requireCount(t, subject, 0)
+		require.NoError(t, subject.Load(records))
+		requireCount(t, subject, wantCount)
+	})
+}
+
+func TestCountableIndex_EmptyIndexCountIsZero(t *testing.T) {
+	for _, codec := range []multicodec.Code{multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted} {
+		t.Run(codec.String(), func(t *testing.T) {
+			subject, err := index.New(codec)
+			require.NoError(t, err)
+			require.NoError(t, subject.Load(nil))
+			requireCount(t, subject, 0)
+		})
+	}
+}
+
+func requireCount(t *testing.T, idx index.Index, want uint64) {
+	countable, ok := idx.(index.CountableIndex)
+	require.True(t, ok, "expected %T to be countable", idx)
+	got, err := countable.Count()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
@@ -2,5 +2,17 @@
 // CID to offset. This can then be used to implement random access over a CARv1.
 //
 // Index can be written or read using the following static functions: index.WriteTo and
-// index.ReadFrom.
+// index.ReadFrom. Index files stored alongside a CAR file can be written atomically and read back
+// with validation using index.SaveToFile and index.FromFile. To detect corruption of an index when
+// it is read back, index.WriteToWithChecksum writes it along with a checksum that index.ReadFrom
+// verifies.
+//
+// The records of an iterable index can be checked against the CARv1 data payload they refer to
+// using index.Validate, and dumped in a human-readable form for debugging using index.DumpJSON.
+// The multihashes of two iterable indices can be compared using index.Difference and
+// index.Intersect, e.g. to find the blocks that are present in one CAR but not in another.
+//
+// Loaded indices are safe for concurrent lookups. An index that is looked up while records are still
+// loaded into it, e.g. an index.InsertionIndex, can be shared between goroutines via
+// index.Synchronized.
 package index
@@ -0,0 +1,111 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+type (
+	// dumpHeader is the first line written by DumpJSON.
+	dumpHeader struct {
+		Codec   string `json:"codec"`
+		Code    uint64 `json:"code"`
+		Records uint64 `json:"records"`
+	}
+
+	// dumpRecord is a line written by DumpJSON for each record of an index.
+	dumpRecord struct {
+		Multihash string  `json:"multihash"`
+		Cid       string  `json:"cid,omitempty"`
+		Codec     string  `json:"codec,omitempty"`
+		Offset    uint64  `json:"offset"`
+		Size      *uint64 `json:"size,omitempty"`
+	}
+)
+
+// DumpJSON writes a human-readable representation of the given index to w, for debugging purposes.
+// The output consists of one JSON object per line: first a header object describing the index
+// codec and the number of records, followed by one object per record in the order of ForEach.
+//
+// Each record object contains the base58btc-encoded multihash and the offset of the record. If the
+// index stores whole CIDs, e.g. CidIndexSorted, the CID and its codec are included. If the index
+// stores the size of block data, e.g. MultihashSizedIndexSorted, the size is included when known.
+// For example:
+//
+//	{"codec":"car-multihash-index-sorted","code":1025,"records":1}
+//	{"multihash":"QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n","offset":59}
+//
+// The records are streamed to w as they are iterated over, without accumulating them in memory.
+// The index must be an IterableIndex; an error is returned otherwise. The output format is
+// intended for human consumption and may change.
+func DumpJSON(idx Index, w io.Writer) error {
+	iterable, ok := idx.(IterableIndex)
+	if !ok {
+		return fmt.Errorf("index of type %T does not support iteration", idx)
+	}
+
+	var count uint64
+	if err := iterable.ForEach(func(multihash.Multihash, uint64) error {
+		count++
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(dumpHeader{
+		Codec:   idx.Codec().String(),
+		Code:    uint64(idx.Codec()),
+		Records: count,
+	}); err != nil {
+		return err
+	}
+	return forEachDumpRecord(iterable, enc.Encode)
+}
+
+// forEachDumpRecord calls f for every record of the given index, populated with as much
+// information as the index stores.
+func forEachDumpRecord(idx IterableIndex, f func(interface{}) error) error {
+	switch idx := idx.(type) {
+	case *CidIndexSorted:
+		return idx.ForEachCid(func(key cid.Cid, offset uint64) error {
+			return f(newCidDumpRecord(key, offset, nil))
+		})
+	case *InsertionIndex:
+		return idx.forEachRecord(func(r Record) error {
+			var size *uint64
+			if cidLen := uint64(r.Cid.ByteLen()); r.Size >= cidLen && r.Size != 0 {
+				dataSize := r.Size - cidLen
+				size = &dataSize
+			}
+			return f(newCidDumpRecord(r.Cid, r.Offset, size))
+		})
+	case *MultihashSizedIndexSorted:
+		return idx.forEachSized(func(mh multihash.Multihash, offset uint64, size uint64, known bool) error {
+			r := dumpRecord{Multihash: mh.B58String(), Offset: offset}
+			if known {
+				r.Size = &size
+			}
+			return f(r)
+		})
+	default:
+		return idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+			return f(dumpRecord{Multihash: mh.B58String(), Offset: offset})
+		})
+	}
+}
+
+func newCidDumpRecord(key cid.Cid, offset uint64, size *uint64) dumpRecord {
+	return dumpRecord{
+		Multihash: key.Hash().B58String(),
+		Cid:       key.String(),
+		Codec:     multicodec.Code(key.Prefix().Codec).String(),
+		Offset:    offset,
+		Size:      size,
+	}
+}
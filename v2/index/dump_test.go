@@ -0,0 +1,104 @@
+package index_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type dumpedRecord struct {
+	Multihash string  `json:"multihash"`
+	Cid       string  `json:"cid"`
+	Codec     string  `json:"codec"`
+	Offset    uint64  `json:"offset"`
+	Size      *uint64 `json:"size"`
+}
+
+func TestDumpJSON(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateSizedIndexRecords(t, multihash.SHA2_256, rng)
+	// Leave the size of one record unknown.
+	records[0].Size = 0
+
+	tests := []struct {
+		codec    multicodec.Code
+		wantCids bool
+		wantSize bool
+	}{
+		{codec: multicodec.CarMultihashIndexSorted},
+		{codec: index.CarCidIndexSorted, wantCids: true},
+		{codec: index.CarMultihashSizedIndexSorted, wantSize: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec.String(), func(t *testing.T) {
+			subject, err := index.New(tt.codec)
+			require.NoError(t, err)
+			require.NoError(t, subject.Load(records))
+
+			var buf bytes.Buffer
+			require.NoError(t, index.DumpJSON(subject, &buf))
+
+			scanner := bufio.NewScanner(&buf)
+			require.True(t, scanner.Scan())
+			var header struct {
+				Codec   string `json:"codec"`
+				Code    uint64 `json:"code"`
+				Records uint64 `json:"records"`
+			}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+			require.Equal(t, tt.codec.String(), header.Codec)
+			require.Equal(t, uint64(tt.codec), header.Code)
+			require.Equal(t, uint64(len(records)), header.Records)
+
+			var got []dumpedRecord
+			for scanner.Scan() {
+				var r dumpedRecord
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+				got = append(got, r)
+			}
+			require.NoError(t, scanner.Err())
+
+			var want []dumpedRecord
+			for _, r := range records {
+				w := dumpedRecord{Multihash: r.Cid.Hash().B58String(), Offset: r.Offset}
+				if tt.wantCids {
+					w.Cid = r.Cid.String()
+					w.Codec = multicodec.Code(r.Cid.Prefix().Codec).String()
+				}
+				if tt.wantSize && r.Size != 0 {
+					size := r.Size - uint64(r.Cid.ByteLen())
+					w.Size = &size
+				}
+				want = append(want, w)
+			}
+			require.ElementsMatch(t, want, got)
+		})
+	}
+}
+
+func TestDumpJSON_InsertionIndex(t *testing.T) {
+	c := generateCidV1(t, multihash.SHA2_256, rand.New(rand.NewSource(1413)))
+	subject := index.NewInsertionIndex()
+	subject.InsertSizedNoReplace(c, 42, uint64(c.ByteLen())+3)
+
+	var buf bytes.Buffer
+	require.NoError(t, index.DumpJSON(subject, &buf))
+	require.Equal(t,
+		`{"codec":"Code(3145731)","code":3145731,"records":1}`+"\n"+
+			`{"multihash":"`+c.Hash().B58String()+`","cid":"`+c.String()+`","codec":"raw","offset":42,"size":3}`+"\n",
+		buf.String())
+}
+
+func TestDumpJSON_NonIterableIndexIsError(t *testing.T) {
+	subject, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	require.NoError(t, subject.Load(generateIndexRecords(t, multihash.SHA2_256, rand.New(rand.NewSource(1413)))))
+	require.Error(t, index.DumpJSON(subject, &bytes.Buffer{}))
+}
@@ -1,6 +1,90 @@
 package index
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/multiformats/go-multicodec"
+)
+
+var (
+	_ error = (*ErrIndexChecksum)(nil)
+	_ error = (*ErrMalformedIndex)(nil)
+	_ error = (*ErrRecordOutOfBounds)(nil)
+	_ error = (*ErrTranscodeUnsupported)(nil)
+	_ error = (*ErrUnknownIndexCodec)(nil)
+)
 
 // ErrNotFound signals a record is not found in the index.
 var ErrNotFound = errors.New("not found")
+
+// ErrIndexChecksum signals that the checksum of a checksummed index does not match the checksum
+// computed over its bytes, i.e. the index is corrupt.
+// See: WriteToWithChecksum.
+type ErrIndexChecksum struct {
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *ErrIndexChecksum) Error() string {
+	return fmt.Sprintf("index checksum mismatch: expected %x, got %x", e.Expected, e.Actual)
+}
+
+// ErrMalformedIndex signals that a serialized index cannot be decoded, because it is truncated
+// in a way that breaks its structure, or because the lengths, widths or counts it declares are
+// invalid or exceed the allowed maximums. Detail describes the offending part of the index.
+type ErrMalformedIndex struct {
+	Detail string
+}
+
+func (e *ErrMalformedIndex) Error() string {
+	return "malformed index; " + e.Detail
+}
+
+// malformedIndexError returns an ErrMalformedIndex with the given formatted detail.
+func malformedIndexError(format string, args ...interface{}) error {
+	return &ErrMalformedIndex{Detail: fmt.Sprintf(format, args...)}
+}
+
+// ErrRecordOutOfBounds signals that a record of an index points at a section that lies past the end
+// of the data payload it indexes, i.e. that the index does not match the payload, as opposed to the
+// payload being corrupt. Offset is the offset of the record, relative to the start of the data
+// payload, and Err is the error encountered reading the section at Offset: io.EOF if Offset is at
+// or past the end of the payload, or io.ErrUnexpectedEOF if the section is cut short by it.
+// See: Validate, which counts such records as out of bounds.
+type ErrRecordOutOfBounds struct {
+	Offset uint64
+	Err    error
+}
+
+func (e *ErrRecordOutOfBounds) Error() string {
+	return fmt.Sprintf("index record at offset %d is out of bounds of the data payload: %v", e.Offset, e.Err)
+}
+
+func (e *ErrRecordOutOfBounds) Unwrap() error {
+	return e.Err
+}
+
+// ErrTranscodeUnsupported signals that an index cannot be transcoded to the target codec, because
+// the source index does not carry the information required by the target codec, e.g. whole CIDs.
+// Reason describes the missing information.
+// See: Transcode.
+type ErrTranscodeUnsupported struct {
+	Source multicodec.Code
+	Target multicodec.Code
+	Reason string
+}
+
+func (e *ErrTranscodeUnsupported) Error() string {
+	return fmt.Sprintf("cannot transcode index from %s to %s: %s", e.Source, e.Target, e.Reason)
+}
+
+// ErrUnknownIndexCodec signals that an index codec is neither defined by this package nor
+// registered via RegisterCodec.
+type ErrUnknownIndexCodec struct {
+	Code multicodec.Code
+}
+
+func (e *ErrUnknownIndexCodec) Error() string {
+	return fmt.Sprintf("unknown index codec: %v", e.Code)
+}
@@ -0,0 +1,111 @@
+package index
+
+import "unsafe"
+
+var (
+	_ MemoryFootprintIndex = (*multiWidthIndex)(nil)
+	_ MemoryFootprintIndex = (*MultihashIndexSorted)(nil)
+	_ MemoryFootprintIndex = (*CidIndexSorted)(nil)
+	_ MemoryFootprintIndex = (*MultihashSizedIndexSorted)(nil)
+	_ MemoryFootprintIndex = (*MultihashIndexHashed)(nil)
+	_ MemoryFootprintIndex = (*InsertionIndex)(nil)
+)
+
+// MemoryFootprint returns the estimated heap memory held by this index, i.e. the records of every
+// width along with the map of widths.
+func (m *multiWidthIndex) MemoryFootprint() uint64 {
+	size := allocSize(uint64(unsafe.Sizeof(*m))) +
+		mapFootprint(len(*m), unsafe.Sizeof(uint32(0))+unsafe.Sizeof(singleWidthIndex{}))
+	for _, s := range *m {
+		size += allocSize(uint64(cap(s.index)))
+	}
+	return size
+}
+
+// MemoryFootprint returns the estimated heap memory held by this index, across all multihash codes.
+func (m *MultihashIndexSorted) MemoryFootprint() uint64 {
+	size := allocSize(uint64(unsafe.Sizeof(*m))) +
+		mapFootprint(len(*m), unsafe.Sizeof(uint64(0))+unsafe.Sizeof(&multiWidthCodedIndex{}))
+	for _, mwci := range *m {
+		size += allocSize(uint64(unsafe.Sizeof(*mwci))) + mwci.multiWidthIndex.MemoryFootprint()
+	}
+	return size
+}
+
+// MemoryFootprint returns the estimated heap memory held by this index.
+func (c *CidIndexSorted) MemoryFootprint() uint64 {
+	return allocSize(uint64(unsafe.Sizeof(*c))) + c.widths.MemoryFootprint()
+}
+
+// MemoryFootprint returns the estimated heap memory held by this index.
+func (m *MultihashSizedIndexSorted) MemoryFootprint() uint64 {
+	size := allocSize(uint64(unsafe.Sizeof(*m))) +
+		mapFootprint(len(*m), unsafe.Sizeof(uint32(0))+unsafe.Sizeof(sizedSingleWidthIndex{}))
+	for _, bucket := range *m {
+		size += allocSize(uint64(cap(bucket.index)))
+	}
+	return size
+}
+
+// MemoryFootprint returns the estimated heap memory held by this index, i.e. the slots of its hash
+// tables, including empty ones.
+func (m *MultihashIndexHashed) MemoryFootprint() uint64 {
+	size := allocSize(uint64(unsafe.Sizeof(*m))) +
+		mapFootprint(len(*m), unsafe.Sizeof(uint32(0))+unsafe.Sizeof(hashTable{}))
+	for _, table := range *m {
+		size += allocSize(uint64(cap(table.slots)))
+	}
+	return size
+}
+
+// MemoryFootprint returns the estimated heap memory held by this index, i.e. its runs and buffer of
+// records, along with the bytes of the CID of every record. CIDs shared with other records or with
+// the caller are counted once per record.
+func (ii *InsertionIndex) MemoryFootprint() uint64 {
+	recordSize := uint64(unsafe.Sizeof(insertionRecord{}))
+	size := allocSize(uint64(unsafe.Sizeof(*ii))) +
+		allocSize(uint64(cap(ii.runs))*uint64(unsafe.Sizeof([]insertionRecord{}))) +
+		allocSize(uint64(cap(ii.buffer))*recordSize) +
+		mapFootprint(len(ii.layouts), unsafe.Sizeof(recordLayout{})+unsafe.Sizeof(0))
+	for _, run := range ii.runs {
+		size += allocSize(uint64(cap(run)) * recordSize)
+	}
+	for l, n := range ii.layouts {
+		size += uint64(n) * allocSize(uint64(l.cidLen))
+	}
+	return size
+}
+
+// allocSize returns the number of bytes the Go runtime allocates for an object of n bytes, i.e. n
+// rounded up to the size class of small objects or to the page size of large objects. The size
+// classes between 256 bytes and 32KiB are not modelled, and are rounded to multiples of 16 bytes
+// only; such objects are rare in indices, which mostly consist of CIDs and large bucket slices.
+func allocSize(n uint64) uint64 {
+	const (
+		maxSmallSize = 32 << 10
+		pageSize     = 8 << 10
+	)
+	switch {
+	case n == 0:
+		return 0
+	case n <= 32:
+		return roundUp(n, 8)
+	case n <= maxSmallSize:
+		return roundUp(n, 16)
+	default:
+		return roundUp(n, pageSize)
+	}
+}
+
+func roundUp(n, multiple uint64) uint64 {
+	return (n + multiple - 1) / multiple * multiple
+}
+
+// mapFootprint returns the estimated number of bytes held by a map with the given number of
+// entries of the given size, i.e. the size of a key plus the size of a value. Maps in indices hold a
+// handful of buckets at most, so the estimate need not be precise: it accounts for the map header
+// and twice the entries, to allow for the load factor and per-entry metadata of the map.
+func mapFootprint(entries int, entrySize uintptr) uint64 {
+	const mapHeaderSize = 48
+	return mapHeaderSize + allocSize(2*uint64(entries)*uint64(entrySize+1))
+}
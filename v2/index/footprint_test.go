@@ -0,0 +1,107 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryFootprint(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := make([]index.Record, 0, 100000)
+	for i := 0; i < cap(records); i++ {
+		code := uint64(multihash.SHA2_256)
+		if i%10 == 0 {
+			code = multihash.SHA2_512
+		}
+		records = append(records, index.Record{Cid: generateCidV1(t, code, rng), Offset: rng.Uint64()})
+	}
+
+	// requireFootprintMatchesHeap asserts that the footprint of the index built by build is within
+	// 10% of the heap memory it actually holds once built.
+	requireFootprintMatchesHeap := func(t *testing.T, build func() index.Index) {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		idx := build()
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+
+		subject, ok := idx.(index.MemoryFootprintIndex)
+		require.True(t, ok)
+		actual := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+		require.Positive(t, actual)
+		require.InEpsilon(t, actual, int64(subject.MemoryFootprint()), 0.1)
+		runtime.KeepAlive(idx)
+	}
+
+	for _, codec := range []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	} {
+		t.Run(codec.String(), func(t *testing.T) {
+			loaded, err := index.New(codec)
+			require.NoError(t, err)
+			require.NoError(t, loaded.Load(records))
+			var buf bytes.Buffer
+			_, err = index.WriteTo(loaded, &buf)
+			require.NoError(t, err)
+
+			requireFootprintMatchesHeap(t, func() index.Index {
+				idx, err := index.ReadFrom(bytes.NewReader(buf.Bytes()))
+				require.NoError(t, err)
+				return idx
+			})
+
+			// Assert indices opened via OpenMmap report no footprint, since they hold no records.
+			if mmapped, err := index.OpenMmap(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+				_, ok := mmapped.(index.MemoryFootprintIndex)
+				require.False(t, ok)
+			}
+		})
+	}
+
+	t.Run("InsertionIndex", func(t *testing.T) {
+		empty := index.NewInsertionIndex()
+		require.Less(t, empty.MemoryFootprint(), uint64(1024))
+
+		loaded := index.NewInsertionIndex()
+		require.NoError(t, loaded.Load(records))
+		var buf bytes.Buffer
+		_, err := loaded.Marshal(&buf)
+		require.NoError(t, err)
+
+		// Unmarshal the index, such that its CIDs are allocated by it rather than shared with records.
+		requireFootprintMatchesHeap(t, func() index.Index {
+			ii := index.NewInsertionIndex()
+			require.NoError(t, ii.Unmarshal(bytes.NewReader(buf.Bytes())))
+			return ii
+		})
+		// Keep the loaded and marshaled indices alive until the heap is measured, such that freeing
+		// them does not offset the heap held by the unmarshaled index.
+		runtime.KeepAlive(loaded)
+		runtime.KeepAlive(buf.Bytes())
+
+		// Assert the footprint grows with records inserted one at a time and shrinks once deleted.
+		subject := index.NewInsertionIndex()
+		last := subject.MemoryFootprint()
+		for _, r := range records[:5000] {
+			subject.InsertNoReplace(r.Cid, r.Offset)
+		}
+		require.Greater(t, subject.MemoryFootprint(), last)
+		last = subject.MemoryFootprint()
+		for _, r := range records[:5000] {
+			subject.Delete(r.Cid)
+		}
+		require.Less(t, subject.MemoryFootprint(), last)
+	})
+}
@@ -0,0 +1,218 @@
+//go:build go1.18
+
+package index_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+// fuzzedCodecs lists the codecs of every index that can be read via index.ReadFrom.
+var fuzzedCodecs = []multicodec.Code{
+	multicodec.CarIndexSorted,
+	multicodec.CarMultihashIndexSorted,
+	index.CarCidIndexSorted,
+	index.CarMultihashSizedIndexSorted,
+	index.CarMultihashIndexHashed,
+}
+
+// fuzzRecords returns a handful of records with multihashes of differing codes and lengths, such
+// that the seeded indices contain more than one bucket.
+func fuzzRecords(f *testing.F) []index.Record {
+	var records []index.Record
+	for i, code := range []uint64{multihash.SHA2_256, multihash.SHA2_512, multihash.IDENTITY, multihash.SHA2_256} {
+		mh, err := multihash.Sum([]byte(fmt.Sprintf("fish%d", i)), code, -1)
+		if err != nil {
+			f.Fatal(err)
+		}
+		records = append(records, index.Record{
+			Cid:    cid.NewCidV1(cid.Raw, mh),
+			Offset: uint64(i) * 100,
+			Size:   uint64(i) + 100,
+		})
+	}
+	return records
+}
+
+// seedWithIndices adds the serialized form of every fuzzed codec to f, both with and without a
+// checksum.
+func seedWithIndices(f *testing.F) {
+	records := fuzzRecords(f)
+	for _, codec := range fuzzedCodecs {
+		idx, err := index.New(codec)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if err := idx.Load(records); err != nil {
+			f.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := index.WriteTo(idx, &buf); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+		buf.Reset()
+		if _, err := index.WriteToWithChecksum(idx, &buf); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+}
+
+// exerciseIndex calls every read method of idx, which must not panic regardless of the bytes idx
+// was decoded from.
+func exerciseIndex(idx index.Index) {
+	_, _ = idx.Marshal(io.Discard)
+	if counter, ok := idx.(index.CountableIndex); ok {
+		_, _ = counter.Count()
+	}
+	iterable, ok := idx.(index.IterableIndex)
+	if !ok {
+		return
+	}
+	_ = iterable.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		if _, err := multihash.Decode(mh); err != nil {
+			return nil
+		}
+		_ = idx.GetAll(cid.NewCidV1(cid.Raw, mh), func(uint64) bool { return true })
+		return nil
+	})
+}
+
+func FuzzReadFrom(f *testing.F) {
+	seedWithIndices(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		idx, err := index.ReadFrom(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		exerciseIndex(idx)
+	})
+}
+
+func FuzzOpenMmap(f *testing.F) {
+	seedWithIndices(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		idx, err := index.OpenMmap(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		exerciseIndex(idx)
+	})
+}
+
+func FuzzInsertionIndexUnmarshal(f *testing.F) {
+	subject := index.NewInsertionIndex()
+	if err := subject.Load(fuzzRecords(f)); err != nil {
+		f.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := subject.Marshal(&buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		idx := index.NewInsertionIndex()
+		if err := idx.Unmarshal(bytes.NewReader(data)); err != nil {
+			return
+		}
+		exerciseIndex(idx)
+	})
+}
+
+func TestReadFrom_HostileIndexIsError(t *testing.T) {
+	// indexBytes serializes an index of the given codec from the given values, each of which is
+	// written in little-endian.
+	indexBytes := func(codec multicodec.Code, values ...interface{}) []byte {
+		buf := new(bytes.Buffer)
+		buf.Write(varint.ToUvarint(uint64(codec)))
+		for _, v := range values {
+			require.NoError(t, binary.Write(buf, binary.LittleEndian, v))
+		}
+		return buf.Bytes()
+	}
+	record := make([]byte, 40)
+
+	tests := []struct {
+		name          string
+		data          []byte
+		wantMalformed bool
+	}{
+		{
+			name: "SortedHugeDataLen",
+			// A single bucket that declares exabytes of records without actually containing them.
+			data: indexBytes(multicodec.CarIndexSorted, int32(1), uint32(40), uint64(40)<<55),
+		},
+		{
+			name:          "SortedDataLenNotMultipleOfWidth",
+			data:          indexBytes(multicodec.CarIndexSorted, int32(1), uint32(40), uint64(39), record[:39]),
+			wantMalformed: true,
+		},
+		{
+			name:          "SortedWidthTooSmall",
+			data:          indexBytes(multicodec.CarIndexSorted, int32(1), uint32(7), uint64(0)),
+			wantMalformed: true,
+		},
+		{
+			name: "SortedDuplicateWidths",
+			data: indexBytes(multicodec.CarIndexSorted, int32(2),
+				uint32(40), uint64(40), record,
+				uint32(40), uint64(40), record),
+			wantMalformed: true,
+		},
+		{
+			name: "MultihashSortedDuplicateCodes",
+			data: indexBytes(multicodec.CarMultihashIndexSorted, int32(2),
+				uint64(multihash.SHA2_256), int32(1), uint32(40), uint64(40), record,
+				uint64(multihash.SHA2_256), int32(1), uint32(40), uint64(40), record),
+			wantMalformed: true,
+		},
+		{
+			name:          "MultihashSortedCountOverflow",
+			data:          indexBytes(multicodec.CarMultihashIndexSorted, int32(-1)),
+			wantMalformed: true,
+		},
+		{
+			name: "SizedHugeDataLen",
+			data: indexBytes(index.CarMultihashSizedIndexSorted, int32(1), uint32(50), int64(50)<<55),
+		},
+		{
+			name: "HashedHugeDataLen",
+			data: indexBytes(index.CarMultihashIndexHashed, int32(1), uint32(40), uint64(1), uint64(40)<<55),
+		},
+		{
+			name:          "ChecksummedLengthOverflow",
+			data:          indexBytes(index.CarIndexChecksummed, uint64(1)<<63, make([]byte, 32)),
+			wantMalformed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := index.ReadFrom(bytes.NewReader(tt.data))
+			require.Error(t, err)
+			var malformedErr *index.ErrMalformedIndex
+			if tt.wantMalformed {
+				require.True(t, errors.As(err, &malformedErr), "expected malformed index error, got %v", err)
+			} else {
+				require.Equal(t, io.ErrUnexpectedEOF, err)
+			}
+
+			_, err = index.OpenMmap(bytes.NewReader(tt.data), int64(len(tt.data)))
+			require.Error(t, err)
+		})
+	}
+}
@@ -1,9 +1,16 @@
 package index
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/ipfs/go-cid"
 	internalio "github.com/ipld/go-car/v2/internal/io"
@@ -16,11 +23,40 @@ import (
 // CarIndexNone is a sentinal value used as a multicodec code for the index indicating no index.
 const CarIndexNone = 0x300000
 
+// CarCidIndexSorted is the multicodec code for CidIndexSorted, an index that stores whole CIDs.
+// Since the index is not yet defined in the CARv2 spec, its code is taken from the private use
+// range of multicodec codes.
+const CarCidIndexSorted = multicodec.Code(0x300001)
+
+// CarMultihashSizedIndexSorted is the multicodec code for MultihashSizedIndexSorted, an index that
+// stores the size of blocks alongside their multihash and offset.
+// Since the index is not yet defined in the CARv2 spec, its code is taken from the private use
+// range of multicodec codes.
+const CarMultihashSizedIndexSorted = multicodec.Code(0x300002)
+
+// CarMultihashIndexHashed is the multicodec code for MultihashIndexHashed, an index that stores
+// records in hash tables for constant time lookups.
+// Since the index is not yet defined in the CARv2 spec, its code is taken from the private use
+// range of multicodec codes.
+const CarMultihashIndexHashed = multicodec.Code(0x300004)
+
+// CarIndexChecksummed is the multicodec code of a checksummed index, i.e. an index serialized as
+// by WriteTo, prefixed by its length and checksum. It is not an index itself; see
+// WriteToWithChecksum.
+// Since the wrapper is not yet defined in the CARv2 spec, its code is taken from the private use
+// range of multicodec codes.
+const CarIndexChecksummed = multicodec.Code(0x300005)
+
 type (
 	// Record is a pre-processed record of a car item and location.
 	Record struct {
 		cid.Cid
 		Offset uint64
+		// Size is the length of the section in bytes, i.e. the length of its CID plus the length
+		// of its block data, excluding the section length prefix.
+		// Zero signals that the length is not known.
+		// Only indices that satisfy SizedIndex make use of it.
+		Size uint64
 	}
 
 	// Index provides an interface for looking up byte offset of a given CID.
@@ -32,7 +68,14 @@ type (
 	// implementations might index the entire CID, the entire multihash, or
 	// just part of a multihash's digest.
 	//
-	// See: multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted
+	// See: multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, CarCidIndexSorted,
+	// CarMultihashIndexHashed
+	//
+	// The indices constructed via New, ReadFrom and OpenMmap, as well as InsertionIndex, are safe
+	// for concurrent lookups via GetAll and the methods of the other interfaces they satisfy, e.g.
+	// ForEach, such that a loaded index can be shared between goroutines. They are not safe for
+	// lookups concurrent with Load or Unmarshal; indices that are still being loaded while looked
+	// up should be wrapped via Synchronized.
 	Index interface {
 		// Codec provides the multicodec code that the index implements.
 		//
@@ -41,7 +84,7 @@ type (
 		Codec() multicodec.Code
 
 		// Marshal encodes the index in serial form.
-		Marshal(w io.Writer) (uint64, error)
+		Marshal(w io.Writer) (int64, error)
 
 		// Unmarshal decodes the index from its serial form.
 		// Note, this function will copy the entire index into memory.
@@ -71,7 +114,15 @@ type (
 		GetAll(cid.Cid, func(uint64) bool) error
 	}
 
-	// IterableIndex is an index which support iterating over it's elements
+	// IterableIndex is an index which support iterating over it's elements.
+	//
+	// Consumers that need to enumerate the contents of an index, rather than perform point lookups,
+	// should type-assert to this interface instead of rescanning the CAR payload.
+	// The indices constructed via New, ReadFrom and car.GenerateIndex with the default
+	// multicodec.CarMultihashIndexSorted codec, or the CarCidIndexSorted,
+	// CarMultihashSizedIndexSorted and CarMultihashIndexHashed codecs satisfy this interface.
+	// Note that multicodec.CarIndexSorted indices do not, since they only store multihash digests
+	// and cannot reconstruct the original multihashes.
 	IterableIndex interface {
 		Index
 
@@ -89,6 +140,85 @@ type (
 		// The order of calls to the given function is deterministic, but entirely index-specific.
 		ForEach(func(multihash.Multihash, uint64) error) error
 	}
+
+	// SizedIndex is an index which records the size of the indexed blocks, such that it can be
+	// looked up without reading the CAR payload.
+	//
+	// Consumers should type-assert to this interface, and fall back on reading the size from the
+	// CAR payload if the index does not satisfy it or if the size of a block is not known.
+	SizedIndex interface {
+		Index
+
+		// GetSize returns the size of the data of the first indexed block matching the given CID,
+		// excluding the CID itself.
+		//
+		// The returned bool is false if the block is indexed but its size is not known.
+		// If the CID isn't indexed, ErrNotFound is returned.
+		GetSize(cid.Cid) (uint64, bool, error)
+	}
+
+	// CountableIndex is an index which can report the number of records it stores without
+	// iterating over them.
+	//
+	// The indices constructed via New and ReadFrom, as well as InsertionIndex and the indices
+	// opened via OpenMmap satisfy this interface.
+	CountableIndex interface {
+		Index
+
+		// Count returns the total number of records stored by this index.
+		//
+		// Records with the same multihash, e.g. via duplicate blocks, are each counted. Therefore,
+		// the count may be larger than the number of unique multihashes stored by this index.
+		Count() (uint64, error)
+	}
+
+	// MemoryFootprintIndex is an index which can report an estimate of the heap memory it holds,
+	// e.g. to account for the memory cost of keeping many indices loaded at once.
+	//
+	// The indices constructed via New and ReadFrom, as well as InsertionIndex satisfy this
+	// interface. The indices opened via OpenMmap do not, since their records are not held in
+	// memory.
+	MemoryFootprintIndex interface {
+		Index
+
+		// MemoryFootprint returns the estimated number of bytes of heap memory held by this index,
+		// including the storage of its records and the fixed overhead of its buckets. The estimate
+		// accounts for the rounding of allocations by the Go runtime, and is intended to be within
+		// ~10% of the heap memory actually held by indices that store many records.
+		MemoryFootprint() uint64
+	}
+
+	// OffsetIndex is an index which can resolve an offset within the CAR payload back to the record
+	// of the section containing it, e.g. to tell which block an I/O error at a given offset affects.
+	//
+	// InsertionIndex satisfies this interface, and any IterableIndex can be made to satisfy it via
+	// WithOffsetLookup.
+	OffsetIndex interface {
+		IterableIndex
+
+		// FindByOffset returns the record of the indexed section that contains the given offset,
+		// i.e. the record with the greatest offset that is at most the given one, such that
+		// Record.Offset is the start of the section. Records are populated with as much
+		// information as the index stores, as by OffsetOrdered.
+		//
+		// The returned bool is false if no indexed section contains the offset, i.e. if it precedes
+		// every record, or if it is past the end of the section of the preceding record as told by
+		// its size, in which case the preceding record is returned nonetheless. When the size of
+		// that record is not known, the offset is assumed to be within its section; this is not
+		// the case for offsets within padding or sections that are not indexed, e.g. sections of
+		// identity CIDs.
+		FindByOffset(offset uint64) (Record, bool, error)
+
+		// ForEachOffsetOrder calls f with every record of the index in ascending order of offset,
+		// i.e. in the order in which their sections appear in the CAR payload, stopping at the first
+		// error returned by f, which is then returned. Every record is visited, including duplicate
+		// records of the same CID, and records at the same offset are visited in the order in which
+		// ForEach visits them. Records are populated as by FindByOffset.
+		//
+		// Unlike ForEach, this allows comparing the index with the sections read from the payload,
+		// e.g. to diff them, without seeking back and forth.
+		ForEachOffsetOrder(f func(Record) error) error
+	}
 )
 
 // GetFirst is a wrapper over Index.GetAll, returning the offset for the first
@@ -102,36 +232,201 @@ func GetFirst(idx Index, key cid.Cid) (uint64, error) {
 	return firstOffset, err
 }
 
-// New constructs a new index corresponding to the given CAR index codec.
+// contextCheckInterval is the number of records visited between checks of whether a context is
+// done, such that checking it does not dominate the cost of visiting records.
+const contextCheckInterval = 1024
+
+// GetAllContext is a wrapper over Index.GetAll that stops once ctx is done, in which case the
+// context error is returned. The context is checked before looking up the index, and periodically
+// as offsets are passed to fn, such that looking up a CID with many duplicates can be aborted.
+// ErrNotFound is returned as is if the CID isn't indexed.
+func GetAllContext(ctx context.Context, idx Index, key cid.Cid, fn func(uint64) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var calls uint64
+	var ctxErr error
+	err := idx.GetAll(key, func(offset uint64) bool {
+		if calls++; calls%contextCheckInterval == 0 {
+			if ctxErr = ctx.Err(); ctxErr != nil {
+				return false
+			}
+		}
+		return fn(offset)
+	})
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// ForEachContext is a wrapper over IterableIndex.ForEach that stops once ctx is done, in which case
+// the context error is returned. The context is checked before iterating over the index, and
+// periodically as records are passed to fn, such that iterating over a large index can be aborted.
+func ForEachContext(ctx context.Context, idx IterableIndex, fn func(multihash.Multihash, uint64) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var calls uint64
+	return idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		if calls++; calls%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return fn(mh, offset)
+	})
+}
+
+// GetAllOffsets is a wrapper over Index.GetAll, returning the offsets of all matching indexed
+// CIDs in the order in which they are passed to the GetAll callback. ErrNotFound is returned as is
+// if the CID isn't indexed.
+// See ForEachOffset.
+func GetAllOffsets(idx Index, key cid.Cid) ([]uint64, error) {
+	var offsets []uint64
+	err := idx.GetAll(key, func(offset uint64) bool {
+		offsets = append(offsets, offset)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// ForEachOffset is a wrapper over Index.GetAll, calling f with the offset of each matching indexed
+// CID. Iteration stops once f has been called limit times, where zero means no limit, at the first
+// error returned by f, or once ctx is done, in which case the context error is returned.
+// ErrNotFound is returned as is if the CID isn't indexed.
+func ForEachOffset(ctx context.Context, idx Index, key cid.Cid, limit uint64, f func(offset uint64) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var calls uint64
+	var fErr error
+	err := idx.GetAll(key, func(offset uint64) bool {
+		if fErr = ctx.Err(); fErr != nil {
+			return false
+		}
+		if fErr = f(offset); fErr != nil {
+			return false
+		}
+		calls++
+		return limit == 0 || calls < limit
+	})
+	if fErr != nil {
+		return fErr
+	}
+	return err
+}
+
+// OffsetOrdered returns the records of the given index sorted in ascending order of offset, i.e.
+// in the order in which their sections appear in the CAR payload, which allows reading or
+// prefetching blocks sequentially. Records at the same offset are kept in the order in which
+// the index iterates over them.
+//
+// The records are computed on demand, holding every record of the index in memory; nothing is
+// persisted alongside the index. Records are populated with as much information as the index
+// stores: see Transcode for which codecs store whole CIDs and sizes. Records of indices that only
+// store multihashes have CIDs of codec cid.Raw.
+func OffsetOrdered(idx IterableIndex) ([]Record, error) {
+	records, err := transcodeRecords(idx)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Offset < records[j].Offset
+	})
+	return records, nil
+}
+
+// New constructs a new index corresponding to the given CAR index codec, which is either one of the
+// codecs defined by this package or a codec registered via RegisterCodec.
+// ErrUnknownIndexCodec is returned if the codec is neither.
 func New(codec multicodec.Code) (Index, error) {
 	switch codec {
 	case multicodec.CarIndexSorted:
 		return newSorted(), nil
 	case multicodec.CarMultihashIndexSorted:
 		return NewMultihashSorted(), nil
+	case CarCidIndexSorted:
+		return NewCidSorted(), nil
+	case CarMultihashSizedIndexSorted:
+		return NewMultihashSizedSorted(), nil
+	case CarMultihashIndexHashed:
+		return NewMultihashHashed(), nil
 	default:
-		return nil, fmt.Errorf("unknwon index codec: %v", codec)
+		if unmarshaler, ok := registered(codec); ok {
+			return unmarshaler(), nil
+		}
+		return nil, &ErrUnknownIndexCodec{Code: codec}
+	}
+}
+
+// NewFromRecords constructs a new index corresponding to the given CAR index codec, loaded with
+// the given records. This allows tools that already know the offsets of sections, e.g. parsers of
+// other formats, to build an index without generating one from a CAR.
+//
+// Records are validated upfront, and an error is returned if any record has an undefined CID or a
+// CID with an invalid multihash. Records with duplicate CIDs are all stored, such that their
+// offsets are all passed to GetAll. The given records are not modified.
+func NewFromRecords(codec multicodec.Code, records []Record) (Index, error) {
+	for i, r := range records {
+		if !r.Cid.Defined() {
+			return nil, fmt.Errorf("invalid record %d: undefined CID", i)
+		}
+		if _, err := multihash.Decode(r.Cid.Hash()); err != nil {
+			return nil, fmt.Errorf("invalid record %d: %w", i, err)
+		}
+	}
+	idx, err := New(codec)
+	if err != nil {
+		return nil, err
 	}
+	if err := idx.Load(records); err != nil {
+		return nil, err
+	}
+	return idx, nil
 }
 
 // WriteTo writes the given idx into w.
 // The written bytes include the index encoding.
 // This can then be read back using index.ReadFrom
-func WriteTo(idx Index, w io.Writer) (uint64, error) {
+//
+// The indices defined by this package are written canonically: two indices of the same codec
+// loaded with the same set of records are written identically, regardless of the order in which
+// the records are loaded, including records with duplicate multihashes. Buckets are written in
+// ascending order of multihash code and width, and the records within each bucket are sorted, or
+// inserted into hash tables, in ascending order of digest, then offset. This makes CARv2 files
+// built from the same data payload byte-for-byte reproducible. The same holds for
+// InsertionIndex.WriteFlattenedTo.
+func WriteTo(idx Index, w io.Writer) (int64, error) {
 	buf := make([]byte, binary.MaxVarintLen64)
 	b := varint.PutUvarint(buf, uint64(idx.Codec()))
 	n, err := w.Write(buf[:b])
 	if err != nil {
-		return uint64(n), err
+		return int64(n), err
 	}
 
 	l, err := idx.Marshal(w)
-	return uint64(n) + l, err
+	return int64(n) + l, err
 }
 
 // ReadFrom reads index from r.
 // The reader decodes the index by reading the first byte to interpret the encoding.
-// Returns error if the encoding is not known.
+// Returns ErrUnknownIndexCodec if the encoding is neither defined by this package nor registered
+// via RegisterCodec.
+//
+// The returned index may be type-asserted to IterableIndex in order to enumerate its records,
+// depending on its codec.
+//
+// Checksummed indices, as written by WriteToWithChecksum, are verified before being decoded, and
+// ErrIndexChecksum is returned if the checksum does not match. The returned index is the wrapped
+// index. Indices written by WriteTo carry no checksum and are read as is.
+//
+// Indices whose declared lengths, widths or counts are invalid result in ErrMalformedIndex, and
+// the memory allocated while decoding is bounded by the number of bytes actually read from r
+// rather than the lengths declared by the index. Truncated indices result in io.ErrUnexpectedEOF.
 //
 // Attempting to read index data from untrusted sources is not recommended.
 // Instead the index should be regenerated from the CARv2 data payload.
@@ -140,6 +435,9 @@ func ReadFrom(r io.Reader) (Index, error) {
 	if err != nil {
 		return nil, err
 	}
+	if codec == CarIndexChecksummed {
+		return readChecksummed(r)
+	}
 	idx, err := New(codec)
 	if err != nil {
 		return nil, err
@@ -150,6 +448,180 @@ func ReadFrom(r io.Reader) (Index, error) {
 	return idx, nil
 }
 
+// countedHeaderMarker is written in place of the bucket count of the indices defined by this
+// package whose serial form otherwise starts with it, i.e. CidIndexSorted and
+// MultihashSizedIndexSorted, and is followed by the total number of records of the index and then
+// by the bucket count. Since bucket counts are never negative, indices written without a record
+// count are still read as is.
+const countedHeaderMarker = int32(-1)
+
+// writeCountedHeader writes the marker and record count that precede the bucket count of an index
+// that records its count; see countedHeaderMarker.
+func writeCountedHeader(w io.Writer, count uint64) (int64, error) {
+	if err := binary.Write(w, binary.LittleEndian, countedHeaderMarker); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, count); err != nil {
+		return 4, err
+	}
+	return 12, nil
+}
+
+// readCountedHeader reads the bucket count of an index, preceded by its record count if present.
+// The returned bool is false if the index was written without a record count.
+func readCountedHeader(r io.Reader) (buckets int32, count uint64, counted bool, err error) {
+	if err = binary.Read(r, binary.LittleEndian, &buckets); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	if buckets != countedHeaderMarker {
+		return
+	}
+	if err = binary.Read(r, binary.LittleEndian, &count); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	if int64(count) < 0 {
+		err = malformedIndexError("record count %d is overflowing int64", count)
+		return
+	}
+	if err = binary.Read(r, binary.LittleEndian, &buckets); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	counted = true
+	return
+}
+
+// checkRecordCount checks that an index read with the given record count, as read by
+// readCountedHeader, holds exactly that many records.
+func checkRecordCount(idx CountableIndex, count uint64) error {
+	got, err := idx.Count()
+	if err != nil {
+		return err
+	}
+	if got != count {
+		return malformedIndexError("index has %d records, but declares %d", got, count)
+	}
+	return nil
+}
+
+// maxUpfrontAlloc is the maximum number of bytes allocated upfront when reading a length-prefixed
+// part of an index, since the declared length cannot be trusted until the bytes are actually read.
+const maxUpfrontAlloc = 1 << 20 // 1MiB
+
+// readDeclaredLength reads exactly n bytes from r, where n is a length declared by the index being
+// read. Lengths larger than maxUpfrontAlloc are read incrementally, such that the allocated memory
+// grows with the bytes actually read. Returns io.ErrUnexpectedEOF if r has fewer than n bytes.
+func readDeclaredLength(r io.Reader, n uint64) ([]byte, error) {
+	if n <= maxUpfrontAlloc {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return buf, nil
+	}
+	if int64(n) < 0 {
+		return nil, malformedIndexError("length %d is overflowing int64", n)
+	}
+	var buf bytes.Buffer
+	buf.Grow(maxUpfrontAlloc)
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SaveToFile writes the given idx to a file at the given path, in the same format as WriteTo.
+// The file is written atomically: the index is first written to a temporary file in the same
+// directory, which is synced to disk and then renamed to path. Therefore, an existing file at path
+// is either entirely replaced or left untouched.
+//
+// The saved index can be read back using FromFile.
+func SaveToFile(idx Index, path string) (err error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Clean up the temporary file if anything went wrong.
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	if _, err = WriteTo(idx, bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// FromFile reads an index from the file at the given path, as written by SaveToFile or WriteTo.
+// The file must contain exactly one index; an error is returned if the file is empty, truncated,
+// or has trailing bytes after the index.
+//
+// Attempting to read index data from untrusted sources is not recommended.
+// Instead the index should be regenerated from the CARv2 data payload.
+func FromFile(path string) (Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+	if size == 0 {
+		return nil, fmt.Errorf("invalid index file %s: file is empty", path)
+	}
+
+	idx, err := ReadFrom(f)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("invalid index file %s: truncated or corrupt: %w", path, err)
+	}
+	read, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if read != size {
+		return nil, fmt.Errorf("invalid index file %s: %d unexpected trailing bytes", path, size-read)
+	}
+	return idx, nil
+}
+
 // ReadCodec reads the codec of the index by decoding the first varint read from r.
 func ReadCodec(r io.Reader) (multicodec.Code, error) {
 	code, err := varint.ReadUvarint(internalio.ToByteReader(r))
@@ -2,15 +2,21 @@ package index
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/ipld/go-car/v2/internal/carv1/util"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/require"
 )
@@ -46,6 +52,239 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewFromRecords(t *testing.T) {
+	var records []Record
+	for i := 0; i < 10; i++ {
+		c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte{byte(i)})
+		require.NoError(t, err)
+		records = append(records, Record{Cid: c, Offset: uint64(i) * 100, Size: uint64(c.ByteLen()) + 1})
+	}
+	// Duplicate the first record at a different offset.
+	duplicate := Record{Cid: records[0].Cid, Offset: 1413, Size: records[0].Size}
+	records = append(records, duplicate)
+
+	codecs := []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		CarCidIndexSorted,
+		CarMultihashSizedIndexSorted,
+		CarMultihashIndexHashed,
+	}
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			subject, err := NewFromRecords(codec, records)
+			require.NoError(t, err)
+			require.Equal(t, codec, subject.Codec())
+			for _, r := range records[1 : len(records)-1] {
+				offset, err := GetFirst(subject, r.Cid)
+				require.NoError(t, err)
+				require.Equal(t, r.Offset, offset)
+			}
+			var offsets []uint64
+			err = subject.GetAll(records[0].Cid, func(offset uint64) bool {
+				offsets = append(offsets, offset)
+				return true
+			})
+			require.NoError(t, err)
+			require.ElementsMatch(t, []uint64{records[0].Offset, duplicate.Offset}, offsets)
+		})
+	}
+
+	_, err := NewFromRecords(multicodec.CarMultihashIndexSorted, append(records, Record{Offset: 1}))
+	require.Error(t, err)
+	_, err = NewFromRecords(multicodec.Cidv1, records)
+	require.Error(t, err)
+}
+
+func TestGetAllOffsetsAndForEachOffset(t *testing.T) {
+	var records []Record
+	for i := 0; i < 10; i++ {
+		c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte{byte(i)})
+		require.NoError(t, err)
+		records = append(records, Record{Cid: c, Offset: uint64(i) * 100})
+	}
+	// Duplicate the first record at two more offsets.
+	key := records[0].Cid
+	records = append(records, Record{Cid: key, Offset: 1413}, Record{Cid: key, Offset: 1414})
+	wantOffsets := []uint64{records[0].Offset, 1413, 1414}
+	missing, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte("fish"))
+	require.NoError(t, err)
+
+	subject, err := NewFromRecords(multicodec.CarMultihashIndexSorted, records)
+	require.NoError(t, err)
+
+	got, err := GetAllOffsets(subject, key)
+	require.NoError(t, err)
+	require.ElementsMatch(t, wantOffsets, got)
+	_, err = GetAllOffsets(subject, missing)
+	require.Equal(t, ErrNotFound, err)
+
+	// Assert the limit bounds the number of calls, and zero means no limit.
+	for limit, wantCalls := range []int{3, 1, 2, 3, 3} {
+		var calls int
+		err := ForEachOffset(context.Background(), subject, key, uint64(limit), func(offset uint64) error {
+			require.Contains(t, wantOffsets, offset)
+			calls++
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, wantCalls, calls)
+	}
+	err = ForEachOffset(context.Background(), subject, missing, 0, func(uint64) error { return nil })
+	require.Equal(t, ErrNotFound, err)
+
+	// Assert iteration stops at the first error returned by f.
+	wantErr := errors.New("lobster")
+	var calls int
+	err = ForEachOffset(context.Background(), subject, key, 0, func(uint64) error {
+		calls++
+		return wantErr
+	})
+	require.Equal(t, wantErr, err)
+	require.Equal(t, 1, calls)
+
+	// Assert iteration stops once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = ForEachOffset(ctx, subject, key, 0, func(uint64) error {
+		calls++
+		cancel()
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 1, calls)
+	err = ForEachOffset(ctx, subject, key, 0, func(uint64) error { return nil })
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestGetAllContextAndForEachContext(t *testing.T) {
+	// Index enough records for the context to be checked a few times while iterating.
+	const count = 3 * contextCheckInterval
+	var records []Record
+	for i := 0; i < count; i++ {
+		c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte(fmt.Sprintf("🐙-%d", i)))
+		require.NoError(t, err)
+		records = append(records, Record{Cid: c, Offset: uint64(i)})
+	}
+	// Duplicate the first record as many times.
+	key := records[0].Cid
+	for i := 1; i < count; i++ {
+		records = append(records, Record{Cid: key, Offset: uint64(count + i)})
+	}
+	subject, err := NewFromRecords(multicodec.CarMultihashIndexSorted, records)
+	require.NoError(t, err)
+	iterable := subject.(IterableIndex)
+
+	var calls int
+	err = GetAllContext(context.Background(), subject, key, func(uint64) bool {
+		calls++
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, count, calls)
+	calls = 0
+	err = ForEachContext(context.Background(), iterable, func(multihash.Multihash, uint64) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(records), calls)
+	missing, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte("fish"))
+	require.NoError(t, err)
+	err = GetAllContext(context.Background(), subject, missing, func(uint64) bool { return true })
+	require.Equal(t, ErrNotFound, err)
+
+	// Assert iteration stops at the next check once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = GetAllContext(ctx, subject, key, func(uint64) bool {
+		calls++
+		cancel()
+		return true
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, contextCheckInterval-1, calls)
+	ctx, cancel = context.WithCancel(context.Background())
+	calls = 0
+	err = ForEachContext(ctx, iterable, func(multihash.Multihash, uint64) error {
+		calls++
+		cancel()
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, contextCheckInterval-1, calls)
+
+	// Assert nothing is iterated over once the context is done.
+	calls = 0
+	err = GetAllContext(ctx, subject, key, func(uint64) bool {
+		calls++
+		return true
+	})
+	require.Equal(t, context.Canceled, err)
+	err = ForEachContext(ctx, iterable, func(multihash.Multihash, uint64) error {
+		calls++
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Zero(t, calls)
+}
+
+func TestOffsetOrdered(t *testing.T) {
+	// Generate records in descending order of offset, with DAG-CBOR CIDs such that indices that
+	// store whole CIDs can be told apart from those that do not.
+	var records []Record
+	for i := 0; i < 20; i++ {
+		c, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum([]byte{byte(i)})
+		require.NoError(t, err)
+		records = append(records, Record{Cid: c, Offset: uint64(20-i) * 100, Size: uint64(c.ByteLen() + i)})
+	}
+	want := make([]Record, len(records))
+	for i, r := range records {
+		want[len(records)-1-i] = r
+	}
+
+	for _, tt := range []struct {
+		codec     multicodec.Code
+		wholeCids bool
+		sizes     bool
+	}{
+		{codec: multicodec.CarMultihashIndexSorted},
+		{codec: CarMultihashIndexHashed},
+		{codec: CarCidIndexSorted, wholeCids: true},
+		{codec: CarMultihashSizedIndexSorted, sizes: true},
+		{codec: insertionIndexCodec, wholeCids: true, sizes: true},
+	} {
+		t.Run(tt.codec.String(), func(t *testing.T) {
+			var subject IterableIndex
+			if tt.codec == insertionIndexCodec {
+				ii := NewInsertionIndex()
+				require.NoError(t, ii.Load(records))
+				subject = ii
+			} else {
+				idx, err := NewFromRecords(tt.codec, records)
+				require.NoError(t, err)
+				subject = idx.(IterableIndex)
+			}
+
+			got, err := OffsetOrdered(subject)
+			require.NoError(t, err)
+			require.Len(t, got, len(want))
+			for i, r := range got {
+				require.Equal(t, want[i].Offset, r.Offset)
+				require.Equal(t, want[i].Cid.Hash(), r.Cid.Hash())
+				if tt.wholeCids {
+					require.Equal(t, want[i].Cid, r.Cid)
+				} else {
+					require.Equal(t, uint64(cid.Raw), r.Cid.Prefix().Codec)
+				}
+				if tt.sizes {
+					require.Equal(t, want[i].Size, r.Size)
+				}
+			}
+		})
+	}
+}
+
 func TestReadFrom(t *testing.T) {
 	idxf, err := os.Open("../testdata/sample-index.carindex")
 	require.NoError(t, err)
@@ -93,7 +332,7 @@ func TestReadFrom(t *testing.T) {
 		require.NoError(t, err)
 
 		// Read the fame at offset and assert the frame corresponds to the expected block.
-		gotCid, gotData, err := util.ReadNode(crf, false, carv1.DefaultMaxAllowedSectionSize)
+		gotCid, gotData, err := util.ReadNode(crf, false, false, false, carv1.DefaultMaxAllowedSectionSize)
 		require.NoError(t, err)
 		gotBlock, err := blocks.NewBlockWithCid(gotData, gotCid)
 		require.NoError(t, err)
@@ -101,6 +340,33 @@ func TestReadFrom(t *testing.T) {
 	}
 }
 
+func TestReadFromReturnsIterableIndex(t *testing.T) {
+	idxf, err := os.Open("../testdata/sample-multihash-index-sorted.carindex")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, idxf.Close()) })
+
+	idx, err := ReadFrom(idxf)
+	require.NoError(t, err)
+	subject, ok := idx.(IterableIndex)
+	require.True(t, ok)
+
+	var count int
+	err = subject.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		count++
+		// Assert each record iterated over is consistent with point lookups.
+		var found bool
+		err := subject.GetAll(cid.NewCidV1(cid.Raw, mh), func(o uint64) bool {
+			found = o == offset
+			return !found
+		})
+		require.NoError(t, err)
+		require.True(t, found)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotZero(t, count)
+}
+
 func TestWriteTo(t *testing.T) {
 	// Read sample index on file
 	idxf, err := os.Open("../testdata/sample-multihash-index-sorted.carindex")
@@ -131,6 +397,152 @@ func TestWriteTo(t *testing.T) {
 	require.Equal(t, wantIdx, gotIdx)
 }
 
+func TestEmptyIndexRoundTrip(t *testing.T) {
+	key, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte("fish"))
+	require.NoError(t, err)
+
+	codecs := []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		CarCidIndexSorted,
+		CarMultihashSizedIndexSorted,
+		CarMultihashIndexHashed,
+	}
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			empty, err := NewFromRecords(codec, nil)
+			require.NoError(t, err)
+			ii := NewInsertionIndex()
+			flattened, err := ii.Flatten(codec)
+			require.NoError(t, err)
+			require.Equal(t, empty, flattened)
+
+			var plain, checksummed, flattenedPlain, flattenedChecksummed bytes.Buffer
+			_, err = WriteTo(empty, &plain)
+			require.NoError(t, err)
+			_, err = WriteToWithChecksum(empty, &checksummed)
+			require.NoError(t, err)
+			n, err := ii.WriteFlattenedTo(&flattenedPlain, codec)
+			require.NoError(t, err)
+			require.Equal(t, int64(flattenedPlain.Len()), n)
+			n, err = ii.WriteFlattenedToWithChecksum(&flattenedChecksummed, codec)
+			require.NoError(t, err)
+			require.Equal(t, int64(flattenedChecksummed.Len()), n)
+			require.Equal(t, plain.Bytes(), flattenedPlain.Bytes())
+			require.Equal(t, checksummed.Bytes(), flattenedChecksummed.Bytes())
+
+			serializations := []struct {
+				name        string
+				serialized  []byte
+				checksummed bool
+			}{
+				{"Plain", plain.Bytes(), false},
+				{"Checksummed", checksummed.Bytes(), true},
+			}
+			for _, s := range serializations {
+				t.Run(s.name, func(t *testing.T) {
+					r := bytes.NewReader(s.serialized)
+					got, err := ReadFrom(r)
+					require.NoError(t, err)
+					require.Zero(t, r.Len())
+					requireEmpty(t, codec, got, key)
+
+					// Codecs not supported by OpenMmap are read into memory only when checksummed.
+					got, err = OpenMmap(bytes.NewReader(s.serialized), int64(len(s.serialized)))
+					if !s.checksummed && (codec == CarCidIndexSorted || codec == CarMultihashSizedIndexSorted) {
+						require.Error(t, err)
+						return
+					}
+					require.NoError(t, err)
+					requireEmpty(t, codec, got, key)
+				})
+			}
+		})
+	}
+}
+
+// requireEmpty asserts that the given index is of the given codec, holds no records, and returns
+// ErrNotFound when looking up the given key.
+func requireEmpty(t *testing.T, codec multicodec.Code, idx Index, key cid.Cid) {
+	t.Helper()
+	require.Equal(t, codec, idx.Codec())
+	err := idx.GetAll(key, func(uint64) bool {
+		require.Fail(t, "empty index must not yield any offset")
+		return false
+	})
+	require.Equal(t, ErrNotFound, err)
+	_, err = GetFirst(idx, key)
+	require.Equal(t, ErrNotFound, err)
+	count, err := idx.(CountableIndex).Count()
+	require.NoError(t, err)
+	require.Zero(t, count)
+	if iterable, ok := idx.(IterableIndex); ok {
+		require.NoError(t, iterable.ForEach(func(multihash.Multihash, uint64) error {
+			return errors.New("empty index must not iterate over any record")
+		}))
+	}
+}
+
+func TestSaveToFileAndFromFile(t *testing.T) {
+	wantIdx, err := FromFile("../testdata/sample-multihash-index-sorted.carindex")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "index-save-to-file-test.carindex")
+	require.NoError(t, SaveToFile(wantIdx, dest))
+
+	// Assert no temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	gotIdx, err := FromFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, wantIdx, gotIdx)
+
+	// Assert saving again replaces the existing file.
+	require.NoError(t, SaveToFile(wantIdx, dest))
+	gotIdx, err = FromFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, wantIdx, gotIdx)
+}
+
+func TestFromFileWithInvalidContentIsError(t *testing.T) {
+	content, err := os.ReadFile("../testdata/sample-multihash-index-sorted.carindex")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr string
+	}{
+		{
+			name:    "Empty",
+			content: []byte{},
+			wantErr: "file is empty",
+		},
+		{
+			name:    "Truncated",
+			content: content[:len(content)/2],
+			wantErr: "truncated or corrupt",
+		},
+		{
+			name:    "TrailingBytes",
+			content: append(append([]byte{}, content...), 0x01, 0x02),
+			wantErr: "2 unexpected trailing bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "invalid.carindex")
+			require.NoError(t, os.WriteFile(path, tt.content, 0o644))
+			_, err := FromFile(path)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
 func TestMarshalledIndexStartsWithCodec(t *testing.T) {
 
 	tests := []struct {
@@ -166,3 +578,82 @@ func TestMarshalledIndexStartsWithCodec(t *testing.T) {
 		})
 	}
 }
+
+func TestHashTableCapacity(t *testing.T) {
+	tests := []struct {
+		count        uint64
+		wantCapacity uint64
+	}{
+		{count: 0, wantCapacity: 1},
+		{count: 1, wantCapacity: 2},
+		{count: 3, wantCapacity: 4},
+		{count: 4, wantCapacity: 8},
+		{count: 6, wantCapacity: 8},
+		{count: 7, wantCapacity: 16},
+		{count: 12, wantCapacity: 16},
+		{count: 13, wantCapacity: 32},
+		{count: 3 << 20, wantCapacity: 4 << 20},
+		{count: 3<<20 + 1, wantCapacity: 8 << 20},
+	}
+	for _, tt := range tests {
+		gotCapacity := hashTableCapacity(tt.count)
+		require.Equal(t, tt.wantCapacity, gotCapacity, "count %d", tt.count)
+		// Assert the load factor is at most 3/4, and that there is always an empty slot.
+		require.LessOrEqual(t, tt.count*4, gotCapacity*3)
+		require.Less(t, tt.count, gotCapacity)
+	}
+}
+
+func TestHashTableCollisions(t *testing.T) {
+	// Find multihashes that all hash to the last slot of a table with capacity of 8, such that
+	// probing for them collides and wraps around to the start of the table.
+	const capacity = 8
+	var colliding, others []multihash.Multihash
+	for i := 0; len(colliding) < 3 || len(others) < 3; i++ {
+		mh, err := multihash.Sum([]byte{byte(i), byte(i >> 8)}, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		if hashMultihash(mh)&(capacity-1) == capacity-1 {
+			colliding = append(colliding, mh)
+		} else {
+			others = append(others, mh)
+		}
+	}
+	// Records are inserted in order of multihash; therefore, sort the colliding multihashes to know
+	// which one is stored first.
+	sort.Slice(colliding, func(i, j int) bool { return bytes.Compare(colliding[i], colliding[j]) < 0 })
+	records := []hashedRecord{
+		{mh: colliding[0], offset: 1},
+		{mh: colliding[1], offset: 2},
+		{mh: colliding[2], offset: 3},
+		{mh: colliding[0], offset: 4},
+		{mh: others[0], offset: 5},
+		{mh: others[1], offset: 6},
+	}
+	width := hashTableWidth(colliding[0])
+	subject, err := newHashTable(width, records)
+	require.NoError(t, err)
+	require.Equal(t, uint64(capacity), subject.capacity())
+	require.Equal(t, uint64(len(records)), subject.count)
+
+	// Assert the first colliding multihash is stored in the last slot, with the rest of colliding
+	// records wrapped around.
+	gotMh, gotOffset, occupied := subject.slot(capacity - 1)
+	require.True(t, occupied)
+	require.Equal(t, []byte(colliding[0]), gotMh)
+	require.Equal(t, uint64(1), gotOffset)
+
+	getAll := func(mh multihash.Multihash) []uint64 {
+		var offsets []uint64
+		subject.getAll(mh, func(offset uint64) bool {
+			offsets = append(offsets, offset)
+			return true
+		})
+		return offsets
+	}
+	require.Equal(t, []uint64{1, 4}, getAll(colliding[0]))
+	require.Equal(t, []uint64{2}, getAll(colliding[1]))
+	require.Equal(t, []uint64{3}, getAll(colliding[2]))
+	require.Equal(t, []uint64{5}, getAll(others[0]))
+	require.Equal(t, []uint64{6}, getAll(others[1]))
+	require.Empty(t, getAll(others[2]))
+}
@@ -3,7 +3,6 @@ package index
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	internalio "github.com/ipld/go-car/v2/internal/io"
 	"io"
@@ -15,7 +14,10 @@ import (
 	"github.com/multiformats/go-multihash"
 )
 
-var _ Index = (*multiWidthIndex)(nil)
+var (
+	_ Index          = (*multiWidthIndex)(nil)
+	_ CountableIndex = (*multiWidthIndex)(nil)
+)
 
 type (
 	digestRecord struct {
@@ -40,16 +42,21 @@ func (r recordSet) Len() int {
 	return len(r)
 }
 
+// Less orders records by digest, then by offset, such that records with duplicate digests are
+// ordered deterministically regardless of the order in which they are loaded.
 func (r recordSet) Less(i, j int) bool {
-	return bytes.Compare(r[i].digest, r[j].digest) < 0
+	if c := bytes.Compare(r[i].digest, r[j].digest); c != 0 {
+		return c < 0
+	}
+	return r[i].index < r[j].index
 }
 
 func (r recordSet) Swap(i, j int) {
 	r[i], r[j] = r[j], r[i]
 }
 
-func (s *singleWidthIndex) Marshal(w io.Writer) (uint64, error) {
-	l := uint64(0)
+func (s *singleWidthIndex) Marshal(w io.Writer) (int64, error) {
+	l := int64(0)
 	if err := binary.Write(w, binary.LittleEndian, s.width); err != nil {
 		return 0, err
 	}
@@ -59,7 +66,7 @@ func (s *singleWidthIndex) Marshal(w io.Writer) (uint64, error) {
 	}
 	l += 8
 	n, err := w.Write(s.index)
-	return l + uint64(n), err
+	return l + int64(n), err
 }
 
 func (s *singleWidthIndex) Unmarshal(r io.Reader) error {
@@ -82,8 +89,8 @@ func (s *singleWidthIndex) Unmarshal(r io.Reader) error {
 		return err
 	}
 
-	buf := make([]byte, dataLen)
-	if _, err := io.ReadFull(r, buf); err != nil {
+	buf, err := readDeclaredLength(r, dataLen)
+	if err != nil {
 		return err
 	}
 	s.index = buf
@@ -92,18 +99,21 @@ func (s *singleWidthIndex) Unmarshal(r io.Reader) error {
 
 func (s *singleWidthIndex) checkUnmarshalLengths(width uint32, dataLen, extra uint64) error {
 	if width < 8 {
-		return errors.New("malformed index; width must be at least 8")
+		return malformedIndexError("width must be at least 8")
 	}
 	const maxWidth = 32 << 20 // 32MiB, to ~match the go-cid maximum
 	if width > maxWidth {
-		return errors.New("index too big; singleWidthIndex width is larger than allowed maximum")
+		return malformedIndexError("singleWidthIndex width is larger than allowed maximum")
 	}
 	oldDataLen, dataLen := dataLen, dataLen+extra
 	if oldDataLen > dataLen {
-		return errors.New("index too big; singleWidthIndex len is overflowing")
+		return malformedIndexError("singleWidthIndex len is overflowing")
 	}
 	if int64(dataLen) < 0 {
-		return errors.New("index too big; singleWidthIndex len is overflowing int64")
+		return malformedIndexError("singleWidthIndex len is overflowing int64")
+	}
+	if dataLen%uint64(width) != 0 {
+		return malformedIndexError("data length %d is not a multiple of width %d", dataLen, width)
 	}
 	s.width = width
 	s.len = dataLen / uint64(width)
@@ -197,8 +207,8 @@ func (m *multiWidthIndex) Codec() multicodec.Code {
 	return multicodec.CarIndexSorted
 }
 
-func (m *multiWidthIndex) Marshal(w io.Writer) (uint64, error) {
-	l := uint64(0)
+func (m *multiWidthIndex) Marshal(w io.Writer) (int64, error) {
+	l := int64(0)
 	if err := binary.Write(w, binary.LittleEndian, int32(len(*m))); err != nil {
 		return l, err
 	}
@@ -227,20 +237,26 @@ func (m *multiWidthIndex) Marshal(w io.Writer) (uint64, error) {
 }
 
 func (m *multiWidthIndex) Unmarshal(r io.Reader) error {
-	reader := internalio.ToByteReadSeeker(r)
 	var l int32
-	if err := binary.Read(reader, binary.LittleEndian, &l); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
 		if err == io.EOF {
 			return io.ErrUnexpectedEOF
 		}
 		return err
 	}
+	return m.unmarshalBuckets(r, l)
+}
+
+// unmarshalBuckets reads l buckets from r, i.e. the remainder of the serial form of the index after
+// its bucket count.
+func (m *multiWidthIndex) unmarshalBuckets(r io.Reader, l int32) error {
+	reader := internalio.ToByteReadSeeker(r)
 	sum, err := reader.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return err
 	}
 	if int32(l) < 0 {
-		return errors.New("index too big; multiWidthIndex count is overflowing int32")
+		return malformedIndexError("multiWidthIndex count is overflowing int32")
 	}
 	for i := 0; i < int(l); i++ {
 		s := singleWidthIndex{}
@@ -254,7 +270,10 @@ func (m *multiWidthIndex) Unmarshal(r io.Reader) error {
 		oldSum := sum
 		sum += n
 		if sum < oldSum {
-			return errors.New("index too big; multiWidthIndex len is overflowing int64")
+			return malformedIndexError("multiWidthIndex len is overflowing int64")
+		}
+		if _, ok := (*m)[s.width]; ok {
+			return malformedIndexError("multiWidthIndex has duplicate buckets of width %d", s.width)
 		}
 		(*m)[s.width] = s
 	}
@@ -278,7 +297,13 @@ func (m *multiWidthIndex) Load(items []Record) error {
 		}
 		idxs[len(digest)] = append(idx, digestRecord{digest, item.Offset})
 	}
+	m.loadDigestRecords(idxs)
+	return nil
+}
 
+// loadDigestRecords sorts each group of digest records, keyed by digest length, and stores them in
+// compact form.
+func (m *multiWidthIndex) loadDigestRecords(idxs map[int][]digestRecord) {
 	// Sort each list. then write to compact form.
 	for width, lst := range idxs {
 		sort.Sort(recordSet(lst))
@@ -294,7 +319,6 @@ func (m *multiWidthIndex) Load(items []Record) error {
 		}
 		(*m)[uint32(width)+8] = s
 	}
-	return nil
 }
 
 func (m *multiWidthIndex) forEachDigest(f func(digest []byte, offset uint64) error) error {
@@ -312,6 +336,16 @@ func (m *multiWidthIndex) forEachDigest(f func(digest []byte, offset uint64) err
 	return nil
 }
 
+// Count returns the total number of records stored by this index, i.e. the sum of the number of
+// records of every width.
+func (m *multiWidthIndex) Count() (uint64, error) {
+	var count uint64
+	for _, s := range *m {
+		count += s.len
+	}
+	return count, nil
+}
+
 func newSorted() Index {
 	m := make(multiWidthIndex)
 	return &m
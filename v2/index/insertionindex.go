@@ -0,0 +1,949 @@
+package index
+
+import (
+	"bufio"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	cbor "github.com/whyrusleeping/cbor/go"
+)
+
+var (
+	_ IterableIndex  = (*InsertionIndex)(nil)
+	_ SizedIndex     = (*InsertionIndex)(nil)
+	_ CountableIndex = (*InsertionIndex)(nil)
+	_ OffsetIndex    = (*InsertionIndex)(nil)
+)
+
+var (
+	errUnsupported      = errors.New("not supported")
+	insertionIndexCodec = multicodec.Code(0x300003)
+)
+
+// insertionIndexBufferSize is the number of records inserted one at a time that an InsertionIndex
+// buffers before they become a run of their own.
+const insertionIndexBufferSize = 1 << 10
+
+// InsertionIndexMode specifies how an InsertionIndex trades memory for the speed of insertions.
+type InsertionIndexMode int
+
+const (
+	// InsertionIndexBalanced leaves spare capacity in the runs of records it merges, such that
+	// subsequent merges mostly happen in place, at the cost of holding up to a quarter more records'
+	// worth of memory than needed. This is the default mode.
+	InsertionIndexBalanced InsertionIndexMode = iota
+	// InsertionIndexCompact holds runs of records of exactly the capacity they need, at the cost of
+	// allocating and copying on every merge. It is intended for large ingests whose index is held in
+	// memory throughout, where the memory held matters more than insertion throughput.
+	InsertionIndexCompact
+)
+
+type (
+	// InsertionIndex is an index that is intended to be efficient for random-access, in-memory
+	// lookups and incremental insertion, e.g. while writing a CAR file. It is not intended to be an
+	// index type that is attached to a CARv2; see Flatten for conversion of this index to a known,
+	// existing index type.
+	//
+	// InsertionIndex records whole CIDs, along with their offset and section size. Lookups via Get,
+	// GetAll and GetSize match records by multihash digest, while HasExactCID matches whole CIDs.
+	// Records deleted via Delete or DeleteExact are removed altogether, such that they are neither
+	// matched by lookups nor included by Flatten.
+	//
+	// Records are kept in a log-structured manner: recently inserted records are buffered in a small
+	// sorted slice, which once full becomes a sorted run of records, and runs are merged such that
+	// there are at most logarithmically many of them. Records are therefore stored contiguously,
+	// without any per-record allocation beyond the CID itself, and are iterated over in ascending
+	// order of multihash digest, then in insertion order, by merging the runs.
+	//
+	// InsertionIndex is not safe for concurrent use, except for concurrent lookups. Wrap it via
+	// Synchronized in order to look it up while records are inserted via Load.
+	InsertionIndex struct {
+		// runs holds sorted runs of records, from the oldest to the newest, i.e. every record of a
+		// run was inserted before every record of the runs after it. Each run is sorted by digest,
+		// and records with equal digests are in insertion order. Runs are merged such that each is
+		// more than twice as long as the next one.
+		runs [][]insertionRecord
+		// buffer holds the most recently inserted records, sorted like a run, until it is full.
+		buffer []insertionRecord
+		len    int
+		mode   InsertionIndexMode
+		// layouts counts the records by the layout of their CID, such that the size of the index
+		// once flattened can be computed without iterating over its records; see FlattenedSize.
+		layouts map[recordLayout]int
+	}
+
+	// insertionRecord is a record along with the positions of its multihash and digest within the
+	// bytes of its CID, such that they can be compared without decoding the CID.
+	insertionRecord struct {
+		Record
+		mhAt, digestAt uint32
+	}
+
+	// recordLayout is the layout of the CID of a record, by which the records of an index are
+	// bucketed once flattened: its multihash code, and the lengths of the CID, its multihash and
+	// the digest of its multihash.
+	recordLayout struct {
+		code                     uint64
+		cidLen, mhLen, digestLen int
+	}
+)
+
+// newInsertionRecord returns the insertion record of the given record, or an error if the
+// multihash of its CID is invalid.
+func newInsertionRecord(r Record) (insertionRecord, error) {
+	mhAt, digestAt, err := cidLayout(r.Cid)
+	if err != nil {
+		return insertionRecord{}, err
+	}
+	return insertionRecord{Record: r, mhAt: mhAt, digestAt: digestAt}, nil
+}
+
+// cidLayout returns the positions of the multihash and of its digest within the bytes of c. Like
+// decoding the multihash of the CID, it checks that the multihash is valid, but it does not
+// allocate.
+func cidLayout(c cid.Cid) (mhAt uint32, digestAt uint32, err error) {
+	s := c.KeyString()
+	var at int
+	if c.Version() != 0 {
+		// Skip the CID version and codec, after which the multihash starts.
+		for i := 0; i < 2; i++ {
+			_, n, err := uvarintFromString(s[at:])
+			if err != nil {
+				return 0, 0, err
+			}
+			at += n
+		}
+	}
+	mhAt = uint32(at)
+	// Skip the multihash code, and check that the digest length matches the rest of the CID.
+	_, n, err := uvarintFromString(s[at:])
+	if err != nil {
+		return 0, 0, err
+	}
+	at += n
+	length, n, err := uvarintFromString(s[at:])
+	if err != nil {
+		return 0, 0, err
+	}
+	at += n
+	if length != uint64(len(s)-at) {
+		return 0, 0, fmt.Errorf("multihash digest length %d does not match the %d bytes remaining in CID", length, len(s)-at)
+	}
+	return mhAt, uint32(at), nil
+}
+
+func (r *insertionRecord) digest() string {
+	return r.Cid.KeyString()[r.digestAt:]
+}
+
+func (r *insertionRecord) multihash() string {
+	return r.Cid.KeyString()[r.mhAt:]
+}
+
+// multihashCode returns the multihash code of the CID of the record.
+func (r *insertionRecord) multihashCode() (uint64, error) {
+	code, _, err := uvarintFromString(r.multihash())
+	return code, err
+}
+
+// layout returns the layout of the CID of the record.
+func (r *insertionRecord) layout() recordLayout {
+	// The multihash is known to be valid, as checked by newInsertionRecord.
+	code, _ := r.multihashCode()
+	cidLen := len(r.Cid.KeyString())
+	return recordLayout{
+		code:      code,
+		cidLen:    cidLen,
+		mhLen:     cidLen - int(r.mhAt),
+		digestLen: cidLen - int(r.digestAt),
+	}
+}
+
+// NewInsertionIndex instantiates a new, empty InsertionIndex in the InsertionIndexBalanced mode.
+func NewInsertionIndex() *InsertionIndex {
+	return NewInsertionIndexWithMode(InsertionIndexBalanced)
+}
+
+// NewInsertionIndexWithMode instantiates a new, empty InsertionIndex in the given mode.
+func NewInsertionIndexWithMode(mode InsertionIndexMode) *InsertionIndex {
+	return &InsertionIndex{mode: mode}
+}
+
+// InsertionIndexFrom instantiates a new InsertionIndex populated with the records of the given
+// index, such that an index generated via car.GenerateIndex or read from a finalized CARv2 can be
+// added to without scanning the CAR payload again.
+//
+// Records are populated with as much information as the given index stores, as by Transcode. If it
+// stores whole CIDs, i.e. StoresWholeCIDs returns true, the records of the returned index retain
+// the original CIDs. Otherwise, since only their multihashes are known, records are inserted with
+// CIDs of codec cid.Raw, which only match the original CIDs by multihash; lookups via HasExactCID
+// therefore do not match the original CIDs. Sizes are retained if the given index stores them,
+// and are not known otherwise.
+func InsertionIndexFrom(idx IterableIndex) (*InsertionIndex, error) {
+	records, err := transcodeRecords(idx)
+	if err != nil {
+		return nil, err
+	}
+	ii := NewInsertionIndex()
+	if err := ii.Load(records); err != nil {
+		return nil, err
+	}
+	return ii, nil
+}
+
+// InsertNoReplace inserts a record of the section at offset n with the given CID, whose size is not
+// known. Records with the same CID are kept alongside each other.
+func (ii *InsertionIndex) InsertNoReplace(key cid.Cid, n uint64) {
+	ii.InsertSizedNoReplace(key, n, 0)
+}
+
+// InsertSizedNoReplace inserts a record of the section at offset n with the given CID, and the
+// given section length, i.e. the length of CID plus the length of block data.
+// See: Record.Size.
+func (ii *InsertionIndex) InsertSizedNoReplace(key cid.Cid, n uint64, size uint64) {
+	r, err := newInsertionRecord(Record{Cid: key, Offset: n, Size: size})
+	if err != nil {
+		panic(err)
+	}
+	ii.insert(r)
+}
+
+// insert inserts the given record into the buffer, after any records with the same digest.
+func (ii *InsertionIndex) insert(r insertionRecord) {
+	if len(ii.buffer) >= insertionIndexBufferSize {
+		ii.flushBuffer()
+	}
+	if ii.buffer == nil {
+		ii.buffer = make([]insertionRecord, 0, insertionIndexBufferSize)
+	}
+	d := r.digest()
+	i := sort.Search(len(ii.buffer), func(i int) bool {
+		return ii.buffer[i].digest() > d
+	})
+	ii.buffer = append(ii.buffer, insertionRecord{})
+	copy(ii.buffer[i+1:], ii.buffer[i:])
+	ii.buffer[i] = r
+	ii.len++
+	ii.countLayout(&r, 1)
+}
+
+// insertRun inserts the given records, which need not be sorted, as a run of their own.
+func (ii *InsertionIndex) insertRun(run []insertionRecord) {
+	if len(run) == 0 {
+		return
+	}
+	sort.SliceStable(run, func(i, j int) bool {
+		return run[i].digest() < run[j].digest()
+	})
+	// The buffered records were inserted before the run, and must therefore precede it.
+	ii.flushBuffer()
+	ii.pushRun(run)
+	ii.len += len(run)
+	for i := range run {
+		ii.countLayout(&run[i], 1)
+	}
+}
+
+// countLayout adds delta to the number of records with the same layout as r.
+func (ii *InsertionIndex) countLayout(r *insertionRecord, delta int) {
+	if ii.layouts == nil {
+		ii.layouts = make(map[recordLayout]int)
+	}
+	l := r.layout()
+	if n := ii.layouts[l] + delta; n > 0 {
+		ii.layouts[l] = n
+	} else {
+		delete(ii.layouts, l)
+	}
+}
+
+// flushBuffer turns the buffered records, if any, into the newest run.
+func (ii *InsertionIndex) flushBuffer() {
+	if len(ii.buffer) == 0 {
+		return
+	}
+	run := ii.buffer
+	if ii.mode == InsertionIndexCompact && len(run) < cap(run) {
+		// The buffer is flushed before it is full when a run is inserted; do not retain its spare
+		// capacity.
+		run = append([]insertionRecord(nil), run...)
+	}
+	ii.pushRun(run)
+	ii.buffer = nil
+}
+
+// pushRun appends the given sorted run as the newest run, and merges the newest runs until each
+// run is more than twice as long as the next one, such that there are logarithmically many runs.
+func (ii *InsertionIndex) pushRun(run []insertionRecord) {
+	ii.runs = append(ii.runs, run)
+	// Leave room for subsequent merges to happen in place, unless compact.
+	slack := ii.mode != InsertionIndexCompact
+	for n := len(ii.runs); n >= 2 && len(ii.runs[n-2]) <= 2*len(ii.runs[n-1]); n-- {
+		ii.runs[n-2] = mergeRuns(ii.runs[n-2], ii.runs[n-1], slack)
+		ii.runs[n-1] = nil
+		ii.runs = ii.runs[:n-1]
+	}
+}
+
+// mergeRuns merges the newer run b into the older run a, reusing the capacity of a if possible.
+// Records of a precede records of b with the same digest. If a needs to grow and slack is true, it
+// is grown with spare capacity.
+func mergeRuns(a, b []insertionRecord, slack bool) []insertionRecord {
+	total := len(a) + len(b)
+	if cap(a) < total {
+		size := total
+		if slack {
+			size += total / 4
+		}
+		grown := make([]insertionRecord, len(a), size)
+		copy(grown, a)
+		a = grown
+	}
+	// Merge from the back, such that records of a are not overwritten before they are moved.
+	i, j := len(a)-1, len(b)-1
+	a = a[:total]
+	for k := total - 1; j >= 0; k-- {
+		// Take from b on ties, since its records were inserted later.
+		if i >= 0 && a[i].digest() > b[j].digest() {
+			a[k] = a[i]
+			i--
+		} else {
+			a[k] = b[j]
+			j--
+		}
+	}
+	return a
+}
+
+// sources returns the runs and the buffer, from the oldest records to the newest.
+func (ii *InsertionIndex) sources() [][]insertionRecord {
+	if len(ii.buffer) == 0 {
+		return ii.runs
+	}
+	return append(ii.runs[:len(ii.runs):len(ii.runs)], ii.buffer)
+}
+
+// lookup calls f for every record with the given digest in insertion order, until f returns false.
+func (ii *InsertionIndex) lookup(digest string, f func(r *insertionRecord) bool) {
+	for _, run := range ii.sources() {
+		for i := searchDigest(run, digest); i < len(run) && run[i].digest() == digest; i++ {
+			if !f(&run[i]) {
+				return
+			}
+		}
+	}
+}
+
+// searchDigest returns the position of the first record of the given run with the given digest, or
+// of the first record with a greater digest if there is none.
+func searchDigest(run []insertionRecord, digest string) int {
+	return sort.Search(len(run), func(i int) bool {
+		return run[i].digest() >= digest
+	})
+}
+
+// digestOf returns the digest of the multihash of c.
+func digestOf(c cid.Cid) (string, error) {
+	_, digestAt, err := cidLayout(c)
+	if err != nil {
+		return "", err
+	}
+	return c.KeyString()[digestAt:], nil
+}
+
+// Delete deletes every record with exactly the given CID, and returns the number of records
+// deleted. Records with the same multihash digest as c but a different CID are kept, such that
+// lookups via Get and GetAll still match them, while HasExactCID no longer matches c.
+func (ii *InsertionIndex) Delete(c cid.Cid) int {
+	return ii.deleteMatching(c, func(r Record) bool {
+		return r.Cid == c
+	})
+}
+
+// DeleteExact deletes the record with exactly the given CID at offset n, and returns whether such
+// a record was present. Other records with the same CID or multihash digest, at other offsets,
+// are kept. Records inserted more than once with the same CID and offset are deleted altogether.
+func (ii *InsertionIndex) DeleteExact(c cid.Cid, n uint64) bool {
+	return ii.deleteMatching(c, func(r Record) bool {
+		return r.Cid == c && r.Offset == n
+	}) > 0
+}
+
+// deleteMatching deletes the records with the same multihash digest as c for which match returns
+// true, and returns the number of records deleted.
+func (ii *InsertionIndex) deleteMatching(c cid.Cid, match func(Record) bool) int {
+	digest, err := digestOf(c)
+	if err != nil {
+		return 0
+	}
+	deleteFrom := func(run []insertionRecord) ([]insertionRecord, int) {
+		start := searchDigest(run, digest)
+		end, kept := start, start
+		for ; end < len(run) && run[end].digest() == digest; end++ {
+			if !match(run[end].Record) {
+				run[kept] = run[end]
+				kept++
+			} else {
+				ii.countLayout(&run[end], -1)
+			}
+		}
+		if kept == end {
+			return run, 0
+		}
+		// Shift the remaining records, preserving their order, and clear the vacated ones so that
+		// their CIDs can be garbage collected.
+		n := copy(run[kept:], run[end:])
+		for i := kept + n; i < len(run); i++ {
+			run[i] = insertionRecord{}
+		}
+		return run[:kept+n], end - kept
+	}
+
+	var deleted int
+	runs := ii.runs[:0]
+	for _, run := range ii.runs {
+		run, n := deleteFrom(run)
+		deleted += n
+		if len(run) > 0 {
+			runs = append(runs, run)
+		}
+	}
+	for i := len(runs); i < len(ii.runs); i++ {
+		ii.runs[i] = nil
+	}
+	ii.runs = runs
+	var n int
+	ii.buffer, n = deleteFrom(ii.buffer)
+	deleted += n
+	ii.len -= deleted
+	return deleted
+}
+
+// Get returns the offset of the first block with the same multihash digest as c, in insertion
+// order. If no such block is indexed, ErrNotFound is returned.
+func (ii *InsertionIndex) Get(c cid.Cid) (uint64, error) {
+	digest, err := digestOf(c)
+	if err != nil {
+		return 0, err
+	}
+	var found *insertionRecord
+	ii.lookup(digest, func(r *insertionRecord) bool {
+		found = r
+		return false
+	})
+	if found == nil {
+		return 0, ErrNotFound
+	}
+	return found.Offset, nil
+}
+
+// GetAll calls fn for the offset of every block with the same multihash digest as c, in insertion
+// order.
+func (ii *InsertionIndex) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	digest, err := digestOf(c)
+	if err != nil {
+		return err
+	}
+	any := false
+	ii.lookup(digest, func(r *insertionRecord) bool {
+		any = true
+		return fn(r.Offset)
+	})
+	if !any {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetSize returns the size of the data of the first block with the same multihash as c, as
+// recorded when the block was inserted.
+func (ii *InsertionIndex) GetSize(c cid.Cid) (uint64, bool, error) {
+	mhAt, digestAt, err := cidLayout(c)
+	if err != nil {
+		return 0, false, err
+	}
+	mh := c.KeyString()[mhAt:]
+
+	var found *insertionRecord
+	ii.lookup(c.KeyString()[digestAt:], func(r *insertionRecord) bool {
+		if r.multihash() == mh {
+			found = r
+			return false
+		}
+		// Continue looking in insertion order.
+		return true
+	})
+	if found == nil {
+		return 0, false, ErrNotFound
+	}
+	cidLen := uint64(found.Cid.ByteLen())
+	if found.Size < cidLen {
+		// The size of the section is not known.
+		return 0, false, nil
+	}
+	return found.Size - cidLen, true, nil
+}
+
+func (ii *InsertionIndex) Marshal(w io.Writer) (int64, error) {
+	// Count the bytes written, since the CBOR encoder does not report them.
+	cw := &countingWriter{w: w}
+	if err := binary.Write(cw, binary.LittleEndian, int64(ii.len)); err != nil {
+		return cw.n, err
+	}
+
+	var err error
+	ii.forEach(func(r *insertionRecord) bool {
+		err = cbor.Encode(cw, marshaledRecord{Cid: r.Cid.Bytes(), Offset: r.Offset, Size: r.Size})
+		return err == nil
+	})
+	return cw.n, err
+}
+
+// marshaledRecord is the form in which a Record is encoded by InsertionIndex.Marshal. The CID is
+// encoded as its bytes, since cid.Cid has no exported fields for the encoder to pick up.
+type marshaledRecord struct {
+	Cid    []byte
+	Offset uint64
+	Size   uint64
+}
+
+func (ii *InsertionIndex) Unmarshal(r io.Reader) error {
+	var length int64
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if length < 0 {
+		return malformedIndexError("InsertionIndex len is overflowing int64")
+	}
+	d := cbor.NewDecoder(r)
+	var run []insertionRecord
+	for i := int64(0); i < length; i++ {
+		var mr marshaledRecord
+		if err := d.Decode(&mr); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		c, err := cid.Cast(mr.Cid)
+		if err != nil {
+			return malformedIndexError("InsertionIndex record %d has invalid CID: %v", i, err)
+		}
+		ir, err := newInsertionRecord(Record{Cid: c, Offset: mr.Offset, Size: mr.Size})
+		if err != nil {
+			return malformedIndexError("InsertionIndex record %d has invalid multihash: %v", i, err)
+		}
+		run = append(run, ir)
+	}
+	ii.insertRun(run)
+	return nil
+}
+
+// ForEach calls f for every multihash and its associated offset stored by this index, in ascending
+// order of multihash digest.
+func (ii *InsertionIndex) ForEach(f func(multihash.Multihash, uint64) error) error {
+	return ii.forEachRecord(func(r Record) error {
+		return f(r.Cid.Hash(), r.Offset)
+	})
+}
+
+// forEachRecord calls f for every record stored by this index, in the same order as ForEach.
+func (ii *InsertionIndex) forEachRecord(f func(Record) error) error {
+	var errr error
+	ii.forEach(func(r *insertionRecord) bool {
+		errr = f(r.Record)
+		return errr == nil
+	})
+	return errr
+}
+
+// forEach calls f for every record stored by this index, in ascending order of multihash digest and
+// then in insertion order, until f returns false. The runs are merged as they are iterated over.
+func (ii *InsertionIndex) forEach(f func(r *insertionRecord) bool) {
+	sources := ii.sources()
+	if len(sources) == 1 {
+		for i := range sources[0] {
+			if !f(&sources[0][i]) {
+				return
+			}
+		}
+		return
+	}
+	h := make(runHeap, 0, len(sources))
+	for i, run := range sources {
+		if len(run) > 0 {
+			h = append(h, runCursor{run: run, source: i})
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		c := &h[0]
+		if !f(&c.run[c.next]) {
+			return
+		}
+		if c.next++; c.next == len(c.run) {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+}
+
+type (
+	// runCursor is the position of the next record of a run to iterate over.
+	runCursor struct {
+		run    []insertionRecord
+		next   int
+		source int
+	}
+
+	// runHeap orders run cursors by the digest of their next record, then by the age of their run.
+	runHeap []runCursor
+)
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	di, dj := h[i].run[h[i].next].digest(), h[j].run[h[j].next].digest()
+	if di != dj {
+		return di < dj
+	}
+	return h[i].source < h[j].source
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(runCursor)) }
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+func (ii *InsertionIndex) Codec() multicodec.Code {
+	return insertionIndexCodec
+}
+
+func (ii *InsertionIndex) Load(rs []Record) error {
+	run := make([]insertionRecord, 0, len(rs))
+	for _, r := range rs {
+		ir, err := newInsertionRecord(r)
+		if err != nil {
+			return fmt.Errorf("invalid entry: %v", r)
+		}
+		run = append(run, ir)
+	}
+	ii.insertRun(run)
+	return nil
+}
+
+// FindByOffset returns the record of the section that contains the given offset; see OffsetIndex.
+// Since records are ordered by digest rather than offset, and may be inserted at any time, every
+// record is visited on each call, without holding any additional state.
+func (ii *InsertionIndex) FindByOffset(offset uint64) (Record, bool, error) {
+	var found *insertionRecord
+	for _, run := range ii.sources() {
+		for i := range run {
+			r := &run[i]
+			if r.Offset <= offset && (found == nil || r.Offset > found.Offset) {
+				found = r
+			}
+		}
+	}
+	if found == nil {
+		return Record{}, false, nil
+	}
+	return found.Record, sectionContains(found.Record, offset), nil
+}
+
+// ForEachOffsetOrder calls f with every record of this index in ascending order of offset; see
+// OffsetIndex. Since records may be inserted at any time, the ordering is computed again on each
+// call, holding a copy of every record in memory while iterating.
+func (ii *InsertionIndex) ForEachOffsetOrder(f func(Record) error) error {
+	records, err := OffsetOrdered(ii)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of records in this index.
+func (ii *InsertionIndex) Len() int {
+	return ii.len
+}
+
+// Count returns the number of records in this index, i.e. Len.
+func (ii *InsertionIndex) Count() (uint64, error) {
+	return uint64(ii.len), nil
+}
+
+// Flatten returns a formatted index in the given codec for more efficient subsequent loading.
+func (ii *InsertionIndex) Flatten(codec multicodec.Code) (Index, error) {
+	si, err := New(codec)
+	if err != nil {
+		return nil, err
+	}
+	rcrds := make([]Record, 0, ii.len)
+	ii.forEach(func(r *insertionRecord) bool {
+		rcrds = append(rcrds, r.Record)
+		return true
+	})
+	if err := si.Load(rcrds); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// WriteFlattenedTo writes this index to w in the given codec, in the same format as
+// WriteTo(Flatten(codec), w), and returns the number of bytes written.
+//
+// For the multicodec.CarMultihashIndexSorted codec, the records are written as they are iterated
+// over via a MultihashIndexSortedWriter, without materializing a flattened copy of the index in
+// memory. To do so, the records are iterated over once to count the records of each bucket, then
+// once per bucket, i.e. once per combination of multihash code and digest length, which is once for
+// a typical CAR with a single hash function. Other codecs are flattened first.
+func (ii *InsertionIndex) WriteFlattenedTo(w io.Writer, codec multicodec.Code) (int64, error) {
+	if codec != multicodec.CarMultihashIndexSorted {
+		fi, err := ii.Flatten(codec)
+		if err != nil {
+			return 0, err
+		}
+		return WriteTo(fi, w)
+	}
+	counts, err := ii.multihashBucketCounts()
+	if err != nil {
+		return 0, err
+	}
+	return ii.writeMultihashSorted(w, counts)
+}
+
+// WriteFlattenedToWithChecksum is similar to WriteFlattenedTo, except that the index is written in
+// the same format as WriteToWithChecksum(Flatten(codec), w).
+//
+// For the multicodec.CarMultihashIndexSorted codec, the records are iterated over twice as many
+// times as WriteFlattenedTo, since the checksum is computed over the index before it is written.
+func (ii *InsertionIndex) WriteFlattenedToWithChecksum(w io.Writer, codec multicodec.Code) (int64, error) {
+	if codec != multicodec.CarMultihashIndexSorted {
+		fi, err := ii.Flatten(codec)
+		if err != nil {
+			return 0, err
+		}
+		return WriteToWithChecksum(fi, w)
+	}
+	counts, err := ii.multihashBucketCounts()
+	if err != nil {
+		return 0, err
+	}
+	hasher := sha256.New()
+	if _, err := ii.writeMultihashSorted(hasher, counts); err != nil {
+		return 0, err
+	}
+	written, err := writeChecksumHeader(w, counts.marshaledSize(), hasher.Sum(nil))
+	if err != nil {
+		return written, err
+	}
+	n, err := ii.writeMultihashSorted(w, counts)
+	return written + n, err
+}
+
+// FlattenedSize returns the number of bytes that WriteFlattenedTo writes for this index in the
+// given codec, i.e. the size of the index as written by WriteTo(Flatten(codec), w).
+//
+// For the codecs defined by this package, the size is computed from the number of records of each
+// bucket of the codec, which are counted as records are inserted and deleted, without iterating
+// over the records. Indices of other codecs are flattened and written to io.Discard in order to
+// count their bytes.
+func (ii *InsertionIndex) FlattenedSize(codec multicodec.Code) (uint64, error) {
+	size := uint64(varint.UvarintSize(uint64(codec)))
+	switch codec {
+	case multicodec.CarMultihashIndexSorted:
+		counts := make(MultihashBucketCounts)
+		for l, n := range ii.layouts {
+			if counts[l.code] == nil {
+				counts[l.code] = make(map[int]uint64)
+			}
+			counts[l.code][l.digestLen] += uint64(n)
+		}
+		return counts.marshaledSize(), nil
+	case multicodec.CarIndexSorted:
+		return size + ii.sortedSize(func(l recordLayout) int { return l.digestLen + 8 }), nil
+	case CarCidIndexSorted:
+		// The buckets are preceded by a marker and the record count; see countedHeaderMarker.
+		return size + 12 + ii.sortedSize(func(l recordLayout) int { return l.cidLen + 8 }), nil
+	case CarMultihashSizedIndexSorted:
+		return size + 12 + ii.sortedSize(func(l recordLayout) int { return l.mhLen + 16 }), nil
+	case CarMultihashIndexHashed:
+		size += 4
+		for width, n := range ii.countByWidth(func(l recordLayout) int { return l.mhLen + 8 }) {
+			size += 4 + 8 + 8 + hashTableCapacity(n)*uint64(width)
+		}
+		return size, nil
+	default:
+		fi, err := ii.Flatten(codec)
+		if err != nil {
+			return 0, err
+		}
+		n, err := WriteTo(fi, io.Discard)
+		return uint64(n), err
+	}
+}
+
+// FlattenedSizeWithChecksum is similar to FlattenedSize, except that it returns the number of
+// bytes that WriteFlattenedToWithChecksum writes.
+func (ii *InsertionIndex) FlattenedSizeWithChecksum(codec multicodec.Code) (uint64, error) {
+	size, err := ii.FlattenedSize(codec)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(varint.UvarintSize(uint64(CarIndexChecksummed))) + 8 + checksumSize + size, nil
+}
+
+// sortedSize returns the marshaled size of a sorted index whose records are bucketed by the given
+// width, where each bucket is written as its width and length, followed by its records.
+func (ii *InsertionIndex) sortedSize(width func(recordLayout) int) uint64 {
+	size := uint64(4)
+	for w, n := range ii.countByWidth(width) {
+		size += 4 + 8 + n*uint64(w)
+	}
+	return size
+}
+
+// countByWidth counts the records of this index by the given width of their layout.
+func (ii *InsertionIndex) countByWidth(width func(recordLayout) int) map[int]uint64 {
+	counts := make(map[int]uint64)
+	for l, n := range ii.layouts {
+		counts[width(l)] += uint64(n)
+	}
+	return counts
+}
+
+// multihashBucketCounts counts the records of this index by multihash code and digest length.
+func (ii *InsertionIndex) multihashBucketCounts() (MultihashBucketCounts, error) {
+	counts := make(MultihashBucketCounts)
+	var errr error
+	ii.forEach(func(r *insertionRecord) bool {
+		code, err := r.multihashCode()
+		if err != nil {
+			errr = err
+			return false
+		}
+		counts.add(code, len(r.digest()))
+		return true
+	})
+	return counts, errr
+}
+
+// writeMultihashSorted writes this index to w in the multicodec.CarMultihashIndexSorted codec, by
+// iterating over its records once per bucket of the given counts.
+func (ii *InsertionIndex) writeMultihashSorted(w io.Writer, counts MultihashBucketCounts) (int64, error) {
+	// Buffer writes, since each record is written with two small writes.
+	bw := bufio.NewWriterSize(w, 64<<10)
+	sw, err := NewMultihashIndexSortedWriter(bw, counts)
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range sw.buckets {
+		// Records with duplicate digests are iterated over in insertion order; buffer their offsets
+		// to write them in ascending order, as Flatten does.
+		var digest string
+		var offsets []uint64
+		flush := func() error {
+			sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+			for _, offset := range offsets {
+				if err := sw.write(b.code, digest, offset); err != nil {
+					return err
+				}
+			}
+			offsets = offsets[:0]
+			return nil
+		}
+		var errr error
+		ii.forEach(func(r *insertionRecord) bool {
+			d := r.digest()
+			if len(d) != b.digestLen {
+				return true
+			}
+			code, err := r.multihashCode()
+			if err != nil {
+				errr = err
+				return false
+			}
+			if code != b.code {
+				return true
+			}
+			if d != digest {
+				if errr = flush(); errr != nil {
+					return false
+				}
+				digest = d
+			}
+			offsets = append(offsets, r.Offset)
+			return true
+		})
+		if errr == nil {
+			errr = flush()
+		}
+		if errr != nil {
+			return 0, errr
+		}
+	}
+	if err := sw.Close(); err != nil {
+		return 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(counts.marshaledSize()), nil
+}
+
+// uvarintFromString decodes the uvarint at the start of s, returning its value and length.
+func uvarintFromString(s string) (uint64, int, error) {
+	var x uint64
+	var shift uint
+	for i := 0; i < len(s) && i < binary.MaxVarintLen64; i++ {
+		b := s[i]
+		if b < 0x80 {
+			return x | uint64(b)<<shift, i + 1, nil
+		}
+		x |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+	return 0, 0, errors.New("invalid uvarint in CID")
+}
+
+// HasExactCID returns true if a record with the exact given CID is present in this index.
+//
+// Note that HasExactCID is very similar to GetAll, but it's separate as it allows comparing
+// Record.Cid directly, whereas GetAll just provides Record.Offset.
+func (ii *InsertionIndex) HasExactCID(c cid.Cid) bool {
+	digest, err := digestOf(c)
+	if err != nil {
+		return false
+	}
+	found := false
+	ii.lookup(digest, func(r *insertionRecord) bool {
+		// Stop once an exact match is found.
+		found = r.Cid == c
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,900 @@
+package index_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/petar/GoLLRB/llrb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertionIndex_GetAndHasExactCID(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewInsertionIndex()
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	for _, r := range records {
+		subject.InsertNoReplace(r.Cid, r.Offset)
+	}
+	require.Equal(t, len(records), subject.Len())
+	requireContainsAll(t, subject, records)
+
+	for _, r := range records {
+		got, err := subject.Get(r.Cid)
+		require.NoError(t, err)
+		require.Equal(t, r.Offset, got)
+		require.True(t, subject.HasExactCID(r.Cid))
+
+		// Assert a CID with the same multihash but different codec is found by multihash only.
+		other := cid.NewCidV1(cid.DagCBOR, r.Cid.Hash())
+		got, err = subject.Get(other)
+		require.NoError(t, err)
+		require.Equal(t, r.Offset, got)
+		require.False(t, subject.HasExactCID(other))
+	}
+
+	absent := generateCidV1(t, multihash.SHA2_256, rng)
+	_, err := subject.Get(absent)
+	require.Equal(t, index.ErrNotFound, err)
+	require.False(t, subject.HasExactCID(absent))
+}
+
+func TestInsertionIndex_InsertNoReplaceKeepsDuplicates(t *testing.T) {
+	c := generateCidV1(t, multihash.SHA2_256, rand.New(rand.NewSource(1413)))
+	subject := index.NewInsertionIndex()
+	subject.InsertSizedNoReplace(c, 1, 42)
+	subject.InsertNoReplace(c, 2)
+
+	var got []uint64
+	require.NoError(t, subject.GetAll(c, func(o uint64) bool {
+		got = append(got, o)
+		return true
+	}))
+	require.ElementsMatch(t, []uint64{1, 2}, got)
+}
+
+func TestInsertionIndex_Flatten(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	subject := index.NewInsertionIndex()
+	for _, r := range records {
+		subject.InsertNoReplace(r.Cid, r.Offset)
+	}
+
+	for _, codec := range []multicodec.Code{multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, index.CarCidIndexSorted} {
+		t.Run(codec.String(), func(t *testing.T) {
+			got, err := subject.Flatten(codec)
+			require.NoError(t, err)
+			require.Equal(t, codec, got.Codec())
+			requireContainsAll(t, got, records)
+		})
+	}
+
+	_, err := subject.Flatten(multicodec.Cidv1)
+	require.Error(t, err)
+}
+
+func TestInsertionIndex_MarshalRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	subject := index.NewInsertionIndex()
+	for _, r := range records {
+		subject.InsertSizedNoReplace(r.Cid, r.Offset, uint64(r.Cid.ByteLen())+7)
+	}
+	var buf bytes.Buffer
+	n, err := subject.Marshal(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+
+	got := index.NewInsertionIndex()
+	require.NoError(t, got.Unmarshal(&buf))
+	require.Equal(t, subject.Len(), got.Len())
+	requireContainsAll(t, got, records)
+	for _, r := range records {
+		require.True(t, got.HasExactCID(r.Cid))
+		size, known, err := got.GetSize(r.Cid)
+		require.NoError(t, err)
+		require.True(t, known)
+		require.Equal(t, uint64(7), size)
+	}
+}
+
+func TestInsertionIndexFrom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	for i := range records {
+		// Use a codec other than cid.Raw to distinguish original CIDs from placeholder ones.
+		records[i].Cid = cid.NewCidV1(cid.DagCBOR, records[i].Cid.Hash())
+	}
+
+	t.Run("CidIndexSorted", func(t *testing.T) {
+		existing, err := index.New(index.CarCidIndexSorted)
+		require.NoError(t, err)
+		require.NoError(t, existing.Load(records))
+
+		require.True(t, index.StoresWholeCIDs(existing))
+		subject, err := index.InsertionIndexFrom(existing.(index.IterableIndex))
+		require.NoError(t, err)
+		require.True(t, index.StoresWholeCIDs(subject))
+		require.Equal(t, len(records), subject.Len())
+		requireContainsAll(t, subject, records)
+		for _, r := range records {
+			require.True(t, subject.HasExactCID(r.Cid))
+		}
+	})
+
+	t.Run("MultihashSizedIndexSorted", func(t *testing.T) {
+		sized := make([]index.Record, len(records))
+		for i, r := range records {
+			r.Size = uint64(r.Cid.ByteLen() + i + 1)
+			sized[i] = r
+		}
+		existing, err := index.New(index.CarMultihashSizedIndexSorted)
+		require.NoError(t, err)
+		require.NoError(t, existing.Load(sized))
+
+		require.False(t, index.StoresWholeCIDs(existing))
+		subject, err := index.InsertionIndexFrom(existing.(index.IterableIndex))
+		require.NoError(t, err)
+		requireContainsAll(t, subject, sized)
+		for i, r := range sized {
+			// Sizes exclude the CID, which is the same length as the placeholder one.
+			size, known, err := subject.GetSize(r.Cid)
+			require.NoError(t, err)
+			require.True(t, known)
+			require.Equal(t, uint64(i+1), size)
+		}
+	})
+
+	t.Run("MultihashIndexSorted", func(t *testing.T) {
+		existing, err := index.New(multicodec.CarMultihashIndexSorted)
+		require.NoError(t, err)
+		require.NoError(t, existing.Load(records))
+
+		require.False(t, index.StoresWholeCIDs(existing))
+		subject, err := index.InsertionIndexFrom(existing.(index.IterableIndex))
+		require.NoError(t, err)
+		require.Equal(t, len(records), subject.Len())
+		requireContainsAll(t, subject, records)
+		for _, r := range records {
+			// Only the multihash of records is known.
+			require.False(t, subject.HasExactCID(r.Cid))
+			require.True(t, subject.HasExactCID(cid.NewCidV1(cid.Raw, r.Cid.Hash())))
+		}
+	})
+}
+
+func TestInsertionIndex_WriteFlattenedTo(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	var records []index.Record
+	for _, code := range []uint64{multihash.SHA2_256, multihash.SHA2_512, multihash.BLAKE2B_MIN + 31} {
+		records = append(records, generateIndexRecords(t, code, rng)...)
+	}
+	// Include CIDv0s, whose multihash code is not preceded by a CID version and codec.
+	for _, r := range generateIndexRecords(t, multihash.SHA2_256, rng) {
+		records = append(records, index.Record{Cid: cid.NewCidV0(r.Cid.Hash()), Offset: r.Offset})
+	}
+	subject := index.NewInsertionIndex()
+	require.NoError(t, subject.Load(records))
+
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, multicodec.CarIndexSorted, index.CarCidIndexSorted} {
+		t.Run(codec.String(), func(t *testing.T) {
+			flattened, err := subject.Flatten(codec)
+			require.NoError(t, err)
+
+			var want, got bytes.Buffer
+			_, err = index.WriteTo(flattened, &want)
+			require.NoError(t, err)
+			n, err := subject.WriteFlattenedTo(&got, codec)
+			require.NoError(t, err)
+			require.Equal(t, int64(got.Len()), n)
+			require.Equal(t, want.Bytes(), got.Bytes())
+
+			want.Reset()
+			got.Reset()
+			_, err = index.WriteToWithChecksum(flattened, &want)
+			require.NoError(t, err)
+			n, err = subject.WriteFlattenedToWithChecksum(&got, codec)
+			require.NoError(t, err)
+			require.Equal(t, int64(got.Len()), n)
+			require.Equal(t, want.Bytes(), got.Bytes())
+		})
+	}
+}
+
+func TestInsertionIndex_FlattenedSize(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	var records []index.Record
+	for _, code := range []uint64{multihash.SHA2_256, multihash.SHA2_512, multihash.BLAKE2B_MIN + 31} {
+		records = append(records, generateIndexRecords(t, code, rng)...)
+	}
+	for _, r := range generateIndexRecords(t, multihash.SHA2_256, rng) {
+		records = append(records, index.Record{Cid: cid.NewCidV0(r.Cid.Hash()), Offset: r.Offset})
+	}
+	subject := index.NewInsertionIndex()
+	empty := index.NewInsertionIndex()
+	require.NoError(t, subject.Load(records[:len(records)/2]))
+	for _, r := range records[len(records)/2:] {
+		subject.InsertNoReplace(r.Cid, r.Offset)
+	}
+	// Delete every record of a whole bucket, along with some records of other buckets, such that
+	// emptied buckets are no longer counted.
+	for i, r := range records {
+		if r.Cid.Prefix().MhType == multihash.SHA2_512 || i%7 == 0 {
+			subject.Delete(r.Cid)
+		}
+	}
+
+	for _, codec := range []multicodec.Code{
+		multicodec.CarMultihashIndexSorted,
+		multicodec.CarIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	} {
+		t.Run(codec.String(), func(t *testing.T) {
+			for _, ii := range []*index.InsertionIndex{subject, empty} {
+				var buf bytes.Buffer
+				_, err := ii.WriteFlattenedTo(&buf, codec)
+				require.NoError(t, err)
+				size, err := ii.FlattenedSize(codec)
+				require.NoError(t, err)
+				require.Equal(t, uint64(buf.Len()), size)
+
+				buf.Reset()
+				_, err = ii.WriteFlattenedToWithChecksum(&buf, codec)
+				require.NoError(t, err)
+				size, err = ii.FlattenedSizeWithChecksum(codec)
+				require.NoError(t, err)
+				require.Equal(t, uint64(buf.Len()), size)
+			}
+		})
+	}
+
+	_, err := subject.FlattenedSize(multicodec.Identity)
+	require.Error(t, err)
+}
+
+func TestInsertionIndex_WriteFlattenedToAllocatesLessThanFlatten(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewInsertionIndex()
+	for i := 0; i < 1<<17; i++ {
+		subject.InsertNoReplace(generateCidV1(t, multihash.SHA2_256, rng), rng.Uint64())
+	}
+
+	flattenAlloc := totalAlloc(t, func() {
+		flattened, err := subject.Flatten(multicodec.CarMultihashIndexSorted)
+		require.NoError(t, err)
+		_, err = index.WriteTo(flattened, io.Discard)
+		require.NoError(t, err)
+	})
+	streamAlloc := totalAlloc(t, func() {
+		_, err := subject.WriteFlattenedTo(io.Discard, multicodec.CarMultihashIndexSorted)
+		require.NoError(t, err)
+	})
+	t.Logf("allocated %d bytes to flatten and %d bytes to stream", flattenAlloc, streamAlloc)
+	require.Less(t, streamAlloc*10, flattenAlloc)
+}
+
+func TestMultihashIndexSortedWriter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	counts := make(index.MultihashBucketCounts)
+	for _, r := range records {
+		require.NoError(t, counts.Add(r.Cid.Hash()))
+	}
+	want := marshalIndex(t, newLoadedIndex(t, multicodec.CarMultihashIndexSorted, records, false))
+
+	// Write the records in the sorted order of the index itself.
+	var sorted []index.Record
+	require.NoError(t, newLoadedIndex(t, multicodec.CarMultihashIndexSorted, records, false).(index.IterableIndex).ForEach(
+		func(mh multihash.Multihash, offset uint64) error {
+			sorted = append(sorted, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset})
+			return nil
+		}))
+	var got bytes.Buffer
+	subject, err := index.NewMultihashIndexSortedWriter(&got, counts)
+	require.NoError(t, err)
+	for _, r := range sorted {
+		require.NoError(t, subject.WriteRecord(r))
+	}
+	require.NoError(t, subject.Close())
+	require.Equal(t, want, got.Bytes())
+
+	// Assert records out of order, or that do not match the counts, are errors.
+	subject, err = index.NewMultihashIndexSortedWriter(io.Discard, counts)
+	require.NoError(t, err)
+	require.Error(t, subject.WriteRecord(sorted[len(sorted)-1]))
+
+	subject, err = index.NewMultihashIndexSortedWriter(io.Discard, counts)
+	require.NoError(t, err)
+	require.NoError(t, subject.WriteRecord(sorted[1]))
+	require.Error(t, subject.WriteRecord(sorted[0]))
+
+	subject, err = index.NewMultihashIndexSortedWriter(io.Discard, counts)
+	require.NoError(t, err)
+	for _, r := range sorted[:len(sorted)-1] {
+		require.NoError(t, subject.WriteRecord(r))
+	}
+	require.Error(t, subject.Close())
+	require.NoError(t, subject.WriteRecord(sorted[len(sorted)-1]))
+	require.NoError(t, subject.Close())
+	require.Error(t, subject.WriteRecord(sorted[len(sorted)-1]))
+}
+
+// BenchmarkInsertionIndex_WriteIndex compares the memory allocated to write an insertion index
+// with millions of records by flattening it first, as opposed to streaming it.
+func BenchmarkInsertionIndex_WriteIndex(b *testing.B) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewInsertionIndex()
+	digest := make([]byte, 32)
+	for i := 0; i < 2_000_000; i++ {
+		rng.Read(digest)
+		mh, err := multihash.Encode(digest, multihash.SHA2_256)
+		require.NoError(b, err)
+		subject.InsertNoReplace(cid.NewCidV1(cid.Raw, mh), rng.Uint64())
+	}
+
+	b.Run("Flatten", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			flattened, err := subject.Flatten(multicodec.CarMultihashIndexSorted)
+			require.NoError(b, err)
+			_, err = index.WriteTo(flattened, io.Discard)
+			require.NoError(b, err)
+		}
+	})
+	b.Run("WriteFlattenedTo", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := subject.WriteFlattenedTo(io.Discard, multicodec.CarMultihashIndexSorted)
+			require.NoError(b, err)
+		}
+	})
+}
+
+// totalAlloc returns the number of bytes allocated on the heap while calling f.
+func totalAlloc(t *testing.T, f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestInsertionIndex_DeleteAgreesWithReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+
+	// Use a small pool of keys, such that most of them are inserted more than once, and include CIDs
+	// of different codecs with the same multihash, which lookups by multihash cannot tell apart.
+	var keys []cid.Cid
+	for i := 0; i < 16; i++ {
+		c := generateCidV1(t, multihash.SHA2_256, rng)
+		keys = append(keys, c)
+		if i%4 == 0 {
+			keys = append(keys, cid.NewCidV1(cid.DagCBOR, c.Hash()))
+		}
+	}
+
+	subject := index.NewInsertionIndex()
+	var reference []index.Record
+	deleteFromReference := func(match func(index.Record) bool) int {
+		var deleted int
+		kept := reference[:0]
+		for _, r := range reference {
+			if match(r) {
+				deleted++
+			} else {
+				kept = append(kept, r)
+			}
+		}
+		reference = kept
+		return deleted
+	}
+
+	for i := 0; i < 2000; i++ {
+		key := keys[rng.Intn(len(keys))]
+		switch op := rng.Intn(10); {
+		case op < 6:
+			offset := uint64(rng.Intn(64))
+			subject.InsertNoReplace(key, offset)
+			reference = append(reference, index.Record{Cid: key, Offset: offset})
+		case op < 8 && len(reference) > 0:
+			// Delete an existing record, or one at an offset that is most likely absent.
+			r := reference[rng.Intn(len(reference))]
+			if rng.Intn(4) == 0 {
+				r.Offset = uint64(rng.Intn(64))
+			}
+			want := deleteFromReference(func(got index.Record) bool {
+				return got.Cid == r.Cid && got.Offset == r.Offset
+			})
+			require.Equal(t, want > 0, subject.DeleteExact(r.Cid, r.Offset))
+		default:
+			want := deleteFromReference(func(got index.Record) bool {
+				return got.Cid == key
+			})
+			require.Equal(t, want, subject.Delete(key))
+		}
+
+		if i%50 == 0 {
+			requireInsertionIndexMatches(t, subject, keys, reference)
+		}
+	}
+	requireInsertionIndexMatches(t, subject, keys, reference)
+
+	// Assert deleting everything leaves an empty index.
+	for _, key := range keys {
+		subject.Delete(key)
+	}
+	requireInsertionIndexMatches(t, subject, keys, nil)
+}
+
+func requireInsertionIndexMatches(t *testing.T, subject *index.InsertionIndex, keys []cid.Cid, reference []index.Record) {
+	require.Equal(t, len(reference), subject.Len())
+
+	for _, key := range keys {
+		var wantOffsets []uint64
+		var wantExact bool
+		for _, r := range reference {
+			if bytes.Equal(r.Cid.Hash(), key.Hash()) {
+				wantOffsets = append(wantOffsets, r.Offset)
+			}
+			wantExact = wantExact || r.Cid == key
+		}
+		require.Equal(t, wantExact, subject.HasExactCID(key))
+
+		var gotOffsets []uint64
+		err := subject.GetAll(key, func(o uint64) bool {
+			gotOffsets = append(gotOffsets, o)
+			return true
+		})
+		got, getErr := subject.Get(key)
+		if len(wantOffsets) == 0 {
+			require.Equal(t, index.ErrNotFound, err)
+			require.Equal(t, index.ErrNotFound, getErr)
+			continue
+		}
+		require.NoError(t, err)
+		require.ElementsMatch(t, wantOffsets, gotOffsets)
+		require.NoError(t, getErr)
+		require.Contains(t, wantOffsets, got)
+	}
+
+	// Assert the flattened index is identical to one loaded with the reference records only.
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, index.CarCidIndexSorted} {
+		flattened, err := subject.Flatten(codec)
+		require.NoError(t, err)
+		want, err := index.New(codec)
+		require.NoError(t, err)
+		require.NoError(t, want.Load(reference))
+		require.Equal(t, marshalIndex(t, want), marshalIndex(t, flattened))
+	}
+}
+
+// llrbInsertionIndex is the implementation of InsertionIndex prior to it keeping records in sorted
+// runs, backed by a left-leaning red-black tree of records ordered by digest. It is used as a
+// reference to cross-check the current implementation against, and to compare their performance.
+type llrbInsertionIndex struct {
+	items llrb.LLRB
+	// seq is the sequence number of the last record inserted.
+	seq uint64
+}
+
+// llrbRecord is a record in an llrbInsertionIndex. Records are ordered by digest, then by sequence
+// number, i.e. in insertion order. The order is strict, since the tree does not support deleting
+// items that compare equal. Keys used for lookups have a zero sequence number, such that they
+// precede every record with the same digest.
+type llrbRecord struct {
+	digest []byte
+	seq    uint64
+	index.Record
+}
+
+func (r llrbRecord) Less(than llrb.Item) bool {
+	other := than.(llrbRecord)
+	if c := bytes.Compare(r.digest, other.digest); c != 0 {
+		return c < 0
+	}
+	return r.seq < other.seq
+}
+
+func newLlrbRecord(r index.Record) llrbRecord {
+	d, err := multihash.Decode(r.Cid.Hash())
+	if err != nil {
+		panic(err)
+	}
+	return llrbRecord{digest: d.Digest, Record: r}
+}
+
+func (l *llrbInsertionIndex) insert(r index.Record) {
+	rec := newLlrbRecord(r)
+	l.seq++
+	rec.seq = l.seq
+	l.items.InsertNoReplace(rec)
+}
+
+func (l *llrbInsertionIndex) InsertNoReplace(c cid.Cid, n uint64) {
+	l.InsertSizedNoReplace(c, n, 0)
+}
+
+func (l *llrbInsertionIndex) InsertSizedNoReplace(c cid.Cid, n uint64, size uint64) {
+	l.insert(index.Record{Cid: c, Offset: n, Size: size})
+}
+
+func (l *llrbInsertionIndex) Load(rs []index.Record) error {
+	for _, r := range rs {
+		l.insert(r)
+	}
+	return nil
+}
+
+func (l *llrbInsertionIndex) Get(c cid.Cid) (uint64, error) {
+	matching := l.matching(c)
+	if len(matching) == 0 {
+		return 0, index.ErrNotFound
+	}
+	return matching[0].Offset, nil
+}
+
+// matching returns the records with the same digest as c, in ascending order.
+func (l *llrbInsertionIndex) matching(c cid.Cid) []index.Record {
+	var records []index.Record
+	for _, r := range l.matchingItems(c) {
+		records = append(records, r.Record)
+	}
+	return records
+}
+
+// matchingItems returns the items with the same digest as c, in ascending order.
+func (l *llrbInsertionIndex) matchingItems(c cid.Cid) []llrbRecord {
+	key := newLlrbRecord(index.Record{Cid: c})
+	var items []llrbRecord
+	l.items.AscendGreaterOrEqual(key, func(i llrb.Item) bool {
+		r := i.(llrbRecord)
+		if !bytes.Equal(r.digest, key.digest) {
+			return false
+		}
+		items = append(items, r)
+		return true
+	})
+	return items
+}
+
+// records returns every record, in ascending order.
+func (l *llrbInsertionIndex) records() []index.Record {
+	var records []index.Record
+	l.items.AscendGreaterOrEqual(l.items.Min(), func(i llrb.Item) bool {
+		records = append(records, i.(llrbRecord).Record)
+		return true
+	})
+	return records
+}
+
+func (l *llrbInsertionIndex) deleteMatching(c cid.Cid, match func(index.Record) bool) int {
+	var deleted int
+	for _, r := range l.matchingItems(c) {
+		if match(r.Record) {
+			if l.items.Delete(r) == nil {
+				panic("matching record not found")
+			}
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (l *llrbInsertionIndex) Flatten(codec multicodec.Code) (index.Index, error) {
+	flattened, err := index.New(codec)
+	if err != nil {
+		return nil, err
+	}
+	if err := flattened.Load(l.records()); err != nil {
+		return nil, err
+	}
+	return flattened, nil
+}
+
+func TestInsertionIndex_AgreesWithLlrbInsertionIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+
+	// Use a pool of keys small enough for most of them to be inserted more than once, and include
+	// CIDs of different codecs and versions with the same multihash, as well as multihashes of
+	// different codes and digest lengths.
+	var keys []cid.Cid
+	for i := 0; i < 256; i++ {
+		code := uint64(multihash.SHA2_256)
+		if i%8 == 0 {
+			code = multihash.SHA2_512
+		}
+		c := generateCidV1(t, code, rng)
+		keys = append(keys, c)
+		switch i % 16 {
+		case 1:
+			keys = append(keys, cid.NewCidV1(cid.DagCBOR, c.Hash()))
+		case 2:
+			keys = append(keys, cid.NewCidV0(c.Hash()))
+		}
+	}
+	randomRecord := func() index.Record {
+		r := index.Record{Cid: keys[rng.Intn(len(keys))], Offset: uint64(rng.Intn(1 << 12))}
+		if rng.Intn(2) == 0 {
+			r.Size = uint64(r.Cid.ByteLen() + rng.Intn(64))
+		}
+		return r
+	}
+
+	subject := index.NewInsertionIndex()
+	reference := &llrbInsertionIndex{}
+	for i := 0; i < 20000; i++ {
+		switch op := rng.Intn(100); {
+		case op < 80:
+			r := randomRecord()
+			if r.Size == 0 {
+				subject.InsertNoReplace(r.Cid, r.Offset)
+				reference.InsertNoReplace(r.Cid, r.Offset)
+			} else {
+				subject.InsertSizedNoReplace(r.Cid, r.Offset, r.Size)
+				reference.InsertSizedNoReplace(r.Cid, r.Offset, r.Size)
+			}
+		case op < 81:
+			// Load batches, which may be larger than the buffer of insertions, from time to time.
+			batch := make([]index.Record, rng.Intn(2048))
+			for j := range batch {
+				batch[j] = randomRecord()
+			}
+			require.NoError(t, subject.Load(batch))
+			require.NoError(t, reference.Load(batch))
+		case op < 95:
+			r := randomRecord()
+			if matching := reference.matching(r.Cid); len(matching) > 0 && rng.Intn(4) != 0 {
+				r = matching[rng.Intn(len(matching))]
+			}
+			want := reference.deleteMatching(r.Cid, func(got index.Record) bool {
+				return got.Cid == r.Cid && got.Offset == r.Offset
+			})
+			require.Equal(t, want > 0, subject.DeleteExact(r.Cid, r.Offset))
+		default:
+			key := keys[rng.Intn(len(keys))]
+			want := reference.deleteMatching(key, func(got index.Record) bool {
+				return got.Cid == key
+			})
+			require.Equal(t, want, subject.Delete(key))
+		}
+
+		if i%2000 == 0 {
+			requireAgreesWithLlrbInsertionIndex(t, subject, reference, keys)
+		}
+	}
+	requireAgreesWithLlrbInsertionIndex(t, subject, reference, keys)
+
+	// Assert the index agrees once marshalled and unmarshalled too.
+	var buf bytes.Buffer
+	_, err := subject.Marshal(&buf)
+	require.NoError(t, err)
+	unmarshalled := index.NewInsertionIndex()
+	require.NoError(t, unmarshalled.Unmarshal(&buf))
+	requireAgreesWithLlrbInsertionIndex(t, unmarshalled, reference, keys)
+}
+
+func requireAgreesWithLlrbInsertionIndex(t *testing.T, subject *index.InsertionIndex, reference *llrbInsertionIndex, keys []cid.Cid) {
+	want := reference.records()
+	require.Equal(t, len(want), subject.Len())
+
+	// Assert records are iterated over in the same order, i.e. by digest then in insertion order.
+	var got []index.Record
+	require.NoError(t, subject.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		got = append(got, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset})
+		return nil
+	}))
+	require.Equal(t, len(want), len(got))
+	for i := range want {
+		require.Equal(t, want[i].Cid.Hash(), got[i].Cid.Hash())
+		require.Equal(t, want[i].Offset, got[i].Offset)
+	}
+
+	for _, key := range keys {
+		matching := reference.matching(key)
+		var wantOffsets []uint64
+		var wantExact bool
+		for _, r := range matching {
+			wantOffsets = append(wantOffsets, r.Offset)
+			wantExact = wantExact || r.Cid == key
+		}
+		require.Equal(t, wantExact, subject.HasExactCID(key))
+
+		var gotOffsets []uint64
+		err := subject.GetAll(key, func(o uint64) bool {
+			gotOffsets = append(gotOffsets, o)
+			return true
+		})
+		gotOffset, getErr := subject.Get(key)
+		gotSize, gotKnown, getSizeErr := subject.GetSize(key)
+		if len(matching) == 0 {
+			_, err := reference.Get(key)
+			require.Equal(t, index.ErrNotFound, err)
+			require.Equal(t, index.ErrNotFound, getErr)
+			require.Equal(t, index.ErrNotFound, getSizeErr)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, wantOffsets, gotOffsets)
+
+		// The tree returns any of the matching records, whereas the first one is now returned.
+		require.NoError(t, getErr)
+		referenceOffset, err := reference.Get(key)
+		require.NoError(t, err)
+		require.Contains(t, wantOffsets, referenceOffset)
+		require.Equal(t, wantOffsets[0], gotOffset)
+
+		var wantSize uint64
+		var wantKnown bool
+		var wantSizeErr error = index.ErrNotFound
+		for _, r := range matching {
+			if bytes.Equal(r.Cid.Hash(), key.Hash()) {
+				wantSizeErr = nil
+				if cidLen := uint64(r.Cid.ByteLen()); r.Size >= cidLen {
+					wantSize, wantKnown = r.Size-cidLen, true
+				}
+				break
+			}
+		}
+		require.Equal(t, wantSizeErr, getSizeErr)
+		require.Equal(t, wantKnown, gotKnown)
+		require.Equal(t, wantSize, gotSize)
+	}
+
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, index.CarCidIndexSorted} {
+		wantFlattened, err := reference.Flatten(codec)
+		require.NoError(t, err)
+		gotFlattened, err := subject.Flatten(codec)
+		require.NoError(t, err)
+		require.Equal(t, marshalIndex(t, wantFlattened), marshalIndex(t, gotFlattened))
+	}
+	var buf bytes.Buffer
+	_, err := subject.WriteFlattenedTo(&buf, multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	wantFlattened, err := reference.Flatten(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	require.Equal(t, marshalIndex(t, wantFlattened), buf.Bytes())
+}
+
+func TestInsertionIndex_CompactMode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := make([]index.Record, 5000)
+	for i := range records {
+		records[i] = index.Record{Cid: generateCidV1(t, multihash.SHA2_256, rng), Offset: uint64(i)}
+	}
+
+	balanced := index.NewInsertionIndex()
+	compact := index.NewInsertionIndexWithMode(index.InsertionIndexCompact)
+	for _, subject := range []*index.InsertionIndex{balanced, compact} {
+		for _, r := range records[:len(records)/2] {
+			subject.InsertNoReplace(r.Cid, r.Offset)
+		}
+		require.NoError(t, subject.Load(records[len(records)/2:]))
+		for _, r := range records[:len(records)/10] {
+			require.Equal(t, 1, subject.Delete(r.Cid))
+		}
+	}
+
+	// Assert the modes hold the same records, and that the compact mode holds less memory.
+	require.Equal(t, balanced.Len(), compact.Len())
+	for _, r := range records[len(records)/10:] {
+		got, err := compact.Get(r.Cid)
+		require.NoError(t, err)
+		require.Equal(t, r.Offset, got)
+	}
+	want, err := balanced.Flatten(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	got, err := compact.Flatten(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	require.Equal(t, marshalIndex(t, want), marshalIndex(t, got))
+	require.Less(t, compact.MemoryFootprint(), balanced.MemoryFootprint())
+}
+
+// benchmarkedInsertionIndex is the subset of the methods of InsertionIndex that are compared
+// across its modes and against llrbInsertionIndex by benchmarks.
+type benchmarkedInsertionIndex interface {
+	InsertNoReplace(cid.Cid, uint64)
+	Get(cid.Cid) (uint64, error)
+	Flatten(multicodec.Code) (index.Index, error)
+}
+
+var benchmarkedInsertionIndices = []struct {
+	name string
+	new  func() benchmarkedInsertionIndex
+}{
+	{"llrb", func() benchmarkedInsertionIndex { return &llrbInsertionIndex{} }},
+	{"runs", func() benchmarkedInsertionIndex { return index.NewInsertionIndex() }},
+	{"runs-compact", func() benchmarkedInsertionIndex {
+		return index.NewInsertionIndexWithMode(index.InsertionIndexCompact)
+	}},
+}
+
+func generateBenchmarkCids(b *testing.B, n int) []cid.Cid {
+	rng := rand.New(rand.NewSource(1413))
+	digest := make([]byte, 32)
+	cids := make([]cid.Cid, n)
+	for i := range cids {
+		rng.Read(digest)
+		mh, err := multihash.Encode(digest, multihash.SHA2_256)
+		require.NoError(b, err)
+		cids[i] = cid.NewCidV1(cid.Raw, mh)
+	}
+	return cids
+}
+
+// BenchmarkInsertionIndex_Insert measures inserting records one at a time, and reports the number of
+// bytes retained on the heap per record, excluding the CIDs themselves.
+func BenchmarkInsertionIndex_Insert(b *testing.B) {
+	for _, impl := range benchmarkedInsertionIndices {
+		b.Run(impl.name, func(b *testing.B) {
+			cids := generateBenchmarkCids(b, b.N)
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			subject := impl.new()
+			for i, c := range cids {
+				subject.InsertNoReplace(c, uint64(i))
+			}
+
+			b.StopTimer()
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "B/record")
+			runtime.KeepAlive(subject)
+		})
+	}
+}
+
+// BenchmarkInsertionIndex_GetDuringIngest measures interleaving insertions with lookups of previously
+// inserted records, as a blockstore does when checking for duplicate puts.
+func BenchmarkInsertionIndex_GetDuringIngest(b *testing.B) {
+	for _, impl := range benchmarkedInsertionIndices {
+		b.Run(impl.name, func(b *testing.B) {
+			cids := generateBenchmarkCids(b, b.N)
+			rng := rand.New(rand.NewSource(1413))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			subject := impl.new()
+			for i, c := range cids {
+				subject.InsertNoReplace(c, uint64(i))
+				if _, err := subject.Get(cids[rng.Intn(i+1)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInsertionIndex_Flatten(b *testing.B) {
+	cids := generateBenchmarkCids(b, 1_000_000)
+	for _, impl := range benchmarkedInsertionIndices {
+		b.Run(impl.name, func(b *testing.B) {
+			subject := impl.new()
+			for i, c := range cids {
+				subject.InsertNoReplace(c, uint64(i))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := subject.Flatten(multicodec.CarMultihashIndexSorted); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
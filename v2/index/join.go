@@ -0,0 +1,247 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// joinCursorBuffer is the number of multihashes buffered ahead by a joinCursor.
+const joinCursorBuffer = 64
+
+var errJoinCursorClosed = errors.New("join cursor closed")
+
+// Difference returns the distinct multihashes that are present in a but not in b, in the order in
+// which they are iterated over in a. Both indices must be iterable.
+// See ForEachDifference.
+func Difference(a, b Index) ([]multihash.Multihash, error) {
+	return collectJoined(a, b, false)
+}
+
+// Intersect returns the distinct multihashes that are present in both a and b, in the order in
+// which they are iterated over in a. Both indices must be iterable.
+// See ForEachIntersection.
+func Intersect(a, b Index) ([]multihash.Multihash, error) {
+	return collectJoined(a, b, true)
+}
+
+// ForEachDifference calls f for every record of a whose multihash is not present in b, along with
+// its offset in a, such that the missing blocks can be read from the CAR indexed by a directly.
+// Records with duplicate multihashes in a are passed to f once per record. Both indices must be
+// iterable, and iteration stops at the first error returned by f.
+//
+// When both indices are of the MultihashIndexSorted codec, their records are iterated over in
+// the same sorted order, and are merge-joined as they are iterated over without materializing
+// either index. Otherwise, the multihashes of b are collected in memory before iterating over a.
+func ForEachDifference(a, b Index, f func(mh multihash.Multihash, offset uint64) error) error {
+	return forEachJoined(a, b, func(mh multihash.Multihash, offset uint64, inB bool) error {
+		if inB {
+			return nil
+		}
+		return f(mh, offset)
+	})
+}
+
+// ForEachIntersection calls f for every record of a whose multihash is also present in b, along
+// with its offset in a. Records with duplicate multihashes in a are passed to f once per record.
+// Both indices must be iterable, and iteration stops at the first error returned by f.
+//
+// As with ForEachDifference, indices of the MultihashIndexSorted codec are merge-joined without
+// materializing either index.
+func ForEachIntersection(a, b Index, f func(mh multihash.Multihash, offset uint64) error) error {
+	return forEachJoined(a, b, func(mh multihash.Multihash, offset uint64, inB bool) error {
+		if !inB {
+			return nil
+		}
+		return f(mh, offset)
+	})
+}
+
+// collectJoined returns the distinct multihashes of a that are present in b if inB is true, or
+// absent from b otherwise.
+func collectJoined(a, b Index, inB bool) ([]multihash.Multihash, error) {
+	var mhs []multihash.Multihash
+	seen := make(map[string]struct{})
+	err := forEachJoined(a, b, func(mh multihash.Multihash, _ uint64, found bool) error {
+		if found != inB {
+			return nil
+		}
+		if _, ok := seen[string(mh)]; ok {
+			return nil
+		}
+		seen[string(mh)] = struct{}{}
+		mhs = append(mhs, append(multihash.Multihash(nil), mh...))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mhs, nil
+}
+
+// forEachJoined calls f for every record of a, along with whether its multihash is present in b.
+func forEachJoined(a, b Index, f func(mh multihash.Multihash, offset uint64, inB bool) error) error {
+	ia, ok := a.(IterableIndex)
+	if !ok {
+		return fmt.Errorf("index with codec %s is not iterable", a.Codec())
+	}
+	ib, ok := b.(IterableIndex)
+	if !ok {
+		return fmt.Errorf("index with codec %s is not iterable", b.Codec())
+	}
+	if isMultihashSortedOrder(ia) && isMultihashSortedOrder(ib) {
+		return mergeJoin(ia, ib, f)
+	}
+
+	inB := make(map[string]struct{})
+	if err := ib.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		inB[string(mh)] = struct{}{}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return ia.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		_, ok := inB[string(mh)]
+		return f(mh, offset, ok)
+	})
+}
+
+// isMultihashSortedOrder checks whether the ForEach of the given index iterates over records in
+// the order defined by compareMultihashSortedOrder.
+func isMultihashSortedOrder(idx IterableIndex) bool {
+	switch idx.(type) {
+	case *MultihashIndexSorted, *mmapMultihashIndexSorted:
+		return true
+	default:
+		return false
+	}
+}
+
+// compareMultihashSortedOrder compares the given multihashes in the order in which records are
+// iterated over by MultihashIndexSorted, i.e. by multihash code, then digest length, then digest.
+func compareMultihashSortedOrder(a, b multihash.Multihash) (int, error) {
+	da, err := multihash.Decode(a)
+	if err != nil {
+		return 0, err
+	}
+	db, err := multihash.Decode(b)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case da.Code < db.Code:
+		return -1, nil
+	case da.Code > db.Code:
+		return 1, nil
+	case len(da.Digest) < len(db.Digest):
+		return -1, nil
+	case len(da.Digest) > len(db.Digest):
+		return 1, nil
+	default:
+		return bytes.Compare(da.Digest, db.Digest), nil
+	}
+}
+
+// mergeJoin iterates over a while pulling the records of b in lockstep, both of which must
+// iterate in the order defined by compareMultihashSortedOrder.
+func mergeJoin(a, b IterableIndex, f func(mh multihash.Multihash, offset uint64, inB bool) error) error {
+	cursor := newJoinCursor(b)
+	defer cursor.close()
+	if cursor.err != nil {
+		return cursor.err
+	}
+
+	var prev multihash.Multihash
+	err := a.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		if prev != nil {
+			if cmp, err := compareMultihashSortedOrder(prev, mh); err != nil {
+				return err
+			} else if cmp > 0 {
+				return fmt.Errorf("records of index with codec %s are not in sorted order", a.Codec())
+			}
+		}
+		prev = append(prev[:0], mh...)
+
+		// Advance b up to the first multihash that is not ordered before mh.
+		for cursor.ok {
+			cmp, err := compareMultihashSortedOrder(cursor.cur, mh)
+			if err != nil {
+				return err
+			}
+			if cmp >= 0 {
+				return f(mh, offset, cmp == 0)
+			}
+			if err := cursor.next(); err != nil {
+				return err
+			}
+		}
+		return f(mh, offset, false)
+	})
+	if err != nil {
+		return err
+	}
+	return cursor.err
+}
+
+// joinCursor pulls the multihashes of an index one at a time, by iterating over the index in a
+// separate goroutine.
+type joinCursor struct {
+	idx  IterableIndex
+	mhs  chan multihash.Multihash
+	done chan struct{}
+	// forEachErr is the error returned by ForEach, which is set before mhs is closed.
+	forEachErr error
+
+	cur multihash.Multihash
+	ok  bool
+	err error
+}
+
+func newJoinCursor(idx IterableIndex) *joinCursor {
+	c := &joinCursor{
+		idx:  idx,
+		mhs:  make(chan multihash.Multihash, joinCursorBuffer),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(c.mhs)
+		c.forEachErr = idx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+			select {
+			case c.mhs <- append(multihash.Multihash(nil), mh...):
+				return nil
+			case <-c.done:
+				return errJoinCursorClosed
+			}
+		})
+	}()
+	c.err = c.next()
+	return c
+}
+
+// next advances the cursor, setting ok to false once the index is exhausted. An error is returned
+// if iterating over the index fails, or if its records are not in sorted order.
+func (c *joinCursor) next() error {
+	prev := c.cur
+	c.cur, c.ok = <-c.mhs
+	if !c.ok {
+		c.err = c.forEachErr
+		return c.err
+	}
+	if prev != nil {
+		if cmp, err := compareMultihashSortedOrder(prev, c.cur); err != nil {
+			return err
+		} else if cmp > 0 {
+			return fmt.Errorf("records of index with codec %s are not in sorted order", c.idx.Codec())
+		}
+	}
+	return nil
+}
+
+// close stops iterating over the index, and waits for the iterating goroutine to return.
+func (c *joinCursor) close() {
+	close(c.done)
+	for range c.mhs {
+	}
+}
@@ -0,0 +1,139 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDifferenceAndIntersect(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	shared := generateIndexRecords(t, multihash.SHA2_256, rng)
+	shared = append(shared, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	onlyA := generateIndexRecords(t, multihash.SHA2_256, rng)
+	onlyB := generateIndexRecords(t, multihash.SHA2_512, rng)
+
+	aRecords := append(append([]index.Record{}, shared...), onlyA...)
+	// Duplicate a record of a, with a different offset.
+	aRecords = append(aRecords, index.Record{Cid: onlyA[0].Cid, Offset: onlyA[0].Offset + 1})
+	bRecords := append(append([]index.Record{}, shared...), onlyB...)
+
+	wantDifference := distinctMultihashes(onlyA)
+	wantIntersection := distinctMultihashes(shared)
+	wantDifferenceOffsets := make(map[string][]uint64)
+	for _, r := range aRecords[len(shared):] {
+		wantDifferenceOffsets[string(r.Cid.Hash())] = append(wantDifferenceOffsets[string(r.Cid.Hash())], r.Offset)
+	}
+
+	tests := []struct {
+		name   string
+		aCodec multicodec.Code
+		bCodec multicodec.Code
+		mmap   bool
+	}{
+		{name: "SortedAndSorted", aCodec: multicodec.CarMultihashIndexSorted, bCodec: multicodec.CarMultihashIndexSorted},
+		{name: "MmapSortedAndMmapSorted", aCodec: multicodec.CarMultihashIndexSorted, bCodec: multicodec.CarMultihashIndexSorted, mmap: true},
+		{name: "SortedAndHashed", aCodec: multicodec.CarMultihashIndexSorted, bCodec: index.CarMultihashIndexHashed},
+		{name: "HashedAndSized", aCodec: index.CarMultihashIndexHashed, bCodec: index.CarMultihashSizedIndexSorted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newLoadedIndex(t, tt.aCodec, aRecords, tt.mmap)
+			b := newLoadedIndex(t, tt.bCodec, bRecords, tt.mmap)
+
+			gotDifference, err := index.Difference(a, b)
+			require.NoError(t, err)
+			require.ElementsMatch(t, wantDifference, gotDifference)
+
+			gotIntersection, err := index.Intersect(a, b)
+			require.NoError(t, err)
+			require.ElementsMatch(t, wantIntersection, gotIntersection)
+
+			// Assert the offsets of every record in the difference are those of a.
+			gotDifferenceOffsets := make(map[string][]uint64)
+			err = index.ForEachDifference(a, b, func(mh multihash.Multihash, offset uint64) error {
+				gotDifferenceOffsets[string(mh)] = append(gotDifferenceOffsets[string(mh)], offset)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, len(wantDifferenceOffsets), len(gotDifferenceOffsets))
+			for mh, offsets := range wantDifferenceOffsets {
+				require.ElementsMatch(t, offsets, gotDifferenceOffsets[mh])
+			}
+
+			var intersectionCount int
+			err = index.ForEachIntersection(a, b, func(multihash.Multihash, uint64) error {
+				intersectionCount++
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, len(shared), intersectionCount)
+
+			// Assert the difference of b and a is the other way around.
+			gotDifference, err = index.Difference(b, a)
+			require.NoError(t, err)
+			require.ElementsMatch(t, distinctMultihashes(onlyB), gotDifference)
+		})
+	}
+}
+
+func TestDifference_EmptyIndices(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	populated := newLoadedIndex(t, multicodec.CarMultihashIndexSorted, records, false)
+	empty := index.NewMultihashSorted()
+
+	got, err := index.Difference(populated, empty)
+	require.NoError(t, err)
+	require.ElementsMatch(t, distinctMultihashes(records), got)
+
+	got, err = index.Difference(empty, populated)
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	got, err = index.Intersect(populated, empty)
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func TestDifference_NonIterableIndexIsError(t *testing.T) {
+	nonIterable, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	_, err = index.Difference(nonIterable, index.NewMultihashSorted())
+	require.Error(t, err)
+	_, err = index.Intersect(index.NewMultihashSorted(), nonIterable)
+	require.Error(t, err)
+}
+
+// newLoadedIndex instantiates an index of the given codec loaded with the given records, and
+// optionally opens it via index.OpenMmap.
+func newLoadedIndex(t *testing.T, codec multicodec.Code, records []index.Record, mmap bool) index.Index {
+	idx, err := index.New(codec)
+	require.NoError(t, err)
+	require.NoError(t, idx.Load(records))
+	if !mmap {
+		return idx
+	}
+	b := marshalIndex(t, idx)
+	mmapped, err := index.OpenMmap(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	return mmapped
+}
+
+func distinctMultihashes(records []index.Record) []multihash.Multihash {
+	var mhs []multihash.Multihash
+	seen := make(map[string]struct{})
+	for _, r := range records {
+		if _, ok := seen[string(r.Cid.Hash())]; ok {
+			continue
+		}
+		seen[string(r.Cid.Hash())] = struct{}{}
+		mhs = append(mhs, r.Cid.Hash())
+	}
+	return mhs
+}
@@ -0,0 +1,420 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Index          = (*MultihashIndexHashed)(nil)
+	_ IterableIndex  = (*MultihashIndexHashed)(nil)
+	_ CountableIndex = (*MultihashIndexHashed)(nil)
+)
+
+const (
+	// hashTableMaxLoadNumerator and hashTableMaxLoadDenominator define the maximum load factor of
+	// the hash tables of MultihashIndexHashed, i.e. 3/4. The capacity of each table is the smallest
+	// power of two at which the load factor does not exceed the maximum.
+	//
+	// With linear probing, this bounds the expected number of slots probed by a lookup to ~2.5
+	// for keys that are present and ~8.5 for keys that are not, while keeping the serialized index
+	// at most ~2.7 times larger than the records it stores.
+	hashTableMaxLoadNumerator   = 3
+	hashTableMaxLoadDenominator = 4
+
+	// fnv64Offset and fnv64Prime are the parameters of the 64-bit FNV-1a hash function, used to
+	// hash multihashes into hash table slots.
+	fnv64Offset = 14695981039346656037
+	fnv64Prime  = 1099511628211
+)
+
+type (
+	// MultihashIndexHashed is an index that stores the multihash and offset of each block in
+	// open-addressing hash tables, such that blocks are looked up in expected constant time
+	// regardless of the number of records, as opposed to the logarithmic time of sorted indices.
+	//
+	// Records are grouped by the length of their multihash, and each group is stored in a hash
+	// table keyed by the 64-bit FNV-1a hash of the multihash, using linear probing to resolve
+	// collisions. Records with the same multihash, e.g. via duplicate blocks, are all stored and
+	// are returned by GetAll in ascending order of offset.
+	//
+	// The serialized form of the index is deterministic: loading the same set of records, in any
+	// order, always results in the same bytes. The index can be opened without reading its
+	// records into memory via OpenMmap.
+	MultihashIndexHashed map[uint32]hashTable
+
+	// hashTable is an open-addressing hash table of records with equal multihash length.
+	//
+	// The table consists of a power of two number of slots of equal width, where each slot holds
+	// the multihash of a record followed by the little-endian offset of the record plus one.
+	// Empty slots are all zeros, which is never the case for an occupied slot.
+	hashTable struct {
+		width uint32
+		count uint64
+		slots []byte
+	}
+
+	hashedRecord struct {
+		mh     multihash.Multihash
+		offset uint64
+	}
+)
+
+// NewMultihashHashed instantiates a new empty MultihashIndexHashed.
+func NewMultihashHashed() *MultihashIndexHashed {
+	index := make(MultihashIndexHashed)
+	return &index
+}
+
+// MultihashIndexHashedFrom builds a MultihashIndexHashed from the records of the given index.
+//
+// This allows converting an existing index, e.g. one read from a CARv2 file via ReadFrom or
+// generated via car.GenerateIndex, without re-reading the CAR payload.
+func MultihashIndexHashedFrom(idx IterableIndex) (*MultihashIndexHashed, error) {
+	byWidth := make(map[uint32][]hashedRecord)
+	if err := idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		// Copy the multihash, since the given slice may be reused by the index.
+		mh = append(multihash.Multihash(nil), mh...)
+		width := hashTableWidth(mh)
+		byWidth[width] = append(byWidth[width], hashedRecord{mh, offset})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	m := NewMultihashHashed()
+	if err := m.load(byWidth); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func hashTableWidth(mh multihash.Multihash) uint32 {
+	return uint32(len(mh)) + 8
+}
+
+// hashMultihash returns the 64-bit FNV-1a hash of the given multihash.
+func hashMultihash(mh []byte) uint64 {
+	h := uint64(fnv64Offset)
+	for _, b := range mh {
+		h ^= uint64(b)
+		h *= fnv64Prime
+	}
+	return h
+}
+
+// hashTableCapacity returns the number of slots of a hash table that stores count records, i.e.
+// the smallest power of two at which the load factor does not exceed the maximum. The capacity is
+// always larger than count, such that every table has at least one empty slot.
+func hashTableCapacity(count uint64) uint64 {
+	capacity := uint64(1)
+	for capacity*hashTableMaxLoadNumerator < count*hashTableMaxLoadDenominator {
+		capacity <<= 1
+	}
+	return capacity
+}
+
+// checkHashTableLengths checks the lengths of a serialized hash table, returning its capacity.
+func checkHashTableLengths(width uint32, count, dataLen uint64) (uint64, error) {
+	// Each slot must at least contain a multihash code and length, followed by the offset.
+	if width < 10 {
+		return 0, malformedIndexError("width must be at least 10")
+	}
+	const maxWidth = 32 << 20 // 32MiB, to ~match the go-cid maximum
+	if width > maxWidth {
+		return 0, malformedIndexError("hashTable width is larger than allowed maximum")
+	}
+	if int64(dataLen) < 0 {
+		return 0, malformedIndexError("hashTable len is overflowing int64")
+	}
+	if dataLen%uint64(width) != 0 {
+		return 0, malformedIndexError("data length %d is not a multiple of width %d", dataLen, width)
+	}
+	capacity := dataLen / uint64(width)
+	if capacity == 0 || capacity&(capacity-1) != 0 {
+		return 0, malformedIndexError("hashTable capacity %d is not a power of two", capacity)
+	}
+	if count >= capacity {
+		return 0, malformedIndexError("hashTable count %d must be less than its capacity %d", count, capacity)
+	}
+	return capacity, nil
+}
+
+// newHashTable builds a hash table of the given width from records, which must all have
+// multihashes of the same length. The records are inserted in order of multihash and offset, such
+// that the resulting table is independent of the order of the given records.
+func newHashTable(width uint32, records []hashedRecord) (hashTable, error) {
+	sort.Slice(records, func(i, j int) bool {
+		if c := bytes.Compare(records[i].mh, records[j].mh); c != 0 {
+			return c < 0
+		}
+		return records[i].offset < records[j].offset
+	})
+	capacity := hashTableCapacity(uint64(len(records)))
+	t := hashTable{
+		width: width,
+		count: uint64(len(records)),
+		slots: make([]byte, capacity*uint64(width)),
+	}
+	for _, r := range records {
+		if r.offset == math.MaxUint64 {
+			return hashTable{}, fmt.Errorf("invalid record offset for %s: %d", r.mh.B58String(), r.offset)
+		}
+		t.insert(r.mh, r.offset)
+	}
+	return t, nil
+}
+
+func (t *hashTable) capacity() uint64 {
+	return uint64(len(t.slots)) / uint64(t.width)
+}
+
+// slot returns the multihash and offset stored at the i-th slot of t.
+// The returned bool is false if the slot is empty.
+func (t *hashTable) slot(i uint64) (mh []byte, offset uint64, occupied bool) {
+	start := i * uint64(t.width)
+	mhEnd := start + uint64(t.width) - 8
+	value := binary.LittleEndian.Uint64(t.slots[mhEnd : mhEnd+8])
+	if value == 0 {
+		return nil, 0, false
+	}
+	return t.slots[start:mhEnd], value - 1, true
+}
+
+// insert stores the given record at the first empty slot, starting from the slot its multihash
+// hashes to. Records with the same multihash are therefore probed in order of insertion.
+func (t *hashTable) insert(mh []byte, offset uint64) {
+	mask := t.capacity() - 1
+	i := hashMultihash(mh) & mask
+	for {
+		if _, _, occupied := t.slot(i); !occupied {
+			break
+		}
+		i = (i + 1) & mask
+	}
+	start := i * uint64(t.width)
+	n := copy(t.slots[start:], mh)
+	binary.LittleEndian.PutUint64(t.slots[start+uint64(n):], offset+1)
+}
+
+// getAll calls fn for the offset of each record with the given multihash, in order, until fn
+// returns false. The returned bool is false if no record with the given multihash is found.
+func (t *hashTable) getAll(mh []byte, fn func(uint64) bool) bool {
+	capacity := t.capacity()
+	mask := capacity - 1
+	i := hashMultihash(mh) & mask
+	var any bool
+	// Probe at most capacity slots, in case the table has no empty slots.
+	for probed := uint64(0); probed < capacity; probed++ {
+		got, offset, occupied := t.slot(i)
+		if !occupied {
+			// Reached the end of the probe sequence; therefore, break.
+			break
+		}
+		if bytes.Equal(got, mh) {
+			any = true
+			if !fn(offset) {
+				// User signalled to stop searching; therefore, break.
+				break
+			}
+		}
+		i = (i + 1) & mask
+	}
+	return any
+}
+
+func (t *hashTable) forEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	for i := uint64(0); i < t.capacity(); i++ {
+		mh, offset, occupied := t.slot(i)
+		if !occupied {
+			continue
+		}
+		if err := f(mh, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *hashTable) Marshal(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.LittleEndian, t.width); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, t.count); err != nil {
+		return 4, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int64(len(t.slots))); err != nil {
+		return 12, err
+	}
+	n, err := w.Write(t.slots)
+	return 20 + int64(n), err
+}
+
+func (t *hashTable) Unmarshal(r io.Reader) error {
+	var width uint32
+	if err := binary.Read(r, binary.LittleEndian, &width); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var dataLen uint64
+	if err := binary.Read(r, binary.LittleEndian, &dataLen); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if _, err := checkHashTableLengths(width, count, dataLen); err != nil {
+		return err
+	}
+
+	buf, err := readDeclaredLength(r, dataLen)
+	if err != nil {
+		return err
+	}
+	t.width = width
+	t.count = count
+	t.slots = buf
+
+	// Check the number of occupied slots matches the count, since lookups rely on it being less
+	// than the capacity.
+	var occupied uint64
+	_ = t.forEach(func(multihash.Multihash, uint64) error {
+		occupied++
+		return nil
+	})
+	if occupied != count {
+		return malformedIndexError("hashTable has %d occupied slots but a count of %d", occupied, count)
+	}
+	return nil
+}
+
+func (m *MultihashIndexHashed) Codec() multicodec.Code {
+	return CarMultihashIndexHashed
+}
+
+func (m *MultihashIndexHashed) Marshal(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.LittleEndian, int32(len(*m))); err != nil {
+		return 0, err
+	}
+	l := int64(4)
+	for _, width := range m.sortedWidths() {
+		table := (*m)[width]
+		n, err := table.Marshal(w)
+		l += n
+		if err != nil {
+			return l, err
+		}
+	}
+	return l, nil
+}
+
+func (m *MultihashIndexHashed) Unmarshal(r io.Reader) error {
+	reader := internalio.ToByteReadSeeker(r)
+	var l int32
+	if err := binary.Read(reader, binary.LittleEndian, &l); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if l < 0 {
+		return malformedIndexError("MultihashIndexHashed count is overflowing int32")
+	}
+	for i := 0; i < int(l); i++ {
+		var t hashTable
+		if err := t.Unmarshal(reader); err != nil {
+			return err
+		}
+		if _, ok := (*m)[t.width]; ok {
+			return malformedIndexError("MultihashIndexHashed has duplicate hash tables of width %d", t.width)
+		}
+		(*m)[t.width] = t
+	}
+	return nil
+}
+
+// Load inserts the given records into the index. Since the capacity of hash tables depends on the
+// number of records they store, the tables to which records are added are rebuilt entirely.
+func (m *MultihashIndexHashed) Load(records []Record) error {
+	byWidth := make(map[uint32][]hashedRecord)
+	for _, record := range records {
+		mh := record.Cid.Hash()
+		width := hashTableWidth(mh)
+		byWidth[width] = append(byWidth[width], hashedRecord{mh, record.Offset})
+	}
+	return m.load(byWidth)
+}
+
+func (m *MultihashIndexHashed) load(byWidth map[uint32][]hashedRecord) error {
+	for width, group := range byWidth {
+		if existing, ok := (*m)[width]; ok {
+			_ = existing.forEach(func(mh multihash.Multihash, offset uint64) error {
+				group = append(group, hashedRecord{mh, offset})
+				return nil
+			})
+		}
+		table, err := newHashTable(width, group)
+		if err != nil {
+			return err
+		}
+		(*m)[width] = table
+	}
+	return nil
+}
+
+// GetAll calls fn for the offset of every block with the same multihash as c, in ascending order.
+func (m *MultihashIndexHashed) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	mh := c.Hash()
+	table, ok := (*m)[hashTableWidth(mh)]
+	if !ok || !table.getAll(mh, fn) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ForEach calls f for every multihash and its associated offset stored by this index, ordered by
+// multihash length and then by the slot at which each record is stored.
+func (m *MultihashIndexHashed) ForEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	for _, width := range m.sortedWidths() {
+		table := (*m)[width]
+		if err := table.forEach(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count returns the total number of records stored by this index.
+func (m *MultihashIndexHashed) Count() (uint64, error) {
+	var count uint64
+	for _, table := range *m {
+		count += table.count
+	}
+	return count, nil
+}
+
+func (m *MultihashIndexHashed) sortedWidths() []uint32 {
+	widths := make([]uint32, 0, len(*m))
+	for width := range *m {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+	return widths
+}
@@ -0,0 +1,207 @@
+package index_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultihashIndexHashed_GetAll(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	records = append(records, generateIndexRecords(t, multihash.SHA3_224, rng)...)
+
+	subject := index.NewMultihashHashed()
+	require.NoError(t, subject.Load(records))
+	requireContainsAll(t, subject, records)
+
+	// Assert keys that are not indexed are not found, including ones with a multihash length
+	// that is not present in the index.
+	for i := 0; i < 100; i++ {
+		for _, code := range []uint64{multihash.SHA2_256, multihash.SHA3_384} {
+			_, err := index.GetFirst(subject, generateCidV1(t, code, rng))
+			require.Equal(t, index.ErrNotFound, err)
+		}
+	}
+}
+
+func TestMultihashIndexHashed_GetAllReturnsDuplicatesInOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	key := records[0].Cid
+	wantOffsets := []uint64{records[0].Offset}
+	for i := 0; i < 5; i++ {
+		offset := rng.Uint64() >> 1
+		records = append(records, index.Record{Cid: key, Offset: offset})
+		wantOffsets = append(wantOffsets, offset)
+	}
+	sort.Slice(wantOffsets, func(i, j int) bool { return wantOffsets[i] < wantOffsets[j] })
+
+	subject := index.NewMultihashHashed()
+	require.NoError(t, subject.Load(records))
+	gotOffsets, err := getAllOffsets(subject, key)
+	require.NoError(t, err)
+	require.Equal(t, wantOffsets, gotOffsets)
+
+	// Assert GetAll stops when the given function returns false.
+	var calls int
+	require.NoError(t, subject.GetAll(key, func(uint64) bool {
+		calls++
+		return false
+	}))
+	require.Equal(t, 1, calls)
+}
+
+func TestMultihashIndexHashed_MarshalIsDeterministic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	for _, r := range records[:10] {
+		records = append(records, index.Record{Cid: r.Cid, Offset: rng.Uint64() >> 1})
+	}
+
+	want := index.NewMultihashHashed()
+	require.NoError(t, want.Load(records))
+	wantBytes := marshalIndex(t, want)
+
+	// Assert the index marshals identically regardless of the order in which records are loaded.
+	for i := 0; i < 10; i++ {
+		shuffled := append([]index.Record(nil), records...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		got := index.NewMultihashHashed()
+		require.NoError(t, got.Load(shuffled))
+		require.Equal(t, wantBytes, marshalIndex(t, got))
+	}
+
+	// Assert the index marshals identically when records are loaded in several batches.
+	got := index.NewMultihashHashed()
+	require.NoError(t, got.Load(records[:len(records)/2]))
+	require.NoError(t, got.Load(records[len(records)/2:]))
+	require.Equal(t, wantBytes, marshalIndex(t, got))
+
+	// Assert the index marshals identically once read back.
+	read, err := index.ReadFrom(bytes.NewReader(wantBytes))
+	require.NoError(t, err)
+	require.Equal(t, index.CarMultihashIndexHashed, read.Codec())
+	require.Equal(t, wantBytes, marshalIndex(t, read))
+}
+
+func TestMultihashIndexHashedFrom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	want := index.NewMultihashHashed()
+	require.NoError(t, want.Load(records))
+
+	for _, codec := range []multicodec.Code{multicodec.CarMultihashIndexSorted, index.CarMultihashSizedIndexSorted} {
+		t.Run(codec.String(), func(t *testing.T) {
+			from, err := index.New(codec)
+			require.NoError(t, err)
+			require.NoError(t, from.Load(records))
+
+			got, err := index.MultihashIndexHashedFrom(from.(index.IterableIndex))
+			require.NoError(t, err)
+			requireContainsAll(t, got, records)
+			require.Equal(t, marshalIndex(t, want), marshalIndex(t, got))
+		})
+	}
+}
+
+func TestMultihashIndexHashed_ForEach(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	subject := index.NewMultihashHashed()
+	require.NoError(t, subject.Load(records))
+
+	want := make(map[string]uint64)
+	for _, r := range records {
+		want[r.Cid.Hash().String()] = r.Offset
+	}
+	got := make(map[string]uint64)
+	require.NoError(t, subject.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		got[mh.String()] = offset
+		return nil
+	}))
+	require.Equal(t, want, got)
+}
+
+func TestMultihashIndexHashed_LoadWithMaxOffsetIsError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewMultihashHashed()
+	err := subject.Load([]index.Record{{Cid: generateCidV1(t, multihash.SHA2_256, rng), Offset: 1<<64 - 1}})
+	require.Error(t, err)
+}
+
+func TestMultihashIndexHashed_UnmarshalMalformedIsError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewMultihashHashed()
+	require.NoError(t, subject.Load(generateIndexRecords(t, multihash.SHA2_256, rng)))
+	buf := new(bytes.Buffer)
+	_, err := subject.Marshal(buf)
+	require.NoError(t, err)
+	valid := buf.Bytes()
+
+	// The serialized index starts with the number of tables, followed by the width, count and
+	// data length of the first table.
+	const countOffset = 4 + 4
+	const dataLenOffset = countOffset + 8
+	tests := []struct {
+		name   string
+		mutate func(b []byte) []byte
+	}{
+		{
+			name: "Truncated",
+			mutate: func(b []byte) []byte {
+				return b[:len(b)-1]
+			},
+		},
+		{
+			name: "CountMismatch",
+			mutate: func(b []byte) []byte {
+				count := binary.LittleEndian.Uint64(b[countOffset:])
+				binary.LittleEndian.PutUint64(b[countOffset:], count-1)
+				return b
+			},
+		},
+		{
+			name: "CountNotLessThanCapacity",
+			mutate: func(b []byte) []byte {
+				binary.LittleEndian.PutUint64(b[countOffset:], 1<<40)
+				return b
+			},
+		},
+		{
+			name: "CapacityNotPowerOfTwo",
+			mutate: func(b []byte) []byte {
+				width := binary.LittleEndian.Uint32(b[4:])
+				dataLen := binary.LittleEndian.Uint64(b[dataLenOffset:])
+				binary.LittleEndian.PutUint64(b[dataLenOffset:], dataLen-uint64(width))
+				return b[:len(b)-int(width)]
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			malformed := tt.mutate(append([]byte(nil), valid...))
+			require.Error(t, index.NewMultihashHashed().Unmarshal(bytes.NewReader(malformed)))
+		})
+	}
+}
+
+func marshalIndex(t *testing.T, idx index.Index) []byte {
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(idx, buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
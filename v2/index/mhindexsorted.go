@@ -2,7 +2,6 @@ package index
 
 import (
 	"encoding/binary"
-	"errors"
 	internalio "github.com/ipld/go-car/v2/internal/io"
 	"io"
 	"sort"
@@ -13,12 +12,20 @@ import (
 )
 
 var (
-	_ Index         = (*MultihashIndexSorted)(nil)
-	_ IterableIndex = (*MultihashIndexSorted)(nil)
+	_ Index          = (*MultihashIndexSorted)(nil)
+	_ IterableIndex  = (*MultihashIndexSorted)(nil)
+	_ CountableIndex = (*MultihashIndexSorted)(nil)
 )
 
 type (
 	// MultihashIndexSorted maps multihash code (i.e. hashing algorithm) to multiWidthCodedIndex.
+	//
+	// Records are bucketed by multihash code as well as digest length, such that lookups are exact
+	// for CARs that mix hash functions with the same digest length, e.g. sha2-256 and blake2b-256;
+	// a lookup never returns the offsets of records with a different multihash code. This is
+	// unlike the CarIndexSorted codec, which buckets records by digest length alone.
+	// MultihashIndexSorted is the default codec used by car.GenerateIndex, and when finalizing
+	// blockstores.
 	MultihashIndexSorted map[uint64]*multiWidthCodedIndex
 	// multiWidthCodedIndex stores multihash code for each multiWidthIndex.
 	multiWidthCodedIndex struct {
@@ -33,9 +40,9 @@ func newMultiWidthCodedIndex() *multiWidthCodedIndex {
 	}
 }
 
-func (m *multiWidthCodedIndex) Marshal(w io.Writer) (uint64, error) {
+func (m *multiWidthCodedIndex) Marshal(w io.Writer) (int64, error) {
 	if err := binary.Write(w, binary.LittleEndian, m.code); err != nil {
-		return 8, err
+		return 0, err
 	}
 	n, err := m.multiWidthIndex.Marshal(w)
 	return 8 + n, err
@@ -65,15 +72,15 @@ func (m *MultihashIndexSorted) Codec() multicodec.Code {
 	return multicodec.CarMultihashIndexSorted
 }
 
-func (m *MultihashIndexSorted) Marshal(w io.Writer) (uint64, error) {
+func (m *MultihashIndexSorted) Marshal(w io.Writer) (int64, error) {
 	if err := binary.Write(w, binary.LittleEndian, int32(len(*m))); err != nil {
-		return 4, err
+		return 0, err
 	}
 	// The codes are unique, but ranging over a map isn't deterministic.
 	// As per the CARv2 spec, we must order buckets by digest length.
 	// TODO update CARv2 spec to reflect this for the new index type.
 	codes := m.sortedMultihashCodes()
-	l := uint64(4)
+	l := int64(4)
 
 	for _, code := range codes {
 		mwci := (*m)[code]
@@ -111,7 +118,7 @@ func (m *MultihashIndexSorted) Unmarshal(r io.Reader) error {
 		return err
 	}
 	if int32(l) < 0 {
-		return errors.New("index too big; MultihashIndexSorted count is overflowing int32")
+		return malformedIndexError("MultihashIndexSorted count is overflowing int32")
 	}
 	for i := 0; i < int(l); i++ {
 		mwci := newMultiWidthCodedIndex()
@@ -125,7 +132,10 @@ func (m *MultihashIndexSorted) Unmarshal(r io.Reader) error {
 		oldSum := sum
 		sum += n
 		if sum < oldSum {
-			return errors.New("index too big; MultihashIndexSorted len is overflowing int64")
+			return malformedIndexError("MultihashIndexSorted len is overflowing int64")
+		}
+		if _, ok := (*m)[mwci.code]; ok {
+			return malformedIndexError("MultihashIndexSorted has duplicate buckets of multihash code %d", mwci.code)
 		}
 		m.put(mwci)
 	}
@@ -200,6 +210,19 @@ func (m *MultihashIndexSorted) ForEach(f func(mh multihash.Multihash, offset uin
 	return nil
 }
 
+// Count returns the total number of records stored by this index, across all multihash codes.
+func (m *MultihashIndexSorted) Count() (uint64, error) {
+	var count uint64
+	for _, mwci := range *m {
+		c, err := mwci.Count()
+		if err != nil {
+			return 0, err
+		}
+		count += c
+	}
+	return count, nil
+}
+
 func (m *MultihashIndexSorted) get(dmh *multihash.DecodedMultihash) (*multiWidthCodedIndex, error) {
 	if codedIdx, ok := (*m)[dmh.Code]; ok {
 		return codedIdx, nil
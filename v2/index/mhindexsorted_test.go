@@ -2,6 +2,7 @@ package index_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -77,6 +78,96 @@ func TestMultiWidthCodedIndex_StableIterate(t *testing.T) {
 	}
 }
 
+func TestMultihashIndexSorted_ForEachStopsOnError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1415))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	subject := index.NewMultihashSorted()
+	require.NoError(t, subject.Load(records))
+
+	// Assert every record is visited when the callback never errors.
+	var count int
+	require.NoError(t, subject.ForEach(func(multihash.Multihash, uint64) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, len(records), count)
+
+	// Assert returning an error stops iteration and propagates the error as is.
+	wantErr := errors.New("lobster")
+	count = 0
+	err := subject.ForEach(func(multihash.Multihash, uint64) error {
+		count++
+		if count == 2 {
+			return wantErr
+		}
+		return nil
+	})
+	require.Equal(t, wantErr, err)
+	require.Equal(t, 2, count)
+}
+
+func TestMultihashIndexSorted_ForEachIsInMultihashOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1416))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+
+	subject := index.NewMultihashSorted()
+	require.NoError(t, subject.Load(records))
+
+	// Within a single multihash code and digest length, iteration is in ascending order.
+	var prev multihash.Multihash
+	require.NoError(t, subject.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		if prev != nil {
+			require.True(t, bytes.Compare(prev, mh) <= 0)
+		}
+		prev = mh
+		return nil
+	}))
+}
+
+func TestMultihashIndexSorted_MixedHashFunctionsAreBucketedApart(t *testing.T) {
+	// Construct multihashes of two hash functions with the same digest length and identical digests,
+	// such that they can only be told apart by their multihash code.
+	digest := bytes.Repeat([]byte{0x2a}, 32)
+	sha256Mh, err := multihash.Encode(digest, multihash.SHA2_256)
+	require.NoError(t, err)
+	blake2bMh, err := multihash.Encode(digest, multihash.BLAKE2B_MIN+31)
+	require.NoError(t, err)
+	sha256Cid := cid.NewCidV1(cid.Raw, sha256Mh)
+	blake2bCid := cid.NewCidV1(cid.DagCBOR, blake2bMh)
+	records := []index.Record{
+		{Cid: sha256Cid, Offset: 10},
+		{Cid: blake2bCid, Offset: 20},
+	}
+
+	loaded := index.NewMultihashSorted()
+	require.NoError(t, loaded.Load(records))
+	var buf bytes.Buffer
+	_, err = loaded.Marshal(&buf)
+	require.NoError(t, err)
+	roundTripped := index.NewMultihashSorted()
+	require.NoError(t, roundTripped.Unmarshal(&buf))
+
+	for _, subject := range []index.Index{loaded, roundTripped} {
+		gotOffsets, err := getAllOffsets(subject, sha256Cid)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{10}, gotOffsets)
+		gotOffsets, err = getAllOffsets(subject, blake2bCid)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{20}, gotOffsets)
+	}
+
+	// Assert that, unlike the default codec, the digest-only CarIndexSorted codec cannot tell the
+	// two apart.
+	digestOnly, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	require.NoError(t, digestOnly.Load(records))
+	gotOffsets, err := getAllOffsets(digestOnly, sha256Cid)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint64{10, 20}, gotOffsets)
+}
+
 func generateIndexRecords(t *testing.T, hasherCode uint64, rng *rand.Rand) []index.Record {
 	var records []index.Record
 	recordCount := rng.Intn(99) + 1 // Up to 100 records
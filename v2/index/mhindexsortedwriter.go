@@ -0,0 +1,206 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+type (
+	// MultihashBucketCounts holds the number of records of a MultihashIndexSorted by multihash code
+	// and digest length, i.e. by the buckets that determine the layout of the serialized index.
+	// See MultihashIndexSortedWriter.
+	MultihashBucketCounts map[uint64]map[int]uint64
+
+	// MultihashIndexSortedWriter writes an index of the MultihashIndexSorted codec one record at a
+	// time, in the same format as WriteTo, such that an index can be serialized without holding all
+	// of its records in memory at once.
+	//
+	// Since the serialized index declares the size of each bucket before its records, the number of
+	// records of every bucket must be known upfront. Records must then be written in the order in
+	// which they are serialized, i.e. in ascending order of multihash code, then digest length, then
+	// digest; an error is returned otherwise.
+	MultihashIndexSortedWriter struct {
+		w       io.Writer
+		buckets []multihashBucket
+		// current is the index of the bucket being written, and written is the number of its
+		// records written so far.
+		current    int
+		written    uint64
+		prevDigest []byte
+		buf        []byte
+	}
+
+	// multihashBucket is a bucket of records with equal multihash code and digest length.
+	multihashBucket struct {
+		code      uint64
+		digestLen int
+		count     uint64
+		// widths is the number of buckets with the same code, set on the first one of them only.
+		widths int
+	}
+)
+
+// Add counts a record with the given multihash.
+func (c MultihashBucketCounts) Add(mh multihash.Multihash) error {
+	dmh, err := multihash.Decode(mh)
+	if err != nil {
+		return err
+	}
+	c.add(dmh.Code, len(dmh.Digest))
+	return nil
+}
+
+func (c MultihashBucketCounts) add(code uint64, digestLen int) {
+	byLen, ok := c[code]
+	if !ok {
+		byLen = make(map[int]uint64)
+		c[code] = byLen
+	}
+	byLen[digestLen]++
+}
+
+// buckets returns the non-empty buckets in the order in which they are serialized.
+func (c MultihashBucketCounts) buckets() []multihashBucket {
+	var buckets []multihashBucket
+	codes := make([]uint64, 0, len(c))
+	for code := range c {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		digestLens := make([]int, 0, len(c[code]))
+		for digestLen, count := range c[code] {
+			if count > 0 {
+				digestLens = append(digestLens, digestLen)
+			}
+		}
+		sort.Ints(digestLens)
+		for i, digestLen := range digestLens {
+			b := multihashBucket{code: code, digestLen: digestLen, count: c[code][digestLen]}
+			if i == 0 {
+				b.widths = len(digestLens)
+			}
+			buckets = append(buckets, b)
+		}
+	}
+	return buckets
+}
+
+// marshaledSize returns the number of bytes written by a MultihashIndexSortedWriter with these
+// counts, i.e. the size of the index as written by WriteTo.
+func (c MultihashBucketCounts) marshaledSize() uint64 {
+	size := uint64(varint.UvarintSize(uint64(multicodec.CarMultihashIndexSorted))) + 4
+	for _, b := range c.buckets() {
+		if b.widths > 0 {
+			size += 8 + 4
+		}
+		size += 4 + 8 + b.count*uint64(b.digestLen+8)
+	}
+	return size
+}
+
+// NewMultihashIndexSortedWriter instantiates a new MultihashIndexSortedWriter that writes an index
+// with the given number of records per bucket to w. The codec of the index is written immediately.
+func NewMultihashIndexSortedWriter(w io.Writer, counts MultihashBucketCounts) (*MultihashIndexSortedWriter, error) {
+	sw := &MultihashIndexSortedWriter{
+		w:       w,
+		buckets: counts.buckets(),
+		current: -1,
+		buf:     make([]byte, binary.MaxVarintLen64+8),
+	}
+	// Write the codec, followed by the number of multihash codes.
+	var codes int32
+	for _, b := range sw.buckets {
+		if b.widths > 0 {
+			codes++
+		}
+	}
+	n := varint.PutUvarint(sw.buf, uint64(multicodec.CarMultihashIndexSorted))
+	binary.LittleEndian.PutUint32(sw.buf[n:], uint32(codes))
+	if _, err := w.Write(sw.buf[:n+4]); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// WriteRecord writes the given record. Only the multihash and offset of the record are written.
+func (sw *MultihashIndexSortedWriter) WriteRecord(r Record) error {
+	dmh, err := multihash.Decode(r.Hash())
+	if err != nil {
+		return err
+	}
+	return sw.write(dmh.Code, string(dmh.Digest), r.Offset)
+}
+
+func (sw *MultihashIndexSortedWriter) write(code uint64, digest string, offset uint64) error {
+	if sw.current < 0 || sw.written == sw.buckets[sw.current].count {
+		// Move on to the next bucket, which the record must belong to.
+		if sw.current+1 >= len(sw.buckets) {
+			return errors.New("more records written than counted")
+		}
+		next := sw.buckets[sw.current+1]
+		if next.code != code || next.digestLen != len(digest) {
+			return fmt.Errorf("record with multihash code %d and digest length %d is out of order or not counted", code, len(digest))
+		}
+		if err := sw.writeBucketHeader(next); err != nil {
+			return err
+		}
+		sw.current++
+		sw.written = 0
+	} else {
+		b := sw.buckets[sw.current]
+		if b.code != code || b.digestLen != len(digest) {
+			return fmt.Errorf("record with multihash code %d and digest length %d is out of order or not counted", code, len(digest))
+		}
+		if string(sw.prevDigest) > digest {
+			return errors.New("records are not in sorted order of digest")
+		}
+	}
+
+	if _, err := io.WriteString(sw.w, digest); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(sw.buf, offset)
+	if _, err := sw.w.Write(sw.buf[:8]); err != nil {
+		return err
+	}
+	sw.prevDigest = append(sw.prevDigest[:0], digest...)
+	sw.written++
+	return nil
+}
+
+// writeBucketHeader writes the header of the given bucket, preceded by the header of its multihash
+// code if it is the first bucket with that code.
+func (sw *MultihashIndexSortedWriter) writeBucketHeader(b multihashBucket) error {
+	if b.widths > 0 {
+		binary.LittleEndian.PutUint64(sw.buf, b.code)
+		binary.LittleEndian.PutUint32(sw.buf[8:], uint32(b.widths))
+		if _, err := sw.w.Write(sw.buf[:12]); err != nil {
+			return err
+		}
+	}
+	width := uint64(b.digestLen + 8)
+	binary.LittleEndian.PutUint32(sw.buf, uint32(width))
+	binary.LittleEndian.PutUint64(sw.buf[4:], b.count*width)
+	_, err := sw.w.Write(sw.buf[:12])
+	return err
+}
+
+// Close checks that every counted record has been written. It does not close the underlying
+// io.Writer.
+func (sw *MultihashIndexSortedWriter) Close() error {
+	if len(sw.buckets) == 0 {
+		return nil
+	}
+	if sw.current != len(sw.buckets)-1 || sw.written != sw.buckets[sw.current].count {
+		return errors.New("fewer records written than counted")
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Index         = (*MultihashSizedIndexSorted)(nil)
+	_ IterableIndex = (*MultihashSizedIndexSorted)(nil)
+	_ SizedIndex    = (*MultihashSizedIndexSorted)(nil)
+)
+
+// unknownSize is the size stored for records whose size is not known.
+const unknownSize = math.MaxUint64
+
+type (
+	// MultihashSizedIndexSorted is an index that stores the multihash, offset and size of each
+	// block, such that the size of blocks can be looked up without reading the CAR payload.
+	//
+	// Rather than the full section length, the size stored for each block is the length of its
+	// data within the section, i.e. the section length minus the length of its CID. Unlike the
+	// section length, the block size is determined by the multihash alone, regardless of the CID
+	// version or codec. The section length can be derived from it given the CID of the section.
+	//
+	// Records are grouped by the length of their multihash and each group is sorted by multihash.
+	// Records loaded with no Size are stored as such, and their size is reported as unknown by
+	// GetSize. The serial form starts with the total number of records, such that truncated
+	// indices are detected. Indices written without the number of records are still read.
+	MultihashSizedIndexSorted map[uint32]sizedSingleWidthIndex
+
+	// sizedSingleWidthIndex stores records of equal multihash length in compact form, where each
+	// record is the multihash followed by the little-endian offset and size.
+	sizedSingleWidthIndex struct {
+		width uint32
+		index []byte
+	}
+
+	sizedRecord struct {
+		mh     multihash.Multihash
+		offset uint64
+		size   uint64
+	}
+)
+
+// NewMultihashSizedSorted instantiates a new empty MultihashSizedIndexSorted.
+func NewMultihashSizedSorted() *MultihashSizedIndexSorted {
+	index := make(MultihashSizedIndexSorted)
+	return &index
+}
+
+func (s *sizedSingleWidthIndex) len() int {
+	return len(s.index) / int(s.width)
+}
+
+func (s *sizedSingleWidthIndex) record(i int) (mh []byte, offset uint64, size uint64) {
+	start := i * int(s.width)
+	end := start + int(s.width)
+	mhEnd := end - 16
+	mh = s.index[start:mhEnd]
+	offset = binary.LittleEndian.Uint64(s.index[mhEnd : mhEnd+8])
+	size = binary.LittleEndian.Uint64(s.index[mhEnd+8 : end])
+	return
+}
+
+// getAll calls fn for each record with the given multihash, in order, until fn returns false.
+func (s *sizedSingleWidthIndex) getAll(mh []byte, fn func(offset, size uint64) bool) bool {
+	l := s.len()
+	i := sort.Search(l, func(i int) bool {
+		got, _, _ := s.record(i)
+		return bytes.Compare(got, mh) >= 0
+	})
+	var any bool
+	for ; i < l; i++ {
+		got, offset, size := s.record(i)
+		if !bytes.Equal(got, mh) {
+			// No more matches; therefore, break.
+			break
+		}
+		any = true
+		if !fn(offset, size) {
+			// User signalled to stop searching; therefore, break.
+			break
+		}
+	}
+	return any
+}
+
+func (s *sizedSingleWidthIndex) Marshal(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.LittleEndian, s.width); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int64(len(s.index))); err != nil {
+		return 4, err
+	}
+	n, err := w.Write(s.index)
+	return 12 + int64(n), err
+}
+
+func (s *sizedSingleWidthIndex) Unmarshal(r io.Reader) error {
+	var width uint32
+	if err := binary.Read(r, binary.LittleEndian, &width); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var dataLen int64
+	if err := binary.Read(r, binary.LittleEndian, &dataLen); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	// Each record must at least contain a multihash code and length, followed by offset and size.
+	if width < 18 {
+		return malformedIndexError("width must be at least 18")
+	}
+	const maxWidth = 32 << 20 // 32MiB, to ~match the go-cid maximum
+	if width > maxWidth {
+		return malformedIndexError("sizedSingleWidthIndex width is larger than allowed maximum")
+	}
+	if dataLen < 0 {
+		return malformedIndexError("sizedSingleWidthIndex len is overflowing int64")
+	}
+	if dataLen%int64(width) != 0 {
+		return malformedIndexError("data length %d is not a multiple of width %d", dataLen, width)
+	}
+
+	buf, err := readDeclaredLength(r, uint64(dataLen))
+	if err != nil {
+		return err
+	}
+	s.width = width
+	s.index = buf
+	return nil
+}
+
+func (m *MultihashSizedIndexSorted) Codec() multicodec.Code {
+	return CarMultihashSizedIndexSorted
+}
+
+func (m *MultihashSizedIndexSorted) Marshal(w io.Writer) (int64, error) {
+	count, err := m.Count()
+	if err != nil {
+		return 0, err
+	}
+	l, err := writeCountedHeader(w, count)
+	if err != nil {
+		return l, err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int32(len(*m))); err != nil {
+		return l, err
+	}
+	l += 4
+	for _, width := range m.sortedWidths() {
+		bucket := (*m)[width]
+		n, err := bucket.Marshal(w)
+		l += n
+		if err != nil {
+			return l, err
+		}
+	}
+	return l, nil
+}
+
+func (m *MultihashSizedIndexSorted) Unmarshal(r io.Reader) error {
+	reader := internalio.ToByteReadSeeker(r)
+	l, count, counted, err := readCountedHeader(reader)
+	if err != nil {
+		return err
+	}
+	if l < 0 {
+		return malformedIndexError("MultihashSizedIndexSorted count is overflowing int32")
+	}
+	for i := 0; i < int(l); i++ {
+		var s sizedSingleWidthIndex
+		if err := s.Unmarshal(reader); err != nil {
+			return err
+		}
+		if _, ok := (*m)[s.width]; ok {
+			return malformedIndexError("MultihashSizedIndexSorted has duplicate buckets of width %d", s.width)
+		}
+		(*m)[s.width] = s
+	}
+	if counted {
+		return checkRecordCount(m, count)
+	}
+	return nil
+}
+
+func (m *MultihashSizedIndexSorted) Load(records []Record) error {
+	// Split records on the length of their multihash.
+	byWidth := make(map[uint32][]sizedRecord)
+	for _, record := range records {
+		mh := record.Cid.Hash()
+		size := uint64(unknownSize)
+		if record.Size != 0 {
+			cidLen := uint64(record.Cid.ByteLen())
+			if record.Size < cidLen {
+				return fmt.Errorf("invalid record size for %s: %d is smaller than its CID length %d", record.Cid, record.Size, cidLen)
+			}
+			size = record.Size - cidLen
+		}
+		width := uint32(len(mh)) + 16
+		byWidth[width] = append(byWidth[width], sizedRecord{mh, record.Offset, size})
+	}
+
+	// Sort each group deterministically, then write it in compact form.
+	for width, group := range byWidth {
+		sort.Slice(group, func(i, j int) bool {
+			if c := bytes.Compare(group[i].mh, group[j].mh); c != 0 {
+				return c < 0
+			}
+			return group[i].offset < group[j].offset
+		})
+		compact := make([]byte, int(width)*len(group))
+		for i, r := range group {
+			buf := compact[i*int(width) : (i+1)*int(width)]
+			n := copy(buf, r.mh)
+			binary.LittleEndian.PutUint64(buf[n:], r.offset)
+			binary.LittleEndian.PutUint64(buf[n+8:], r.size)
+		}
+		(*m)[width] = sizedSingleWidthIndex{width: width, index: compact}
+	}
+	return nil
+}
+
+func (m *MultihashSizedIndexSorted) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	found := m.getAll(c.Hash(), func(offset, _ uint64) bool {
+		return fn(offset)
+	})
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetSize returns the size of the data of the first block with the same multihash as c.
+// The returned bool is false if the block was indexed without a size.
+func (m *MultihashSizedIndexSorted) GetSize(c cid.Cid) (uint64, bool, error) {
+	var size uint64
+	found := m.getAll(c.Hash(), func(_, s uint64) bool {
+		size = s
+		return false
+	})
+	if !found {
+		return 0, false, ErrNotFound
+	}
+	if size == unknownSize {
+		return 0, false, nil
+	}
+	return size, true, nil
+}
+
+// ForEach calls f for every multihash and its associated offset stored by this index, ordered by
+// multihash length and then by multihash.
+func (m *MultihashSizedIndexSorted) ForEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	for _, width := range m.sortedWidths() {
+		bucket := (*m)[width]
+		for i := 0; i < bucket.len(); i++ {
+			mh, offset, _ := bucket.record(i)
+			if err := f(mh, offset); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Count returns the total number of records stored by this index.
+func (m *MultihashSizedIndexSorted) Count() (uint64, error) {
+	var count uint64
+	for _, bucket := range *m {
+		count += uint64(bucket.len())
+	}
+	return count, nil
+}
+
+// forEachSized calls f for every multihash, its associated offset and the size of its block data
+// stored by this index, in the same order as ForEach. The given bool is false if the size is not
+// known.
+func (m *MultihashSizedIndexSorted) forEachSized(f func(mh multihash.Multihash, offset uint64, size uint64, known bool) error) error {
+	for _, width := range m.sortedWidths() {
+		bucket := (*m)[width]
+		for i := 0; i < bucket.len(); i++ {
+			mh, offset, size := bucket.record(i)
+			if err := f(mh, offset, size, size != unknownSize); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MultihashSizedIndexSorted) getAll(mh multihash.Multihash, fn func(offset, size uint64) bool) bool {
+	bucket, ok := (*m)[uint32(len(mh))+16]
+	if !ok {
+		return false
+	}
+	return bucket.getAll(mh, fn)
+}
+
+func (m *MultihashSizedIndexSorted) sortedWidths() []uint32 {
+	widths := make([]uint32, 0, len(*m))
+	for width := range *m {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+	return widths
+}
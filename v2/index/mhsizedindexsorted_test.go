@@ -0,0 +1,132 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultihashSizedIndexSorted_Codec(t *testing.T) {
+	subject, err := index.New(index.CarMultihashSizedIndexSorted)
+	require.NoError(t, err)
+	require.Equal(t, index.CarMultihashSizedIndexSorted, subject.Codec())
+}
+
+func TestMultihashSizedIndexSorted_WriteToReadFrom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateSizedIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateSizedIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	subject := index.NewMultihashSizedSorted()
+	require.NoError(t, subject.Load(records))
+
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(subject, buf)
+	require.NoError(t, err)
+	got, err := index.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, subject, got)
+
+	requireContainsAll(t, got, records)
+	sized, ok := got.(index.SizedIndex)
+	require.True(t, ok)
+	for _, r := range records {
+		size, known, err := sized.GetSize(r.Cid)
+		require.NoError(t, err)
+		require.True(t, known)
+		require.Equal(t, r.Size-uint64(r.Cid.ByteLen()), size)
+	}
+}
+
+func TestMultihashSizedIndexSorted_RecordCount(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateSizedIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateSizedIndexRecords(t, multihash.SHA2_512, rng)...)
+
+	subject := index.NewMultihashSizedSorted()
+	require.NoError(t, subject.Load(records))
+	testRecordCount(t, subject, records)
+}
+
+func TestMultihashSizedIndexSorted_GetSizeIsUnknownForRecordsWithoutSize(t *testing.T) {
+	rng := rand.New(rand.NewSource(1414))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+
+	subject := index.NewMultihashSizedSorted()
+	require.NoError(t, subject.Load(records))
+
+	requireContainsAll(t, subject, records)
+	for _, r := range records {
+		_, known, err := subject.GetSize(r.Cid)
+		require.NoError(t, err)
+		require.False(t, known)
+	}
+
+	_, _, err := subject.GetSize(generateCidV1(t, multihash.SHA2_256, rng))
+	require.Equal(t, index.ErrNotFound, err)
+}
+
+func TestMultihashSizedIndexSorted_GetSizeIsIndependentOfCidEncoding(t *testing.T) {
+	rng := rand.New(rand.NewSource(1415))
+	v1 := generateCidV1(t, multihash.SHA2_256, rng)
+	v0 := cid.NewCidV0(v1.Hash())
+
+	subject := index.NewMultihashSizedSorted()
+	require.NoError(t, subject.Load([]index.Record{{Cid: v1, Offset: 1, Size: uint64(v1.ByteLen()) + 42}}))
+
+	for _, c := range []cid.Cid{v1, v0} {
+		size, known, err := subject.GetSize(c)
+		require.NoError(t, err)
+		require.True(t, known)
+		require.Equal(t, uint64(42), size)
+	}
+}
+
+func TestMultihashSizedIndexSorted_LoadFailsOnSizeSmallerThanCid(t *testing.T) {
+	rng := rand.New(rand.NewSource(1416))
+	c := generateCidV1(t, multihash.SHA2_256, rng)
+	subject := index.NewMultihashSizedSorted()
+	err := subject.Load([]index.Record{{Cid: c, Offset: 1, Size: 1}})
+	require.Error(t, err)
+}
+
+func TestMultihashSizedIndexSorted_IsConsistentWithMultihashIndexSorted(t *testing.T) {
+	rng := rand.New(rand.NewSource(1417))
+	records := generateSizedIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateSizedIndexRecords(t, multihash.IDENTITY, rng)...)
+
+	mhIdx, err := index.New(multicodec.CarMultihashIndexSorted)
+	require.NoError(t, err)
+	require.NoError(t, mhIdx.Load(records))
+	_, ok := mhIdx.(index.SizedIndex)
+	require.False(t, ok)
+
+	subject := index.NewMultihashSizedSorted()
+	require.NoError(t, subject.Load(records))
+
+	want := make(map[string]uint64)
+	require.NoError(t, mhIdx.(index.IterableIndex).ForEach(func(mh multihash.Multihash, offset uint64) error {
+		want[mh.String()] = offset
+		return nil
+	}))
+	got := make(map[string]uint64)
+	require.NoError(t, subject.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		got[mh.String()] = offset
+		return nil
+	}))
+	require.Equal(t, want, got)
+}
+
+func generateSizedIndexRecords(t *testing.T, hasherCode uint64, rng *rand.Rand) []index.Record {
+	records := generateIndexRecords(t, hasherCode, rng)
+	for i := range records {
+		records[i].Size = uint64(records[i].Cid.ByteLen()) + uint64(rng.Intn(1<<20))
+	}
+	return records
+}
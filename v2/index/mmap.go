@@ -0,0 +1,596 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Index          = (*mmapIndexSorted)(nil)
+	_ CountableIndex = (*mmapIndexSorted)(nil)
+	_ IterableIndex  = (*mmapMultihashIndexSorted)(nil)
+	_ CountableIndex = (*mmapMultihashIndexSorted)(nil)
+	_ IterableIndex  = (*mmapMultihashIndexHashed)(nil)
+	_ CountableIndex = (*mmapMultihashIndexHashed)(nil)
+)
+
+var errMmapIndexReadOnly = errors.New("index opened via OpenMmap is read-only")
+
+type (
+	// mmapSingleWidthIndex is the equivalent of singleWidthIndex that reads its records from r on
+	// demand, rather than holding them in memory.
+	mmapSingleWidthIndex struct {
+		r io.ReaderAt
+		// offset is the offset in r at which the first record starts.
+		offset int64
+		width  uint32
+		len    uint64
+	}
+	mmapMultiWidthIndex map[uint32]mmapSingleWidthIndex
+
+	// mmapIndexSorted is the equivalent of multicodec.CarIndexSorted opened via OpenMmap.
+	mmapIndexSorted struct {
+		widths mmapMultiWidthIndex
+		body   *io.SectionReader
+	}
+
+	// mmapMultihashIndexSorted is the equivalent of MultihashIndexSorted opened via OpenMmap.
+	mmapMultihashIndexSorted struct {
+		codes map[uint64]mmapMultiWidthIndex
+		body  *io.SectionReader
+	}
+
+	// mmapHashTable is the equivalent of hashTable that reads its slots from r on demand.
+	mmapHashTable struct {
+		r io.ReaderAt
+		// offset is the offset in r at which the first slot starts.
+		offset   int64
+		width    uint32
+		count    uint64
+		capacity uint64
+	}
+
+	// mmapMultihashIndexHashed is the equivalent of MultihashIndexHashed opened via OpenMmap.
+	mmapMultihashIndexHashed struct {
+		tables map[uint32]mmapHashTable
+		body   *io.SectionReader
+	}
+)
+
+// OpenMmap opens the serialized index of the given size read from r, as written by WriteTo,
+// without reading its records into memory. Only the headers of the index buckets are read upon
+// opening; lookups binary search over the records read directly from r. This makes OpenMmap
+// suitable for very large indices, particularly when r is backed by a memory-mapped file.
+//
+// The returned index answers lookups exactly as the index returned by ReadFrom would. It is
+// read-only: its Load and Unmarshal functions return an error. Marshal copies the index bytes
+// from r. Indices opened via OpenMmap are safe for concurrent use as long as r is.
+//
+// Only multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted and CarMultihashIndexHashed
+// codecs are supported; multicodec.CarMultihashIndexSorted and CarMultihashIndexHashed indices may
+// be type-asserted to IterableIndex. Lookups on CarMultihashIndexHashed indices probe the slots of
+// its hash tables read directly from r, rather than binary search.
+//
+// Checksummed indices, as written by WriteToWithChecksum, are verified upon opening by reading the
+// wrapped index sequentially, and ErrIndexChecksum is returned if the checksum does not match. The
+// wrapped index is then opened as above if its codec is supported, or otherwise read into memory
+// as by ReadFrom.
+func OpenMmap(r io.ReaderAt, size int64) (Index, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	reader, err := internalio.NewOffsetReadSeeker(sr, 0)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := ReadCodec(reader)
+	if err != nil {
+		return nil, err
+	}
+	bodyOffset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	body := io.NewSectionReader(sr, bodyOffset, size-bodyOffset)
+
+	switch codec {
+	case CarIndexChecksummed:
+		return openMmapChecksummed(sr, reader, size)
+	case multicodec.CarIndexSorted:
+		widths, err := readMmapMultiWidthIndex(sr, reader, size)
+		if err != nil {
+			return nil, err
+		}
+		return &mmapIndexSorted{widths: widths, body: body}, nil
+	case multicodec.CarMultihashIndexSorted:
+		var l int32
+		if err := binary.Read(reader, binary.LittleEndian, &l); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if l < 0 {
+			return nil, malformedIndexError("MultihashIndexSorted count is overflowing int32")
+		}
+		codes := make(map[uint64]mmapMultiWidthIndex)
+		for i := 0; i < int(l); i++ {
+			var code uint64
+			if err := binary.Read(reader, binary.LittleEndian, &code); err != nil {
+				if err == io.EOF {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			widths, err := readMmapMultiWidthIndex(sr, reader, size)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := codes[code]; ok {
+				return nil, malformedIndexError("MultihashIndexSorted has duplicate buckets of multihash code %d", code)
+			}
+			codes[code] = widths
+		}
+		return &mmapMultihashIndexSorted{codes: codes, body: body}, nil
+	case CarMultihashIndexHashed:
+		tables, err := readMmapHashTables(sr, reader, size)
+		if err != nil {
+			return nil, err
+		}
+		return &mmapMultihashIndexHashed{tables: tables, body: body}, nil
+	default:
+		return nil, fmt.Errorf("index codec %v cannot be opened via OpenMmap", codec)
+	}
+}
+
+// openMmapChecksummed opens the checksummed index read from r, once its checksum is verified.
+// The reader must be positioned right after the CarIndexChecksummed codec.
+func openMmapChecksummed(r io.ReaderAt, reader internalio.ReadSeekerAt, size int64) (Index, error) {
+	length, checksum, err := readChecksumHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(size-offset) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	wrapped := io.NewSectionReader(r, offset, int64(length))
+	if err := verifyChecksum(wrapped, length, checksum); err != nil {
+		return nil, err
+	}
+
+	codec, err := ReadCodec(io.NewSectionReader(wrapped, 0, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	switch codec {
+	case multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, CarMultihashIndexHashed:
+		return OpenMmap(wrapped, int64(length))
+	case CarIndexChecksummed:
+		return nil, malformedIndexError("checksummed index cannot wrap another checksummed index")
+	default:
+		return ReadFrom(io.NewSectionReader(wrapped, 0, int64(length)))
+	}
+}
+
+// readMmapMultiWidthIndex reads the bucket headers of a multiWidthIndex from reader, skipping over
+// the records of each bucket. The records are subsequently read from r on demand.
+func readMmapMultiWidthIndex(r io.ReaderAt, reader internalio.ReadSeekerAt, size int64) (mmapMultiWidthIndex, error) {
+	var l int32
+	if err := binary.Read(reader, binary.LittleEndian, &l); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if l < 0 {
+		return nil, malformedIndexError("multiWidthIndex count is overflowing int32")
+	}
+	m := make(mmapMultiWidthIndex)
+	for i := 0; i < int(l); i++ {
+		var width uint32
+		if err := binary.Read(reader, binary.LittleEndian, &width); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		var dataLen uint64
+		if err := binary.Read(reader, binary.LittleEndian, &dataLen); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		var s singleWidthIndex
+		if err := s.checkUnmarshalLengths(width, dataLen, 0); err != nil {
+			return nil, err
+		}
+		offset, err := reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if dataLen > uint64(size-offset) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if _, err := reader.Seek(int64(dataLen), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		if _, ok := m[s.width]; ok {
+			return nil, malformedIndexError("multiWidthIndex has duplicate buckets of width %d", s.width)
+		}
+		m[s.width] = mmapSingleWidthIndex{r: r, offset: offset, width: s.width, len: s.len}
+	}
+	return m, nil
+}
+
+// readRecord reads the i-th record into buf, which must be of the same length as s.width.
+func (s *mmapSingleWidthIndex) readRecord(i int, buf []byte) error {
+	n, err := s.r.ReadAt(buf, s.offset+int64(i)*int64(s.width))
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (s *mmapSingleWidthIndex) getAll(d []byte, fn func(uint64) bool) error {
+	buf := make([]byte, s.width)
+	digestEnd := int(s.width) - 8
+
+	var readErr error
+	idx := sort.Search(int(s.len), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		if readErr = s.readRecord(i, buf); readErr != nil {
+			return true
+		}
+		return bytes.Compare(d, buf[:digestEnd]) <= 0
+	})
+	if readErr != nil {
+		return readErr
+	}
+
+	var any bool
+	for ; uint64(idx) < s.len; idx++ {
+		if err := s.readRecord(idx, buf); err != nil {
+			return err
+		}
+		if !bytes.Equal(d, buf[:digestEnd]) {
+			// No more matches; therefore, break.
+			break
+		}
+		any = true
+		if !fn(binary.LittleEndian.Uint64(buf[digestEnd:])) {
+			// User signalled to stop searching; therefore, break.
+			break
+		}
+	}
+	if !any {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mmapSingleWidthIndex) forEachDigest(f func(digest []byte, offset uint64) error) error {
+	br := bufio.NewReader(io.NewSectionReader(s.r, s.offset, int64(s.len)*int64(s.width)))
+	buf := make([]byte, s.width)
+	digestEnd := int(s.width) - 8
+	for i := uint64(0); i < s.len; i++ {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return err
+		}
+		if err := f(buf[:digestEnd], binary.LittleEndian.Uint64(buf[digestEnd:])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m mmapMultiWidthIndex) getAll(c cid.Cid, fn func(uint64) bool) error {
+	d, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	if s, ok := m[uint32(len(d.Digest)+8)]; ok {
+		return s.getAll(d.Digest, fn)
+	}
+	return ErrNotFound
+}
+
+func (m mmapMultiWidthIndex) forEachDigest(f func(digest []byte, offset uint64) error) error {
+	widths := make([]uint32, 0, len(m))
+	for width := range m {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+	for _, width := range widths {
+		s := m[width]
+		if err := s.forEachDigest(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m mmapMultiWidthIndex) count() uint64 {
+	var count uint64
+	for _, s := range m {
+		count += s.len
+	}
+	return count
+}
+
+// readMmapHashTables reads the headers of the hash tables of a MultihashIndexHashed from reader,
+// skipping over their slots. The slots are subsequently read from r on demand.
+func readMmapHashTables(r io.ReaderAt, reader internalio.ReadSeekerAt, size int64) (map[uint32]mmapHashTable, error) {
+	var l int32
+	if err := binary.Read(reader, binary.LittleEndian, &l); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if l < 0 {
+		return nil, malformedIndexError("MultihashIndexHashed count is overflowing int32")
+	}
+	tables := make(map[uint32]mmapHashTable)
+	for i := 0; i < int(l); i++ {
+		var width uint32
+		if err := binary.Read(reader, binary.LittleEndian, &width); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		var count uint64
+		if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		var dataLen uint64
+		if err := binary.Read(reader, binary.LittleEndian, &dataLen); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		capacity, err := checkHashTableLengths(width, count, dataLen)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if dataLen > uint64(size-offset) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if _, err := reader.Seek(int64(dataLen), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		if _, ok := tables[width]; ok {
+			return nil, malformedIndexError("MultihashIndexHashed has duplicate hash tables of width %d", width)
+		}
+		tables[width] = mmapHashTable{r: r, offset: offset, width: width, count: count, capacity: capacity}
+	}
+	return tables, nil
+}
+
+// readSlot reads the i-th slot into buf, which must be of the same length as t.width.
+// The returned bool is false if the slot is empty.
+func (t *mmapHashTable) readSlot(i uint64, buf []byte) (bool, error) {
+	n, err := t.r.ReadAt(buf, t.offset+int64(i)*int64(t.width))
+	if n != len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+	return binary.LittleEndian.Uint64(buf[t.width-8:]) != 0, nil
+}
+
+func (t *mmapHashTable) getAll(mh []byte, fn func(uint64) bool) error {
+	buf := make([]byte, t.width)
+	mhEnd := t.width - 8
+	mask := t.capacity - 1
+	i := hashMultihash(mh) & mask
+	var any bool
+	// Probe at most capacity slots, in case the table has no empty slots.
+	for probed := uint64(0); probed < t.capacity; probed++ {
+		occupied, err := t.readSlot(i, buf)
+		if err != nil {
+			return err
+		}
+		if !occupied {
+			// Reached the end of the probe sequence; therefore, break.
+			break
+		}
+		if bytes.Equal(buf[:mhEnd], mh) {
+			any = true
+			if !fn(binary.LittleEndian.Uint64(buf[mhEnd:]) - 1) {
+				// User signalled to stop searching; therefore, break.
+				break
+			}
+		}
+		i = (i + 1) & mask
+	}
+	if !any {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (t *mmapHashTable) forEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	br := bufio.NewReader(io.NewSectionReader(t.r, t.offset, int64(t.capacity)*int64(t.width)))
+	buf := make([]byte, t.width)
+	mhEnd := t.width - 8
+	for i := uint64(0); i < t.capacity; i++ {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return err
+		}
+		value := binary.LittleEndian.Uint64(buf[mhEnd:])
+		if value == 0 {
+			continue
+		}
+		if err := f(buf[:mhEnd], value-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalMmapBody copies the serialized index, excluding its codec, from body into w.
+func marshalMmapBody(body *io.SectionReader, w io.Writer) (int64, error) {
+	n, err := io.Copy(w, io.NewSectionReader(body, 0, body.Size()))
+	return int64(n), err
+}
+
+func (m *mmapIndexSorted) Codec() multicodec.Code {
+	return multicodec.CarIndexSorted
+}
+
+func (m *mmapIndexSorted) Marshal(w io.Writer) (int64, error) {
+	return marshalMmapBody(m.body, w)
+}
+
+func (m *mmapIndexSorted) Unmarshal(io.Reader) error {
+	return errMmapIndexReadOnly
+}
+
+func (m *mmapIndexSorted) Load([]Record) error {
+	return errMmapIndexReadOnly
+}
+
+func (m *mmapIndexSorted) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	return m.widths.getAll(c, fn)
+}
+
+func (m *mmapIndexSorted) Count() (uint64, error) {
+	return m.widths.count(), nil
+}
+
+func (m *mmapMultihashIndexSorted) Codec() multicodec.Code {
+	return multicodec.CarMultihashIndexSorted
+}
+
+func (m *mmapMultihashIndexSorted) Marshal(w io.Writer) (int64, error) {
+	return marshalMmapBody(m.body, w)
+}
+
+func (m *mmapMultihashIndexSorted) Unmarshal(io.Reader) error {
+	return errMmapIndexReadOnly
+}
+
+func (m *mmapMultihashIndexSorted) Load([]Record) error {
+	return errMmapIndexReadOnly
+}
+
+func (m *mmapMultihashIndexSorted) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	widths, ok := m.codes[dmh.Code]
+	if !ok {
+		return ErrNotFound
+	}
+	return widths.getAll(c, fn)
+}
+
+func (m *mmapMultihashIndexSorted) Count() (uint64, error) {
+	var count uint64
+	for _, widths := range m.codes {
+		count += widths.count()
+	}
+	return count, nil
+}
+
+// ForEach calls f for every multihash and its associated offset stored by this index, in the same
+// order as MultihashIndexSorted.ForEach.
+func (m *mmapMultihashIndexSorted) ForEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	codes := make([]uint64, 0, len(m.codes))
+	for code := range m.codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		err := m.codes[code].forEachDigest(func(digest []byte, offset uint64) error {
+			mh, err := multihash.Encode(digest, code)
+			if err != nil {
+				return err
+			}
+			return f(mh, offset)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mmapMultihashIndexHashed) Codec() multicodec.Code {
+	return CarMultihashIndexHashed
+}
+
+func (m *mmapMultihashIndexHashed) Marshal(w io.Writer) (int64, error) {
+	return marshalMmapBody(m.body, w)
+}
+
+func (m *mmapMultihashIndexHashed) Unmarshal(io.Reader) error {
+	return errMmapIndexReadOnly
+}
+
+func (m *mmapMultihashIndexHashed) Load([]Record) error {
+	return errMmapIndexReadOnly
+}
+
+func (m *mmapMultihashIndexHashed) GetAll(c cid.Cid, fn func(uint64) bool) error {
+	mh := c.Hash()
+	table, ok := m.tables[hashTableWidth(mh)]
+	if !ok {
+		return ErrNotFound
+	}
+	return table.getAll(mh, fn)
+}
+
+func (m *mmapMultihashIndexHashed) Count() (uint64, error) {
+	var count uint64
+	for _, table := range m.tables {
+		count += table.count
+	}
+	return count, nil
+}
+
+// ForEach calls f for every multihash and its associated offset stored by this index, in the same
+// order as MultihashIndexHashed.ForEach.
+func (m *mmapMultihashIndexHashed) ForEach(f func(mh multihash.Multihash, offset uint64) error) error {
+	widths := make([]uint32, 0, len(m.tables))
+	for width := range m.tables {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+	for _, width := range widths {
+		table := m.tables[width]
+		if err := table.forEach(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMmap_IsConsistentWithReadFrom(t *testing.T) {
+	for _, codec := range []multicodec.Code{multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, index.CarMultihashIndexHashed} {
+		t.Run(codec.String(), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1413))
+			records := generateIndexRecords(t, multihash.SHA2_256, rng)
+			records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+			// Add records with duplicate multihashes but different offsets.
+			for _, r := range records[:10] {
+				records = append(records, index.Record{Cid: r.Cid, Offset: rng.Uint64()})
+			}
+
+			subject, err := index.New(codec)
+			require.NoError(t, err)
+			require.NoError(t, subject.Load(records))
+			buf := new(bytes.Buffer)
+			_, err = index.WriteTo(subject, buf)
+			require.NoError(t, err)
+			serialized := buf.Bytes()
+
+			want, err := index.ReadFrom(bytes.NewReader(serialized))
+			require.NoError(t, err)
+			got, err := index.OpenMmap(bytes.NewReader(serialized), int64(len(serialized)))
+			require.NoError(t, err)
+			require.Equal(t, want.Codec(), got.Codec())
+
+			// Look up every indexed key, along with random keys that are not indexed, including
+			// ones with multihash codes and digest lengths that are not present in the index.
+			keys := make([]cid.Cid, 0, len(records)+300)
+			for _, r := range records {
+				keys = append(keys, r.Cid)
+			}
+			for i := 0; i < 100; i++ {
+				keys = append(keys,
+					generateCidV1(t, multihash.SHA2_256, rng),
+					generateCidV1(t, multihash.SHA2_512, rng),
+					generateCidV1(t, multihash.SHA3_224, rng))
+			}
+			for _, key := range keys {
+				wantOffsets, wantErr := getAllOffsets(want, key)
+				gotOffsets, gotErr := getAllOffsets(got, key)
+				require.Equal(t, wantErr, gotErr)
+				require.Equal(t, wantOffsets, gotOffsets)
+
+				wantFirst, wantErr := index.GetFirst(want, key)
+				gotFirst, gotErr := index.GetFirst(got, key)
+				require.Equal(t, wantErr, gotErr)
+				require.Equal(t, wantFirst, gotFirst)
+			}
+
+			// Assert the index marshals identically.
+			wantMarshalled := new(bytes.Buffer)
+			_, err = index.WriteTo(want, wantMarshalled)
+			require.NoError(t, err)
+			gotMarshalled := new(bytes.Buffer)
+			_, err = index.WriteTo(got, gotMarshalled)
+			require.NoError(t, err)
+			require.Equal(t, wantMarshalled.Bytes(), gotMarshalled.Bytes())
+
+			// Assert the index iterates identically, if iterable.
+			wantIterable, ok := want.(index.IterableIndex)
+			if !ok {
+				_, ok = got.(index.IterableIndex)
+				require.False(t, ok)
+				return
+			}
+			gotIterable, ok := got.(index.IterableIndex)
+			require.True(t, ok)
+			require.Equal(t, forEachRecord(t, wantIterable), forEachRecord(t, gotIterable))
+		})
+	}
+}
+
+func TestOpenMmap_TruncatedIndexIsError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewMultihashSorted()
+	require.NoError(t, subject.Load(generateIndexRecords(t, multihash.SHA2_256, rng)))
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(subject, buf)
+	require.NoError(t, err)
+	serialized := buf.Bytes()
+
+	for size := 0; size < len(serialized); size++ {
+		_, err := index.OpenMmap(bytes.NewReader(serialized), int64(size))
+		require.Error(t, err, "expected error when truncated to %d bytes", size)
+	}
+}
+
+func TestOpenMmap_UnsupportedCodecIsError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	subject := index.NewCidSorted()
+	require.NoError(t, subject.Load(generateIndexRecords(t, multihash.SHA2_256, rng)))
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(subject, buf)
+	require.NoError(t, err)
+
+	_, err = index.OpenMmap(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Error(t, err)
+}
+
+func TestOpenMmap_IsReadOnly(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := index.WriteTo(index.NewMultihashSorted(), buf)
+	require.NoError(t, err)
+	subject, err := index.OpenMmap(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(1413))
+	require.Error(t, subject.Load(generateIndexRecords(t, multihash.SHA2_256, rng)))
+	require.Error(t, subject.Unmarshal(bytes.NewReader(buf.Bytes())))
+}
+
+func getAllOffsets(idx index.Index, key cid.Cid) ([]uint64, error) {
+	var offsets []uint64
+	err := idx.GetAll(key, func(offset uint64) bool {
+		offsets = append(offsets, offset)
+		return true
+	})
+	return offsets, err
+}
+
+func forEachRecord(t *testing.T, idx index.IterableIndex) []index.Record {
+	var records []index.Record
+	err := idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+		records = append(records, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: offset})
+		return nil
+	})
+	require.NoError(t, err)
+	return records
+}
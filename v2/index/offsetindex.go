@@ -0,0 +1,105 @@
+package index
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/multiformats/go-varint"
+)
+
+var _ OffsetIndex = (*offsetLookupIndex)(nil)
+
+// offsetLookupIndex is an OffsetIndex that wraps an IterableIndex, looking up offsets via binary
+// search over the records of the index ordered by offset.
+type offsetLookupIndex struct {
+	IterableIndex
+
+	// mu guards records, which are computed on first use via OffsetOrdered, and discarded when
+	// records are loaded into the index.
+	mu      sync.Mutex
+	records []Record
+	built   bool
+}
+
+// WithOffsetLookup wraps the given index such that it satisfies OffsetIndex. The records of the
+// index are ordered by offset on the first call to FindByOffset or ForEachOffsetOrder, holding
+// every record of the index in memory, after which offsets are looked up via binary search.
+// Records loaded via the Load method of the returned index cause the ordering to be computed again
+// on its next use.
+// The returned index has the same codec, and is written the same way, as the wrapped index.
+// Indices that already satisfy OffsetIndex, e.g. InsertionIndex, are returned as is.
+//
+// Since the ordering is only computed again when records are loaded via the returned index, the
+// wrapped index must not be modified otherwise.
+func WithOffsetLookup(idx IterableIndex) OffsetIndex {
+	if oi, ok := idx.(OffsetIndex); ok {
+		return oi
+	}
+	return &offsetLookupIndex{IterableIndex: idx}
+}
+
+func (oi *offsetLookupIndex) Load(records []Record) error {
+	oi.mu.Lock()
+	defer oi.mu.Unlock()
+	oi.records, oi.built = nil, false
+	return oi.IterableIndex.Load(records)
+}
+
+// ordered returns the records of the wrapped index ordered by offset, computing them if needed.
+// The returned slice is never modified, such that it may be used once mu is released.
+func (oi *offsetLookupIndex) ordered() ([]Record, error) {
+	oi.mu.Lock()
+	defer oi.mu.Unlock()
+	if !oi.built {
+		records, err := OffsetOrdered(oi.IterableIndex)
+		if err != nil {
+			return nil, err
+		}
+		oi.records, oi.built = records, true
+	}
+	return oi.records, nil
+}
+
+func (oi *offsetLookupIndex) FindByOffset(offset uint64) (Record, bool, error) {
+	records, err := oi.ordered()
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	// Find the first of the records with the greatest offset that is at most the given one.
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Offset > offset
+	}) - 1
+	if i < 0 {
+		return Record{}, false, nil
+	}
+	for i > 0 && records[i-1].Offset == records[i].Offset {
+		i--
+	}
+	r := records[i]
+	return r, sectionContains(r, offset), nil
+}
+
+// ForEachOffsetOrder calls f with each record of the wrapped index in ascending order of offset;
+// see OffsetIndex. The ordering is shared with FindByOffset, and computed on first use of either.
+func (oi *offsetLookupIndex) ForEachOffsetOrder(f func(Record) error) error {
+	records, err := oi.ordered()
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sectionContains checks whether the given offset, which is at least the offset of the given
+// record, is within the section of the record. Sections of unknown size are assumed to contain it.
+func sectionContains(r Record, offset uint64) bool {
+	if r.Size == 0 {
+		return true
+	}
+	return offset-r.Offset < uint64(varint.UvarintSize(r.Size))+r.Size
+}
@@ -0,0 +1,149 @@
+package index_test
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithOffsetLookup(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+
+	// Lay out the records as consecutive sections, with a gap of unindexed bytes in between.
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	next := uint64(100)
+	for i := range records {
+		records[i].Size = uint64(records[i].Cid.ByteLen() + rng.Intn(1000))
+		records[i].Offset = next
+		next += uint64(varint.UvarintSize(records[i].Size)) + records[i].Size + uint64(rng.Intn(2)*10)
+	}
+	last := records[len(records)-1]
+	lastEnd := last.Offset + uint64(varint.UvarintSize(last.Size)) + last.Size
+
+	tests := []struct {
+		name string
+		new  func(t *testing.T) index.OffsetIndex
+		// sized is whether the index stores sizes, such that offsets past the end of a section
+		// are not found.
+		sized bool
+	}{
+		{"MultihashIndexSorted", newOffsetIndex(multicodec.CarMultihashIndexSorted), false},
+		{"CidIndexSorted", newOffsetIndex(index.CarCidIndexSorted), false},
+		{"MultihashIndexHashed", newOffsetIndex(index.CarMultihashIndexHashed), false},
+		{"MultihashSizedIndexSorted", newOffsetIndex(index.CarMultihashSizedIndexSorted), true},
+		{"InsertionIndex", func(t *testing.T) index.OffsetIndex {
+			ii := index.NewInsertionIndex()
+			require.Same(t, ii, index.WithOffsetLookup(ii))
+			return ii
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject := tt.new(t)
+			_, found, err := subject.FindByOffset(last.Offset)
+			require.NoError(t, err)
+			require.False(t, found)
+
+			// Assert the ordering is computed again once records are loaded.
+			require.NoError(t, subject.Load(records))
+
+			for _, r := range records {
+				end := r.Offset + uint64(varint.UvarintSize(r.Size)) + r.Size
+				for _, at := range []uint64{r.Offset, (r.Offset + end) / 2, end - 1} {
+					got, found, err := subject.FindByOffset(at)
+					require.NoError(t, err)
+					require.True(t, found)
+					require.Equal(t, r.Offset, got.Offset)
+					require.Equal(t, r.Cid.Hash(), got.Cid.Hash())
+				}
+			}
+
+			_, found, err = subject.FindByOffset(records[0].Offset - 1)
+			require.NoError(t, err)
+			require.False(t, found)
+
+			// Assert offsets past the end of the last section are only found if sizes are unknown,
+			// and that the last record is returned either way.
+			got, found, err := subject.FindByOffset(lastEnd + 5)
+			require.NoError(t, err)
+			require.Equal(t, !tt.sized, found)
+			require.Equal(t, last.Offset, got.Offset)
+		})
+	}
+}
+
+func newOffsetIndex(codec multicodec.Code) func(t *testing.T) index.OffsetIndex {
+	return func(t *testing.T) index.OffsetIndex {
+		idx, err := index.New(codec)
+		require.NoError(t, err)
+		return index.WithOffsetLookup(idx.(index.IterableIndex))
+	}
+}
+
+func TestForEachOffsetOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+
+	// Include records of duplicate CIDs at distinct offsets, and of distinct CIDs at the same offset.
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	for i := 0; i < 10; i++ {
+		records = append(records,
+			index.Record{Cid: records[i].Cid, Offset: rng.Uint64()},
+			index.Record{Cid: generateCidV1(t, multihash.SHA2_256, rng), Offset: records[i].Offset})
+	}
+
+	for _, tt := range []struct {
+		name string
+		new  func(t *testing.T) index.OffsetIndex
+	}{
+		{"MultihashIndexSorted", newOffsetIndex(multicodec.CarMultihashIndexSorted)},
+		{"CidIndexSorted", newOffsetIndex(index.CarCidIndexSorted)},
+		{"MultihashIndexHashed", newOffsetIndex(index.CarMultihashIndexHashed)},
+		{"MultihashSizedIndexSorted", newOffsetIndex(index.CarMultihashSizedIndexSorted)},
+		{"InsertionIndex", func(t *testing.T) index.OffsetIndex { return index.NewInsertionIndex() }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			subject := tt.new(t)
+			require.NoError(t, subject.ForEachOffsetOrder(func(index.Record) error {
+				require.Fail(t, "expected no records")
+				return nil
+			}))
+
+			// Assert the ordering is computed again once records are loaded.
+			require.NoError(t, subject.Load(records))
+			var count int
+			require.NoError(t, subject.ForEach(func(multihash.Multihash, uint64) error {
+				count++
+				return nil
+			}))
+
+			var got []index.Record
+			require.NoError(t, subject.ForEachOffsetOrder(func(r index.Record) error {
+				got = append(got, r)
+				return nil
+			}))
+			require.Len(t, got, count)
+			want, err := index.OffsetOrdered(subject)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+			for i := 1; i < len(got); i++ {
+				require.LessOrEqual(t, got[i-1].Offset, got[i].Offset)
+			}
+
+			// Assert the iteration stops at the first error.
+			stop := errors.New("stop")
+			var visited int
+			err = subject.ForEachOffsetOrder(func(index.Record) error {
+				visited++
+				return stop
+			})
+			require.Equal(t, stop, err)
+			require.Equal(t, 1, visited)
+		})
+	}
+}
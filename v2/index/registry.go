@@ -0,0 +1,60 @@
+package index
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/multiformats/go-multicodec"
+)
+
+// registry holds the constructors of the index codecs registered via RegisterCodec.
+var registry = struct {
+	sync.RWMutex
+	codecs map[multicodec.Code]func() Index
+}{codecs: make(map[multicodec.Code]func() Index)}
+
+// RegisterCodec registers the constructor of an index with the given codec, such that indices of
+// that codec are instantiated by New and decoded by ReadFrom, and therefore by the blockstores
+// reading CARv2 files with such an index embedded in them. The constructor must return an empty
+// index whose Codec is the given code, and whose Unmarshal decodes what its Marshal encodes.
+// Since WriteTo writes any index via its Codec and Marshal, no registration is needed to write
+// indices.
+//
+// RegisterCodec is typically called from an init function, and is safe for concurrent use.
+// It panics if the constructor is nil, if the code is one of the codecs defined by this package,
+// or if the code is already registered; a codec can only be registered once.
+func RegisterCodec(code multicodec.Code, unmarshaler func() Index) {
+	if unmarshaler == nil {
+		panic(fmt.Sprintf("index: nil constructor registered for codec %v", code))
+	}
+	if isBuiltinCodec(code) {
+		panic(fmt.Sprintf("index: codec %v is defined by this package and cannot be registered", code))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.codecs[code]; ok {
+		panic(fmt.Sprintf("index: codec %v is already registered", code))
+	}
+	registry.codecs[code] = unmarshaler
+}
+
+// registered returns the constructor registered for the given codec, if any.
+func registered(code multicodec.Code) (func() Index, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	unmarshaler, ok := registry.codecs[code]
+	return unmarshaler, ok
+}
+
+// isBuiltinCodec checks whether the given code is reserved by this package, either as an index
+// codec or as a sentinel value.
+func isBuiltinCodec(code multicodec.Code) bool {
+	switch code {
+	case CarIndexNone, CarIndexChecksummed, insertionIndexCodec,
+		multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted,
+		CarCidIndexSorted, CarMultihashSizedIndexSorted, CarMultihashIndexHashed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,153 @@
+package index_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+// customIndex is an index of a codec defined outside of the index package, which is serialized
+// identically to multicodec.CarMultihashIndexSorted.
+type customIndex struct {
+	*index.MultihashIndexSorted
+	code multicodec.Code
+}
+
+func (c customIndex) Codec() multicodec.Code {
+	return c.code
+}
+
+func newCustomIndexConstructor(code multicodec.Code) func() index.Index {
+	return func() index.Index {
+		return customIndex{MultihashIndexSorted: index.NewMultihashSorted(), code: code}
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	const code = multicodec.Code(0x300100)
+
+	// Assert the codec is unknown before it is registered.
+	_, err := index.New(code)
+	var unknown *index.ErrUnknownIndexCodec
+	require.True(t, errors.As(err, &unknown))
+	require.Equal(t, code, unknown.Code)
+
+	index.RegisterCodec(code, newCustomIndexConstructor(code))
+
+	// Assert the index round-trips via WriteTo and ReadFrom.
+	path := filepath.Join(t.TempDir(), "custom-index.car")
+	src, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, src.Close()) })
+	dst, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, carv2.WrapV1(src, dst, carv2.UseIndexCodec(code)))
+	require.NoError(t, dst.Close())
+	cr, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, cr.Close()) })
+	ir, err := cr.IndexReader()
+	require.NoError(t, err)
+	got, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	require.Equal(t, code, got.Codec())
+	require.IsType(t, customIndex{}, got)
+
+	var buf bytes.Buffer
+	_, err = index.WriteTo(got, &buf)
+	require.NoError(t, err)
+	roundTripped, err := index.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, got, roundTripped)
+
+	// Assert blocks are looked up via the embedded index by the blockstore.
+	bs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, bs.Close()) })
+	roots, err := bs.Roots()
+	require.NoError(t, err)
+	for _, root := range roots {
+		has, err := bs.Has(context.TODO(), root)
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+}
+
+func TestRegisterCodec_IsError(t *testing.T) {
+	const code = multicodec.Code(0x300101)
+	index.RegisterCodec(code, newCustomIndexConstructor(code))
+
+	require.Panics(t, func() { index.RegisterCodec(code, newCustomIndexConstructor(code)) })
+	require.Panics(t, func() { index.RegisterCodec(0x300102, nil) })
+	for _, builtin := range []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+		index.CarIndexChecksummed,
+		index.CarIndexNone,
+	} {
+		require.Panics(t, func() { index.RegisterCodec(builtin, newCustomIndexConstructor(builtin)) })
+	}
+
+	// Assert unregistered codecs found in serialized indices are typed errors.
+	var buf bytes.Buffer
+	_, err := index.WriteTo(customIndex{MultihashIndexSorted: index.NewMultihashSorted(), code: 0x300103}, &buf)
+	require.NoError(t, err)
+	_, err = index.ReadFrom(&buf)
+	var unknown *index.ErrUnknownIndexCodec
+	require.True(t, errors.As(err, &unknown))
+	require.Equal(t, multicodec.Code(0x300103), unknown.Code)
+}
+
+func TestRegisterCodec_IsSafeForConcurrentUse(t *testing.T) {
+	const (
+		first = multicodec.Code(0x300200)
+		count = 64
+	)
+	var wg sync.WaitGroup
+	panics := make(chan interface{}, 2*count)
+	for i := 0; i < count; i++ {
+		code := first + multicodec.Code(i)
+		// Register every codec twice concurrently, while looking it up.
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if r := recover(); r != nil {
+						panics <- r
+					}
+				}()
+				index.RegisterCodec(code, newCustomIndexConstructor(code))
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = index.New(code)
+		}()
+	}
+	wg.Wait()
+	close(panics)
+
+	// Assert exactly one of the two registrations of each codec panicked.
+	require.Len(t, panics, count)
+	for i := 0; i < count; i++ {
+		code := first + multicodec.Code(i)
+		idx, err := index.New(code)
+		require.NoError(t, err)
+		require.Equal(t, code, idx.Codec())
+	}
+}
@@ -0,0 +1,71 @@
+package index
+
+import "io"
+
+var (
+	_ io.WriterTo   = (*Serialized)(nil)
+	_ io.ReaderFrom = (*Serialized)(nil)
+)
+
+// Serialized adapts an index to the standard io.WriterTo and io.ReaderFrom interfaces, in the
+// serialization format of WriteTo and ReadFrom, such that the number of bytes an index spans in a
+// stream is known without measuring it again, e.g. to compute the offset of whatever follows it.
+//
+// The CARv2 index formats are shared with other implementations, and are therefore left as is;
+// Serialized only changes how the byte counts are surfaced.
+type Serialized struct {
+	// Index is the index written by WriteTo, and set by ReadFrom.
+	Index Index
+}
+
+// WriteTo writes s.Index to w as index.WriteTo does, and returns the number of bytes written.
+func (s *Serialized) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(s.Index, w)
+}
+
+// ReadFrom reads an index from r as index.ReadFrom does, sets it as s.Index, and returns the
+// number of bytes read from r. See ReadFromCounted.
+func (s *Serialized) ReadFrom(r io.Reader) (int64, error) {
+	idx, n, err := ReadFromCounted(r)
+	if err != nil {
+		return n, err
+	}
+	s.Index = idx
+	return n, nil
+}
+
+// ReadFromCounted reads an index from r as ReadFrom does, and returns the number of bytes read
+// from r along with it, even if reading fails.
+//
+// The indices defined by this package are decoded without reading past their end, such that the
+// count is exactly the number of bytes the index spans, and r is left positioned right after it.
+// Codecs registered via RegisterCodec must do the same for the count to be exact.
+func ReadFromCounted(r io.Reader) (Index, int64, error) {
+	cr := &countingReader{r: r}
+	idx, err := ReadFrom(cr)
+	return idx, cr.n, err
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
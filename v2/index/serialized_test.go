@@ -0,0 +1,71 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerialized(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	trailer := []byte("followed by something else")
+
+	for _, codec := range []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	} {
+		t.Run(codec.String(), func(t *testing.T) {
+			idx, err := index.NewFromRecords(codec, records)
+			require.NoError(t, err)
+			want := marshalIndex(t, idx)
+
+			for _, checksummed := range []bool{false, true} {
+				var buf bytes.Buffer
+				var written int64
+				if checksummed {
+					written, err = index.WriteToWithChecksum(idx, &buf)
+					require.NoError(t, err)
+				} else {
+					written, err = (&index.Serialized{Index: idx}).WriteTo(&buf)
+					require.NoError(t, err)
+					require.Equal(t, want, buf.Bytes())
+				}
+				require.Equal(t, int64(buf.Len()), written)
+				buf.Write(trailer)
+
+				// Assert the bytes read are exactly those of the index, leaving the trailer as is.
+				var subject index.Serialized
+				read, err := subject.ReadFrom(&buf)
+				require.NoError(t, err)
+				require.Equal(t, written, read)
+				require.Equal(t, trailer, buf.Bytes())
+				require.Equal(t, want, marshalIndex(t, subject.Index))
+			}
+		})
+	}
+}
+
+func TestReadFromCounted_CountsBytesReadOnError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	idx, err := index.NewFromRecords(multicodec.CarMultihashIndexSorted, generateIndexRecords(t, multihash.SHA2_256, rng))
+	require.NoError(t, err)
+	serialized := marshalIndex(t, idx)
+	truncated := serialized[:len(serialized)-1]
+
+	var subject index.Serialized
+	read, err := subject.ReadFrom(bytes.NewReader(truncated))
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	require.Nil(t, subject.Index)
+	require.Equal(t, int64(len(truncated)), read)
+}
@@ -0,0 +1,97 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// maxSplitParts is the maximum number of parts an index can be split into, i.e. the number of
+// distinct first bytes of a digest.
+const maxSplitParts = 256
+
+// Split partitions the records of src into the given number of indices of the same codec, by the
+// first byte of the digest of their multihash. The space of first bytes is divided into parts
+// contiguous ranges of as equal widths as possible, such that the i-th index holds the records
+// whose digests start with a byte b where b*parts/256 == i. Records with empty digests are held
+// by the first index. Offsets are left unchanged, and the source index is left unmodified.
+//
+// Since records are partitioned by digest rather than by count, the resulting indices may be of
+// uneven sizes, and some of them may be empty, e.g. when parts is larger than the number of
+// distinct first bytes. Every resulting index can be written via WriteTo, and read back via
+// ReadFrom, regardless. Join is the inverse of Split.
+//
+// The number of parts must be between 1 and 256. The codec of src must be one that New can
+// construct; in particular, an InsertionIndex must be flattened before it is split.
+func Split(src IterableIndex, parts int) ([]Index, error) {
+	if parts < 1 || parts > maxSplitParts {
+		return nil, fmt.Errorf("number of parts must be between 1 and %d; got %d", maxSplitParts, parts)
+	}
+	records, err := transcodeRecords(src)
+	if err != nil {
+		return nil, err
+	}
+	byPart := make([][]Record, parts)
+	for _, r := range records {
+		dmh, err := multihash.Decode(r.Cid.Hash())
+		if err != nil {
+			return nil, err
+		}
+		var part int
+		if len(dmh.Digest) > 0 {
+			part = int(dmh.Digest[0]) * parts / maxSplitParts
+		}
+		byPart[part] = append(byPart[part], r)
+	}
+
+	shards := make([]Index, parts)
+	for i, records := range byPart {
+		shard, err := New(src.Codec())
+		if err != nil {
+			return nil, err
+		}
+		if err := shard.Load(records); err != nil {
+			return nil, err
+		}
+		shards[i] = shard
+	}
+	return shards, nil
+}
+
+// Join merges the records of the given indices into a single index of their codec, leaving their
+// offsets unchanged. It is the inverse of Split: joining the indices returned by Split results in
+// an index that is written identically to the split index via WriteTo.
+//
+// At least one index must be given, and all of them must be iterable and of the same codec. Unlike
+// Split, the records of the given indices are not required to be partitioned in any way.
+func Join(shards []Index) (Index, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("at least one index must be given")
+	}
+	codec := shards[0].Codec()
+	var records []Record
+	for _, shard := range shards {
+		if shard.Codec() != codec {
+			return nil, fmt.Errorf("cannot join indices of different codecs %s and %s", codec, shard.Codec())
+		}
+		iterable, ok := shard.(IterableIndex)
+		if !ok {
+			return nil, fmt.Errorf("index with codec %s is not iterable", codec)
+		}
+		shardRecords, err := transcodeRecords(iterable)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, shardRecords...)
+	}
+
+	joined, err := New(codec)
+	if err != nil {
+		return nil, err
+	}
+	if err := joined.Load(records); err != nil {
+		return nil, err
+	}
+	return joined, nil
+}
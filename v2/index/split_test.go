@@ -0,0 +1,96 @@
+package index_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitAndJoin(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	// Duplicate a record with a different offset.
+	records = append(records, index.Record{Cid: records[0].Cid, Offset: records[0].Offset + 1})
+
+	codecs := []multicodec.Code{
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	}
+	for _, codec := range codecs {
+		for _, tt := range []struct {
+			name    string
+			records []index.Record
+			parts   int
+		}{
+			{name: "OnePart", records: records, parts: 1},
+			{name: "UnevenParts", records: records, parts: 7},
+			{name: "PartPerPrefix", records: records, parts: 256},
+			// More parts than distinct prefixes, such that most parts are empty.
+			{name: "MostlyEmptyParts", records: records[:3], parts: 256},
+		} {
+			t.Run(codec.String()+"/"+tt.name, func(t *testing.T) {
+				src := newLoadedIndex(t, codec, tt.records, false).(index.IterableIndex)
+				shards, err := index.Split(src, tt.parts)
+				require.NoError(t, err)
+				require.Len(t, shards, tt.parts)
+
+				var total int
+				var readShards []index.Index
+				for i, shard := range shards {
+					require.Equal(t, codec, shard.Codec())
+					// Assert every shard round-trips, including empty ones, and only holds records
+					// with digests in its prefix range.
+					read, err := index.ReadFrom(bytes.NewReader(marshalIndex(t, shard)))
+					require.NoError(t, err)
+					err = read.(index.IterableIndex).ForEach(func(mh multihash.Multihash, _ uint64) error {
+						dmh, err := multihash.Decode(mh)
+						require.NoError(t, err)
+						require.Equal(t, i, int(dmh.Digest[0])*tt.parts/256)
+						total++
+						return nil
+					})
+					require.NoError(t, err)
+					readShards = append(readShards, read)
+				}
+				require.Equal(t, len(tt.records), total)
+
+				// Assert joining the shards results in the split index, with offsets unchanged.
+				want := marshalIndex(t, src)
+				joined, err := index.Join(shards)
+				require.NoError(t, err)
+				require.Equal(t, want, marshalIndex(t, joined))
+				joined, err = index.Join(readShards)
+				require.NoError(t, err)
+				require.Equal(t, want, marshalIndex(t, joined))
+				requireContainsAll(t, joined, tt.records[1:len(tt.records)-1])
+			})
+		}
+	}
+}
+
+func TestSplitAndJoin_IsError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	src := newLoadedIndex(t, multicodec.CarMultihashIndexSorted, records, false).(index.IterableIndex)
+
+	_, err := index.Split(src, 0)
+	require.Error(t, err)
+	_, err = index.Split(src, 257)
+	require.Error(t, err)
+
+	_, err = index.Join(nil)
+	require.Error(t, err)
+	_, err = index.Join([]index.Index{src, newLoadedIndex(t, index.CarMultihashIndexHashed, records, false)})
+	require.Error(t, err)
+	nonIterable := newLoadedIndex(t, multicodec.CarIndexSorted, records, false)
+	_, err = index.Join([]index.Index{nonIterable})
+	require.Error(t, err)
+}
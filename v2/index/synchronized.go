@@ -0,0 +1,91 @@
+package index
+
+import (
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Index         = (*synchronizedIndex)(nil)
+	_ IterableIndex = (*synchronizedIterableIndex)(nil)
+)
+
+type (
+	// synchronizedIndex is an Index that guards the index it wraps with a read-write mutex.
+	synchronizedIndex struct {
+		mu  sync.RWMutex
+		idx Index
+	}
+
+	// synchronizedIterableIndex is a synchronizedIndex that wraps an IterableIndex.
+	synchronizedIterableIndex struct {
+		*synchronizedIndex
+		iterable IterableIndex
+	}
+)
+
+// Synchronized wraps the given index such that it is safe for concurrent use, including while
+// records are loaded into it. Lookups via GetAll and ForEach, as well as Marshal, hold a read lock
+// and therefore run concurrently with each other, whereas Load and Unmarshal hold a write lock.
+// This allows an index that is still being populated, e.g. an InsertionIndex, to be shared between
+// goroutines that look it up.
+//
+// The wrapped index must only be modified via the returned index. Indices that are not modified
+// once loaded, e.g. those returned by ReadFrom or OpenMmap, are already safe for concurrent lookups
+// and need not be wrapped.
+//
+// The returned index has the same codec, and is written the same way, as the wrapped index. It is
+// an IterableIndex if the wrapped index is one. Indices returned by Synchronized are returned as is.
+func Synchronized(idx Index) Index {
+	switch idx.(type) {
+	case *synchronizedIndex, *synchronizedIterableIndex:
+		return idx
+	}
+	si := &synchronizedIndex{idx: idx}
+	if iterable, ok := idx.(IterableIndex); ok {
+		return &synchronizedIterableIndex{synchronizedIndex: si, iterable: iterable}
+	}
+	return si
+}
+
+func (si *synchronizedIndex) Codec() multicodec.Code {
+	return si.idx.Codec()
+}
+
+func (si *synchronizedIndex) Marshal(w io.Writer) (int64, error) {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.idx.Marshal(w)
+}
+
+func (si *synchronizedIndex) Unmarshal(r io.Reader) error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.idx.Unmarshal(r)
+}
+
+func (si *synchronizedIndex) Load(records []Record) error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.idx.Load(records)
+}
+
+// GetAll looks up the wrapped index while holding a read lock, such that fn must not call the Load
+// or Unmarshal methods of the index.
+func (si *synchronizedIndex) GetAll(key cid.Cid, fn func(uint64) bool) error {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.idx.GetAll(key, fn)
+}
+
+// ForEach iterates over the wrapped index while holding a read lock, such that f must not call the
+// Load or Unmarshal methods of the index.
+func (si *synchronizedIterableIndex) ForEach(f func(multihash.Multihash, uint64) error) error {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.iterable.ForEach(f)
+}
@@ -0,0 +1,126 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSynchronized(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	var records []index.Record
+	for len(records) < 1000 {
+		records = append(records, generateIndexRecords(t, multihash.SHA2_256, rng)...)
+	}
+
+	ii := index.NewInsertionIndex()
+	subject := index.Synchronized(ii)
+	require.Equal(t, ii.Codec(), subject.Codec())
+	require.Same(t, subject, index.Synchronized(subject))
+	iterable, ok := subject.(index.IterableIndex)
+	require.True(t, ok)
+
+	// Assert lookups running alongside inserts find either nothing or the inserted offset.
+	const writers, readers = 4, 8
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(records); i += writers {
+				assert.NoError(t, subject.Load(records[i:i+1]))
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < len(records); i++ {
+				want := records[(i*readers+r)%len(records)]
+				got, err := index.GetFirst(subject, want.Cid)
+				if errors.Is(err, index.ErrNotFound) {
+					continue
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, want.Offset, got)
+			}
+			assert.NoError(t, iterable.ForEach(func(multihash.Multihash, uint64) error { return nil }))
+		}(r)
+	}
+	wg.Wait()
+
+	requireContainsAll(t, subject, records)
+	count, err := ii.Count()
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(records)), count)
+
+	var want, got bytes.Buffer
+	_, err = index.WriteTo(ii, &want)
+	require.NoError(t, err)
+	_, err = index.WriteTo(subject, &got)
+	require.NoError(t, err)
+	require.Equal(t, want.Bytes(), got.Bytes())
+
+	// Assert non-iterable indices remain so once wrapped.
+	idx, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	_, ok = index.Synchronized(idx).(index.IterableIndex)
+	require.False(t, ok)
+}
+
+func TestConcurrentLookups(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+
+	for _, codec := range []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	} {
+		idx, err := index.New(codec)
+		require.NoError(t, err)
+		require.NoError(t, idx.Load(records))
+		subjects := map[string]index.Index{"Loaded": idx}
+
+		var buf bytes.Buffer
+		_, err = index.WriteTo(idx, &buf)
+		require.NoError(t, err)
+		subjects["Read"], err = index.ReadFrom(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		if mmapped, err := index.OpenMmap(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+			subjects["Mmap"] = mmapped
+		}
+
+		for name, subject := range subjects {
+			t.Run(codec.String()+"/"+name, func(t *testing.T) {
+				var wg sync.WaitGroup
+				for r := 0; r < 8; r++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for _, want := range records {
+							got, err := index.GetFirst(subject, want.Cid)
+							assert.NoError(t, err)
+							assert.Equal(t, want.Offset, got)
+						}
+						if iterable, ok := subject.(index.IterableIndex); ok {
+							assert.NoError(t, iterable.ForEach(func(multihash.Multihash, uint64) error { return nil }))
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package index
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// Transcode converts the given index to an index of the target codec, without reading the CAR
+// payload it indexes. The source index is left unmodified.
+//
+// Every codec stores at least the multihash and offset of each record, which is all that
+// MultihashIndexSorted, CarMultihashIndexHashed and multicodec.CarIndexSorted require. Other
+// codecs require more information, which the source index must carry:
+//   - CarCidIndexSorted requires whole CIDs, which are only stored by CidIndexSorted and
+//     InsertionIndex.
+//   - CarMultihashSizedIndexSorted requires the size of blocks, which is only stored by
+//     MultihashSizedIndexSorted and InsertionIndex. Sizes that are not known by the source index
+//     are not known by the transcoded index either.
+//
+// ErrTranscodeUnsupported is returned if the source index lacks the required information. Since
+// the records of the source index must be enumerated, it must be an IterableIndex, unless it is
+// already of the target codec, in which case it is returned as is.
+func Transcode(src Index, target multicodec.Code) (Index, error) {
+	if src.Codec() == target {
+		return src, nil
+	}
+	iterable, ok := src.(IterableIndex)
+	if !ok {
+		return nil, &ErrTranscodeUnsupported{Source: src.Codec(), Target: target, Reason: "source index is not iterable"}
+	}
+
+	switch target {
+	case CarCidIndexSorted:
+		if !StoresWholeCIDs(src) {
+			return nil, &ErrTranscodeUnsupported{Source: src.Codec(), Target: target, Reason: "source index does not store whole CIDs"}
+		}
+	case CarMultihashSizedIndexSorted:
+		switch src.(type) {
+		case *MultihashSizedIndexSorted, *InsertionIndex:
+		default:
+			return nil, &ErrTranscodeUnsupported{Source: src.Codec(), Target: target, Reason: "source index does not store block sizes"}
+		}
+	}
+
+	dst, err := New(target)
+	if err != nil {
+		return nil, err
+	}
+	records, err := transcodeRecords(iterable)
+	if err != nil {
+		return nil, err
+	}
+	if err := dst.Load(records); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// StoresWholeCIDs returns whether the given index stores the whole CIDs of its records, rather than
+// only their multihashes or digests, i.e. whether it is a *CidIndexSorted or an *InsertionIndex.
+// Only such indices can tell apart blocks with the same multihash but different CIDs, e.g. for
+// deduplication of blocks by exact CID.
+func StoresWholeCIDs(idx Index) bool {
+	switch idx.(type) {
+	case *CidIndexSorted, *InsertionIndex:
+		return true
+	default:
+		return false
+	}
+}
+
+// transcodeRecords returns the records of the given index, populated with as much information as
+// the index stores. Records of indices that only store multihashes have CIDs of codec cid.Raw.
+func transcodeRecords(idx IterableIndex) ([]Record, error) {
+	var records []Record
+	switch idx := idx.(type) {
+	case *CidIndexSorted:
+		err := idx.ForEachCid(func(key cid.Cid, offset uint64) error {
+			records = append(records, Record{Cid: key, Offset: offset})
+			return nil
+		})
+		return records, err
+	case *InsertionIndex:
+		err := idx.forEachRecord(func(r Record) error {
+			records = append(records, r)
+			return nil
+		})
+		return records, err
+	case *MultihashSizedIndexSorted:
+		err := idx.forEachSized(func(mh multihash.Multihash, offset uint64, size uint64, known bool) error {
+			r := Record{Cid: cid.NewCidV1(cid.Raw, append(multihash.Multihash(nil), mh...)), Offset: offset}
+			if known {
+				// Record sizes include the CID, whose length is subtracted again when loaded.
+				r.Size = uint64(r.Cid.ByteLen()) + size
+			}
+			records = append(records, r)
+			return nil
+		})
+		return records, err
+	default:
+		err := idx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+			records = append(records, Record{Cid: cid.NewCidV1(cid.Raw, append(multihash.Multihash(nil), mh...)), Offset: offset})
+			return nil
+		})
+		return records, err
+	}
+}
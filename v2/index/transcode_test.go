@@ -0,0 +1,82 @@
+package index_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+	records := generateIndexRecords(t, multihash.SHA2_256, rng)
+	records = append(records, generateIndexRecords(t, multihash.SHA2_512, rng)...)
+	for i := range records {
+		records[i].Size = uint64(records[i].Cid.ByteLen()) + uint64(i) + 1
+	}
+
+	sources := map[string]index.Index{
+		"InsertionIndex": func() index.Index {
+			ii := index.NewInsertionIndex()
+			require.NoError(t, ii.Load(records))
+			return ii
+		}(),
+	}
+	for _, codec := range []multicodec.Code{
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	} {
+		sources[codec.String()] = newLoadedIndex(t, codec, records, false)
+	}
+	targets := []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	}
+
+	for name, src := range sources {
+		for _, target := range targets {
+			t.Run(fmt.Sprintf("%s/%s", name, target), func(t *testing.T) {
+				got, err := index.Transcode(src, target)
+
+				_, srcIsInsertion := src.(*index.InsertionIndex)
+				lacksCids := target == index.CarCidIndexSorted && src.Codec() != target && !srcIsInsertion
+				lacksSizes := target == index.CarMultihashSizedIndexSorted && src.Codec() != target && !srcIsInsertion
+				if lacksCids || lacksSizes {
+					var unsupported *index.ErrTranscodeUnsupported
+					require.True(t, errors.As(err, &unsupported), "expected ErrTranscodeUnsupported but got: %v", err)
+					require.Equal(t, src.Codec(), unsupported.Source)
+					require.Equal(t, target, unsupported.Target)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, target, got.Codec())
+				requireContainsAll(t, got, records)
+				// Assert the transcoded index is identical to one loaded with the original records.
+				require.Equal(t, marshalIndex(t, newLoadedIndex(t, target, records, false)), marshalIndex(t, got))
+			})
+		}
+	}
+}
+
+func TestTranscode_NonIterableIndex(t *testing.T) {
+	src, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+
+	got, err := index.Transcode(src, multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	require.Same(t, src, got)
+
+	_, err = index.Transcode(src, multicodec.CarMultihashIndexSorted)
+	var unsupported *index.ErrTranscodeUnsupported
+	require.True(t, errors.As(err, &unsupported), "expected ErrTranscodeUnsupported but got: %v", err)
+}
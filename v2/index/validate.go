@@ -0,0 +1,233 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// DefaultMaxReportedOffsets is the default number of offending offsets recorded per category in a
+// Report.
+const DefaultMaxReportedOffsets = 10
+
+// ValidationMode specifies how thoroughly Validate checks each record of an index.
+type ValidationMode int
+
+const (
+	// ValidateSections checks that each record points to a section within the payload whose CID
+	// matches the record's multihash. This is the default mode.
+	ValidateSections ValidationMode = iota
+	// ValidateBounds only checks that the offset of each record is within the payload.
+	// No section data is read.
+	ValidateBounds
+	// ValidateHashes performs the checks of ValidateSections, and additionally hashes the block
+	// data of each section to verify that it matches the record's multihash.
+	ValidateHashes
+)
+
+type (
+	// ValidateOption configures Validate.
+	ValidateOption func(*validateOptions)
+
+	validateOptions struct {
+		mode               ValidationMode
+		payloadSize        int64
+		maxReportedOffsets int
+	}
+
+	// Report is the outcome of validating an index against a CARv1 data payload.
+	Report struct {
+		// Valid is the number of records that passed validation.
+		Valid uint64
+		// Mismatched is the number of records whose section does not match the record's multihash.
+		Mismatched uint64
+		// OutOfBounds is the number of records whose offset, or whose section, falls outside the
+		// payload.
+		OutOfBounds uint64
+		// MismatchedOffsets holds the offsets of the first few mismatched records, in the order
+		// they were encountered.
+		MismatchedOffsets []uint64
+		// OutOfBoundsOffsets holds the offsets of the first few out of bounds records, in the order
+		// they were encountered.
+		OutOfBoundsOffsets []uint64
+	}
+)
+
+// WithValidationMode sets how thoroughly each record is checked.
+// Defaults to ValidateSections if unspecified.
+func WithValidationMode(mode ValidationMode) ValidateOption {
+	return func(o *validateOptions) {
+		o.mode = mode
+	}
+}
+
+// WithPayloadSize sets the size of the payload in bytes.
+// It is required when the size cannot be inferred from the payload itself, i.e. when the payload
+// implements neither `Size() int64` nor `Stat() (os.FileInfo, error)`.
+func WithPayloadSize(size int64) ValidateOption {
+	return func(o *validateOptions) {
+		o.payloadSize = size
+	}
+}
+
+// WithMaxReportedOffsets sets the maximum number of offending offsets recorded in each category of
+// the Report.
+// Defaults to DefaultMaxReportedOffsets if unspecified.
+func WithMaxReportedOffsets(n int) ValidateOption {
+	return func(o *validateOptions) {
+		o.maxReportedOffsets = n
+	}
+}
+
+// Total returns the total number of records validated.
+func (r Report) Total() uint64 {
+	return r.Valid + r.Mismatched + r.OutOfBounds
+}
+
+// OK returns true if every validated record was found to be valid.
+func (r Report) OK() bool {
+	return r.Mismatched == 0 && r.OutOfBounds == 0
+}
+
+// Validate checks every record of the given index against the CARv1 data payload it indexes.
+// The offsets of records are interpreted relative to the start of payload; when validating the
+// index of a CARv2 file, pass its inner CARv1 data payload, e.g. via Reader.DataReader.
+//
+// The index must implement IterableIndex; an error is returned otherwise. Records that fail
+// validation are counted in the returned Report rather than reported as an error. An error is only
+// returned if the index cannot be iterated or the payload cannot be read.
+//
+// See: ValidationMode, ValidateContext.
+func Validate(idx Index, payload io.ReaderAt, opts ...ValidateOption) (Report, error) {
+	return ValidateContext(context.Background(), idx, payload, opts...)
+}
+
+// ValidateContext is similar to Validate, except that validation is aborted once ctx is done, in
+// which case the context error is returned along with the Report of the records validated so far.
+// The context is checked periodically as records are validated.
+func ValidateContext(ctx context.Context, idx Index, payload io.ReaderAt, opts ...ValidateOption) (Report, error) {
+	o := validateOptions{
+		mode:               ValidateSections,
+		payloadSize:        -1,
+		maxReportedOffsets: DefaultMaxReportedOffsets,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	iidx, ok := idx.(IterableIndex)
+	if !ok {
+		return Report{}, fmt.Errorf("index of type %T does not support iteration", idx)
+	}
+	size := o.payloadSize
+	if size < 0 {
+		var err error
+		if size, err = payloadSize(payload); err != nil {
+			return Report{}, err
+		}
+	}
+
+	var report Report
+	err := ForEachContext(ctx, iidx, func(mh multihash.Multihash, offset uint64) error {
+		status, err := validateRecord(payload, size, mh, offset, o.mode)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case recordValid:
+			report.Valid++
+		case recordMismatched:
+			report.Mismatched++
+			if len(report.MismatchedOffsets) < o.maxReportedOffsets {
+				report.MismatchedOffsets = append(report.MismatchedOffsets, offset)
+			}
+		case recordOutOfBounds:
+			report.OutOfBounds++
+			if len(report.OutOfBoundsOffsets) < o.maxReportedOffsets {
+				report.OutOfBoundsOffsets = append(report.OutOfBoundsOffsets, offset)
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+type recordStatus int
+
+const (
+	recordValid recordStatus = iota
+	recordMismatched
+	recordOutOfBounds
+)
+
+func validateRecord(payload io.ReaderAt, size int64, mh multihash.Multihash, offset uint64, mode ValidationMode) (recordStatus, error) {
+	if offset >= uint64(size) {
+		return recordOutOfBounds, nil
+	}
+	if mode == ValidateBounds {
+		return recordValid, nil
+	}
+
+	// Read the section length prefix.
+	var prefix [varint.MaxLenUvarint63]byte
+	n, err := payload.ReadAt(prefix[:], int64(offset))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	sectionLen, prefixLen, err := varint.FromUvarint(prefix[:n])
+	if err != nil {
+		if n < len(prefix) {
+			// The prefix may be truncated by the end of the payload.
+			return recordOutOfBounds, nil
+		}
+		return recordMismatched, nil
+	}
+	start := offset + uint64(prefixLen)
+	if sectionLen == 0 {
+		return recordMismatched, nil
+	}
+	if sectionLen > uint64(size)-start {
+		return recordOutOfBounds, nil
+	}
+
+	section := io.NewSectionReader(payload, int64(start), int64(sectionLen))
+	cidLen, c, err := cid.CidFromReader(section)
+	if err != nil || !bytes.Equal(c.Hash(), mh) {
+		return recordMismatched, nil
+	}
+	if mode != ValidateHashes {
+		return recordValid, nil
+	}
+
+	data := make([]byte, sectionLen-uint64(cidLen))
+	if _, err := section.ReadAt(data, int64(cidLen)); err != nil && err != io.EOF {
+		return 0, err
+	}
+	got, err := c.Prefix().Sum(data)
+	if err != nil || !got.Equals(c) {
+		return recordMismatched, nil
+	}
+	return recordValid, nil
+}
+
+func payloadSize(payload io.ReaderAt) (int64, error) {
+	switch p := payload.(type) {
+	case interface{ Size() int64 }:
+		return p.Size(), nil
+	case interface{ Stat() (os.FileInfo, error) }:
+		stat, err := p.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return stat.Size(), nil
+	default:
+		return 0, errors.New("cannot determine payload size; specify it via WithPayloadSize")
+	}
+}
@@ -0,0 +1,175 @@
+package index_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	payload, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	idx, err := carv2.GenerateIndex(bytes.NewReader(payload))
+	require.NoError(t, err)
+	total := countRecords(t, idx)
+
+	for _, mode := range []index.ValidationMode{index.ValidateBounds, index.ValidateSections, index.ValidateHashes} {
+		report, err := index.Validate(idx, bytes.NewReader(payload), index.WithValidationMode(mode))
+		require.NoError(t, err)
+		require.True(t, report.OK())
+		require.Equal(t, total, report.Valid)
+		require.Equal(t, total, report.Total())
+		require.Empty(t, report.MismatchedOffsets)
+		require.Empty(t, report.OutOfBoundsOffsets)
+	}
+}
+
+func TestValidateContext(t *testing.T) {
+	payload, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	idx, err := carv2.GenerateIndex(bytes.NewReader(payload))
+	require.NoError(t, err)
+
+	report, err := index.ValidateContext(context.Background(), idx, bytes.NewReader(payload))
+	require.NoError(t, err)
+	require.True(t, report.OK())
+	require.Equal(t, countRecords(t, idx), report.Valid)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = index.ValidateContext(ctx, idx, bytes.NewReader(payload))
+	require.Equal(t, context.Canceled, err)
+	require.Zero(t, report.Total())
+}
+
+func TestValidateDetectsOutOfBoundsRecords(t *testing.T) {
+	payload, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	idx, err := carv2.GenerateIndex(bytes.NewReader(payload))
+	require.NoError(t, err)
+	lastOffset := maxRecordOffset(t, idx)
+
+	// Truncate the payload half way through its last section.
+	truncated := bytes.NewReader(payload[:lastOffset+4])
+
+	report, err := index.Validate(idx, truncated, index.WithValidationMode(index.ValidateBounds))
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	report, err = index.Validate(idx, truncated)
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	require.Equal(t, uint64(1), report.OutOfBounds)
+	require.Equal(t, []uint64{lastOffset}, report.OutOfBoundsOffsets)
+
+	// Truncate the payload right before its last section.
+	report, err = index.Validate(idx, bytes.NewReader(payload[:lastOffset]), index.WithValidationMode(index.ValidateBounds))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), report.OutOfBounds)
+	require.Equal(t, []uint64{lastOffset}, report.OutOfBoundsOffsets)
+}
+
+func TestValidateDetectsMismatchedRecords(t *testing.T) {
+	payload, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	idx, err := carv2.GenerateIndex(bytes.NewReader(payload))
+	require.NoError(t, err)
+	total := countRecords(t, idx)
+	lastOffset := maxRecordOffset(t, idx)
+
+	// Corrupt the last byte of block data in the last section.
+	sectionLen, prefixLen, err := varint.FromUvarint(payload[lastOffset:])
+	require.NoError(t, err)
+	corrupt := append([]byte{}, payload...)
+	corrupt[lastOffset+uint64(prefixLen)+sectionLen-1] ^= 0xff
+
+	report, err := index.Validate(idx, bytes.NewReader(corrupt))
+	require.NoError(t, err)
+	require.True(t, report.OK(), "sections mode does not hash block data")
+
+	report, err = index.Validate(idx, bytes.NewReader(corrupt), index.WithValidationMode(index.ValidateHashes))
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	require.Equal(t, total-1, report.Valid)
+	require.Equal(t, uint64(1), report.Mismatched)
+	require.Equal(t, []uint64{lastOffset}, report.MismatchedOffsets)
+
+	// Point every record at the start of the same section; all but one should mismatch.
+	var records []index.Record
+	err = idx.(index.IterableIndex).ForEach(func(mh multihash.Multihash, _ uint64) error {
+		records = append(records, index.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: lastOffset})
+		return nil
+	})
+	require.NoError(t, err)
+	misplaced := index.NewMultihashSorted()
+	require.NoError(t, misplaced.Load(records))
+
+	report, err = index.Validate(misplaced, bytes.NewReader(payload), index.WithMaxReportedOffsets(2))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), report.Valid)
+	require.Equal(t, total-1, report.Mismatched)
+	require.Equal(t, []uint64{lastOffset, lastOffset}, report.MismatchedOffsets)
+}
+
+func TestValidatePayloadSize(t *testing.T) {
+	payload, err := os.ReadFile("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	idx, err := carv2.GenerateIndex(bytes.NewReader(payload))
+	require.NoError(t, err)
+
+	// A payload without a known size is rejected unless the size is specified explicitly.
+	sizeless := struct{ io.ReaderAt }{bytes.NewReader(payload)}
+	_, err = index.Validate(idx, sizeless)
+	require.Error(t, err)
+
+	report, err := index.Validate(idx, sizeless, index.WithPayloadSize(int64(len(payload))))
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	// The size is inferred from files.
+	f, err := os.Open("../testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	report, err = index.Validate(idx, f)
+	require.NoError(t, err)
+	require.True(t, report.OK())
+}
+
+func TestValidateNonIterableIndexIsError(t *testing.T) {
+	idx, err := index.New(multicodec.CarIndexSorted)
+	require.NoError(t, err)
+	_, err = index.Validate(idx, bytes.NewReader(nil))
+	require.Error(t, err)
+}
+
+func countRecords(t *testing.T, idx index.Index) uint64 {
+	var count uint64
+	err := idx.(index.IterableIndex).ForEach(func(multihash.Multihash, uint64) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	return count
+}
+
+func maxRecordOffset(t *testing.T, idx index.Index) uint64 {
+	var max uint64
+	err := idx.(index.IterableIndex).ForEach(func(_ multihash.Multihash, offset uint64) error {
+		if offset > max {
+			max = offset
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return max
+}
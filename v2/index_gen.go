@@ -8,9 +8,9 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
 	"github.com/multiformats/go-multihash"
-	"github.com/multiformats/go-varint"
 )
 
 // GenerateIndex generates index for the given car payload reader.
@@ -39,57 +39,19 @@ func GenerateIndex(v1r io.Reader, opts ...Option) (index.Index, error) {
 func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 	// Parse Options.
 	o := ApplyOptions(opts...)
+	totalBytes := readerSize(r)
 
-	reader := internalio.ToByteReadSeeker(r)
-	pragma, err := carv1.ReadHeader(r, o.MaxAllowedHeaderSize)
-	if err != nil {
-		return fmt.Errorf("error reading car header: %w", err)
-	}
-
-	var dataSize, dataOffset int64
-	switch pragma.Version {
-	case 1:
-		break
-	case 2:
-		// Read V2 header which should appear immediately after pragma according to CARv2 spec.
-		var v2h Header
-		_, err := v2h.ReadFrom(r)
-		if err != nil {
-			return err
-		}
-
-		// Sanity-check the CARv2 header
-		if v2h.DataOffset < HeaderSize {
-			return fmt.Errorf("malformed CARv2; data offset too small: %d", v2h.DataOffset)
-		}
-		if v2h.DataSize < 1 {
-			return fmt.Errorf("malformed CARv2; data payload size too small: %d", v2h.DataSize)
-		}
-
-		// Seek to the beginning of the inner CARv1 payload
-		_, err = reader.Seek(int64(v2h.DataOffset), io.SeekStart)
-		if err != nil {
+	if o.ReadBufferSize > 0 {
+		var err error
+		if r, err = withReadBuffer(r, o.ReadBufferSize); err != nil {
 			return err
 		}
+	}
 
-		// Set dataSize and dataOffset which are then used during index loading logic to decide
-		// where to stop and adjust section offset respectively.
-		// Note that we could use a LimitReader here and re-define reader with it. However, it means
-		// the internalio.ToByteReadSeeker will be less efficient since LimitReader does not
-		// implement ByteReader nor ReadSeeker.
-		dataSize = int64(v2h.DataSize)
-		dataOffset = int64(v2h.DataOffset)
-
-		// Read the inner CARv1 header to skip it and sanity check it.
-		v1h, err := carv1.ReadHeader(reader, o.MaxAllowedHeaderSize)
-		if err != nil {
-			return err
-		}
-		if v1h.Version != 1 {
-			return fmt.Errorf("expected data payload header version of 1; got %d", v1h.Version)
-		}
-	default:
-		return fmt.Errorf("expected either version 1 or 2; got %d", pragma.Version)
+	reader := internalio.ToByteReadSeeker(r)
+	dataOffset, dataSize, err := readIndexedPayloadHeaders(r, reader, o)
+	if err != nil {
+		return err
 	}
 
 	// Record the start of each section, with first section starring from current position in the
@@ -107,12 +69,31 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 	// CARv2 header.
 	sectionOffset -= dataOffset
 
+	if dataSize != 0 {
+		totalBytes = dataSize
+	}
+	progress := newIndexProgress(o, totalBytes)
+	warnings := newIndexWarnings(o)
+
 	records := make([]index.Record, 0)
 	for {
+		if err := progress.checkContext(); err != nil {
+			return err
+		}
+
+		// Check if we have reached the end of data payload and if so treat it as an EOF. This is
+		// checked before reading each section, since a data payload may hold no sections at all.
+		// Note, dataSize will be non-zero only if we are reading from a CARv2.
+		if dataSize != 0 && sectionOffset >= dataSize {
+			warnings.endOfPadding(sectionOffset)
+			break
+		}
+
 		// Read the section's length.
-		sectionLen, err := varint.ReadUvarint(reader)
+		sectionLen, _, err := util.ReadUvarint(reader, o.LenientVarints)
 		if err != nil {
 			if err == io.EOF {
+				warnings.endOfPadding(sectionOffset)
 				break
 			}
 			return err
@@ -120,7 +101,18 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 
 		// Null padding; by default it's an error.
 		if sectionLen == 0 {
-			if o.ZeroLengthSectionAsEOF {
+			if o.SkipNullPadding {
+				warnings.nullPadding(sectionOffset)
+				// Skip over the padding, i.e. the single byte of the zero length, to the next section.
+				if sectionOffset, err = reader.Seek(0, io.SeekCurrent); err != nil {
+					return err
+				}
+				sectionOffset -= dataOffset
+				continue
+			} else if o.ZeroLengthSectionAsEOF {
+				if err := warnings.zeroLengthAsEOF(sectionOffset, dataSize, reader); err != nil {
+					return err
+				}
 				break
 			} else {
 				return fmt.Errorf("carv1 null padding not allowed by default; see ZeroLengthSectionAsEOF")
@@ -133,11 +125,13 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 			return err
 		}
 
+		warnings.section(sectionOffset, c, sectionLen)
+
 		if o.StoreIdentityCIDs || c.Prefix().MhType != multihash.IDENTITY {
 			if uint64(cidLen) > o.MaxIndexCidSize {
 				return &ErrCidTooLarge{MaxSize: o.MaxIndexCidSize, CurrentSize: uint64(cidLen)}
 			}
-			records = append(records, index.Record{Cid: c, Offset: uint64(sectionOffset)})
+			records = append(records, index.Record{Cid: c, Offset: uint64(sectionOffset), Size: sectionLen})
 		}
 
 		// Seek to the next section by skipping the block.
@@ -148,13 +142,9 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 		}
 		// Subtract the data offset which will be non-zero when reader represents a CARv2.
 		sectionOffset -= dataOffset
-
-		// Check if we have reached the end of data payload and if so treat it as an EOF.
-		// Note, dataSize will be non-zero only if we are reading from a CARv2.
-		if dataSize != 0 && sectionOffset >= dataSize {
-			break
-		}
+		progress.sectionIndexed(sectionOffset)
 	}
+	progress.done(sectionOffset)
 
 	if err := idx.Load(records); err != nil {
 		return err
@@ -163,6 +153,146 @@ func LoadIndex(idx index.Index, r io.Reader, opts ...Option) error {
 	return nil
 }
 
+// indexProgress reports the progress of index generation via Options.IndexProgress, and checks
+// Options.IndexContext for cancellation.
+type indexProgress struct {
+	o          Options
+	totalBytes int64
+	sections   uint64
+	// reported is the number of sections at the last call to Options.IndexProgress.
+	reported uint64
+}
+
+func newIndexProgress(o Options, totalBytes int64) *indexProgress {
+	return &indexProgress{o: o, totalBytes: totalBytes}
+}
+
+// checkContext returns the error of Options.IndexContext if it is done.
+func (p *indexProgress) checkContext() error {
+	if p.o.IndexContext == nil {
+		return nil
+	}
+	return p.o.IndexContext.Err()
+}
+
+// sectionIndexed records that a section has been indexed, having read the given number of bytes so
+// far, and reports the progress every Options.IndexProgressInterval sections.
+func (p *indexProgress) sectionIndexed(bytesRead int64) {
+	p.sections++
+	if p.o.IndexProgress != nil && p.sections%p.o.IndexProgressInterval == 0 {
+		p.report(bytesRead)
+	}
+}
+
+// done reports the final progress, unless it has already been reported for the last section.
+func (p *indexProgress) done(bytesRead int64) {
+	if p.o.IndexProgress != nil && (p.reported != p.sections || p.sections == 0) {
+		p.report(bytesRead)
+	}
+}
+
+func (p *indexProgress) report(bytesRead int64) {
+	p.reported = p.sections
+	p.o.IndexProgress(p.sections, bytesRead, p.totalBytes)
+}
+
+// readerSize returns the size of r if it is known, i.e. if r has a Size method or is a regular file,
+// or -1 otherwise.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size()
+	case interface{ Stat() (os.FileInfo, error) }:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// readIndexedPayloadHeaders reads the headers of the CAR payload from r, leaving reader positioned
+// at the first section of the CARv1 data payload. For CARv2 payloads the data offset and size are
+// returned as specified by the CARv2 header; they are both zero for CARv1 payloads.
+func readIndexedPayloadHeaders(r io.Reader, reader internalio.ByteReadSeeker, o Options) (dataOffset, dataSize int64, err error) {
+	pragma, err := carv1.ReadHeader(r, o.MaxAllowedHeaderSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading car header: %w", err)
+	}
+
+	if err := o.checkVersion(pragma.Version); err != nil {
+		return 0, 0, err
+	}
+
+	// Any accepted version other than 1 is read as CARv2.
+	switch pragma.Version {
+	case 1:
+		break
+	default:
+		// Read V2 header which should appear immediately after pragma according to CARv2 spec.
+		var v2h Header
+		_, err := v2h.ReadFrom(r)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		// Sanity-check the CARv2 header
+		if v2h.DataOffset < HeaderSize {
+			return 0, 0, fmt.Errorf("malformed CARv2; data offset too small: %d", v2h.DataOffset)
+		}
+		if v2h.DataSize < 1 {
+			return 0, 0, fmt.Errorf("malformed CARv2; data payload size too small: %d", v2h.DataSize)
+		}
+
+		// Seek to the beginning of the inner CARv1 payload
+		_, err = reader.Seek(int64(v2h.DataOffset), io.SeekStart)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		// Set dataSize and dataOffset which are then used during index loading logic to decide
+		// where to stop and adjust section offset respectively.
+		// Note that we could use a LimitReader here and re-define reader with it. However, it means
+		// the internalio.ToByteReadSeeker will be less efficient since LimitReader does not
+		// implement ByteReader nor ReadSeeker.
+		dataSize = int64(v2h.DataSize)
+		dataOffset = int64(v2h.DataOffset)
+
+		// Read the inner CARv1 header to skip it and sanity check it.
+		v1h, err := carv1.ReadHeader(reader, o.MaxAllowedHeaderSize)
+		if err != nil {
+			return 0, 0, err
+		}
+		if v1h.Version != 1 {
+			return 0, 0, fmt.Errorf("expected data payload header version of 1; got %d", v1h.Version)
+		}
+	}
+	return dataOffset, dataSize, nil
+}
+
+// withReadBuffer wraps r with a read-ahead buffer of the given size, positioned at the current
+// position of r. If r does not implement both io.ReaderAt and io.Seeker it is returned as is.
+func withReadBuffer(r io.Reader, size int) (io.Reader, error) {
+	ras, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return r, nil
+	}
+	pos, err := ras.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	buffered, err := internalio.NewOffsetReadSeeker(internalio.NewPrefetchReaderAt(ras, size), 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := buffered.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return buffered, nil
+}
+
 // GenerateIndexFromFile walks a CAR file at the give path and generates an index of cid->byte offset.
 // The index can be stored using index.WriteTo. Both CARv1 and CARv2 formats are accepted.
 //
@@ -182,7 +312,8 @@ func GenerateIndexFromFile(path string, opts ...Option) (index.Index, error) {
 // ReadOrGenerateIndex accepts both CARv1 and CARv2 formats, and reads or generates an index for it.
 // When the given reader is in CARv1 format an index is always generated.
 // For a payload in CARv2 format, an index is only generated if Header.HasIndex returns false.
-// An error is returned for all other formats, i.e. pragma with versions other than 1 or 2.
+// An error is returned for all other formats, i.e. pragma with versions other than 1 or 2, unless
+// accepted via WithAcceptedVersions.
 //
 // Note, the returned index lives entirely in memory and will not depend on the
 // given reader to fulfill index lookup.
@@ -201,8 +332,8 @@ func ReadOrGenerateIndex(rs io.ReadSeeker, opts ...Option) (index.Index, error)
 	case 1:
 		// Simply generate the index, since there can't be a pre-existing one.
 		return GenerateIndex(rs, opts...)
-	case 2:
-		// Read CARv2 format
+	default:
+		// Read CARv2 format, as is any accepted version other than 1.
 		v2r, err := NewReader(internalio.ToReaderAt(rs), opts...)
 		if err != nil {
 			return nil, err
@@ -221,7 +352,5 @@ func ReadOrGenerateIndex(rs io.ReadSeeker, opts ...Option) (index.Index, error)
 			return nil, err
 		}
 		return GenerateIndex(dr, opts...)
-	default:
-		return nil, fmt.Errorf("unknown version %v", version)
 	}
 }
@@ -0,0 +1,252 @@
+package car
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// parallelIndexBatchSize is the number of sections handed to a worker at a time.
+	parallelIndexBatchSize = 1024
+	// parallelIndexCidPeekSize is the number of bytes at the start of each section that are passed
+	// to workers in order to decode the section's CID. Sections whose CID is longer are decoded by
+	// the worker reading the payload directly.
+	parallelIndexCidPeekSize = 128
+	// defaultParallelReadBufferSize is the size of the read-ahead buffer used to walk section
+	// boundaries when no read buffer size is set via WithReadBufferSize.
+	defaultParallelReadBufferSize = 1 << 20
+)
+
+type (
+	// sectionBatch is a batch of consecutive sections whose boundaries have been walked, and whose
+	// index records are generated by a worker.
+	sectionBatch struct {
+		sections []sectionBoundary
+		// peek holds the leading bytes of each section, referred to by sectionBoundary.
+		peek    []byte
+		records []index.Record
+		err     error
+	}
+
+	sectionBoundary struct {
+		// offset is the offset of the section relative to the CARv1 data payload.
+		offset uint64
+		// cidOffset is the absolute offset of the section's CID in the CAR payload.
+		cidOffset int64
+		length    uint64
+		peekStart int
+		peekEnd   int
+	}
+)
+
+// GenerateIndexParallel generates an index for the CAR payload of the given size read from r, using
+// the given number of workers. Both CARv1 and CARv2 formats are accepted. If workers is less than
+// one, runtime.GOMAXPROCS workers are used.
+//
+// Section boundaries are walked sequentially, since the offset of each section depends on the
+// length of the previous one, while decoding CIDs and constructing index records is distributed
+// across workers. For a well-formed payload the generated index is identical to the one generated
+// by GenerateIndex with the same options. The read-ahead buffer used to walk section boundaries can
+// be configured via WithReadBufferSize.
+//
+// Unlike GenerateIndex, the CID of a section must be contained within the section.
+//
+// See: GenerateIndex.
+func GenerateIndexParallel(r io.ReaderAt, size int64, workers int, opts ...Option) (index.Index, error) {
+	o := ApplyOptions(opts...)
+	idx, err := index.New(o.IndexCodec)
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	bufSize := o.ReadBufferSize
+	if bufSize <= 0 {
+		bufSize = defaultParallelReadBufferSize
+	}
+
+	r = io.NewSectionReader(r, 0, size)
+	reader, err := internalio.NewOffsetReadSeeker(internalio.NewPrefetchReaderAt(r, bufSize), 0)
+	if err != nil {
+		return nil, err
+	}
+	dataOffset, dataSize, err := readIndexedPayloadHeaders(reader, reader, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg      sync.WaitGroup
+		batches = make(chan *sectionBatch, workers)
+		done    = make(chan struct{})
+		once    sync.Once
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				batch.load(r, o)
+				if batch.err != nil {
+					once.Do(func() { close(done) })
+				}
+			}
+		}()
+	}
+
+	totalBytes := size
+	if dataSize != 0 {
+		totalBytes = dataSize
+	}
+	progress := newIndexProgress(o, totalBytes)
+	all, walkErr := walkSectionBoundaries(reader, dataOffset, dataSize, o, progress, batches, done)
+	close(batches)
+	wg.Wait()
+
+	// Report the first error by position in the payload, so that errors are consistent with
+	// sequential index generation.
+	records := make([]index.Record, 0)
+	for _, batch := range all {
+		if batch.err != nil {
+			return nil, batch.err
+		}
+		records = append(records, batch.records...)
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if err := idx.Load(records); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// walkSectionBoundaries walks the sections of the CARv1 data payload from the current position of
+// reader, sending batches of consecutive sections to the given channel. All sent batches are
+// returned in order. Walking stops early if done is closed. The progress of walking is reported
+// via the given progress, as is the case for sequential index generation.
+func walkSectionBoundaries(reader internalio.ReadSeekerAt, dataOffset, dataSize int64, o Options, progress *indexProgress, batches chan<- *sectionBatch, done <-chan struct{}) ([]*sectionBatch, error) {
+	var all []*sectionBatch
+	batch := &sectionBatch{}
+	send := func() bool {
+		if len(batch.sections) == 0 {
+			return true
+		}
+		select {
+		case batches <- batch:
+			all = append(all, batch)
+			batch = &sectionBatch{}
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	var sectionStart int64
+	for {
+		if err := progress.checkContext(); err != nil {
+			return all, err
+		}
+
+		// Get the absolute position of the section; seeking relative to the current position
+		// returns the position relative to the start of the CAR payload.
+		var err error
+		if sectionStart, err = reader.Seek(0, io.SeekCurrent); err != nil {
+			return all, err
+		}
+		// Check if we have reached the end of data payload and if so treat it as an EOF.
+		// Note, dataSize will be non-zero only if we are reading from a CARv2.
+		if dataSize != 0 && sectionStart-dataOffset >= dataSize {
+			break
+		}
+
+		// Read the section's length.
+		sectionLen, sectionLenLen, err := util.ReadUvarint(reader, o.LenientVarints)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return all, err
+		}
+
+		// Null padding; by default it's an error.
+		if sectionLen == 0 {
+			if o.SkipNullPadding {
+				continue
+			}
+			if o.ZeroLengthSectionAsEOF {
+				break
+			}
+			return all, fmt.Errorf("carv1 null padding not allowed by default; see ZeroLengthSectionAsEOF")
+		}
+
+		// Peek at the start of the section for workers to decode its CID.
+		cidOffset := sectionStart + int64(sectionLenLen)
+		peekLen := sectionLen
+		if peekLen > parallelIndexCidPeekSize {
+			peekLen = parallelIndexCidPeekSize
+		}
+		peekStart := len(batch.peek)
+		batch.peek = append(batch.peek, make([]byte, peekLen)...)
+		n, err := io.ReadFull(reader, batch.peek[peekStart:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return all, err
+		}
+		batch.peek = batch.peek[:peekStart+n]
+		batch.sections = append(batch.sections, sectionBoundary{
+			offset:    uint64(sectionStart - dataOffset),
+			cidOffset: cidOffset,
+			length:    sectionLen,
+			peekStart: peekStart,
+			peekEnd:   peekStart + n,
+		})
+
+		// Seek to the next section by skipping the rest of the block.
+		nextSectionStart, err := reader.Seek(int64(sectionLen)-int64(n), io.SeekCurrent)
+		if err != nil {
+			return all, err
+		}
+		progress.sectionIndexed(nextSectionStart - dataOffset)
+
+		if len(batch.sections) == parallelIndexBatchSize && !send() {
+			return all, nil
+		}
+	}
+	send()
+	progress.done(sectionStart - dataOffset)
+	return all, nil
+}
+
+// load decodes the CIDs of the sections in the batch and populates its index records.
+// Any error encountered is set on the batch.
+func (b *sectionBatch) load(r io.ReaderAt, o Options) {
+	b.records = make([]index.Record, 0, len(b.sections))
+	for _, s := range b.sections {
+		cidLen, c, err := cid.CidFromBytes(b.peek[s.peekStart:s.peekEnd])
+		if err != nil && uint64(s.peekEnd-s.peekStart) < s.length {
+			// The CID may be longer than the peeked bytes; read it from the section itself.
+			cidLen, c, err = cid.CidFromReader(io.NewSectionReader(r, s.cidOffset, int64(s.length)))
+		}
+		if err != nil {
+			b.err = err
+			return
+		}
+
+		if o.StoreIdentityCIDs || c.Prefix().MhType != multihash.IDENTITY {
+			if uint64(cidLen) > o.MaxIndexCidSize {
+				b.err = &ErrCidTooLarge{MaxSize: o.MaxIndexCidSize, CurrentSize: uint64(cidLen)}
+				return
+			}
+			b.records = append(b.records, index.Record{Cid: c, Offset: s.offset, Size: s.length})
+		}
+	}
+}
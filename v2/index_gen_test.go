@@ -1,15 +1,24 @@
 package car_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
 	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
@@ -139,6 +148,52 @@ func TestGenerateIndex(t *testing.T) {
 	}
 }
 
+// countingReadSeekerAt counts the number of reads issued against the underlying file.
+type countingReadSeekerAt struct {
+	*os.File
+	reads int
+}
+
+func (c *countingReadSeekerAt) Read(p []byte) (int, error) {
+	c.reads++
+	return c.File.Read(p)
+}
+
+func (c *countingReadSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.File.ReadAt(p, off)
+}
+
+func TestGenerateIndexWithReadBufferSize(t *testing.T) {
+	for _, path := range []string{
+		"testdata/sample-v1.car",
+		"testdata/sample-wrapped-v2.car",
+		"testdata/sample-v1-with-zero-len-section.car",
+	} {
+		for _, size := range []int{1, 17, 4 << 10} {
+			t.Run(fmt.Sprintf("%s/%d", filepath.Base(path), size), func(t *testing.T) {
+				opts := []carv2.Option{carv2.ZeroLengthSectionAsEOF(true)}
+				unbuffered, unbufferedReads := generateIndexCountingReads(t, path, opts...)
+				buffered, bufferedReads := generateIndexCountingReads(t, path, append(opts, carv2.WithReadBufferSize(size))...)
+				require.Equal(t, unbuffered, buffered)
+				if size > 1 {
+					require.Less(t, bufferedReads, unbufferedReads)
+				}
+			})
+		}
+	}
+}
+
+func generateIndexCountingReads(t *testing.T, path string, opts ...carv2.Option) (index.Index, int) {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	subject := &countingReadSeekerAt{File: f}
+	idx, err := carv2.GenerateIndex(subject, opts...)
+	require.NoError(t, err)
+	return idx, subject.reads
+}
+
 func TestMultihashIndexSortedConsistencyWithIndexSorted(t *testing.T) {
 	path := "testdata/sample-v1.car"
 
@@ -271,3 +326,572 @@ func generateMultihashSortedIndex(t *testing.T, path string) *index.MultihashInd
 
 	return idx
 }
+
+func TestGenerateIndexParallel(t *testing.T) {
+	paths := []string{
+		"testdata/sample-v1.car",
+		"testdata/sample-wrapped-v2.car",
+		"testdata/sample-unixfs-v2.car",
+		"testdata/sample-v1-with-zero-len-section.car",
+		generateCarWithManySections(t),
+	}
+	codecs := []multicodec.Code{multicodec.CarMultihashIndexSorted, multicodec.CarIndexSorted, index.CarMultihashSizedIndexSorted, index.CarMultihashIndexHashed}
+	for _, path := range paths {
+		for _, codec := range codecs {
+			for _, workers := range []int{0, 1, 4, 16} {
+				t.Run(fmt.Sprintf("%s/%s/%d", filepath.Base(path), codec, workers), func(t *testing.T) {
+					opts := []carv2.Option{
+						carv2.ZeroLengthSectionAsEOF(true),
+						carv2.StoreIdentityCIDs(true),
+						carv2.UseIndexCodec(codec),
+					}
+					want, err := carv2.GenerateIndexFromFile(path, opts...)
+					require.NoError(t, err)
+
+					f, err := os.Open(path)
+					require.NoError(t, err)
+					t.Cleanup(func() { require.NoError(t, f.Close()) })
+					info, err := f.Stat()
+					require.NoError(t, err)
+					got, err := carv2.GenerateIndexParallel(f, info.Size(), workers, opts...)
+					require.NoError(t, err)
+
+					require.Equal(t, marshalIndex(t, want), marshalIndex(t, got))
+				})
+			}
+		}
+	}
+}
+
+func TestGenerateIndexParallelErrorsAreConsistentWithGenerateIndex(t *testing.T) {
+	for _, path := range []string{
+		"testdata/sample-v1-with-zero-len-section.car",
+		"testdata/sample-v1-tailing-corrupt-section.car",
+		"testdata/sample-corrupt-pragma.car",
+		"testdata/sample-rootless-v42.car",
+	} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			_, wantErr := carv2.GenerateIndexFromFile(path)
+
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, f.Close()) })
+			info, err := f.Stat()
+			require.NoError(t, err)
+			_, err = carv2.GenerateIndexParallel(f, info.Size(), 4)
+			require.Equal(t, wantErr != nil, err != nil, "want error: %v, got error: %v", wantErr, err)
+		})
+	}
+}
+
+func TestGenerateIndexReportsProgress(t *testing.T) {
+	type progress struct {
+		sections              uint64
+		bytesRead, totalBytes int64
+	}
+	recordProgress := func(got *[]progress) carv2.Option {
+		return carv2.WithIndexProgress(1000, func(sections uint64, bytesRead, totalBytes int64) {
+			*got = append(*got, progress{sections, bytesRead, totalBytes})
+		})
+	}
+
+	path := generateCarWithManySections(t)
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	info, err := f.Stat()
+	require.NoError(t, err)
+
+	var got []progress
+	_, err = carv2.GenerateIndex(f, recordProgress(&got))
+	require.NoError(t, err)
+	// Progress is reported every 1000 sections; since the CAR has exactly 5000 sections, the last
+	// report is not repeated once all sections have been indexed.
+	require.Len(t, got, 5)
+	for i, p := range got {
+		require.Equal(t, uint64(1000*(i+1)), p.sections)
+		require.Equal(t, info.Size(), p.totalBytes)
+	}
+	require.Equal(t, info.Size(), got[4].bytesRead)
+
+	var gotParallel []progress
+	_, err = carv2.GenerateIndexParallel(f, info.Size(), 4, recordProgress(&gotParallel))
+	require.NoError(t, err)
+	require.Equal(t, got, gotParallel)
+
+	// Assert that for a CARv2 progress is relative to the data payload.
+	v2r, err := carv2.OpenReader("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, v2r.Close()) })
+	v2f, err := os.Open("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, v2f.Close()) })
+	got = nil
+	_, err = carv2.GenerateIndex(v2f, recordProgress(&got))
+	require.NoError(t, err)
+	// The payload has more than 1000 but fewer than 2000 sections, hence one interval report
+	// followed by the final one.
+	require.Len(t, got, 2)
+	require.Equal(t, uint64(1000), got[0].sections)
+	require.Equal(t, int64(v2r.Header.DataSize), got[1].bytesRead)
+	require.Equal(t, int64(v2r.Header.DataSize), got[1].totalBytes)
+}
+
+func TestGenerateIndexIsCancellable(t *testing.T) {
+	path := generateCarWithManySections(t)
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	info, err := f.Stat()
+	require.NoError(t, err)
+
+	generators := map[string]func(opts ...carv2.Option) error{
+		"GenerateIndex": func(opts ...carv2.Option) error {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			_, err := carv2.GenerateIndex(f, opts...)
+			return err
+		},
+		"GenerateIndexParallel": func(opts ...carv2.Option) error {
+			_, err := carv2.GenerateIndexParallel(f, info.Size(), 4, opts...)
+			return err
+		},
+	}
+	for name, generate := range generators {
+		t.Run(name, func(t *testing.T) {
+			// Cancel the context mid-scan, and assert no more sections are scanned.
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+			var lastReported uint64
+			err := generate(
+				carv2.WithIndexContext(ctx),
+				carv2.WithIndexProgress(100, func(sections uint64, _, _ int64) {
+					lastReported = sections
+					if sections == 1000 {
+						cancel()
+					}
+				}))
+			require.True(t, errors.Is(err, context.Canceled), "expected context.Canceled but got: %v", err)
+			require.Equal(t, uint64(1000), lastReported)
+		})
+	}
+}
+
+// generateCarWithManySections writes a CARv1 file with enough sections to span multiple batches
+// of parallel index generation, including identity CIDs longer than the bytes peeked per section.
+func generateCarWithManySections(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "many-sections-v1.car")
+	bs, err := blockstore.OpenReadWrite(path, []cid.Cid{}, blockstore.WriteAsCarV1(true), carv2.StoreIdentityCIDs(true))
+	require.NoError(t, err)
+	for i := 0; i < 5000; i++ {
+		data := []byte(fmt.Sprintf("section %d", i))
+		if i%100 == 0 {
+			data = append(data, make([]byte, 200)...)
+			c, err := cid.NewPrefixV1(cid.Raw, multihash.IDENTITY).Sum(data)
+			require.NoError(t, err)
+			blk, err := blocks.NewBlockWithCid(data, c)
+			require.NoError(t, err)
+			require.NoError(t, bs.Put(context.TODO(), blk))
+			continue
+		}
+		require.NoError(t, bs.Put(context.TODO(), merkledag.NewRawNode(data)))
+	}
+	require.NoError(t, bs.Finalize())
+	return path
+}
+
+func TestGenerateIndexReportsWarnings(t *testing.T) {
+	fish := blocks.NewBlock([]byte("fish"))
+	lobster := blocks.NewBlock([]byte("lobster"))
+	large := blocks.NewBlock(bytes.Repeat([]byte("x"), carv2.LargeSectionWarningSize))
+	sectionLen := func(blk blocks.Block) uint64 {
+		return uint64(len(blk.Cid().Bytes()) + len(blk.RawData()))
+	}
+	writeCar := func(t *testing.T, sections ...interface{}) ([]byte, []uint64) {
+		var buf bytes.Buffer
+		require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: []cid.Cid{fish.Cid()}, Version: 1}, &buf))
+		var offsets []uint64
+		for _, section := range sections {
+			offsets = append(offsets, uint64(buf.Len()))
+			switch section := section.(type) {
+			case blocks.Block:
+				require.NoError(t, util.LdWrite(&buf, section.Cid().Bytes(), section.RawData()))
+			case []byte:
+				buf.Write(section)
+			}
+		}
+		return buf.Bytes(), offsets
+	}
+
+	t.Run("NullPaddingDuplicatesAndLargeSections", func(t *testing.T) {
+		car, offsets := writeCar(t, fish, []byte{0, 0}, lobster, fish, large, []byte{0})
+		_, got, err := carv2.GenerateIndexWithWarnings(bytes.NewReader(car), carv2.WithSkipNullPadding())
+		require.NoError(t, err)
+		require.Equal(t, []carv2.IndexWarning{
+			{Kind: carv2.IndexWarningNullPaddingSkipped, Offset: offsets[1], Size: 2},
+			{Kind: carv2.IndexWarningDuplicateCid, Offset: offsets[3], Size: sectionLen(fish), Cid: fish.Cid()},
+			{Kind: carv2.IndexWarningLargeSection, Offset: offsets[4], Size: sectionLen(large), Cid: large.Cid()},
+			{Kind: carv2.IndexWarningNullPaddingSkipped, Offset: offsets[5], Size: 1},
+		}, got)
+	})
+
+	t.Run("ZeroLengthSectionAsEOFAndTrailingBytes", func(t *testing.T) {
+		car, offsets := writeCar(t, fish, []byte{0}, []byte{1, 2, 3})
+		want := []carv2.IndexWarning{
+			{Kind: carv2.IndexWarningZeroLengthSectionAsEOF, Offset: offsets[1]},
+			{Kind: carv2.IndexWarningTrailingBytes, Offset: offsets[2], Size: 3},
+		}
+		_, got, err := carv2.GenerateIndexWithWarnings(bytes.NewReader(car), carv2.ZeroLengthSectionAsEOF(true))
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+
+		// Assert the same warnings are reported for a CARv2, whose offsets are relative to the data
+		// payload.
+		var v2 bytes.Buffer
+		require.NoError(t, carv2.WrapV1(bytes.NewReader(car), &v2, carv2.ZeroLengthSectionAsEOF(true)))
+		_, got, err = carv2.GenerateIndexWithWarnings(bytes.NewReader(v2.Bytes()), carv2.ZeroLengthSectionAsEOF(true))
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+
+		// Assert a zero-length section at the very end is reported without trailing bytes.
+		car, offsets = writeCar(t, fish, []byte{0})
+		_, got, err = carv2.GenerateIndexWithWarnings(bytes.NewReader(car), carv2.ZeroLengthSectionAsEOF(true))
+		require.NoError(t, err)
+		require.Equal(t, []carv2.IndexWarning{{Kind: carv2.IndexWarningZeroLengthSectionAsEOF, Offset: offsets[1]}}, got)
+	})
+
+	t.Run("WellFormed", func(t *testing.T) {
+		car, _ := writeCar(t, fish, lobster)
+		var called bool
+		_, err := carv2.GenerateIndex(bytes.NewReader(car), carv2.WithIndexWarnings(func(carv2.IndexWarning) {
+			called = true
+		}))
+		require.NoError(t, err)
+		require.False(t, called)
+	})
+}
+
+func TestSkipNullPadding(t *testing.T) {
+	padded, want := generateCarWithNullPadding(t)
+
+	// Assert that by default the padding is an error.
+	_, err := carv2.GenerateIndex(bytes.NewReader(padded))
+	require.Error(t, err)
+
+	t.Run("BlockReader", func(t *testing.T) {
+		subject, err := carv2.NewBlockReader(bytes.NewReader(padded), carv2.WithSkipNullPadding())
+		require.NoError(t, err)
+		var got []blocks.Block
+		for {
+			blk, err := subject.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			got = append(got, blk)
+		}
+		require.Equal(t, want, got)
+	})
+
+	t.Run("GenerateIndex", func(t *testing.T) {
+		opts := []carv2.Option{carv2.WithSkipNullPadding(), carv2.ZeroLengthSectionAsEOF(true), carv2.StoreIdentityCIDs(true)}
+		idx, err := carv2.GenerateIndex(bytes.NewReader(padded), opts...)
+		require.NoError(t, err)
+		parallel, err := carv2.GenerateIndexParallel(bytes.NewReader(padded), int64(len(padded)), 4, opts...)
+		require.NoError(t, err)
+		require.Equal(t, marshalIndex(t, idx), marshalIndex(t, parallel))
+
+		// Assert every block is indexed at the offset of its section.
+		for _, blk := range want {
+			offset, err := index.GetFirst(idx, blk.Cid())
+			require.NoError(t, err)
+			c, data, err := util.ReadNode(bytes.NewReader(padded[offset:]), false, false, false, carv1.DefaultMaxAllowedSectionSize)
+			require.NoError(t, err)
+			require.True(t, c.Equals(blk.Cid()))
+			require.Equal(t, blk.RawData(), data)
+		}
+	})
+
+	t.Run("Inspect", func(t *testing.T) {
+		subject, err := carv2.NewReader(bytes.NewReader(padded), carv2.WithSkipNullPadding())
+		require.NoError(t, err)
+		stats, err := subject.Inspect(true)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(want)), stats.BlockCount)
+	})
+
+	t.Run("ReadOnlyBlockstore", func(t *testing.T) {
+		subject, err := blockstore.NewReadOnly(bytes.NewReader(padded), nil, carv2.WithSkipNullPadding(), blockstore.UseWholeCIDs(true))
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, subject.Close()) })
+		for _, blk := range want {
+			got, err := subject.Get(context.TODO(), blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, blk.RawData(), got.RawData())
+		}
+		keys, err := subject.AllKeysChan(context.TODO())
+		require.NoError(t, err)
+		var count int
+		for range keys {
+			count++
+		}
+		require.Equal(t, len(want), count)
+	})
+}
+
+// generateCarWithNullPadding generates a CARv1 with the blocks of sample-v1.car, where sections
+// are interleaved with varying lengths of null padding, and the payload is padded at the end.
+func generateCarWithNullPadding(t *testing.T) ([]byte, []blocks.Block) {
+	f, err := os.Open("testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	r, err := carv1.NewCarReader(f)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(r.Header, &buf))
+	var blks []blocks.Block
+	for i := 0; ; i++ {
+		blk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		buf.Write(make([]byte, i%4))
+		require.NoError(t, util.LdWrite(&buf, blk.Cid().Bytes(), blk.RawData()))
+		blks = append(blks, blk)
+	}
+	buf.Write(make([]byte, 7))
+	return buf.Bytes(), blks
+}
+
+func TestLenientVarints(t *testing.T) {
+	framed, want := generateCarWithNonMinimalVarints(t)
+
+	// Assert that by default non-minimal varints are an error.
+	_, err := carv2.GenerateIndex(bytes.NewReader(framed))
+	require.True(t, errors.Is(err, varint.ErrNotMinimal), "expected varint.ErrNotMinimal but got: %v", err)
+	strict, err := carv2.NewBlockReader(bytes.NewReader(framed))
+	require.NoError(t, err)
+	_, err = strict.Next()
+	require.True(t, errors.Is(err, varint.ErrNotMinimal), "expected varint.ErrNotMinimal but got: %v", err)
+
+	t.Run("BlockReader", func(t *testing.T) {
+		subject, err := carv2.NewBlockReader(bytes.NewReader(framed), carv2.WithLenientVarints())
+		require.NoError(t, err)
+		var got []blocks.Block
+		for {
+			blk, err := subject.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			got = append(got, blk)
+		}
+		require.Equal(t, want, got)
+	})
+
+	t.Run("GenerateIndex", func(t *testing.T) {
+		opts := []carv2.Option{carv2.WithLenientVarints(), carv2.StoreIdentityCIDs(true)}
+		idx, err := carv2.GenerateIndex(bytes.NewReader(framed), opts...)
+		require.NoError(t, err)
+		parallel, err := carv2.GenerateIndexParallel(bytes.NewReader(framed), int64(len(framed)), 4, opts...)
+		require.NoError(t, err)
+		require.Equal(t, marshalIndex(t, idx), marshalIndex(t, parallel))
+
+		// Assert every block is indexed at the offset of its section.
+		for _, blk := range want {
+			offset, err := index.GetFirst(idx, blk.Cid())
+			require.NoError(t, err)
+			c, data, err := util.ReadNode(bytes.NewReader(framed[offset:]), false, false, true, carv1.DefaultMaxAllowedSectionSize)
+			require.NoError(t, err)
+			require.True(t, c.Equals(blk.Cid()))
+			require.Equal(t, blk.RawData(), data)
+		}
+	})
+
+	t.Run("Inspect", func(t *testing.T) {
+		subject, err := carv2.NewReader(bytes.NewReader(framed), carv2.WithLenientVarints())
+		require.NoError(t, err)
+		stats, err := subject.Inspect(true)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(want)), stats.BlockCount)
+	})
+
+	t.Run("ReadOnlyBlockstore", func(t *testing.T) {
+		subject, err := blockstore.NewReadOnly(bytes.NewReader(framed), nil, carv2.WithLenientVarints(), blockstore.UseWholeCIDs(true))
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, subject.Close()) })
+		for _, blk := range want {
+			got, err := subject.Get(context.TODO(), blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, blk.RawData(), got.RawData())
+			size, err := subject.GetSize(context.TODO(), blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, len(blk.RawData()), size)
+		}
+		var count int
+		require.NoError(t, subject.EachBlock(context.TODO(), func(c cid.Cid, data []byte, offset uint64) error {
+			require.Equal(t, want[count].Cid(), c)
+			require.Equal(t, want[count].RawData(), data)
+			// Assert offsets account for the actual length of the varint of each section.
+			got, _, err := util.ReadNode(bytes.NewReader(framed[offset:]), false, false, true, carv1.DefaultMaxAllowedSectionSize)
+			require.NoError(t, err)
+			require.Equal(t, c, got)
+			count++
+			return nil
+		}))
+		require.Equal(t, len(want), count)
+	})
+
+	t.Run("ReadWriteBlockstoreResumption", func(t *testing.T) {
+		// Wrap the payload in an unfinalized CARv2, such that resuming scans its sections.
+		var unfinalized bytes.Buffer
+		unfinalized.Write(carv2.Pragma)
+		_, err := new(carv2.Header).WriteTo(&unfinalized)
+		require.NoError(t, err)
+		unfinalized.Write(framed)
+		path := filepath.Join(t.TempDir(), "unfinalized.car")
+		require.NoError(t, os.WriteFile(path, unfinalized.Bytes(), 0o666))
+		br, err := carv2.NewBlockReader(bytes.NewReader(framed), carv2.WithLenientVarints())
+		require.NoError(t, err)
+
+		subject, err := blockstore.OpenReadWrite(path, br.Roots, carv2.WithLenientVarints(), carv2.StoreIdentityCIDs(true), blockstore.UseWholeCIDs(true))
+		require.NoError(t, err)
+		for _, blk := range want {
+			got, err := subject.Get(context.TODO(), blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, blk.RawData(), got.RawData())
+		}
+		added := merkledag.NewRawNode([]byte("lenient")).Block
+		require.NoError(t, subject.Put(context.TODO(), added))
+		require.NoError(t, subject.Finalize())
+
+		// Assert the block put after resumption is appended after the last section.
+		resumed, err := blockstore.OpenReadOnly(path, carv2.WithLenientVarints(), blockstore.UseWholeCIDs(true))
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, resumed.Close()) })
+		for _, blk := range append(want, added) {
+			got, err := resumed.Get(context.TODO(), blk.Cid())
+			require.NoError(t, err)
+			require.Equal(t, blk.RawData(), got.RawData())
+		}
+	})
+}
+
+// generateCarWithNonMinimalVarints generates a CARv1 with the blocks of sample-v1.car, where the
+// lengths of sections are encoded with varying numbers of redundant trailing varint groups.
+func generateCarWithNonMinimalVarints(t *testing.T) ([]byte, []blocks.Block) {
+	f, err := os.Open("testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	r, err := carv1.NewCarReader(f)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(r.Header, &buf))
+	var blks []blocks.Block
+	for i := 0; ; i++ {
+		blk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		length := varint.ToUvarint(uint64(len(blk.Cid().Bytes()) + len(blk.RawData())))
+		if redundant := (i + 1) % 3; redundant > 0 {
+			// Continue the last group, then add groups of zeros ending with a zero byte.
+			length[len(length)-1] |= 0x80
+			length = append(length, bytes.Repeat([]byte{0x80}, redundant-1)...)
+			length = append(length, 0x00)
+		}
+		buf.Write(length)
+		buf.Write(blk.Cid().Bytes())
+		buf.Write(blk.RawData())
+		blks = append(blks, blk)
+	}
+	return buf.Bytes(), blks
+}
+
+func marshalIndex(t *testing.T, idx index.Index) []byte {
+	var buf bytes.Buffer
+	_, err := index.WriteTo(idx, &buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestEmptyCar(t *testing.T) {
+	root := blocks.NewBlock([]byte("fish")).Cid()
+	v1 := writeV1(t, []cid.Cid{root})
+
+	codecs := []multicodec.Code{multicodec.CarIndexSorted, multicodec.CarMultihashIndexSorted, index.CarCidIndexSorted, index.CarMultihashSizedIndexSorted, index.CarMultihashIndexHashed}
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			opts := []carv2.Option{carv2.UseIndexCodec(codec)}
+			var v2 bytes.Buffer
+			require.NoError(t, carv2.WrapV1(bytes.NewReader(v1), &v2, opts...))
+
+			tests := []struct {
+				name string
+				car  []byte
+			}{
+				{"CARv1", v1},
+				{"CARv2", v2.Bytes()},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					idx, err := carv2.GenerateIndex(bytes.NewReader(tt.car), opts...)
+					require.NoError(t, err)
+					requireEmptyIndex(t, codec, idx, root)
+
+					idx, err = carv2.GenerateIndexParallel(bytes.NewReader(tt.car), int64(len(tt.car)), 2, opts...)
+					require.NoError(t, err)
+					requireEmptyIndex(t, codec, idx, root)
+
+					idx, err = carv2.ReadOrGenerateIndex(bytes.NewReader(tt.car), opts...)
+					require.NoError(t, err)
+					requireEmptyIndex(t, codec, idx, root)
+
+					r, err := carv2.NewReader(bytes.NewReader(tt.car))
+					require.NoError(t, err)
+					roots, err := r.Roots()
+					require.NoError(t, err)
+					require.Equal(t, []cid.Cid{root}, roots)
+					stats, err := r.Inspect(true)
+					require.NoError(t, err)
+					require.Zero(t, stats.BlockCount)
+					require.False(t, stats.RootsPresent)
+
+					br, err := carv2.NewBlockReader(bytes.NewReader(tt.car))
+					require.NoError(t, err)
+					require.Equal(t, []cid.Cid{root}, br.Roots)
+					_, err = br.Next()
+					require.Equal(t, io.EOF, err)
+				})
+			}
+
+			// Assert the index embedded in the CARv2 is read back as empty.
+			r, err := carv2.NewReader(bytes.NewReader(v2.Bytes()))
+			require.NoError(t, err)
+			ir, err := r.IndexReader()
+			require.NoError(t, err)
+			idx, err := index.ReadFrom(ir)
+			require.NoError(t, err)
+			requireEmptyIndex(t, codec, idx, root)
+		})
+	}
+}
+
+// requireEmptyIndex asserts that the given index is of the given codec, holds no records, and
+// returns index.ErrNotFound when looking up the given key.
+func requireEmptyIndex(t *testing.T, codec multicodec.Code, idx index.Index, key cid.Cid) {
+	t.Helper()
+	require.Equal(t, codec, idx.Codec())
+	err := idx.GetAll(key, func(uint64) bool { return true })
+	require.True(t, errors.Is(err, index.ErrNotFound))
+	countable, ok := idx.(index.CountableIndex)
+	require.True(t, ok)
+	count, err := countable.Count()
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
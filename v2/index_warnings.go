@@ -0,0 +1,181 @@
+package car
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+)
+
+// LargeSectionWarningSize is the section length above which index generation reports an
+// IndexWarningLargeSection, i.e. the largest block IPFS implementations commonly exchange.
+// Currently set to 2 MiB.
+const LargeSectionWarningSize = 2 << 20
+
+// IndexWarningKind is the kind of anomaly described by an IndexWarning.
+type IndexWarningKind int
+
+const (
+	// IndexWarningZeroLengthSectionAsEOF signals that a zero-length section was treated as the end
+	// of the data payload, as enabled via ZeroLengthSectionAsEOF.
+	IndexWarningZeroLengthSectionAsEOF IndexWarningKind = iota
+	// IndexWarningNullPaddingSkipped signals that null padding, i.e. consecutive zero-length
+	// sections, was skipped over, as enabled via WithSkipNullPadding.
+	IndexWarningNullPaddingSkipped
+	// IndexWarningTrailingBytes signals that bytes follow the section at which the data payload was
+	// considered to end, and were therefore not indexed.
+	IndexWarningTrailingBytes
+	// IndexWarningDuplicateCid signals that a section has the same CID as a previous section.
+	IndexWarningDuplicateCid
+	// IndexWarningLargeSection signals that a section is longer than LargeSectionWarningSize.
+	IndexWarningLargeSection
+)
+
+func (k IndexWarningKind) String() string {
+	switch k {
+	case IndexWarningZeroLengthSectionAsEOF:
+		return "zero-length section treated as end of payload"
+	case IndexWarningNullPaddingSkipped:
+		return "null padding skipped"
+	case IndexWarningTrailingBytes:
+		return "trailing bytes after last section"
+	case IndexWarningDuplicateCid:
+		return "duplicate CID"
+	case IndexWarningLargeSection:
+		return "unusually large section"
+	default:
+		return fmt.Sprintf("unknown index warning kind %d", int(k))
+	}
+}
+
+// IndexWarning describes a non-fatal anomaly found in a CAR payload during index generation, i.e.
+// one that does not prevent the index from being generated, but that may reveal a questionable CAR.
+// See: WithIndexWarnings.
+type IndexWarning struct {
+	Kind IndexWarningKind
+	// Offset is the offset of the anomaly relative to the beginning of the CARv1 data payload.
+	Offset uint64
+	// Size is the number of bytes spanned by the anomaly, i.e. the length of the null padding
+	// skipped, of the trailing bytes, or of the section concerned. It is zero for a zero-length
+	// section treated as the end of the payload.
+	Size uint64
+	// Cid is the CID of the section concerned, if any.
+	Cid cid.Cid
+}
+
+func (w IndexWarning) String() string {
+	if w.Cid.Defined() {
+		return fmt.Sprintf("%s at offset %d: %s of %d bytes", w.Kind, w.Offset, w.Cid, w.Size)
+	}
+	return fmt.Sprintf("%s at offset %d: %d bytes", w.Kind, w.Offset, w.Size)
+}
+
+// IndexWarningFunc is called to report a non-fatal anomaly found during index generation; see
+// WithIndexWarnings.
+type IndexWarningFunc func(IndexWarning)
+
+// WithIndexWarnings sets a callback that reports non-fatal anomalies found in a CAR payload as its
+// index is generated via GenerateIndex or LoadIndex, such as zero-length sections treated as the
+// end of the payload, trailing bytes, duplicate CIDs, or unusually large sections. See
+// IndexWarningKind for the anomalies reported. This gives visibility into questionable CARs without
+// failing index generation.
+//
+// The callback is called synchronously from the goroutine generating the index, in the order
+// anomalies are found. Note that detecting duplicate CIDs requires holding the CID of every section
+// in memory for the duration of index generation. Anomalies are not reported by
+// GenerateIndexParallel.
+func WithIndexWarnings(f IndexWarningFunc) Option {
+	return func(o *Options) {
+		o.IndexWarning = f
+	}
+}
+
+// GenerateIndexWithWarnings generates an index as GenerateIndex does, and returns it along with the
+// non-fatal anomalies found in the CAR payload, in the order they are found.
+// See: WithIndexWarnings.
+func GenerateIndexWithWarnings(r io.Reader, opts ...Option) (index.Index, []IndexWarning, error) {
+	var warnings []IndexWarning
+	opts = append(opts[:len(opts):len(opts)], WithIndexWarnings(func(w IndexWarning) {
+		warnings = append(warnings, w)
+	}))
+	idx, err := GenerateIndex(r, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return idx, warnings, nil
+}
+
+// indexWarnings accumulates the anomalies found during index generation, and reports them via
+// Options.IndexWarning. All of its methods are no-ops if no callback is set.
+type indexWarnings struct {
+	f    IndexWarningFunc
+	seen map[cid.Cid]struct{}
+	// paddingStart is the offset at which the null padding being skipped starts, or -1 if none.
+	paddingStart int64
+}
+
+func newIndexWarnings(o Options) *indexWarnings {
+	w := &indexWarnings{f: o.IndexWarning, paddingStart: -1}
+	if w.f != nil {
+		w.seen = make(map[cid.Cid]struct{})
+	}
+	return w
+}
+
+// nullPadding records that a zero-length section at the given offset is skipped as null padding.
+func (w *indexWarnings) nullPadding(offset int64) {
+	if w.f != nil && w.paddingStart < 0 {
+		w.paddingStart = offset
+	}
+}
+
+// endOfPadding reports any null padding being skipped, which ends at the given offset.
+func (w *indexWarnings) endOfPadding(offset int64) {
+	if w.f != nil && w.paddingStart >= 0 {
+		w.f(IndexWarning{Kind: IndexWarningNullPaddingSkipped, Offset: uint64(w.paddingStart), Size: uint64(offset - w.paddingStart)})
+		w.paddingStart = -1
+	}
+}
+
+// section reports anomalies of the section at the given offset, with the given CID and length.
+func (w *indexWarnings) section(offset int64, c cid.Cid, length uint64) {
+	if w.f == nil {
+		return
+	}
+	w.endOfPadding(offset)
+	if _, ok := w.seen[c]; ok {
+		w.f(IndexWarning{Kind: IndexWarningDuplicateCid, Offset: uint64(offset), Size: length, Cid: c})
+	} else {
+		w.seen[c] = struct{}{}
+	}
+	if length > LargeSectionWarningSize {
+		w.f(IndexWarning{Kind: IndexWarningLargeSection, Offset: uint64(offset), Size: length, Cid: c})
+	}
+}
+
+// zeroLengthAsEOF reports that the zero-length section at the given offset is treated as the end of
+// the data payload, followed by the number of trailing bytes after it, if any. These are counted
+// up to dataSize for CARv2 payloads, and by reading the rest of reader otherwise.
+func (w *indexWarnings) zeroLengthAsEOF(offset int64, dataSize int64, reader io.Reader) error {
+	if w.f == nil {
+		return nil
+	}
+	w.endOfPadding(offset)
+	w.f(IndexWarning{Kind: IndexWarningZeroLengthSectionAsEOF, Offset: uint64(offset)})
+	// The zero-length section spans a single byte.
+	trailingStart := offset + 1
+	var trailing int64
+	if dataSize != 0 {
+		trailing = dataSize - trailingStart
+	} else {
+		var err error
+		if trailing, err = io.Copy(io.Discard, reader); err != nil {
+			return err
+		}
+	}
+	if trailing > 0 {
+		w.f(IndexWarning{Kind: IndexWarningTrailingBytes, Offset: uint64(trailingStart), Size: uint64(trailing)})
+	}
+	return nil
+}
@@ -60,7 +60,7 @@ func WriteCar(ctx context.Context, ds format.NodeGetter, roots []cid.Cid, w io.W
 }
 
 func ReadHeader(r io.Reader, maxReadBytes uint64) (*CarHeader, error) {
-	hb, err := util.LdRead(r, false, maxReadBytes)
+	hb, err := util.LdRead(r, false, false, false, maxReadBytes)
 	if err != nil {
 		if err == util.ErrSectionTooLarge {
 			err = util.ErrHeaderTooLarge
@@ -149,7 +149,7 @@ func NewCarReaderWithoutDefaults(r io.Reader, zeroLenAsEOF bool, maxAllowedHeade
 }
 
 func (cr *CarReader) Next() (blocks.Block, error) {
-	c, data, err := util.ReadNode(cr.r, cr.zeroLenAsEOF, cr.maxAllowedSectionSize)
+	c, data, err := util.ReadNode(cr.r, cr.zeroLenAsEOF, false, false, cr.maxAllowedSectionSize)
 	if err != nil {
 		return nil, err
 	}
@@ -258,6 +258,27 @@ func (h CarHeader) Matches(other CarHeader) bool {
 	return true
 }
 
+// MatchesExactly checks whether two headers match exactly.
+// Two headers are considered exactly matching if:
+//  1. They have the same version number, and
+//  2. They contain the same root CIDs in the same order, including any duplicates.
+//
+// Unlike Matches, this function is sensitive to the order and multiplicity of roots.
+func (h CarHeader) MatchesExactly(other CarHeader) bool {
+	if h.Version != other.Version {
+		return false
+	}
+	if len(h.Roots) != len(other.Roots) {
+		return false
+	}
+	for i, r := range h.Roots {
+		if !r.Equals(other.Roots[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 func (h *CarHeader) containsRoot(root cid.Cid) bool {
 	for _, r := range h.Roots {
 		if r.Equals(root) {
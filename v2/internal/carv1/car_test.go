@@ -15,6 +15,7 @@ import (
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
 	dstest "github.com/ipfs/go-merkledag/test"
+	"github.com/multiformats/go-varint"
 )
 
 func assertAddNodes(t *testing.T, ds format.DAGService, nds ...format.Node) {
@@ -331,3 +332,122 @@ func TestReadingZeroLengthSectionWithOptionSetIsSuccess(t *testing.T) {
 		require.NoError(t, err)
 	}
 }
+
+func TestCarHeaderMatchesExactly(t *testing.T) {
+	oneCid := merkledag.NewRawNode([]byte("fish")).Cid()
+	anotherCid := merkledag.NewRawNode([]byte("lobster")).Cid()
+	tests := []struct {
+		name  string
+		one   CarHeader
+		other CarHeader
+		want  bool
+	}{
+		{
+			"SameVersionNilRootsIsMatching",
+			CarHeader{nil, 1},
+			CarHeader{nil, 1},
+			true,
+		},
+		{
+			"SameVersionNilAndEmptyRootsIsMatching",
+			CarHeader{nil, 1},
+			CarHeader{[]cid.Cid{}, 1},
+			true,
+		},
+		{
+			"SameVersionSameRootsInSameOrderIsMatching",
+			CarHeader{[]cid.Cid{oneCid, anotherCid}, 1},
+			CarHeader{[]cid.Cid{oneCid, anotherCid}, 1},
+			true,
+		},
+		{
+			"SameVersionSameRootsInDifferentOrderIsNotMatching",
+			CarHeader{[]cid.Cid{oneCid, anotherCid}, 1},
+			CarHeader{[]cid.Cid{anotherCid, oneCid}, 1},
+			false,
+		},
+		{
+			"SameVersionSameDuplicateRootsIsMatching",
+			CarHeader{[]cid.Cid{oneCid, anotherCid, oneCid}, 1},
+			CarHeader{[]cid.Cid{oneCid, anotherCid, oneCid}, 1},
+			true,
+		},
+		{
+			"SameVersionDifferentDuplicateRootsIsNotMatching",
+			CarHeader{[]cid.Cid{oneCid, anotherCid, oneCid}, 1},
+			CarHeader{[]cid.Cid{oneCid, anotherCid, anotherCid}, 1},
+			false,
+		},
+		{
+			"MismatchingVersionIsNotMatching",
+			CarHeader{[]cid.Cid{oneCid}, 0},
+			CarHeader{[]cid.Cid{oneCid}, 1},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.one.MatchesExactly(tt.other)
+			require.Equal(t, tt.want, got, "MatchesExactly() = %v, want %v", got, tt.want)
+		})
+	}
+}
+
+func TestCarHeaderWithCidV0Roots(t *testing.T) {
+	v0 := merkledag.NodeWithData([]byte("fish")).Cid()
+	require.Equal(t, uint64(0), v0.Version())
+	v1 := merkledag.NewRawNode([]byte("lobster")).Cid()
+	header := &CarHeader{Roots: []cid.Cid{v0, v1, v0}, Version: 1}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteHeader(header, buf))
+	size, err := HeaderSize(header)
+	require.NoError(t, err)
+	require.Equal(t, uint64(buf.Len()), size)
+
+	got, err := ReadHeader(bytes.NewReader(buf.Bytes()), DefaultMaxAllowedHeaderSize)
+	require.NoError(t, err)
+	require.True(t, header.MatchesExactly(*got))
+	require.Equal(t, uint64(0), got.Roots[0].Version())
+	require.Equal(t, v0.Bytes(), got.Roots[0].Bytes())
+
+	it, err := NewRootsIterator(bytes.NewReader(buf.Bytes()), DefaultMaxAllowedHeaderSize)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), it.Count())
+	for _, want := range header.Roots {
+		root, ok, err := it.Next()
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, want, root)
+	}
+	_, ok, err := it.Next()
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// A CIDv1 with the same codec and multihash is a different root.
+	sameHash := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+	require.False(t, header.MatchesExactly(CarHeader{Roots: []cid.Cid{sameHash, v1, sameHash}, Version: 1}))
+	require.False(t, header.Matches(CarHeader{Roots: []cid.Cid{v1, sameHash, sameHash}, Version: 1}))
+}
+
+func TestRootsIteratorRejectsTrailingBytesAfterCid(t *testing.T) {
+	root := merkledag.NodeWithData([]byte("fish")).Cid()
+	encoded := append([]byte{0x00}, root.Bytes()...)
+	encoded = append(encoded, 0xff)
+	header := append([]byte{0xa2, 0x65}, "roots"...)
+	header = append(header, 0x81, 0xd8, 0x2a, 0x58, byte(len(encoded)))
+	header = append(header, encoded...)
+	header = append(header, 0x67)
+	header = append(header, "version"...)
+	header = append(header, 0x01)
+	payload := append(varint.ToUvarint(uint64(len(header))), header...)
+
+	_, err := ReadHeader(bytes.NewReader(payload), DefaultMaxAllowedHeaderSize)
+	require.Error(t, err)
+
+	it, err := NewRootsIterator(bytes.NewReader(payload), DefaultMaxAllowedHeaderSize)
+	require.NoError(t, err)
+	_, ok, err := it.Next()
+	require.Error(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,264 @@
+package carv1
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+
+	cid "github.com/ipfs/go-cid"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-varint"
+)
+
+// CBOR major types and the tag of CIDs, as used by the DAG-CBOR encoding of CarHeader.
+const (
+	cborMajorBytes  = 2
+	cborMajorText   = 3
+	cborMajorArray  = 4
+	cborMajorMap    = 5
+	cborMajorTag    = 6
+	cborMajorSimple = 7
+
+	cborSimpleNull = 22
+	cborTagCid     = 42
+
+	// minEncodedRootSize is the minimum number of bytes taken by an encoded root: two bytes for the
+	// tag, one for the byte string length, one for the multibase prefix and at least one for the CID.
+	minEncodedRootSize = 5
+
+	// maxSkipDepth is the maximum nesting depth of the header fields skipped while seeking roots.
+	maxSkipDepth = 32
+)
+
+var errIndefiniteLength = errors.New("invalid header: indefinite length items are not supported")
+
+// RootsIterator decodes the roots of a CARv1 header one at a time, as they are read from the
+// underlying reader, such that the roots are never held in memory all at once.
+type RootsIterator struct {
+	r *bufio.Reader
+	// headerLen is the length of the header, which bounds the length of any item within it.
+	headerLen uint64
+	count     uint64
+	read      uint64
+	err       error
+}
+
+// NewRootsIterator reads the CARv1 header from r up to its roots, and returns an iterator over
+// them. The declared number of roots is validated against the length of the header, so that a
+// maliciously large count is rejected without decoding any root; see RootsIterator.Count.
+// Any fields that precede the roots in the header are skipped.
+func NewRootsIterator(r io.Reader, maxReadBytes uint64) (*RootsIterator, error) {
+	l, err := varint.ReadUvarint(internalio.ToByteReader(r))
+	if err != nil {
+		if l > 0 && err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if l > maxReadBytes {
+		return nil, util.ErrHeaderTooLarge
+	}
+	it := &RootsIterator{
+		r:         bufio.NewReader(io.LimitReader(r, int64(l))),
+		headerLen: l,
+	}
+	if err := it.seekRoots(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return it, nil
+}
+
+// Count returns the number of roots declared by the header.
+func (it *RootsIterator) Count() uint64 {
+	return it.count
+}
+
+// Next decodes the next root. It returns false once all roots are decoded, or if an error occurs.
+func (it *RootsIterator) Next() (cid.Cid, bool, error) {
+	if it.err != nil {
+		return cid.Undef, false, it.err
+	}
+	if it.read == it.count {
+		return cid.Undef, false, nil
+	}
+	c, err := it.readRoot()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		it.err = err
+		return cid.Undef, false, err
+	}
+	it.read++
+	return c, true, nil
+}
+
+// seekRoots reads the header map up to the value of its roots key, and reads the number of roots.
+func (it *RootsIterator) seekRoots() error {
+	major, entries, err := it.readItemHeader()
+	if err != nil {
+		return err
+	}
+	if major != cborMajorMap {
+		return fmt.Errorf("invalid header: expected map, got major type %d", major)
+	}
+	for i := uint64(0); i < entries; i++ {
+		major, keyLen, err := it.readItemHeader()
+		if err != nil {
+			return err
+		}
+		if major != cborMajorText {
+			return fmt.Errorf("invalid header: expected text key, got major type %d", major)
+		}
+		key, err := it.readBytes(keyLen)
+		if err != nil {
+			return err
+		}
+		if string(key) != "roots" {
+			if err := it.skipItem(0); err != nil {
+				return err
+			}
+			continue
+		}
+
+		major, count, err := it.readItemHeader()
+		if err != nil {
+			return err
+		}
+		switch {
+		case major == cborMajorSimple && count == cborSimpleNull:
+			return nil
+		case major != cborMajorArray:
+			return fmt.Errorf("invalid header: expected roots array, got major type %d", major)
+		case count > it.headerLen/minEncodedRootSize:
+			return fmt.Errorf("invalid header: %d roots cannot fit in a header of %d bytes", count, it.headerLen)
+		}
+		it.count = count
+		return nil
+	}
+	return errors.New("invalid header: no roots")
+}
+
+// readRoot reads a single CID, encoded as a tagged byte string prefixed by the identity multibase.
+func (it *RootsIterator) readRoot() (cid.Cid, error) {
+	major, tag, err := it.readItemHeader()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if major != cborMajorTag || tag != cborTagCid {
+		return cid.Undef, fmt.Errorf("invalid header: expected CID tag, got major type %d with value %d", major, tag)
+	}
+	major, l, err := it.readItemHeader()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if major != cborMajorBytes {
+		return cid.Undef, fmt.Errorf("invalid header: expected CID bytes, got major type %d", major)
+	}
+	b, err := it.readBytes(l)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(b) == 0 || b[0] != 0 {
+		return cid.Undef, errors.New("invalid header: CID bytes must be prefixed by the identity multibase")
+	}
+	// Reject trailing bytes after the CID, as does cid.Cast when decoding the header as a whole.
+	n, c, err := cid.CidFromBytes(b[1:])
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid header: %w", err)
+	}
+	if n != len(b)-1 {
+		return cid.Undef, fmt.Errorf("invalid header: %d trailing bytes after CID %s", len(b)-1-n, c)
+	}
+	return c, nil
+}
+
+// readItemHeader reads the header of a CBOR item, returning its major type along with its
+// argument, i.e. its value, length or number of entries depending on the major type.
+func (it *RootsIterator) readItemHeader() (byte, uint64, error) {
+	first, err := it.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := first>>5, first&0x1f
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	case info == 31:
+		return 0, 0, errIndefiniteLength
+	default:
+		return 0, 0, fmt.Errorf("invalid header: reserved additional information %d", info)
+	}
+	var buf [8]byte
+	if _, err := io.ReadFull(it.r, buf[8-n:]); err != nil {
+		return 0, 0, err
+	}
+	return major, binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// readBytes reads the next l bytes, which must not exceed the length of the header.
+func (it *RootsIterator) readBytes(l uint64) ([]byte, error) {
+	if l > it.headerLen {
+		return nil, fmt.Errorf("invalid header: item length %d exceeds header length %d", l, it.headerLen)
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(it.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// skipItem skips over the next CBOR item at the given nesting depth, including any items nested
+// within it.
+func (it *RootsIterator) skipItem(depth int) error {
+	if depth > maxSkipDepth {
+		return errors.New("invalid header: too deeply nested")
+	}
+	major, arg, err := it.readItemHeader()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cborMajorBytes, cborMajorText:
+		if arg > it.headerLen {
+			return fmt.Errorf("invalid header: item length %d exceeds header length %d", arg, it.headerLen)
+		}
+		_, err := it.r.Discard(int(arg))
+		return err
+	case cborMajorArray, cborMajorMap:
+		if arg > it.headerLen {
+			return fmt.Errorf("invalid header: %d entries exceed header length %d", arg, it.headerLen)
+		}
+		items := arg
+		if major == cborMajorMap {
+			items *= 2
+		}
+		for i := uint64(0); i < items; i++ {
+			if err := it.skipItem(depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case cborMajorTag:
+		return it.skipItem(depth + 1)
+	default:
+		// Unsigned and negative integers, and simple values, have no content beyond the header.
+		return nil
+	}
+}
@@ -19,8 +19,8 @@ type BytesReader interface {
 	io.ByteReader
 }
 
-func ReadNode(r io.Reader, zeroLenAsEOF bool, maxReadBytes uint64) (cid.Cid, []byte, error) {
-	data, err := LdRead(r, zeroLenAsEOF, maxReadBytes)
+func ReadNode(r io.Reader, zeroLenAsEOF, skipZeroLen, lenientVarints bool, maxReadBytes uint64) (cid.Cid, []byte, error) {
+	data, err := LdRead(r, zeroLenAsEOF, skipZeroLen, lenientVarints, maxReadBytes)
 	if err != nil {
 		return cid.Cid{}, nil, err
 	}
@@ -65,8 +65,13 @@ func LdSize(d ...[]byte) uint64 {
 	return sum + uint64(s)
 }
 
-func LdRead(r io.Reader, zeroLenAsEOF bool, maxReadBytes uint64) ([]byte, error) {
-	l, err := varint.ReadUvarint(internalio.ToByteReader(r))
+func LdRead(r io.Reader, zeroLenAsEOF, skipZeroLen, lenientVarints bool, maxReadBytes uint64) ([]byte, error) {
+	br := internalio.ToByteReader(r)
+	l, _, err := ReadUvarint(br, lenientVarints)
+	// Skip over null padding, i.e. consecutive zero-length sections, if asked to.
+	for err == nil && l == 0 && skipZeroLen {
+		l, _, err = ReadUvarint(br, lenientVarints)
+	}
 	if err != nil {
 		// If the length of bytes read is non-zero when the error is EOF then signal an unclean EOF.
 		if l > 0 && err == io.EOF {
@@ -88,3 +93,36 @@ func LdRead(r io.Reader, zeroLenAsEOF bool, maxReadBytes uint64) ([]byte, error)
 
 	return buf, nil
 }
+
+// ReadUvarint reads an unsigned varint from r, and returns it along with the number of bytes it
+// spans. Like varint.ReadUvarint, non-minimal encodings are rejected with varint.ErrNotMinimal,
+// unless lenient is true, in which case they are accepted as long as they span no more than
+// varint.MaxLenUvarint63 bytes. Since the number of bytes read may then be larger than the
+// varint.UvarintSize of the value, callers that compute offsets must use the returned length.
+func ReadUvarint(r io.ByteReader, lenient bool) (uint64, int, error) {
+	// Modified from varint.ReadUvarint, which is itself modified from the go standard library.
+	var x uint64
+	var s uint
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i != 0 {
+				// Having read part of a value is not a clean EOF.
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, i, err
+		}
+		if (i == 8 && b >= 0x80) || i >= varint.MaxLenUvarint63 {
+			return 0, i + 1, varint.ErrOverflow
+		}
+		if b < 0x80 {
+			// A trailing zero byte contributes nothing to the value, i.e. a shorter encoding exists.
+			if b == 0 && s > 0 && !lenient {
+				return 0, i + 1, varint.ErrNotMinimal
+			}
+			return x | uint64(b)<<s, i + 1, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+}
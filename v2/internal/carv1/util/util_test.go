@@ -2,11 +2,13 @@ package util_test
 
 import (
 	"bytes"
+	"io"
 	"math/rand"
 	"testing"
 
 	"github.com/ipld/go-car/v2/internal/carv1/util"
 
+	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,3 +27,39 @@ func TestLdSize(t *testing.T) {
 		require.Equal(t, uint64(len(buf.Bytes())), size)
 	}
 }
+
+func TestReadUvarint(t *testing.T) {
+	tests := []struct {
+		name           string
+		encoded        []byte
+		wantValue      uint64
+		wantLen        int
+		wantStrictErr  error
+		wantLenientErr error
+	}{
+		{"Minimal", []byte{0x01}, 1, 1, nil, nil},
+		{"MinimalMultiByte", []byte{0xac, 0x02}, 300, 2, nil, nil},
+		{"NonMinimal", []byte{0x81, 0x00}, 1, 2, varint.ErrNotMinimal, nil},
+		{"NonMinimalZero", []byte{0x80, 0x80, 0x00}, 0, 3, varint.ErrNotMinimal, nil},
+		{"NonMinimalMaxLength", []byte{0x81, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, 1, 9, varint.ErrNotMinimal, nil},
+		{"Overflow", []byte{0x81, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, 0, 9, varint.ErrOverflow, varint.ErrOverflow},
+		{"Truncated", []byte{0x81, 0x80}, 0, 2, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF},
+		{"Empty", []byte{}, 0, 0, io.EOF, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, lenient := range []bool{false, true} {
+				wantErr := tt.wantStrictErr
+				if lenient {
+					wantErr = tt.wantLenientErr
+				}
+				gotValue, gotLen, err := util.ReadUvarint(bytes.NewReader(tt.encoded), lenient)
+				require.Equal(t, wantErr, err)
+				require.Equal(t, tt.wantLen, gotLen)
+				if wantErr == nil {
+					require.Equal(t, tt.wantValue, gotValue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package io
+
+import (
+	"io"
+	"sync"
+)
+
+var _ io.ReaderAt = (*prefetchReaderAt)(nil)
+
+// prefetchReaderAt is an io.ReaderAt that reads ahead of the requested range into a buffer, such
+// that subsequent reads falling within the buffered range are served from memory.
+// This turns the many small sequential reads performed when walking a CAR payload, e.g. to read
+// section varints and CIDs, into fewer larger reads on the underlying io.ReaderAt.
+//
+// Reads that fall outside the buffered range simply refill the buffer, so random access is
+// supported, albeit without any benefit from buffering.
+type prefetchReaderAt struct {
+	r   io.ReaderAt
+	buf []byte
+	off int64 // offset of buf[0] in r.
+	mu  sync.Mutex
+}
+
+// NewPrefetchReaderAt returns an io.ReaderAt that reads from r in chunks of at least size bytes,
+// buffering the data that is read ahead.
+// It is safe for concurrent use as long as r is.
+func NewPrefetchReaderAt(r io.ReaderAt, size int) io.ReaderAt {
+	return &prefetchReaderAt{
+		r:   r,
+		buf: make([]byte, 0, size),
+	}
+}
+
+func (p *prefetchReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, io.EOF
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Serve from the buffer if it contains the entire requested range.
+	if off >= p.off && off+int64(len(b)) <= p.off+int64(len(p.buf)) {
+		return copy(b, p.buf[off-p.off:]), nil
+	}
+
+	// Read directly into b if the requested range is too large to benefit from buffering.
+	if len(b) >= cap(p.buf) {
+		return p.r.ReadAt(b, off)
+	}
+
+	// Otherwise, refill the buffer starting from off.
+	n, err := p.r.ReadAt(p.buf[:cap(p.buf)], off)
+	if err != nil && err != io.EOF {
+		p.buf = p.buf[:0]
+		return 0, err
+	}
+	p.buf = p.buf[:n]
+	p.off = off
+	n = copy(b, p.buf)
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,53 @@
+// Package links extracts the links of IPLD blocks, as needed to walk a DAG stored in a CAR.
+package links
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/multiformats/go-multicodec"
+)
+
+// Extract decodes the given block data according to the codec of c and returns the CIDs it links
+// to, in the order they appear. Blocks encoded as raw, dag-pb or dag-cbor are supported; raw blocks
+// have no links. An error is returned if the block uses any other codec or fails to decode.
+func Extract(c cid.Cid, data []byte) ([]cid.Cid, error) {
+	var nb datamodel.NodeBuilder
+	var err error
+	switch multicodec.Code(c.Prefix().Codec) {
+	case multicodec.Raw:
+		return nil, nil
+	case multicodec.DagPb:
+		nb = dagpb.Type.PBNode.NewBuilder()
+		err = dagpb.DecodeBytes(nb, data)
+	case multicodec.DagCbor:
+		nb = basicnode.Prototype.Any.NewBuilder()
+		err = dagcbor.Decode(nb, bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("cannot extract links from block %s: unsupported codec %s", c, multicodec.Code(c.Prefix().Codec))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode block %s: %w", c, err)
+	}
+
+	links, err := traversal.SelectLinks(nb.Build())
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract links from block %s: %w", c, err)
+	}
+	cids := make([]cid.Cid, 0, len(links))
+	for _, link := range links {
+		cl, ok := link.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("block %s contains unsupported link type %T", c, link)
+		}
+		cids = append(cids, cl.Cid)
+	}
+	return cids, nil
+}
@@ -74,6 +74,7 @@ func (w *writingReader) Read(p []byte) (int, error) {
 		w.wo.rcrds[c] = index.Record{
 			Cid:    c,
 			Offset: w.wo.size,
+			Size:   uint64(w.len) + uint64(len(w.cid)),
 		}
 		w.wo.size += uint64(w.len) + uint64(len(size)+len(w.cid))
 
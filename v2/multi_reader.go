@@ -0,0 +1,93 @@
+package car
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+var _ io.ReaderAt = (*multiReaderAt)(nil)
+
+// multiReaderAt implements io.ReaderAt over the logical concatenation of several io.ReaderAt.
+type multiReaderAt struct {
+	readers []io.ReaderAt
+	// ends holds the offset at which each reader ends in the concatenation, i.e. the cumulative
+	// sum of reader sizes.
+	ends []int64
+}
+
+// MultiReaderAt returns an io.ReaderAt that is the logical concatenation of the given readers,
+// where sizes specifies the number of bytes read from each reader. Offsets are translated to
+// the reader that holds them, and reads that straddle the boundary between two readers are
+// stitched together from both. Reads past the total size return io.EOF.
+//
+// This allows a CAR split across several files, along with an index with offsets relative to
+// the whole CAR, to be read via NewReader or blockstore.NewReadOnly without concatenating the
+// files on disk. The returned reader implements Size, which returns the total size.
+//
+// MultiReaderAt panics if the number of readers and sizes differ, or if any size is negative.
+func MultiReaderAt(readers []io.ReaderAt, sizes []int64) io.ReaderAt {
+	if len(readers) != len(sizes) {
+		panic(fmt.Sprintf("car: number of readers %d does not match number of sizes %d", len(readers), len(sizes)))
+	}
+	m := &multiReaderAt{
+		readers: append([]io.ReaderAt(nil), readers...),
+		ends:    make([]int64, len(sizes)),
+	}
+	var end int64
+	for i, size := range sizes {
+		if size < 0 {
+			panic(fmt.Sprintf("car: invalid negative size of reader %d: %d", i, size))
+		}
+		end += size
+		m.ends[i] = end
+	}
+	return m
+}
+
+// Size returns the total size of the concatenated readers.
+func (m *multiReaderAt) Size() int64 {
+	if len(m.ends) == 0 {
+		return 0
+	}
+	return m.ends[len(m.ends)-1]
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid negative offset: %d", off)
+	}
+	if off >= m.Size() {
+		return 0, io.EOF
+	}
+
+	// Find the first reader that ends after off, skipping over empty readers.
+	i := sort.Search(len(m.ends), func(i int) bool { return m.ends[i] > off })
+	var n int
+	for n < len(p) && i < len(m.readers) {
+		var start int64
+		if i > 0 {
+			start = m.ends[i-1]
+		}
+		// Read up to the end of the current reader.
+		want := len(p) - n
+		if remaining := m.ends[i] - off; int64(want) > remaining {
+			want = int(remaining)
+		}
+		read, err := m.readers[i].ReadAt(p[n:n+want], off-start)
+		n += read
+		off += int64(read)
+		if read < want {
+			if err == nil || err == io.EOF {
+				// The reader is shorter than its given size.
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
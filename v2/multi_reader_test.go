@@ -0,0 +1,118 @@
+package car_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiReaderAt(t *testing.T) {
+	content := []byte("fishlobstercrabshrimp")
+	// Split the content into shards, including an empty one.
+	shards := [][]byte{content[:4], content[4:11], {}, content[11:15], content[15:]}
+	readers := make([]io.ReaderAt, len(shards))
+	sizes := make([]int64, len(shards))
+	for i, shard := range shards {
+		readers[i] = bytes.NewReader(shard)
+		sizes[i] = int64(len(shard))
+	}
+	subject := carv2.MultiReaderAt(readers, sizes)
+	require.Equal(t, int64(len(content)), subject.(interface{ Size() int64 }).Size())
+
+	tests := []struct {
+		name   string
+		off    int64
+		length int
+	}{
+		{name: "WithinFirstShard", off: 1, length: 2},
+		{name: "WithinMiddleShard", off: 5, length: 5},
+		{name: "EntireShard", off: 4, length: 7},
+		{name: "StraddlingTwoShards", off: 2, length: 5},
+		{name: "StraddlingEmptyShard", off: 9, length: 4},
+		{name: "StraddlingAllShards", off: 0, length: len(content)},
+		{name: "EndOfLastShard", off: int64(len(content) - 3), length: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]byte, tt.length)
+			n, err := subject.ReadAt(got, tt.off)
+			require.NoError(t, err)
+			require.Equal(t, tt.length, n)
+			require.Equal(t, content[tt.off:tt.off+int64(tt.length)], got)
+		})
+	}
+
+	// Assert reads that go past the end are trimmed and signal io.EOF.
+	got := make([]byte, 10)
+	n, err := subject.ReadAt(got, int64(len(content)-4))
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, content[len(content)-4:], got[:n])
+	n, err = subject.ReadAt(got, int64(len(content)))
+	require.Equal(t, io.EOF, err)
+	require.Zero(t, n)
+	_, err = subject.ReadAt(got, -1)
+	require.Error(t, err)
+}
+
+func TestMultiReaderAtShorterThanSizeIsError(t *testing.T) {
+	subject := carv2.MultiReaderAt(
+		[]io.ReaderAt{bytes.NewReader([]byte("fish")), bytes.NewReader([]byte("lobster"))},
+		[]int64{6, 7})
+	got := make([]byte, 8)
+	_, err := subject.ReadAt(got, 0)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestMultiReaderAtMismatchingSizesPanics(t *testing.T) {
+	require.Panics(t, func() {
+		carv2.MultiReaderAt([]io.ReaderAt{bytes.NewReader(nil)}, nil)
+	})
+	require.Panics(t, func() {
+		carv2.MultiReaderAt([]io.ReaderAt{bytes.NewReader(nil)}, []int64{-1})
+	})
+}
+
+func TestMultiReaderAtWithReadOnlyBlockstore(t *testing.T) {
+	path := "testdata/sample-wrapped-v2.car"
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// Split the CAR into shards of odd sizes such that sections straddle shard boundaries.
+	var readers []io.ReaderAt
+	var sizes []int64
+	for start := 0; start < len(content); start += 333 {
+		end := start + 333
+		if end > len(content) {
+			end = len(content)
+		}
+		readers = append(readers, bytes.NewReader(content[start:end]))
+		sizes = append(sizes, int64(end-start))
+	}
+	subject, err := blockstore.NewReadOnly(carv2.MultiReaderAt(readers, sizes), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+	want, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, want.Close()) })
+
+	ctx := context.Background()
+	keys, err := want.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var count int
+	for key := range keys {
+		wantBlock, err := want.Get(ctx, key)
+		require.NoError(t, err)
+		gotBlock, err := subject.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, wantBlock, gotBlock)
+		count++
+	}
+	require.NotZero(t, count)
+}
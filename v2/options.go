@@ -1,9 +1,12 @@
 package car
 
 import (
+	"context"
 	"math"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/v2/index"
+	ipld "github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/traversal"
 	"github.com/multiformats/go-multicodec"
 
@@ -29,6 +32,33 @@ const DefaultMaxAllowedHeaderSize = carv1.DefaultMaxAllowedHeaderSize
 // Currently set to 8 MiB.
 const DefaultMaxAllowedSectionSize = carv1.DefaultMaxAllowedSectionSize
 
+// DefaultMaxAllowedPadding specifies the default maximum size in bytes accepted as either data
+// or index padding when writing a CARv2. This is to prevent malformed headers, or huge allocations
+// and files, as a result of unreasonably large padding values.
+// Currently set to 1 GiB.
+const DefaultMaxAllowedPadding = 1 << 30
+
+// DefaultMaxDuplicateLookups specifies the default maximum number of index records with the same
+// multihash that a blockstore looks at when looking up a single block. This is to prevent CAR
+// files containing a very large number of sections with the same multihash from making lookups
+// arbitrarily expensive.
+// Currently set to 1024.
+const DefaultMaxDuplicateLookups = 1 << 10
+
+// DefaultMmapIndexThreshold specifies the default size in bytes of the index embedded in a CARv2
+// above which a read-only blockstore looks up blocks in the index as stored in the CARv2, rather
+// than reading the entire index into memory upfront.
+// Currently set to 64 MiB.
+const DefaultMmapIndexThreshold = 64 << 20
+
+// DefaultIndexProgressInterval specifies the default number of sections indexed in between calls
+// to the callback set via WithIndexProgress.
+// Currently set to 1024.
+const DefaultIndexProgressInterval = 1 << 10
+
+// IndexProgressFunc is called to report the progress of index generation; see WithIndexProgress.
+type IndexProgressFunc func(sectionsIndexed uint64, bytesRead, totalBytes int64)
+
 // Option describes an option which affects behavior when interacting with CAR files.
 type Option func(*Options)
 
@@ -52,17 +82,66 @@ type Options struct {
 	IndexPadding           uint64
 	IndexCodec             multicodec.Code
 	ZeroLengthSectionAsEOF bool
+	SkipNullPadding        bool
+	LenientVarints         bool
 	MaxIndexCidSize        uint64
 	StoreIdentityCIDs      bool
+	DataChecksum           multicodec.Code
 
-	BlockstoreAllowDuplicatePuts bool
-	BlockstoreUseWholeCIDs       bool
-	MaxTraversalLinks            uint64
-	WriteAsCarV1                 bool
-	TraversalPrototypeChooser    traversal.LinkTargetNodePrototypeChooser
+	BlockstoreAllowDuplicatePuts   bool
+	BlockstoreUseWholeCIDs         bool
+	BlockstoreIndexWALPath         string
+	BlockstoreExistingIndex        index.Index
+	BlockstoreInsertionIndexMode   index.InsertionIndexMode
+	BlockstoreEmbeddedIndex        bool
+	BlockstoreMmapIndex            bool
+	BlockstoreMmapIndexThreshold   uint64
+	BlockstoreDisableMmap          bool
+	BlockstoreStrictCodecMatch     bool
+	BlockstoreMaxDuplicateLookups  uint64
+	BlockstoreDisableIndexChecksum bool
+	BlockstoreSyncOnFinalize       bool
+	BlockstoreSyncInterval         uint64
+	BlockstoreExpectedSize         uint64
+	BlockstoreGetHook              func(c cid.Cid, size int, err error)
+	BlockstoreHasHook              func(c cid.Cid, has bool, err error)
+	BlockstorePutHook              func(c cid.Cid, size int, err error)
+	BlockstoreVerifyOnGet          bool
+	BlockstoreVerifyPutHashes      bool
+	BlockstoreIndexMismatchHook    func(key cid.Cid, indexedOffset, actualOffset uint64, found bool)
+	BlockstoreBloomFPRate          float64
+	BlockstoreBloom                *index.Bloom
+	BlockstoreGzipBacking          bool
+	BlockstoreGzipTempFile         bool
+	BlockstoreGzipTempDir          string
+	BlockstoreTraversalRoot        cid.Cid
+	BlockstoreTraversalLinkSystem  ipld.LinkSystem
+	MaxTraversalLinks              uint64
+	WriteAsCarV1                   bool
+	TraversalPrototypeChooser      traversal.LinkTargetNodePrototypeChooser
 
 	MaxAllowedHeaderSize  uint64
 	MaxAllowedSectionSize uint64
+	MaxAllowedPadding     uint64
+	MaxRoots              uint64
+
+	ReadBufferSize int
+
+	IndexProgress         IndexProgressFunc
+	IndexProgressInterval uint64
+	IndexContext          context.Context
+	IndexWarning          IndexWarningFunc
+
+	AcceptedVersions []uint64
+
+	CompareBlockData bool
+
+	ValidateBlockHashes bool
+
+	DetectDataSize bool
+	DryRun         bool
+
+	PadRootsWithIdentityCID bool
 }
 
 // ApplyOptions applies given opts and returns the resulting Options.
@@ -73,6 +152,7 @@ func ApplyOptions(opt ...Option) Options {
 		MaxTraversalLinks:     math.MaxInt64, //default: traverse all
 		MaxAllowedHeaderSize:  carv1.DefaultMaxAllowedHeaderSize,
 		MaxAllowedSectionSize: carv1.DefaultMaxAllowedSectionSize,
+		MaxAllowedPadding:     DefaultMaxAllowedPadding,
 	}
 	for _, o := range opt {
 		o(&opts)
@@ -84,6 +164,18 @@ func ApplyOptions(opt ...Option) Options {
 	if opts.MaxIndexCidSize == 0 {
 		opts.MaxIndexCidSize = DefaultMaxIndexCidSize
 	}
+	if opts.BlockstoreMaxDuplicateLookups == 0 {
+		opts.BlockstoreMaxDuplicateLookups = DefaultMaxDuplicateLookups
+	}
+	if opts.BlockstoreMmapIndexThreshold == 0 {
+		opts.BlockstoreMmapIndexThreshold = DefaultMmapIndexThreshold
+	}
+	if opts.IndexProgressInterval == 0 {
+		opts.IndexProgressInterval = DefaultIndexProgressInterval
+	}
+	if opts.AcceptedVersions == nil {
+		opts.AcceptedVersions = []uint64{1, 2}
+	}
 	return opts
 }
 
@@ -97,6 +189,37 @@ func ZeroLengthSectionAsEOF(enable bool) Option {
 	}
 }
 
+// WithSkipNullPadding sets the CARv1 decoder to treat a zero-length section as null padding
+// between sections, which is skipped over such that decoding continues with the next section.
+// For example, this can be useful to read CARs whose producers pad sections for alignment.
+//
+// Since each zero-length section is a single null byte, padding of any length is skipped. When
+// enabled, this option takes precedence over ZeroLengthSectionAsEOF; the decoder stops at the end
+// of the input instead.
+func WithSkipNullPadding() Option {
+	return func(o *Options) {
+		o.SkipNullPadding = true
+	}
+}
+
+// WithLenientVarints sets the CARv1 decoder to accept section lengths whose varints are not
+// minimally encoded, e.g. 0x81 0x00 rather than 0x01 for a length of one. For example, this can be
+// useful to read CARs written by legacy producers with such framing quirks.
+//
+// By default, non-minimal varints are rejected with varint.ErrNotMinimal. Enabling this option
+// weakens that guarantee: the same CAR payload then has more than one valid encoding, such that
+// CARs with identical sections may differ byte-wise, and checks that compare or hash raw CAR bytes
+// may be sidestepped by re-encoding section lengths. Only enable it for input from trusted
+// producers known to emit non-minimal varints. Varints are still limited to 9 bytes, i.e.
+// varint.MaxLenUvarint63, and varints within CIDs and the CAR header length are always required to
+// be minimal. CARs resumed by blockstore.OpenReadWrite are scanned strictly regardless, since their
+// sections are always written minimally.
+func WithLenientVarints() Option {
+	return func(o *Options) {
+		o.LenientVarints = true
+	}
+}
+
 // UseDataPadding sets the padding to be added between CARv2 header and its data payload on Finalize.
 func UseDataPadding(p uint64) Option {
 	return func(o *Options) {
@@ -112,12 +235,37 @@ func UseIndexPadding(p uint64) Option {
 }
 
 // UseIndexCodec sets the codec used for index generation.
+// Use index.CarCidIndexSorted to generate an index that stores whole CIDs, or
+// index.CarMultihashIndexHashed to generate an index with constant time lookups. Since an index
+// that stores whole CIDs cannot be looked up by multihash alone, blockstores key its records by
+// multihash on the first lookup by multihash, e.g. via blockstore.ReadOnly.HasMultihash, at a cost
+// in time and memory linear in the number of records.
+//
+// The codec is respected wherever an index is generated, i.e. by GenerateIndex and the functions
+// built on it, GenerateIndexParallel, WrapV1, AttachIndexToFile, and blockstores that generate an
+// index for a CAR without one. Generating an index fails with an *index.ErrUnknownIndexCodec error
+// if the codec is neither defined by the index package nor registered via index.RegisterCodec.
+// Defaults to multicodec.CarMultihashIndexSorted.
 func UseIndexCodec(c multicodec.Code) Option {
 	return func(o *Options) {
 		o.IndexCodec = c
 	}
 }
 
+// WithDataChecksum sets the algorithm used to compute a checksum of the data payload, which is
+// stored right after the data payload, i.e. at the start of the index padding. The index padding is
+// increased by the size of the checksum accordingly. Use multicodec.Sha2_256 or Crc32c; the latter
+// is cheaper to compute but only suitable to detect accidental corruption.
+//
+// The checksum is written by WrapV1 and on blockstore.ReadWrite.Finalize, unless the blockstore is
+// written as a CARv1, and is verified via Reader.VerifyDataChecksum. By default, no checksum is
+// written.
+func WithDataChecksum(algo multicodec.Code) Option {
+	return func(o *Options) {
+		o.DataChecksum = algo
+	}
+}
+
 // WithoutIndex flags that no index should be included in generation.
 func WithoutIndex() Option {
 	return func(o *Options) {
@@ -130,6 +278,11 @@ func WithoutIndex() Option {
 // When writing CAR files with this option,
 // Characteristics.IsFullyIndexed will be set.
 //
+// The option also controls whether such sections are recorded in the index when indexing an
+// existing data payload, which includes GenerateIndex and the index written by
+// blockstore.ReadWrite.Finalize when resuming from a file that contains them. Since identity CIDs
+// are resolved inline, and never need to be looked up, they are excluded by default.
+//
 // This option is disabled by default.
 func StoreIdentityCIDs(b bool) Option {
 	return func(o *Options) {
@@ -172,3 +325,89 @@ func MaxAllowedSectionSize(max uint64) Option {
 		o.MaxAllowedSectionSize = max
 	}
 }
+
+// MaxAllowedPadding overrides the default maximum size (of 1 GiB) accepted as either data or index
+// padding when writing a CARv2.
+// Padding larger than the allowed maximum results in ErrPaddingTooLarge error.
+func MaxAllowedPadding(max uint64) Option {
+	return func(o *Options) {
+		o.MaxAllowedPadding = max
+	}
+}
+
+// WithMaxRoots sets the maximum number of roots accepted when reading the roots of a CAR payload via
+// Reader.Roots or Reader.RootsIter. The number of roots declared by the header is checked before
+// any root is decoded, and reading the roots of a payload that declares more than n roots results
+// in an ErrTooManyRoots error. Defaults to zero, i.e. the number of roots is only bounded by
+// MaxAllowedHeaderSize.
+func WithMaxRoots(n uint64) Option {
+	return func(o *Options) {
+		o.MaxRoots = n
+	}
+}
+
+// WithAcceptedVersions sets the CAR versions that are accepted when reading a CAR payload. Reading a
+// payload whose version is not accepted results in an ErrUnsupportedVersion error.
+// Defaults to versions 1 and 2.
+//
+// Versions other than 1 and 2 are read on a best-effort basis, as if they were CARv2; that is, the
+// payload is expected to start with a pragma followed by a CARv2 header. Note that writing to, or
+// converting from, such payloads is not supported.
+func WithAcceptedVersions(versions ...uint64) Option {
+	return func(o *Options) {
+		o.AcceptedVersions = append([]uint64{}, versions...)
+	}
+}
+
+// checkVersion checks that the given CAR version is one of the accepted versions.
+// See: WithAcceptedVersions.
+func (o Options) checkVersion(version uint64) error {
+	for _, v := range o.AcceptedVersions {
+		if v == version {
+			return nil
+		}
+	}
+	return &ErrUnsupportedVersion{Version: version, Accepted: o.AcceptedVersions}
+}
+
+// WithReadBufferSize sets the size of the read-ahead buffer used when generating an index, e.g. via
+// GenerateIndex or LoadIndex, and when iterating over blocks via blockstore.ReadOnly.EachBlock.
+// When set to a positive value, the CAR payload is read in chunks of at least n bytes, which
+// reduces the number of small reads issued against the underlying storage. This is particularly
+// beneficial when reading from spinning disks or over the network.
+//
+// The buffer is only used if the reader from which the index is generated implements both
+// io.ReaderAt and io.Seeker. In that case, the position of the reader after index generation is
+// unspecified. Buffering is disabled by default.
+func WithReadBufferSize(n int) Option {
+	return func(o *Options) {
+		o.ReadBufferSize = n
+	}
+}
+
+// WithIndexProgress sets a callback that reports the progress of index generation, e.g. via
+// GenerateIndex, GenerateIndexParallel, or when a blockstore generates an index or resumes writing
+// to a CAR. The callback is called every interval sections, and once more when all sections have
+// been indexed. If interval is zero, DefaultIndexProgressInterval is used.
+//
+// The callback is passed the number of sections indexed so far, the number of bytes read and the
+// total number of bytes to read. For CARv2 payloads, both are relative to the data payload;
+// otherwise they are relative to the start of the reader, and totalBytes is -1 if the size of the
+// reader is not known. The callback is called synchronously from the goroutine generating the
+// index, and so should return quickly.
+func WithIndexProgress(interval uint64, f IndexProgressFunc) Option {
+	return func(o *Options) {
+		o.IndexProgressInterval = interval
+		o.IndexProgress = f
+	}
+}
+
+// WithIndexContext sets the context checked for cancellation in between sections during index
+// generation, e.g. via GenerateIndex, GenerateIndexParallel, or when a blockstore generates an index
+// or resumes writing to a CAR. Once the context is done, index generation is aborted with the error
+// returned by ctx.Err().
+func WithIndexContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.IndexContext = ctx
+	}
+}
@@ -1,50 +1,132 @@
 package car_test
 
 import (
+	"context"
 	"math"
 	"testing"
 
+	blocks "github.com/ipfs/go-block-format"
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	ipld "github.com/ipld/go-ipld-prime"
 	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 )
 
 func TestApplyOptions_SetsExpectedDefaults(t *testing.T) {
 	require.Equal(t, carv2.Options{
-		IndexCodec:            multicodec.CarMultihashIndexSorted,
-		MaxIndexCidSize:       carv2.DefaultMaxIndexCidSize,
-		MaxTraversalLinks:     math.MaxInt64,
-		MaxAllowedHeaderSize:  32 << 20,
-		MaxAllowedSectionSize: 8 << 20,
+		IndexCodec:                    multicodec.CarMultihashIndexSorted,
+		MaxIndexCidSize:               carv2.DefaultMaxIndexCidSize,
+		MaxTraversalLinks:             math.MaxInt64,
+		MaxAllowedHeaderSize:          32 << 20,
+		MaxAllowedSectionSize:         8 << 20,
+		MaxAllowedPadding:             1 << 30,
+		BlockstoreMaxDuplicateLookups: carv2.DefaultMaxDuplicateLookups,
+		BlockstoreMmapIndexThreshold:  carv2.DefaultMmapIndexThreshold,
+		IndexProgressInterval:         carv2.DefaultIndexProgressInterval,
+		AcceptedVersions:              []uint64{1, 2},
 	}, carv2.ApplyOptions())
 }
 
 func TestApplyOptions_AppliesOptions(t *testing.T) {
+	existingIndex := index.NewMultihashSorted()
+	bloom, err := index.NewBloom(1, 0.01)
+	require.NoError(t, err)
+	traversalRoot := blocks.NewBlock([]byte("fish")).Cid()
 	require.Equal(t,
 		carv2.Options{
-			DataPadding:                  123,
-			IndexPadding:                 456,
-			IndexCodec:                   multicodec.CarIndexSorted,
-			ZeroLengthSectionAsEOF:       true,
-			MaxIndexCidSize:              789,
-			StoreIdentityCIDs:            true,
-			BlockstoreAllowDuplicatePuts: true,
-			BlockstoreUseWholeCIDs:       true,
-			MaxTraversalLinks:            math.MaxInt64,
-			MaxAllowedHeaderSize:         101,
-			MaxAllowedSectionSize:        202,
+			DataPadding:                    123,
+			IndexPadding:                   456,
+			IndexCodec:                     multicodec.CarIndexSorted,
+			ZeroLengthSectionAsEOF:         true,
+			SkipNullPadding:                true,
+			LenientVarints:                 true,
+			MaxIndexCidSize:                789,
+			StoreIdentityCIDs:              true,
+			DataChecksum:                   multicodec.Sha2_256,
+			BlockstoreAllowDuplicatePuts:   true,
+			BlockstoreUseWholeCIDs:         true,
+			BlockstoreIndexWALPath:         "index.wal",
+			BlockstoreExistingIndex:        existingIndex,
+			BlockstoreInsertionIndexMode:   index.InsertionIndexCompact,
+			BlockstoreEmbeddedIndex:        true,
+			BlockstoreMmapIndex:            true,
+			BlockstoreMmapIndexThreshold:   4096,
+			BlockstoreDisableMmap:          true,
+			BlockstoreStrictCodecMatch:     true,
+			BlockstoreMaxDuplicateLookups:  505,
+			BlockstoreDisableIndexChecksum: true,
+			BlockstoreSyncOnFinalize:       true,
+			BlockstoreSyncInterval:         707,
+			BlockstoreExpectedSize:         808,
+			BlockstoreVerifyOnGet:          true,
+			BlockstoreVerifyPutHashes:      true,
+			BlockstoreBloomFPRate:          0.01,
+			BlockstoreBloom:                bloom,
+			BlockstoreGzipBacking:          true,
+			BlockstoreGzipTempFile:         true,
+			BlockstoreGzipTempDir:          "gzip-dir",
+			BlockstoreTraversalRoot:        traversalRoot,
+			BlockstoreTraversalLinkSystem:  ipld.LinkSystem{},
+			MaxTraversalLinks:              math.MaxInt64,
+			MaxAllowedHeaderSize:           101,
+			MaxAllowedSectionSize:          202,
+			MaxAllowedPadding:              303,
+			MaxRoots:                       909,
+			ReadBufferSize:                 404,
+			IndexProgressInterval:          606,
+			IndexContext:                   context.Background(),
+			AcceptedVersions:               []uint64{2, 3},
+			CompareBlockData:               true,
+			ValidateBlockHashes:            true,
+			DetectDataSize:                 true,
+			DryRun:                         true,
+			PadRootsWithIdentityCID:        true,
 		},
 		carv2.ApplyOptions(
 			carv2.UseDataPadding(123),
 			carv2.UseIndexPadding(456),
 			carv2.UseIndexCodec(multicodec.CarIndexSorted),
 			carv2.ZeroLengthSectionAsEOF(true),
+			carv2.WithSkipNullPadding(),
+			carv2.WithLenientVarints(),
 			carv2.MaxIndexCidSize(789),
 			carv2.StoreIdentityCIDs(true),
+			carv2.WithDataChecksum(multicodec.Sha2_256),
 			carv2.MaxAllowedHeaderSize(101),
 			carv2.MaxAllowedSectionSize(202),
+			carv2.MaxAllowedPadding(303),
+			carv2.WithMaxRoots(909),
+			carv2.WithReadBufferSize(404),
+			carv2.WithIndexProgress(606, nil),
+			carv2.WithIndexContext(context.Background()),
+			carv2.WithAcceptedVersions(2, 3),
+			carv2.CompareBlockData(true),
+			carv2.ValidateBlockHashes(true),
+			carv2.DetectDataSize(true),
+			carv2.WithDryRun(),
+			carv2.PadRootsWithIdentityCID(true),
 			blockstore.AllowDuplicatePuts(true),
 			blockstore.UseWholeCIDs(true),
+			blockstore.WithIndexWAL("index.wal"),
+			blockstore.WithExistingIndex(existingIndex),
+			blockstore.WithInsertionIndexMode(index.InsertionIndexCompact),
+			blockstore.WithEmbeddedIndex(),
+			blockstore.UseMmapIndex(true),
+			blockstore.UseMmapIndexAbove(4096),
+			blockstore.WithoutMmap(),
+			blockstore.WithStrictCodecMatch(),
+			blockstore.WithMaxDuplicateLookups(505),
+			blockstore.WithIndexChecksum(false),
+			blockstore.WithSyncOnFinalize(),
+			blockstore.WithSyncInterval(707),
+			blockstore.WithExpectedSize(808),
+			blockstore.WithVerifyOnGet(),
+			blockstore.WithVerifyPutHashes(),
+			blockstore.WithBloomFilter(0.01),
+			blockstore.UseBloomFilter(bloom),
+			blockstore.WithGzipBackingTempFile("gzip-dir"),
+			blockstore.WithTraversalOrder(ipld.LinkSystem{}, traversalRoot),
 		))
 }
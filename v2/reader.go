@@ -1,7 +1,6 @@
 package car
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -13,7 +12,6 @@ import (
 	internalio "github.com/ipld/go-car/v2/internal/io"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
-	"github.com/multiformats/go-varint"
 	"golang.org/x/exp/mmap"
 )
 
@@ -22,9 +20,13 @@ type Reader struct {
 	Header  Header
 	Version uint64
 	r       io.ReaderAt
-	roots   []cid.Cid
 	opts    Options
 	closer  io.Closer
+
+	// roots are the roots of the data payload header, once read by Roots; rootsRead tells whether
+	// they were, since a header may declare no roots.
+	roots     []cid.Cid
+	rootsRead bool
 }
 
 // OpenReader is a wrapper for NewReader which opens the file at path.
@@ -47,9 +49,10 @@ func OpenReader(path string, opts ...Option) (*Reader, error) {
 // Upon instantiation, the reader inspects the payload and provides appropriate read operations
 // for both CARv1 and CARv2.
 //
-// Note that any other version other than 1 or 2 will result in an error. The caller may use
-// Reader.Version to get the actual version r represents. In the case where r represents a CARv1
-// Reader.Header will not be populated and is left as zero-valued.
+// Note that any version other than 1 or 2 will result in an error, unless accepted via
+// WithAcceptedVersions. The caller may use Reader.Version to get the actual version r represents.
+// In the case where r represents a CARv1 Reader.Header will not be populated and is left as
+// zero-valued.
 func NewReader(r io.ReaderAt, opts ...Option) (*Reader, error) {
 	cr := &Reader{
 		r: r,
@@ -64,12 +67,12 @@ func NewReader(r io.ReaderAt, opts ...Option) (*Reader, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	if cr.Version != 1 && cr.Version != 2 {
-		return nil, fmt.Errorf("invalid car version: %d", cr.Version)
+	if err := cr.opts.checkVersion(cr.Version); err != nil {
+		return nil, err
 	}
 
-	if cr.Version == 2 {
+	// Any accepted version other than 1 is read as CARv2.
+	if cr.Version != 1 {
 		if err := cr.readV2Header(); err != nil {
 			return nil, err
 		}
@@ -79,23 +82,98 @@ func NewReader(r io.ReaderAt, opts ...Option) (*Reader, error) {
 }
 
 // Roots returns the root CIDs.
-// The root CIDs are extracted lazily from the data payload header.
+// The root CIDs are extracted lazily from the data payload header, which is read once and starts
+// at the data offset of the CARv2 header, if any; the roots are cached for subsequent calls.
+//
+// An ErrMalformedDataHeader is returned if the data payload header is truncated or cannot be
+// decoded, and an ErrTooManyRoots if it declares more roots than allowed via WithMaxRoots.
 func (r *Reader) Roots() ([]cid.Cid, error) {
-	if r.roots != nil {
+	if r.rootsRead {
 		return r.roots, nil
 	}
 	dr, err := r.DataReader()
 	if err != nil {
 		return nil, err
 	}
+	if r.opts.MaxRoots > 0 {
+		// Check the number of roots before decoding them all at once.
+		if _, err := r.newRootsIterator(); err != nil {
+			return nil, malformedDataHeader(err)
+		}
+	}
 	header, err := carv1.ReadHeader(dr, r.opts.MaxAllowedHeaderSize)
 	if err != nil {
-		return nil, err
+		return nil, malformedDataHeader(err)
+	}
+	if header.Version != 1 {
+		return nil, &ErrMalformedDataHeader{Err: fmt.Errorf("expected version 1, got %d", header.Version)}
 	}
-	r.roots = header.Roots
+	r.roots, r.rootsRead = header.Roots, true
 	return r.roots, nil
 }
 
+// malformedDataHeader wraps the given error, encountered while reading the data payload header, in
+// an ErrMalformedDataHeader, unless it signals that a limit set via options is exceeded.
+func malformedDataHeader(err error) error {
+	switch err.(type) {
+	case *ErrTooManyRoots:
+		return err
+	}
+	switch err {
+	case util.ErrHeaderTooLarge:
+		return err
+	case io.EOF:
+		err = io.ErrUnexpectedEOF
+	}
+	return &ErrMalformedDataHeader{Err: err}
+}
+
+// RootsIter returns a function that iterates over the root CIDs, decoding them one at a time from
+// the data payload header rather than all at once as Roots does. This is useful for CARs with very
+// large numbers of roots, particularly when only the first few roots are of interest.
+//
+// Each call to the returned function returns the next root along with true, or false once all
+// roots have been returned. Any error encountered, e.g. while reading the header or because the
+// number of roots exceeds the maximum set via WithMaxRoots, is returned by the first call that
+// encounters it, and by every call after that.
+func (r *Reader) RootsIter() func() (cid.Cid, bool, error) {
+	if r.rootsRead {
+		roots := r.roots
+		return func() (cid.Cid, bool, error) {
+			if len(roots) == 0 {
+				return cid.Undef, false, nil
+			}
+			c := roots[0]
+			roots = roots[1:]
+			return c, true, nil
+		}
+	}
+	it, err := r.newRootsIterator()
+	if err != nil {
+		return func() (cid.Cid, bool, error) {
+			return cid.Undef, false, err
+		}
+	}
+	return it.Next
+}
+
+// newRootsIterator instantiates an iterator over the roots in the data payload header, checking
+// the number of roots against MaxRoots.
+func (r *Reader) newRootsIterator() (*carv1.RootsIterator, error) {
+	dr, err := r.DataReader()
+	if err != nil {
+		return nil, err
+	}
+	it, err := carv1.NewRootsIterator(dr, r.opts.MaxAllowedHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	if r.opts.MaxRoots > 0 && it.Count() > r.opts.MaxRoots {
+		return nil, &ErrTooManyRoots{MaxRoots: r.opts.MaxRoots, Count: it.Count()}
+	}
+	return it, nil
+}
+
 func (r *Reader) readV2Header() (err error) {
 	headerSection := io.NewSectionReader(r.r, PragmaSize, HeaderSize)
 	_, err = r.Header.ReadFrom(headerSection)
@@ -113,7 +191,7 @@ type SectionReader interface {
 
 // DataReader provides a reader containing the data payload in CARv1 format.
 func (r *Reader) DataReader() (SectionReader, error) {
-	if r.Version == 2 {
+	if r.Version != 1 {
 		return io.NewSectionReader(r.r, int64(r.Header.DataOffset), int64(r.Header.DataSize)), nil
 	}
 	return internalio.NewOffsetReadSeeker(r.r, 0)
@@ -145,6 +223,31 @@ type Stats struct {
 	MaxBlockLength uint64
 	MinBlockLength uint64
 	IndexCodec     multicodec.Code
+	// DataLength is the length of the CARv1 data payload up to the end of its last section, i.e.
+	// excluding any trailing null padding or zero-length section read as EOF.
+	DataLength uint64
+}
+
+// ValidateBlockHashes sets whether Inspect hashes the data of every block and compares it to the
+// CID of the block.
+// Disabled by default.
+//
+// See: Reader.Inspect.
+func ValidateBlockHashes(enable bool) Option {
+	return func(o *Options) {
+		o.ValidateBlockHashes = enable
+	}
+}
+
+// Inspect reads the CARv1 or CARv2 payload of r with the given options and inspects it as
+// Reader.Inspect does, in a single sequential pass over its data payload. Block hashes are only
+// validated if ValidateBlockHashes is enabled.
+func Inspect(r io.ReaderAt, opts ...Option) (Stats, error) {
+	reader, err := NewReader(r, opts...)
+	if err != nil {
+		return Stats{}, err
+	}
+	return reader.Inspect(reader.opts.ValidateBlockHashes)
 }
 
 // Inspect does a quick scan of a CAR, performing basic validation of the format
@@ -159,6 +262,11 @@ type Stats struct {
 // Performing a full block hash validation is similar to using a BlockReader and
 // calling Next over all blocks.
 //
+// An ErrMalformedDataHeader is returned if the data payload header cannot be
+// decoded, and an ErrCorruptSection with the offset of the first section that
+// is malformed, e.g. truncated, shorter than its CID, or whose data does not
+// match its CID when validating block hashes.
+//
 // Inspect will perform a basic check of a CARv2 index, where present, but this
 // does not guarantee that the index is correct. Attempting to read index data
 // from untrusted sources is not recommended. If required, further validation of
@@ -215,45 +323,61 @@ func (r *Reader) Inspect(validateBlockHash bool) (Stats, error) {
 	// read roots, not using Roots(), because we need the offset setup in the data trader
 	header, err := carv1.ReadHeader(dr, r.opts.MaxAllowedHeaderSize)
 	if err != nil {
-		return Stats{}, err
+		return Stats{}, malformedDataHeader(err)
+	}
+	if header.Version != 1 {
+		return Stats{}, &ErrMalformedDataHeader{Err: fmt.Errorf("expected version 1, got %d", header.Version)}
 	}
 	stats.Roots = header.Roots
 	var rootsPresentCount int
 	rootsPresent := make([]bool, len(stats.Roots))
 
+	// The data reader is not buffered, so its position is the offset of the next section to read.
+	sectionOffset, err := dr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.DataLength = uint64(sectionOffset)
+	corrupt := func(reason string, args ...interface{}) error {
+		return &ErrCorruptSection{Offset: uint64(sectionOffset), Reason: fmt.Sprintf(reason, args...)}
+	}
+
 	// read block sections
 	for {
-		sectionLength, err := varint.ReadUvarint(bdr)
+		if sectionOffset, err = dr.Seek(0, io.SeekCurrent); err != nil {
+			return Stats{}, err
+		}
+		sectionLength, _, err := util.ReadUvarint(bdr, r.opts.LenientVarints)
 		if err != nil {
 			if err == io.EOF {
-				// if the length of bytes read is non-zero when the error is EOF then signal an unclean EOF.
-				if sectionLength > 0 {
-					return Stats{}, io.ErrUnexpectedEOF
-				}
-				// otherwise, this is a normal ending
+				// A partially read length is signalled as io.ErrUnexpectedEOF, so this is a normal ending.
 				break
 			}
-			return Stats{}, err
+			return Stats{}, corrupt("cannot read section length: %v", err)
+		}
+		if sectionLength == 0 && r.opts.SkipNullPadding {
+			// null padding between sections for this read mode
+			continue
 		}
 		if sectionLength == 0 && r.opts.ZeroLengthSectionAsEOF {
 			// normal ending for this read mode
 			break
 		}
 		if sectionLength > r.opts.MaxAllowedSectionSize {
-			return Stats{}, util.ErrSectionTooLarge
+			return Stats{}, corrupt("%v", util.ErrSectionTooLarge)
 		}
 
 		// decode just the CID bytes
 		cidLen, c, err := cid.CidFromReader(dr)
 		if err != nil {
-			return Stats{}, err
+			return Stats{}, corrupt("cannot decode CID: %v", err)
 		}
 
 		if sectionLength < uint64(cidLen) {
 			// this case is handled different in the normal ReadNode() path since it
 			// slurps in the whole section bytes and decodes CID from there - so an
 			// error should come from a failing io.ReadFull
-			return Stats{}, errors.New("section length shorter than CID length")
+			return Stats{}, corrupt("section length shorter than CID length")
 		}
 
 		// is this a root block? (also account for duplicate root CIDs)
@@ -275,6 +399,11 @@ func (r *Reader) Inspect(validateBlockHash bool) (Stats, error) {
 		stats.MhTypeCounts[mhtype] = count + 1
 
 		blockLength := sectionLength - uint64(cidLen)
+		blockOffset, err := dr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return Stats{}, err
+		}
+		blockEnd := uint64(blockOffset) + blockLength
 
 		if validateBlockHash {
 			// Use multihash.SumStream to avoid having to copy the entire block content into memory.
@@ -288,7 +417,14 @@ func (r *Reader) Inspect(validateBlockHash bool) (Stats, error) {
 			}
 			mh, err := multihash.SumStream(blockReader, cp.MhType, mhl)
 			if err != nil {
+				return Stats{}, corrupt("cannot hash block: %v", err)
+			}
+			// Check the block was read in full before comparing its hash, such that a truncated
+			// block is reported as such rather than as a mismatch.
+			if pos, err := dr.Seek(0, io.SeekCurrent); err != nil {
 				return Stats{}, err
+			} else if uint64(pos) < blockEnd {
+				return Stats{}, corrupt("cannot read block: %v", io.ErrUnexpectedEOF)
 			}
 			var gotCid cid.Cid
 			switch cp.Version {
@@ -297,17 +433,30 @@ func (r *Reader) Inspect(validateBlockHash bool) (Stats, error) {
 			case 1:
 				gotCid = cid.NewCidV1(cp.Codec, mh)
 			default:
-				return Stats{}, fmt.Errorf("invalid cid version: %d", cp.Version)
+				return Stats{}, corrupt("invalid cid version: %d", cp.Version)
 			}
 			if !gotCid.Equals(c) {
-				return Stats{}, fmt.Errorf("mismatch in content integrity, expected: %s, got: %s", c, gotCid)
+				return Stats{}, corrupt("mismatch in content integrity, expected: %s, got: %s", c, gotCid)
 			}
 		} else {
 			// otherwise, skip over it
 			if _, err := dr.Seek(int64(blockLength), io.SeekCurrent); err != nil {
 				return Stats{}, err
 			}
+			// Seeking past the end of the payload does not fail, so check the last byte of the
+			// block is present. Only the last section can be truncated this way, since any section
+			// following a truncated one would fail to be read.
+			if blockLength > 0 {
+				var last [1]byte
+				if _, err := dr.ReadAt(last[:], int64(blockEnd)-1); err != nil {
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					return Stats{}, corrupt("cannot read block: %v", err)
+				}
+			}
 		}
+		stats.DataLength = blockEnd
 
 		stats.BlockCount++
 		totalCidLength += uint64(cidLen)
@@ -3,16 +3,21 @@ package car_test
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
 	"testing"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
 	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/require"
 )
 
@@ -88,7 +93,7 @@ func TestReadVersion(t *testing.T) {
 
 func TestReaderFailsOnUnknownVersion(t *testing.T) {
 	_, err := carv2.OpenReader("testdata/sample-rootless-v42.car")
-	require.EqualError(t, err, "invalid car version: 42")
+	require.EqualError(t, err, "unsupported car version: 42; accepted versions are [1 2]")
 }
 
 func TestReaderFailsOnCorruptPragma(t *testing.T) {
@@ -161,6 +166,10 @@ func TestReader_WithCarV2Consistency(t *testing.T) {
 			name: "CarV2ProducedByBlockstore",
 			path: "testdata/sample-rw-bs-v2.car",
 		},
+		{
+			name: "CarV2WithIndexBeforeData",
+			path: "testdata/sample-v2-index-before-data.car",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -188,6 +197,46 @@ func TestReader_WithCarV2Consistency(t *testing.T) {
 	}
 }
 
+func TestReader_WithIndexBeforeData(t *testing.T) {
+	// The fixture holds the same data payload and index as sample-wrapped-v2.car, except that the
+	// index is placed right after the CARv2 header, before the data payload.
+	want, err := carv2.OpenReader("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, want.Close()) })
+	subject, err := carv2.OpenReader("testdata/sample-v2-index-before-data.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, subject.Close()) })
+	require.Equal(t, uint64(carv2.PragmaSize+carv2.HeaderSize), subject.Header.IndexOffset)
+	require.Less(t, subject.Header.IndexOffset, subject.Header.DataOffset)
+	require.Equal(t, want.Header.DataSize, subject.Header.DataSize)
+
+	readAll := func(r *carv2.Reader) ([]byte, []byte) {
+		dr, err := r.DataReader()
+		require.NoError(t, err)
+		data, err := io.ReadAll(dr)
+		require.NoError(t, err)
+		ir, err := r.IndexReader()
+		require.NoError(t, err)
+		idx, err := index.ReadFrom(ir)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		_, err = index.WriteTo(idx, &buf)
+		require.NoError(t, err)
+		return data, buf.Bytes()
+	}
+	wantData, wantIndex := readAll(want)
+	gotData, gotIndex := readAll(subject)
+	require.Equal(t, wantData, gotData)
+	require.Equal(t, wantIndex, gotIndex)
+
+	wantStats, err := want.Inspect(true)
+	require.NoError(t, err)
+	gotStats, err := subject.Inspect(true)
+	require.NoError(t, err)
+	require.Equal(t, wantStats.IndexCodec, gotStats.IndexCodec)
+	require.Equal(t, wantStats.BlockCount, gotStats.BlockCount)
+}
+
 func TestOpenReader_DoesNotPanicForReadersCreatedBeforeClosure(t *testing.T) {
 	subject, err := carv2.OpenReader("testdata/sample-wrapped-v2.car")
 	require.NoError(t, err)
@@ -320,6 +369,7 @@ func TestInspect(t *testing.T) {
 					multicodec.Identity:   6,
 					multicodec.Blake2b256: 1043,
 				},
+				DataLength: 479907,
 			},
 		},
 		{
@@ -346,6 +396,7 @@ func TestInspect(t *testing.T) {
 					multicodec.Identity:   6,
 					multicodec.Blake2b256: 1043,
 				},
+				DataLength: 479907,
 			},
 		},
 		{
@@ -375,6 +426,7 @@ func TestInspect(t *testing.T) {
 				MaxBlockLength: 9,
 				MinBlockLength: 4,
 				IndexCodec:     multicodec.CarMultihashIndexSorted,
+				DataLength:     273,
 			},
 		},
 		// same as CarV1 but with a zero-byte EOF to test options
@@ -402,6 +454,7 @@ func TestInspect(t *testing.T) {
 					multicodec.Identity:   6,
 					multicodec.Blake2b256: 1043,
 				},
+				DataLength: 479907,
 			},
 		},
 		{
@@ -422,6 +475,7 @@ func TestInspect(t *testing.T) {
 				AvgCidLength: 25,
 				MaxCidLength: 25,
 				MinCidLength: 25,
+				DataLength:   74,
 			},
 		},
 	}
@@ -458,7 +512,7 @@ func TestInspectError(t *testing.T) {
 		{
 			name:                 "BadCidV0",
 			carHex:               "3aa265726f6f747381d8305825000130302030303030303030303030303030303030303030303030303030303030303030306776657273696f6e010130",
-			expectedInspectError: "expected 1 as the cid version number, got: 48",
+			expectedInspectError: "corrupt section at offset 59: cannot decode CID: expected 1 as the cid version number, got: 48",
 		},
 		{
 			name:              "BadHeaderLength",
@@ -468,18 +522,18 @@ func TestInspectError(t *testing.T) {
 		{
 			name:                 "BadSectionLength",
 			carHex:               "11a265726f6f7473806776657273696f6e01e0e0e0e0a7060155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681ca00000000000000000000",
-			expectedInspectError: "invalid section data, length of read beyond allowable maximum",
+			expectedInspectError: "corrupt section at offset 18: invalid section data, length of read beyond allowable maximum",
 		},
 		{
 			name:                 "BadSectionLength2",
 			carHex:               "3aa265726f6f747381d8305825000130302030303030303030303030303030303030303030303030303030303030303030306776657273696f6e01200130302030303030303030303030303030303030303030303030303030303030303030303030303030303030",
-			expectedInspectError: "section length shorter than CID length",
+			expectedInspectError: "corrupt section at offset 59: section length shorter than CID length",
 			validateBlockHash:    true,
 		},
 		{
 			name:                 "BadSectionLength3",
 			carHex:               "11a265726f6f7473f66776657273696f6e0180",
-			expectedInspectError: "unexpected EOF",
+			expectedInspectError: "corrupt section at offset 18: cannot read section length: unexpected EOF",
 		},
 		{
 			name: "BadBlockHash(SanityCheck)", // this should pass because we don't ask the CID be validated even though it doesn't match
@@ -491,14 +545,14 @@ func TestInspectError(t *testing.T) {
 			//       header                             cid                                                                          data
 			carHex:               "11a265726f6f7473806776657273696f6e 012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681ca ffffffffffffffffffff",
 			validateBlockHash:    true,
-			expectedInspectError: "mismatch in content integrity, expected: bafkreiab2rek7wjiazkfrt3hbnqpljmu24226alszdlh6ivic2abgjubzi, got: bafkreiaaqoxrddiyuy6gxnks6ioqytxhq5a7tchm2mm5htigznwiljukmm",
+			expectedInspectError: "corrupt section at offset 18: mismatch in content integrity, expected: bafkreiab2rek7wjiazkfrt3hbnqpljmu24226alszdlh6ivic2abgjubzi, got: bafkreiaaqoxrddiyuy6gxnks6ioqytxhq5a7tchm2mm5htigznwiljukmm",
 		},
 		{
 			name: "IdentityCID", // a case where this _could_ be a valid CAR if we allowed identity CIDs and not matching block contents to exist, there's no block bytes in this
 			//                  47 {version:1,roots:[identity cid]}                                                               25 identity cid (dag-json {"identity":"block"})
 			carHex:               "2f a265726f6f747381d82a581a0001a90200147b226964656e74697479223a22626c6f636b227d6776657273696f6e01 19 01a90200147b226964656e74697479223a22626c6f636b227d",
 			validateBlockHash:    true,
-			expectedInspectError: "mismatch in content integrity, expected: baguqeaaupmrgszdfnz2gs5dzei5ceytmn5rwwit5, got: baguqeaaa",
+			expectedInspectError: "corrupt section at offset 48: mismatch in content integrity, expected: baguqeaaupmrgszdfnz2gs5dzei5ceytmn5rwwit5, got: baguqeaaa",
 		},
 		// the bad index tests are manually constructed from this single-block CARv2 by adjusting the Uint32 and Uint64 values in the index:
 		// pragma                 carv2 header                                                                     carv1                                                                                                                              icodec count  codec            count (swi) width dataLen          mh                                                               offset
@@ -529,6 +583,37 @@ func TestInspectError(t *testing.T) {
 	}
 }
 
+func TestInspect_ReaderAt(t *testing.T) {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	reader, err := carv2.NewReader(bytes.NewReader(v1))
+	require.NoError(t, err)
+	want, err := reader.Inspect(true)
+	require.NoError(t, err)
+	got, err := carv2.Inspect(bytes.NewReader(v1), carv2.ValidateBlockHashes(true))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.Equal(t, uint64(len(v1)), got.DataLength)
+
+	// Assert block hashes are only validated if enabled.
+	badBlockHash, err := hex.DecodeString("11a265726f6f7473806776657273696f6e012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681caffffffffffffffffffff")
+	require.NoError(t, err)
+	_, err = carv2.Inspect(bytes.NewReader(badBlockHash))
+	require.NoError(t, err)
+	_, err = carv2.Inspect(bytes.NewReader(badBlockHash), carv2.ValidateBlockHashes(true))
+	var corrupt *carv2.ErrCorruptSection
+	require.True(t, errors.As(err, &corrupt), "expected ErrCorruptSection but got: %v", err)
+	require.Equal(t, uint64(18), corrupt.Offset)
+
+	// Assert a truncated last block is detected whether or not block hashes are validated, at the
+	// offset of its section.
+	truncated := v1[:len(v1)-1]
+	for _, validate := range []bool{false, true} {
+		_, err = carv2.Inspect(bytes.NewReader(truncated), carv2.ValidateBlockHashes(validate))
+		require.EqualError(t, err, "corrupt section at offset 479518: cannot read block: unexpected EOF")
+	}
+}
+
 func TestIndex_ReadFromCorruptIndex(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -539,7 +624,7 @@ func TestIndex_ReadFromCorruptIndex(t *testing.T) {
 			name: "BadIndexCountOverflow",
 			//                     pragma                 carv2 header                                                                     carv1                                                                                                                              icodec count  codec            count (swi) width dataLen          mh                                                               offset
 			givenCarHex: "0aa16776657273696f6e02 00000000000000000000000000000000330000000000000041000000000000007400000000000000 11a265726f6f7473806776657273696f6e012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681ca00000000000000000000 8108 ffffffff 1200000000000000 01000000 28000000 2800000000000000 01d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681ca 1200000000000000",
-			wantErr:     "index too big; MultihashIndexSorted count is overflowing int32",
+			wantErr:     "malformed index; MultihashIndexSorted count is overflowing int32",
 		},
 		{
 			name: "BadIndexCountTooMany",
@@ -551,7 +636,7 @@ func TestIndex_ReadFromCorruptIndex(t *testing.T) {
 			name: "BadIndexMultiWidthOverflow",
 			//                     pragma                 carv2 header                                                                     carv1                                                                                                                              icodec count  codec            count (swi) width dataLen          mh                                                               offset
 			givenCarHex: "0aa16776657273696f6e02 00000000000000000000000000000000330000000000000041000000000000007400000000000000 11a265726f6f7473806776657273696f6e012e0155122001d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681ca00000000000000000000 8108 01000000 1200000000000000 ffffffff 28000000 2800000000000000 01d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681ca 1200000000000000",
-			wantErr:     "index too big; multiWidthIndex count is overflowing int32",
+			wantErr:     "malformed index; multiWidthIndex count is overflowing int32",
 		},
 		{
 			name: "BadIndexMultiWidthTooMany",
@@ -590,3 +675,294 @@ func mustCidDecode(s string) cid.Cid {
 	}
 	return c
 }
+
+func TestReaderWithAcceptedVersions(t *testing.T) {
+	v2Payload, err := os.ReadFile("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	// Craft a version 3 payload by changing the version in the pragma of a CARv2 payload; the
+	// version is encoded as the last byte of the pragma.
+	v3Payload := append([]byte{}, v2Payload...)
+	require.Equal(t, byte(2), v3Payload[carv2.PragmaSize-1])
+	v3Payload[carv2.PragmaSize-1] = 3
+
+	t.Run("RejectedByDefault", func(t *testing.T) {
+		wantErr := &carv2.ErrUnsupportedVersion{Version: 3, Accepted: []uint64{1, 2}}
+		_, err := carv2.NewReader(bytes.NewReader(v3Payload))
+		require.Equal(t, wantErr, err)
+		_, err = carv2.NewBlockReader(bytes.NewReader(v3Payload))
+		require.Equal(t, wantErr, err)
+		_, err = carv2.GenerateIndex(bytes.NewReader(v3Payload))
+		require.Equal(t, wantErr, err)
+	})
+
+	t.Run("AcceptedIsReadAsCarV2", func(t *testing.T) {
+		opt := carv2.WithAcceptedVersions(1, 2, 3)
+		want, err := carv2.NewReader(bytes.NewReader(v2Payload))
+		require.NoError(t, err)
+		wantRoots, err := want.Roots()
+		require.NoError(t, err)
+
+		subject, err := carv2.NewReader(bytes.NewReader(v3Payload), opt)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), subject.Version)
+		require.Equal(t, want.Header, subject.Header)
+		gotRoots, err := subject.Roots()
+		require.NoError(t, err)
+		require.Equal(t, wantRoots, gotRoots)
+
+		wantBlocks, err := carv2.NewBlockReader(bytes.NewReader(v2Payload))
+		require.NoError(t, err)
+		gotBlocks, err := carv2.NewBlockReader(bytes.NewReader(v3Payload), opt)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), gotBlocks.Version)
+		for {
+			wantBlock, wantErr := wantBlocks.Next()
+			gotBlock, gotErr := gotBlocks.Next()
+			require.Equal(t, wantErr, gotErr)
+			require.Equal(t, wantBlock, gotBlock)
+			if wantErr == io.EOF {
+				break
+			}
+		}
+
+		wantIdx, err := carv2.GenerateIndex(bytes.NewReader(v2Payload))
+		require.NoError(t, err)
+		gotIdx, err := carv2.GenerateIndex(bytes.NewReader(v3Payload), opt)
+		require.NoError(t, err)
+		require.Equal(t, wantIdx, gotIdx)
+	})
+
+	t.Run("OptingOutIsRejected", func(t *testing.T) {
+		_, err := carv2.NewReader(bytes.NewReader(v2Payload), carv2.WithAcceptedVersions(1))
+		require.Equal(t, &carv2.ErrUnsupportedVersion{Version: 2, Accepted: []uint64{1}}, err)
+		_, err = carv2.NewBlockReader(bytes.NewReader(v2Payload), carv2.WithAcceptedVersions(1))
+		require.Equal(t, &carv2.ErrUnsupportedVersion{Version: 2, Accepted: []uint64{1}}, err)
+	})
+}
+
+func TestReader_RootsIter(t *testing.T) {
+	for _, path := range []string{"testdata/sample-v1.car", "testdata/sample-wrapped-v2.car"} {
+		t.Run(path, func(t *testing.T) {
+			subject, err := carv2.OpenReader(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, subject.Close()) })
+			// Iterate before calling Roots, since Roots caches the decoded roots.
+			got := collectRoots(t, subject.RootsIter())
+			want, err := subject.Roots()
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+			require.Equal(t, want, collectRoots(t, subject.RootsIter()))
+		})
+	}
+
+	t.Run("ManyRoots", func(t *testing.T) {
+		roots := make([]cid.Cid, 100_000)
+		for i := range roots {
+			roots[i] = blocks.NewBlock([]byte(fmt.Sprintf("root %d", i))).Cid()
+		}
+		var payload bytes.Buffer
+		require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: 1}, &payload))
+
+		subject, err := carv2.NewReader(bytes.NewReader(payload.Bytes()))
+		require.NoError(t, err)
+		next := subject.RootsIter()
+		first, ok, err := next()
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, roots[0], first)
+		require.Equal(t, roots[1:], collectRoots(t, next))
+		// Assert the iterator is exhausted.
+		_, ok, err = next()
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		subject, err = carv2.NewReader(bytes.NewReader(payload.Bytes()), carv2.WithMaxRoots(1000))
+		require.NoError(t, err)
+		wantErr := &carv2.ErrTooManyRoots{MaxRoots: 1000, Count: uint64(len(roots))}
+		_, err = subject.Roots()
+		require.Equal(t, wantErr, err)
+		_, _, err = subject.RootsIter()()
+		require.Equal(t, wantErr, err)
+
+		subject, err = carv2.NewReader(bytes.NewReader(payload.Bytes()), carv2.WithMaxRoots(uint64(len(roots))))
+		require.NoError(t, err)
+		got, err := subject.Roots()
+		require.NoError(t, err)
+		require.Equal(t, roots, got)
+	})
+
+	t.Run("RootsAfterOtherFields", func(t *testing.T) {
+		root := blocks.NewBlock([]byte("fish")).Cid()
+		// A header with version preceding roots, i.e. not in canonical DAG-CBOR key order.
+		header := append([]byte{0xa2, 0x67}, "version"...)
+		header = append(header, 0x01, 0x65)
+		header = append(header, "roots"...)
+		header = append(header, 0x81, 0xd8, 0x2a, 0x58, byte(len(root.Bytes())+1), 0x00)
+		header = append(header, root.Bytes()...)
+		payload := append(varint.ToUvarint(uint64(len(header))), header...)
+
+		subject, err := carv2.NewReader(bytes.NewReader(payload))
+		require.NoError(t, err)
+		require.Equal(t, []cid.Cid{root}, collectRoots(t, subject.RootsIter()))
+	})
+
+	t.Run("MaliciousRootsCountIsError", func(t *testing.T) {
+		// A header declaring 2^40 roots, followed by none.
+		header := append([]byte{0xa1, 0x65}, "roots"...)
+		header = append(header, 0x9b, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00)
+		v1Payload := append(varint.ToUvarint(uint64(len(header))), header...)
+		// Wrap the payload in a CARv2, since reading the version of a CARv1 decodes its header.
+		var payload bytes.Buffer
+		_, err := payload.Write(carv2.Pragma)
+		require.NoError(t, err)
+		_, err = carv2.NewHeader(uint64(len(v1Payload))).WriteTo(&payload)
+		require.NoError(t, err)
+		_, err = payload.Write(v1Payload)
+		require.NoError(t, err)
+
+		subject, err := carv2.NewReader(bytes.NewReader(payload.Bytes()))
+		require.NoError(t, err)
+		_, ok, err := subject.RootsIter()()
+		require.Error(t, err)
+		require.False(t, ok)
+		_, err = subject.Roots()
+		require.Error(t, err)
+	})
+}
+
+func TestReader_RootsWithMalformedDataHeader(t *testing.T) {
+	var wrongVersion bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Version: 2}, &wrongVersion))
+	tests := []struct {
+		name      string
+		v1Payload []byte
+		wantCause error
+	}{
+		{"Truncated", append(varint.ToUvarint(50), make([]byte, 10)...), io.ErrUnexpectedEOF},
+		{"TruncatedVarint", []byte{0x80}, io.ErrUnexpectedEOF},
+		{"NotCbor", append(varint.ToUvarint(3), 0xff, 0xff, 0xff), nil},
+		{"WrongVersion", wrongVersion.Bytes(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Wrap the payload in a CARv2, since reading the version of a CARv1 decodes its header.
+			var payload bytes.Buffer
+			payload.Write(carv2.Pragma)
+			_, err := carv2.NewHeader(uint64(len(tt.v1Payload))).WriteTo(&payload)
+			require.NoError(t, err)
+			payload.Write(tt.v1Payload)
+
+			subject, err := carv2.NewReader(bytes.NewReader(payload.Bytes()))
+			require.NoError(t, err)
+			_, err = subject.Roots()
+			var malformed *carv2.ErrMalformedDataHeader
+			require.True(t, errors.As(err, &malformed), "expected malformed data header, got %v", err)
+			if tt.wantCause != nil {
+				require.True(t, errors.Is(err, tt.wantCause))
+			}
+		})
+	}
+}
+
+func TestReader_RootsAreCached(t *testing.T) {
+	var v1Payload bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Version: 1}, &v1Payload))
+	var payload bytes.Buffer
+	payload.Write(carv2.Pragma)
+	_, err := carv2.NewHeader(uint64(v1Payload.Len())).WriteTo(&payload)
+	require.NoError(t, err)
+	payload.Write(v1Payload.Bytes())
+
+	// Assert the roots are read once, even if the header declares none, by corrupting the data
+	// payload header once read.
+	data := payload.Bytes()
+	subject, err := carv2.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	roots, err := subject.Roots()
+	require.NoError(t, err)
+	require.Empty(t, roots)
+	data[carv2.PragmaSize+carv2.HeaderSize] = 0xff
+	roots, err = subject.Roots()
+	require.NoError(t, err)
+	require.Empty(t, roots)
+}
+
+func TestReaderWithCidV0(t *testing.T) {
+	child := merkledag.NodeWithData([]byte("fish"))
+	parent := merkledag.NodeWithData([]byte("lobster"))
+	require.NoError(t, parent.AddNodeLink("child", child))
+	require.Equal(t, uint64(0), parent.Cid().Version())
+	require.Equal(t, uint64(0), child.Cid().Version())
+	roots := []cid.Cid{parent.Cid()}
+	v1 := writeV1(t, roots, parent, child)
+	var v2 bytes.Buffer
+	require.NoError(t, carv2.WrapV1(bytes.NewReader(v1), &v2))
+
+	tests := []struct {
+		name string
+		car  []byte
+	}{
+		{"CARv1", v1},
+		{"CARv2", v2.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := carv2.NewReader(bytes.NewReader(tt.car))
+			require.NoError(t, err)
+			require.Equal(t, roots, collectRoots(t, subject.RootsIter()))
+			gotRoots, err := subject.Roots()
+			require.NoError(t, err)
+			require.Equal(t, roots, gotRoots)
+			stats, err := subject.Inspect(true)
+			require.NoError(t, err)
+			require.True(t, stats.RootsPresent)
+			require.Equal(t, uint64(2), stats.BlockCount)
+			require.Equal(t, map[multicodec.Code]uint64{multicodec.DagPb: 2}, stats.CodecCounts)
+			require.Equal(t, map[multicodec.Code]uint64{multicodec.Sha2_256: 2}, stats.MhTypeCounts)
+
+			br, err := carv2.NewBlockReader(bytes.NewReader(tt.car))
+			require.NoError(t, err)
+			require.Equal(t, roots, br.Roots)
+			for _, want := range []blocks.Block{parent, child} {
+				got, err := br.Next()
+				require.NoError(t, err)
+				require.Equal(t, want.Cid(), got.Cid())
+				require.Equal(t, uint64(0), got.Cid().Version())
+				require.Equal(t, want.RawData(), got.RawData())
+			}
+			_, err = br.Next()
+			require.Equal(t, io.EOF, err)
+
+			// Assert CIDv0 blocks are indexed by multihash, or by their exact bytes if whole CIDs
+			// are indexed.
+			sameHash := cid.NewCidV1(cid.DagProtobuf, child.Cid().Hash())
+			idx, err := carv2.GenerateIndex(bytes.NewReader(tt.car))
+			require.NoError(t, err)
+			_, err = index.GetFirst(idx, child.Cid())
+			require.NoError(t, err)
+			_, err = index.GetFirst(idx, sameHash)
+			require.NoError(t, err)
+			idx, err = carv2.GenerateIndex(bytes.NewReader(tt.car), carv2.UseIndexCodec(index.CarCidIndexSorted))
+			require.NoError(t, err)
+			_, err = index.GetFirst(idx, child.Cid())
+			require.NoError(t, err)
+			_, err = index.GetFirst(idx, sameHash)
+			require.Equal(t, index.ErrNotFound, err)
+
+			require.NoError(t, carv2.VerifyStream(struct{ io.Reader }{bytes.NewReader(tt.car)}, parent.Cid()))
+		})
+	}
+}
+
+// collectRoots calls next until it returns false, and returns the roots it returned.
+func collectRoots(t *testing.T, next func() (cid.Cid, bool, error)) []cid.Cid {
+	var roots []cid.Cid
+	for {
+		c, ok, err := next()
+		require.NoError(t, err)
+		if !ok {
+			return roots
+		}
+		roots = append(roots, c)
+	}
+}
@@ -0,0 +1,213 @@
+package car
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-varint"
+)
+
+// Repair rebuilds a CARv2 from src, whose header or index may be corrupt but whose data payload is
+// at least partially intact, and writes the repaired CARv2 to dst. The number of blocks recovered,
+// i.e. the number of sections of the data payload written to dst, is returned.
+//
+// The data payload is located using the CARv2 header of src if it is sane, i.e. if it can be read
+// and a CARv1 header is found at its data offset. Otherwise, the data payload is assumed to start
+// right after the header, skipping over any null padding of up to MaxAllowedPadding bytes. Either
+// way, the pragma of src must be intact, as must be the CARv1 header of its data payload.
+//
+// Sections are then scanned from the start of the data payload, up to the data size specified by
+// the header if sane, or up to the end of src otherwise. Scanning stops at the first section that
+// is truncated or malformed, or whose block data does not match its CID, and that section is
+// dropped along with everything after it, e.g. the index of src when its header is corrupt. The
+// sections scanned are copied to dst unmodified, preceded by a fresh pragma and header and followed
+// by an index generated from them, such that dst does not use any padding.
+//
+// Options are applied as they are by GenerateIndex, e.g. UseIndexCodec, StoreIdentityCIDs,
+// ZeroLengthSectionAsEOF and WithSkipNullPadding, and WithoutIndex omits the index altogether.
+// Note that blocks whose hash function is not supported cannot be verified, and are therefore
+// treated as corrupt.
+func Repair(src io.ReaderAt, dst io.Writer, opts ...Option) (uint64, error) {
+	o := ApplyOptions(opts...)
+	if o.ReadBufferSize > 0 {
+		src = internalio.NewPrefetchReaderAt(src, o.ReadBufferSize)
+	}
+
+	pragma := make([]byte, PragmaSize)
+	if _, err := src.ReadAt(pragma, 0); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if !bytes.Equal(pragma, Pragma) {
+		return 0, errors.New("cannot repair CAR; CARv2 pragma is missing or corrupt")
+	}
+
+	dataOffset, dataSize, err := locatePayload(src, o)
+	if err != nil {
+		return 0, err
+	}
+	recovered, size, err := scanRecoverableSections(src, dataOffset, dataSize, o)
+	if err != nil {
+		return 0, err
+	}
+
+	payload := io.NewSectionReader(src, dataOffset, size)
+	v2Header := NewHeader(uint64(size))
+	v2Header.Characteristics.SetFullyIndexed(o.StoreIdentityCIDs)
+	var idx index.Index
+	if o.IndexCodec == index.CarIndexNone {
+		v2Header.IndexOffset = 0
+	} else {
+		if idx, err = GenerateIndex(payload, opts...); err != nil {
+			return 0, err
+		}
+		if _, err := payload.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := dst.Write(Pragma); err != nil {
+		return 0, err
+	}
+	if _, err := v2Header.WriteTo(dst); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(dst, payload); err != nil {
+		return 0, err
+	}
+	if idx != nil {
+		if _, err := index.WriteTo(idx, dst); err != nil {
+			return 0, err
+		}
+	}
+	return recovered, nil
+}
+
+// locatePayload returns the offset and size of the data payload of the CARv2 src, as specified by
+// its header if sane. Otherwise, the data payload is located right after the header and any null
+// padding that follows it, and its size is returned as -1, i.e. unknown.
+func locatePayload(src io.ReaderAt, o Options) (int64, int64, error) {
+	var header Header
+	if _, err := header.ReadFrom(io.NewSectionReader(src, PragmaSize, HeaderSize)); err == nil {
+		dataOffset, dataSize := int64(header.DataOffset), int64(header.DataSize)
+		if dataOffset+dataSize > dataOffset && hasV1HeaderAt(src, dataOffset, o) {
+			return dataOffset, dataSize, nil
+		}
+	}
+
+	// Skip over null padding, which is as far as the data payload can be told apart heuristically.
+	offset := int64(PragmaSize + HeaderSize)
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.ReadAt(buf, offset)
+		if i := bytes.IndexFunc(buf[:n], func(r rune) bool { return r != 0 }); i >= 0 {
+			offset += int64(i)
+			break
+		}
+		offset += int64(n)
+		if uint64(offset-PragmaSize-HeaderSize) > o.MaxAllowedPadding {
+			return 0, 0, errors.New("cannot repair CAR; no data payload found within the maximum allowed padding")
+		}
+		if err == io.EOF {
+			return 0, 0, errors.New("cannot repair CAR; no data payload found after CARv2 header")
+		} else if err != nil {
+			return 0, 0, err
+		}
+	}
+	if !hasV1HeaderAt(src, offset, o) {
+		return 0, 0, fmt.Errorf("cannot repair CAR; no valid CARv1 header found at offset %d", offset)
+	}
+	return offset, -1, nil
+}
+
+// hasV1HeaderAt checks whether a valid CARv1 header is present at the given offset of src.
+func hasV1HeaderAt(src io.ReaderAt, offset int64, o Options) bool {
+	rdr, err := internalio.NewOffsetReadSeeker(src, offset)
+	if err != nil {
+		return false
+	}
+	header, err := carv1.ReadHeader(rdr, o.MaxAllowedHeaderSize)
+	return err == nil && header.Version == 1
+}
+
+// scanRecoverableSections scans the sections of the data payload at the given offset of src, up to
+// dataSize bytes unless it is negative, and returns the number of sections scanned and the size of
+// the data payload they span, including its CARv1 header. Scanning stops at the first section that
+// cannot be recovered; an error is only returned if reading from src fails for any other reason.
+func scanRecoverableSections(src io.ReaderAt, dataOffset, dataSize int64, o Options) (uint64, int64, error) {
+	if dataSize < 0 {
+		dataSize = math.MaxInt64 - dataOffset
+	}
+	rdr, err := internalio.NewOffsetReadSeeker(src, dataOffset)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := carv1.ReadHeader(rdr, o.MaxAllowedHeaderSize); err != nil {
+		return 0, 0, err
+	}
+	size, err := rdr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var recovered uint64
+	for size < dataSize {
+		length, _, err := util.ReadUvarint(rdr, o.LenientVarints)
+		if err != nil {
+			if isDamagedSection(err) {
+				break
+			}
+			return 0, 0, err
+		}
+		if length == 0 {
+			if o.SkipNullPadding {
+				continue
+			}
+			// Whether treated as the end of the payload or as corrupt, nothing past it is recovered.
+			break
+		}
+		offset, err := rdr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, 0, err
+		}
+		if length > o.MaxAllowedSectionSize || length > uint64(dataSize-offset) {
+			break
+		}
+
+		section := make([]byte, length)
+		if _, err := io.ReadFull(rdr, section); err != nil {
+			if isDamagedSection(err) {
+				break
+			}
+			return 0, 0, err
+		}
+		n, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			break
+		}
+		if hashed, err := c.Prefix().Sum(section[n:]); err != nil || !hashed.Equals(c) {
+			break
+		}
+		recovered++
+		size = offset + int64(length)
+	}
+	return recovered, size, nil
+}
+
+// isDamagedSection checks whether the given error, returned while reading a section, signals that
+// the section is truncated or malformed, as opposed to a failure to read it.
+func isDamagedSection(err error) bool {
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, varint.ErrOverflow, varint.ErrNotMinimal:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,248 @@
+package car_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	original, err := os.ReadFile("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+	reader, err := carv2.NewReader(bytes.NewReader(original))
+	require.NoError(t, err)
+	dataOffset, dataSize := reader.Header.DataOffset, reader.Header.DataSize
+	want := readAllBlocks(t, original)
+	require.Greater(t, len(want), 10)
+
+	// Find the end of the section of the fifth block, relative to the start of the file.
+	idx, err := carv2.GenerateIndex(bytes.NewReader(original), carv2.StoreIdentityCIDs(true))
+	require.NoError(t, err)
+	fifthOffset, err := index.GetFirst(idx, want[4].Cid())
+	require.NoError(t, err)
+	fifthEnd := dataOffset + fifthOffset + util.LdSize(want[4].Cid().Bytes(), want[4].RawData())
+
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		corrupt func() []byte
+		// wantCount is the number of blocks expected to be recovered.
+		wantCount int
+	}{
+		{
+			name:      "Intact",
+			corrupt:   func() []byte { return original },
+			wantCount: len(want),
+		},
+		{
+			name: "ZeroedHeader",
+			corrupt: func() []byte {
+				corrupted := append([]byte{}, original...)
+				copy(corrupted[carv2.PragmaSize:], make([]byte, carv2.HeaderSize))
+				return corrupted
+			},
+			wantCount: len(want),
+		},
+		{
+			name: "HeaderWithOverflowingOffsets",
+			corrupt: func() []byte {
+				corrupted := append([]byte{}, original...)
+				copy(corrupted[carv2.PragmaSize:], bytes.Repeat([]byte{0xff}, carv2.HeaderSize))
+				return corrupted
+			},
+			wantCount: len(want),
+		},
+		{
+			name: "HeaderWithWrongDataOffset",
+			corrupt: func() []byte {
+				corrupted := append([]byte{}, original...)
+				binary.LittleEndian.PutUint64(corrupted[carv2.PragmaSize+carv2.CharacteristicsSize:], dataOffset+dataSize)
+				return corrupted
+			},
+			wantCount: len(want),
+		},
+		{
+			name: "CorruptIndex",
+			corrupt: func() []byte {
+				corrupted := append([]byte{}, original...)
+				copy(corrupted[reader.Header.IndexOffset:], bytes.Repeat([]byte{0xff}, 64))
+				return corrupted
+			},
+			wantCount: len(want),
+		},
+		{
+			name: "TruncatedTail",
+			corrupt: func() []byte {
+				return original[:fifthEnd-1]
+			},
+			wantCount: 4,
+		},
+		{
+			name: "TruncatedTailWithZeroedHeader",
+			corrupt: func() []byte {
+				corrupted := append([]byte{}, original[:fifthEnd-1]...)
+				copy(corrupted[carv2.PragmaSize:], make([]byte, carv2.HeaderSize))
+				return corrupted
+			},
+			wantCount: 4,
+		},
+		{
+			name: "CorruptSectionData",
+			corrupt: func() []byte {
+				corrupted := append([]byte{}, original...)
+				corrupted[fifthEnd-1] ^= 0xff
+				return corrupted
+			},
+			wantCount: 4,
+		},
+		{
+			name: "PaddedPayloadWithZeroedHeader",
+			corrupt: func() []byte {
+				var buf bytes.Buffer
+				buf.Write(carv2.Pragma)
+				buf.Write(make([]byte, carv2.HeaderSize+123))
+				buf.Write(v1)
+				return buf.Bytes()
+			},
+			wantCount: len(want),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var repaired bytes.Buffer
+			recovered, err := carv2.Repair(bytes.NewReader(tt.corrupt()), &repaired)
+			require.NoError(t, err)
+			require.Equal(t, uint64(tt.wantCount), recovered)
+			requireRepaired(t, repaired.Bytes(), want[:tt.wantCount], want[tt.wantCount:])
+		})
+	}
+
+	t.Run("WithoutIndex", func(t *testing.T) {
+		var repaired bytes.Buffer
+		recovered, err := carv2.Repair(bytes.NewReader(original[:fifthEnd-1]), &repaired, carv2.WithoutIndex())
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), recovered)
+		subject, err := carv2.NewReader(bytes.NewReader(repaired.Bytes()))
+		require.NoError(t, err)
+		require.Zero(t, subject.Header.IndexOffset)
+		require.Equal(t, uint64(repaired.Len()), subject.Header.DataOffset+subject.Header.DataSize)
+		require.Equal(t, want[:4], readAllBlocks(t, repaired.Bytes()))
+	})
+}
+
+func TestRepair_IsErrorWhenUnrecoverable(t *testing.T) {
+	original, err := os.ReadFile("testdata/sample-wrapped-v2.car")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		src  func() []byte
+	}{
+		{
+			name: "CARv1",
+			src: func() []byte {
+				v1, err := os.ReadFile("testdata/sample-v1.car")
+				require.NoError(t, err)
+				return v1
+			},
+		},
+		{
+			name: "CorruptPragma",
+			src: func() []byte {
+				corrupted := append([]byte{}, original...)
+				corrupted[3] ^= 0xff
+				return corrupted
+			},
+		},
+		{
+			name: "CorruptHeaderAndPayloadHeader",
+			src: func() []byte {
+				corrupted := append([]byte{}, original...)
+				copy(corrupted[carv2.PragmaSize:], bytes.Repeat([]byte{0xff}, carv2.HeaderSize+8))
+				return corrupted
+			},
+		},
+		{
+			name: "OnlyPragmaAndHeader",
+			src: func() []byte {
+				return original[:carv2.PragmaSize+carv2.HeaderSize]
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := carv2.Repair(bytes.NewReader(tt.src()), io.Discard)
+			require.Error(t, err)
+		})
+	}
+}
+
+// requireRepaired asserts that the given repaired CARv2 is well-formed, and contains exactly the
+// wanted blocks, none of which are among the dropped blocks.
+func requireRepaired(t *testing.T, repaired []byte, want, dropped []blocks.Block) {
+	subject, err := carv2.NewReader(bytes.NewReader(repaired))
+	require.NoError(t, err)
+	require.Equal(t, uint64(carv2.PragmaSize+carv2.HeaderSize), subject.Header.DataOffset)
+	require.Equal(t, subject.Header.DataOffset+subject.Header.DataSize, subject.Header.IndexOffset)
+	require.Equal(t, want, readAllBlocks(t, repaired))
+
+	// Assert the regenerated index is attached, and is the one generated from the data payload.
+	ir, err := subject.IndexReader()
+	require.NoError(t, err)
+	attached, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	dr, err := subject.DataReader()
+	require.NoError(t, err)
+	generated, err := carv2.GenerateIndex(dr)
+	require.NoError(t, err)
+	require.Equal(t, marshalIndex(t, generated), marshalIndex(t, attached))
+
+	bs, err := blockstore.NewReadOnly(bytes.NewReader(repaired), nil, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, bs.Close()) })
+	for _, blk := range want {
+		got, err := bs.Get(context.TODO(), blk.Cid())
+		require.NoError(t, err)
+		require.Equal(t, blk.RawData(), got.RawData())
+	}
+	wanted := make(map[string]bool)
+	for _, blk := range want {
+		wanted[blk.Cid().KeyString()] = true
+	}
+	for _, blk := range dropped {
+		if blk.Cid().Prefix().MhType == multihash.IDENTITY || wanted[blk.Cid().KeyString()] {
+			// Identity CIDs are always present, as are dropped blocks that were also recovered.
+			continue
+		}
+		has, err := bs.Has(context.TODO(), blk.Cid())
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+}
+
+func readAllBlocks(t *testing.T, car []byte) []blocks.Block {
+	br, err := carv2.NewBlockReader(bytes.NewReader(car))
+	require.NoError(t, err)
+	var got []blocks.Block
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			return got
+		}
+		require.NoError(t, err)
+		got = append(got, blk)
+	}
+}
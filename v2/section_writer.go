@@ -0,0 +1,61 @@
+package car
+
+import (
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+)
+
+// SectionWriter is a low-level writer for assembling CARs with custom layouts, one part at a
+// time, e.g. to write sections in a precomputed order that does not fit the blockstore model.
+//
+// Each Write method writes its part at the current position of the underlying io.WriteSeeker,
+// which may be moved by the caller in between writes, e.g. to leave room for padding or to
+// rewrite the CARv2 header once the data size is known. No validation of the overall layout is
+// performed; it is up to the caller to write the parts in the order mandated by the CAR format:
+// for a CARv2, the pragma, the header, the data payload and the optional index, where the data
+// payload is a CARv1, i.e. a CARv1 header followed by sections.
+type SectionWriter struct {
+	w io.WriteSeeker
+}
+
+// NewSectionWriter instantiates a new SectionWriter that writes to w.
+func NewSectionWriter(w io.WriteSeeker) *SectionWriter {
+	return &SectionWriter{w: w}
+}
+
+// WritePragma writes the CARv2 pragma.
+func (sw *SectionWriter) WritePragma() error {
+	_, err := sw.w.Write(Pragma)
+	return err
+}
+
+// WriteHeader writes the given CARv2 header, which must immediately follow the pragma.
+func (sw *SectionWriter) WriteHeader(h Header) error {
+	_, err := h.WriteTo(sw.w)
+	return err
+}
+
+// WriteV1Header writes a CARv1 header with the given roots, which starts the data payload of a
+// CARv2, or a CARv1 as a whole.
+func (sw *SectionWriter) WriteV1Header(roots []cid.Cid) error {
+	return carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: 1}, sw.w)
+}
+
+// WriteSection writes a section made up of the given CID and block data, prefixed by its
+// varint length. It returns the offset at which the section starts, i.e. the position of the
+// underlying io.WriteSeeker before writing. Note that the offset is relative to the start of the
+// io.WriteSeeker; when assembling a CARv2, the data offset must be subtracted from it to get the
+// offset of the section relative to the data payload, as recorded by indices.
+func (sw *SectionWriter) WriteSection(c cid.Cid, data []byte) (offset uint64, err error) {
+	pos, err := sw.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if err := util.LdWrite(sw.w, c.Bytes(), data); err != nil {
+		return 0, err
+	}
+	return uint64(pos), nil
+}
@@ -0,0 +1,89 @@
+package car_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSectionWriter(t *testing.T) {
+	f, err := os.Open("testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	r, err := carv1.NewCarReader(f)
+	require.NoError(t, err)
+	var blks []blocks.Block
+	for {
+		blk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		blks = append(blks, blk)
+	}
+
+	path := filepath.Join(t.TempDir(), "assembled.car")
+	out, err := os.Create(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, out.Close()) })
+	subject := carv2.NewSectionWriter(out)
+
+	// Write the pragma and leave room for the header along with some padding, to be written
+	// once the data size and index offset are known.
+	const dataPadding = 7
+	require.NoError(t, subject.WritePragma())
+	header := carv2.NewHeader(0).WithDataPadding(dataPadding)
+	_, err = out.Seek(int64(header.DataOffset), io.SeekStart)
+	require.NoError(t, err)
+
+	// Write the sections in reverse order, recording their offsets relative to the data payload.
+	require.NoError(t, subject.WriteV1Header(r.Header.Roots))
+	var records []index.Record
+	for i := len(blks) - 1; i >= 0; i-- {
+		offset, err := subject.WriteSection(blks[i].Cid(), blks[i].RawData())
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, offset, header.DataOffset)
+		records = append(records, index.Record{Cid: blks[i].Cid(), Offset: offset - header.DataOffset})
+	}
+	dataEnd, err := out.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	header = header.WithDataSize(uint64(dataEnd) - header.DataOffset)
+
+	idx, err := index.New(carv2.ApplyOptions().IndexCodec)
+	require.NoError(t, err)
+	require.NoError(t, idx.Load(records))
+	_, err = index.WriteTo(idx, out)
+	require.NoError(t, err)
+
+	_, err = out.Seek(carv2.PragmaSize, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, subject.WriteHeader(header))
+
+	// Assert the assembled CAR is readable, and every block is found at its recorded offset.
+	reader, err := carv2.OpenReader(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, reader.Close()) })
+	require.Equal(t, header, reader.Header)
+	stats, err := reader.Inspect(true)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(blks)), stats.BlockCount)
+	require.Equal(t, r.Header.Roots, stats.Roots)
+
+	bs, err := blockstore.OpenReadOnly(path, blockstore.UseWholeCIDs(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, bs.Close()) })
+	for _, want := range blks {
+		got, err := bs.Get(context.TODO(), want.Cid())
+		require.NoError(t, err)
+		require.Equal(t, want.RawData(), got.RawData())
+	}
+}
@@ -154,7 +154,7 @@ func (tc *traversalCar) WriteTo(w io.Writer) (int64, error) {
 			}
 		}
 		in, err := index.WriteTo(idx, w)
-		n += int64(in)
+		n += in
 		if err != nil {
 			return n, err
 		}
@@ -164,6 +164,9 @@ func (tc *traversalCar) WriteTo(w io.Writer) (int64, error) {
 }
 
 func (tc *traversalCar) WriteV2Header(w io.Writer) (int64, error) {
+	if err := ValidatePadding(tc.opts.DataPadding, tc.opts.IndexPadding, tc.opts.MaxAllowedPadding); err != nil {
+		return 0, err
+	}
 	n, err := w.Write(Pragma)
 	if err != nil {
 		return int64(n), err
@@ -0,0 +1,215 @@
+package car
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	"github.com/multiformats/go-multihash"
+)
+
+var errStreamWriterClosed = errors.New("stream writer is closed")
+
+// StreamWriter writes a CARv2 to an io.Writer in a single forward pass, from blocks given one at a
+// time, without seeking. Since the CARv2 header precedes the data payload and records its size,
+// the data payload is spooled to a caller-provided io.ReadWriter, e.g. a temporary file or a
+// bytes.Buffer, while the index of its blocks is accumulated in memory. Close then writes the
+// pragma, the header, the spooled data payload and the index to the destination, in that order.
+//
+// The CARv2 written is byte for byte the same as the one written by a ReadWrite blockstore opened
+// via blockstore.OpenReadWrite with the same roots and options, to which the same blocks are put
+// before it is finalized. Accordingly, the options honoured are UseDataPadding, UseIndexPadding,
+// UseIndexCodec, WithDataChecksum, StoreIdentityCIDs and MaxIndexCidSize, along with the blockstore
+// options AllowDuplicatePuts, UseWholeCIDs, WithStrictCodecMatch, WithIndexChecksum and
+// WithInsertionIndexMode. As with the blockstore, blocks are deduplicated by multihash by default.
+//
+// StreamWriter is not safe for concurrent use. Once any of its methods returns an error other than
+// for a rejected block, e.g. because writing to the spool failed, it should be discarded.
+type StreamWriter struct {
+	dst   io.Writer
+	spool io.ReadWriter
+	// spoolStart is the position of spool before the data payload was written to it, if spool is an
+	// io.Seeker.
+	spoolStart int64
+	// data writes to spool, and to checksum if enabled.
+	data     io.Writer
+	checksum hash.Hash
+	size     uint64
+	idx      *index.InsertionIndex
+	opts     Options
+	closed   bool
+}
+
+// NewStreamWriter instantiates a new StreamWriter that writes a CARv2 with the given roots to dst,
+// spooling the data payload to spool until Close is called.
+//
+// The spool must read back what is written to it from the start, as a bytes.Buffer does, or else
+// implement io.Seeker, as an os.File does, in which case it is rewound to its current position as
+// of this call before it is read back. The spool is neither closed nor truncated by StreamWriter.
+func NewStreamWriter(dst io.Writer, spool io.ReadWriter, roots []cid.Cid, opts ...Option) (*StreamWriter, error) {
+	o := ApplyOptions(opts...)
+	if err := ValidatePadding(o.DataPadding, o.IndexPadding, o.MaxAllowedPadding); err != nil {
+		return nil, err
+	}
+	sw := &StreamWriter{
+		dst:   dst,
+		spool: spool,
+		data:  spool,
+		idx:   index.NewInsertionIndexWithMode(o.BlockstoreInsertionIndexMode),
+		opts:  o,
+	}
+	if o.DataChecksum != 0 {
+		var err error
+		if sw.checksum, err = newDataChecksumHash(o.DataChecksum); err != nil {
+			return nil, err
+		}
+		sw.data = io.MultiWriter(spool, sw.checksum)
+	}
+	if s, ok := spool.(io.Seeker); ok {
+		var err error
+		if sw.spoolStart, err = s.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+
+	header := &carv1.CarHeader{Roots: roots, Version: 1}
+	size, err := carv1.HeaderSize(header)
+	if err != nil {
+		return nil, err
+	}
+	if err := carv1.WriteHeader(header, sw.data); err != nil {
+		return nil, err
+	}
+	sw.size = size
+	return sw, nil
+}
+
+// Put writes the given block as a section of the data payload; see WriteSection.
+func (sw *StreamWriter) Put(blk blocks.Block) error {
+	return sw.WriteSection(blk.Cid(), blk.RawData())
+}
+
+// WriteSection writes a section made up of the given CID and block data to the data payload, and
+// records it in the index.
+//
+// As with the blockstore, blocks with identity CIDs are skipped unless StoreIdentityCIDs is enabled,
+// and blocks that were written already are skipped unless AllowDuplicatePuts is enabled. An
+// ErrCidTooLarge error is returned if the CID is larger than MaxIndexCidSize.
+func (sw *StreamWriter) WriteSection(c cid.Cid, data []byte) error {
+	if sw.closed {
+		return errStreamWriterClosed
+	}
+	if !sw.opts.StoreIdentityCIDs {
+		dmh, err := multihash.Decode(c.Hash())
+		if err != nil {
+			return err
+		}
+		if dmh.Code == multihash.IDENTITY {
+			return nil
+		}
+	}
+	cidBytes := c.Bytes()
+	if cSize := uint64(len(cidBytes)); cSize > sw.opts.MaxIndexCidSize {
+		return &ErrCidTooLarge{MaxSize: sw.opts.MaxIndexCidSize, CurrentSize: cSize}
+	}
+	if !sw.opts.BlockstoreAllowDuplicatePuts {
+		if sw.opts.BlockstoreUseWholeCIDs || sw.opts.BlockstoreStrictCodecMatch {
+			if sw.idx.HasExactCID(c) {
+				return nil
+			}
+		} else if _, err := sw.idx.Get(c); err == nil {
+			return nil
+		}
+	}
+
+	if err := util.LdWrite(sw.data, cidBytes, data); err != nil {
+		return err
+	}
+	sw.idx.InsertSizedNoReplace(c, sw.size, uint64(len(cidBytes)+len(data)))
+	sw.size += util.LdSize(cidBytes, data)
+	return nil
+}
+
+// Close writes the CARv2 to the destination: the pragma, the header, the data payload read back
+// from the spool, the data checksum if enabled, and the index. The destination is not closed.
+// After this call, the StreamWriter can no longer be used.
+func (sw *StreamWriter) Close() error {
+	if sw.closed {
+		return errStreamWriterClosed
+	}
+	sw.closed = true
+
+	// Lay out the header as a ReadWrite blockstore does upon opening and finalizing.
+	header := NewHeader(0)
+	if p := sw.opts.DataPadding; p > 0 {
+		header = header.WithDataPadding(p)
+	}
+	if p := sw.opts.IndexPadding; p > 0 {
+		header = header.WithIndexPadding(p)
+	}
+	header = header.WithDataSize(sw.size)
+	header.Characteristics.SetFullyIndexed(sw.opts.StoreIdentityCIDs)
+	var checksumSize uint64
+	if sw.checksum != nil {
+		// The algorithm is checked by NewStreamWriter.
+		checksumSize, _ = DataChecksumSize(sw.opts.DataChecksum)
+	}
+	header = header.WithIndexPadding(checksumSize)
+
+	if _, err := sw.dst.Write(Pragma); err != nil {
+		return err
+	}
+	if _, err := header.WriteTo(sw.dst); err != nil {
+		return err
+	}
+	if err := writeZeros(sw.dst, header.DataOffset-PragmaSize-HeaderSize); err != nil {
+		return err
+	}
+	if s, ok := sw.spool.(io.Seeker); ok {
+		if _, err := s.Seek(sw.spoolStart, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if n, err := io.CopyN(sw.dst, sw.spool, int64(sw.size)); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("spool holds %d bytes of data payload, expected %d", n, sw.size)
+		}
+		return err
+	}
+	if sw.checksum != nil {
+		if _, err := writeDataChecksum(sw.dst, sw.opts.DataChecksum, sw.checksum.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	if err := writeZeros(sw.dst, header.IndexOffset-header.DataOffset-header.DataSize-checksumSize); err != nil {
+		return err
+	}
+	writeIndex := sw.idx.WriteFlattenedToWithChecksum
+	if sw.opts.BlockstoreDisableIndexChecksum {
+		writeIndex = sw.idx.WriteFlattenedTo
+	}
+	_, err := writeIndex(sw.dst, sw.opts.IndexCodec)
+	return err
+}
+
+// writeZeros writes n zero bytes to w.
+func writeZeros(w io.Writer, n uint64) error {
+	var zeros [4 << 10]byte
+	for n > 0 {
+		chunk := zeros[:]
+		if n < uint64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		n -= uint64(len(chunk))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package car_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamWriter_MatchesReadWrite(t *testing.T) {
+	f, err := os.Open("testdata/sample-v1.car")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	r, err := carv1.NewCarReader(f)
+	require.NoError(t, err)
+	var blks []blocks.Block
+	for {
+		blk, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		blks = append(blks, blk)
+	}
+	// Put some blocks twice, such that deduplication is exercised.
+	blks = append(blks, blks[:10]...)
+
+	tests := []struct {
+		name string
+		opts []carv2.Option
+	}{
+		{"Default", nil},
+		{"Padding", []carv2.Option{carv2.UseDataPadding(1413), carv2.UseIndexPadding(10 << 10)}},
+		{"WholeCIDs", []carv2.Option{carv2.UseIndexCodec(index.CarCidIndexSorted), blockstore.UseWholeCIDs(true)}},
+		{"DuplicatesAndIdentityCIDs", []carv2.Option{
+			blockstore.AllowDuplicatePuts(true),
+			carv2.StoreIdentityCIDs(true),
+			blockstore.WithIndexChecksum(false),
+		}},
+		{"DataChecksum", []carv2.Option{carv2.WithDataChecksum(multicodec.Sha2_256), carv2.UseIndexPadding(7)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "readwrite.car")
+			rw, err := blockstore.OpenReadWrite(path, r.Header.Roots, tt.opts...)
+			require.NoError(t, err)
+			for _, blk := range blks {
+				require.NoError(t, rw.Put(context.Background(), blk))
+			}
+			require.NoError(t, rw.Finalize())
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			var got bytes.Buffer
+			subject, err := carv2.NewStreamWriter(&got, &bytes.Buffer{}, r.Header.Roots, tt.opts...)
+			require.NoError(t, err)
+			for _, blk := range blks {
+				require.NoError(t, subject.Put(blk))
+			}
+			require.NoError(t, subject.Close())
+			require.Equal(t, want, got.Bytes())
+
+			require.Error(t, subject.Put(blks[0]))
+			require.Error(t, subject.Close())
+		})
+	}
+
+	t.Run("FileSpool", func(t *testing.T) {
+		// Spool to a file that holds unrelated bytes ahead of the data payload, which must be left
+		// out of the CAR written.
+		spool, err := os.Create(filepath.Join(t.TempDir(), "spool"))
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, spool.Close()) })
+		_, err = spool.Write([]byte("unrelated"))
+		require.NoError(t, err)
+
+		var got bytes.Buffer
+		subject, err := carv2.NewStreamWriter(&got, spool, r.Header.Roots)
+		require.NoError(t, err)
+		for _, blk := range blks {
+			require.NoError(t, subject.WriteSection(blk.Cid(), blk.RawData()))
+		}
+		require.NoError(t, subject.Close())
+
+		reader, err := carv2.NewReader(bytes.NewReader(got.Bytes()))
+		require.NoError(t, err)
+		stats, err := reader.Inspect(true)
+		require.NoError(t, err)
+		// Identity CIDs are not stored by default, and duplicates are skipped.
+		require.Equal(t, uint64(1043), stats.BlockCount)
+		require.Zero(t, stats.MhTypeCounts[multicodec.Identity])
+		require.Equal(t, r.Header.Roots, stats.Roots)
+		// The index is checksummed by default, as written by a ReadWrite blockstore.
+		require.Equal(t, index.CarIndexChecksummed, stats.IndexCodec)
+	})
+}
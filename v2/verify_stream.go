@@ -0,0 +1,101 @@
+package car
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2/internal/links"
+	"github.com/multiformats/go-multihash"
+)
+
+// VerifyStream verifies that the CARv1 or CARv2 read from r contains exactly the DAG rooted at the
+// given root, reading it sequentially without seeking. This allows a CAR to be verified as it is
+// fetched from an untrusted source, e.g. over the network, before it is stored anywhere.
+//
+// The header of the CAR must declare root as one of its roots. Every block is hashed as it is read
+// to confirm it matches its CID, failing fast on the first block that does not. Once the end of the
+// CAR is reached, the DAG is walked from root via the links of the blocks read, and an
+// ErrMissingBlock is returned for the first link to a block that is absent, or an
+// ErrUnreachableBlock for the first block read that is not linked to from root. Blocks may appear
+// in any order and more than once. Identity CIDs are considered present, and their inlined data is
+// walked as a block.
+//
+// Blocks encoded as raw, dag-pb or dag-cbor are supported; raw blocks have no links. An error is
+// returned if a block uses any other codec or fails to decode. The links of every block read are
+// held in memory until the end of the CAR is reached, but the block data is not.
+//
+// Options are applied as they are by NewBlockReader, e.g. ZeroLengthSectionAsEOF,
+// WithSkipNullPadding and MaxAllowedSectionSize.
+func VerifyStream(r io.Reader, root cid.Cid, opts ...Option) error {
+	br, err := NewBlockReader(r, opts...)
+	if err != nil {
+		return err
+	}
+	var declared bool
+	for _, c := range br.Roots {
+		if c.Equals(root) {
+			declared = true
+			break
+		}
+	}
+	if !declared {
+		return fmt.Errorf("CAR does not declare %s as a root", root)
+	}
+
+	// Record the links of each block, in the order blocks are read.
+	present := make(map[cid.Cid][]cid.Cid)
+	var order []cid.Cid
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		c := blk.Cid()
+		if _, seen := present[c]; seen {
+			continue
+		}
+		linked, err := links.Extract(c, blk.RawData())
+		if err != nil {
+			return err
+		}
+		present[c] = linked
+		order = append(order, c)
+	}
+
+	visited := map[cid.Cid]struct{}{root: {}}
+	queue := []cid.Cid{root}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		linked, found := present[c]
+		if !found {
+			if c.Prefix().MhType != multihash.IDENTITY {
+				return &ErrMissingBlock{Cid: c}
+			}
+			dmh, err := multihash.Decode(c.Hash())
+			if err != nil {
+				return err
+			}
+			if linked, err = links.Extract(c, dmh.Digest); err != nil {
+				return err
+			}
+		}
+		for _, link := range linked {
+			if _, seen := visited[link]; !seen {
+				visited[link] = struct{}{}
+				queue = append(queue, link)
+			}
+		}
+	}
+	for _, c := range order {
+		if _, reached := visited[c]; !reached {
+			return &ErrUnreachableBlock{Cid: c}
+		}
+	}
+	return nil
+}
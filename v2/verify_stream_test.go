@@ -0,0 +1,153 @@
+package car_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipfs/go-merkledag"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyStream(t *testing.T) {
+	leaf := merkledag.NewRawNode([]byte("fish"))
+	unrelated := merkledag.NewRawNode([]byte("lobster"))
+	inlinePb := merkledag.NodeWithData([]byte("barreleye"))
+	require.NoError(t, inlinePb.AddNodeLink("fish", leaf))
+	inline, err := cid.NewPrefixV1(cid.DagProtobuf, multihash.IDENTITY).Sum(inlinePb.RawData())
+	require.NoError(t, err)
+
+	pb := merkledag.NodeWithData([]byte("octopus"))
+	require.NoError(t, pb.AddNodeLink("fish", leaf))
+
+	root, err := cbor.WrapObject(map[string]interface{}{
+		"pb":     pb.Cid(),
+		"again":  leaf.Cid(),
+		"inline": inline,
+	}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	roots := []cid.Cid{root.Cid()}
+
+	tampered := append([]byte{}, leaf.RawData()...)
+	tampered[0] ^= 0xff
+	tamperedLeaf, err := blocks.NewBlockWithCid(tampered, leaf.Cid())
+	require.NoError(t, err)
+
+	unsupportedData := []byte(`{"fish":"lobster"}`)
+	unsupportedCid, err := cid.NewPrefixV1(uint64(multicodec.DagJson), multihash.SHA2_256).Sum(unsupportedData)
+	require.NoError(t, err)
+	unsupported, err := blocks.NewBlockWithCid(unsupportedData, unsupportedCid)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		car     []byte
+		root    cid.Cid
+		wantErr func(t *testing.T, err error)
+	}{
+		{
+			name: "Valid",
+			car:  writeV1(t, roots, root, pb, leaf),
+			root: root.Cid(),
+		},
+		{
+			name: "ValidInReverseOrderWithDuplicates",
+			car:  writeV1(t, roots, leaf, pb, leaf, root, pb),
+			root: root.Cid(),
+		},
+		{
+			name: "ValidCARv2",
+			car: func() []byte {
+				var v2 bytes.Buffer
+				require.NoError(t, carv2.WrapV1(bytes.NewReader(writeV1(t, roots, root, pb, leaf)), &v2))
+				return v2.Bytes()
+			}(),
+			root: root.Cid(),
+		},
+		{
+			name: "TamperedBlock",
+			car:  writeV1(t, roots, root, pb, tamperedLeaf),
+			root: root.Cid(),
+			wantErr: func(t *testing.T, err error) {
+				require.Contains(t, err.Error(), "mismatch in content integrity")
+			},
+		},
+		{
+			name: "MissingBlock",
+			car:  writeV1(t, roots, root, leaf),
+			root: root.Cid(),
+			wantErr: func(t *testing.T, err error) {
+				var missing *carv2.ErrMissingBlock
+				require.True(t, errors.As(err, &missing))
+				require.Equal(t, pb.Cid(), missing.Cid)
+			},
+		},
+		{
+			name: "UnreachableBlock",
+			car:  writeV1(t, roots, root, pb, unrelated, leaf),
+			root: root.Cid(),
+			wantErr: func(t *testing.T, err error) {
+				var unreachable *carv2.ErrUnreachableBlock
+				require.True(t, errors.As(err, &unreachable))
+				require.Equal(t, unrelated.Cid(), unreachable.Cid)
+			},
+		},
+		{
+			name: "UndeclaredRoot",
+			car:  writeV1(t, []cid.Cid{pb.Cid()}, root, pb, leaf),
+			root: root.Cid(),
+			wantErr: func(t *testing.T, err error) {
+				require.Contains(t, err.Error(), "does not declare")
+			},
+		},
+		{
+			name: "SubDAGWithUnreachableParent",
+			car:  writeV1(t, []cid.Cid{root.Cid(), pb.Cid()}, root, pb, leaf),
+			root: pb.Cid(),
+			wantErr: func(t *testing.T, err error) {
+				var unreachable *carv2.ErrUnreachableBlock
+				require.True(t, errors.As(err, &unreachable))
+				require.Equal(t, root.Cid(), unreachable.Cid)
+			},
+		},
+		{
+			name: "UnsupportedCodec",
+			car:  writeV1(t, []cid.Cid{unsupportedCid}, unsupported),
+			root: unsupportedCid,
+			wantErr: func(t *testing.T, err error) {
+				require.Contains(t, err.Error(), "unsupported codec")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Hide all but the Read method of the reader, asserting the CAR is only ever read sequentially.
+			err := carv2.VerifyStream(struct{ io.Reader }{bytes.NewReader(tt.car)}, tt.root)
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+// writeV1 writes a CARv1 with the given roots and blocks, in the given order.
+func writeV1(t *testing.T, roots []cid.Cid, blks ...blocks.Block) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: roots, Version: 1}, &buf))
+	for _, blk := range blks {
+		require.NoError(t, util.LdWrite(&buf, blk.Cid().Bytes(), blk.RawData()))
+	}
+	return buf.Bytes()
+}
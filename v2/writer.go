@@ -1,16 +1,23 @@
 package car
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
 	internalio "github.com/ipld/go-car/v2/internal/io"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 )
 
 // ErrAlreadyV1 signals that the given payload is already in CARv1 format.
@@ -20,7 +27,8 @@ var ErrAlreadyV1 = errors.New("already a CARv1")
 // The source path is assumed to exist, and the destination path is overwritten.
 // Note that the destination path might still be created even if an error
 // occurred.
-func WrapV1File(srcPath, dstPath string) error {
+// The given options are applied as they are by WrapV1.
+func WrapV1File(srcPath, dstPath string, opts ...Option) error {
 	src, err := os.Open(srcPath)
 	if err != nil {
 		return err
@@ -33,7 +41,7 @@ func WrapV1File(srcPath, dstPath string) error {
 	}
 	defer dst.Close()
 
-	if err := WrapV1(src, dst); err != nil {
+	if err := WrapV1(src, dst, opts...); err != nil {
 		return err
 	}
 
@@ -47,13 +55,21 @@ func WrapV1File(srcPath, dstPath string) error {
 }
 
 // WrapV1 takes a CARv1 file and wraps it as a CARv2 file with an index.
-// The resulting CARv2 file's inner CARv1 payload is left unmodified,
-// and does not use any padding before the innner CARv1 or index.
+// The resulting CARv2 file's inner CARv1 payload is left unmodified.
+//
+// The index is generated in the codec set via UseIndexCodec. The inner CARv1 and the index are
+// preceded by the padding set via UseDataPadding and UseIndexPadding respectively, if any, which is
+// filled with zeros; the checksum of the CARv1, if enabled via WithDataChecksum, is written right
+// after it, ahead of the index padding. As by blockstore.ReadWrite.Finalize, the index is written
+// with a checksum unless disabled via blockstore.WithIndexChecksum; see index.WriteToWithChecksum.
 func WrapV1(src io.ReadSeeker, dst io.Writer, opts ...Option) error {
 	// TODO: verify src is indeed a CARv1 to prevent misuse.
 	// GenerateIndex should probably be in charge of that.
 
 	o := ApplyOptions(opts...)
+	if err := ValidatePadding(o.DataPadding, o.IndexPadding, o.MaxAllowedPadding); err != nil {
+		return err
+	}
 	idx, err := index.New(o.IndexCodec)
 	if err != nil {
 		return err
@@ -73,24 +89,172 @@ func WrapV1(src io.ReadSeeker, dst io.Writer, opts ...Option) error {
 	}
 
 	// Similar to the writer API, write all components of a CARv2 to the
-	// destination file: Pragma, Header, CARv1, Index.
+	// destination file: Pragma, Header, data padding, CARv1, data checksum if enabled, index
+	// padding, Index.
 	v2Header := NewHeader(uint64(v1Size))
+	if p := o.DataPadding; p > 0 {
+		v2Header = v2Header.WithDataPadding(p)
+	}
+	if p := o.IndexPadding; p > 0 {
+		v2Header = v2Header.WithIndexPadding(p)
+	}
+	var data io.Reader = src
+	var checksum hash.Hash
+	if o.DataChecksum != 0 {
+		// Reserve space for the checksum right after the CARv1, as index padding, and compute it
+		// as the CARv1 is copied.
+		size, err := DataChecksumSize(o.DataChecksum)
+		if err != nil {
+			return err
+		}
+		v2Header = v2Header.WithIndexPadding(size)
+		if checksum, err = newDataChecksumHash(o.DataChecksum); err != nil {
+			return err
+		}
+		data = io.TeeReader(src, checksum)
+	}
 	if _, err := dst.Write(Pragma); err != nil {
 		return err
 	}
 	if _, err := v2Header.WriteTo(dst); err != nil {
 		return err
 	}
-	if _, err := io.Copy(dst, src); err != nil {
+	if err := writeZeros(dst, o.DataPadding); err != nil {
 		return err
 	}
-	if _, err := index.WriteTo(idx, dst); err != nil {
+	if _, err := io.Copy(dst, data); err != nil {
+		return err
+	}
+	if checksum != nil {
+		if _, err := writeDataChecksum(dst, o.DataChecksum, checksum.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	if err := writeZeros(dst, o.IndexPadding); err != nil {
+		return err
+	}
+	if _, err := writeEmbeddedIndex(dst, idx, o); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// writeEmbeddedIndex writes idx to w with a checksum, unless disabled via
+// blockstore.WithIndexChecksum, such that indices written by this package are checksummed by
+// default as those written by blockstore.ReadWrite.Finalize and StreamWriter.
+func writeEmbeddedIndex(w io.Writer, idx index.Index, o Options) (int64, error) {
+	if o.BlockstoreDisableIndexChecksum {
+		return index.WriteTo(idx, w)
+	}
+	return index.WriteToWithChecksum(idx, w)
+}
+
+// Repad copies the CARv2 read from src to dst with its data payload and index preceded by the given
+// padding, i.e. as if it had been written with UseDataPadding(newDataPadding) and
+// UseIndexPadding(newIndexPadding), without reading its sections or regenerating its index. This
+// is useful to align the data payload to a boundary, e.g. the sector size of a storage provider.
+//
+// The offsets stored in a CARv2 index are relative to the start of the data payload rather than to
+// the start of the file, and therefore stay valid as the data payload is moved: the index is copied
+// as is, including its checksum, if any. Similarly, the data payload and its checksum, if any, are
+// copied as is, such that only the header and the padding differ from src. The padding is filled
+// with zeros, and the index padding follows the data checksum as it does in CARv2s written with
+// WithDataChecksum.
+//
+// The CARv2 is written at the current position of dst, and its header is written last, such that
+// dst is not a valid CARv2 until the copy completes. An error is returned if src is a CARv1, if its
+// index precedes its data payload, or if either padding exceeds MaxAllowedPadding.
+func Repad(src io.ReaderAt, dst io.WriteSeeker, newDataPadding, newIndexPadding uint64, opts ...Option) error {
+	o := ApplyOptions(opts...)
+	if err := ValidatePadding(newDataPadding, newIndexPadding, o.MaxAllowedPadding); err != nil {
+		return err
+	}
+	r, err := NewReader(src, opts...)
+	if err != nil {
+		return err
+	}
+	if r.Version == 1 {
+		return fmt.Errorf("cannot repad a CARv1")
+	}
+	srcHeader := r.Header
+	hasIndex := srcHeader.HasIndex()
+	if hasIndex && srcHeader.IndexOffset < srcHeader.DataOffset {
+		return fmt.Errorf("cannot repad a CARv2 whose index precedes its data payload")
+	}
+
+	// Carry the data checksum, if any, over to right after the data payload.
+	dataEnd := srcHeader.DataOffset + srcHeader.DataSize
+	checksumBound := uint64(maxDataChecksumSize)
+	if hasIndex && srcHeader.IndexOffset-dataEnd < checksumBound {
+		checksumBound = srcHeader.IndexOffset - dataEnd
+	}
+	algo, digest, err := readDataChecksum(io.NewSectionReader(src, int64(dataEnd), int64(checksumBound)))
+	if err != nil && err != ErrNoDataChecksum {
+		return err
+	}
+
+	header := NewHeader(srcHeader.DataSize).WithDataPadding(newDataPadding).WithIndexPadding(newIndexPadding)
+	header.Characteristics = srcHeader.Characteristics
+	if digest != nil {
+		// The algorithm is checked by readDataChecksum.
+		checksumSize, _ := DataChecksumSize(algo)
+		header = header.WithIndexPadding(checksumSize)
+	}
+	if !hasIndex {
+		header.IndexOffset = 0
+	}
+
+	start, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(Pragma); err != nil {
+		return err
+	}
+	// Leave the header zeroed until the copy completes.
+	if err := writeZeros(dst, HeaderSize+newDataPadding); err != nil {
+		return err
+	}
+	dr, err := r.DataReader()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, dr); err != nil {
+		return err
+	}
+	if digest != nil {
+		if _, err := writeDataChecksum(dst, algo, digest); err != nil {
+			return err
+		}
+	}
+	if hasIndex {
+		if err := writeZeros(dst, newIndexPadding); err != nil {
+			return err
+		}
+		ir, err := r.IndexReader()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, ir); err != nil {
+			return err
+		}
+	}
+	end, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.Seek(start+PragmaSize, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := header.WriteTo(dst); err != nil {
+		return err
+	}
+	_, err = dst.Seek(end, io.SeekStart)
+	return err
+}
+
 // ExtractV1File takes a CARv2 file and extracts its CARv1 data payload, unmodified.
 // The resulting CARv1 file will not include any data payload padding that may be present in the
 // CARv2 srcPath.
@@ -200,6 +364,322 @@ func ExtractV1File(srcPath, dstPath string, opts ...Option) (err error) {
 	return err
 }
 
+// ErrExtractV1Interrupted signals that a CAR file was being converted to a CARv1 in place by
+// ExtractV1InPlace, and that the conversion was interrupted part way through. Such a file is
+// neither a valid CARv2 nor a valid CARv1, and must be restored from elsewhere; its CARv2 header
+// still locates where the data payload was moved from.
+var ErrExtractV1Interrupted = errors.New("in-place extraction of CARv1 was interrupted; file is corrupt")
+
+// extractInPlaceBufferSize is the size of the chunks in which ExtractV1InPlace moves the data
+// payload.
+const extractInPlaceBufferSize = 1 << 20
+
+// ExtractV1Move describes bytes moved within a file by ExtractV1InPlace.
+type ExtractV1Move struct {
+	From   int64
+	To     int64
+	Length int64
+}
+
+// ExtractV1Plan describes the changes made to a file by ExtractV1InPlace. The pragma is first
+// zeroed, then the bytes are moved, and the file is truncated to its final size right before the
+// last move, which writes the head of the data payload in place of the pragma and header.
+type ExtractV1Plan struct {
+	// Moves lists the bytes moved, in the order they are moved.
+	Moves []ExtractV1Move
+	// OriginalSize is the size of the file before extraction.
+	OriginalSize int64
+	// Size is the size of the file after extraction, i.e. the size of the CARv1.
+	Size int64
+}
+
+// WithDryRun sets ExtractV1InPlace to only return the plan of the changes it would make to a file,
+// without making them.
+func WithDryRun() Option {
+	return func(o *Options) {
+		o.DryRun = true
+	}
+}
+
+// DetachIndexFromFile converts the CARv2 file at the given path to a CARv1 in place, by removing its
+// pragma, header, padding and index, and leaving only its data payload.
+// See ExtractV1InPlace.
+func DetachIndexFromFile(path string, opts ...Option) error {
+	_, err := ExtractV1InPlace(path, opts...)
+	return err
+}
+
+// ExtractV1InPlace converts the CARv2 file at the given path to the CARv1 of its data payload, in
+// place, i.e. without taking any more disk space. The data payload is moved down to the start of
+// the file, and the file is then truncated to the size of the data payload, discarding the index.
+// The plan of the changes made is returned; see WithDryRun to only compute it. If the file is a
+// CARv1, ErrAlreadyV1 is returned.
+//
+// Unlike ExtractV1File with the same source and destination paths, the conversion is made such
+// that an interruption, e.g. a crash, is detectable: before any byte is moved, the pragma is zeroed
+// and synced to disk, such that the file is no longer read as a CAR at all, and the first bytes of
+// the data payload are only written in its place once all other bytes have been moved and the file
+// truncated. Calling ExtractV1InPlace on a file whose conversion was interrupted returns
+// ErrExtractV1Interrupted. The conversion cannot be resumed though, since the bytes moved
+// overwrite the data payload as it was.
+func ExtractV1InPlace(path string, opts ...Option) (plan ExtractV1Plan, err error) {
+	o := ApplyOptions(opts...)
+	flag := os.O_RDWR
+	if o.DryRun {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, flag, 0o666)
+	if err != nil {
+		return ExtractV1Plan{}, err
+	}
+	defer func() {
+		// Close file and override return error type if it is nil.
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	pragma := make([]byte, PragmaSize)
+	if _, err := f.ReadAt(pragma, 0); err == nil && bytes.Equal(pragma, make([]byte, PragmaSize)) {
+		return ExtractV1Plan{}, ErrExtractV1Interrupted
+	}
+	r, err := NewReader(f, opts...)
+	if err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if r.Version == 1 {
+		return ExtractV1Plan{}, ErrAlreadyV1
+	}
+	dataOffset, dataSize := int64(r.Header.DataOffset), int64(r.Header.DataSize)
+	if dataSize <= 0 {
+		return ExtractV1Plan{}, fmt.Errorf("invalid data payload size: %d", dataSize)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if stat.Size() < dataOffset+dataSize {
+		return ExtractV1Plan{}, fmt.Errorf("data payload is truncated; file size %d is smaller than its end offset %d", stat.Size(), dataOffset+dataSize)
+	}
+
+	// The head of the data payload is written in place of the pragma and header last.
+	headSize := int64(PragmaSize + HeaderSize)
+	if dataSize < headSize {
+		headSize = dataSize
+	}
+	plan = ExtractV1Plan{OriginalSize: stat.Size(), Size: dataSize}
+	if tail := dataSize - headSize; tail > 0 {
+		plan.Moves = append(plan.Moves, ExtractV1Move{From: dataOffset + headSize, To: headSize, Length: tail})
+	}
+	plan.Moves = append(plan.Moves, ExtractV1Move{From: dataOffset, To: 0, Length: headSize})
+	if o.DryRun {
+		return plan, nil
+	}
+
+	head := make([]byte, headSize)
+	if _, err := f.ReadAt(head, dataOffset); err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if _, err := f.WriteAt(make([]byte, PragmaSize), 0); err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if len(plan.Moves) > 1 {
+		if err := moveWithin(f, plan.Moves[0]); err != nil {
+			return ExtractV1Plan{}, err
+		}
+	}
+	if err := f.Truncate(dataSize); err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if _, err := f.WriteAt(head, 0); err != nil {
+		return ExtractV1Plan{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return ExtractV1Plan{}, err
+	}
+	return plan, nil
+}
+
+// moveWithin moves the given bytes of f towards its start, in chunks of extractInPlaceBufferSize.
+// Since each chunk is read in full before it is written, and chunks are moved in ascending order,
+// no byte is overwritten before it has been moved.
+func moveWithin(f *os.File, m ExtractV1Move) error {
+	buf := make([]byte, extractInPlaceBufferSize)
+	for moved := int64(0); moved < m.Length; {
+		chunk := buf
+		if remaining := m.Length - moved; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		if _, err := f.ReadAt(chunk, m.From+moved); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(chunk, m.To+moved); err != nil {
+			return err
+		}
+		moved += int64(len(chunk))
+	}
+	return nil
+}
+
+// DetectDataSize sets whether ExtractV1Stream tolerates a CARv2 header that declares a data payload
+// size of zero, which only malformed CARv2s do, by detecting the end of the data payload instead:
+// the length-prefixed header and sections of the CARv1 are copied until the end of the input, or
+// until a zero-length section, i.e. null padding ahead of the index. Detection therefore fails if
+// the index directly follows the data payload without any padding in between.
+// Disabled by default, in which case such a header is rejected.
+func DetectDataSize(enable bool) Option {
+	return func(o *Options) {
+		o.DetectDataSize = enable
+	}
+}
+
+// ExtractV1Stream reads a CARv2 from src and writes its CARv1 data payload, unmodified, to dst,
+// returning the number of bytes written. Unlike ExtractV1File, neither src nor dst need to be
+// seekable: src is read sequentially, the padding ahead of the data payload is discarded, and
+// reading stops right after the data payload, such that the index, if any, is never read. This
+// allows the CARv1 to be extracted from a CARv2 read from a pipe or a network stream without
+// spooling it first.
+//
+// ErrAlreadyV1 is returned if src is a CARv1, once its header has been read. An ErrTruncatedStream
+// is returned if src ends before the pragma, the header, the padding or the data payload it
+// declares is read in full; the part of the data payload read up to then is written to dst
+// regardless. See DetectDataSize for headers that declare a data payload size of zero.
+func ExtractV1Stream(src io.Reader, dst io.Writer, opts ...Option) (int64, error) {
+	o := ApplyOptions(opts...)
+
+	buf := make([]byte, PragmaSize)
+	n, err := io.ReadFull(src, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	if !bytes.Equal(buf[:n], Pragma) {
+		if bytes.HasPrefix(Pragma, buf[:n]) {
+			return 0, &ErrTruncatedStream{Part: "pragma", Expected: PragmaSize, Actual: uint64(n)}
+		}
+		// Read what is not the pragma as a CARv1 header, as is the pragma, to tell its version.
+		pragmaOrV1Header, err := carv1.ReadHeader(io.MultiReader(bytes.NewReader(buf[:n]), src), o.MaxAllowedHeaderSize)
+		if err != nil {
+			return 0, err
+		}
+		switch pragmaOrV1Header.Version {
+		case 1:
+			return 0, ErrAlreadyV1
+		case 2:
+			// The pragma is encoded differently than usual, and read in full regardless.
+		default:
+			return 0, fmt.Errorf("source version must be 2; got: %d", pragmaOrV1Header.Version)
+		}
+	}
+
+	var header Header
+	buf = make([]byte, HeaderSize)
+	if n, err := io.ReadFull(src, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, &ErrTruncatedStream{Part: "header", Expected: HeaderSize, Actual: uint64(n)}
+		}
+		return 0, err
+	}
+	// Decode the header fields here rather than via Header.ReadFrom, which rejects a zero data size
+	// even if it is to be detected.
+	if _, err := header.Characteristics.ReadFrom(bytes.NewReader(buf[:CharacteristicsSize])); err != nil {
+		return 0, err
+	}
+	header.DataOffset = binary.LittleEndian.Uint64(buf[CharacteristicsSize:])
+	header.DataSize = binary.LittleEndian.Uint64(buf[CharacteristicsSize+8:])
+	header.IndexOffset = binary.LittleEndian.Uint64(buf[CharacteristicsSize+16:])
+	if header.DataOffset < PragmaSize+HeaderSize {
+		return 0, fmt.Errorf("invalid data payload offset: %d", header.DataOffset)
+	}
+	if int64(header.DataSize) < 0 || (header.DataSize == 0 && !o.DetectDataSize) {
+		return 0, fmt.Errorf("invalid data payload size: %d", header.DataSize)
+	}
+	if int64(header.IndexOffset) < 0 {
+		return 0, fmt.Errorf("invalid index offset: %d", header.IndexOffset)
+	}
+
+	padding := header.DataOffset - PragmaSize - HeaderSize
+	if n, err := io.CopyN(io.Discard, src, int64(padding)); err != nil {
+		if err == io.EOF {
+			return 0, &ErrTruncatedStream{Part: "data padding", Expected: padding, Actual: uint64(n)}
+		}
+		return 0, err
+	}
+
+	if header.DataSize == 0 {
+		return copyDataPayload(src, dst, o)
+	}
+	written, err := io.CopyN(dst, src, int64(header.DataSize))
+	if err == io.EOF {
+		err = &ErrTruncatedStream{Part: "data payload", Expected: header.DataSize, Actual: uint64(written)}
+	}
+	return written, err
+}
+
+// copyDataPayload copies the length-prefixed header and sections of a CARv1 from src to dst, as is,
+// until the end of src or a zero-length section, and returns the number of bytes written.
+func copyDataPayload(src io.Reader, dst io.Writer, o Options) (int64, error) {
+	br := &recordingByteReader{ByteReader: internalio.ToByteReader(src)}
+	var written int64
+	for maxLength := o.MaxAllowedHeaderSize; ; maxLength = o.MaxAllowedSectionSize {
+		br.read = br.read[:0]
+		length, _, err := util.ReadUvarint(br, o.LenientVarints)
+		if err == io.EOF || (err == nil && length == 0) {
+			return written, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return written, &ErrTruncatedStream{
+				Part:     "data payload",
+				Expected: uint64(written) + uint64(len(br.read)) + 1,
+				Actual:   uint64(written) + uint64(len(br.read)),
+			}
+		}
+		if err != nil {
+			return written, &ErrCorruptSection{Offset: uint64(written), Reason: fmt.Sprintf("cannot read section length: %v", err)}
+		}
+		if length > maxLength {
+			return written, &ErrCorruptSection{Offset: uint64(written), Reason: util.ErrSectionTooLarge.Error()}
+		}
+		n, err := dst.Write(br.read)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		copied, err := io.CopyN(dst, src, int64(length))
+		written += copied
+		if err == io.EOF {
+			return written, &ErrTruncatedStream{
+				Part:     "data payload",
+				Expected: uint64(written) + length - uint64(copied),
+				Actual:   uint64(written),
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// recordingByteReader records the bytes read from the underlying io.ByteReader, such that varints
+// can be copied as they are encoded.
+type recordingByteReader struct {
+	io.ByteReader
+	read []byte
+}
+
+func (r *recordingByteReader) ReadByte() (byte, error) {
+	b, err := r.ByteReader.ReadByte()
+	if err == nil {
+		r.read = append(r.read, b)
+	}
+	return b, err
+}
+
 // AttachIndex attaches a given index to an existing CARv2 file at given path and offset.
 func AttachIndex(path string, idx index.Index, offset uint64) error {
 	// TODO: instead of offset, maybe take padding?
@@ -215,11 +695,166 @@ func AttachIndex(path string, idx index.Index, offset uint64) error {
 	return err
 }
 
+// AttachIndexToFile generates an index for the CAR file at the given path and attaches it to the
+// file in place. The index is generated in the codec set via UseIndexCodec, and other options are
+// applied as they are by GenerateIndex. If the file is a CARv2 that already has an index, it is left
+// as is; see TranscodeIndexInFile to convert the codec of an existing index.
+//
+// As by WrapV1, the index is written with a checksum unless disabled via
+// blockstore.WithIndexChecksum.
+//
+// A CARv2 without an index is upgraded by appending the index to the end of file, preceded by the
+// padding set via UseIndexPadding, and only then patching the index offset in its header. Therefore,
+// the file remains a valid CARv2 if the call fails or is interrupted at any point, at worst with
+// the bytes appended so far left over as trailing bytes, which are ignored by readers. The appended
+// bytes are removed if the call fails.
+//
+// A CARv1 is instead rewritten as a CARv2 wrapping it as is, as done by WrapV1 with the same
+// options. Since the data payload must then be moved to make room for the CARv2 pragma and header,
+// the CARv2 is written to a temporary file in the same directory, which is synced to disk and then
+// renamed to path. The file at path is therefore either entirely replaced or left untouched, at
+// the cost of temporarily taking twice the disk space. The permissions of the file are preserved.
+func AttachIndexToFile(path string, opts ...Option) (err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Close file and override return error type if it is nil.
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	r, err := NewReader(f, opts...)
+	if err != nil {
+		return err
+	}
+	if r.Version == 1 {
+		return wrapV1InPlace(f, path, opts...)
+	}
+	if r.Header.HasIndex() {
+		return nil
+	}
+
+	dr, err := r.DataReader()
+	if err != nil {
+		return err
+	}
+	idx, err := GenerateIndex(dr, opts...)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+	if uint64(size) < r.Header.DataOffset+r.Header.DataSize {
+		return fmt.Errorf("data payload is truncated; file size %d is smaller than its end offset %d", size, r.Header.DataOffset+r.Header.DataSize)
+	}
+	defer func() {
+		// Remove the bytes appended so far if anything went wrong.
+		if err != nil {
+			_ = f.Truncate(size)
+		}
+	}()
+
+	// Append the index and sync it to disk before it is pointed at by the header.
+	o := ApplyOptions(opts...)
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err = writeZeros(bw, o.IndexPadding); err != nil {
+		return err
+	}
+	if _, err = writeEmbeddedIndex(bw, idx, o); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	header := r.Header
+	header.IndexOffset = uint64(size) + o.IndexPadding
+	header.Characteristics.SetFullyIndexed(o.StoreIdentityCIDs)
+	if _, err = header.WriteTo(internalio.NewOffsetWriter(f, PragmaSize)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// wrapV1InPlace wraps the CARv1 f at the given path as a CARv2 via WrapV1, written to a temporary
+// file that is then renamed to path.
+func wrapV1InPlace(f *os.File, path string, opts ...Option) (err error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Clean up the temporary file if anything went wrong.
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	if err = WrapV1(f, bw, opts...); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(stat.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PadRootsWithIdentityCID sets whether ReplaceRootsInFile pads the roots it writes with an extra
+// root when they serialize to fewer bytes than the existing roots, such that the data header keeps
+// its size. The extra root is a CIDv1 with raw codec and identity multihash, whose digest consists
+// of zeros and is as long as needed to take up the difference, and is appended after the given
+// roots. Since such a root inlines its own data, it does not refer to any block in the CAR.
+//
+// Note that readers see the extra root as any other, and that a difference of less than 8 bytes
+// cannot be padded, since no identity CID is that short.
+func PadRootsWithIdentityCID(pad bool) Option {
+	return func(o *Options) {
+		o.PadRootsWithIdentityCID = pad
+	}
+}
+
 // ReplaceRootsInFile replaces the root CIDs in CAR file at given path with the given roots.
 // This function accepts both CARv1 and CARv2 files.
 //
-// Note that the roots are only replaced if their total serialized size exactly matches the total
-// serialized size of existing roots in CAR file.
+// The roots are replaced in place, by overwriting the data header, i.e. the CARv1 header. Therefore,
+// the data payload, and the CARv2 header and index if any, are left untouched. Note that the roots
+// are only replaced if their total serialized size exactly matches the total serialized size of
+// existing roots in CAR file, unless padded via PadRootsWithIdentityCID when shorter. An error is
+// returned otherwise, in which case the CAR must be rewritten instead.
 func ReplaceRootsInFile(path string, roots []cid.Cid, opts ...Option) (err error) {
 	f, err := os.OpenFile(path, os.O_RDWR, 0o666)
 	if err != nil {
@@ -302,7 +937,17 @@ func ReplaceRootsInFile(path string, roots []cid.Cid, opts ...Option) (err error
 		return err
 	}
 	// Assert the header sizes are consistent.
-	newSize := int64(buf.Len())
+	replacement := buf.Bytes()
+	newSize := int64(len(replacement))
+	if newSize > currentSize {
+		return fmt.Errorf("replacement header size (%d) exceeds current header size (%d); roots cannot be replaced in place without overwriting the data payload", newSize, currentSize)
+	}
+	if newSize < currentSize && options.PadRootsWithIdentityCID {
+		if replacement, err = padHeaderWithIdentityRoot(roots, currentSize); err != nil {
+			return err
+		}
+		newSize = int64(len(replacement))
+	}
 	if currentSize != newSize {
 		return fmt.Errorf("current header size (%d) must match replacement header size (%d)", currentSize, newSize)
 	}
@@ -310,6 +955,122 @@ func ReplaceRootsInFile(path string, roots []cid.Cid, opts ...Option) (err error
 	if _, err = f.Seek(newHeaderOffset, io.SeekStart); err != nil {
 		return err
 	}
-	_, err = f.Write(buf.Bytes())
+	_, err = f.Write(replacement)
+	return err
+}
+
+// padHeaderWithIdentityRoot serializes the data header with the given roots followed by an identity
+// CID whose digest is as long as needed for the header to serialize to exactly size bytes. An error
+// is returned if there is no such digest length.
+func padHeaderWithIdentityRoot(roots []cid.Cid, size int64) ([]byte, error) {
+	padded := append(roots[:len(roots):len(roots)], cid.Undef)
+	// The serialized size grows by one byte per digest byte, except where a length prefix grows,
+	// in which case some sizes are skipped over.
+	for digestLen := 0; ; digestLen++ {
+		mh, err := multihash.Sum(make([]byte, digestLen), multihash.IDENTITY, -1)
+		if err != nil {
+			return nil, err
+		}
+		padded[len(roots)] = cid.NewCidV1(cid.Raw, mh)
+		var buf bytes.Buffer
+		if err := carv1.WriteHeader(&carv1.CarHeader{Roots: padded, Version: 1}, &buf); err != nil {
+			return nil, err
+		}
+		switch n := int64(buf.Len()); {
+		case n == size:
+			return buf.Bytes(), nil
+		case n > size:
+			return nil, fmt.Errorf("replacement header cannot be padded with an identity CID root to the current header size (%d); the closest padded size is %d", size, n)
+		}
+	}
+}
+
+// TranscodeIndexInFile converts the index embedded in the CARv2 file at the given path to the given
+// codec, without reading the data payload. See index.Transcode for the conversions supported.
+// An error is returned if the file is not a CARv2 or does not have an index.
+//
+// If the converted index fits within the space taken by the existing index, i.e. from the index
+// offset until the end of file, it is written in its place and the file is truncated to its end.
+// If the existing index precedes the data payload, the space it takes extends up to the data
+// payload instead, and the file is not truncated; see Header.IndexSize. Otherwise, it is appended
+// to the end of file, and the index offset in the CARv2 header is patched to point to it; the
+// existing index is left in place as padding, and is only superseded once the header is written.
+// The converted index is checksummed if the existing index is.
+func TranscodeIndexInFile(path string, codec multicodec.Code, opts ...Option) (err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0o666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Close file and override return error type if it is nil.
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	r, err := NewReader(f, opts...)
+	if err != nil {
+		return err
+	}
+	if r.Version == 1 {
+		return fmt.Errorf("cannot transcode index of a CARv1")
+	}
+	if !r.Header.HasIndex() {
+		return fmt.Errorf("cannot transcode index of a CARv2 without an index")
+	}
+
+	indexOffset := int64(r.Header.IndexOffset)
+	ir, err := r.IndexReader()
+	if err != nil {
+		return err
+	}
+	existingCodec, err := index.ReadCodec(ir)
+	if err != nil {
+		return err
+	}
+	ir, err = r.IndexReader()
+	if err != nil {
+		return err
+	}
+	existing, err := index.ReadFrom(ir)
+	if err != nil {
+		return err
+	}
+	transcoded, err := index.Transcode(existing, codec)
+	if err != nil {
+		return err
+	}
+
+	writeIndex := index.WriteTo
+	if existingCodec == index.CarIndexChecksummed {
+		writeIndex = index.WriteToWithChecksum
+	}
+	var buf bytes.Buffer
+	if _, err = writeIndex(transcoded, &buf); err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if int64(buf.Len()) <= r.Header.IndexSize(stat.Size()) {
+		if _, err = f.WriteAt(buf.Bytes(), indexOffset); err != nil {
+			return err
+		}
+		if r.Header.IndexOffset < r.Header.DataOffset {
+			// The index precedes the data payload, which must be kept as is; any remaining bytes
+			// of the existing index are left in place as padding.
+			return nil
+		}
+		return f.Truncate(indexOffset + int64(buf.Len()))
+	}
+
+	if _, err = f.WriteAt(buf.Bytes(), stat.Size()); err != nil {
+		return err
+	}
+	header := r.Header
+	header.IndexOffset = uint64(stat.Size())
+	_, err = header.WriteTo(internalio.NewOffsetWriter(f, PragmaSize))
 	return err
 }
@@ -1,16 +1,23 @@
 package car
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/iotest"
 
 	"github.com/ipld/go-car/v2/index"
 	"github.com/ipld/go-car/v2/internal/carv1"
+	"github.com/ipld/go-car/v2/internal/carv1/util"
+	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
@@ -62,6 +69,156 @@ func TestWrapV1(t *testing.T) {
 	require.Equal(t, wantIdx, gotIdx)
 }
 
+func TestWrapV1WithPaddingAndIndexCodec(t *testing.T) {
+	const (
+		dataPadding  = 1413
+		indexPadding = 4096
+	)
+	src := "testdata/sample-v1.car"
+	opts := []Option{
+		UseDataPadding(dataPadding),
+		UseIndexPadding(indexPadding),
+		UseIndexCodec(multicodec.CarIndexSorted),
+	}
+	dest := filepath.Join(t.TempDir(), "wrapped-padded.car")
+	require.NoError(t, WrapV1File(src, dest, opts...))
+	wantPayload, err := os.ReadFile(src)
+	require.NoError(t, err)
+	wrapped, err := os.ReadFile(dest)
+	require.NoError(t, err)
+
+	// Assert the header offsets account for the padding, which is filled with zeros.
+	subject, err := NewReader(bytes.NewReader(wrapped))
+	require.NoError(t, err)
+	dataOffset := uint64(PragmaSize + HeaderSize + dataPadding)
+	dataEnd := dataOffset + uint64(len(wantPayload))
+	require.Equal(t, Header{
+		DataOffset:  dataOffset,
+		DataSize:    uint64(len(wantPayload)),
+		IndexOffset: dataEnd + indexPadding,
+	}, subject.Header)
+	require.Equal(t, make([]byte, dataPadding), wrapped[PragmaSize+HeaderSize:dataOffset])
+	require.Equal(t, make([]byte, indexPadding), wrapped[dataEnd:dataEnd+indexPadding])
+
+	// Assert the data payload is intact, and the index is written in the given codec.
+	dr, err := subject.DataReader()
+	require.NoError(t, err)
+	gotPayload, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	require.Equal(t, wantPayload, gotPayload)
+	ir, err := subject.IndexReader()
+	require.NoError(t, err)
+	gotIdx, err := index.ReadFrom(ir)
+	require.NoError(t, err)
+	require.Equal(t, multicodec.CarIndexSorted, gotIdx.Codec())
+	stats, err := subject.Inspect(true)
+	require.NoError(t, err)
+	// The index is checksummed by default, as written by a ReadWrite blockstore.
+	require.Equal(t, index.CarIndexChecksummed, stats.IndexCodec)
+
+	// Assert the index is written as is when index checksums are disabled, as done by
+	// blockstore.WithIndexChecksum(false).
+	withoutChecksum := func(o *Options) { o.BlockstoreDisableIndexChecksum = true }
+	require.NoError(t, WrapV1File(src, dest, append(opts, withoutChecksum)...))
+	plain, err := OpenReader(dest)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, plain.Close()) })
+	stats, err = plain.Inspect(true)
+	require.NoError(t, err)
+	require.Equal(t, multicodec.CarIndexSorted, stats.IndexCodec)
+	wrapped, err = os.ReadFile(dest)
+	require.NoError(t, err)
+	wantIdx, err := GenerateIndex(bytes.NewReader(wantPayload), opts...)
+	require.NoError(t, err)
+	var wantIdxBuf bytes.Buffer
+	_, err = index.WriteTo(wantIdx, &wantIdxBuf)
+	require.NoError(t, err)
+	require.Equal(t, wantIdxBuf.Bytes(), wrapped[plain.Header.IndexOffset:])
+
+	// Assert padding larger than allowed is rejected.
+	err = WrapV1File(src, dest, UseDataPadding(2), MaxAllowedPadding(1))
+	require.Equal(t, &ErrPaddingTooLarge{MaxSize: 1, CurrentSize: 2}, err)
+}
+
+func TestRepad(t *testing.T) {
+	src := "testdata/sample-v1.car"
+	tests := []struct {
+		name string
+		from []Option
+		to   []Option
+	}{
+		{"Grow", nil, []Option{UseDataPadding(4096 - PragmaSize - HeaderSize), UseIndexPadding(1413)}},
+		{"Shrink", []Option{UseDataPadding(4096), UseIndexPadding(1413)}, []Option{UseDataPadding(7)}},
+		{"DataChecksum",
+			[]Option{WithDataChecksum(multicodec.Sha2_256), UseIndexPadding(1413)},
+			[]Option{WithDataChecksum(multicodec.Sha2_256), UseDataPadding(101), UseIndexPadding(11)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			from := filepath.Join(dir, "from.car")
+			require.NoError(t, WrapV1File(src, from, tt.from...))
+			to := filepath.Join(dir, "to.car")
+			require.NoError(t, WrapV1File(src, to, tt.to...))
+			want, err := os.ReadFile(to)
+			require.NoError(t, err)
+			fromBytes, err := os.ReadFile(from)
+			require.NoError(t, err)
+
+			// Assert the repadded CARv2 is the same as the one written with the new padding, and that
+			// it is written at the current position of the destination.
+			toOpts := ApplyOptions(tt.to...)
+			dst, err := os.Create(filepath.Join(dir, "repadded.car"))
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, dst.Close()) })
+			_, err = dst.Write([]byte("prefix"))
+			require.NoError(t, err)
+			require.NoError(t, Repad(bytes.NewReader(fromBytes), dst, toOpts.DataPadding, toOpts.IndexPadding))
+			end, err := dst.Seek(0, io.SeekCurrent)
+			require.NoError(t, err)
+			require.Equal(t, int64(len("prefix")+len(want)), end)
+			got, err := os.ReadFile(dst.Name())
+			require.NoError(t, err)
+			require.Equal(t, "prefix", string(got[:len("prefix")]))
+			got = got[len("prefix"):]
+			require.Equal(t, want, got)
+
+			// Assert the offsets in the index resolve to the sections of the moved data payload.
+			subject, err := NewReader(bytes.NewReader(got))
+			require.NoError(t, err)
+			ir, err := subject.IndexReader()
+			require.NoError(t, err)
+			idx, err := index.ReadFrom(ir)
+			require.NoError(t, err)
+			dr, err := subject.DataReader()
+			require.NoError(t, err)
+			br, err := NewBlockReader(dr)
+			require.NoError(t, err)
+			for i := 0; i < 10; i++ {
+				blk, err := br.Next()
+				require.NoError(t, err)
+				offset, err := index.GetFirst(idx, blk.Cid())
+				require.NoError(t, err)
+				sr := io.NewSectionReader(bytes.NewReader(got), int64(subject.Header.DataOffset+offset), int64(subject.Header.DataSize-offset))
+				gotCid, _, err := util.ReadNode(sr, false, false, false, DefaultMaxAllowedSectionSize)
+				require.NoError(t, err)
+				require.Equal(t, blk.Cid(), gotCid)
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		v1, err := os.ReadFile(src)
+		require.NoError(t, err)
+		dst, err := os.Create(filepath.Join(t.TempDir(), "repadded.car"))
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, dst.Close()) })
+		require.EqualError(t, Repad(bytes.NewReader(v1), dst, 0, 0), "cannot repad a CARv1")
+		err = Repad(bytes.NewReader(v1), dst, 2, 0, MaxAllowedPadding(1))
+		require.Equal(t, &ErrPaddingTooLarge{MaxSize: 1, CurrentSize: 2}, err)
+	})
+}
+
 func TestExtractV1(t *testing.T) {
 	// Produce a CARv1 file to test.
 	dagSvc := dstest.Mock()
@@ -93,6 +250,59 @@ func TestExtractV1(t *testing.T) {
 	require.Equal(t, wantV1, gotFromInPlaceFile)
 }
 
+func TestExtractV1InPlace(t *testing.T) {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	const dataPadding = extractInPlaceBufferSize + 7
+	dir := t.TempDir()
+	v2Path := filepath.Join(dir, "wrapped.car")
+	require.NoError(t, WrapV1File("testdata/sample-v1.car", v2Path, UseDataPadding(dataPadding), UseIndexPadding(3)))
+	v2, err := os.ReadFile(v2Path)
+	require.NoError(t, err)
+	dataOffset := int64(PragmaSize + HeaderSize + dataPadding)
+	wantPlan := ExtractV1Plan{
+		Moves: []ExtractV1Move{
+			{From: dataOffset + PragmaSize + HeaderSize, To: PragmaSize + HeaderSize, Length: int64(len(v1)) - PragmaSize - HeaderSize},
+			{From: dataOffset, To: 0, Length: PragmaSize + HeaderSize},
+		},
+		OriginalSize: int64(len(v2)),
+		Size:         int64(len(v1)),
+	}
+
+	// Assert a dry run leaves the file as is.
+	plan, err := ExtractV1InPlace(v2Path, WithDryRun())
+	require.NoError(t, err)
+	require.Equal(t, wantPlan, plan)
+	got, err := os.ReadFile(v2Path)
+	require.NoError(t, err)
+	require.Equal(t, v2, got)
+
+	plan, err = ExtractV1InPlace(v2Path)
+	require.NoError(t, err)
+	require.Equal(t, wantPlan, plan)
+	got, err = os.ReadFile(v2Path)
+	require.NoError(t, err)
+	require.Equal(t, v1, got)
+	_, err = ExtractV1InPlace(v2Path)
+	require.Equal(t, ErrAlreadyV1, err)
+
+	// Assert a payload shifted by less than a chunk is moved intact.
+	unpaddedPath := filepath.Join(dir, "unpadded.car")
+	require.NoError(t, WrapV1File("testdata/sample-v1.car", unpaddedPath))
+	require.NoError(t, DetachIndexFromFile(unpaddedPath))
+	got, err = os.ReadFile(unpaddedPath)
+	require.NoError(t, err)
+	require.Equal(t, v1, got)
+
+	// Assert an interrupted extraction, which leaves the pragma zeroed, is detected.
+	interrupted := append([]byte{}, v2...)
+	copy(interrupted, make([]byte, PragmaSize))
+	interruptedPath := filepath.Join(dir, "interrupted.car")
+	require.NoError(t, os.WriteFile(interruptedPath, interrupted, 0o666))
+	_, err = ExtractV1InPlace(interruptedPath)
+	require.Equal(t, ErrExtractV1Interrupted, err)
+}
+
 func TestExtractV1WithUnknownVersionIsError(t *testing.T) {
 	dstPath := filepath.Join(t.TempDir(), "extract-dst-file-test-v42.car")
 	err := ExtractV1File("testdata/sample-rootless-v42.car", dstPath)
@@ -135,6 +345,209 @@ func assertAddNodes(t *testing.T, adder format.NodeAdder, nds ...format.Node) {
 	}
 }
 
+func TestExtractV1Stream(t *testing.T) {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	wrapped := filepath.Join(t.TempDir(), "wrapped.car")
+	require.NoError(t, WrapV1File("testdata/sample-v1.car", wrapped, UseDataPadding(1413), UseIndexPadding(7)))
+	v2, err := os.ReadFile(wrapped)
+	require.NoError(t, err)
+	dataOffset := PragmaSize + HeaderSize + 1413
+
+	// Read one byte at a time, such that the source is neither seekable nor read ahead of.
+	extract := func(src []byte, opts ...Option) ([]byte, int64, error) {
+		var dst bytes.Buffer
+		n, err := ExtractV1Stream(iotest.OneByteReader(bytes.NewReader(src)), &dst, opts...)
+		return dst.Bytes(), n, err
+	}
+
+	got, n, err := extract(v2)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(v1)), n)
+	require.Equal(t, v1, got)
+
+	_, _, err = extract(v1)
+	require.Equal(t, ErrAlreadyV1, err)
+
+	t.Run("Truncated", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			length int
+			want   *ErrTruncatedStream
+		}{
+			{"Empty", 0, &ErrTruncatedStream{Part: "pragma", Expected: PragmaSize}},
+			{"Pragma", 5, &ErrTruncatedStream{Part: "pragma", Expected: PragmaSize, Actual: 5}},
+			{"Header", PragmaSize + 10, &ErrTruncatedStream{Part: "header", Expected: HeaderSize, Actual: 10}},
+			{"DataPadding", PragmaSize + HeaderSize + 13, &ErrTruncatedStream{Part: "data padding", Expected: 1413, Actual: 13}},
+			{"DataPayload", dataOffset + 100, &ErrTruncatedStream{Part: "data payload", Expected: uint64(len(v1)), Actual: 100}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, n, err := extract(v2[:tt.length])
+				require.Equal(t, tt.want, err)
+				require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+				if tt.want.Part == "data payload" {
+					require.Equal(t, int64(tt.want.Actual), n)
+					require.Equal(t, v1[:n], got)
+				} else {
+					// Nothing is written until the data payload is reached.
+					require.Zero(t, n)
+				}
+			})
+		}
+	})
+
+	t.Run("ZeroDataSize", func(t *testing.T) {
+		// Zero the data size in the header, which is preceded by the characteristics and data offset.
+		malformed := append([]byte{}, v2...)
+		copy(malformed[PragmaSize+24:PragmaSize+32], make([]byte, 8))
+		_, _, err := extract(malformed)
+		require.EqualError(t, err, "invalid data payload size: 0")
+
+		// Assert the end of the data payload is detected at the index padding.
+		got, n, err := extract(malformed, DetectDataSize(true))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(v1)), n)
+		require.Equal(t, v1, got)
+
+		// Assert the end of the data payload is detected at the end of the stream.
+		got, n, err = extract(malformed[:dataOffset+len(v1)], DetectDataSize(true))
+		require.NoError(t, err)
+		require.Equal(t, int64(len(v1)), n)
+		require.Equal(t, v1, got)
+
+		// Assert a section cut short is reported as truncated.
+		_, n, err = extract(malformed[:dataOffset+100], DetectDataSize(true))
+		var truncated *ErrTruncatedStream
+		require.True(t, errors.As(err, &truncated))
+		require.Equal(t, "data payload", truncated.Part)
+		require.Equal(t, uint64(100), truncated.Actual)
+		require.Equal(t, int64(100), n)
+	})
+}
+
+func TestAttachIndexToFile(t *testing.T) {
+	v1, err := os.ReadFile("testdata/sample-v1.car")
+	require.NoError(t, err)
+	opts := []Option{UseDataPadding(9), UseIndexPadding(5), UseIndexCodec(multicodec.CarIndexSorted)}
+	dir := t.TempDir()
+	wantPath := filepath.Join(dir, "want.car")
+	require.NoError(t, WrapV1File("testdata/sample-v1.car", wantPath, opts...))
+	want, err := os.ReadFile(wantPath)
+	require.NoError(t, err)
+
+	t.Run("CarV1", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sample.car")
+		require.NoError(t, os.WriteFile(path, v1, 0o640))
+		require.NoError(t, AttachIndexToFile(path, opts...))
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o640), stat.Mode().Perm())
+		// Assert the temporary file is gone.
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+
+	t.Run("CarV2WithoutIndex", func(t *testing.T) {
+		// Drop the index, and zero the index offset in the header.
+		subject, err := NewReader(bytes.NewReader(want))
+		require.NoError(t, err)
+		unindexed := append([]byte{}, want[:subject.Header.DataOffset+subject.Header.DataSize]...)
+		copy(unindexed[PragmaSize+32:PragmaSize+HeaderSize], make([]byte, 8))
+		path := filepath.Join(t.TempDir(), "sample.car")
+		require.NoError(t, os.WriteFile(path, unindexed, 0o666))
+
+		require.NoError(t, AttachIndexToFile(path, opts...))
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+
+		// Assert the index offsets resolve to the sections of the data payload.
+		r, err := NewReader(bytes.NewReader(got))
+		require.NoError(t, err)
+		ir, err := r.IndexReader()
+		require.NoError(t, err)
+		idx, err := index.ReadFrom(ir)
+		require.NoError(t, err)
+		dr, err := r.DataReader()
+		require.NoError(t, err)
+		br, err := NewBlockReader(dr)
+		require.NoError(t, err)
+		blk, err := br.Next()
+		require.NoError(t, err)
+		offset, err := index.GetFirst(idx, blk.Cid())
+		require.NoError(t, err)
+		sr := io.NewSectionReader(bytes.NewReader(got), int64(r.Header.DataOffset+offset), int64(r.Header.DataSize-offset))
+		gotCid, _, err := util.ReadNode(sr, false, false, false, DefaultMaxAllowedSectionSize)
+		require.NoError(t, err)
+		require.Equal(t, blk.Cid(), gotCid)
+
+		// Assert a CARv2 that already has an index is left as is.
+		require.NoError(t, AttachIndexToFile(path, UseIndexCodec(multicodec.CarMultihashIndexSorted)))
+		again, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, want, again)
+	})
+
+	t.Run("IndexChecksum", func(t *testing.T) {
+		subject, err := NewReader(bytes.NewReader(want))
+		require.NoError(t, err)
+		unindexed := append([]byte{}, want[:subject.Header.DataOffset+subject.Header.DataSize]...)
+		copy(unindexed[PragmaSize+32:PragmaSize+HeaderSize], make([]byte, 8))
+
+		// Assert the index is checksummed by default, as written by a ReadWrite blockstore, unless
+		// disabled as done by blockstore.WithIndexChecksum(false).
+		withoutChecksum := func(o *Options) { o.BlockstoreDisableIndexChecksum = true }
+		for _, tc := range []struct {
+			opts      []Option
+			wantCodec multicodec.Code
+		}{
+			{opts, index.CarIndexChecksummed},
+			{append(opts[:len(opts):len(opts)], withoutChecksum), multicodec.CarIndexSorted},
+		} {
+			for _, original := range [][]byte{v1, unindexed} {
+				path := filepath.Join(t.TempDir(), "sample.car")
+				require.NoError(t, os.WriteFile(path, original, 0o666))
+				require.NoError(t, AttachIndexToFile(path, tc.opts...))
+				r, err := OpenReader(path)
+				require.NoError(t, err)
+				stats, err := r.Inspect(true)
+				require.NoError(t, err)
+				require.Equal(t, tc.wantCodec, stats.IndexCodec)
+				require.NoError(t, r.Close())
+			}
+		}
+	})
+
+	t.Run("FailureLeavesFileIntact", func(t *testing.T) {
+		subject, err := NewReader(bytes.NewReader(want))
+		require.NoError(t, err)
+		unindexed := append([]byte{}, want[:subject.Header.DataOffset+subject.Header.DataSize]...)
+		copy(unindexed[PragmaSize+32:PragmaSize+HeaderSize], make([]byte, 8))
+		path := filepath.Join(t.TempDir(), "sample.car")
+		require.NoError(t, os.WriteFile(path, unindexed, 0o666))
+
+		require.Error(t, AttachIndexToFile(path, UseIndexCodec(multicodec.Code(0x300fff))))
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, unindexed, got)
+
+		v1Path := filepath.Join(filepath.Dir(path), "sample-v1.car")
+		require.NoError(t, os.WriteFile(v1Path, v1, 0o666))
+		require.Error(t, AttachIndexToFile(v1Path, UseIndexCodec(multicodec.Code(0x300fff))))
+		got, err = os.ReadFile(v1Path)
+		require.NoError(t, err)
+		require.Equal(t, v1, got)
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+	})
+}
+
 func TestReplaceRootsInFile(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -258,6 +671,84 @@ func TestReplaceRootsInFile(t *testing.T) {
 	}
 }
 
+func TestReplaceRootsInFileWithPadding(t *testing.T) {
+	root := requireDecodedCid(t, "bafy2bzaced4ueelaegfs5fqu4tzsh6ywbbpfk3cxppupmxfdhbpbhzawfw5oi")
+	shortRoot, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: uint64(multicodec.Identity), MhLength: -1}.Sum([]byte("fish"))
+	require.NoError(t, err)
+	tests := []struct {
+		name       string
+		path       string
+		headerAt   int
+		roots      []cid.Cid
+		wantErrMsg string
+	}{
+		{
+			name: "CARv1EmptyRootsArePadded",
+			path: "testdata/sample-v1.car",
+		},
+		{
+			name:     "CARv2EmptyRootsArePadded",
+			path:     "testdata/sample-wrapped-v2.car",
+			headerAt: PragmaSize + HeaderSize,
+		},
+		{
+			name:     "CARv2IndexlessShorterRootsArePadded",
+			path:     "testdata/sample-v2-indexless.car",
+			headerAt: PragmaSize + HeaderSize,
+			roots:    []cid.Cid{shortRoot},
+		},
+		{
+			name:       "CARv1RootsTooCloseInSizeAreNotPadded",
+			path:       "testdata/sample-v1.car",
+			roots:      []cid.Cid{requireDecodedCid(t, "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")},
+			wantErrMsg: "cannot be padded with an identity CID root to the current header size (61)",
+		},
+		{
+			name:       "CARv1LargerRootsAreNotReplaced",
+			path:       "testdata/sample-v1.car",
+			roots:      []cid.Cid{root, root},
+			wantErrMsg: "exceeds current header size (61)",
+		},
+		{
+			name:       "CARv2LargerRootsAreNotReplaced",
+			path:       "testdata/sample-wrapped-v2.car",
+			roots:      []cid.Cid{root, root},
+			wantErrMsg: "exceeds current header size (61)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpCopy := requireTmpCopy(t, tt.path)
+			err := ReplaceRootsInFile(tmpCopy, tt.roots, PadRootsWithIdentityCID(true))
+			if tt.wantErrMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErrMsg)
+				return
+			}
+			require.NoError(t, err)
+
+			// Assert nothing but the data header has changed, such that the CARv2 header and index
+			// remain consistent.
+			want, err := os.ReadFile(tt.path)
+			require.NoError(t, err)
+			got, err := os.ReadFile(tmpCopy)
+			require.NoError(t, err)
+			require.Equal(t, len(want), len(got))
+			const headerSize = 61
+			require.Equal(t, want[:tt.headerAt], got[:tt.headerAt])
+			require.Equal(t, want[tt.headerAt+headerSize:], got[tt.headerAt+headerSize:])
+
+			// Assert the roots are replaced, followed by a single identity CID.
+			reader, err := NewBlockReader(bytes.NewReader(got))
+			require.NoError(t, err)
+			require.Len(t, reader.Roots, len(tt.roots)+1)
+			require.ElementsMatch(t, tt.roots, reader.Roots[:len(tt.roots)])
+			padding := reader.Roots[len(tt.roots)]
+			require.Equal(t, uint64(multicodec.Identity), padding.Prefix().MhType)
+		})
+	}
+}
+
 func requireDecodedCid(t *testing.T, s string) cid.Cid {
 	decoded, err := cid.Decode(s)
 	require.NoError(t, err)
@@ -280,3 +771,198 @@ func requireTmpCopy(t *testing.T, src string) string {
 	require.NoError(t, err)
 	return dst
 }
+
+func TestTranscodeIndexInFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcode-index-v2.car")
+	require.NoError(t, WrapV1File("testdata/sample-v1.car", path))
+
+	requireIndex := func(t *testing.T, codec multicodec.Code) Header {
+		r, err := OpenReader(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, r.Close()) })
+		ir, err := r.IndexReader()
+		require.NoError(t, err)
+		got, err := index.ReadFrom(ir)
+		require.NoError(t, err)
+		require.Equal(t, codec, got.Codec())
+
+		// Assert the index is identical to one generated from the data payload.
+		dr, err := r.DataReader()
+		require.NoError(t, err)
+		want, err := GenerateIndex(dr, UseIndexCodec(codec))
+		require.NoError(t, err)
+		var wantBuf, gotBuf bytes.Buffer
+		_, err = index.WriteTo(want, &wantBuf)
+		require.NoError(t, err)
+		_, err = index.WriteTo(got, &gotBuf)
+		require.NoError(t, err)
+		require.Equal(t, wantBuf.Bytes(), gotBuf.Bytes())
+
+		// Assert the file ends right after the index, which is checksummed as written by WrapV1File
+		// by default.
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		n, err := index.WriteToWithChecksum(got, io.Discard)
+		require.NoError(t, err)
+		require.Equal(t, int64(r.Header.IndexOffset)+n, stat.Size())
+		return r.Header
+	}
+	original := requireIndex(t, multicodec.CarMultihashIndexSorted)
+
+	// Assert a larger index is appended to the file, leaving the existing one in place.
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, TranscodeIndexInFile(path, index.CarMultihashIndexHashed))
+	hashed := requireIndex(t, index.CarMultihashIndexHashed)
+	require.Equal(t, uint64(stat.Size()), hashed.IndexOffset)
+	require.Equal(t, original.DataOffset, hashed.DataOffset)
+	require.Equal(t, original.DataSize, hashed.DataSize)
+
+	// Assert a smaller index is written in place of the existing one.
+	require.NoError(t, TranscodeIndexInFile(path, multicodec.CarMultihashIndexSorted))
+	require.Equal(t, hashed, requireIndex(t, multicodec.CarMultihashIndexSorted))
+
+	// Assert that information missing from the existing index cannot be transcoded.
+	err = TranscodeIndexInFile(path, index.CarMultihashSizedIndexSorted)
+	var unsupported *index.ErrTranscodeUnsupported
+	require.True(t, errors.As(err, &unsupported), "expected ErrTranscodeUnsupported but got: %v", err)
+
+	err = TranscodeIndexInFile(requireTmpCopy(t, "testdata/sample-v1.car"), index.CarMultihashIndexHashed)
+	require.EqualError(t, err, "cannot transcode index of a CARv1")
+	err = TranscodeIndexInFile(requireTmpCopy(t, "testdata/sample-v2-indexless.car"), index.CarMultihashIndexHashed)
+	require.EqualError(t, err, "cannot transcode index of a CARv2 without an index")
+}
+
+func TestTranscodeIndexInFileWithIndexBeforeData(t *testing.T) {
+	path := requireTmpCopy(t, "testdata/sample-v2-index-before-data.car")
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	originalSize := stat.Size()
+
+	requireIndex := func(t *testing.T, codec multicodec.Code) Header {
+		r, err := OpenReader(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, r.Close()) })
+		ir, err := r.IndexReader()
+		require.NoError(t, err)
+		got, err := index.ReadFrom(ir)
+		require.NoError(t, err)
+		require.Equal(t, codec, got.Codec())
+
+		// Assert the data payload is left intact.
+		dr, err := r.DataReader()
+		require.NoError(t, err)
+		want, err := GenerateIndex(dr, UseIndexCodec(codec))
+		require.NoError(t, err)
+		var wantBuf, gotBuf bytes.Buffer
+		_, err = index.WriteTo(want, &wantBuf)
+		require.NoError(t, err)
+		_, err = index.WriteTo(got, &gotBuf)
+		require.NoError(t, err)
+		require.Equal(t, wantBuf.Bytes(), gotBuf.Bytes())
+		_, err = r.Inspect(true)
+		require.NoError(t, err)
+		return r.Header
+	}
+	original := requireIndex(t, multicodec.CarMultihashIndexSorted)
+
+	// Assert a smaller index is written in place of the existing one, without truncating the file.
+	require.NoError(t, TranscodeIndexInFile(path, multicodec.CarIndexSorted))
+	require.Equal(t, original, requireIndex(t, multicodec.CarIndexSorted))
+	stat, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, originalSize, stat.Size())
+
+	// Assert a larger index is appended to the file, after the data payload. A fresh copy is
+	// transcoded, since CarIndexSorted is not iterable and cannot be transcoded from.
+	path = requireTmpCopy(t, "testdata/sample-v2-index-before-data.car")
+	require.NoError(t, TranscodeIndexInFile(path, index.CarMultihashIndexHashed))
+	hashed := requireIndex(t, index.CarMultihashIndexHashed)
+	require.Equal(t, uint64(originalSize), hashed.IndexOffset)
+	require.Equal(t, original.DataOffset, hashed.DataOffset)
+	require.Equal(t, original.DataSize, hashed.DataSize)
+}
+
+func TestIndexSerializationIsDeterministic(t *testing.T) {
+	rng := rand.New(rand.NewSource(1413))
+
+	// Write a payload that includes a duplicate block, and a block whose multihash is shared by a
+	// CID with a different codec.
+	var blks []blocks.Block
+	for i := 0; i < 100; i++ {
+		data := make([]byte, 1+rng.Intn(100))
+		rng.Read(data)
+		blks = append(blks, blocks.NewBlock(data))
+	}
+	blks = append(blks, blks[3])
+	sameMultihash, err := blocks.NewBlockWithCid(blks[7].RawData(), cid.NewCidV1(cid.DagCBOR, blks[7].Cid().Hash()))
+	require.NoError(t, err)
+	blks = append(blks, sameMultihash)
+
+	var payload bytes.Buffer
+	require.NoError(t, carv1.WriteHeader(&carv1.CarHeader{Roots: []cid.Cid{blks[0].Cid()}, Version: 1}, &payload))
+	var records []index.Record
+	for _, blk := range blks {
+		records = append(records, index.Record{
+			Cid:    blk.Cid(),
+			Offset: uint64(payload.Len()),
+			Size:   uint64(blk.Cid().ByteLen() + len(blk.RawData())),
+		})
+		require.NoError(t, util.LdWrite(&payload, blk.Cid().Bytes(), blk.RawData()))
+	}
+
+	// buildCar assembles a CARv2 with the payload and the index written by writeIndex.
+	buildCar := func(writeIndex func(w io.Writer) error) []byte {
+		var buf bytes.Buffer
+		_, err := buf.Write(Pragma)
+		require.NoError(t, err)
+		_, err = NewHeader(uint64(payload.Len())).WriteTo(&buf)
+		require.NoError(t, err)
+		_, err = buf.Write(payload.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, writeIndex(&buf))
+		return buf.Bytes()
+	}
+
+	codecs := []multicodec.Code{
+		multicodec.CarIndexSorted,
+		multicodec.CarMultihashIndexSorted,
+		index.CarCidIndexSorted,
+		index.CarMultihashSizedIndexSorted,
+		index.CarMultihashIndexHashed,
+	}
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			var wantLoaded, wantFlattened []byte
+			for i := 0; i < 10; i++ {
+				shuffled := append([]index.Record{}, records...)
+				rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+				loaded := buildCar(func(w io.Writer) error {
+					idx, err := index.NewFromRecords(codec, shuffled)
+					if err != nil {
+						return err
+					}
+					_, err = index.WriteTo(idx, w)
+					return err
+				})
+				// Assert the index written by a blockstore upon finalization, i.e. flattened from an
+				// insertion index, is also deterministic.
+				flattened := buildCar(func(w io.Writer) error {
+					ii := index.NewInsertionIndex()
+					if err := ii.Load(shuffled); err != nil {
+						return err
+					}
+					_, err := ii.WriteFlattenedToWithChecksum(w, codec)
+					return err
+				})
+				if i == 0 {
+					wantLoaded, wantFlattened = loaded, flattened
+					continue
+				}
+				require.Equal(t, wantLoaded, loaded)
+				require.Equal(t, wantFlattened, flattened)
+			}
+		})
+	}
+}